@@ -16,6 +16,7 @@ import (
 	"testing"
 	"time"
 
+	sgbucket "github.com/couchbase/sg-bucket"
 	"github.com/couchbase/sync_gateway/base"
 	"github.com/stretchr/testify/assert"
 )
@@ -24,65 +25,67 @@ func TestCreateSession(t *testing.T) {
 	var username string = "Alice"
 	const invalidSessionTTLError = "400 Invalid session time-to-live"
 	defer base.SetUpTestLogging(base.LevelDebug, base.KeyAuth)()
-	testBucket := base.GetTestBucket(t)
-	defer testBucket.Close()
-
-	auth := NewAuthenticator(testBucket, nil, DefaultAuthenticatorOptions())
-
-	// Create session with a username and valid TTL of 2 hours.
-	session, err := auth.CreateSession(username, 2*time.Hour)
-	assert.NoError(t, err)
-
-	assert.Equal(t, username, session.Username)
-	assert.Equal(t, 2*time.Hour, session.Ttl)
-	assert.NotEmpty(t, session.ID)
-	assert.NotEmpty(t, session.Expiration)
-
-	// Once the session is created, the details should be persisted on the bucket
-	// and it must be accessible anytime later within the session expiration time.
-	session, err = auth.GetSession(session.ID)
-	assert.NoError(t, err)
-
-	assert.Equal(t, username, session.Username)
-	assert.Equal(t, 2*time.Hour, session.Ttl)
-	assert.NotEmpty(t, session.ID)
-	assert.NotEmpty(t, session.Expiration)
-
-	// Session must not be created with zero TTL; it's illegal.
-	session, err = auth.CreateSession(username, time.Duration(0))
-	assert.Nil(t, session)
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), invalidSessionTTLError)
-
-	// Session must not be created with negative TTL; it's illegal.
-	session, err = auth.CreateSession(username, time.Duration(-1))
-	assert.Nil(t, session)
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), invalidSessionTTLError)
+	base.ForAllDataStores(t, func(t *testing.T, testBucket sgbucket.DataStore) {
+		auth := NewAuthenticator(testBucket, nil, DefaultAuthenticatorOptions())
+
+		// Create session with a username and valid TTL of 2 hours.
+		session, err := auth.CreateSession(username, 2*time.Hour)
+		assert.NoError(t, err)
+
+		assert.Equal(t, username, session.Username)
+		assert.Equal(t, 2*time.Hour, session.Ttl)
+		assert.NotEmpty(t, session.ID)
+		assert.NotEmpty(t, session.Expiration)
+
+		// Once the session is created, the details should be persisted on the bucket
+		// and it must be accessible anytime later within the session expiration time.
+		session, err = auth.GetSession(session.ID)
+		assert.NoError(t, err)
+
+		assert.Equal(t, username, session.Username)
+		assert.Equal(t, 2*time.Hour, session.Ttl)
+		assert.NotEmpty(t, session.ID)
+		assert.NotEmpty(t, session.Expiration)
+
+		// Session must not be created with zero TTL; it's illegal.
+		session, err = auth.CreateSession(username, time.Duration(0))
+		assert.Nil(t, session)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), invalidSessionTTLError)
+
+		// Session must not be created with negative TTL; it's illegal.
+		session, err = auth.CreateSession(username, time.Duration(-1))
+		assert.Nil(t, session)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), invalidSessionTTLError)
+	})
 }
 
 func TestDeleteSession(t *testing.T) {
 	defer base.SetUpTestLogging(base.LevelDebug, base.KeyAuth)()
 	var username string = "Alice"
-	testBucket := base.GetTestBucket(t)
-	defer testBucket.Close()
-
-	auth := NewAuthenticator(testBucket, nil, DefaultAuthenticatorOptions())
-
-	mockSession := &LoginSession{
-		ID:         base.GenerateRandomSecret(),
-		Username:   username,
-		Expiration: time.Now().Add(2 * time.Hour),
-		Ttl:        24 * time.Hour,
-	}
-	const noSessionExpiry = 0
-	assert.NoError(t, testBucket.Set(DocIDForSession(mockSession.ID), noSessionExpiry, mockSession))
-	assert.NoError(t, auth.DeleteSession(mockSession.ID))
-
-	// Just to verify the session has been deleted gracefully.
-	session, err := auth.GetSession(mockSession.ID)
-	assert.Nil(t, session)
-	assert.NoError(t, err)
+	base.ForAllDataStores(t, func(t *testing.T, testBucket sgbucket.DataStore) {
+		auth := NewAuthenticator(testBucket, nil, DefaultAuthenticatorOptions())
+
+		mockSession := &LoginSession{
+			ID:         base.GenerateRandomSecret(),
+			Username:   username,
+			Expiration: time.Now().Add(2 * time.Hour),
+			Ttl:        24 * time.Hour,
+		}
+		const noSessionExpiry = 0
+		assert.NoError(t, testBucket.Set(DocIDForSession(mockSession.ID), noSessionExpiry, mockSession))
+		assert.NoError(t, auth.DeleteSession(mockSession.ID))
+
+		// Just to verify the session has been deleted gracefully.
+		session, err := auth.GetSession(mockSession.ID)
+		assert.Nil(t, session)
+		assert.NoError(t, err)
+
+		// Deleting a session that doesn't exist is a not found error
+		err = auth.DeleteSession(mockSession.ID)
+		assert.True(t, base.IsDocNotFoundError(err))
+	})
 }
 
 // Coverage for MakeSessionCookie. The MakeSessionCookie should create a cookie
@@ -422,6 +422,8 @@ func GetFeedType(bucket Bucket) (feedType string) {
 		}
 	case *LeakyBucket:
 		return GetFeedType(typedBucket.bucket)
+	case *NamespaceBucket:
+		return GetFeedType(typedBucket.bucket)
 	case *LoggingBucket:
 		return GetFeedType(typedBucket.bucket)
 	default:
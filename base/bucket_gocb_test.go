@@ -10,7 +10,9 @@ package base
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -499,6 +501,59 @@ func TestXattrWriteCasSimple(t *testing.T) {
 
 }
 
+func TestEscapeSubdocPathElement(t *testing.T) {
+	assert.Equal(t, "_sync", escapeSubdocPathElement("_sync"))
+	assert.Equal(t, "ключ", escapeSubdocPathElement("ключ"))
+	assert.Equal(t, "`a.b`", escapeSubdocPathElement("a.b"))
+	assert.Equal(t, "`a[0]`", escapeSubdocPathElement("a[0]"))
+	assert.Equal(t, "`a``b`", escapeSubdocPathElement("a`b"))
+	assert.Equal(t, "`a.b`.cas", xattrCasPath("a.b"))
+	assert.Equal(t, "`a``b`.value_crc32c", xattrCrc32cPath("a`b"))
+}
+
+func TestValidateXattrKey(t *testing.T) {
+	for _, key := range []string{"_sync", "user.key", "user`key", "ключ"} {
+		assert.NoError(t, ValidateXattrKey(key), "Expected %q to be valid", key)
+	}
+	for _, key := range []string{"", "$document", "seventeen_bytes_x", "bad key", "bad\nkey", "\xff"} {
+		err := ValidateXattrKey(key)
+		var invalidKeyErr *InvalidXattrKeyError
+		require.True(t, errors.As(err, &invalidKeyErr), "Expected %q to be invalid", key)
+		assert.Equal(t, key, invalidKeyErr.Key)
+	}
+}
+
+// TestXattrWriteCasEscapedKey validates round trips of xattrs with keys containing characters that must be escaped in
+// subdoc paths.
+func TestXattrWriteCasEscapedKey(t *testing.T) {
+
+	SkipXattrTestsIfNotEnabled(t)
+
+	ForAllDataStores(t, func(t *testing.T, bucket sgbucket.DataStore) {
+		for i, xattrName := range []string{"user.key", "user`key", "ключ"} {
+			key := fmt.Sprintf("%s_%d", t.Name(), i)
+			val := map[string]interface{}{"body_field": "1234"}
+			xattrVal := map[string]interface{}{"rev": "1-1234"}
+
+			cas, err := bucket.WriteCasWithXattr(key, xattrName, 0, 0, val, xattrVal)
+			require.NoError(t, err, "WriteCasWithXattr error for xattr key %q", xattrName)
+
+			var retrievedVal map[string]interface{}
+			var retrievedXattr map[string]interface{}
+			getCas, err := bucket.GetWithXattr(key, xattrName, "", &retrievedVal, &retrievedXattr, nil)
+			require.NoError(t, err, "GetWithXattr error for xattr key %q", xattrName)
+			assert.Equal(t, cas, getCas)
+			assert.Equal(t, "1234", retrievedVal["body_field"])
+			assert.Equal(t, "1-1234", retrievedXattr["rev"])
+			macroCasString, ok := retrievedXattr[xattrMacroCas].(string)
+			require.True(t, ok, "Unable to retrieve xattrMacroCas for xattr key %q", xattrName)
+			assert.Equal(t, cas, HexCasToUint64(macroCasString))
+
+			require.NoError(t, bucket.DeleteWithXattr(key, xattrName))
+		}
+	})
+}
+
 // TestXattrWriteCasUpsert.  Validates basic write of document with xattr,  retrieval of the same doc w/ xattr, update of the doc w/ xattr, retrieval of the doc w/ xattr.
 func TestXattrWriteCasUpsert(t *testing.T) {
 
@@ -1172,7 +1227,7 @@ func TestXattrDeleteDocumentAndUpdateXattr(t *testing.T) {
 		subdocXattrStore, ok := AsSubdocXattrStore(bucket)
 		require.True(t, ok)
 
-		_, mutateErr := subdocXattrStore.SubdocUpdateXattrDeleteBody(key, xattrName, 0, cas, xattrVal)
+		_, mutateErr := subdocXattrStore.SubdocUpdateXattrDeleteBody(context.TODO(), key, xattrName, 0, cas, xattrVal)
 		assert.NoError(t, mutateErr)
 
 		// Verify delete of body and update of XATTR
@@ -1264,10 +1319,10 @@ func TestXattrTombstoneDocAndUpdateXattr(t *testing.T) {
 
 			log.Printf("Delete testing for key: %v", key)
 			// First attempt to update with a bad cas value, and ensure we're getting the expected error
-			_, errCasMismatch := UpdateTombstoneXattr(subdocStore, key, xattrName, 0, uint64(1234), &updatedXattrVal, shouldDeleteBody[i])
+			_, errCasMismatch := UpdateTombstoneXattr(context.TODO(), subdocStore, key, xattrName, 0, uint64(1234), &updatedXattrVal, shouldDeleteBody[i])
 			assert.True(t, IsCasMismatch(errCasMismatch), fmt.Sprintf("Expected cas mismatch for %s", key))
 
-			_, errDelete := UpdateTombstoneXattr(subdocStore, key, xattrName, 0, uint64(casValues[i]), &updatedXattrVal, shouldDeleteBody[i])
+			_, errDelete := UpdateTombstoneXattr(context.TODO(), subdocStore, key, xattrName, 0, uint64(casValues[i]), &updatedXattrVal, shouldDeleteBody[i])
 			log.Printf("Delete error: %v", errDelete)
 
 			assert.NoError(t, errDelete, fmt.Sprintf("Unexpected error deleting %s", key))
@@ -1276,7 +1331,7 @@ func TestXattrTombstoneDocAndUpdateXattr(t *testing.T) {
 
 		// Now attempt to tombstone key4 (NoDocNoXattr), should not return an error (per SG #3307).  Should save xattr metadata.
 		log.Printf("Deleting key: %v", key4)
-		_, errDelete := UpdateTombstoneXattr(subdocStore, key4, xattrName, 0, uint64(0), &updatedXattrVal, false)
+		_, errDelete := UpdateTombstoneXattr(context.TODO(), subdocStore, key4, xattrName, 0, uint64(0), &updatedXattrVal, false)
 		assert.NoError(t, errDelete, "Unexpected error tombstoning non-existent doc")
 		assert.True(t, verifyDocDeletedXattrExists(bucket, key4, xattrName), "Expected doc to be deleted, but xattrs to exist")
 	})
@@ -1405,7 +1460,7 @@ func TestDeleteWithXattrWithSimulatedRaceResurrect(t *testing.T) {
 		require.True(t, ok)
 		kvXattrStore, ok := subdocStore.(KvXattrStore)
 		require.True(t, ok)
-		deleteErr := deleteWithXattrInternal(kvXattrStore, key, xattrName, callback)
+		deleteErr := deleteWithXattrInternal(context.TODO(), kvXattrStore, key, xattrName, callback)
 
 		assert.True(t, deleteErr != nil, "We expected an error here, because deleteWithXattrInternal should have "+
 			" detected that the doc was resurrected during its execution")
@@ -2037,7 +2092,7 @@ func createTombstonedDoc(bucket sgbucket.DataStore, key, xattrName string) {
 
 	subdocStore, _ := AsSubdocXattrStore(bucket)
 	// Create tombstone revision which deletes doc body but preserves XATTR
-	_, mutateErr := subdocStore.SubdocDeleteBody(key, xattrName, 0, cas)
+	_, mutateErr := subdocStore.SubdocDeleteBody(context.TODO(), key, xattrName, 0, cas)
 	/*
 		flags := gocb.SubdocDocFlagAccessDeleted
 		_, mutateErr := bucket.Bucket.MutateInEx(key, flags, gocb.Cas(cas), uint32(0)).
@@ -2115,7 +2170,7 @@ func TestUpdateXattrWithDeleteBodyAndIsDelete(t *testing.T) {
 		updatedXattrVal["rev"] = "2-EmDC"
 
 		// Attempt to delete the document body (deleteBody = true); isDelete is true to mark this doc as a tombstone.
-		_, errDelete := UpdateTombstoneXattr(subdocXattrStore, key, xattrKey, 0, cas, &updatedXattrVal, true)
+		_, errDelete := UpdateTombstoneXattr(context.TODO(), subdocXattrStore, key, xattrKey, 0, cas, &updatedXattrVal, true)
 		assert.NoError(t, errDelete, fmt.Sprintf("Unexpected error deleting %s", key))
 		assert.True(t, verifyDocDeletedXattrExists(bucket, key, xattrKey), fmt.Sprintf("Expected doc %s to be deleted", key))
 
@@ -2213,7 +2268,7 @@ func TestInsertTombstoneWithXattr(t *testing.T) {
 
 		cas := uint64(0)
 		// Attempt to delete the document body (deleteBody = true); isDelete is true to mark this doc as a tombstone.
-		_, errDelete := UpdateTombstoneXattr(subdocXattrStore, key, xattrKey, 0, cas, &xattrVal, false)
+		_, errDelete := UpdateTombstoneXattr(context.TODO(), subdocXattrStore, key, xattrKey, 0, cas, &xattrVal, false)
 		assert.NoError(t, errDelete, fmt.Sprintf("Unexpected error deleting %s", key))
 		assert.True(t, verifyDocDeletedXattrExists(bucket, key, xattrKey), fmt.Sprintf("Expected doc %s to be deleted", key))
 
@@ -26,6 +26,7 @@ const IndexStateOnline = "online"       // bucket state value, as returned by SE
 const IndexStateDeferred = "deferred"   // bucket state value, as returned by SELECT FROM system:indexes.  Index has been created but not built.
 const IndexStatePending = "pending"     // bucket state value, as returned by SELECT FROM system:indexes.  Index has been created, build is in progress
 const PrimaryIndexName = "#primary"
+const n1qlClientContextIDParam = "client_context_id" // Query request parameter identifying the query in the query service's logs
 
 // IndexOptions used to build the 'with' clause
 type N1qlIndexOptions struct {
@@ -54,10 +55,18 @@ func (bucket *CouchbaseBucketGoCB) Keyspace() string {
 //
 // Query retries on Indexer Errors, as these are normally transient
 func (bucket *CouchbaseBucketGoCB) Query(statement string, params map[string]interface{}, consistency ConsistencyMode, adhoc bool) (results sgbucket.QueryResultIterator, err error) {
+	return bucket.QueryWithClientContextID(statement, params, consistency, adhoc, "")
+}
+
+// QueryWithClientContextID performs Query, identifying the query to the query service by clientContextID when set.
+func (bucket *CouchbaseBucketGoCB) QueryWithClientContextID(statement string, params map[string]interface{}, consistency ConsistencyMode, adhoc bool, clientContextID string) (results sgbucket.QueryResultIterator, err error) {
 	bucketStatement := strings.Replace(statement, KeyspaceQueryToken, bucket.GetName(), -1)
 	n1qlQuery := gocb.NewN1qlQuery(bucketStatement)
 	n1qlQuery = n1qlQuery.AdHoc(adhoc)
 	n1qlQuery = n1qlQuery.Consistency(gocb.ConsistencyMode(consistency))
+	if clientContextID != "" {
+		n1qlQuery = n1qlQuery.Custom(n1qlClientContextIDParam, clientContextID)
+	}
 
 	waitTime := 10 * time.Millisecond
 	for i := 1; i <= MaxQueryRetries; i++ {
@@ -1,6 +1,7 @@
 package base
 
 import (
+	"context"
 	"errors"
 
 	sgbucket "github.com/couchbase/sg-bucket"
@@ -9,52 +10,58 @@ import (
 	"gopkg.in/couchbase/gocbcore.v7"
 )
 
+// gocb v1 operations don't accept a context, so the SubdocXattrStore implementation only checks the context between
+// retries.
 var _ SubdocXattrStore = &CouchbaseBucketGoCB{}
 
 func (bucket *CouchbaseBucketGoCB) WriteCasWithXattr(k string, xattrKey string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
-	return WriteCasWithXattr(bucket, k, xattrKey, exp, cas, v, xv)
+	return WriteCasWithXattr(context.Background(), bucket, k, xattrKey, exp, cas, v, xv)
 }
 
 func (bucket *CouchbaseBucketGoCB) WriteWithXattr(k string, xattrKey string, exp uint32, cas uint64, v []byte, xv []byte, isDelete bool, deleteBody bool) (casOut uint64, err error) { // If this is a tombstone, we want to delete the document and update the xattr
-	return WriteWithXattr(bucket, k, xattrKey, exp, cas, v, xv, isDelete, deleteBody)
+	return WriteWithXattr(context.Background(), bucket, k, xattrKey, exp, cas, v, xv, isDelete, deleteBody)
 }
 
 func (bucket *CouchbaseBucketGoCB) DeleteWithXattr(k string, xattrKey string) error {
-	return DeleteWithXattr(bucket, k, xattrKey)
+	return DeleteWithXattr(context.Background(), bucket, k, xattrKey)
 }
 
 func (bucket *CouchbaseBucketGoCB) GetXattr(k string, xattrKey string, xv interface{}) (casOut uint64, err error) {
-	return bucket.SubdocGetXattr(k, xattrKey, xv)
+	return bucket.SubdocGetXattr(context.Background(), k, xattrKey, xv)
 }
 
 func (bucket *CouchbaseBucketGoCB) GetWithXattr(k string, xattrKey string, userXattrKey string, rv interface{}, xv interface{}, uxv interface{}) (cas uint64, err error) {
-	return bucket.SubdocGetBodyAndXattr(k, xattrKey, userXattrKey, rv, xv, uxv)
+	return bucket.SubdocGetBodyAndXattr(context.Background(), k, xattrKey, userXattrKey, rv, xv, uxv)
 }
 
 func (bucket *CouchbaseBucketGoCB) WriteUpdateWithXattr(k string, xattrKey string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
-	return WriteUpdateWithXattr(bucket, k, xattrKey, userXattrKey, exp, previous, callback)
+	return WriteUpdateWithXattr(context.Background(), bucket, k, xattrKey, userXattrKey, exp, previous, callback)
+}
+
+func (bucket *CouchbaseBucketGoCB) WriteUpdateWithXattrCtx(ctx context.Context, k string, xattrKey string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
+	return WriteUpdateWithXattr(ctx, bucket, k, xattrKey, userXattrKey, exp, previous, callback)
 }
 
 func (bucket *CouchbaseBucketGoCB) UpdateXattr(k string, xattrKey string, exp uint32, cas uint64, xv interface{}, deleteBody bool, isDelete bool) (casOut uint64, err error) {
-	return UpdateTombstoneXattr(bucket, k, xattrKey, exp, cas, xv, deleteBody)
+	return UpdateTombstoneXattr(context.Background(), bucket, k, xattrKey, exp, cas, xv, deleteBody)
 }
 
 // SubdocGetXattr retrieves the named xattr
-func (bucket *CouchbaseBucketGoCB) SubdocGetXattr(k string, xattrKey string, xv interface{}) (casOut uint64, err error) {
+func (bucket *CouchbaseBucketGoCB) SubdocGetXattr(ctx context.Context, k string, xattrKey string, xv interface{}) (casOut uint64, err error) {
 
 	worker := func() (shouldRetry bool, err error, value interface{}) {
 		res, lookupErr := bucket.Bucket.LookupInEx(k, gocb.SubdocDocFlagAccessDeleted).
-			GetEx(xattrKey, gocb.SubdocFlagXattr).Execute()
+			GetEx(xattrPath(xattrKey), gocb.SubdocFlagXattr).Execute()
 		switch lookupErr {
 		case nil:
-			xattrContErr := res.Content(xattrKey, xv)
+			xattrContErr := res.Content(xattrPath(xattrKey), xv)
 			if xattrContErr != nil {
 				Debugf(KeyCRUD, "No xattr content found for key=%s, xattrKey=%s: %v", UD(k), UD(xattrKey), xattrContErr)
 			}
 			cas := uint64(res.Cas())
 			return false, err, cas
 		case gocbcore.ErrSubDocBadMulti:
-			xattrErr := res.Content(xattrKey, xv)
+			xattrErr := res.Content(xattrPath(xattrKey), xv)
 			Debugf(KeyCRUD, "No xattr content found for key=%s, xattrKey=%s: %v", UD(k), UD(xattrKey), xattrErr)
 			cas := uint64(res.Cas())
 			return false, ErrXattrNotFound, cas
@@ -62,7 +69,7 @@ func (bucket *CouchbaseBucketGoCB) SubdocGetXattr(k string, xattrKey string, xv
 			Debugf(KeyCRUD, "No document found for key=%s", UD(k))
 			return false, ErrNotFound, 0
 		case gocbcore.ErrSubDocMultiPathFailureDeleted, gocb.ErrSubDocSuccessDeleted:
-			xattrContentErr := res.Content(xattrKey, xv)
+			xattrContentErr := res.Content(xattrPath(xattrKey), xv)
 			if xattrContentErr != nil {
 				return false, ErrXattrNotFound, uint64(0)
 			}
@@ -75,7 +82,7 @@ func (bucket *CouchbaseBucketGoCB) SubdocGetXattr(k string, xattrKey string, xv
 
 	}
 
-	err, result := RetryLoop("SubdocGetXattr", worker, bucket.Spec.RetrySleeper())
+	err, result := RetryLoopCtx("SubdocGetXattr", worker, bucket.Spec.RetrySleeper(), ctx)
 	if err != nil {
 		err = pkgerrors.Wrapf(err, "SubdocGetXattr %s", UD(k).Redact())
 	}
@@ -93,15 +100,15 @@ func (bucket *CouchbaseBucketGoCB) SubdocGetXattr(k string, xattrKey string, xv
 }
 
 // Retrieve a document and it's associated named xattr
-func (bucket *CouchbaseBucketGoCB) SubdocGetBodyAndXattr(k string, xattrKey string, userXattrKey string, rv interface{}, xv interface{}, uxv interface{}) (cas uint64, err error) {
+func (bucket *CouchbaseBucketGoCB) SubdocGetBodyAndXattr(ctx context.Context, k string, xattrKey string, userXattrKey string, rv interface{}, xv interface{}, uxv interface{}) (cas uint64, err error) {
 
 	worker := func() (shouldRetry bool, err error, value uint64) {
 
 		// First, attempt to get the document and xattr in one shot. We can't set SubdocDocFlagAccessDeleted when attempting
 		// to retrieve the full doc body, so need to retry that scenario below.
 		res, lookupErr := bucket.Bucket.LookupInEx(k, gocb.SubdocDocFlagAccessDeleted).
-			GetEx(xattrKey, gocb.SubdocFlagXattr). // Get the xattr
-			GetEx("", gocb.SubdocFlagNone).        // Get the document body
+			GetEx(xattrPath(xattrKey), gocb.SubdocFlagXattr). // Get the xattr
+			GetEx("", gocb.SubdocFlagNone).                   // Get the document body
 			Execute()
 
 		// There are two 'partial success' error codes:
@@ -115,7 +122,7 @@ func (bucket *CouchbaseBucketGoCB) SubdocGetBodyAndXattr(k string, xattrKey stri
 				Debugf(KeyCRUD, "No document body found for key=%s, xattrKey=%s: %v", UD(k), UD(xattrKey), docContentErr)
 			}
 			// Attempt to retrieve the xattr, if present
-			xattrContentErr := res.Content(xattrKey, xv)
+			xattrContentErr := res.Content(xattrPath(xattrKey), xv)
 			if xattrContentErr != nil {
 				Debugf(KeyCRUD, "No xattr content found for key=%s, xattrKey=%s: %v", UD(k), UD(xattrKey), xattrContentErr)
 			}
@@ -123,7 +130,7 @@ func (bucket *CouchbaseBucketGoCB) SubdocGetBodyAndXattr(k string, xattrKey stri
 
 		case gocbcore.ErrSubDocMultiPathFailureDeleted:
 			//   ErrSubDocMultiPathFailureDeleted - one of the subdoc operations failed, and the doc is deleted.  Occurs when xattr may exist but doc is deleted (tombstone)
-			xattrContentErr := res.Content(xattrKey, xv)
+			xattrContentErr := res.Content(xattrPath(xattrKey), xv)
 			cas = uint64(res.Cas())
 			if xattrContentErr != nil {
 				// No doc, no xattr means the doc isn't found
@@ -141,7 +148,7 @@ func (bucket *CouchbaseBucketGoCB) SubdocGetBodyAndXattr(k string, xattrKey stri
 		// TODO: We may be able to improve in the future by having this secondary op as part of the first. At present
 		// there is no support to obtain more than one xattr in a single operation however MB-28041 is filed for this.
 		if userXattrKey != "" {
-			userXattrCas, err := bucket.SubdocGetXattr(k, userXattrKey, uxv)
+			userXattrCas, err := bucket.SubdocGetXattr(ctx, k, userXattrKey, uxv)
 			switch pkgerrors.Cause(err) {
 
 			case gocb.ErrKeyNotFound:
@@ -167,7 +174,7 @@ func (bucket *CouchbaseBucketGoCB) SubdocGetBodyAndXattr(k string, xattrKey stri
 	}
 
 	// Kick off retry loop
-	err, cas = RetryLoopCas("SubdocGetBodyAndXattr", worker, bucket.Spec.RetrySleeper())
+	err, cas = RetryLoopCasCtx("SubdocGetBodyAndXattr", worker, bucket.Spec.RetrySleeper(), ctx)
 	if err != nil {
 		err = pkgerrors.Wrapf(err, "SubdocGetBodyAndXattr %v", UD(k).Redact())
 	}
@@ -178,7 +185,7 @@ func (bucket *CouchbaseBucketGoCB) SubdocGetBodyAndXattr(k string, xattrKey stri
 
 // SubdocDeleteXattr removes the specified xattr.  Used to remove xattr from Couchbase Server
 // tombstones.
-func (bucket *CouchbaseBucketGoCB) SubdocDeleteXattr(k string, xattrKey string, cas uint64) error {
+func (bucket *CouchbaseBucketGoCB) SubdocDeleteXattr(ctx context.Context, k string, xattrKey string, cas uint64) error {
 	bucket.singleOps <- struct{}{}
 	defer func() {
 		<-bucket.singleOps
@@ -186,7 +193,7 @@ func (bucket *CouchbaseBucketGoCB) SubdocDeleteXattr(k string, xattrKey string,
 	// Cas-safe delete of just the XATTR.  Use SubdocDocFlagAccessDeleted since presumably the document body
 	// has been deleted.
 	_, mutateErrDeleteXattr := bucket.Bucket.MutateInEx(k, gocb.SubdocDocFlagAccessDeleted, gocb.Cas(cas), uint32(0)).
-		RemoveEx(xattrKey, gocb.SubdocFlagXattr). // Remove the xattr
+		RemoveEx(xattrPath(xattrKey), gocb.SubdocFlagXattr). // Remove the xattr
 		Execute()
 
 	// If no error, or it was just a ErrSubDocSuccessDeleted error, we're done.
@@ -199,15 +206,15 @@ func (bucket *CouchbaseBucketGoCB) SubdocDeleteXattr(k string, xattrKey string,
 
 // SubdocDeleteBodyAndXattr removes the body and specified xattr for a document.  Used
 // when purging a document
-func (bucket *CouchbaseBucketGoCB) SubdocDeleteBodyAndXattr(k string, xattrKey string) error {
+func (bucket *CouchbaseBucketGoCB) SubdocDeleteBodyAndXattr(ctx context.Context, k string, xattrKey string) error {
 	bucket.singleOps <- struct{}{}
 	defer func() {
 		<-bucket.singleOps
 	}()
 
 	_, mutateErr := bucket.Bucket.MutateInEx(k, gocb.SubdocDocFlagNone, gocb.Cas(0), uint32(0)).
-		RemoveEx(xattrKey, gocb.SubdocFlagXattr). // Remove the xattr
-		RemoveEx("", gocb.SubdocFlagNone).        // Delete the document body
+		RemoveEx(xattrPath(xattrKey), gocb.SubdocFlagXattr). // Remove the xattr
+		RemoveEx("", gocb.SubdocFlagNone).                   // Delete the document body
 		Execute()
 
 	if mutateErr == nil || mutateErr == gocbcore.ErrSubDocSuccessDeleted {
@@ -229,7 +236,7 @@ func (bucket *CouchbaseBucketGoCB) SubdocDeleteBodyAndXattr(k string, xattrKey s
 }
 
 // SubdocRemoveBody removes the document body, and updates the cas and crc32c on the specified xattr
-func (bucket *CouchbaseBucketGoCB) SubdocDeleteBody(k string, xattrKey string, exp uint32, cas uint64) (casOut uint64, err error) {
+func (bucket *CouchbaseBucketGoCB) SubdocDeleteBody(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64) (casOut uint64, err error) {
 	bucket.singleOps <- struct{}{}
 	defer func() {
 		<-bucket.singleOps
@@ -251,14 +258,14 @@ func (bucket *CouchbaseBucketGoCB) SubdocDeleteBody(k string, xattrKey string, e
 
 // SubdocUpdateXattrRemoveBody upserts the xattr and removes the document body.  Used when tombstoning a
 // document.
-func (bucket *CouchbaseBucketGoCB) SubdocUpdateXattrDeleteBody(k, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
+func (bucket *CouchbaseBucketGoCB) SubdocUpdateXattrDeleteBody(ctx context.Context, k, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
 	bucket.singleOps <- struct{}{}
 	defer func() {
 		<-bucket.singleOps
 	}()
 
 	docFragment, mutateErr := bucket.Bucket.MutateInEx(k, gocb.SubdocDocFlagNone, gocb.Cas(cas), exp).
-		UpsertEx(xattrKey, xv, gocb.SubdocFlagXattr).                                                       // Update the xattr
+		UpsertEx(xattrPath(xattrKey), xv, gocb.SubdocFlagXattr).                                            // Update the xattr
 		UpsertEx(xattrCasPath(xattrKey), "${Mutation.CAS}", gocb.SubdocFlagXattr|gocb.SubdocFlagUseMacros). // Stamp the cas on the xattr
 		UpsertEx(xattrCrc32cPath(xattrKey), DeleteCrc32c, gocb.SubdocFlagXattr).                            // Stamp crc32c on the xattr
 		RemoveEx("", gocb.SubdocFlagNone).                                                                  // Remove the body
@@ -274,7 +281,7 @@ func (bucket *CouchbaseBucketGoCB) SubdocUpdateXattrDeleteBody(k, xattrKey strin
 
 // Inserts a new server tombstone with xattr.  If tombstone creation is supported by server, body of created document
 // will be nil.  If unsupported, document body will be {}
-func (bucket *CouchbaseBucketGoCB) SubdocInsertXattr(k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
+func (bucket *CouchbaseBucketGoCB) SubdocInsertXattr(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
 	bucket.singleOps <- struct{}{}
 	defer func() {
 		<-bucket.singleOps
@@ -290,7 +297,7 @@ func (bucket *CouchbaseBucketGoCB) SubdocInsertXattr(k string, xattrKey string,
 		mutateFlag = gocb.SubdocDocFlagMkDoc
 	}
 	builder := bucket.Bucket.MutateInEx(k, mutateFlag, gocb.Cas(cas), exp).
-		UpsertEx(xattrKey, xv, gocb.SubdocFlagXattr).                                                       // Update the xattr
+		UpsertEx(xattrPath(xattrKey), xv, gocb.SubdocFlagXattr).                                            // Update the xattr
 		UpsertEx(xattrCasPath(xattrKey), "${Mutation.CAS}", gocb.SubdocFlagXattr|gocb.SubdocFlagUseMacros). // Stamp the cas on the xattr
 		UpsertEx(xattrCrc32cPath(xattrKey), DeleteCrc32c, gocb.SubdocFlagXattr)                             // Stamp the body hash on the xattr
 
@@ -302,10 +309,10 @@ func (bucket *CouchbaseBucketGoCB) SubdocInsertXattr(k string, xattrKey string,
 }
 
 // SubdocInsertBodyAndXattr creates a document with xattr.  Fails if document already exists
-func (bucket *CouchbaseBucketGoCB) SubdocInsertBodyAndXattr(k string, xattrKey string, exp uint32, v interface{}, xv interface{}) (casOut uint64, err error) {
+func (bucket *CouchbaseBucketGoCB) SubdocInsertBodyAndXattr(ctx context.Context, k string, xattrKey string, exp uint32, v interface{}, xv interface{}) (casOut uint64, err error) {
 
 	mutateInBuilder := bucket.Bucket.MutateInEx(k, gocb.SubdocDocFlagReplaceDoc, 0, exp).
-		UpsertEx(xattrKey, xv, gocb.SubdocFlagXattr).                                                      // Update the xattr
+		UpsertEx(xattrPath(xattrKey), xv, gocb.SubdocFlagXattr).                                           // Update the xattr
 		UpsertEx(xattrCasPath(xattrKey), "${Mutation.CAS}", gocb.SubdocFlagXattr|gocb.SubdocFlagUseMacros) // Stamp the cas on the xattr
 	if bucket.IsSupported(sgbucket.DataStoreFeatureCrc32cMacroExpansion) {
 		mutateInBuilder.UpsertEx(xattrCrc32cPath(xattrKey), "${Mutation.value_crc32c}", gocb.SubdocFlagXattr|gocb.SubdocFlagUseMacros) // Stamp the body hash on the xattr
@@ -319,11 +326,11 @@ func (bucket *CouchbaseBucketGoCB) SubdocInsertBodyAndXattr(k string, xattrKey s
 }
 
 // SubdocUpdateithXattr updates the document body and specified xattr.
-func (bucket *CouchbaseBucketGoCB) SubdocUpdateBodyAndXattr(k string, xattrKey string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
+func (bucket *CouchbaseBucketGoCB) SubdocUpdateBodyAndXattr(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
 
 	// Have value and xattr value - update both
 	mutateInBuilder := bucket.Bucket.MutateInEx(k, gocb.SubdocDocFlagMkDoc, gocb.Cas(cas), exp).
-		UpsertEx(xattrKey, xv, gocb.SubdocFlagXattr).                                                      // Update the xattr
+		UpsertEx(xattrPath(xattrKey), xv, gocb.SubdocFlagXattr).                                           // Update the xattr
 		UpsertEx(xattrCasPath(xattrKey), "${Mutation.CAS}", gocb.SubdocFlagXattr|gocb.SubdocFlagUseMacros) // Stamp the cas on the xattr
 	if bucket.IsSupported(sgbucket.DataStoreFeatureCrc32cMacroExpansion) {
 		mutateInBuilder.UpsertEx(xattrCrc32cPath(xattrKey), "${Mutation.value_crc32c}", gocb.SubdocFlagXattr|gocb.SubdocFlagUseMacros) // Stamp the body hash on the xattr
@@ -337,11 +344,11 @@ func (bucket *CouchbaseBucketGoCB) SubdocUpdateBodyAndXattr(k string, xattrKey s
 }
 
 // SubdocUpdateithXattrOnly upserts an xattr, does not modify body
-func (bucket *CouchbaseBucketGoCB) SubdocUpdateXattr(k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
+func (bucket *CouchbaseBucketGoCB) SubdocUpdateXattr(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
 
 	// Have value and xattr value - update both
 	mutateInBuilder := bucket.Bucket.MutateInEx(k, gocb.SubdocDocFlagAccessDeleted, gocb.Cas(cas), exp).
-		UpsertEx(xattrKey, xv, gocb.SubdocFlagXattr).                                                      // Update the xattr
+		UpsertEx(xattrPath(xattrKey), xv, gocb.SubdocFlagXattr).                                           // Update the xattr
 		UpsertEx(xattrCasPath(xattrKey), "${Mutation.CAS}", gocb.SubdocFlagXattr|gocb.SubdocFlagUseMacros) // Stamp the cas on the xattr
 	if bucket.IsSupported(sgbucket.DataStoreFeatureCrc32cMacroExpansion) {
 		mutateInBuilder.UpsertEx(xattrCrc32cPath(xattrKey), "${Mutation.value_crc32c}", gocb.SubdocFlagXattr|gocb.SubdocFlagUseMacros) // Stamp the body hash on the xattr
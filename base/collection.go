@@ -85,6 +85,7 @@ func GetCouchbaseCollection(spec BucketSpec) (*Collection, error) {
 	viewOpsQueue := make(chan struct{}, MaxConcurrentViewOps*nodeCount)
 	collection := &Collection{
 		Collection: bucket.DefaultCollection(),
+		Spec:       spec,
 		cluster:    cluster,
 		viewOps:    viewOpsQueue,
 	}
@@ -119,38 +120,54 @@ func (c *Collection) IsSupported(feature sgbucket.DataStoreFeature) bool {
 // KV store
 
 func (c *Collection) Get(k string, rv interface{}) (cas uint64, err error) {
-	getResult, err := c.Collection.Get(k, nil)
+	worker := func() (shouldRetry bool, err error, value uint64) {
+		getResult, err := c.Collection.Get(k, nil)
+		if err != nil {
+			return c.isRecoverableReadError(err), err, 0
+		}
+		return false, getResult.Content(rv), uint64(getResult.Cas())
+	}
+	err, cas = RetryLoopCas("Get", worker, c.Spec.RetrySleeper())
 	if err != nil {
-		return 0, err
+		err = pkgerrors.Wrapf(err, "Error during Get %s", UD(k).Redact())
 	}
-	err = getResult.Content(rv)
-	return uint64(getResult.Cas()), err
+	return cas, err
 }
 
 func (c *Collection) GetRaw(k string) (rv []byte, cas uint64, err error) {
 	getOptions := &gocb.GetOptions{
 		Transcoder: gocb.NewRawBinaryTranscoder(),
 	}
-	getRawResult, getErr := c.Collection.Get(k, getOptions)
-	if getErr != nil {
-		return nil, 0, getErr
+	worker := func() (shouldRetry bool, err error, value uint64) {
+		getRawResult, err := c.Collection.Get(k, getOptions)
+		if err != nil {
+			return c.isRecoverableReadError(err), err, 0
+		}
+		return false, getRawResult.Content(&rv), uint64(getRawResult.Cas())
 	}
-
-	err = getRawResult.Content(&rv)
-	return rv, uint64(getRawResult.Cas()), err
+	err, cas = RetryLoopCas("GetRaw", worker, c.Spec.RetrySleeper())
+	if err != nil {
+		err = pkgerrors.Wrapf(err, "Error during GetRaw %s", UD(k).Redact())
+	}
+	return rv, cas, err
 }
 
 func (c *Collection) GetAndTouchRaw(k string, exp uint32) (rv []byte, cas uint64, err error) {
 	getAndTouchOptions := &gocb.GetAndTouchOptions{
 		Transcoder: gocb.NewRawBinaryTranscoder(),
 	}
-	getAndTouchRawResult, getErr := c.Collection.GetAndTouch(k, expAsDuration(exp), getAndTouchOptions)
-	if getErr != nil {
-		return nil, 0, getErr
+	worker := func() (shouldRetry bool, err error, value uint64) {
+		getAndTouchRawResult, err := c.Collection.GetAndTouch(k, expAsDuration(exp), getAndTouchOptions)
+		if err != nil {
+			return c.isRecoverableReadError(err), err, 0
+		}
+		return false, getAndTouchRawResult.Content(&rv), uint64(getAndTouchRawResult.Cas())
 	}
-
-	err = getAndTouchRawResult.Content(&rv)
-	return rv, uint64(getAndTouchRawResult.Cas()), err
+	err, cas = RetryLoopCas("GetAndTouchRaw", worker, c.Spec.RetrySleeper())
+	if err != nil {
+		err = pkgerrors.Wrapf(err, "Error during GetAndTouchRaw %s", UD(k).Redact())
+	}
+	return rv, cas, err
 }
 
 func (c *Collection) Touch(k string, exp uint32) (cas uint64, err error) {
@@ -165,15 +182,7 @@ func (c *Collection) Add(k string, exp uint32, v interface{}) (added bool, err e
 	opts := &gocb.InsertOptions{
 		Expiry: expAsDuration(exp),
 	}
-	_, gocbErr := c.Collection.Insert(k, v, opts)
-	if gocbErr != nil {
-		// Check key exists handling
-		if errors.Is(gocbErr, gocb.ErrDocumentExists) {
-			return false, nil
-		}
-		err = pkgerrors.WithStack(gocbErr)
-	}
-	return err == nil, err
+	return c.insert(k, v, opts)
 }
 
 func (c *Collection) AddRaw(k string, exp uint32, v []byte) (added bool, err error) {
@@ -181,13 +190,22 @@ func (c *Collection) AddRaw(k string, exp uint32, v []byte) (added bool, err err
 		Expiry:     expAsDuration(exp),
 		Transcoder: gocb.NewRawBinaryTranscoder(),
 	}
-	_, gocbErr := c.Collection.Insert(k, v, opts)
-	if gocbErr != nil {
+	return c.insert(k, v, opts)
+}
+
+// insert performs an Add/AddRaw, returning false without error if the key already exists.
+func (c *Collection) insert(k string, v interface{}, opts *gocb.InsertOptions) (added bool, err error) {
+	worker := func() (shouldRetry bool, err error, value interface{}) {
+		_, err = c.Collection.Insert(k, v, opts)
+		return c.isRecoverableWriteError(err), err, nil
+	}
+	err, _ = RetryLoop("Collection Add()", worker, c.Spec.RetrySleeper())
+	if err != nil {
 		// Check key exists handling
-		if errors.Is(gocbErr, gocb.ErrDocumentExists) {
+		if errors.Is(err, gocb.ErrDocumentExists) {
 			return false, nil
 		}
-		err = pkgerrors.WithStack(gocbErr)
+		err = pkgerrors.WithStack(err)
 	}
 	return err == nil, err
 }
@@ -196,8 +214,7 @@ func (c *Collection) Set(k string, exp uint32, v interface{}) error {
 	upsertOptions := &gocb.UpsertOptions{
 		Expiry: expAsDuration(exp),
 	}
-	_, err := c.Collection.Upsert(k, v, upsertOptions)
-	return err
+	return c.upsert(k, v, upsertOptions)
 }
 
 func (c *Collection) SetRaw(k string, exp uint32, v []byte) error {
@@ -205,34 +222,63 @@ func (c *Collection) SetRaw(k string, exp uint32, v []byte) error {
 		Expiry:     expAsDuration(exp),
 		Transcoder: gocb.NewRawBinaryTranscoder(),
 	}
-	_, err := c.Collection.Upsert(k, v, upsertOptions)
+	return c.upsert(k, v, upsertOptions)
+}
+
+// upsert performs a Set/SetRaw.
+func (c *Collection) upsert(k string, v interface{}, opts *gocb.UpsertOptions) error {
+	worker := func() (shouldRetry bool, err error, value interface{}) {
+		_, err = c.Collection.Upsert(k, v, opts)
+		return c.isRecoverableWriteError(err), err, nil
+	}
+	err, _ := RetryLoop("Collection Set()", worker, c.Spec.RetrySleeper())
+	if err != nil {
+		err = pkgerrors.WithStack(err)
+	}
+	return err
+}
+
+func (c *Collection) Append(k string, data []byte) error {
+	_, err := c.Collection.Binary().Append(k, data, nil)
 	return err
 }
 
 func (c *Collection) WriteCas(k string, flags int, exp uint32, cas uint64, v interface{}, opt sgbucket.WriteOptions) (casOut uint64, err error) {
-	var result *gocb.MutationResult
-	if cas == 0 {
-		insertOpts := &gocb.InsertOptions{
-			Expiry: expAsDuration(exp),
-		}
-		if opt == sgbucket.Raw {
-			insertOpts.Transcoder = gocb.NewRawBinaryTranscoder()
-		}
-		result, err = c.Collection.Insert(k, v, insertOpts)
-	} else {
-		replaceOpts := &gocb.ReplaceOptions{
-			Cas:    gocb.Cas(cas),
-			Expiry: expAsDuration(exp),
+	// As for CouchbaseBucketGoCB, only the sgbucket.Raw WriteOption is supported
+	if opt != 0 && opt != sgbucket.Raw {
+		Panicf("WriteOption must be empty or sgbucket.Raw")
+	}
+
+	worker := func() (shouldRetry bool, err error, value uint64) {
+		var result *gocb.MutationResult
+		if cas == 0 {
+			insertOpts := &gocb.InsertOptions{
+				Expiry: expAsDuration(exp),
+			}
+			if opt == sgbucket.Raw {
+				insertOpts.Transcoder = gocb.NewRawBinaryTranscoder()
+			}
+			result, err = c.Collection.Insert(k, v, insertOpts)
+		} else {
+			replaceOpts := &gocb.ReplaceOptions{
+				Cas:    gocb.Cas(cas),
+				Expiry: expAsDuration(exp),
+			}
+			if opt == sgbucket.Raw {
+				replaceOpts.Transcoder = gocb.NewRawBinaryTranscoder()
+			}
+			result, err = c.Collection.Replace(k, v, replaceOpts)
 		}
-		if opt == sgbucket.Raw {
-			replaceOpts.Transcoder = gocb.NewRawBinaryTranscoder()
+		if err != nil {
+			return c.isRecoverableWriteError(err), err, 0
 		}
-		result, err = c.Collection.Replace(k, v, replaceOpts)
+		return false, nil, uint64(result.Cas())
 	}
+	err, casOut = RetryLoopCas("WriteCas", worker, c.Spec.RetrySleeper())
 	if err != nil {
-		return 0, err
+		err = pkgerrors.Wrapf(err, "WriteCas with key %v", UD(k).Redact())
 	}
-	return uint64(result.Cas()), nil
+	return casOut, err
 }
 
 func (c *Collection) Delete(k string) error {
@@ -241,11 +287,15 @@ func (c *Collection) Delete(k string) error {
 }
 
 func (c *Collection) Remove(k string, cas uint64) (casOut uint64, err error) {
-	result, errRemove := c.Collection.Remove(k, &gocb.RemoveOptions{Cas: gocb.Cas(cas)})
-	if errRemove != nil && result != nil {
-		casOut = uint64(result.Cas())
+	worker := func() (shouldRetry bool, err error, value uint64) {
+		result, errRemove := c.Collection.Remove(k, &gocb.RemoveOptions{Cas: gocb.Cas(cas)})
+		if errRemove != nil {
+			return c.isRecoverableWriteError(errRemove), errRemove, 0
+		}
+		return false, nil, uint64(result.Cas())
 	}
-	return casOut, errRemove
+	err, casOut = RetryLoopCas("Collection Remove()", worker, c.Spec.RetrySleeper())
+	return casOut, err
 }
 
 func (c *Collection) Update(k string, exp uint32, callback sgbucket.UpdateFunc) (casOut uint64, err error) {
@@ -337,17 +387,25 @@ func (c *Collection) Incr(k string, amt, def uint64, exp uint32) (uint64, error)
 	if amt == 0 {
 		return 0, errors.New("amt passed to Incr must be non-zero")
 	}
-	incrOptions := gocb.IncrementOptions{
+	incrOptions := &gocb.IncrementOptions{
 		Initial: int64(def),
 		Delta:   amt,
 		Expiry:  expAsDuration(exp),
 	}
-	incrResult, err := c.Collection.Binary().Increment(k, &incrOptions)
-	if err != nil {
-		return 0, err
+	worker := func() (shouldRetry bool, err error, value uint64) {
+		incrResult, err := c.Collection.Binary().Increment(k, incrOptions)
+		if err != nil {
+			return c.isRecoverableWriteError(err), err, 0
+		}
+		return false, nil, incrResult.Content()
 	}
 
-	return incrResult.Content(), nil
+	// Using RetryLoopCas to return a strongly typed incr result (NOT CAS)
+	err, val := RetryLoopCas("Incr with key", worker, c.Spec.RetrySleeper())
+	if err != nil {
+		err = pkgerrors.Wrapf(err, "Error during Incr with key: %v", UD(k).Redact())
+	}
+	return val, err
 }
 
 func (c *Collection) StartDCPFeed(args sgbucket.FeedArguments, callback sgbucket.FeedEventCallbackFunc, dbStats *expvar.Map) error {
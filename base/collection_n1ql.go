@@ -28,6 +28,11 @@ func (c *Collection) Keyspace() string {
 }
 
 func (c *Collection) Query(statement string, params map[string]interface{}, consistency ConsistencyMode, adhoc bool) (resultsIterator sgbucket.QueryResultIterator, err error) {
+	return c.QueryWithClientContextID(statement, params, consistency, adhoc, "")
+}
+
+// QueryWithClientContextID performs Query, identifying the query to the query service by clientContextID when set.
+func (c *Collection) QueryWithClientContextID(statement string, params map[string]interface{}, consistency ConsistencyMode, adhoc bool, clientContextID string) (resultsIterator sgbucket.QueryResultIterator, err error) {
 
 	bucketStatement := strings.Replace(statement, KeyspaceQueryToken, c.Keyspace(), -1)
 
@@ -35,6 +40,7 @@ func (c *Collection) Query(statement string, params map[string]interface{}, cons
 		ScanConsistency: gocb.QueryScanConsistency(consistency),
 		Adhoc:           adhoc,
 		NamedParameters: params,
+		ClientContextID: clientContextID,
 	}
 
 	waitTime := 10 * time.Millisecond
@@ -43,6 +43,7 @@ type N1QLStore interface {
 	Keyspace() string
 	GetIndexMeta(indexName string) (exists bool, meta *IndexMeta, err error)
 	Query(statement string, params map[string]interface{}, consistency ConsistencyMode, adhoc bool) (results sgbucket.QueryResultIterator, err error)
+	QueryWithClientContextID(statement string, params map[string]interface{}, consistency ConsistencyMode, adhoc bool, clientContextID string) (results sgbucket.QueryResultIterator, err error)
 	WaitForIndexOnline(indexName string) error
 	IsErrNoResults(error) bool
 
@@ -341,6 +342,12 @@ func AsN1QLStore(bucket Bucket) (N1QLStore, bool) {
 		underlyingBucket = typedBucket.GetUnderlyingBucket()
 	case *LeakyBucket:
 		underlyingBucket = typedBucket.GetUnderlyingBucket()
+	case *NamespaceBucket:
+		// Queries must go through the namespace bucket to filter results, but only when the underlying bucket supports N1QL
+		if _, ok := AsN1QLStore(typedBucket.GetUnderlyingBucket()); !ok {
+			return nil, false
+		}
+		return typedBucket, true
 	case *TestBucket:
 		underlyingBucket = typedBucket.Bucket
 	default:
@@ -1,6 +1,7 @@
 package base
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 
@@ -15,13 +16,14 @@ var GetSpecXattr = &gocb.GetSpecOptions{IsXattr: true}
 var InsertSpecXattr = &gocb.InsertSpecOptions{IsXattr: true}
 var UpsertSpecXattr = &gocb.UpsertSpecOptions{IsXattr: true}
 var RemoveSpecXattr = &gocb.RemoveSpecOptions{IsXattr: true}
-var LookupOptsAccessDeleted *gocb.LookupInOptions
 
 var _ SubdocXattrStore = &Collection{}
 
-func init() {
-	LookupOptsAccessDeleted = &gocb.LookupInOptions{}
-	LookupOptsAccessDeleted.Internal.DocFlags = gocb.SubdocDocFlagAccessDeleted
+// lookupOptsAccessDeleted returns options for a LookupIn bounded by ctx, that can access deleted documents.
+func lookupOptsAccessDeleted(ctx context.Context) *gocb.LookupInOptions {
+	options := &gocb.LookupInOptions{Context: ctx}
+	options.Internal.DocFlags = gocb.SubdocDocFlagAccessDeleted
+	return options
 }
 
 func (c *Collection) GetSpec() BucketSpec {
@@ -30,40 +32,46 @@ func (c *Collection) GetSpec() BucketSpec {
 
 // Implementation of the XattrStore interface primarily invokes common wrappers that in turn invoke SDK-specific SubdocXattrStore API
 func (c *Collection) WriteCasWithXattr(k string, xattrKey string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
-	return WriteCasWithXattr(c, k, xattrKey, exp, cas, v, xv)
+	return WriteCasWithXattr(context.Background(), c, k, xattrKey, exp, cas, v, xv)
 }
 
 func (c *Collection) WriteWithXattr(k string, xattrKey string, exp uint32, cas uint64, v []byte, xv []byte, isDelete bool, deleteBody bool) (casOut uint64, err error) { // If this is a tombstone, we want to delete the document and update the xattr
-	return WriteWithXattr(c, k, xattrKey, exp, cas, v, xv, isDelete, deleteBody)
+	return WriteWithXattr(context.Background(), c, k, xattrKey, exp, cas, v, xv, isDelete, deleteBody)
 }
 
 func (c *Collection) DeleteWithXattr(k string, xattrKey string) error {
-	return DeleteWithXattr(c, k, xattrKey)
+	return DeleteWithXattr(context.Background(), c, k, xattrKey)
 }
 
 func (c *Collection) GetXattr(k string, xattrKey string, xv interface{}) (casOut uint64, err error) {
-	return c.SubdocGetXattr(k, xattrKey, xv)
+	return c.SubdocGetXattr(context.Background(), k, xattrKey, xv)
 }
 
 func (c *Collection) GetWithXattr(k string, xattrKey string, userXattrKey string, rv interface{}, xv interface{}, uxv interface{}) (cas uint64, err error) {
-	return c.SubdocGetBodyAndXattr(k, xattrKey, userXattrKey, rv, xv, uxv)
+	return c.SubdocGetBodyAndXattr(context.Background(), k, xattrKey, userXattrKey, rv, xv, uxv)
 }
 
 func (c *Collection) WriteUpdateWithXattr(k string, xattrKey string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
-	return WriteUpdateWithXattr(c, k, xattrKey, userXattrKey, exp, previous, callback)
+	return WriteUpdateWithXattr(context.Background(), c, k, xattrKey, userXattrKey, exp, previous, callback)
+}
+
+func (c *Collection) WriteUpdateWithXattrCtx(ctx context.Context, k string, xattrKey string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
+	return WriteUpdateWithXattr(ctx, c, k, xattrKey, userXattrKey, exp, previous, callback)
 }
 
 func (c *Collection) UpdateXattr(k string, xattrKey string, exp uint32, cas uint64, xv interface{}, deleteBody bool, isDelete bool) (casOut uint64, err error) {
-	return UpdateTombstoneXattr(c, k, xattrKey, exp, cas, xv, deleteBody)
+	return UpdateTombstoneXattr(context.Background(), c, k, xattrKey, exp, cas, xv, deleteBody)
 }
 
 // SubdocGetXattr retrieves the named xattr
-func (c *Collection) SubdocGetXattr(k string, xattrKey string, xv interface{}) (casOut uint64, err error) {
+func (c *Collection) SubdocGetXattr(ctx context.Context, k string, xattrKey string, xv interface{}) (casOut uint64, err error) {
+	ctx, span := StartSpan(ctx, "kv.SubdocGetXattr")
+	defer span.End()
 
 	ops := []gocb.LookupInSpec{
-		gocb.GetSpec(xattrKey, GetSpecXattr),
+		gocb.GetSpec(xattrPath(xattrKey), GetSpecXattr),
 	}
-	res, lookupErr := c.LookupIn(k, ops, LookupOptsAccessDeleted)
+	res, lookupErr := c.LookupIn(k, ops, lookupOptsAccessDeleted(ctx))
 
 	if lookupErr == nil {
 		xattrContErr := res.ContentAt(0, xv)
@@ -97,15 +105,17 @@ func (c *Collection) SubdocGetXattr(k string, xattrKey string, xv interface{}) (
 }
 
 // SubdocGetBodyAndXattr retrieves the document body and xattr in a single LookupIn subdoc operation.  Does not require both to exist.
-func (c *Collection) SubdocGetBodyAndXattr(k string, xattrKey string, userXattrKey string, rv interface{}, xv interface{}, uxv interface{}) (cas uint64, err error) {
+func (c *Collection) SubdocGetBodyAndXattr(ctx context.Context, k string, xattrKey string, userXattrKey string, rv interface{}, xv interface{}, uxv interface{}) (cas uint64, err error) {
+	ctx, span := StartSpan(ctx, "kv.SubdocGetBodyAndXattr")
+	defer span.End()
 	worker := func() (shouldRetry bool, err error, value uint64) {
 
 		// First, attempt to get the document and xattr in one shot.
 		ops := []gocb.LookupInSpec{
-			gocb.GetSpec(xattrKey, GetSpecXattr),
+			gocb.GetSpec(xattrPath(xattrKey), GetSpecXattr),
 			gocb.GetSpec("", &gocb.GetSpecOptions{}),
 		}
-		res, lookupErr := c.LookupIn(k, ops, LookupOptsAccessDeleted)
+		res, lookupErr := c.LookupIn(k, ops, lookupOptsAccessDeleted(ctx))
 
 		// There are two 'partial success' error codes:
 		//   ErrSubDocBadMulti - one of the subdoc operations failed.  Occurs when doc exists but xattr does not
@@ -152,7 +162,7 @@ func (c *Collection) SubdocGetBodyAndXattr(k string, xattrKey string, userXattrK
 		// TODO: We may be able to improve in the future by having this secondary op as part of the first. At present
 		// there is no support to obtain more than one xattr in a single operation however MB-28041 is filed for this.
 		if userXattrKey != "" {
-			userXattrCas, err := c.SubdocGetXattr(k, userXattrKey, uxv)
+			userXattrCas, err := c.SubdocGetXattr(ctx, k, userXattrKey, uxv)
 			switch pkgerrors.Cause(err) {
 			case gocb.ErrDocumentNotFound:
 				// If key not found it has been deleted in between the first op and this op.
@@ -173,7 +183,7 @@ func (c *Collection) SubdocGetBodyAndXattr(k string, xattrKey string, userXattrK
 	}
 
 	// Kick off retry loop
-	err, cas = RetryLoopCas("SubdocGetBodyAndXattr", worker, c.Spec.RetrySleeper())
+	err, cas = RetryLoopCasCtx("SubdocGetBodyAndXattr", worker, c.Spec.RetrySleeper(), ctx)
 	if err != nil {
 		err = pkgerrors.Wrapf(err, "SubdocGetBodyAndXattr %v", UD(k).Redact())
 	}
@@ -183,7 +193,9 @@ func (c *Collection) SubdocGetBodyAndXattr(k string, xattrKey string, userXattrK
 
 // SubdocInsertXattr inserts a new server tombstone with an associated mobile xattr.  Writes cas and crc32c to the xattr using
 // macro expansion.
-func (c *Collection) SubdocInsertXattr(k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
+func (c *Collection) SubdocInsertXattr(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
+	ctx, span := StartSpan(ctx, "kv.SubdocInsertXattr")
+	defer span.End()
 
 	supportsTombstoneCreation := c.IsSupported(sgbucket.DataStoreFeatureCreateDeletedWithXattr)
 
@@ -195,11 +207,12 @@ func (c *Collection) SubdocInsertXattr(k string, xattrKey string, exp uint32, ca
 	}
 
 	mutateOps := []gocb.MutateInSpec{
-		gocb.UpsertSpec(xattrKey, bytesToRawMessage(xv), UpsertSpecXattr),
+		gocb.UpsertSpec(xattrPath(xattrKey), bytesToRawMessage(xv), UpsertSpecXattr),
 		gocb.UpsertSpec(xattrCasPath(xattrKey), gocb.MutationMacroCAS, UpsertSpecXattr),
 		gocb.UpsertSpec(xattrCrc32cPath(xattrKey), gocb.MutationMacroValueCRC32c, UpsertSpecXattr),
 	}
 	options := &gocb.MutateInOptions{
+		Context:       ctx,
 		StoreSemantic: gocb.StoreSemanticsUpsert,
 		Expiry:        CbsExpiryToDuration(exp),
 		Cas:           gocb.Cas(cas),
@@ -214,15 +227,18 @@ func (c *Collection) SubdocInsertXattr(k string, xattrKey string, exp uint32, ca
 
 // SubdocInsertXattr inserts a document and associated mobile xattr in a single mutateIn operation.  Writes cas and crc32c to the xattr using
 // macro expansion.
-func (c *Collection) SubdocInsertBodyAndXattr(k string, xattrKey string, exp uint32, v interface{}, xv interface{}) (casOut uint64, err error) {
+func (c *Collection) SubdocInsertBodyAndXattr(ctx context.Context, k string, xattrKey string, exp uint32, v interface{}, xv interface{}) (casOut uint64, err error) {
+	ctx, span := StartSpan(ctx, "kv.SubdocInsertBodyAndXattr")
+	defer span.End()
 
 	mutateOps := []gocb.MutateInSpec{
-		gocb.UpsertSpec(xattrKey, bytesToRawMessage(xv), UpsertSpecXattr),
+		gocb.UpsertSpec(xattrPath(xattrKey), bytesToRawMessage(xv), UpsertSpecXattr),
 		gocb.UpsertSpec(xattrCasPath(xattrKey), gocb.MutationMacroCAS, UpsertSpecXattr),
 		gocb.UpsertSpec(xattrCrc32cPath(xattrKey), gocb.MutationMacroValueCRC32c, UpsertSpecXattr),
 		gocb.ReplaceSpec("", bytesToRawMessage(v), nil),
 	}
 	options := &gocb.MutateInOptions{
+		Context:       ctx,
 		Expiry:        CbsExpiryToDuration(exp),
 		StoreSemantic: gocb.StoreSemanticsUpsert,
 	}
@@ -236,13 +252,16 @@ func (c *Collection) SubdocInsertBodyAndXattr(k string, xattrKey string, exp uin
 
 // SubdocUpdateXattr updates the xattr on an existing document. Writes cas and crc32c to the xattr using
 // macro expansion.
-func (c *Collection) SubdocUpdateXattr(k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
+func (c *Collection) SubdocUpdateXattr(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
+	ctx, span := StartSpan(ctx, "kv.SubdocUpdateXattr")
+	defer span.End()
 	mutateOps := []gocb.MutateInSpec{
-		gocb.UpsertSpec(xattrKey, bytesToRawMessage(xv), UpsertSpecXattr),
+		gocb.UpsertSpec(xattrPath(xattrKey), bytesToRawMessage(xv), UpsertSpecXattr),
 		gocb.UpsertSpec(xattrCasPath(xattrKey), gocb.MutationMacroCAS, UpsertSpecXattr),
 		gocb.UpsertSpec(xattrCrc32cPath(xattrKey), gocb.MutationMacroValueCRC32c, UpsertSpecXattr),
 	}
 	options := &gocb.MutateInOptions{
+		Context:       ctx,
 		Expiry:        CbsExpiryToDuration(exp),
 		StoreSemantic: gocb.StoreSemanticsUpsert,
 		Cas:           gocb.Cas(cas),
@@ -258,14 +277,17 @@ func (c *Collection) SubdocUpdateXattr(k string, xattrKey string, exp uint32, ca
 
 // SubdocUpdateBodyAndXattr updates the document body and xattr of an existing document. Writes cas and crc32c to the xattr using
 // macro expansion.
-func (c *Collection) SubdocUpdateBodyAndXattr(k string, xattrKey string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
+func (c *Collection) SubdocUpdateBodyAndXattr(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
+	ctx, span := StartSpan(ctx, "kv.SubdocUpdateBodyAndXattr")
+	defer span.End()
 	mutateOps := []gocb.MutateInSpec{
-		gocb.UpsertSpec(xattrKey, bytesToRawMessage(xv), UpsertSpecXattr),
+		gocb.UpsertSpec(xattrPath(xattrKey), bytesToRawMessage(xv), UpsertSpecXattr),
 		gocb.UpsertSpec(xattrCasPath(xattrKey), gocb.MutationMacroCAS, UpsertSpecXattr),
 		gocb.UpsertSpec(xattrCrc32cPath(xattrKey), gocb.MutationMacroValueCRC32c, UpsertSpecXattr),
 		gocb.ReplaceSpec("", bytesToRawMessage(v), nil),
 	}
 	options := &gocb.MutateInOptions{
+		Context:       ctx,
 		Expiry:        CbsExpiryToDuration(exp),
 		StoreSemantic: gocb.StoreSemanticsUpsert,
 		Cas:           gocb.Cas(cas),
@@ -279,14 +301,17 @@ func (c *Collection) SubdocUpdateBodyAndXattr(k string, xattrKey string, exp uin
 
 // SubdocUpdateBodyAndXattr deletes the document body and updates the xattr of an existing document. Writes cas and crc32c to the xattr using
 // macro expansion.
-func (c *Collection) SubdocUpdateXattrDeleteBody(k, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
+func (c *Collection) SubdocUpdateXattrDeleteBody(ctx context.Context, k, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error) {
+	ctx, span := StartSpan(ctx, "kv.SubdocUpdateXattrDeleteBody")
+	defer span.End()
 	mutateOps := []gocb.MutateInSpec{
-		gocb.UpsertSpec(xattrKey, bytesToRawMessage(xv), UpsertSpecXattr),
+		gocb.UpsertSpec(xattrPath(xattrKey), bytesToRawMessage(xv), UpsertSpecXattr),
 		gocb.UpsertSpec(xattrCasPath(xattrKey), gocb.MutationMacroCAS, UpsertSpecXattr),
 		gocb.UpsertSpec(xattrCrc32cPath(xattrKey), gocb.MutationMacroValueCRC32c, UpsertSpecXattr),
 		gocb.RemoveSpec("", nil),
 	}
 	options := &gocb.MutateInOptions{
+		Context:       ctx,
 		StoreSemantic: gocb.StoreSemanticsReplace,
 		Expiry:        CbsExpiryToDuration(exp),
 		Cas:           gocb.Cas(cas),
@@ -299,13 +324,16 @@ func (c *Collection) SubdocUpdateXattrDeleteBody(k, xattrKey string, exp uint32,
 }
 
 // SubdocDeleteXattr deletes an xattr of an existing document (or document tombstone)
-func (c *Collection) SubdocDeleteXattr(k string, xattrKey string, cas uint64) (err error) {
+func (c *Collection) SubdocDeleteXattr(ctx context.Context, k string, xattrKey string, cas uint64) (err error) {
+	ctx, span := StartSpan(ctx, "kv.SubdocDeleteXattr")
+	defer span.End()
 
 	mutateOps := []gocb.MutateInSpec{
-		gocb.RemoveSpec(xattrKey, RemoveSpecXattr),
+		gocb.RemoveSpec(xattrPath(xattrKey), RemoveSpecXattr),
 	}
 	options := &gocb.MutateInOptions{
-		Cas: gocb.Cas(cas),
+		Context: ctx,
+		Cas:     gocb.Cas(cas),
 	}
 	options.Internal.DocFlags = gocb.SubdocDocFlagAccessDeleted
 
@@ -314,12 +342,15 @@ func (c *Collection) SubdocDeleteXattr(k string, xattrKey string, cas uint64) (e
 }
 
 // SubdocDeleteXattr deletes the document body and associated xattr of an existing document.
-func (c *Collection) SubdocDeleteBodyAndXattr(k string, xattrKey string) (err error) {
+func (c *Collection) SubdocDeleteBodyAndXattr(ctx context.Context, k string, xattrKey string) (err error) {
+	ctx, span := StartSpan(ctx, "kv.SubdocDeleteBodyAndXattr")
+	defer span.End()
 	mutateOps := []gocb.MutateInSpec{
-		gocb.RemoveSpec(xattrKey, RemoveSpecXattr),
+		gocb.RemoveSpec(xattrPath(xattrKey), RemoveSpecXattr),
 		gocb.RemoveSpec("", nil),
 	}
 	options := &gocb.MutateInOptions{
+		Context:       ctx,
 		StoreSemantic: gocb.StoreSemanticsReplace,
 	}
 	_, mutateErr := c.MutateIn(k, mutateOps, options)
@@ -340,13 +371,16 @@ func (c *Collection) SubdocDeleteBodyAndXattr(k string, xattrKey string) (err er
 }
 
 // SubdocDeleteXattr deletes the document body of an existing document, and updates cas and crc32c in the associated xattr.
-func (c *Collection) SubdocDeleteBody(k string, xattrKey string, exp uint32, cas uint64) (casOut uint64, err error) {
+func (c *Collection) SubdocDeleteBody(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64) (casOut uint64, err error) {
+	ctx, span := StartSpan(ctx, "kv.SubdocDeleteBody")
+	defer span.End()
 	mutateOps := []gocb.MutateInSpec{
 		gocb.UpsertSpec(xattrCasPath(xattrKey), gocb.MutationMacroCAS, UpsertSpecXattr),
 		gocb.UpsertSpec(xattrCrc32cPath(xattrKey), gocb.MutationMacroValueCRC32c, UpsertSpecXattr),
 		gocb.RemoveSpec("", nil),
 	}
 	options := &gocb.MutateInOptions{
+		Context:       ctx,
 		StoreSemantic: gocb.StoreSemanticsReplace,
 		Expiry:        CbsExpiryToDuration(exp),
 		Cas:           gocb.Cas(cas),
@@ -1,7 +1,11 @@
 package base
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	sgbucket "github.com/couchbase/sg-bucket"
 	pkgerrors "github.com/pkg/errors"
@@ -13,18 +17,25 @@ const (
 	xattrMacroValueCrc32c = "value_crc32c"
 )
 
-// SubdocXattrStore interface defines the set of operations Sync Gateway uses to manage and interact with xattrs
+// MaxXattrKeyLength is the maximum length (in bytes) of an xattr key supported by the server.
+const MaxXattrKeyLength = 16
+
+// subdocPathSpecialChars are the characters with meaning in a subdoc path, which must be escaped within a path element.
+const subdocPathSpecialChars = ".[]`"
+
+// SubdocXattrStore interface defines the set of operations Sync Gateway uses to manage and interact with xattrs.  The
+// context bounds the operation - implementations pass it to the SDK where supported, and stop retrying once it's done.
 type SubdocXattrStore interface {
-	SubdocGetXattr(k string, xattrKey string, xv interface{}) (casOut uint64, err error)
-	SubdocGetBodyAndXattr(k string, xattrKey string, userXattrKey string, rv interface{}, xv interface{}, uxv interface{}) (cas uint64, err error)
-	SubdocInsertXattr(k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error)
-	SubdocInsertBodyAndXattr(k string, xattrKey string, exp uint32, v interface{}, xv interface{}) (casOut uint64, err error)
-	SubdocUpdateXattr(k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error)
-	SubdocUpdateBodyAndXattr(k string, xattrKey string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error)
-	SubdocUpdateXattrDeleteBody(k, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error)
-	SubdocDeleteXattr(k string, xattrKey string, cas uint64) error
-	SubdocDeleteBodyAndXattr(k string, xattrKey string) error
-	SubdocDeleteBody(k string, xattrKey string, exp uint32, cas uint64) (casOut uint64, err error)
+	SubdocGetXattr(ctx context.Context, k string, xattrKey string, xv interface{}) (casOut uint64, err error)
+	SubdocGetBodyAndXattr(ctx context.Context, k string, xattrKey string, userXattrKey string, rv interface{}, xv interface{}, uxv interface{}) (cas uint64, err error)
+	SubdocInsertXattr(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error)
+	SubdocInsertBodyAndXattr(ctx context.Context, k string, xattrKey string, exp uint32, v interface{}, xv interface{}) (casOut uint64, err error)
+	SubdocUpdateXattr(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error)
+	SubdocUpdateBodyAndXattr(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error)
+	SubdocUpdateXattrDeleteBody(ctx context.Context, k, xattrKey string, exp uint32, cas uint64, xv interface{}) (casOut uint64, err error)
+	SubdocDeleteXattr(ctx context.Context, k string, xattrKey string, cas uint64) error
+	SubdocDeleteBodyAndXattr(ctx context.Context, k string, xattrKey string) error
+	SubdocDeleteBody(ctx context.Context, k string, xattrKey string, exp uint32, cas uint64) (casOut uint64, err error)
 	GetSpec() BucketSpec
 	IsSupported(feature sgbucket.DataStoreFeature) bool
 	isRecoverableReadError(err error) bool
@@ -38,13 +49,13 @@ type KvXattrStore interface {
 }
 
 // CAS-safe write of a document and it's associated named xattr
-func WriteCasWithXattr(store SubdocXattrStore, k string, xattrKey string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
+func WriteCasWithXattr(ctx context.Context, store SubdocXattrStore, k string, xattrKey string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
 
 	worker := func() (shouldRetry bool, err error, value uint64) {
 
 		// cas=0 specifies an insert
 		if cas == 0 {
-			casOut, err = store.SubdocInsertBodyAndXattr(k, xattrKey, exp, v, xv)
+			casOut, err = store.SubdocInsertBodyAndXattr(ctx, k, xattrKey, exp, v, xv)
 			if err != nil {
 				shouldRetry = store.isRecoverableWriteError(err)
 				return shouldRetry, err, uint64(0)
@@ -55,14 +66,14 @@ func WriteCasWithXattr(store SubdocXattrStore, k string, xattrKey string, exp ui
 		// Otherwise, replace existing value
 		if v != nil {
 			// Have value and xattr value - update both
-			casOut, err = store.SubdocUpdateBodyAndXattr(k, xattrKey, exp, cas, v, xv)
+			casOut, err = store.SubdocUpdateBodyAndXattr(ctx, k, xattrKey, exp, cas, v, xv)
 			if err != nil {
 				shouldRetry = store.isRecoverableWriteError(err)
 				return shouldRetry, err, uint64(0)
 			}
 		} else {
 			// Update xattr only
-			casOut, err = store.SubdocUpdateXattr(k, xattrKey, exp, cas, xv)
+			casOut, err = store.SubdocUpdateXattr(ctx, k, xattrKey, exp, cas, xv)
 			if err != nil {
 				shouldRetry = store.isRecoverableWriteError(err)
 				return shouldRetry, err, uint64(0)
@@ -72,7 +83,7 @@ func WriteCasWithXattr(store SubdocXattrStore, k string, xattrKey string, exp ui
 	}
 
 	// Kick off retry loop
-	err, cas = RetryLoopCas("WriteCasWithXattr", worker, store.GetSpec().RetrySleeper())
+	err, cas = RetryLoopCasCtx("WriteCasWithXattr", worker, store.GetSpec().RetrySleeper(), ctx)
 	if err != nil {
 		err = pkgerrors.Wrapf(err, "WriteCasWithXattr with key %v", UD(k).Redact())
 	}
@@ -82,17 +93,17 @@ func WriteCasWithXattr(store SubdocXattrStore, k string, xattrKey string, exp ui
 
 // Single attempt to update a document and xattr.  Setting isDelete=true and value=nil will delete the document body.  Both
 // update types (UpdateTombstoneXattr, WriteCasWithXattr) include recoverable error retry.
-func WriteWithXattr(store SubdocXattrStore, k string, xattrKey string, exp uint32, cas uint64, value []byte, xattrValue []byte, isDelete bool, deleteBody bool) (casOut uint64, err error) { // If this is a tombstone, we want to delete the document and update the xattr
+func WriteWithXattr(ctx context.Context, store SubdocXattrStore, k string, xattrKey string, exp uint32, cas uint64, value []byte, xattrValue []byte, isDelete bool, deleteBody bool) (casOut uint64, err error) { // If this is a tombstone, we want to delete the document and update the xattr
 	if isDelete {
-		return UpdateTombstoneXattr(store, k, xattrKey, exp, cas, xattrValue, deleteBody)
+		return UpdateTombstoneXattr(ctx, store, k, xattrKey, exp, cas, xattrValue, deleteBody)
 	} else {
 		// Not a delete - update the body and xattr
-		return WriteCasWithXattr(store, k, xattrKey, exp, cas, value, xattrValue)
+		return WriteCasWithXattr(ctx, store, k, xattrKey, exp, cas, value, xattrValue)
 	}
 }
 
 // CAS-safe update of a document's xattr (only).  Deletes the document body if deleteBody is true.
-func UpdateTombstoneXattr(store SubdocXattrStore, k string, xattrKey string, exp uint32, cas uint64, xv interface{}, deleteBody bool) (casOut uint64, err error) {
+func UpdateTombstoneXattr(ctx context.Context, store SubdocXattrStore, k string, xattrKey string, exp uint32, cas uint64, xv interface{}, deleteBody bool) (casOut uint64, err error) {
 
 	// WriteCasWithXattr always stamps the xattr with the new cas using macro expansion, into a top-level property called 'cas'.
 	// This is the only use case for macro expansion today - if more cases turn up, should change the sg-bucket API to handle this more generically.
@@ -104,16 +115,16 @@ func UpdateTombstoneXattr(store SubdocXattrStore, k string, xattrKey string, exp
 
 		// If deleteBody == true, remove the body and update xattr
 		if deleteBody {
-			casOut, tombstoneErr = store.SubdocUpdateXattrDeleteBody(k, xattrKey, exp, cas, xv)
+			casOut, tombstoneErr = store.SubdocUpdateXattrDeleteBody(ctx, k, xattrKey, exp, cas, xv)
 		} else {
 			if cas == 0 {
 				// if cas == 0, create a new server tombstone with xattr
-				casOut, tombstoneErr = store.SubdocInsertXattr(k, xattrKey, exp, cas, xv)
+				casOut, tombstoneErr = store.SubdocInsertXattr(ctx, k, xattrKey, exp, cas, xv)
 				// If one-step tombstone creation is not supported, set flag for document body removal
 				requiresBodyRemoval = !store.IsSupported(sgbucket.DataStoreFeatureCreateDeletedWithXattr)
 			} else {
 				// If cas is non-zero, this is an already existing tombstone.  Update xattr only
-				casOut, tombstoneErr = store.SubdocUpdateXattr(k, xattrKey, exp, cas, xv)
+				casOut, tombstoneErr = store.SubdocUpdateXattr(ctx, k, xattrKey, exp, cas, xv)
 			}
 		}
 
@@ -125,7 +136,7 @@ func UpdateTombstoneXattr(store SubdocXattrStore, k string, xattrKey string, exp
 	}
 
 	// Kick off retry loop
-	err, cas = RetryLoopCas("UpdateTombstoneXattr", worker, store.GetSpec().RetrySleeper())
+	err, cas = RetryLoopCasCtx("UpdateTombstoneXattr", worker, store.GetSpec().RetrySleeper(), ctx)
 	if err != nil {
 		err = pkgerrors.Wrapf(err, "Error during UpdateTombstoneXattr with key %v", UD(k).Redact())
 		return cas, err
@@ -138,7 +149,7 @@ func UpdateTombstoneXattr(store SubdocXattrStore, k string, xattrKey string, exp
 	if requiresBodyRemoval {
 		worker := func() (shouldRetry bool, err error, value uint64) {
 
-			casOut, removeErr := store.SubdocDeleteBody(k, xattrKey, exp, cas)
+			casOut, removeErr := store.SubdocDeleteBody(ctx, k, xattrKey, exp, cas)
 			if removeErr != nil {
 				// If there is a cas mismatch the body has since been updated and so we don't need to bother removing
 				// body in this operation
@@ -152,7 +163,7 @@ func UpdateTombstoneXattr(store SubdocXattrStore, k string, xattrKey string, exp
 			return false, nil, casOut
 		}
 
-		err, cas = RetryLoopCas("UpdateXattrDeleteBodySecondOp", worker, store.GetSpec().RetrySleeper())
+		err, cas = RetryLoopCasCtx("UpdateXattrDeleteBodySecondOp", worker, store.GetSpec().RetrySleeper(), ctx)
 		if err != nil {
 			err = pkgerrors.Wrapf(err, "Error during UpdateTombstoneXattr delete op with key %v", UD(k).Redact())
 			return cas, err
@@ -165,7 +176,8 @@ func UpdateTombstoneXattr(store SubdocXattrStore, k string, xattrKey string, exp
 
 // WriteUpdateWithXattr retrieves the existing doc from the bucket, invokes the callback to update the document, then writes the new document to the bucket.  Will repeat this process on cas
 // failure.  If previousValue/xattr/cas are provided, will use those on the first iteration instead of retrieving from the bucket.
-func WriteUpdateWithXattr(store SubdocXattrStore, k string, xattrKey string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
+// Stops retrying once ctx is done, returning ctx.Err().
+func WriteUpdateWithXattr(ctx context.Context, store SubdocXattrStore, k string, xattrKey string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
 
 	var value []byte
 	var xattrValue []byte
@@ -182,11 +194,15 @@ func WriteUpdateWithXattr(store SubdocXattrStore, k string, xattrKey string, use
 	}
 
 	for {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return emptyCas, ctxErr
+		}
+
 		var err error
 		// If no existing value has been provided, retrieve the current value from the bucket
 		if cas == 0 {
 			// Load the existing value.
-			cas, err = store.SubdocGetBodyAndXattr(k, xattrKey, userXattrKey, &value, &xattrValue, &userXattrValue)
+			cas, err = store.SubdocGetBodyAndXattr(ctx, k, xattrKey, userXattrKey, &value, &xattrValue, &userXattrValue)
 
 			if err != nil {
 				if pkgerrors.Cause(err) != ErrNotFound {
@@ -219,7 +235,7 @@ func WriteUpdateWithXattr(store SubdocXattrStore, k string, xattrKey string, use
 
 		// Attempt to write the updated document to the bucket.  Mark body for deletion if previous body was non-empty
 		deleteBody := value != nil
-		casOut, writeErr := WriteWithXattr(store, k, xattrKey, exp, cas, updatedValue, updatedXattrValue, isDelete, deleteBody)
+		casOut, writeErr := WriteWithXattr(ctx, store, k, xattrKey, exp, cas, updatedValue, updatedXattrValue, isDelete, deleteBody)
 
 		switch pkgerrors.Cause(writeErr) {
 		case nil:
@@ -255,16 +271,16 @@ func WriteUpdateWithXattr(store SubdocXattrStore, k string, xattrKey string, use
 // Expected errors:
 //    - Temporary server overloaded errors, in which case the caller should retry
 //    - If the doc is in the the NoDoc and NoXattr state, it will return a KeyNotFound error
-func DeleteWithXattr(store KvXattrStore, k string, xattrKey string) error {
+func DeleteWithXattr(ctx context.Context, store KvXattrStore, k string, xattrKey string) error {
 	// Delegate to internal method that can take a testing-related callback
-	return deleteWithXattrInternal(store, k, xattrKey, nil)
+	return deleteWithXattrInternal(ctx, store, k, xattrKey, nil)
 }
 
 // A function that will be called back after the first delete attempt but before second delete attempt
 // to simulate the doc having changed state (artifiically injected race condition)
 type deleteWithXattrRaceInjection func(k string, xattrKey string)
 
-func deleteWithXattrInternal(store KvXattrStore, k string, xattrKey string, callback deleteWithXattrRaceInjection) error {
+func deleteWithXattrInternal(ctx context.Context, store KvXattrStore, k string, xattrKey string, callback deleteWithXattrRaceInjection) error {
 
 	Debugf(KeyCRUD, "DeleteWithXattr called with key: %v xattrKey: %v", UD(k), UD(xattrKey))
 
@@ -272,7 +288,7 @@ func deleteWithXattrInternal(store KvXattrStore, k string, xattrKey string, call
 	// NOTE: ongoing discussion w/ KV Engine team on whether this should handle cases where the body
 	// doesn't exist (eg, a tombstoned xattr doc) by just ignoring the "delete body" mutation, rather
 	// than current behavior of returning gocb.ErrKeyNotFound
-	mutateErr := store.SubdocDeleteBodyAndXattr(k, xattrKey)
+	mutateErr := store.SubdocDeleteBodyAndXattr(ctx, k, xattrKey)
 	switch {
 	case mutateErr == ErrNotFound:
 		// Invoke the testing related callback.  This is a no-op in non-test contexts.
@@ -280,7 +296,7 @@ func deleteWithXattrInternal(store KvXattrStore, k string, xattrKey string, call
 			callback(k, xattrKey)
 		}
 		// KeyNotFound indicates there is no doc body.  Try to delete only the xattr.
-		return deleteDocXattrOnly(store, k, xattrKey, callback)
+		return deleteDocXattrOnly(ctx, store, k, xattrKey, callback)
 	case mutateErr == ErrXattrNotFound:
 		// Invoke the testing related callback.  This is a no-op in non-test contexts.
 		if callback != nil {
@@ -296,12 +312,12 @@ func deleteWithXattrInternal(store KvXattrStore, k string, xattrKey string, call
 
 }
 
-func deleteDocXattrOnly(store SubdocXattrStore, k string, xattrKey string, callback deleteWithXattrRaceInjection) error {
+func deleteDocXattrOnly(ctx context.Context, store SubdocXattrStore, k string, xattrKey string, callback deleteWithXattrRaceInjection) error {
 
 	//  Do get w/ xattr in order to get cas
 	var retrievedVal map[string]interface{}
 	var retrievedXattr map[string]interface{}
-	getCas, err := store.SubdocGetBodyAndXattr(k, xattrKey, "", &retrievedVal, &retrievedXattr, nil)
+	getCas, err := store.SubdocGetBodyAndXattr(ctx, k, xattrKey, "", &retrievedVal, &retrievedXattr, nil)
 	if err != nil {
 		return err
 	}
@@ -316,7 +332,7 @@ func deleteDocXattrOnly(store SubdocXattrStore, k string, xattrKey string, callb
 
 	// Cas-safe delete of just the XATTR.  Use SubdocDocFlagAccessDeleted since presumably the document body
 	// has been deleted.
-	deleteXattrErr := store.SubdocDeleteXattr(k, xattrKey, getCas)
+	deleteXattrErr := store.SubdocDeleteXattr(ctx, k, xattrKey, getCas)
 	if deleteXattrErr != nil {
 		// If the cas-safe delete of XATTR fails, return an error to the caller.
 		// This might happen if there was a concurrent update interleaved with the purge (someone resurrected doc)
@@ -328,6 +344,24 @@ func deleteDocXattrOnly(store SubdocXattrStore, k string, xattrKey string, callb
 
 }
 
+// XattrStoreCtx is implemented by buckets whose xattr updates can be bounded by a context, such as an HTTP request's.
+type XattrStoreCtx interface {
+	WriteUpdateWithXattrCtx(ctx context.Context, k string, xattrKey string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error)
+}
+
+// WriteUpdateWithXattrCtx performs bucket.WriteUpdateWithXattr bounded by ctx.  The context is ignored by buckets that
+// don't implement XattrStoreCtx.
+func WriteUpdateWithXattrCtx(ctx context.Context, bucket Bucket, k string, xattrKey string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
+	switch typedBucket := bucket.(type) {
+	case XattrStoreCtx:
+		return typedBucket.WriteUpdateWithXattrCtx(ctx, k, xattrKey, userXattrKey, exp, previous, callback)
+	case *TestBucket:
+		return WriteUpdateWithXattrCtx(ctx, typedBucket.Bucket, k, xattrKey, userXattrKey, exp, previous, callback)
+	default:
+		return bucket.WriteUpdateWithXattr(k, xattrKey, userXattrKey, exp, previous, callback)
+	}
+}
+
 // AsSubdocXattrStore tries to return the given bucket as a SubdocXattrStore, based on underlying buckets.
 func AsSubdocXattrStore(bucket Bucket) (SubdocXattrStore, bool) {
 
@@ -351,10 +385,47 @@ func AsSubdocXattrStore(bucket Bucket) (SubdocXattrStore, bool) {
 	return AsSubdocXattrStore(underlyingBucket)
 }
 
+// escapeSubdocPathElement returns element escaped for use as a single element of a subdoc path.  Elements containing
+// characters with meaning in a subdoc path are wrapped in backticks, with any literal backticks doubled.
+func escapeSubdocPathElement(element string) string {
+	if !strings.ContainsAny(element, subdocPathSpecialChars) {
+		return element
+	}
+	return "`" + strings.ReplaceAll(element, "`", "``") + "`"
+}
+
+// xattrPath returns the subdoc path of the xattr with the given key.
+func xattrPath(xattrKey string) string {
+	return escapeSubdocPathElement(xattrKey)
+}
+
 func xattrCasPath(xattrKey string) string {
-	return xattrKey + "." + xattrMacroCas
+	return xattrPath(xattrKey) + "." + xattrMacroCas
 }
 
 func xattrCrc32cPath(xattrKey string) string {
-	return xattrKey + "." + xattrMacroValueCrc32c
+	return xattrPath(xattrKey) + "." + xattrMacroValueCrc32c
+}
+
+// ValidateXattrKey returns an *InvalidXattrKeyError when xattrKey can't be used as an xattr key by the server.
+// Characters with meaning in subdoc paths are permitted, as they're escaped when building subdoc paths.
+func ValidateXattrKey(xattrKey string) error {
+	if xattrKey == "" {
+		return &InvalidXattrKeyError{Key: xattrKey, Reason: "must not be empty"}
+	}
+	if len(xattrKey) > MaxXattrKeyLength {
+		return &InvalidXattrKeyError{Key: xattrKey, Reason: fmt.Sprintf("must not be longer than %d bytes", MaxXattrKeyLength)}
+	}
+	if !utf8.ValidString(xattrKey) {
+		return &InvalidXattrKeyError{Key: xattrKey, Reason: "must be valid UTF-8"}
+	}
+	if strings.HasPrefix(xattrKey, "$") {
+		return &InvalidXattrKeyError{Key: xattrKey, Reason: "must not start with $, which is reserved for virtual xattrs"}
+	}
+	for _, r := range xattrKey {
+		if unicode.IsControl(r) || unicode.IsSpace(r) {
+			return &InvalidXattrKeyError{Key: xattrKey, Reason: "must not contain control or whitespace characters"}
+		}
+	}
+	return nil
 }
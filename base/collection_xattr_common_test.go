@@ -0,0 +1,92 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// errRecoverableTest is treated as recoverable by retryingXattrStore.
+var errRecoverableTest = errors.New("recoverable test error")
+
+// retryingXattrStore is a SubdocXattrStore whose operations always fail with a recoverable error, forcing retries.
+// Only the operations used by WriteCasWithXattr and WriteUpdateWithXattr are implemented.
+type retryingXattrStore struct {
+	SubdocXattrStore
+	attempts int32 // Number of operations attempted
+}
+
+func (s *retryingXattrStore) SubdocGetBodyAndXattr(ctx context.Context, k string, xattrKey string, userXattrKey string, rv interface{}, xv interface{}, uxv interface{}) (cas uint64, err error) {
+	return 0, ErrNotFound
+}
+
+func (s *retryingXattrStore) SubdocInsertBodyAndXattr(ctx context.Context, k string, xattrKey string, exp uint32, v interface{}, xv interface{}) (casOut uint64, err error) {
+	atomic.AddInt32(&s.attempts, 1)
+	return 0, errRecoverableTest
+}
+
+func (s *retryingXattrStore) GetSpec() BucketSpec {
+	// Without cancellation, retries would continue for minutes
+	return BucketSpec{MaxNumRetries: 20, InitialRetrySleepTimeMS: 100}
+}
+
+func (s *retryingXattrStore) isRecoverableWriteError(err error) bool {
+	return err == errRecoverableTest
+}
+
+// Validates that xattr writes retrying on behalf of a caller stop promptly once the caller's context is cancelled.
+func TestWriteWithXattrCancelledMidRetry(t *testing.T) {
+
+	store := &retryingXattrStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(250*time.Millisecond, cancel)
+
+	startTime := time.Now()
+	_, err := WriteCasWithXattr(ctx, store, "doc1", SyncXattrName, 0, 0, []byte(`{}`), []byte(`{}`))
+	require.Error(t, err)
+	assert.Equal(t, context.Canceled, pkgerrors.Cause(err))
+	assert.Less(t, int64(time.Since(startTime)), int64(5*time.Second))
+	attempts := atomic.LoadInt32(&store.attempts)
+	assert.Greater(t, attempts, int32(1))
+
+	// WriteUpdateWithXattr doesn't attempt a write once the context is done
+	callback := func(current []byte, xattr []byte, userXattr []byte, cas uint64) ([]byte, []byte, bool, *uint32, error) {
+		return []byte(`{}`), []byte(`{}`), false, nil, nil
+	}
+	_, err = WriteUpdateWithXattr(ctx, store, "doc1", SyncXattrName, "", 0, nil, callback)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, attempts, atomic.LoadInt32(&store.attempts))
+
+	// The context is passed through bucket wrappers
+	leakyBucket := NewLeakyBucket(&TestBucket{Bucket: &testXattrCtxBucket{store: store}}, LeakyBucketConfig{})
+	_, err = WriteUpdateWithXattrCtx(ctx, leakyBucket, "doc1", SyncXattrName, "", 0, nil, callback)
+	assert.Equal(t, context.Canceled, err)
+}
+
+// testXattrCtxBucket is a Bucket whose context-bounded xattr updates are performed against store.
+type testXattrCtxBucket struct {
+	Bucket
+	store SubdocXattrStore
+}
+
+func (b *testXattrCtxBucket) WriteUpdateWithXattrCtx(ctx context.Context, k string, xattrKey string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
+	return WriteUpdateWithXattr(ctx, b.store, k, xattrKey, userXattrKey, exp, previous, callback)
+}
@@ -110,6 +110,7 @@ const (
 	BackfillCompletePrefix = SyncPrefix + "backfill:complete:"
 	BackfillPendingPrefix  = SyncPrefix + "backfill:pending:"
 	DCPCheckpointPrefix    = SyncPrefix + "dcp_ck:"
+	PurgeMarkerPrefix      = SyncPrefix + "purge:"
 	RepairBackup           = SyncPrefix + "repair:backup:"
 	RepairDryRun           = SyncPrefix + "repair:dryrun:"
 	RevBodyPrefix          = SyncPrefix + "rb:"
@@ -123,9 +124,12 @@ const (
 	UnusedSeqPrefix        = SyncPrefix + "unusedSeq:"
 	UnusedSeqRangePrefix   = SyncPrefix + "unusedSeqs:"
 
-	DCPBackfillSeqKey = SyncPrefix + "dcp_backfill"
-	SyncDataKey       = SyncPrefix + "syncdata"
-	SyncSeqKey        = SyncPrefix + "seq"
+	CacheHandoffKey        = SyncPrefix + "cache_handoff"
+	DCPBackfillSeqKey      = SyncPrefix + "dcp_backfill"
+	DCPCheckpointGroupsKey = SyncPrefix + "dcp_ck_groups"
+	SyncDataKey            = SyncPrefix + "syncdata"
+	SyncSeqKey             = SyncPrefix + "seq"
+	SyncEpochKey           = SyncPrefix + "epoch"
 
 	SyncPropertyName = "_sync"
 	SyncXattrName    = "_sync"
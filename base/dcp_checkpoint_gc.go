@@ -0,0 +1,213 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultDCPCheckpointGroup is the checkpoint group used by feeds persisting ungrouped checkpoints (_sync:dcp_ck:<vbNo>).
+const DefaultDCPCheckpointGroup = ""
+
+// DefaultDCPCheckpointGCMaxAge is the default age after which checkpoints for inactive checkpoint groups are collected.
+const DefaultDCPCheckpointGCMaxAge = 7 * 24 * time.Hour
+
+// DCPCheckpointKey returns the key of the checkpoint document for the given checkpoint group and vbucket.
+func DCPCheckpointKey(group string, vbNo uint16) string {
+	if group == DefaultDCPCheckpointGroup {
+		return fmt.Sprintf("%s%d", DCPCheckpointPrefix, vbNo)
+	}
+	return fmt.Sprintf("%s%s:%d", DCPCheckpointPrefix, group, vbNo)
+}
+
+// dcpCheckpointGroup returns the checkpoint group for the given checkpoint document key.
+func dcpCheckpointGroup(key string) string {
+	groupAndVb := strings.TrimPrefix(key, DCPCheckpointPrefix)
+	separator := strings.LastIndex(groupAndVb, ":")
+	if separator < 0 {
+		return DefaultDCPCheckpointGroup
+	}
+	return groupAndVb[:separator]
+}
+
+// DCPCheckpointGroups is the metadata document recording the active checkpoint group, and when each known group was
+// last active (or first seen inactive) as a unix timestamp.
+type DCPCheckpointGroups struct {
+	Active     string           `json:"active"`
+	LastActive map[string]int64 `json:"last_active"`
+}
+
+// getDCPCheckpointGroups returns the checkpoint groups document, or an empty document when one hasn't been persisted.
+func getDCPCheckpointGroups(bucket Bucket) (*DCPCheckpointGroups, error) {
+	groups := &DCPCheckpointGroups{}
+	rawValue, _, err := bucket.GetRaw(DCPCheckpointGroupsKey)
+	if err != nil && !IsKeyNotFoundError(bucket, err) {
+		return nil, err
+	}
+	if len(rawValue) > 0 {
+		if err := JSONUnmarshal(rawValue, groups); err != nil {
+			return nil, err
+		}
+	}
+	if groups.LastActive == nil {
+		groups.LastActive = make(map[string]int64)
+	}
+	return groups, nil
+}
+
+// updateDCPCheckpointGroups applies the given update to the checkpoint groups document.
+func updateDCPCheckpointGroups(bucket Bucket, update func(groups *DCPCheckpointGroups)) error {
+	_, err := bucket.Update(DCPCheckpointGroupsKey, 0, func(current []byte) (updated []byte, expiry *uint32, isDelete bool, err error) {
+		groups := &DCPCheckpointGroups{}
+		if len(current) > 0 {
+			if err := JSONUnmarshal(current, groups); err != nil {
+				return nil, nil, false, err
+			}
+		}
+		if groups.LastActive == nil {
+			groups.LastActive = make(map[string]int64)
+		}
+		update(groups)
+		updated, err = JSONMarshal(groups)
+		return updated, nil, false, err
+	})
+	return err
+}
+
+// ActiveDCPCheckpointGroup returns the checkpoint group that checkpoints are currently persisted under.
+func ActiveDCPCheckpointGroup(bucket Bucket) (string, error) {
+	groups, err := getDCPCheckpointGroups(bucket)
+	if err != nil {
+		return DefaultDCPCheckpointGroup, err
+	}
+	return groups.Active, nil
+}
+
+// SetActiveDCPCheckpointGroup records group as the active checkpoint group, which feeds subsequently started persist
+// their checkpoints under.  The previously active group becomes eligible for collection once it's been inactive for
+// longer than the checkpoint GC max age.
+func SetActiveDCPCheckpointGroup(bucket Bucket, group string) error {
+	now := time.Now().Unix()
+	return updateDCPCheckpointGroups(bucket, func(groups *DCPCheckpointGroups) {
+		groups.LastActive[groups.Active] = now
+		groups.Active = group
+		groups.LastActive[group] = now
+	})
+}
+
+// isStale returns true if group isn't the active group, and has been inactive for at least maxAge.  Groups that
+// haven't been seen aren't stale, as how long they've been inactive isn't known.
+func (groups *DCPCheckpointGroups) isStale(group string, now time.Time, maxAge time.Duration) bool {
+	if group == groups.Active {
+		return false
+	}
+	lastActive, ok := groups.LastActive[group]
+	if !ok {
+		return false
+	}
+	return now.Sub(time.Unix(lastActive, 0)) >= maxAge
+}
+
+// StaleDCPCheckpointPredicate returns a MetadataPurge predicate matching the checkpoint documents of groups that have
+// been inactive for at least maxAge.  Checkpoints of the active group are never matched.
+func StaleDCPCheckpointPredicate(bucket Bucket, maxAge time.Duration) (func(key string) bool, error) {
+	groups, err := getDCPCheckpointGroups(bucket)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return func(key string) bool {
+		return groups.isStale(dcpCheckpointGroup(key), now, maxAge)
+	}, nil
+}
+
+// DCPCheckpointGCResult reports the checkpoint documents collected (or, for a dry run, that would be collected) by
+// stale checkpoint group.
+type DCPCheckpointGCResult struct {
+	DryRun      bool           `json:"dry_run"`
+	ActiveGroup string         `json:"active_group"`
+	StaleGroups map[string]int `json:"stale_groups"`
+	Deleted     int            `json:"deleted"`
+	Keys        []string       `json:"keys,omitempty"` // Keys that would be deleted, for dry runs
+}
+
+// CollectDCPCheckpoints deletes checkpoint documents belonging to groups other than the active group that have been
+// inactive for at least maxAge.  Groups seen for the first time are recorded in the checkpoint groups document, and
+// collected once they've aged out.  In dry-run mode the keys that would be deleted are listed instead.
+func CollectDCPCheckpoints(bucket Bucket, iterate MetadataKeyIterator, maxAge time.Duration, dryRun bool) (*DCPCheckpointGCResult, error) {
+
+	groups, err := getDCPCheckpointGroups(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DCPCheckpointGCResult{
+		DryRun:      dryRun,
+		ActiveGroup: groups.Active,
+		StaleGroups: make(map[string]int),
+	}
+
+	now := time.Now()
+	unseenGroups := make(map[string]struct{})
+	shouldCollect := func(key string) bool {
+		group := dcpCheckpointGroup(key)
+		if group == groups.Active {
+			return false
+		}
+		if _, ok := groups.LastActive[group]; !ok {
+			unseenGroups[group] = struct{}{}
+			return false
+		}
+		if !groups.isStale(group, now, maxAge) {
+			return false
+		}
+		result.StaleGroups[group]++
+		if dryRun {
+			result.Keys = append(result.Keys, key)
+		}
+		return true
+	}
+
+	purgeResult, err := MetadataPurge(bucket, iterate, []string{DCPCheckpointPrefix}, shouldCollect, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	result.Deleted = purgeResult.Purged[DCPCheckpointPrefix]
+
+	if !dryRun {
+		fullyCollected := result.Deleted == purgeResult.Matched[DCPCheckpointPrefix]
+		if len(unseenGroups) > 0 || (fullyCollected && len(result.StaleGroups) > 0) {
+			err = updateDCPCheckpointGroups(bucket, func(persisted *DCPCheckpointGroups) {
+				for group := range unseenGroups {
+					if _, ok := persisted.LastActive[group]; !ok {
+						persisted.LastActive[group] = now.Unix()
+					}
+				}
+				if fullyCollected {
+					for group := range result.StaleGroups {
+						if group != persisted.Active {
+							delete(persisted.LastActive, group)
+						}
+					}
+				}
+			})
+			if err != nil {
+				Warnf("Unable to update DCP checkpoint groups after checkpoint collection: %v", err)
+			}
+		}
+	}
+
+	Infof(KeyDCP, "DCP checkpoint collection (dry_run=%v) found %d stale checkpoint groups, deleted %d checkpoints (%d newly seen groups recorded, max age %v)",
+		dryRun, len(result.StaleGroups), result.Deleted, len(unseenGroups), maxAge)
+	return result, nil
+}
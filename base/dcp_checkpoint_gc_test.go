@@ -0,0 +1,113 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDCPCheckpointGroup(t *testing.T) {
+	assert.Equal(t, "_sync:dcp_ck:12", DCPCheckpointKey(DefaultDCPCheckpointGroup, 12))
+	assert.Equal(t, "_sync:dcp_ck:g1:12", DCPCheckpointKey("g1", 12))
+	assert.Equal(t, DefaultDCPCheckpointGroup, dcpCheckpointGroup(DCPCheckpointKey(DefaultDCPCheckpointGroup, 12)))
+	assert.Equal(t, "g1", dcpCheckpointGroup(DCPCheckpointKey("g1", 12)))
+	assert.Equal(t, "g1:a", dcpCheckpointGroup(DCPCheckpointKey("g1:a", 12)))
+}
+
+func TestCollectDCPCheckpoints(t *testing.T) {
+	bucket := GetTestBucket(t)
+	defer bucket.Close()
+
+	// Persists checkpoints as a feed started with the given active group does
+	var keys []string
+	writeCheckpoints := func(group string) {
+		require.NoError(t, SetActiveDCPCheckpointGroup(bucket, group))
+		dcpCommon := NewDCPCommon(nil, bucket, 4, true, nil, DCPImportFeedID)
+		require.Equal(t, group, dcpCommon.checkpointGroup)
+		// Feeds that don't persist checkpoints, and feeds other than import, don't use the active group
+		assert.Equal(t, DefaultDCPCheckpointGroup, NewDCPCommon(nil, bucket, 4, false, nil, DCPImportFeedID).checkpointGroup)
+		assert.Equal(t, DefaultDCPCheckpointGroup, NewDCPCommon(nil, bucket, 4, true, nil, DCPCachingFeedID).checkpointGroup)
+		for vbNo := uint16(0); vbNo < 4; vbNo++ {
+			require.NoError(t, dcpCommon.persistCheckpoint(vbNo, []byte(`{}`)))
+			keys = append(keys, DCPCheckpointKey(group, vbNo))
+		}
+	}
+
+	// Iterates over the written checkpoint keys that still exist, as a query-backed iterator would
+	iterate := func(prefix string, callback func(key string) error) error {
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if _, _, err := bucket.GetRaw(key); err != nil {
+				continue
+			}
+			if err := callback(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	writeCheckpoints("old")
+	writeCheckpoints("new")
+
+	// The old group was only just active
+	result, err := CollectDCPCheckpoints(bucket, iterate, time.Hour, false)
+	require.NoError(t, err)
+	assert.Equal(t, "new", result.ActiveGroup)
+	assert.Equal(t, 0, result.Deleted)
+
+	// Dry run lists the old group's checkpoints only
+	result, err = CollectDCPCheckpoints(bucket, iterate, 0, true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"old": 4}, result.StaleGroups)
+	assert.Len(t, result.Keys, 4)
+	for _, key := range result.Keys {
+		assert.Equal(t, "old", dcpCheckpointGroup(key))
+	}
+	assert.Equal(t, 0, result.Deleted)
+
+	result, err = CollectDCPCheckpoints(bucket, iterate, 0, false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Deleted)
+	assert.Empty(t, result.Keys)
+	for vbNo := uint16(0); vbNo < 4; vbNo++ {
+		_, _, err := bucket.GetRaw(DCPCheckpointKey("old", vbNo))
+		assert.True(t, IsDocNotFoundError(err))
+		_, _, err = bucket.GetRaw(DCPCheckpointKey("new", vbNo))
+		assert.NoError(t, err)
+	}
+
+	groups, err := getDCPCheckpointGroups(bucket)
+	require.NoError(t, err)
+	assert.Equal(t, "new", groups.Active)
+	assert.NotContains(t, groups.LastActive, "old")
+
+	// Checkpoints for a group that was never recorded are only collected once they've aged out from first being seen
+	for vbNo := uint16(0); vbNo < 4; vbNo++ {
+		key := DCPCheckpointKey("orphan", vbNo)
+		require.NoError(t, bucket.SetRaw(key, 0, []byte(`{}`)))
+		keys = append(keys, key)
+	}
+	result, err = CollectDCPCheckpoints(bucket, iterate, time.Hour, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Deleted)
+	result, err = CollectDCPCheckpoints(bucket, iterate, 0, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"orphan": 4}, result.StaleGroups)
+	assert.Equal(t, 4, result.Deleted)
+}
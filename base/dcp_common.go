@@ -45,6 +45,10 @@ const kBackfillPersistInterval = 10 * time.Second
 const DCPCachingFeedID = "SG"
 const DCPImportFeedID = "SGI"
 
+// FeedOpRollback is a synthetic feed opcode, not sent by the server, used to notify a DCP feed's callback that the
+// stream for event.VbNo has been rolled back.  Mutations previously received for the vbucket may no longer exist.
+const FeedOpRollback = sgbucket.FeedOpcode(0xff)
+
 type SimpleFeed struct {
 	eventFeed  chan sgbucket.FeedEvent
 	terminator chan bool
@@ -77,6 +81,7 @@ type DCPCommon struct {
 	callback               sgbucket.FeedEventCallbackFunc // Function to callback for mutation processing
 	backfill               *backfillStatus                // Backfill state and stats
 	feedID                 string                         // Unique feed ID, used for logging
+	checkpointGroup        string                         // Checkpoint group that checkpoints are loaded from and persisted under
 	loggingCtx             context.Context                // Logging context, prefixes feedID
 }
 
@@ -103,6 +108,16 @@ func NewDCPCommon(callback sgbucket.FeedEventCallbackFunc, bucket Bucket, maxVbN
 		LogContext{CorrelationID: dcpContextID},
 	)
 
+	// Only the import feed's checkpoints are grouped - other feeds, and feeds that don't persist checkpoints, don't
+	// read the active group
+	if persistCheckpoints && feedID == DCPImportFeedID {
+		group, err := ActiveDCPCheckpointGroup(bucket)
+		if err != nil {
+			WarnfCtx(c.loggingCtx, "Unable to retrieve active DCP checkpoint group - will use the default group.  Error: %v", err)
+		}
+		c.checkpointGroup = group
+	}
+
 	return c
 }
 
@@ -175,6 +190,7 @@ func (c *DCPCommon) rollback(vbucketId uint16, rollbackSeq uint64) error {
 	c.dbStatsExpvars.Add("dcp_rollback_count", 1)
 	c.updateSeq(vbucketId, 0, false)
 	c.setMetaData(vbucketId, nil)
+	c.notifyRollback(vbucketId)
 
 	return nil
 }
@@ -185,9 +201,18 @@ func (c *DCPCommon) rollbackEx(vbucketId uint16, vbucketUUID uint64, rollbackSeq
 	c.dbStatsExpvars.Add("dcp_rollback_count", 1)
 	c.updateSeq(vbucketId, rollbackSeq, false)
 	c.setMetaData(vbucketId, rollbackMetaData)
+	c.notifyRollback(vbucketId)
 	return nil
 }
 
+// notifyRollback sends a FeedOpRollback event for the vbucket to the feed's callback.
+func (c *DCPCommon) notifyRollback(vbucketId uint16) {
+	if c.callback == nil {
+		return
+	}
+	c.callback(makeFeedEvent(nil, nil, 0, 0, 0, vbucketId, FeedOpRollback))
+}
+
 func (c *DCPCommon) incrementCheckpointCount(vbucketId uint16) {
 	c.m.Lock()
 	defer c.m.Unlock()
@@ -202,7 +227,7 @@ func (c *DCPCommon) incrementCheckpointCount(vbucketId uint16) {
 //   - The ongoing performance overhead of persisting last sequence outweighs the minor performance benefit of not reprocessing a few
 //    sequences in a checkpoint on startup
 func (c *DCPCommon) loadCheckpoint(vbNo uint16) (vbMetadata []byte, snapshotStartSeq uint64, snapshotEndSeq uint64, err error) {
-	rawValue, _, err := c.bucket.GetRaw(fmt.Sprintf("%s%d", DCPCheckpointPrefix, vbNo))
+	rawValue, _, err := c.bucket.GetRaw(DCPCheckpointKey(c.checkpointGroup, vbNo))
 	if err != nil {
 		// On a key not found error, metadata hasn't been persisted for this vbucket
 		if IsKeyNotFoundError(c.bucket, err) {
@@ -282,7 +307,7 @@ func (c *DCPCommon) initMetadata(maxVbNo uint16) {
 //         - Is a relatively infrequent operation
 func (c *DCPCommon) persistCheckpoint(vbNo uint16, value []byte) error {
 	TracefCtx(c.loggingCtx, KeyDCP, "Persisting checkpoint for vbno %d", vbNo)
-	return c.bucket.SetRaw(fmt.Sprintf("%s%d", DCPCheckpointPrefix, vbNo), 0, value)
+	return c.bucket.SetRaw(DCPCheckpointKey(c.checkpointGroup, vbNo), 0, value)
 }
 
 // This updates the value stored in r.seqs with the given seq number for the given partition
@@ -10,9 +10,11 @@ package base
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
+	gocbv2 "github.com/couchbase/gocb"
 	"github.com/couchbase/gomemcached"
 	sgbucket "github.com/couchbase/sg-bucket"
 	"github.com/couchbaselabs/walrus"
@@ -41,9 +43,14 @@ var (
 	ErrNotFound              = &sgError{"Not Found"}
 	ErrUpdateCancel          = &sgError{"Cancel update"}
 	ErrImportCancelledPurged = &sgError{"Import Cancelled Due to Purge"}
+	ErrImportDocTooLarge     = &sgError{"Document body exceeds import size limit"}
 	ErrChannelFeed           = &sgError{"Error while building channel feed"}
 	ErrXattrNotFound         = &sgError{"Xattr Not Found"}
 
+	// ErrDatabaseClosed is returned by operations on a database, or its cache, that raced with the database being
+	// closed.  Mapped to a 503 response by ErrorAsHTTPStatus.
+	ErrDatabaseClosed = &sgError{"Database closed"}
+
 	// ErrPartialViewErrors is returned if the view call contains any partial errors.
 	// This is more of a warning, and inspecting ViewResult.Errors is required for detail.
 	ErrPartialViewErrors = &sgError{"Partial errors in view"}
@@ -73,6 +80,16 @@ func HTTPErrorf(status int, format string, args ...interface{}) *HTTPError {
 	return &HTTPError{status, fmt.Sprintf(format, args...)}
 }
 
+// InvalidXattrKeyError is returned by ValidateXattrKey for xattr keys the server doesn't support.
+type InvalidXattrKeyError struct {
+	Key    string
+	Reason string
+}
+
+func (err *InvalidXattrKeyError) Error() string {
+	return fmt.Sprintf("invalid xattr key %q: %s", err.Key, err.Reason)
+}
+
 // Attempts to map an error to an HTTP status code and message.
 // Defaults to 500 if it doesn't recognize the error. Returns 200 for a nil error.
 func ErrorAsHTTPStatus(err error) (int, string) {
@@ -98,10 +115,24 @@ func ErrorAsHTTPStatus(err error) (int, string) {
 		return http.StatusServiceUnavailable, "Database server is over capacity (gocb.ErrTmpFail)"
 	case gocb.ErrTooBig:
 		return http.StatusRequestEntityTooLarge, "Document too large!"
-	case ErrViewTimeoutError:
+	case ErrViewTimeoutError, ErrDatabaseClosed:
 		return http.StatusServiceUnavailable, unwrappedErr.Error()
 	}
 
+	// GoCB v2 errors wrap the underlying error, so can't be compared directly
+	switch {
+	case errors.Is(unwrappedErr, gocbv2.ErrDocumentNotFound):
+		return http.StatusNotFound, "missing"
+	case errors.Is(unwrappedErr, gocbv2.ErrDocumentExists), errors.Is(unwrappedErr, gocbv2.ErrCasMismatch):
+		return http.StatusConflict, "Conflict"
+	case errors.Is(unwrappedErr, gocbv2.ErrTimeout):
+		return http.StatusServiceUnavailable, "Database timeout error (gocb.ErrTimeout)"
+	case errors.Is(unwrappedErr, gocbv2.ErrOverload), errors.Is(unwrappedErr, gocbv2.ErrTemporaryFailure):
+		return http.StatusServiceUnavailable, "Database server is over capacity (gocb.ErrTemporaryFailure)"
+	case errors.Is(unwrappedErr, gocbv2.ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge, "Document too large!"
+	}
+
 	switch unwrappedErr := unwrappedErr.(type) {
 	case *HTTPError:
 		return unwrappedErr.Status, unwrappedErr.Message
@@ -179,6 +210,10 @@ func IsDocNotFoundError(err error) bool {
 		return true
 	}
 
+	if errors.Is(unwrappedErr, gocbv2.ErrDocumentNotFound) {
+		return true
+	}
+
 	switch unwrappedErr := unwrappedErr.(type) {
 	case *gomemcached.MCResponse:
 		return unwrappedErr.Status == gomemcached.KEY_ENOENT || unwrappedErr.Status == gomemcached.NOT_STORED
@@ -20,6 +20,7 @@ import (
 	"github.com/couchbase/gomemcached"
 	sgbucket "github.com/couchbase/sg-bucket"
 	"github.com/couchbaselabs/walrus"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/couchbase/gocb.v1"
 )
@@ -71,6 +72,10 @@ func TestErrorAsHTTPStatus(t *testing.T) {
 	assert.Equal(t, http.StatusServiceUnavailable, code)
 	assert.Equal(t, ErrViewTimeoutError.Error(), text)
 
+	code, text = ErrorAsHTTPStatus(pkgerrors.Wrap(ErrDatabaseClosed, "Unable to get changes"))
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "Database closed", text)
+
 	fakeHTTPError := &HTTPError{Status: http.StatusForbidden, Message: http.StatusText(http.StatusForbidden)}
 	code, text = ErrorAsHTTPStatus(fakeHTTPError)
 	assert.Equal(t, http.StatusForbidden, code)
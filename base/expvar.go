@@ -350,6 +350,13 @@ func (v *IntRollingMeanVar) String() string {
 	return strconv.FormatInt(int64(v.mean), 10)
 }
 
+// Value returns the mean of the latest values, truncated to an integer.
+func (v *IntRollingMeanVar) Value() int64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return int64(v.mean)
+}
+
 // Adds value
 func (v *IntRollingMeanVar) AddValue(value int64) {
 	v.mu.Lock()
@@ -11,6 +11,7 @@ licenses/APL2.txt.
 package base
 
 import (
+	"context"
 	"errors"
 	"expvar"
 	"fmt"
@@ -224,15 +225,19 @@ func (b *LeakyBucket) WriteWithXattr(k string, xattrKey string, exp uint32, cas
 }
 
 func (b *LeakyBucket) WriteUpdateWithXattr(k string, xattr string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
+	return b.WriteUpdateWithXattrCtx(context.Background(), k, xattr, userXattrKey, exp, previous, callback)
+}
+
+func (b *LeakyBucket) WriteUpdateWithXattrCtx(ctx context.Context, k string, xattr string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
 	if b.config.UpdateCallback != nil {
 		wrapperCallback := func(current []byte, xattr []byte, userXattr []byte, cas uint64) (updated []byte, updatedXattr []byte, deletedDoc bool, expiry *uint32, err error) {
 			updated, updatedXattr, deletedDoc, expiry, err = callback(current, xattr, userXattr, cas)
 			b.config.UpdateCallback(k)
 			return updated, updatedXattr, deletedDoc, expiry, err
 		}
-		return b.bucket.WriteUpdateWithXattr(k, xattr, userXattrKey, exp, previous, wrapperCallback)
+		return WriteUpdateWithXattrCtx(ctx, b.bucket, k, xattr, userXattrKey, exp, previous, wrapperCallback)
 	}
-	return b.bucket.WriteUpdateWithXattr(k, xattr, userXattrKey, exp, previous, callback)
+	return WriteUpdateWithXattrCtx(ctx, b.bucket, k, xattr, userXattrKey, exp, previous, callback)
 }
 
 func (b *LeakyBucket) SubdocInsert(docID string, fieldPath string, cas uint64, value interface{}) error {
@@ -463,12 +468,16 @@ func (b *LeakyBucket) Keyspace() string {
 }
 
 func (b *LeakyBucket) Query(statement string, params map[string]interface{}, consistency ConsistencyMode, adhoc bool) (results sgbucket.QueryResultIterator, err error) {
+	return b.QueryWithClientContextID(statement, params, consistency, adhoc, "")
+}
+
+func (b *LeakyBucket) QueryWithClientContextID(statement string, params map[string]interface{}, consistency ConsistencyMode, adhoc bool, clientContextID string) (results sgbucket.QueryResultIterator, err error) {
 	n1qlStore, ok := AsN1QLStore(b.bucket)
 	if !ok {
 		return nil, errors.New("Not N1QL Store")
 	}
 
-	results, err = n1qlStore.Query(statement, params, consistency, adhoc)
+	results, err = n1qlStore.QueryWithClientContextID(statement, params, consistency, adhoc, clientContextID)
 	if b.config.PostN1QLQueryCallback != nil {
 		b.config.PostN1QLQueryCallback()
 	}
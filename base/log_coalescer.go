@@ -0,0 +1,83 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultLogCoalesceInterval is the default interval between summaries logged by a LogCoalescer.
+const DefaultLogCoalesceInterval = time.Minute
+
+// logCoalesceMaxSamples is the number of keys included in each summary logged by a LogCoalescer.
+const logCoalesceMaxSamples = 5
+
+// LogCoalescer replaces a log line per occurrence of a frequently repeated event with a single summary line per
+// interval, reporting the number of occurrences and a sample of the (redacted) keys involved.
+type LogCoalescer struct {
+	logLevel LogLevel      // Level of summary lines
+	logKey   LogKey        // Log key of summary lines
+	message  string        // Describes the event being summarized
+	interval time.Duration // Interval between summaries
+	total    *SgwIntStat   // Optional running total of occurrences
+	lock     sync.Mutex    // Coordinates access to the fields below
+	count    int           // Number of occurrences since the last summary
+	samples  []string      // Sample keys since the last summary
+	timer    *time.Timer   // Fires the next summary
+}
+
+// NewLogCoalescer returns a LogCoalescer logging a summary of occurrences of the event described by message at most
+// once per interval.  When total is non-nil, it's incremented for every occurrence.
+func NewLogCoalescer(logLevel LogLevel, logKey LogKey, message string, interval time.Duration, total *SgwIntStat) *LogCoalescer {
+	return &LogCoalescer{
+		logLevel: logLevel,
+		logKey:   logKey,
+		message:  message,
+		interval: interval,
+		total:    total,
+	}
+}
+
+// Add records an occurrence of the event for key.  The first occurrence since the last summary schedules the next
+// summary for the end of the interval.
+func (lc *LogCoalescer) Add(key string) {
+	if lc.total != nil {
+		lc.total.Add(1)
+	}
+
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+	lc.count++
+	if len(lc.samples) < logCoalesceMaxSamples {
+		lc.samples = append(lc.samples, key)
+	}
+	if lc.count == 1 {
+		lc.timer = time.AfterFunc(lc.interval, lc.Flush)
+	}
+}
+
+// Flush logs a summary of the occurrences since the last summary, if there have been any.
+func (lc *LogCoalescer) Flush() {
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+
+	if lc.timer != nil {
+		lc.timer.Stop()
+		lc.timer = nil
+	}
+	if lc.count == 0 {
+		return
+	}
+	logTo(context.TODO(), lc.logLevel, lc.logKey, "%s: %d occurrences since last report, including %v", lc.message, lc.count, UD(lc.samples))
+	lc.count, lc.samples = 0, nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogCoalescer(t *testing.T) {
+	if GlobalTestLoggingSet.IsTrue() {
+		t.Skip("Test does not work when a global test log level is set")
+	}
+	defer SetUpTestLogging(LevelInfo, KeyCache)()
+
+	total := &SgwIntStat{}
+	coalescer := NewLogCoalescer(LevelInfo, KeyCache, "Coalesced event", time.Hour, total)
+
+	output := CaptureConsoleLogOutput(func() {
+		for i := 0; i < 1000; i++ {
+			coalescer.Add(fmt.Sprintf("doc%d", i))
+		}
+		coalescer.Flush()
+		// Nothing further to report
+		coalescer.Flush()
+	})
+	assert.Equal(t, 1, strings.Count(output, "Coalesced event"))
+	assert.Contains(t, output, "1000 occurrences")
+	assert.Contains(t, output, "doc0")
+	assert.NotContains(t, output, fmt.Sprintf("doc%d", logCoalesceMaxSamples))
+	assert.Equal(t, int64(1000), total.Value())
+
+	// Summary is logged at the end of the interval without an explicit flush
+	coalescer = NewLogCoalescer(LevelInfo, KeyCache, "Timed event", 10*time.Millisecond, nil)
+	output = CaptureConsoleLogOutput(func() {
+		coalescer.Add("doc1")
+		coalescer.Add("doc2")
+		err, _ := RetryLoop("wait for summary", func() (bool, error, interface{}) {
+			coalescer.lock.Lock()
+			defer coalescer.lock.Unlock()
+			return coalescer.count > 0, nil, nil
+		}, CreateSleeperFunc(100, 10))
+		require.NoError(t, err)
+	})
+	assert.Equal(t, 1, strings.Count(output, "Timed event"))
+	assert.Contains(t, output, "2 occurrences")
+}
@@ -111,6 +111,11 @@ func (b *LoggingBucket) WriteUpdateWithXattr(k string, xattr string, userXattrKe
 	return b.bucket.WriteUpdateWithXattr(k, xattr, userXattrKey, exp, previous, callback)
 }
 
+func (b *LoggingBucket) WriteUpdateWithXattrCtx(ctx context.Context, k string, xattr string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
+	defer b.log(time.Now(), k, xattr, exp)
+	return WriteUpdateWithXattrCtx(ctx, b.bucket, k, xattr, userXattrKey, exp, previous, callback)
+}
+
 func (b *LoggingBucket) SubdocInsert(docID string, fieldPath string, cas uint64, value interface{}) error {
 	defer b.log(time.Now(), docID, fieldPath)
 	return b.bucket.SubdocInsert(docID, fieldPath, cas, value)
@@ -25,18 +25,6 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-// asserts that the logs produced by function f contain string s.
-func assertLogContains(t *testing.T, s string, f func()) {
-	b := bytes.Buffer{}
-
-	// temporarily override logger output for the given function call
-	consoleLogger.logger.SetOutput(&b)
-	f()
-	consoleLogger.logger.SetOutput(os.Stderr)
-
-	assert.Contains(t, b.String(), s)
-}
-
 func TestRedactedLogFuncs(t *testing.T) {
 	if GlobalTestLoggingSet.IsTrue() {
 		t.Skip("Test does not work when a global test log level is set")
@@ -47,14 +35,14 @@ func TestRedactedLogFuncs(t *testing.T) {
 	defer func() { RedactUserData = false }()
 
 	RedactUserData = false
-	assertLogContains(t, "Username: alice", func() { Infof(KeyAll, "Username: %s", username) })
+	AssertLogContains(t, "Username: alice", func() { Infof(KeyAll, "Username: %s", username) })
 	RedactUserData = true
-	assertLogContains(t, "Username: <ud>alice</ud>", func() { Infof(KeyAll, "Username: %s", username) })
+	AssertLogContains(t, "Username: <ud>alice</ud>", func() { Infof(KeyAll, "Username: %s", username) })
 
 	RedactUserData = false
-	assertLogContains(t, "Username: alice", func() { Warnf("Username: %s", username) })
+	AssertLogContains(t, "Username: alice", func() { Warnf("Username: %s", username) })
 	RedactUserData = true
-	assertLogContains(t, "Username: <ud>alice</ud>", func() { Warnf("Username: %s", username) })
+	AssertLogContains(t, "Username: <ud>alice</ud>", func() { Warnf("Username: %s", username) })
 }
 
 func Benchmark_LoggingPerformance(b *testing.B) {
@@ -63,6 +63,8 @@ func (lc *LRUCache) Get(key string) (result interface{}, found bool) {
 // position in the LRU cache but ignores the new value, since the entries in the cache
 // are treated as immutable.
 func (lc *LRUCache) Put(key string, value interface{}) {
+	lc.lruLock.Lock()
+	defer lc.lruLock.Unlock()
 
 	// If already present, move to front
 	if elem := lc.cache[key]; elem != nil {
@@ -90,5 +92,7 @@ func (lc *LRUCache) purgeOldest_() {
 }
 
 func (lc *LRUCache) Count() int {
+	lc.lruLock.Lock()
+	defer lc.lruLock.Unlock()
 	return len(lc.cache)
 }
@@ -55,6 +55,10 @@ const (
 	// Prints detailed debug logs from the test pooling framework.
 	tbpEnvVerbose = "SG_TEST_BUCKET_POOL_DEBUG"
 
+	// Shares a single pooled bucket between tests, giving each test a unique key namespace instead of a flushed bucket.
+	// Tests requiring raw DCP or design doc behaviour can still use GetExclusiveTestBucket.
+	tbpEnvNamespaces = "SG_TEST_BUCKET_POOL_NAMESPACES"
+
 	// wait this long when requesting a test bucket from the pool before giving up and failing the test.
 	waitForReadyBucketTimeout = time.Minute
 )
@@ -85,6 +89,12 @@ type TestBucketPool struct {
 	// verbose flag controls debug test pool logging.
 	verbose AtomicBool
 
+	// namespaceMode can be set to true to hand out namespaced views of a single shared bucket, instead of flushed buckets.
+	namespaceMode bool
+	// sharedBucket is the pooled bucket used by all namespaced test buckets, taken from the pool on first use.
+	sharedBucket     *CouchbaseBucketGoCB
+	sharedBucketLock sync.Mutex
+
 	// keep track of tests that don't close their buckets, map of test names to bucket names
 	unclosedBuckets     map[string]map[string]struct{}
 	unclosedBucketsLock sync.Mutex
@@ -116,6 +126,7 @@ func NewTestBucketPool(bucketReadierFunc TBPBucketReadierFunc, bucketInitFunc TB
 	ctx, ctxCancelFunc := context.WithCancel(context.Background())
 
 	preserveBuckets, _ := strconv.ParseBool(os.Getenv(tbpEnvPreserve))
+	namespaceMode, _ := strconv.ParseBool(os.Getenv(tbpEnvNamespaces))
 
 	tbp := TestBucketPool{
 		integrationMode:        true,
@@ -127,6 +138,7 @@ func NewTestBucketPool(bucketReadierFunc TBPBucketReadierFunc, bucketInitFunc TB
 		ctxCancelFunc:          ctxCancelFunc,
 		defaultBucketSpec:      tbpDefaultBucketSpec,
 		preserveBuckets:        preserveBuckets,
+		namespaceMode:          namespaceMode,
 		bucketInitFunc:         bucketInitFunc,
 		unclosedBuckets:        make(map[string]map[string]struct{}),
 	}
@@ -241,7 +253,17 @@ func (tbp *TestBucketPool) GetWalrusTestBucket(t testing.TB, url string) (b Buck
 // GetTestBucketAndSpec returns a bucket to be used during a test.
 // The returned teardownFn MUST be called once the test is done,
 // which closes the bucket, readies it for a new test, and releases back into the pool.
+// When the pool is in namespace mode, the returned bucket is a namespaced view of a shared bucket.
 func (tbp *TestBucketPool) GetTestBucketAndSpec(t testing.TB) (b Bucket, s BucketSpec, teardownFn func()) {
+	if tbp.integrationMode && tbp.namespaceMode {
+		return tbp.getNamespacedTestBucket(t)
+	}
+	return tbp.GetExclusiveTestBucketAndSpec(t)
+}
+
+// GetExclusiveTestBucketAndSpec returns a flushed bucket to be used exclusively by a test, regardless of namespace mode.
+// The returned teardownFn MUST be called once the test is done.
+func (tbp *TestBucketPool) GetExclusiveTestBucketAndSpec(t testing.TB) (b Bucket, s BucketSpec, teardownFn func()) {
 
 	ctx := testCtx(t)
 
@@ -296,6 +318,79 @@ func (tbp *TestBucketPool) GetTestBucketAndSpec(t testing.TB) (b Bucket, s Bucke
 	}
 }
 
+// getNamespacedTestBucket returns a bucket with a unique key namespace on the pool's shared bucket.  Teardown removes
+// the namespace's documents asynchronously, instead of flushing the bucket.
+func (tbp *TestBucketPool) getNamespacedTestBucket(t testing.TB) (b Bucket, s BucketSpec, teardownFn func()) {
+
+	ctx := testCtx(t)
+
+	sharedBucket := tbp.getSharedBucket(t)
+	namespaceBucket := NewNamespaceBucket(sharedBucket, tbpNamespacePrefix())
+	ctx = bucketCtx(ctx, sharedBucket)
+	tbp.Logf(ctx, "Using namespace %q on shared test bucket", namespaceBucket.Namespace())
+	tbp.markBucketOpened(t, namespaceBucket)
+
+	atomic.AddInt32(&tbp.stats.NumBucketsOpened, 1)
+	atomic.AddInt32(&tbp.stats.NumNamespacesOpened, 1)
+	bucketOpenStart := time.Now()
+	bucketClosed := &AtomicBool{}
+	return namespaceBucket, getBucketSpec(tbpBucketName(sharedBucket.GetName())), func() {
+		if !bucketClosed.CompareAndSwap(false, true) {
+			tbp.Logf(ctx, "Bucket teardown was already called. Ignoring.")
+			return
+		}
+
+		tbp.Logf(ctx, "Teardown called - releasing namespace %q", namespaceBucket.Namespace())
+		atomic.AddInt32(&tbp.stats.NumBucketsClosed, 1)
+		atomic.AddInt64(&tbp.stats.TotalInuseBucketNano, time.Since(bucketOpenStart).Nanoseconds())
+		tbp.markBucketClosed(t, namespaceBucket)
+
+		if tbp.preserveBuckets && t.Failed() {
+			tbp.Logf(ctx, "Test using namespace %q failed. Preserving documents for later inspection", namespaceBucket.Namespace())
+			return
+		}
+
+		tbp.bucketReadierWaitGroup.Add(1)
+		go func() {
+			defer tbp.bucketReadierWaitGroup.Done()
+			start := time.Now()
+			deleted, err := namespaceBucket.DeleteNamespace()
+			if err != nil {
+				tbp.Logf(ctx, "Couldn't remove all documents in namespace %q: %v", namespaceBucket.Namespace(), err)
+			}
+			atomic.AddInt64(&tbp.stats.TotalBucketReadierDurationNano, time.Since(start).Nanoseconds())
+			atomic.AddInt32(&tbp.stats.TotalBucketReadierCount, 1)
+			tbp.Logf(ctx, "Removed %d documents in namespace %q", deleted, namespaceBucket.Namespace())
+		}()
+	}
+}
+
+// getSharedBucket returns the bucket shared between namespaced test buckets, taking it from the pool on first use.
+func (tbp *TestBucketPool) getSharedBucket(t testing.TB) *CouchbaseBucketGoCB {
+	tbp.sharedBucketLock.Lock()
+	defer tbp.sharedBucketLock.Unlock()
+
+	if tbp.sharedBucket != nil {
+		return tbp.sharedBucket
+	}
+
+	ctx := testCtx(t)
+	tbp.Logf(ctx, "Attempting to get shared test bucket from pool")
+	select {
+	case tbp.sharedBucket = <-tbp.readyBucketPool:
+	case <-time.After(waitForReadyBucketTimeout):
+		tbp.Logf(ctx, "Timed out after %s waiting for a bucket to become available.", waitForReadyBucketTimeout)
+		t.Fatalf("Timed out after %s waiting for a bucket to become available.", waitForReadyBucketTimeout)
+	}
+	tbp.Logf(bucketCtx(ctx, tbp.sharedBucket), "Got shared test bucket from pool")
+	return tbp.sharedBucket
+}
+
+// tbpNamespacePrefix returns a unique key namespace for a namespaced test bucket.
+func tbpNamespacePrefix() string {
+	return "ns" + GenerateRandomID()[:8] + ":"
+}
+
 func (tbp *TestBucketPool) addBucketToReadierQueue(ctx context.Context, name tbpBucketName) {
 	tbp.bucketReadierWaitGroup.Add(1)
 	tbp.Logf(ctx, "Putting bucket onto bucketReadierQueue")
@@ -315,6 +410,10 @@ func (tbp *TestBucketPool) Close() {
 		tbp.ctxCancelFunc()
 	}
 
+	if tbp.sharedBucket != nil {
+		tbp.sharedBucket.Close()
+	}
+
 	if tbp.cluster != nil {
 		if err := tbp.cluster.Close(); err != nil {
 			tbp.Logf(context.Background(), "Couldn't close cluster connection: %v", err)
@@ -362,6 +461,9 @@ func (tbp *TestBucketPool) printStats() {
 		tbp.Logf(ctx, "Total bucket readier time: %s for %d buckets", totalBucketReadierTime, totalBucketReadierCount)
 	}
 	tbp.Logf(ctx, "Total buckets opened/closed: %d/%d", numBucketsOpened, atomic.LoadInt32(&tbp.stats.NumBucketsClosed))
+	if numNamespacesOpened := atomic.LoadInt32(&tbp.stats.NumNamespacesOpened); numNamespacesOpened > 0 {
+		tbp.Logf(ctx, "Total namespaced buckets opened on shared bucket: %d", numNamespacesOpened)
+	}
 	if numBucketsOpened > 0 {
 		tbp.Logf(ctx, "Total time waiting for ready bucket: %s over %d buckets (avg: %s)", totalBucketWaitTime, numBucketsOpened, totalBucketWaitTime/numBucketsOpened)
 		tbp.Logf(ctx, "Total time tests using buckets: %s (avg: %s)", totalBucketUseTime, totalBucketUseTime/numBucketsOpened)
@@ -667,6 +769,7 @@ type bucketPoolStats struct {
 	NumBucketsClosed               int32
 	TotalWaitingForReadyBucketNano int64
 	TotalInuseBucketNano           int64
+	NumNamespacesOpened            int32
 }
 
 // tbpBucketName use a strongly typed bucket name.
@@ -0,0 +1,106 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MetadataPurgeBatchSize is the number of keys collected before issuing deletes during MetadataPurge.
+var MetadataPurgeBatchSize = 100
+
+// MetadataKeyIterator invokes callback for each metadata document key with the given prefix.  Iteration stops
+// when callback returns an error.
+type MetadataKeyIterator func(prefix string, callback func(key string) error) error
+
+// MetadataPurgeResult reports the number of metadata documents matched and purged, by key prefix.
+type MetadataPurgeResult struct {
+	DryRun  bool           `json:"dry_run"`
+	Matched map[string]int `json:"matched"`
+	Purged  map[string]int `json:"purged"`
+}
+
+// MetadataPurge removes the metadata documents with the given key prefixes for which shouldPurge returns true (all
+// documents, when shouldPurge is nil).  In dry-run mode, matching documents are only counted.  The sequence
+// document and user/role documents are never purged.
+func MetadataPurge(bucket Bucket, iterate MetadataKeyIterator, prefixes []string, shouldPurge func(key string) bool, dryRun bool) (*MetadataPurgeResult, error) {
+
+	for _, prefix := range prefixes {
+		if err := validateMetadataPurgePrefix(prefix); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &MetadataPurgeResult{
+		DryRun:  dryRun,
+		Matched: make(map[string]int, len(prefixes)),
+		Purged:  make(map[string]int, len(prefixes)),
+	}
+
+	for _, prefix := range prefixes {
+		batch := make([]string, 0, MetadataPurgeBatchSize)
+		deleteBatch := func() {
+			for _, key := range batch {
+				if err := bucket.Delete(key); err != nil {
+					Infof(KeyAll, "Unable to purge metadata document %q: %v", MD(key), err)
+					continue
+				}
+				result.Purged[prefix]++
+			}
+			batch = batch[:0]
+		}
+
+		err := iterate(prefix, func(key string) error {
+			if !strings.HasPrefix(key, prefix) || isProtectedMetadataKey(key) {
+				return nil
+			}
+			if shouldPurge != nil && !shouldPurge(key) {
+				return nil
+			}
+			result.Matched[prefix]++
+			if dryRun {
+				return nil
+			}
+			batch = append(batch, key)
+			if len(batch) >= MetadataPurgeBatchSize {
+				deleteBatch()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		deleteBatch()
+
+		Infof(KeyAll, "Metadata purge (dry_run=%v) for prefix %q matched %d documents, purged %d", dryRun, MD(prefix), result.Matched[prefix], result.Purged[prefix])
+	}
+
+	return result, nil
+}
+
+// validateMetadataPurgePrefix rejects prefixes outside of the Sync Gateway metadata key space, and prefixes that would
+// match the sequence document or principal documents.
+func validateMetadataPurgePrefix(prefix string) error {
+	if !strings.HasPrefix(prefix, SyncPrefix) || prefix == SyncPrefix {
+		return fmt.Errorf("Invalid metadata purge prefix %q - must be a Sync Gateway metadata prefix", prefix)
+	}
+	for _, protected := range []string{SyncSeqKey, UserPrefix, RolePrefix} {
+		if strings.HasPrefix(protected, prefix) {
+			return fmt.Errorf("Invalid metadata purge prefix %q - matches protected documents with prefix %q", prefix, protected)
+		}
+	}
+	return nil
+}
+
+func isProtectedMetadataKey(key string) bool {
+	return key == SyncSeqKey || strings.HasPrefix(key, UserPrefix) || strings.HasPrefix(key, RolePrefix)
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataPurge(t *testing.T) {
+	bucket := GetTestBucket(t)
+	defer bucket.Close()
+
+	keys := []string{
+		RevBodyPrefix + "1",
+		RevBodyPrefix + "2",
+		RevBodyPrefix + "old",
+		DCPCheckpointPrefix + "0",
+		UserPrefix + "alice",
+		SyncSeqKey,
+	}
+	for _, key := range keys {
+		require.NoError(t, bucket.Set(key, 0, map[string]interface{}{"key": key}))
+	}
+
+	// Iterates over the fixed key set, as a query-backed iterator would
+	iterate := func(prefix string, callback func(key string) error) error {
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				if err := callback(key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	// Prefixes matching protected documents are rejected
+	for _, prefix := range []string{SyncPrefix, "_sync:se", UserPrefix, "_sync:r", "other:"} {
+		_, err := MetadataPurge(bucket, iterate, []string{prefix}, nil, true)
+		assert.Error(t, err, "Expected error for prefix %q", prefix)
+	}
+
+	// Dry run only counts
+	result, err := MetadataPurge(bucket, iterate, []string{RevBodyPrefix, DCPCheckpointPrefix}, nil, true)
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Matched[RevBodyPrefix])
+	assert.Equal(t, 1, result.Matched[DCPCheckpointPrefix])
+	assert.Equal(t, 0, result.Purged[RevBodyPrefix])
+	for _, key := range keys {
+		_, _, err := bucket.GetRaw(key)
+		assert.NoError(t, err, "Expected %q to exist after dry run", key)
+	}
+
+	// Apply with predicate only removes matching keys
+	shouldPurge := func(key string) bool {
+		return strings.HasSuffix(key, "old")
+	}
+	result, err = MetadataPurge(bucket, iterate, []string{RevBodyPrefix}, shouldPurge, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Matched[RevBodyPrefix])
+	assert.Equal(t, 1, result.Purged[RevBodyPrefix])
+
+	_, _, err = bucket.GetRaw(RevBodyPrefix + "old")
+	assert.True(t, IsDocNotFoundError(err))
+	_, _, err = bucket.GetRaw(RevBodyPrefix + "1")
+	assert.NoError(t, err)
+	_, _, err = bucket.GetRaw(UserPrefix + "alice")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,571 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+)
+
+// NamespaceBucket is a wrapper around a Bucket that transparently applies a key namespace to every document, allowing
+// multiple tests to share a single underlying bucket.  For testing use only.
+//
+// Sync Gateway metadata keys have the namespace inserted after the metadata prefix (_sync:<namespace>seq), and all other
+// keys have the namespace prepended (<namespace>doc1), so that view map functions and N1QL statements filtering on the
+// metadata prefix continue to exclude metadata.  Feed events for keys outside the namespace are dropped, and the
+// namespace is stripped from keys and row ids before they're returned.
+//
+// N1QL statements are restricted to the namespace's documents by a predicate on the document id added to their WHERE
+// clause, so that the server applies LIMIT to the namespace's rows.  View keys don't include the document id, so view
+// rows outside the namespace are dropped as they're read, and limit and skip are applied to the remaining rows rather
+// than by the server.  View rows without an id, and rows of N1QL statements that couldn't be restricted, can't be
+// attributed to a namespace and are dropped.
+//
+// Views and queries that match on specific metadata key prefixes (principals, sync docs), design doc and index
+// management, and feeds that persist checkpoints to the underlying bucket aren't namespace-aware - tests depending on
+// those should use an exclusive bucket.
+type NamespaceBucket struct {
+	bucket    Bucket
+	namespace string
+
+	// keys tracks every underlying key written through this bucket, for removal by DeleteNamespace
+	keys     map[string]struct{}
+	keysLock sync.Mutex
+}
+
+var _ N1QLStore = &NamespaceBucket{}
+
+// NewNamespaceBucket returns a NamespaceBucket applying the given namespace to all keys in bucket.
+func NewNamespaceBucket(bucket Bucket, namespace string) *NamespaceBucket {
+	return &NamespaceBucket{
+		bucket:    bucket,
+		namespace: namespace,
+		keys:      make(map[string]struct{}),
+	}
+}
+
+func (b *NamespaceBucket) GetUnderlyingBucket() Bucket {
+	return b.bucket
+}
+
+// Namespace returns the namespace applied to keys in this bucket.
+func (b *NamespaceBucket) Namespace() string {
+	return b.namespace
+}
+
+// namespacedKey returns the underlying bucket key for k.
+func (b *NamespaceBucket) namespacedKey(k string) string {
+	if strings.HasPrefix(k, SyncPrefix) {
+		return SyncPrefix + b.namespace + k[len(SyncPrefix):]
+	}
+	return b.namespace + k
+}
+
+// trackedKey returns the underlying bucket key for k, and records it for removal by DeleteNamespace.
+func (b *NamespaceBucket) trackedKey(k string) string {
+	key := b.namespacedKey(k)
+	b.keysLock.Lock()
+	b.keys[key] = struct{}{}
+	b.keysLock.Unlock()
+	return key
+}
+
+// stripKey returns the key as seen by users of this bucket for the given underlying bucket key, and false when the
+// key doesn't belong to this namespace.
+func (b *NamespaceBucket) stripKey(k string) (string, bool) {
+	if strings.HasPrefix(k, SyncPrefix+b.namespace) {
+		return SyncPrefix + k[len(SyncPrefix)+len(b.namespace):], true
+	}
+	if strings.HasPrefix(k, b.namespace) {
+		return k[len(b.namespace):], true
+	}
+	return "", false
+}
+
+// DeleteNamespace removes every document written through this bucket from the underlying bucket.
+func (b *NamespaceBucket) DeleteNamespace() (deleted int, err error) {
+	b.keysLock.Lock()
+	keys := b.keys
+	b.keys = make(map[string]struct{})
+	b.keysLock.Unlock()
+
+	for key := range keys {
+		deleteErr := b.bucket.Delete(key)
+		if deleteErr != nil && !IsDocNotFoundError(deleteErr) {
+			err = deleteErr
+			continue
+		}
+		deleted++
+	}
+	return deleted, err
+}
+
+func (b *NamespaceBucket) GetName() string {
+	return b.bucket.GetName()
+}
+func (b *NamespaceBucket) Get(k string, rv interface{}) (cas uint64, err error) {
+	return b.bucket.Get(b.namespacedKey(k), rv)
+}
+func (b *NamespaceBucket) GetRaw(k string) (v []byte, cas uint64, err error) {
+	return b.bucket.GetRaw(b.namespacedKey(k))
+}
+func (b *NamespaceBucket) GetAndTouchRaw(k string, exp uint32) (v []byte, cas uint64, err error) {
+	return b.bucket.GetAndTouchRaw(b.namespacedKey(k), exp)
+}
+func (b *NamespaceBucket) Touch(k string, exp uint32) (cas uint64, err error) {
+	return b.bucket.Touch(b.namespacedKey(k), exp)
+}
+func (b *NamespaceBucket) Add(k string, exp uint32, v interface{}) (added bool, err error) {
+	return b.bucket.Add(b.trackedKey(k), exp, v)
+}
+func (b *NamespaceBucket) AddRaw(k string, exp uint32, v []byte) (added bool, err error) {
+	return b.bucket.AddRaw(b.trackedKey(k), exp, v)
+}
+func (b *NamespaceBucket) Set(k string, exp uint32, v interface{}) error {
+	return b.bucket.Set(b.trackedKey(k), exp, v)
+}
+func (b *NamespaceBucket) SetRaw(k string, exp uint32, v []byte) error {
+	return b.bucket.SetRaw(b.trackedKey(k), exp, v)
+}
+func (b *NamespaceBucket) Delete(k string) error {
+	return b.bucket.Delete(b.namespacedKey(k))
+}
+func (b *NamespaceBucket) Remove(k string, cas uint64) (casOut uint64, err error) {
+	return b.bucket.Remove(b.namespacedKey(k), cas)
+}
+func (b *NamespaceBucket) WriteCas(k string, flags int, exp uint32, cas uint64, v interface{}, opt sgbucket.WriteOptions) (uint64, error) {
+	return b.bucket.WriteCas(b.trackedKey(k), flags, exp, cas, v, opt)
+}
+func (b *NamespaceBucket) Update(k string, exp uint32, callback sgbucket.UpdateFunc) (casOut uint64, err error) {
+	return b.bucket.Update(b.trackedKey(k), exp, callback)
+}
+func (b *NamespaceBucket) Incr(k string, amt, def uint64, exp uint32) (uint64, error) {
+	return b.bucket.Incr(b.trackedKey(k), amt, def, exp)
+}
+
+func (b *NamespaceBucket) GetDDocs() (map[string]sgbucket.DesignDoc, error) {
+	return b.bucket.GetDDocs()
+}
+func (b *NamespaceBucket) GetDDoc(docname string) (ddoc sgbucket.DesignDoc, err error) {
+	return b.bucket.GetDDoc(docname)
+}
+func (b *NamespaceBucket) PutDDoc(docname string, value *sgbucket.DesignDoc) error {
+	return b.bucket.PutDDoc(docname, value)
+}
+func (b *NamespaceBucket) DeleteDDoc(docname string) error {
+	return b.bucket.DeleteDDoc(docname)
+}
+
+func (b *NamespaceBucket) View(ddoc, name string, params map[string]interface{}) (sgbucket.ViewResult, error) {
+	params, skip, limit := namespaceViewParams(params)
+	result, err := b.bucket.View(ddoc, name, params)
+	if err != nil {
+		return result, err
+	}
+	rows := make(sgbucket.ViewRows, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		if row.ID == "" {
+			continue
+		}
+		key, ok := b.stripKey(row.ID)
+		if !ok {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if limit > 0 && len(rows) >= limit {
+			break
+		}
+		row.ID = key
+		rows = append(rows, row)
+	}
+	result.Rows = rows
+	result.TotalRows = len(rows)
+	return result, nil
+}
+
+func (b *NamespaceBucket) ViewQuery(ddoc, name string, params map[string]interface{}) (sgbucket.QueryResultIterator, error) {
+	params, skip, limit := namespaceViewParams(params)
+	iterator, err := b.bucket.ViewQuery(ddoc, name, params)
+	if iterator == nil {
+		return iterator, err
+	}
+	return &namespaceResultIterator{iterator: iterator, bucket: b, requireID: true, skip: skip, limit: limit}, err
+}
+
+// namespaceViewParams returns a copy of params without limit and skip, which would otherwise be applied by the server
+// to rows of every namespace, along with their values.
+func namespaceViewParams(params map[string]interface{}) (viewParams map[string]interface{}, skip, limit int) {
+	viewParams = make(map[string]interface{}, len(params))
+	for name, value := range params {
+		switch name {
+		case ViewQueryParamLimit, ViewQueryParamSkip:
+			uintVal, err := normalizeIntToUint(value)
+			if err != nil {
+				Warnf("Namespaced view %s param error: %v", name, err)
+			}
+			if name == ViewQueryParamLimit {
+				limit = int(uintVal)
+			} else {
+				skip = int(uintVal)
+			}
+		default:
+			viewParams[name] = value
+		}
+	}
+	return viewParams, skip, limit
+}
+
+func (b *NamespaceBucket) GetMaxVbno() (uint16, error) {
+	return b.bucket.GetMaxVbno()
+}
+
+func (b *NamespaceBucket) WriteCasWithXattr(k string, xattr string, exp uint32, cas uint64, v interface{}, xv interface{}) (casOut uint64, err error) {
+	return b.bucket.WriteCasWithXattr(b.trackedKey(k), xattr, exp, cas, v, xv)
+}
+
+func (b *NamespaceBucket) WriteWithXattr(k string, xattrKey string, exp uint32, cas uint64, value []byte, xattrValue []byte, isDelete bool, deleteBody bool) (casOut uint64, err error) {
+	return b.bucket.WriteWithXattr(b.trackedKey(k), xattrKey, exp, cas, value, xattrValue, isDelete, deleteBody)
+}
+
+func (b *NamespaceBucket) WriteUpdateWithXattr(k string, xattr string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
+	return b.bucket.WriteUpdateWithXattr(b.trackedKey(k), xattr, userXattrKey, exp, previous, callback)
+}
+
+func (b *NamespaceBucket) WriteUpdateWithXattrCtx(ctx context.Context, k string, xattr string, userXattrKey string, exp uint32, previous *sgbucket.BucketDocument, callback sgbucket.WriteUpdateWithXattrFunc) (casOut uint64, err error) {
+	return WriteUpdateWithXattrCtx(ctx, b.bucket, b.trackedKey(k), xattr, userXattrKey, exp, previous, callback)
+}
+
+func (b *NamespaceBucket) SubdocInsert(docID string, fieldPath string, cas uint64, value interface{}) error {
+	return b.bucket.SubdocInsert(b.trackedKey(docID), fieldPath, cas, value)
+}
+
+func (b *NamespaceBucket) GetWithXattr(k string, xattr string, userXattrKey string, rv interface{}, xv interface{}, uxv interface{}) (cas uint64, err error) {
+	return b.bucket.GetWithXattr(b.namespacedKey(k), xattr, userXattrKey, rv, xv, uxv)
+}
+
+func (b *NamespaceBucket) DeleteWithXattr(k string, xattr string) error {
+	return b.bucket.DeleteWithXattr(b.namespacedKey(k), xattr)
+}
+
+func (b *NamespaceBucket) GetXattr(k string, xattr string, xv interface{}) (cas uint64, err error) {
+	return b.bucket.GetXattr(b.namespacedKey(k), xattr, xv)
+}
+
+// filterEvent strips the namespace from the event key, returning false for events outside the namespace.
+func (b *NamespaceBucket) filterEvent(event *sgbucket.FeedEvent) bool {
+	// Rollbacks apply to the vbucket, so to every namespace sharing the bucket
+	if event.Opcode == FeedOpRollback {
+		return true
+	}
+	key, ok := b.stripKey(string(event.Key))
+	if !ok {
+		return false
+	}
+	event.Key = []byte(key)
+	return true
+}
+
+func (b *NamespaceBucket) StartTapFeed(args sgbucket.FeedArguments, dbStats *expvar.Map) (sgbucket.MutationFeed, error) {
+	tapFeed, err := b.bucket.StartTapFeed(args, dbStats)
+	if err != nil {
+		return tapFeed, err
+	}
+
+	namespaceFeed := &wrappedTapFeedImpl{
+		channel:        make(chan sgbucket.FeedEvent, 10),
+		wrappedTapFeed: tapFeed,
+	}
+	go func() {
+		for event := range tapFeed.Events() {
+			if b.filterEvent(&event) {
+				namespaceFeed.channel <- event
+			}
+		}
+		close(namespaceFeed.channel)
+	}()
+	return namespaceFeed, nil
+}
+
+func (b *NamespaceBucket) StartDCPFeed(args sgbucket.FeedArguments, callback sgbucket.FeedEventCallbackFunc, dbStats *expvar.Map) error {
+	namespaceCallback := func(event sgbucket.FeedEvent) bool {
+		if !b.filterEvent(&event) {
+			return false
+		}
+		return callback(event)
+	}
+	return b.bucket.StartDCPFeed(args, namespaceCallback, dbStats)
+}
+
+// Close is a no-op - the underlying bucket is shared with other namespaces, and is closed by its owner.
+func (b *NamespaceBucket) Close() {
+}
+
+func (b *NamespaceBucket) Dump() {
+	b.bucket.Dump()
+}
+
+func (b *NamespaceBucket) CouchbaseServerVersion() (major uint64, minor uint64, micro string) {
+	return b.bucket.CouchbaseServerVersion()
+}
+
+func (b *NamespaceBucket) UUID() (string, error) {
+	return b.bucket.UUID()
+}
+
+func (b *NamespaceBucket) GetStatsVbSeqno(maxVbno uint16, useAbsHighSeqNo bool) (uuids map[uint16]uint64, highSeqnos map[uint16]uint64, seqErr error) {
+	return b.bucket.GetStatsVbSeqno(maxVbno, useAbsHighSeqNo)
+}
+
+func (b *NamespaceBucket) IsSupported(feature sgbucket.DataStoreFeature) bool {
+	return b.bucket.IsSupported(feature)
+}
+
+func (b *NamespaceBucket) IsError(err error, errorType sgbucket.DataStoreErrorType) bool {
+	return b.bucket.IsError(err, errorType)
+}
+
+func (b *NamespaceBucket) Keyspace() string {
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return ""
+	}
+	return n1qlStore.Keyspace()
+}
+
+func (b *NamespaceBucket) Query(statement string, params map[string]interface{}, consistency ConsistencyMode, adhoc bool) (results sgbucket.QueryResultIterator, err error) {
+	return b.QueryWithClientContextID(statement, params, consistency, adhoc, "")
+}
+
+func (b *NamespaceBucket) QueryWithClientContextID(statement string, params map[string]interface{}, consistency ConsistencyMode, adhoc bool, clientContextID string) (results sgbucket.QueryResultIterator, err error) {
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return nil, errors.New("Not N1QL Store")
+	}
+	statement, restricted := b.namespaceStatement(statement)
+	results, err = n1qlStore.QueryWithClientContextID(statement, params, consistency, adhoc, clientContextID)
+	if results == nil {
+		return results, err
+	}
+	return &namespaceResultIterator{iterator: results, bucket: b, requireID: !restricted}, err
+}
+
+// namespaceStatement restricts statement to the namespace's documents, adding a predicate on the document id to its
+// WHERE clause.  Returns false when the statement has no WHERE clause to restrict.
+func (b *NamespaceBucket) namespaceStatement(statement string) (string, bool) {
+	whereIndex := strings.Index(statement, "WHERE ")
+	if whereIndex < 0 {
+		return statement, false
+	}
+	clauseStart := whereIndex + len("WHERE ")
+	clauseEnd := len(statement)
+	for _, clauseTerminator := range []string{"ORDER BY ", "GROUP BY ", "LIMIT ", "OFFSET ", ";"} {
+		if i := strings.Index(statement[clauseStart:], clauseTerminator); i >= 0 && clauseStart+i < clauseEnd {
+			clauseEnd = clauseStart + i
+		}
+	}
+
+	idExpression := "META(`" + KeyspaceQueryToken + "`).id"
+	namespacePredicate := fmt.Sprintf("(%s LIKE '%s%%' OR %s LIKE '%s%%')",
+		idExpression, escapeLikePattern(b.namespace), idExpression, escapeLikePattern(SyncPrefix+b.namespace))
+	return statement[:clauseStart] + namespacePredicate + " AND (" + strings.TrimRight(statement[clauseStart:clauseEnd], " ") + ") " +
+		statement[clauseEnd:], true
+}
+
+// escapeLikePattern escapes the N1QL LIKE wildcards in s, for use in a string literal.
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`_`, `\\_`, `%`, `\\%`).Replace(s)
+}
+
+func (b *NamespaceBucket) ExplainQuery(statement string, params map[string]interface{}) (plain map[string]interface{}, err error) {
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return nil, errors.New("Not N1QL Store")
+	}
+	return n1qlStore.ExplainQuery(statement, params)
+}
+
+func (b *NamespaceBucket) CreateIndex(indexName string, expression string, filterExpression string, options *N1qlIndexOptions) error {
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return errors.New("Not N1QL Store")
+	}
+	return n1qlStore.CreateIndex(indexName, expression, filterExpression, options)
+}
+
+func (b *NamespaceBucket) BuildDeferredIndexes(indexSet []string) error {
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return errors.New("Not N1QL Store")
+	}
+	return n1qlStore.BuildDeferredIndexes(indexSet)
+}
+
+func (b *NamespaceBucket) CreatePrimaryIndex(indexName string, options *N1qlIndexOptions) error {
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return errors.New("Not N1QL Store")
+	}
+	return n1qlStore.CreatePrimaryIndex(indexName, options)
+}
+
+func (b *NamespaceBucket) WaitForIndexOnline(indexName string) error {
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return errors.New("Not N1QL Store")
+	}
+	return n1qlStore.WaitForIndexOnline(indexName)
+}
+
+func (b *NamespaceBucket) GetIndexMeta(indexName string) (exists bool, meta *IndexMeta, err error) {
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return false, nil, errors.New("Not N1QL Store")
+	}
+	return n1qlStore.GetIndexMeta(indexName)
+}
+
+func (b *NamespaceBucket) DropIndex(indexName string) error {
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return errors.New("Not N1QL Store")
+	}
+	return n1qlStore.DropIndex(indexName)
+}
+
+func (b *NamespaceBucket) executeQuery(statement string) (results sgbucket.QueryResultIterator, err error) {
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return nil, errors.New("Not N1QL Store")
+	}
+	statement, restricted := b.namespaceStatement(statement)
+	results, err = n1qlStore.executeQuery(statement)
+	if results == nil {
+		return results, err
+	}
+	return &namespaceResultIterator{iterator: results, bucket: b, requireID: !restricted}, err
+}
+
+func (b *NamespaceBucket) executeStatement(statement string) error {
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return errors.New("Not N1QL Store")
+	}
+	return n1qlStore.executeStatement(statement)
+}
+
+func (b *NamespaceBucket) IsErrNoResults(err error) bool {
+	if err == ErrNotFound {
+		return true
+	}
+	n1qlStore, ok := AsN1QLStore(b.bucket)
+	if !ok {
+		return false
+	}
+	return n1qlStore.IsErrNoResults(err)
+}
+
+// namespaceResultIterator wraps a view or N1QL result iterator, dropping rows whose id is outside the bucket's
+// namespace and stripping the namespace from the remaining row ids.  Rows without a string id are dropped when
+// requireID is set, and otherwise returned as-is - for N1QL statements restricted to the namespace, where rows needn't
+// include the id.  The first skip rows in the namespace are dropped, and at most limit returned when non-zero.
+type namespaceResultIterator struct {
+	iterator  sgbucket.QueryResultIterator
+	bucket    *NamespaceBucket
+	requireID bool
+	skip      int
+	limit     int
+	returned  int
+}
+
+func (i *namespaceResultIterator) One(valuePtr interface{}) error {
+	if !i.Next(valuePtr) {
+		_ = i.Close()
+		return ErrNotFound
+	}
+	_ = i.Close()
+	return nil
+}
+
+func (i *namespaceResultIterator) Next(valuePtr interface{}) bool {
+	nextBytes := i.NextBytes()
+	if nextBytes == nil {
+		return false
+	}
+
+	err := JSONUnmarshal(nextBytes, valuePtr)
+	if err != nil {
+		Warnf("Unable to unmarshal namespaced result row into value: %v", err)
+		return false
+	}
+	return true
+}
+
+func (i *namespaceResultIterator) NextBytes() []byte {
+	if i.limit > 0 && i.returned >= i.limit {
+		return nil
+	}
+	for {
+		rowBytes := i.iterator.NextBytes()
+		if len(rowBytes) == 0 {
+			return nil
+		}
+		row, ok := i.stripRowID(rowBytes)
+		if !ok {
+			continue
+		}
+		if i.skip > 0 {
+			i.skip--
+			continue
+		}
+		i.returned++
+		return row
+	}
+}
+
+// stripRowID strips the namespace from the row's id property, returning false for rows outside the namespace, and
+// for rows without an id when one is required.
+func (i *namespaceResultIterator) stripRowID(rowBytes []byte) ([]byte, bool) {
+	var row map[string]json.RawMessage
+	if err := JSONUnmarshal(rowBytes, &row); err != nil {
+		return rowBytes, !i.requireID
+	}
+
+	var id string
+	if err := JSONUnmarshal(row["id"], &id); err != nil || id == "" {
+		return rowBytes, !i.requireID
+	}
+
+	key, ok := i.bucket.stripKey(id)
+	if !ok {
+		return nil, false
+	}
+
+	row["id"], _ = JSONMarshal(key)
+	strippedBytes, err := JSONMarshal(row)
+	if err != nil {
+		return rowBytes, true
+	}
+	return strippedBytes, true
+}
+
+func (i *namespaceResultIterator) Close() error {
+	return i.iterator.Close()
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceBucket(t *testing.T) {
+	if !UnitTestUrlIsWalrus() {
+		t.Skip("Test relies on walrus tap feed and views")
+	}
+
+	bucket := GetTestBucket(t)
+	defer bucket.Close()
+
+	ns1 := NewNamespaceBucket(bucket.Bucket, "ns1:")
+	ns2 := NewNamespaceBucket(bucket.Bucket, "ns2:")
+
+	// Same keys in each namespace are isolated
+	require.NoError(t, ns1.Set("doc1", 0, map[string]interface{}{"ns": 1}))
+	require.NoError(t, ns2.Set("doc1", 0, map[string]interface{}{"ns": 2}))
+	_, err := ns1.Incr(SyncSeqKey, 5, 5, 0)
+	require.NoError(t, err)
+	_, err = ns2.Incr(SyncSeqKey, 1, 1, 0)
+	require.NoError(t, err)
+
+	var body map[string]interface{}
+	_, err = ns1.Get("doc1", &body)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), body["ns"])
+	_, err = ns2.Get("doc1", &body)
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), body["ns"])
+
+	// Metadata keys keep the metadata prefix in the underlying bucket
+	seq, err := ns1.Incr(SyncSeqKey, 0, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), seq)
+	_, _, err = bucket.GetRaw(SyncPrefix + "ns1:seq")
+	assert.NoError(t, err)
+	_, _, err = bucket.GetRaw(SyncSeqKey)
+	assert.True(t, IsDocNotFoundError(err))
+
+	// Feed only includes the namespace's keys, with the namespace stripped
+	feed, err := ns1.StartTapFeed(sgbucket.FeedArguments{}, nil)
+	require.NoError(t, err)
+	defer func() { _ = feed.Close() }()
+	seenKeys := make(map[string]bool)
+	for len(seenKeys) < 2 {
+		select {
+		case event := <-feed.Events():
+			seenKeys[string(event.Key)] = true
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "Timed out waiting for feed events", "Seen keys: %v", seenKeys)
+		}
+	}
+	assert.Equal(t, map[string]bool{"doc1": true, SyncSeqKey: true}, seenKeys)
+
+	// View rows are filtered to the namespace, with ids stripped
+	ddoc := sgbucket.DesignDoc{Views: sgbucket.ViewMap{"ids": sgbucket.ViewDef{Map: `function(doc, meta) { emit(meta.id, null); }`}}}
+	require.NoError(t, ns1.PutDDoc("namespace", &ddoc))
+	result, err := ns2.View("namespace", "ids", map[string]interface{}{"stale": false})
+	require.NoError(t, err)
+	require.Len(t, result.Rows, 2)
+	for _, row := range result.Rows {
+		assert.Contains(t, []string{"doc1", SyncSeqKey}, row.ID)
+	}
+
+	iterator, err := ns2.ViewQuery("namespace", "ids", map[string]interface{}{"stale": false})
+	require.NoError(t, err)
+	var row sgbucket.ViewRow
+	rowCount := 0
+	for iterator.Next(&row) {
+		assert.Contains(t, []string{"doc1", SyncSeqKey}, row.ID)
+		rowCount++
+	}
+	assert.NoError(t, iterator.Close())
+	assert.Equal(t, 2, rowCount)
+
+	// Limit and skip apply to the namespace's rows - ns1's rows sort first in the underlying view
+	result, err = ns2.View("namespace", "ids", map[string]interface{}{"stale": false, ViewQueryParamLimit: 2})
+	require.NoError(t, err)
+	assert.Len(t, result.Rows, 2)
+	iterator, err = ns2.ViewQuery("namespace", "ids", map[string]interface{}{"stale": false, ViewQueryParamLimit: 1})
+	require.NoError(t, err)
+	rowCount = 0
+	for iterator.Next(&row) {
+		assert.Contains(t, []string{"doc1", SyncSeqKey}, row.ID)
+		rowCount++
+	}
+	assert.NoError(t, iterator.Close())
+	assert.Equal(t, 1, rowCount)
+
+	// Deleting a namespace leaves other namespaces untouched
+	deleted, err := ns1.DeleteNamespace()
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	_, err = ns1.Get("doc1", &body)
+	assert.True(t, IsDocNotFoundError(err))
+	_, err = ns2.Get("doc1", &body)
+	assert.NoError(t, err)
+}
+
+// Validates the restriction of N1QL statements to a namespace's documents.
+func TestNamespaceStatement(t *testing.T) {
+	bucket := NewNamespaceBucket(nil, "ns_1:")
+	namespacePredicate := "(META(`$_keyspace`).id LIKE 'ns\\\\_1:%' OR META(`$_keyspace`).id LIKE '\\\\_sync:ns\\\\_1:%')"
+
+	statement, ok := bucket.namespaceStatement("SELECT META().id FROM `$_keyspace` WHERE a = 1 OR b = 2 ORDER BY META().id LIMIT 10")
+	assert.True(t, ok)
+	assert.Equal(t, "SELECT META().id FROM `$_keyspace` WHERE "+namespacePredicate+" AND (a = 1 OR b = 2) ORDER BY META().id LIMIT 10", statement)
+
+	statement, ok = bucket.namespaceStatement("SELECT value FROM `$_keyspace` WHERE any op in x satisfies op.name = $name end;")
+	assert.True(t, ok)
+	assert.Equal(t, "SELECT value FROM `$_keyspace` WHERE "+namespacePredicate+" AND (any op in x satisfies op.name = $name end) ;", statement)
+
+	// Statements without a WHERE clause can't be restricted
+	statement, ok = bucket.namespaceStatement("SELECT COUNT(1) AS count FROM `$_keyspace`")
+	assert.False(t, ok)
+	assert.Equal(t, "SELECT COUNT(1) AS count FROM `$_keyspace`", statement)
+}
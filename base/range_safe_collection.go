@@ -126,6 +126,17 @@ func (r *RangeSafeCollection) Length() int {
 	return length
 }
 
+// Keys returns a snapshot of the keys in the collection, in no particular order.
+func (r *RangeSafeCollection) Keys() []string {
+	r.valueLock.RLock()
+	keys := make([]string, 0, len(r.valueMap))
+	for key := range r.valueMap {
+		keys = append(keys, key)
+	}
+	r.valueLock.RUnlock()
+	return keys
+}
+
 // Range iterates over the set up to the last element at the time range was called, invoking
 // the callback function with the element value.
 func (r *RangeSafeCollection) Range(f func(value interface{}) bool) {
@@ -41,6 +41,8 @@ func TestRangeSafeCollection(t *testing.T) {
 	assert.True(t, created)
 	assert.Equal(t, 2, length)
 
+	assert.ElementsMatch(t, []string{"key1", "key2"}, rsc.Keys())
+
 	// Get an item
 	value, ok := rsc.Get("key2")
 	assert.Equal(t, "value2", value.(string))
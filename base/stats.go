@@ -11,8 +11,10 @@ licenses/APL2.txt.
 package base
 
 import (
+	"encoding/json"
 	"expvar"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -38,10 +40,19 @@ const (
 	SubsystemSecurity           = "security"
 	SubsystemSharedBucketImport = "shared_bucket_import"
 
-	DatabaseLabelKey    = "database"
-	ReplicationLabelKey = "replication"
+	ChannelWebhookLabelKey = "webhook"
+	DatabaseLabelKey       = "database"
+	ReplicationLabelKey    = "replication"
 )
 
+// PendingSeqWaitBuckets are the upper bounds, in milliseconds, of the pending sequence wait distribution.  They span
+// the default CachePendingSeqMaxWait several times over, to show waits when it's been raised.
+var PendingSeqWaitBuckets = []int64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+// FeedStageTimeBuckets are the upper bounds, in nanoseconds, of the sampled feed processing stage time distributions.
+// They span from a microsecond, for parsing small documents, to a second, for imports and lock waits under contention.
+var FeedStageTimeBuckets = []int64{1000, 5000, 10000, 50000, 100000, 500000, 1000000, 5000000, 10000000, 50000000, 100000000, 1000000000}
+
 var (
 	checkedSentDesc               *prometheus.Desc
 	numAttachmentBytesTransferred *prometheus.Desc
@@ -53,7 +64,7 @@ var (
 type SgwStats struct {
 	GlobalStats     *GlobalStat         `json:"global"`
 	DbStats         map[string]*DbStats `json:"per_db"`
-	ReplicatorStats *ReplicatorStats    `json:"per_replication,omitempty"`
+	ReplicatorStats *ReplicatorStats    `json:"per_replication,omitempty" kind:"counter" unit:"count" help:"Replication counters, keyed by replication"`
 
 	dbStatsMapMutex sync.Mutex
 }
@@ -100,6 +111,8 @@ func (g *GlobalStat) initResourceUtilizationStats() {
 	g.ResourceUtilization = &ResourceUtilization{
 		AdminNetworkInterfaceBytesReceived:  NewIntStat(ResourceUtilizationSubsystem, "admin_net_bytes_recv", nil, nil, prometheus.CounterValue, 0),
 		AdminNetworkInterfaceBytesSent:      NewIntStat(ResourceUtilizationSubsystem, "admin_net_bytes_sent", nil, nil, prometheus.CounterValue, 0),
+		ChannelCacheMemoryBytes:             NewIntStat(ResourceUtilizationSubsystem, "chan_cache_memory_bytes", nil, nil, prometheus.GaugeValue, 0),
+		ChannelCacheMemoryPressure:          NewIntStat(ResourceUtilizationSubsystem, "chan_cache_memory_pressure", nil, nil, prometheus.GaugeValue, 0),
 		ErrorCount:                          NewIntStat(ResourceUtilizationSubsystem, "error_count", nil, nil, prometheus.CounterValue, 0),
 		GoMemstatsHeapAlloc:                 NewIntStat(ResourceUtilizationSubsystem, "go_memstats_heapalloc", nil, nil, prometheus.GaugeValue, 0),
 		GoMemstatsHeapIdle:                  NewIntStat(ResourceUtilizationSubsystem, "go_memstats_heapidle", nil, nil, prometheus.GaugeValue, 0),
@@ -137,131 +150,192 @@ func (s *SgwStats) ReplicationStats() *expvar.Map {
 }
 
 type ResourceUtilization struct {
-	AdminNetworkInterfaceBytesReceived  *SgwIntStat   `json:"admin_net_bytes_recv"`
-	AdminNetworkInterfaceBytesSent      *SgwIntStat   `json:"admin_net_bytes_sent"`
-	ErrorCount                          *SgwIntStat   `json:"error_count"`
-	GoMemstatsHeapAlloc                 *SgwIntStat   `json:"go_memstats_heapalloc"`
-	GoMemstatsHeapIdle                  *SgwIntStat   `json:"go_memstats_heapidle"`
-	GoMemstatsHeapInUse                 *SgwIntStat   `json:"go_memstats_heapinuse"`
-	GoMemstatsHeapReleased              *SgwIntStat   `json:"go_memstats_heapreleased"`
-	GoMemstatsPauseTotalNS              *SgwIntStat   `json:"go_memstats_pausetotalns"`
-	GoMemstatsStackInUse                *SgwIntStat   `json:"go_memstats_stackinuse"`
-	GoMemstatsStackSys                  *SgwIntStat   `json:"go_memstats_stacksys"`
-	GoMemstatsSys                       *SgwIntStat   `json:"go_memstats_sys"`
-	GoroutinesHighWatermark             *SgwIntStat   `json:"goroutines_high_watermark"`
-	NumGoroutines                       *SgwIntStat   `json:"num_goroutines"`
-	CpuPercentUtil                      *SgwFloatStat `json:"process_cpu_percent_utilization"`
-	ProcessMemoryResident               *SgwIntStat   `json:"process_memory_resident"`
-	PublicNetworkInterfaceBytesReceived *SgwIntStat   `json:"pub_net_bytes_recv"`
-	PublicNetworkInterfaceBytesSent     *SgwIntStat   `json:"pub_net_bytes_sent"`
-	SystemMemoryTotal                   *SgwIntStat   `json:"system_memory_total"`
-	WarnCount                           *SgwIntStat   `json:"warn_count"`
-	Uptime                              *SgwDurStat   `json:"uptime"`
+	AdminNetworkInterfaceBytesReceived  *SgwIntStat   `json:"admin_net_bytes_recv" kind:"counter" unit:"bytes" help:"Bytes received on the admin network interface"`
+	AdminNetworkInterfaceBytesSent      *SgwIntStat   `json:"admin_net_bytes_sent" kind:"counter" unit:"bytes" help:"Bytes sent on the admin network interface"`
+	ChannelCacheMemoryBytes             *SgwIntStat   `json:"chan_cache_memory_bytes" kind:"gauge" unit:"bytes" help:"Estimated memory used by the channel caches of all databases"`
+	ChannelCacheMemoryPressure          *SgwIntStat   `json:"chan_cache_memory_pressure" kind:"gauge" unit:"percent" help:"Channel cache memory used by all databases, as a percentage of the server-wide limit"`
+	ErrorCount                          *SgwIntStat   `json:"error_count" kind:"counter" unit:"count" help:"Errors logged"`
+	GoMemstatsHeapAlloc                 *SgwIntStat   `json:"go_memstats_heapalloc" kind:"gauge" unit:"bytes" help:"Bytes of allocated heap objects"`
+	GoMemstatsHeapIdle                  *SgwIntStat   `json:"go_memstats_heapidle" kind:"gauge" unit:"bytes" help:"Bytes in idle heap spans"`
+	GoMemstatsHeapInUse                 *SgwIntStat   `json:"go_memstats_heapinuse" kind:"gauge" unit:"bytes" help:"Bytes in in-use heap spans"`
+	GoMemstatsHeapReleased              *SgwIntStat   `json:"go_memstats_heapreleased" kind:"gauge" unit:"bytes" help:"Bytes of heap memory returned to the OS"`
+	GoMemstatsPauseTotalNS              *SgwIntStat   `json:"go_memstats_pausetotalns" kind:"gauge" unit:"nanoseconds" help:"Cumulative garbage collection pause time"`
+	GoMemstatsStackInUse                *SgwIntStat   `json:"go_memstats_stackinuse" kind:"gauge" unit:"bytes" help:"Bytes in stack spans"`
+	GoMemstatsStackSys                  *SgwIntStat   `json:"go_memstats_stacksys" kind:"gauge" unit:"bytes" help:"Stack memory obtained from the OS"`
+	GoMemstatsSys                       *SgwIntStat   `json:"go_memstats_sys" kind:"gauge" unit:"bytes" help:"Total memory obtained from the OS"`
+	GoroutinesHighWatermark             *SgwIntStat   `json:"goroutines_high_watermark" kind:"gauge" unit:"count" help:"Highest number of goroutines seen"`
+	NumGoroutines                       *SgwIntStat   `json:"num_goroutines" kind:"gauge" unit:"count" help:"Current number of goroutines"`
+	CpuPercentUtil                      *SgwFloatStat `json:"process_cpu_percent_utilization" kind:"gauge" unit:"percent" help:"CPU utilization of the process"`
+	ProcessMemoryResident               *SgwIntStat   `json:"process_memory_resident" kind:"gauge" unit:"bytes" help:"Resident memory of the process"`
+	PublicNetworkInterfaceBytesReceived *SgwIntStat   `json:"pub_net_bytes_recv" kind:"counter" unit:"bytes" help:"Bytes received on the public network interface"`
+	PublicNetworkInterfaceBytesSent     *SgwIntStat   `json:"pub_net_bytes_sent" kind:"counter" unit:"bytes" help:"Bytes sent on the public network interface"`
+	SystemMemoryTotal                   *SgwIntStat   `json:"system_memory_total" kind:"gauge" unit:"bytes" help:"Total memory of the system"`
+	WarnCount                           *SgwIntStat   `json:"warn_count" kind:"counter" unit:"count" help:"Warnings logged"`
+	Uptime                              *SgwDurStat   `json:"uptime" kind:"counter" unit:"nanoseconds" help:"Time since the process started"`
 }
 
 type DbStats struct {
 	dbName                  string
-	CacheStats              *CacheStats                   `json:"cache,omitempty"`
-	CBLReplicationPullStats *CBLReplicationPullStats      `json:"cbl_replication_pull,omitempty"`
-	CBLReplicationPushStats *CBLReplicationPushStats      `json:"cbl_replication_push,omitempty"`
-	DatabaseStats           *DatabaseStats                `json:"database,omitempty"`
-	DeltaSyncStats          *DeltaSyncStats               `json:"delta_sync,omitempty"`
-	QueryStats              *QueryStats                   `json:"gsi_views,omitempty"`
-	DbReplicatorStats       map[string]*DbReplicatorStats `json:"replications,omitempty"`
-	SecurityStats           *SecurityStats                `json:"security,omitempty"`
-	SharedBucketImportStats *SharedBucketImportStats      `json:"shared_bucket_import,omitempty"`
+	CacheStats              *CacheStats                     `json:"cache,omitempty"`
+	CBLReplicationPullStats *CBLReplicationPullStats        `json:"cbl_replication_pull,omitempty"`
+	CBLReplicationPushStats *CBLReplicationPushStats        `json:"cbl_replication_push,omitempty"`
+	ChannelWebhookStats     map[string]*ChannelWebhookStats `json:"channel_webhooks,omitempty"`
+	DatabaseStats           *DatabaseStats                  `json:"database,omitempty"`
+	DeltaSyncStats          *DeltaSyncStats                 `json:"delta_sync,omitempty"`
+	QueryStats              *QueryStats                     `json:"gsi_views,omitempty"`
+	DbReplicatorStats       map[string]*DbReplicatorStats   `json:"replications,omitempty"`
+	SecurityStats           *SecurityStats                  `json:"security,omitempty"`
+	SharedBucketImportStats *SharedBucketImportStats        `json:"shared_bucket_import,omitempty"`
 }
 
 type CacheStats struct {
-	AbandonedSeqs                       *SgwIntStat `json:"abandoned_seqs"`
-	ChannelCacheRevsActive              *SgwIntStat `json:"chan_cache_active_revs"`
-	ChannelCacheBypassCount             *SgwIntStat `json:"chan_cache_bypass_count"`
-	ChannelCacheChannelsAdded           *SgwIntStat `json:"chan_cache_channels_added"`
-	ChannelCacheChannelsEvictedInactive *SgwIntStat `json:"chan_cache_channels_evicted_inactive"`
-	ChannelCacheChannelsEvictedNRU      *SgwIntStat `json:"chan_cache_channels_evicted_nru"`
-	ChannelCacheCompactCount            *SgwIntStat `json:"chan_cache_compact_count"`
-	ChannelCacheCompactTime             *SgwIntStat `json:"chan_cache_compact_time"`
-	ChannelCacheHits                    *SgwIntStat `json:"chan_cache_hits"`
-	ChannelCacheMaxEntries              *SgwIntStat `json:"chan_cache_max_entries"`
-	ChannelCacheMisses                  *SgwIntStat `json:"chan_cache_misses"`
-	ChannelCacheNumChannels             *SgwIntStat `json:"chan_cache_num_channels"`
-	ChannelCachePendingQueries          *SgwIntStat `json:"chan_cache_pending_queries"`
-	ChannelCacheRevsRemoval             *SgwIntStat `json:"chan_cache_removal_revs"`
-	ChannelCacheRevsTombstone           *SgwIntStat `json:"chan_cache_tombstone_revs"`
-	HighSeqCached                       *SgwIntStat `json:"high_seq_cached"`
-	HighSeqStable                       *SgwIntStat `json:"high_seq_stable"`
-	NonMobileIgnoredCount               *SgwIntStat `json:"non_mobile_ignored_count"`
-	NumActiveChannels                   *SgwIntStat `json:"num_active_channels"`
-	NumSkippedSeqs                      *SgwIntStat `json:"num_skipped_seqs"`
-	PendingSeqLen                       *SgwIntStat `json:"pending_seq_len"`
-	RevisionCacheBypass                 *SgwIntStat `json:"rev_cache_bypass"`
-	RevisionCacheHits                   *SgwIntStat `json:"rev_cache_hits"`
-	RevisionCacheMisses                 *SgwIntStat `json:"rev_cache_misses"`
-	SkippedSeqLen                       *SgwIntStat `json:"skipped_seq_len"`
-	ViewQueries                         *SgwIntStat `json:"view_queries"`
+	AbandonedSeqs                       *SgwIntStat       `json:"abandoned_seqs" kind:"counter" unit:"count" help:"Skipped sequences abandoned after waiting longer than the skipped sequence max wait"`
+	ChannelCacheRevsActive              *SgwIntStat       `json:"chan_cache_active_revs" kind:"gauge" unit:"count" help:"Active revisions held in channel caches"`
+	ChannelCacheBackfillMerged          *SgwIntStat       `json:"chan_cache_backfill_merged" kind:"counter" unit:"count" help:"Entries from channel backfill queries merged into channel caches"`
+	ChannelCacheBypassCount             *SgwIntStat       `json:"chan_cache_bypass_count" kind:"counter" unit:"count" help:"Bypass channel caches created because the channel cache was full"`
+	ChannelCacheChannelsAdded           *SgwIntStat       `json:"chan_cache_channels_added" kind:"counter" unit:"count" help:"Channels added to the channel cache"`
+	ChannelCacheChannelsEvictedInactive *SgwIntStat       `json:"chan_cache_channels_evicted_inactive" kind:"counter" unit:"count" help:"Channels evicted from the channel cache for having no active changes feeds"`
+	ChannelCacheChannelsEvictedNRU      *SgwIntStat       `json:"chan_cache_channels_evicted_nru" kind:"counter" unit:"count" help:"Channels evicted from the channel cache as not recently used"`
+	ChannelCacheCompactCount            *SgwIntStat       `json:"chan_cache_compact_count" kind:"counter" unit:"count" help:"Channel cache compactions"`
+	ChannelCacheCompactTime             *SgwIntStat       `json:"chan_cache_compact_time" kind:"counter" unit:"nanoseconds" help:"Time spent compacting the channel cache"`
+	ChannelCacheCorruptEntries          *SgwIntStat       `json:"chan_cache_corrupt_entries" kind:"counter" unit:"count" help:"Corrupt channel cache entries detected and discarded"`
+	ChannelCacheHandoffInvalidated      *SgwIntStat       `json:"chan_cache_handoff_invalidated" kind:"counter" unit:"count" help:"Channel caches seeded from a cache handoff snapshot found inconsistent and reverted to backfill"`
+	ChannelCacheHandoffSeeded           *SgwIntStat       `json:"chan_cache_handoff_seeded" kind:"counter" unit:"count" help:"Channel caches seeded on startup from the cache handoff snapshot of the previous node"`
+	ChannelCacheHits                    *SgwIntStat       `json:"chan_cache_hits" kind:"counter" unit:"count" help:"Channel changes requests served entirely from the channel cache"`
+	ChannelCacheMaxEntries              *SgwIntStat       `json:"chan_cache_max_entries" kind:"gauge" unit:"count" help:"Entries in the largest channel cache"`
+	ChannelCacheMemoryBytes             *SgwIntStat       `json:"chan_cache_memory_bytes" kind:"gauge" unit:"bytes" help:"Estimated memory used by the database channel caches"`
+	ChannelCacheMisses                  *SgwIntStat       `json:"chan_cache_misses" kind:"counter" unit:"count" help:"Channel changes requests served by query alone"`
+	ChannelCacheNegativeHits            *SgwIntStat       `json:"chan_cache_negative_hits" kind:"counter" unit:"count" help:"Channel changes requests for channels known to be empty, served without a query"`
+	ChannelCacheNumChannels             *SgwIntStat       `json:"chan_cache_num_channels" kind:"gauge" unit:"count" help:"Channels in the channel cache"`
+	ChannelCachePartialHits             *SgwIntStat       `json:"chan_cache_partial_hits" kind:"counter" unit:"count" help:"Channel changes requests served from the channel cache and a backfill query"`
+	ChannelCachePendingQueries          *SgwIntStat       `json:"chan_cache_pending_queries" kind:"gauge" unit:"count" help:"Channel cache backfill queries in progress"`
+	ChannelCacheRemovalsPruned          *SgwIntStat       `json:"chan_cache_removals_pruned" kind:"counter" unit:"count" help:"Removal entries pruned from channel caches"`
+	ChannelCacheRemovalsRetained        *SgwIntStat       `json:"chan_cache_removals_retained" kind:"counter" unit:"count" help:"Removal entries retained for backfills when pruning channel caches"`
+	ChannelCacheRevsRemoval             *SgwIntStat       `json:"chan_cache_removal_revs" kind:"gauge" unit:"count" help:"Removal revisions held in channel caches"`
+	ChannelCacheRevsTombstone           *SgwIntStat       `json:"chan_cache_tombstone_revs" kind:"gauge" unit:"count" help:"Tombstone revisions held in channel caches"`
+	ChannelCacheSlowBackfillCount       *SgwIntStat       `json:"chan_cache_slow_backfill_count" kind:"counter" unit:"count" help:"Channel backfill queries slower than the slow query warning threshold"`
+	ChannelVerificationCount            *SgwIntStat       `json:"channel_verification_count" kind:"counter" unit:"count" help:"Channel cache verifications against a channel query"`
+	ChannelVerificationDivergedCount    *SgwIntStat       `json:"channel_verification_diverged_count" kind:"counter" unit:"count" help:"Channel cache verifications that found the cache diverged from the query"`
+	DiscardedFeedSeqCount               *SgwIntStat       `json:"discarded_feed_seq_count" kind:"counter" unit:"count" help:"Feed events discarded for sequences at or below the initial sequence of the cache"`
+	EmptyMetadataCount                  *SgwIntStat       `json:"empty_metadata_count" kind:"counter" unit:"count" help:"Feed events without sync metadata"`
+	FeedParseErrorCount                 *SgwIntStat       `json:"feed_parse_error_count" kind:"counter" unit:"count" help:"Feed events whose sync metadata could not be parsed"`
+	FeedStageImportCheckTime            *SgwHistogramStat `json:"feed_stage_import_check_time" kind:"histogram" unit:"nanoseconds" help:"Sampled time spent deciding whether feed events need importing"`
+	FeedStageLockHeldTime               *SgwHistogramStat `json:"feed_stage_lock_held_time" kind:"histogram" unit:"nanoseconds" help:"Sampled time the change cache lock was held to process a feed event"`
+	FeedStageLockWaitTime               *SgwHistogramStat `json:"feed_stage_lock_wait_time" kind:"histogram" unit:"nanoseconds" help:"Sampled time spent waiting for the change cache lock to process a feed event"`
+	FeedStageNotifyTime                 *SgwHistogramStat `json:"feed_stage_notify_time" kind:"histogram" unit:"nanoseconds" help:"Sampled time spent notifying changes feeds of the channels changed by a feed event"`
+	FeedStageUnmarshalTime              *SgwHistogramStat `json:"feed_stage_unmarshal_time" kind:"histogram" unit:"nanoseconds" help:"Sampled time spent parsing the sync metadata of feed events"`
+	HighFeedLag                         *SgwIntStat       `json:"high_feed_lag" kind:"counter" unit:"count" help:"Feed events received with a latency over the high feed lag threshold"`
+	HighSeqCached                       *SgwIntStat       `json:"high_seq_cached" kind:"counter" unit:"sequence" help:"Highest sequence in the change cache"`
+	HighSeqStable                       *SgwIntStat       `json:"high_seq_stable" kind:"counter" unit:"sequence" help:"Highest contiguous sequence in the change cache"`
+	MaxFeedLag                          *SgwIntStat       `json:"max_feed_lag" kind:"gauge" unit:"milliseconds" help:"Highest feed latency since stats were last updated"`
+	MaxSequenceGap                      *SgwIntStat       `json:"max_sequence_gap" kind:"gauge" unit:"count" help:"Largest gap between received sequences"`
+	MaxVbLag                            *SgwIntStat       `json:"max_vb_lag" kind:"gauge" unit:"seconds" help:"Largest time since a vbucket last received a mutation on the cache feed"`
+	MeanFeedLag                         *SgwIntStat       `json:"mean_feed_lag" kind:"gauge" unit:"milliseconds" help:"Mean feed latency of the most recently received changes"`
+	MetadataEventCount                  *SgwIntStat       `json:"metadata_event_count" kind:"counter" unit:"count" help:"Principal and unused sequence feed events processed"`
+	MetadataEventTime                   *SgwIntStat       `json:"metadata_event_time" kind:"counter" unit:"nanoseconds" help:"Time from receiving principal and unused sequence feed events to processing them"`
+	NonMobileIgnoredCount               *SgwIntStat       `json:"non_mobile_ignored_count" kind:"counter" unit:"count" help:"Feed events ignored for documents not managed by Sync Gateway"`
+	NumActiveChannels                   *SgwIntStat       `json:"num_active_channels" kind:"gauge" unit:"count" help:"Channels with active changes feeds"`
+	NumSkippedSeqs                      *SgwIntStat       `json:"num_skipped_seqs" kind:"counter" unit:"count" help:"Sequences added to the skipped sequence list"`
+	OldestSkippedSeqAge                 *SgwIntStat       `json:"oldest_skipped_seq_age" kind:"gauge" unit:"seconds" help:"Age of the oldest sequence in the skipped sequence list"`
+	PendingSeqLen                       *SgwIntStat       `json:"pending_seq_len" kind:"gauge" unit:"count" help:"Sequences waiting in the pending sequence buffer"`
+	PendingSeqBytes                     *SgwIntStat       `json:"pending_seq_bytes" kind:"gauge" unit:"bytes" help:"Estimated memory used by the pending sequence buffer"`
+	PendingSeqMaxWait                   *SgwIntStat       `json:"pending_seq_max_wait" kind:"gauge" unit:"milliseconds" help:"Effective maximum wait for a pending sequence"`
+	PendingSeqSkippedForwardCount       *SgwIntStat       `json:"pending_seq_skipped_forward_count" kind:"counter" unit:"count" help:"Times the pending sequence buffer skipped forward over missing sequences"`
+	PendingSeqWait                      *SgwHistogramStat `json:"pending_seq_wait" kind:"histogram" unit:"milliseconds" help:"Time sequences waited in the pending sequence buffer"`
+	PrincipalParseErrorCount            *SgwIntStat       `json:"principal_parse_error_count" kind:"counter" unit:"count" help:"User and role documents on the feed that could not be parsed"`
+	RevisionCacheBypass                 *SgwIntStat       `json:"rev_cache_bypass" kind:"gauge" unit:"count" help:"Revision cache bypass operations"`
+	RevisionCacheHits                   *SgwIntStat       `json:"rev_cache_hits" kind:"counter" unit:"count" help:"Revision cache hits"`
+	RevisionCacheMisses                 *SgwIntStat       `json:"rev_cache_misses" kind:"counter" unit:"count" help:"Revision cache misses"`
+	RollbackCount                       *SgwIntStat       `json:"rollback_count" kind:"counter" unit:"count" help:"Feed rollbacks applied to the change cache"`
+	RolledBackEntryCount                *SgwIntStat       `json:"rolled_back_entry_count" kind:"counter" unit:"count" help:"Change cache entries removed by feed rollbacks"`
+	SequenceGap                         *SgwIntStat       `json:"sequence_gap" kind:"gauge" unit:"count" help:"Size of the sequence gap the pending sequence buffer is waiting on"`
+	SequenceWaitTimeoutCount            *SgwIntStat       `json:"sequence_wait_timeout" kind:"counter" unit:"count" help:"Waits for a sequence to be cached that timed out"`
+	SkippedSeqLen                       *SgwIntStat       `json:"skipped_seq_len" kind:"gauge" unit:"count" help:"Sequences in the skipped sequence list"`
+	UnbufferedLateSeqCount              *SgwIntStat       `json:"unbuffered_late_seq_count" kind:"counter" unit:"count" help:"Late sequences received while sequence buffering was bypassed"`
+	ViewQueries                         *SgwIntStat       `json:"view_queries" kind:"counter" unit:"count" help:"Channel cache backfill queries"`
+
+	// ChannelAccess breaks chan_cache_hits, chan_cache_partial_hits and chan_cache_misses down by channel.  Not exported
+	// to prometheus, as the number of channels is unbounded.
+	ChannelAccess *ChannelCacheAccessStats `json:"chan_cache_channel_access" kind:"counter" unit:"count" help:"Channel cache hits, partial hits and misses, keyed by channel"`
 }
 
 type CBLReplicationPullStats struct {
-	AttachmentPullBytes         *SgwIntStat `json:"attachment_pull_bytes"`
-	AttachmentPullCount         *SgwIntStat `json:"attachment_pull_count"`
-	MaxPending                  *SgwIntStat `json:"max_pending"`
-	NumReplicationsActive       *SgwIntStat `json:"num_replications_active"`
-	NumPullReplActiveContinuous *SgwIntStat `json:"num_pull_repl_active_continuous"`
-	NumPullReplActiveOneShot    *SgwIntStat `json:"num_pull_repl_active_one_shot"`
-	NumPullReplCaughtUp         *SgwIntStat `json:"num_pull_repl_caught_up"`
-	NumPullReplTotalCaughtUp    *SgwIntStat `json:"num_pull_repl_total_caught_up"`
-	NumPullReplSinceZero        *SgwIntStat `json:"num_pull_repl_since_zero"`
-	NumPullReplTotalContinuous  *SgwIntStat `json:"num_pull_repl_total_continuous"`
-	NumPullReplTotalOneShot     *SgwIntStat `json:"num_pull_repl_total_one_shot"`
-	RequestChangesCount         *SgwIntStat `json:"request_changes_count"`
-	RequestChangesTime          *SgwIntStat `json:"request_changes_time"`
-	RevProcessingTime           *SgwIntStat `json:"rev_processing_time"`
-	RevSendCount                *SgwIntStat `json:"rev_send_count"`
-	RevSendLatency              *SgwIntStat `json:"rev_send_latency"`
+	AttachmentPullBytes         *SgwIntStat   `json:"attachment_pull_bytes" kind:"counter" unit:"bytes" help:"Attachment bytes pulled"`
+	AttachmentPullCount         *SgwIntStat   `json:"attachment_pull_count" kind:"counter" unit:"count" help:"Attachments pulled"`
+	ChangesCompressedBytes      *SgwIntStat   `json:"changes_compressed_bytes" kind:"counter" unit:"bytes" help:"Compressed bytes of changes messages sent"`
+	ChangesCompressionRatio     *SgwFloatStat `json:"changes_compression_ratio" kind:"gauge" unit:"ratio" help:"Uncompressed to compressed size of changes messages sent"`
+	ChangesCompressionSaved     *SgwIntStat   `json:"changes_compression_saved_bytes" kind:"gauge" unit:"bytes" help:"Bytes saved by compressing changes messages"`
+	ChangesUncompressedBytes    *SgwIntStat   `json:"changes_uncompressed_bytes" kind:"counter" unit:"bytes" help:"Uncompressed bytes of changes messages sent"`
+	MaxPending                  *SgwIntStat   `json:"max_pending" kind:"gauge" unit:"count" help:"Highest number of sequences in the pending sequence buffer"`
+	NumReplicationsActive       *SgwIntStat   `json:"num_replications_active" kind:"gauge" unit:"count" help:"Active pull replications"`
+	NumPullReplActiveContinuous *SgwIntStat   `json:"num_pull_repl_active_continuous" kind:"gauge" unit:"count" help:"Active continuous pull replications"`
+	NumPullReplActiveOneShot    *SgwIntStat   `json:"num_pull_repl_active_one_shot" kind:"gauge" unit:"count" help:"Active one-shot pull replications"`
+	NumPullReplCaughtUp         *SgwIntStat   `json:"num_pull_repl_caught_up" kind:"gauge" unit:"count" help:"Pull replications that are caught up"`
+	NumPullReplTotalCaughtUp    *SgwIntStat   `json:"num_pull_repl_total_caught_up" kind:"gauge" unit:"count" help:"Pull replications that have caught up"`
+	NumPullReplSinceZero        *SgwIntStat   `json:"num_pull_repl_since_zero" kind:"counter" unit:"count" help:"Pull replications started from sequence zero"`
+	NumPullReplTotalContinuous  *SgwIntStat   `json:"num_pull_repl_total_continuous" kind:"gauge" unit:"count" help:"Continuous pull replications started"`
+	NumPullReplTotalOneShot     *SgwIntStat   `json:"num_pull_repl_total_one_shot" kind:"gauge" unit:"count" help:"One-shot pull replications started"`
+	RequestChangesCount         *SgwIntStat   `json:"request_changes_count" kind:"counter" unit:"count" help:"Changes requests handled"`
+	RequestChangesTime          *SgwIntStat   `json:"request_changes_time" kind:"counter" unit:"nanoseconds" help:"Time spent handling changes requests"`
+	RevProcessingTime           *SgwIntStat   `json:"rev_processing_time" kind:"gauge" unit:"nanoseconds" help:"Time spent sending revisions"`
+	RevSendCount                *SgwIntStat   `json:"rev_send_count" kind:"counter" unit:"count" help:"Revisions sent"`
+	RevSendLatency              *SgwIntStat   `json:"rev_send_latency" kind:"counter" unit:"nanoseconds" help:"Time from announcing a change to sending its revision"`
 }
 
 type CBLReplicationPushStats struct {
-	AttachmentPushBytes *SgwIntStat `json:"attachment_push_bytes"`
-	AttachmentPushCount *SgwIntStat `json:"attachment_push_count"`
-	DocPushCount        *SgwIntStat `json:"doc_push_count"`
-	ProposeChangeCount  *SgwIntStat `json:"propose_change_count"`
-	ProposeChangeTime   *SgwIntStat `json:"propose_change_time"`
-	SyncFunctionCount   *SgwIntStat `json:"sync_function_count"`
-	SyncFunctionTime    *SgwIntStat `json:"sync_function_time"`
-	WriteProcessingTime *SgwIntStat `json:"write_processing_time"`
+	AttachmentPushBytes *SgwIntStat `json:"attachment_push_bytes" kind:"counter" unit:"bytes" help:"Attachment bytes pushed"`
+	AttachmentPushCount *SgwIntStat `json:"attachment_push_count" kind:"counter" unit:"count" help:"Attachments pushed"`
+	AttachmentPushKnown *SgwIntStat `json:"attachment_push_known" kind:"counter" unit:"count" help:"Pushed attachments already known to Sync Gateway"`
+	DocPushCount        *SgwIntStat `json:"doc_push_count" kind:"gauge" unit:"count" help:"Documents pushed"`
+	ProposeChangeCount  *SgwIntStat `json:"propose_change_count" kind:"counter" unit:"count" help:"Changes proposed by clients"`
+	ProposeChangeTime   *SgwIntStat `json:"propose_change_time" kind:"counter" unit:"nanoseconds" help:"Time spent handling proposed changes"`
+	SyncFunctionCount   *SgwIntStat `json:"sync_function_count" kind:"counter" unit:"count" help:"Sync function calls"`
+	SyncFunctionTime    *SgwIntStat `json:"sync_function_time" kind:"counter" unit:"nanoseconds" help:"Time spent in the sync function"`
+	WriteProcessingTime *SgwIntStat `json:"write_processing_time" kind:"gauge" unit:"nanoseconds" help:"Time spent processing document writes"`
 }
 
 type DatabaseStats struct {
-	ConflictWriteCount      *SgwIntStat `json:"conflict_write_count"`
-	Crc32MatchCount         *SgwIntStat `json:"crc32c_match_count"`
-	DCPCachingCount         *SgwIntStat `json:"dcp_caching_count"`
-	DCPCachingTime          *SgwIntStat `json:"dcp_caching_time"`
-	DCPReceivedCount        *SgwIntStat `json:"dcp_received_count"`
-	DCPReceivedTime         *SgwIntStat `json:"dcp_received_time"`
-	DocReadsBytesBlip       *SgwIntStat `json:"doc_reads_bytes_blip"`
-	DocWritesBytes          *SgwIntStat `json:"doc_writes_bytes"`
-	DocWritesBytesBlip      *SgwIntStat `json:"doc_writes_bytes_blip"`
-	DocWritesXattrBytes     *SgwIntStat `json:"doc_writes_xattr_bytes"`
-	HighSeqFeed             *SgwIntStat `json:"high_seq_feed"`
-	NumDocReadsBlip         *SgwIntStat `json:"num_doc_reads_blip"`
-	NumDocReadsRest         *SgwIntStat `json:"num_doc_reads_rest"`
-	NumDocWrites            *SgwIntStat `json:"num_doc_writes"`
-	NumReplicationsActive   *SgwIntStat `json:"num_replications_active"`
-	NumReplicationsTotal    *SgwIntStat `json:"num_replications_total"`
-	NumTombstonesCompacted  *SgwIntStat `json:"num_tombstones_compacted"`
-	SequenceAssignedCount   *SgwIntStat `json:"sequence_assigned_count"`
-	SequenceGetCount        *SgwIntStat `json:"sequence_get_count"`
-	SequenceIncrCount       *SgwIntStat `json:"sequence_incr_count"`
-	SequenceReleasedCount   *SgwIntStat `json:"sequence_released_count"`
-	SequenceReservedCount   *SgwIntStat `json:"sequence_reserved_count"`
-	WarnChannelsPerDocCount *SgwIntStat `json:"warn_channels_per_doc_count"`
-	WarnGrantsPerDocCount   *SgwIntStat `json:"warn_grants_per_doc_count"`
-	WarnXattrSizeCount      *SgwIntStat `json:"warn_xattr_size_count"`
+	ConflictWriteCount      *SgwIntStat     `json:"conflict_write_count" kind:"counter" unit:"count" help:"Writes that created a conflict"`
+	Crc32MatchCount         *SgwIntStat     `json:"crc32c_match_count" kind:"gauge" unit:"count" help:"Writes and imports skipped as the document body was unchanged"`
+	DCPCachingCount         *ShardedIntStat `json:"dcp_caching_count" kind:"counter" unit:"count" help:"Feed events cached"`
+	DCPCachingTime          *ShardedIntStat `json:"dcp_caching_time" kind:"counter" unit:"nanoseconds" help:"Time from receiving feed events to caching them"`
+	DCPReceivedCount        *ShardedIntStat `json:"dcp_received_count" kind:"counter" unit:"count" help:"Feed events received"`
+	DCPReceivedTime         *ShardedIntStat `json:"dcp_received_time" kind:"counter" unit:"nanoseconds" help:"Time from writing documents to receiving them on the feed"`
+	DocReadsBytesBlip       *SgwIntStat     `json:"doc_reads_bytes_blip" kind:"counter" unit:"bytes" help:"Document bytes read by replications"`
+	DocWritesBytes          *SgwIntStat     `json:"doc_writes_bytes" kind:"counter" unit:"bytes" help:"Document bytes written"`
+	DocWritesBytesBlip      *SgwIntStat     `json:"doc_writes_bytes_blip" kind:"counter" unit:"bytes" help:"Document bytes written by replications"`
+	DocWritesXattrBytes     *SgwIntStat     `json:"doc_writes_xattr_bytes" kind:"counter" unit:"bytes" help:"Sync metadata bytes written"`
+	HighSeqFeed             *SgwIntStat     `json:"high_seq_feed" kind:"counter" unit:"sequence" help:"Highest sequence received on the feed"`
+	MetadataDocCount        *SgwIntStat     `json:"metadata_doc_count" kind:"gauge" unit:"count" help:"Sync Gateway metadata documents in the bucket"`
+	NeedsResync             *SgwIntStat     `json:"needs_resync" kind:"gauge" unit:"boolean" help:"Whether the database has been flagged as needing a resync"`
+	NotifyPacingWindow      *SgwIntStat     `json:"notify_pacing_window" kind:"gauge" unit:"milliseconds" help:"Effective window change notifications are coalesced over"`
+	NumChangesFeedsActive   *SgwIntStat     `json:"num_changes_feeds_active" kind:"gauge" unit:"count" help:"Active changes feeds"`
+	NumChangesFeedsRejected *SgwIntStat     `json:"num_changes_feeds_rejected" kind:"counter" unit:"count" help:"Changes feeds rejected for exceeding the changes feed limit"`
+	NumDocReadsBlip         *SgwIntStat     `json:"num_doc_reads_blip" kind:"counter" unit:"count" help:"Documents read by replications"`
+	NumDocReadsRest         *SgwIntStat     `json:"num_doc_reads_rest" kind:"counter" unit:"count" help:"Documents read through the REST API"`
+	NumDocWrites            *SgwIntStat     `json:"num_doc_writes" kind:"counter" unit:"count" help:"Documents written"`
+	NumReplicationsActive   *SgwIntStat     `json:"num_replications_active" kind:"gauge" unit:"count" help:"Active replications"`
+	NumReplicationsTotal    *SgwIntStat     `json:"num_replications_total" kind:"counter" unit:"count" help:"Replications started"`
+	NumTombstonesCompacted  *SgwIntStat     `json:"num_tombstones_compacted" kind:"counter" unit:"count" help:"Tombstones purged by compaction"`
+	OldRevBackupCount       *SgwIntStat     `json:"old_rev_backup_count" kind:"counter" unit:"count" help:"Old revision bodies backed up"`
+	OldRevNoExpiryCount     *SgwIntStat     `json:"old_rev_no_expiry_count" kind:"counter" unit:"count" help:"Old revision bodies backed up without an expiry"`
+	SequenceAssignedCount   *SgwIntStat     `json:"sequence_assigned_count" kind:"counter" unit:"count" help:"Sequences assigned to documents"`
+	SequenceGetCount        *SgwIntStat     `json:"sequence_get_count" kind:"counter" unit:"count" help:"Reads of the sequence counter"`
+	SequenceIncrCount       *SgwIntStat     `json:"sequence_incr_count" kind:"counter" unit:"count" help:"Increments of the sequence counter"`
+	SequenceReleasedCount   *SgwIntStat     `json:"sequence_released_count" kind:"counter" unit:"count" help:"Reserved sequences released unused"`
+	SequenceReservedCount   *SgwIntStat     `json:"sequence_reserved_count" kind:"counter" unit:"count" help:"Sequences reserved"`
+	WarnChannelsPerDocCount *SgwIntStat     `json:"warn_channels_per_doc_count" kind:"counter" unit:"count" help:"Documents over the channels per document warning threshold"`
+	WarnGrantsPerDocCount   *SgwIntStat     `json:"warn_grants_per_doc_count" kind:"counter" unit:"count" help:"Documents over the grants per document warning threshold"`
+	WarnXattrSizeCount      *SgwIntStat     `json:"warn_xattr_size_count" kind:"counter" unit:"count" help:"Documents over the sync metadata size warning threshold"`
+
+	// Tombstone compaction progress.  Compacted tombstones are counted by NumTombstonesCompacted
+	TombstoneCompactionRunning     *SgwIntStat `json:"tombstone_compaction_running" kind:"gauge" unit:"boolean" help:"Whether tombstone compaction is running"`
+	TombstoneCompactionFailedCount *SgwIntStat `json:"tombstone_compaction_failed_count" kind:"counter" unit:"count" help:"Tombstone compaction runs that failed"`
 
 	// These can be cleaned up in future versions of SGW, implemented as maps to reduce amount of potential risk
 	// prior to Hydrogen release. These are not exported as part of prometheus and only exposed through expvars
-	CacheFeedMapStats  *ExpVarMapWrapper `json:"cache_feed"`
-	ImportFeedMapStats *ExpVarMapWrapper `json:"import_feed"`
+	CacheFeedMapStats  *ExpVarMapWrapper `json:"cache_feed" kind:"counter" unit:"count" help:"Cache feed counters, such as rollbacks and backfill progress"`
+	ImportFeedMapStats *ExpVarMapWrapper `json:"import_feed" kind:"counter" unit:"count" help:"Import feed counters, such as rollbacks and backfill progress"`
 }
 
 // This wrapper ensures that an expvar.Map type can be marshalled into JSON. The expvar.Map has no method to go direct to
@@ -322,12 +396,12 @@ func (rs *ReplicatorStats) Collect(ch chan<- prometheus.Metric) {
 }
 
 type DeltaSyncStats struct {
-	DeltaCacheHit             *SgwIntStat `json:"delta_cache_hit"`
-	DeltaCacheMiss            *SgwIntStat `json:"delta_cache_miss"`
-	DeltaPullReplicationCount *SgwIntStat `json:"delta_pull_replication_count"`
-	DeltaPushDocCount         *SgwIntStat `json:"delta_push_doc_count"`
-	DeltasRequested           *SgwIntStat `json:"deltas_requested"`
-	DeltasSent                *SgwIntStat `json:"deltas_sent"`
+	DeltaCacheHit             *SgwIntStat `json:"delta_cache_hit" kind:"counter" unit:"count" help:"Delta requests served from the delta cache"`
+	DeltaCacheMiss            *SgwIntStat `json:"delta_cache_miss" kind:"counter" unit:"count" help:"Delta requests that had to generate the delta"`
+	DeltaPullReplicationCount *SgwIntStat `json:"delta_pull_replication_count" kind:"counter" unit:"count" help:"Pull replications using deltas"`
+	DeltaPushDocCount         *SgwIntStat `json:"delta_push_doc_count" kind:"counter" unit:"count" help:"Documents pushed as deltas"`
+	DeltasRequested           *SgwIntStat `json:"deltas_requested" kind:"counter" unit:"count" help:"Revisions requested as deltas"`
+	DeltasSent                *SgwIntStat `json:"deltas_sent" kind:"counter" unit:"count" help:"Revisions sent as deltas"`
 }
 
 type QueryStats struct {
@@ -335,49 +409,127 @@ type QueryStats struct {
 	mutex sync.Mutex
 }
 
+// ChannelWebhookStats are the delivery stats of a channel webhook endpoint.
+type ChannelWebhookStats struct {
+	CircuitOpen *SgwIntStat `json:"circuit_open" kind:"gauge" unit:"boolean" help:"Whether deliveries are skipped after repeated failures"`
+	Delivered   *SgwIntStat `json:"delivered" kind:"counter" unit:"count" help:"Notifications delivered"`
+	Dropped     *SgwIntStat `json:"dropped" kind:"counter" unit:"count" help:"Notifications dropped because the delivery queue was full"`
+	Failed      *SgwIntStat `json:"failed" kind:"counter" unit:"count" help:"Deliveries abandoned after exhausting their retries"`
+	Retries     *SgwIntStat `json:"retries" kind:"counter" unit:"count" help:"Delivery retries"`
+	Skipped     *SgwIntStat `json:"skipped" kind:"counter" unit:"count" help:"Deliveries skipped while the circuit was open"`
+}
+
+// Max channels ChannelCacheAccessStats are kept for.  Requests for further channels are only counted in aggregate.
+const MaxChannelCacheAccessStatsChannels = 1000
+
+// ChannelCacheAccessStats counts, per channel, the changes requests served entirely from the channel cache (hits),
+// those that also required a backfill query (partial hits), and those served by query alone (misses).  Channel names
+// are tagged as user data when published.
+type ChannelCacheAccessStats struct {
+	channels map[string]*ChannelCacheAccessCounts
+	lock     sync.RWMutex
+}
+
+// ChannelCacheAccessCounts are a channel's cache access counts.  Updated atomically.
+type ChannelCacheAccessCounts struct {
+	Hits        int64 `json:"hits"`
+	PartialHits int64 `json:"partial_hits"`
+	Misses      int64 `json:"misses"`
+}
+
+// Channel returns the access counts for the channel, creating them if needed.  Returns nil once counts are kept for
+// MaxChannelCacheAccessStatsChannels other channels.
+func (s *ChannelCacheAccessStats) Channel(channelName string) *ChannelCacheAccessCounts {
+	s.lock.RLock()
+	counts, ok := s.channels[channelName]
+	s.lock.RUnlock()
+	if ok {
+		return counts
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if counts, ok := s.channels[channelName]; ok {
+		return counts
+	}
+	if len(s.channels) >= MaxChannelCacheAccessStatsChannels {
+		return nil
+	}
+	if s.channels == nil {
+		s.channels = map[string]*ChannelCacheAccessCounts{}
+	}
+	counts = &ChannelCacheAccessCounts{}
+	s.channels[channelName] = counts
+	return counts
+}
+
+func (s *ChannelCacheAccessStats) MarshalJSON() ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	ret := make(map[string]ChannelCacheAccessCounts, len(s.channels))
+	for channelName, counts := range s.channels {
+		ret[UD(channelName).Redact()] = ChannelCacheAccessCounts{
+			Hits:        atomic.LoadInt64(&counts.Hits),
+			PartialHits: atomic.LoadInt64(&counts.PartialHits),
+			Misses:      atomic.LoadInt64(&counts.Misses),
+		}
+	}
+	return JSONMarshalCanonical(ret)
+}
+
 type DbReplicatorStats struct {
-	NumAttachmentBytesPushed *SgwIntStat `json:"sgr_num_attachment_bytes_pushed"`
-	NumAttachmentPushed      *SgwIntStat `json:"sgr_num_attachments_pushed"`
-	NumDocPushed             *SgwIntStat `json:"sgr_num_docs_pushed"`
-	NumDocsFailedToPush      *SgwIntStat `json:"sgr_num_docs_failed_to_push"`
-	PushConflictCount        *SgwIntStat `json:"sgr_push_conflict_count"`
-	PushRejectedCount        *SgwIntStat `json:"sgr_push_rejected_count"`
-	PushDeltaSentCount       *SgwIntStat `json:"sgr_deltas_sent"`
-	DocsCheckedSent          *SgwIntStat `json:"sgr_docs_checked_sent" `
-	NumConnectAttemptsPull   *SgwIntStat `json:"sgr_num_connect_attempts_pull"`
-	NumReconnectsAbortedPull *SgwIntStat `json:"sgr_num_reconnects_aborted_pull"`
-
-	NumAttachmentBytesPulled *SgwIntStat `json:"sgr_num_attachment_bytes_pulled"`
-	NumAttachmentsPulled     *SgwIntStat `json:"sgr_num_attachments_pulled"`
-	PulledCount              *SgwIntStat `json:"sgr_num_docs_pulled"`
-	PurgedCount              *SgwIntStat `json:"sgr_num_docs_purged"`
-	FailedToPullCount        *SgwIntStat `json:"sgr_num_docs_failed_to_pull"`
-	DeltaReceivedCount       *SgwIntStat `json:"sgr_deltas_recv"`
-	DeltaRequestedCount      *SgwIntStat `json:"sgr_deltas_requested"`
-	DocsCheckedReceived      *SgwIntStat `json:"sgr_docs_checked_recv"`
-	NumConnectAttemptsPush   *SgwIntStat `json:"sgr_num_connect_attempts_push"`
-	NumReconnectsAbortedPush *SgwIntStat `json:"sgr_num_reconnects_aborted_push"`
-
-	ConflictResolvedLocalCount  *SgwIntStat `json:"sgr_conflict_resolved_local_count"`
-	ConflictResolvedRemoteCount *SgwIntStat `json:"sgr_conflict_resolved_remote_count"`
-	ConflictResolvedMergedCount *SgwIntStat `json:"sgr_conflict_resolved_merge_count"`
+	NumAttachmentBytesPushed *SgwIntStat `json:"sgr_num_attachment_bytes_pushed" kind:"counter" unit:"bytes" help:"Attachment bytes pushed"`
+	NumAttachmentPushed      *SgwIntStat `json:"sgr_num_attachments_pushed" kind:"counter" unit:"count" help:"Attachments pushed"`
+	NumAttachmentPushedKnown *SgwIntStat `json:"sgr_num_attachments_pushed_known" kind:"counter" unit:"count" help:"Pushed attachments already known to the remote"`
+	NumDocPushed             *SgwIntStat `json:"sgr_num_docs_pushed" kind:"counter" unit:"count" help:"Documents pushed"`
+	NumDocsFailedToPush      *SgwIntStat `json:"sgr_num_docs_failed_to_push" kind:"counter" unit:"count" help:"Documents that failed to push"`
+	PushConflictCount        *SgwIntStat `json:"sgr_push_conflict_count" kind:"counter" unit:"count" help:"Pushed documents rejected as conflicts"`
+	PushRejectedCount        *SgwIntStat `json:"sgr_push_rejected_count" kind:"counter" unit:"count" help:"Pushed documents rejected by the remote"`
+	PushDeltaSentCount       *SgwIntStat `json:"sgr_deltas_sent" kind:"counter" unit:"count" help:"Revisions pushed as deltas"`
+	DocsCheckedSent          *SgwIntStat `json:"sgr_docs_checked_sent" kind:"counter" unit:"count" help:"Documents checked for pushing"`
+	NumConnectAttemptsPull   *SgwIntStat `json:"sgr_num_connect_attempts_pull" kind:"counter" unit:"count" help:"Pull connection attempts"`
+	NumReconnectsAbortedPull *SgwIntStat `json:"sgr_num_reconnects_aborted_pull" kind:"counter" unit:"count" help:"Pull reconnections abandoned"`
+
+	NumAttachmentBytesPulled  *SgwIntStat `json:"sgr_num_attachment_bytes_pulled" kind:"counter" unit:"bytes" help:"Attachment bytes pulled"`
+	NumAttachmentsPulled      *SgwIntStat `json:"sgr_num_attachments_pulled" kind:"counter" unit:"count" help:"Attachments pulled"`
+	NumAttachmentsPulledKnown *SgwIntStat `json:"sgr_num_attachments_pulled_known" kind:"counter" unit:"count" help:"Pulled attachments already known locally"`
+	PulledCount               *SgwIntStat `json:"sgr_num_docs_pulled" kind:"counter" unit:"count" help:"Documents pulled"`
+	PurgedCount               *SgwIntStat `json:"sgr_num_docs_purged" kind:"counter" unit:"count" help:"Documents purged after pulling"`
+	FailedToPullCount         *SgwIntStat `json:"sgr_num_docs_failed_to_pull" kind:"counter" unit:"count" help:"Documents that failed to pull"`
+	DeltaReceivedCount        *SgwIntStat `json:"sgr_deltas_recv" kind:"counter" unit:"count" help:"Revisions pulled as deltas"`
+	DeltaRequestedCount       *SgwIntStat `json:"sgr_deltas_requested" kind:"counter" unit:"count" help:"Revisions requested as deltas"`
+	DocsCheckedReceived       *SgwIntStat `json:"sgr_docs_checked_recv" kind:"counter" unit:"count" help:"Documents checked for pulling"`
+	NumConnectAttemptsPush    *SgwIntStat `json:"sgr_num_connect_attempts_push" kind:"counter" unit:"count" help:"Push connection attempts"`
+	NumReconnectsAbortedPush  *SgwIntStat `json:"sgr_num_reconnects_aborted_push" kind:"counter" unit:"count" help:"Push reconnections abandoned"`
+
+	ConflictResolvedLocalCount  *SgwIntStat `json:"sgr_conflict_resolved_local_count" kind:"counter" unit:"count" help:"Conflicts resolved in favour of the local revision"`
+	ConflictResolvedRemoteCount *SgwIntStat `json:"sgr_conflict_resolved_remote_count" kind:"counter" unit:"count" help:"Conflicts resolved in favour of the remote revision"`
+	ConflictResolvedMergedCount *SgwIntStat `json:"sgr_conflict_resolved_merge_count" kind:"counter" unit:"count" help:"Conflicts resolved by merging"`
 }
 
 type SecurityStats struct {
-	AuthFailedCount  *SgwIntStat `json:"auth_failed_count"`
-	AuthSuccessCount *SgwIntStat `json:"auth_success_count"`
-	NumAccessErrors  *SgwIntStat `json:"num_access_errors"`
-	NumDocsRejected  *SgwIntStat `json:"num_docs_rejected"`
-	TotalAuthTime    *SgwIntStat `json:"total_auth_time"`
+	AuthFailedCount  *SgwIntStat `json:"auth_failed_count" kind:"counter" unit:"count" help:"Failed authentications"`
+	AuthSuccessCount *SgwIntStat `json:"auth_success_count" kind:"counter" unit:"count" help:"Successful authentications"`
+	NumAccessErrors  *SgwIntStat `json:"num_access_errors" kind:"counter" unit:"count" help:"Writes rejected for lack of channel access"`
+	NumDocsRejected  *SgwIntStat `json:"num_docs_rejected" kind:"counter" unit:"count" help:"Writes rejected by the sync function"`
+	TotalAuthTime    *SgwIntStat `json:"total_auth_time" kind:"gauge" unit:"nanoseconds" help:"Time spent authenticating"`
 }
 
 type SharedBucketImportStats struct {
-	ImportCount          *SgwIntStat `json:"import_count"`
-	ImportCancelCAS      *SgwIntStat `json:"import_cancel_cas"`
-	ImportErrorCount     *SgwIntStat `json:"import_error_count"`
-	ImportProcessingTime *SgwIntStat `json:"import_processing_time"`
-	ImportHighSeq        *SgwIntStat `json:"import_high_seq"`
-	ImportPartitions     *SgwIntStat `json:"import_partitions"`
+	ImportCount                *SgwIntStat       `json:"import_count" kind:"counter" unit:"count" help:"Documents imported"`
+	ImportCancelCAS            *SgwIntStat       `json:"import_cancel_cas" kind:"counter" unit:"count" help:"Imports cancelled by a concurrent update"`
+	ImportErrorCount           *SgwIntStat       `json:"import_error_count" kind:"counter" unit:"count" help:"Imports that failed"`
+	ImportProcessingTime       *SgwIntStat       `json:"import_processing_time" kind:"gauge" unit:"nanoseconds" help:"Time spent importing documents"`
+	ImportHighSeq              *SgwIntStat       `json:"import_high_seq" kind:"counter" unit:"sequence" help:"Highest sequence assigned by import"`
+	ImportPartitions           *SgwIntStat       `json:"import_partitions" kind:"gauge" unit:"count" help:"Import partitions"`
+	ImportLargeDocCount        *SgwIntStat       `json:"import_large_doc_count" kind:"counter" unit:"count" help:"Documents imported with a body over the large document size"`
+	ImportMaxVbLag             *SgwIntStat       `json:"import_max_vb_lag" kind:"gauge" unit:"seconds" help:"Largest time since a vbucket last received a mutation on the import feed"`
+	ImportCheckpointsCollected *SgwIntStat       `json:"import_checkpoints_collected" kind:"counter" unit:"count" help:"Obsolete import checkpoints deleted"`
+	ImportSuppressedCount      *SgwIntStat       `json:"import_suppressed_count" kind:"counter" unit:"count" help:"Imports suppressed for documents under import suppression"`
+	ImportInFlightBytes        *SgwIntStat       `json:"import_in_flight_bytes" kind:"gauge" unit:"bytes" help:"Body bytes of documents being imported"`
+	ImportBudgetWaitCount      *SgwIntStat       `json:"import_budget_wait_count" kind:"counter" unit:"count" help:"Imports that waited for the import budget"`
+	ImportBudgetDeferredCount  *SgwIntStat       `json:"import_budget_deferred_count" kind:"counter" unit:"count" help:"Imports deferred to the next mutation as over the import budget"`
+	ImportTime                 *SgwHistogramStat `json:"import_time" kind:"histogram" unit:"nanoseconds" help:"Sampled time spent importing documents"`
 }
 
 type SgwStat struct {
@@ -465,6 +617,183 @@ func (s *SgwIntStat) Value() int64 {
 	return atomic.LoadInt64(&s.Val)
 }
 
+// shardedIntStatShards is the number of shards in a ShardedIntStat.  Must be a power of two.
+const shardedIntStatShards = 16
+
+// DefaultShardedIntStatFlushEvery is the number of adds to a shard of a ShardedIntStat after which the shard is folded
+// into the total.
+const DefaultShardedIntStatFlushEvery = 1024
+
+// statShard occupies its own cache line, so that concurrent updates to adjacent shards don't contend.
+type statShard struct {
+	val  int64 // Unflushed count
+	adds int64 // Adds made to the shard, for flushing every flushEvery adds
+	_    [48]byte
+}
+
+// ShardedIntStat is an integer counter for hot paths with many concurrent writers, such as the DCP feed workers.
+// Writers add to one of a number of cache line padded shards, selected by a caller provided key (e.g. vbucket
+// number), instead of contending on a single value.  Reads sum the flushed total and the shards, so are always
+// complete.  Each shard is folded into the total every flushEvery adds to it, and Flush folds all of them.
+type ShardedIntStat struct {
+	SgwStat
+	Val        int64                           // Total flushed from shards
+	shards     [shardedIntStatShards]statShard // Unflushed counts
+	flushEvery int64                           // Adds to a shard between flushes of that shard.  Zero only flushes on Flush
+	flushLock  sync.RWMutex                    // Excludes reads while shards are being flushed, so counts in flight aren't missed
+}
+
+func NewShardedIntStat(subsystem string, key string, labelKeys []string, labelVals []string, statValueType prometheus.ValueType, initialValue int64) *ShardedIntStat {
+	stat := &ShardedIntStat{
+		SgwStat:    *newSGWStat(subsystem, key, labelKeys, labelVals, statValueType),
+		Val:        initialValue,
+		flushEvery: DefaultShardedIntStatFlushEvery,
+	}
+	prometheus.MustRegister(stat)
+	return stat
+}
+
+func (s *ShardedIntStat) Describe(ch chan<- *prometheus.Desc) {
+	return
+}
+
+func (s *ShardedIntStat) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(s.statDesc, s.statValueType, float64(s.Value()), s.labelValues...)
+}
+
+// Add adds newV to the shard for key, flushing the shard every flushEvery adds.  Writers using distinct keys don't
+// contend.
+func (s *ShardedIntStat) Add(key uint16, newV int64) {
+	shard := &s.shards[key&(shardedIntStatShards-1)]
+	atomic.AddInt64(&shard.val, newV)
+	if s.flushEvery > 0 && atomic.AddInt64(&shard.adds, 1)%s.flushEvery == 0 {
+		s.flushLock.Lock()
+		s._flushShard(shard)
+		s.flushLock.Unlock()
+	}
+}
+
+// Flush folds the shards into the flushed total.  Adds made during a flush are either included in it, or remain in
+// their shard for the next, so are never lost.
+func (s *ShardedIntStat) Flush() {
+	s.flushLock.Lock()
+	defer s.flushLock.Unlock()
+	for i := range s.shards {
+		s._flushShard(&s.shards[i])
+	}
+}
+
+// _flushShard folds a shard into the flushed total.  Requires flushLock.
+func (s *ShardedIntStat) _flushShard(shard *statShard) {
+	if v := atomic.SwapInt64(&shard.val, 0); v != 0 {
+		atomic.AddInt64(&s.Val, v)
+	}
+}
+
+func (s *ShardedIntStat) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(s.Value(), 10)), nil
+}
+
+func (s *ShardedIntStat) String() string {
+	return strconv.FormatInt(s.Value(), 10)
+}
+
+func (s *ShardedIntStat) Value() int64 {
+	s.flushLock.RLock()
+	defer s.flushLock.RUnlock()
+	total := atomic.LoadInt64(&s.Val)
+	for i := range s.shards {
+		total += atomic.LoadInt64(&s.shards[i].val)
+	}
+	return total
+}
+
+// SgwHistogramStat is a distribution of observed integer values, reported to Prometheus as a histogram.  Each value is
+// counted in the first bucket whose upper bound it doesn't exceed - values over the largest bound are only included in
+// the total count and sum.
+type SgwHistogramStat struct {
+	SgwStat
+	upperBounds []int64  // Bucket upper bounds, ascending
+	counts      []uint64 // Observations per bucket (not cumulative).  The extra final bucket counts values over the largest bound
+	count       uint64   // Total observations
+	sum         int64    // Sum of all observed values
+}
+
+func NewHistogramStat(subsystem string, key string, labelKeys []string, labelVals []string, upperBounds []int64) *SgwHistogramStat {
+	stat := &SgwHistogramStat{
+		SgwStat:     *newSGWStat(subsystem, key, labelKeys, labelVals, prometheus.UntypedValue),
+		upperBounds: upperBounds,
+		counts:      make([]uint64, len(upperBounds)+1),
+	}
+	prometheus.MustRegister(stat)
+	return stat
+}
+
+func (s *SgwHistogramStat) Describe(ch chan<- *prometheus.Desc) {
+	return
+}
+
+func (s *SgwHistogramStat) Collect(ch chan<- prometheus.Metric) {
+	snapshot := s.Snapshot()
+	ch <- prometheus.MustNewConstHistogram(s.statDesc, snapshot.Count, float64(snapshot.Sum), snapshot.cumulativeBuckets(), s.labelValues...)
+}
+
+// Observe adds value to the distribution.
+func (s *SgwHistogramStat) Observe(value int64) {
+	i := sort.Search(len(s.upperBounds), func(i int) bool { return value <= s.upperBounds[i] })
+	atomic.AddUint64(&s.counts[i], 1)
+	atomic.AddUint64(&s.count, 1)
+	atomic.AddInt64(&s.sum, value)
+}
+
+// HistogramSnapshot is a point in time copy of an SgwHistogramStat.
+type HistogramSnapshot struct {
+	Count       uint64   `json:"count"`
+	Sum         int64    `json:"sum"`
+	UpperBounds []int64  `json:"upper_bounds"`
+	Counts      []uint64 `json:"counts"` // Observations per upper bound, not cumulative.  The final count is of observations over the largest bound
+}
+
+// Snapshot returns the current distribution.  An observation made concurrently with the snapshot may be included in
+// some of its counts but not others.
+func (s *SgwHistogramStat) Snapshot() HistogramSnapshot {
+	snapshot := HistogramSnapshot{
+		UpperBounds: s.upperBounds,
+		Counts:      make([]uint64, len(s.counts)),
+	}
+	for i := range s.counts {
+		snapshot.Counts[i] = atomic.LoadUint64(&s.counts[i])
+	}
+	snapshot.Count = atomic.LoadUint64(&s.count)
+	snapshot.Sum = atomic.LoadInt64(&s.sum)
+	return snapshot
+}
+
+// cumulativeBuckets returns the count of observations at or under each upper bound, as reported to Prometheus.
+func (h HistogramSnapshot) cumulativeBuckets() map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(h.UpperBounds))
+	var cumulative uint64
+	for i, upperBound := range h.UpperBounds {
+		cumulative += h.Counts[i]
+		buckets[float64(upperBound)] = cumulative
+	}
+	return buckets
+}
+
+// Count returns the total number of observations.
+func (s *SgwHistogramStat) Count() uint64 {
+	return atomic.LoadUint64(&s.count)
+}
+
+func (s *SgwHistogramStat) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Snapshot())
+}
+
+func (s *SgwHistogramStat) String() string {
+	data, _ := s.MarshalJSON()
+	return string(data)
+}
+
 func NewFloatStat(subsystem string, key string, labelKeys []string, labelVals []string, statValueType prometheus.ValueType, initialValue float64) *SgwFloatStat {
 	stat := &SgwFloatStat{
 		SgwStat: *newSGWStat(subsystem, key, labelKeys, labelVals, statValueType),
@@ -565,9 +894,9 @@ func (s *SgwDurStat) String() string {
 }
 
 type QueryStat struct {
-	QueryCount      *SgwIntStat
-	QueryErrorCount *SgwIntStat
-	QueryTime       *SgwIntStat
+	QueryCount      *SgwIntStat `json:"query_count" kind:"counter" unit:"count" help:"Query executions"`
+	QueryErrorCount *SgwIntStat `json:"query_error_count" kind:"counter" unit:"count" help:"Query executions that failed"`
+	QueryTime       *SgwIntStat `json:"query_time" kind:"counter" unit:"nanoseconds" help:"Time spent executing the query"`
 }
 
 func (s *SgwStats) NewDBStats(name string, deltaSyncEnabled bool, importEnabled bool, viewsEnabled bool, queryNames ...string) *DbStats {
@@ -619,30 +948,69 @@ func (d *DbStats) initCacheStats() {
 	d.CacheStats = &CacheStats{
 		AbandonedSeqs:                       NewIntStat(SubsystemCacheKey, "abandoned_seqs", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCacheRevsActive:              NewIntStat(SubsystemCacheKey, "chan_cache_active_revs", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		ChannelCacheBackfillMerged:          NewIntStat(SubsystemCacheKey, "chan_cache_backfill_merged", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCacheBypassCount:             NewIntStat(SubsystemCacheKey, "chan_cache_bypass_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCacheChannelsAdded:           NewIntStat(SubsystemCacheKey, "chan_cache_channels_added", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCacheChannelsEvictedInactive: NewIntStat(SubsystemCacheKey, "chan_cache_channels_evicted_inactive", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCacheChannelsEvictedNRU:      NewIntStat(SubsystemCacheKey, "chan_cache_channels_evicted_nru", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCacheCompactCount:            NewIntStat(SubsystemCacheKey, "chan_cache_compact_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCacheCompactTime:             NewIntStat(SubsystemCacheKey, "chan_cache_compact_time", labelKeys, labelVals, prometheus.CounterValue, 0),
+		ChannelCacheCorruptEntries:          NewIntStat(SubsystemCacheKey, "chan_cache_corrupt_entries", labelKeys, labelVals, prometheus.CounterValue, 0),
+		ChannelCacheHandoffInvalidated:      NewIntStat(SubsystemCacheKey, "chan_cache_handoff_invalidated", labelKeys, labelVals, prometheus.CounterValue, 0),
+		ChannelCacheHandoffSeeded:           NewIntStat(SubsystemCacheKey, "chan_cache_handoff_seeded", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCacheHits:                    NewIntStat(SubsystemCacheKey, "chan_cache_hits", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCacheMaxEntries:              NewIntStat(SubsystemCacheKey, "chan_cache_max_entries", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		ChannelCacheMemoryBytes:             NewIntStat(SubsystemCacheKey, "chan_cache_memory_bytes", labelKeys, labelVals, prometheus.GaugeValue, 0),
 		ChannelCacheMisses:                  NewIntStat(SubsystemCacheKey, "chan_cache_misses", labelKeys, labelVals, prometheus.CounterValue, 0),
+		ChannelCacheNegativeHits:            NewIntStat(SubsystemCacheKey, "chan_cache_negative_hits", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCacheNumChannels:             NewIntStat(SubsystemCacheKey, "chan_cache_num_channels", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		ChannelCachePartialHits:             NewIntStat(SubsystemCacheKey, "chan_cache_partial_hits", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCachePendingQueries:          NewIntStat(SubsystemCacheKey, "chan_cache_pending_queries", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		ChannelCacheRemovalsPruned:          NewIntStat(SubsystemCacheKey, "chan_cache_removals_pruned", labelKeys, labelVals, prometheus.CounterValue, 0),
+		ChannelCacheRemovalsRetained:        NewIntStat(SubsystemCacheKey, "chan_cache_removals_retained", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ChannelCacheRevsRemoval:             NewIntStat(SubsystemCacheKey, "chan_cache_removal_revs", labelKeys, labelVals, prometheus.GaugeValue, 0),
 		ChannelCacheRevsTombstone:           NewIntStat(SubsystemCacheKey, "chan_cache_tombstone_revs", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		ChannelCacheSlowBackfillCount:       NewIntStat(SubsystemCacheKey, "chan_cache_slow_backfill_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		ChannelVerificationCount:            NewIntStat(SubsystemCacheKey, "channel_verification_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		ChannelVerificationDivergedCount:    NewIntStat(SubsystemCacheKey, "channel_verification_diverged_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		DiscardedFeedSeqCount:               NewIntStat(SubsystemCacheKey, "discarded_feed_seq_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		EmptyMetadataCount:                  NewIntStat(SubsystemCacheKey, "empty_metadata_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		FeedParseErrorCount:                 NewIntStat(SubsystemCacheKey, "feed_parse_error_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		FeedStageImportCheckTime:            NewHistogramStat(SubsystemCacheKey, "feed_stage_import_check_time", labelKeys, labelVals, FeedStageTimeBuckets),
+		FeedStageLockHeldTime:               NewHistogramStat(SubsystemCacheKey, "feed_stage_lock_held_time", labelKeys, labelVals, FeedStageTimeBuckets),
+		FeedStageLockWaitTime:               NewHistogramStat(SubsystemCacheKey, "feed_stage_lock_wait_time", labelKeys, labelVals, FeedStageTimeBuckets),
+		FeedStageNotifyTime:                 NewHistogramStat(SubsystemCacheKey, "feed_stage_notify_time", labelKeys, labelVals, FeedStageTimeBuckets),
+		FeedStageUnmarshalTime:              NewHistogramStat(SubsystemCacheKey, "feed_stage_unmarshal_time", labelKeys, labelVals, FeedStageTimeBuckets),
+		HighFeedLag:                         NewIntStat(SubsystemCacheKey, "high_feed_lag", labelKeys, labelVals, prometheus.CounterValue, 0),
 		HighSeqCached:                       NewIntStat(SubsystemCacheKey, "high_seq_cached", labelKeys, labelVals, prometheus.CounterValue, 0),
 		HighSeqStable:                       NewIntStat(SubsystemCacheKey, "high_seq_stable", labelKeys, labelVals, prometheus.CounterValue, 0),
+		MaxFeedLag:                          NewIntStat(SubsystemCacheKey, "max_feed_lag", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		MaxSequenceGap:                      NewIntStat(SubsystemCacheKey, "max_sequence_gap", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		MaxVbLag:                            NewIntStat(SubsystemCacheKey, "max_vb_lag", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		MeanFeedLag:                         NewIntStat(SubsystemCacheKey, "mean_feed_lag", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		MetadataEventCount:                  NewIntStat(SubsystemCacheKey, "metadata_event_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		MetadataEventTime:                   NewIntStat(SubsystemCacheKey, "metadata_event_time", labelKeys, labelVals, prometheus.CounterValue, 0),
 		NonMobileIgnoredCount:               NewIntStat(SubsystemCacheKey, "non_mobile_ignored_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 		NumActiveChannels:                   NewIntStat(SubsystemCacheKey, "num_active_channels", labelKeys, labelVals, prometheus.GaugeValue, 0),
 		NumSkippedSeqs:                      NewIntStat(SubsystemCacheKey, "num_skipped_seqs", labelKeys, labelVals, prometheus.CounterValue, 0),
+		OldestSkippedSeqAge:                 NewIntStat(SubsystemCacheKey, "oldest_skipped_seq_age", labelKeys, labelVals, prometheus.GaugeValue, 0),
 		PendingSeqLen:                       NewIntStat(SubsystemCacheKey, "pending_seq_len", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		PendingSeqBytes:                     NewIntStat(SubsystemCacheKey, "pending_seq_bytes", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		PendingSeqMaxWait:                   NewIntStat(SubsystemCacheKey, "pending_seq_max_wait", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		PendingSeqSkippedForwardCount:       NewIntStat(SubsystemCacheKey, "pending_seq_skipped_forward_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		PendingSeqWait:                      NewHistogramStat(SubsystemCacheKey, "pending_seq_wait", labelKeys, labelVals, PendingSeqWaitBuckets),
+		PrincipalParseErrorCount:            NewIntStat(SubsystemCacheKey, "principal_parse_error_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 		RevisionCacheBypass:                 NewIntStat(SubsystemCacheKey, "rev_cache_bypass", labelKeys, labelVals, prometheus.GaugeValue, 0),
 		RevisionCacheHits:                   NewIntStat(SubsystemCacheKey, "rev_cache_hits", labelKeys, labelVals, prometheus.CounterValue, 0),
 		RevisionCacheMisses:                 NewIntStat(SubsystemCacheKey, "rev_cache_misses", labelKeys, labelVals, prometheus.CounterValue, 0),
+		RollbackCount:                       NewIntStat(SubsystemCacheKey, "rollback_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		RolledBackEntryCount:                NewIntStat(SubsystemCacheKey, "rolled_back_entry_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		SequenceGap:                         NewIntStat(SubsystemCacheKey, "sequence_gap", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		SequenceWaitTimeoutCount:            NewIntStat(SubsystemCacheKey, "sequence_wait_timeout", labelKeys, labelVals, prometheus.CounterValue, 0),
 		SkippedSeqLen:                       NewIntStat(SubsystemCacheKey, "skipped_seq_len", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		UnbufferedLateSeqCount:              NewIntStat(SubsystemCacheKey, "unbuffered_late_seq_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ViewQueries:                         NewIntStat(SubsystemCacheKey, "view_queries", labelKeys, labelVals, prometheus.CounterValue, 0),
+		ChannelAccess:                       &ChannelCacheAccessStats{},
 	}
 }
 
@@ -656,6 +1024,10 @@ func (d *DbStats) initCBLReplicationPullStats() {
 	d.CBLReplicationPullStats = &CBLReplicationPullStats{
 		AttachmentPullBytes:         NewIntStat(SubsystemReplicationPull, "attachment_pull_bytes", labelKeys, labelVals, prometheus.CounterValue, 0),
 		AttachmentPullCount:         NewIntStat(SubsystemReplicationPull, "attachment_pull_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		ChangesCompressedBytes:      NewIntStat(SubsystemReplicationPull, "changes_compressed_bytes", labelKeys, labelVals, prometheus.CounterValue, 0),
+		ChangesCompressionRatio:     NewFloatStat(SubsystemReplicationPull, "changes_compression_ratio", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		ChangesCompressionSaved:     NewIntStat(SubsystemReplicationPull, "changes_compression_saved_bytes", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		ChangesUncompressedBytes:    NewIntStat(SubsystemReplicationPull, "changes_uncompressed_bytes", labelKeys, labelVals, prometheus.CounterValue, 0),
 		MaxPending:                  NewIntStat(SubsystemReplicationPull, "max_pending", labelKeys, labelVals, prometheus.GaugeValue, 0),
 		NumReplicationsActive:       NewIntStat(SubsystemReplicationPull, "num_pull_repl_active_continuous", labelKeys, labelVals, prometheus.GaugeValue, 0),
 		NumPullReplActiveContinuous: NewIntStat(SubsystemReplicationPull, "num_pull_repl_active_one_shot", labelKeys, labelVals, prometheus.GaugeValue, 0),
@@ -683,6 +1055,7 @@ func (d *DbStats) initCBLReplicationPushStats() {
 	d.CBLReplicationPushStats = &CBLReplicationPushStats{
 		AttachmentPushBytes: NewIntStat(SubsystemReplicationPush, "attachment_push_bytes", labelKeys, labelVals, prometheus.CounterValue, 0),
 		AttachmentPushCount: NewIntStat(SubsystemReplicationPush, "attachment_push_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		AttachmentPushKnown: NewIntStat(SubsystemReplicationPush, "attachment_push_known", labelKeys, labelVals, prometheus.CounterValue, 0),
 		DocPushCount:        NewIntStat(SubsystemReplicationPush, "doc_push_count", labelKeys, labelVals, prometheus.GaugeValue, 0),
 		ProposeChangeCount:  NewIntStat(SubsystemReplicationPush, "propose_change_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ProposeChangeTime:   NewIntStat(SubsystemReplicationPush, "propose_change_time", labelKeys, labelVals, prometheus.CounterValue, 0),
@@ -702,21 +1075,28 @@ func (d *DbStats) initDatabaseStats() {
 	d.DatabaseStats = &DatabaseStats{
 		ConflictWriteCount:      NewIntStat(SubsystemDatabaseKey, "conflict_write_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 		Crc32MatchCount:         NewIntStat(SubsystemDatabaseKey, "crc32c_match_count", labelKeys, labelVals, prometheus.GaugeValue, 0),
-		DCPCachingCount:         NewIntStat(SubsystemDatabaseKey, "dcp_caching_count", labelKeys, labelVals, prometheus.GaugeValue, 0),
-		DCPCachingTime:          NewIntStat(SubsystemDatabaseKey, "dcp_caching_time", labelKeys, labelVals, prometheus.GaugeValue, 0),
-		DCPReceivedCount:        NewIntStat(SubsystemDatabaseKey, "dcp_received_count", labelKeys, labelVals, prometheus.GaugeValue, 0),
-		DCPReceivedTime:         NewIntStat(SubsystemDatabaseKey, "dcp_received_time", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		DCPCachingCount:         NewShardedIntStat(SubsystemDatabaseKey, "dcp_caching_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		DCPCachingTime:          NewShardedIntStat(SubsystemDatabaseKey, "dcp_caching_time", labelKeys, labelVals, prometheus.CounterValue, 0),
+		DCPReceivedCount:        NewShardedIntStat(SubsystemDatabaseKey, "dcp_received_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		DCPReceivedTime:         NewShardedIntStat(SubsystemDatabaseKey, "dcp_received_time", labelKeys, labelVals, prometheus.CounterValue, 0),
 		DocReadsBytesBlip:       NewIntStat(SubsystemDatabaseKey, "doc_reads_bytes_blip", labelKeys, labelVals, prometheus.CounterValue, 0),
 		DocWritesBytes:          NewIntStat(SubsystemDatabaseKey, "doc_writes_bytes", labelKeys, labelVals, prometheus.CounterValue, 0),
 		DocWritesXattrBytes:     NewIntStat(SubsystemDatabaseKey, "doc_writes_xattr_bytes", labelKeys, labelVals, prometheus.CounterValue, 0),
 		HighSeqFeed:             NewIntStat(SubsystemDatabaseKey, "high_seq_feed", labelKeys, labelVals, prometheus.CounterValue, 0),
 		DocWritesBytesBlip:      NewIntStat(SubsystemDatabaseKey, "doc_writes_bytes_blip", labelKeys, labelVals, prometheus.CounterValue, 0),
 		NumDocReadsBlip:         NewIntStat(SubsystemDatabaseKey, "num_doc_reads_blip", labelKeys, labelVals, prometheus.CounterValue, 0),
+		MetadataDocCount:        NewIntStat(SubsystemDatabaseKey, "metadata_doc_count", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		NeedsResync:             NewIntStat(SubsystemDatabaseKey, "needs_resync", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		NotifyPacingWindow:      NewIntStat(SubsystemDatabaseKey, "notify_pacing_window", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		NumChangesFeedsActive:   NewIntStat(SubsystemDatabaseKey, "num_changes_feeds_active", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		NumChangesFeedsRejected: NewIntStat(SubsystemDatabaseKey, "num_changes_feeds_rejected", labelKeys, labelVals, prometheus.CounterValue, 0),
 		NumDocReadsRest:         NewIntStat(SubsystemDatabaseKey, "num_doc_reads_rest", labelKeys, labelVals, prometheus.CounterValue, 0),
 		NumDocWrites:            NewIntStat(SubsystemDatabaseKey, "num_doc_writes", labelKeys, labelVals, prometheus.CounterValue, 0),
 		NumReplicationsActive:   NewIntStat(SubsystemDatabaseKey, "num_replications_active", labelKeys, labelVals, prometheus.GaugeValue, 0),
 		NumReplicationsTotal:    NewIntStat(SubsystemDatabaseKey, "num_replications_total", labelKeys, labelVals, prometheus.CounterValue, 0),
 		NumTombstonesCompacted:  NewIntStat(SubsystemDatabaseKey, "num_tombstones_compacted", labelKeys, labelVals, prometheus.CounterValue, 0),
+		OldRevBackupCount:       NewIntStat(SubsystemDatabaseKey, "old_rev_backup_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		OldRevNoExpiryCount:     NewIntStat(SubsystemDatabaseKey, "old_rev_no_expiry_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 		SequenceAssignedCount:   NewIntStat(SubsystemDatabaseKey, "sequence_assigned_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 		SequenceGetCount:        NewIntStat(SubsystemDatabaseKey, "sequence_get_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 		SequenceIncrCount:       NewIntStat(SubsystemDatabaseKey, "sequence_incr_count", labelKeys, labelVals, prometheus.CounterValue, 0),
@@ -727,6 +1107,9 @@ func (d *DbStats) initDatabaseStats() {
 		WarnXattrSizeCount:      NewIntStat(SubsystemDatabaseKey, "warn_xattr_size_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 		ImportFeedMapStats:      &ExpVarMapWrapper{new(expvar.Map).Init()},
 		CacheFeedMapStats:       &ExpVarMapWrapper{new(expvar.Map).Init()},
+
+		TombstoneCompactionRunning:     NewIntStat(SubsystemDatabaseKey, "tombstone_compaction_running", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		TombstoneCompactionFailedCount: NewIntStat(SubsystemDatabaseKey, "tombstone_compaction_failed_count", labelKeys, labelVals, prometheus.CounterValue, 0),
 	}
 }
 
@@ -776,6 +1159,7 @@ func (d *DbStats) DBReplicatorStats(replicationID string) *DbReplicatorStats {
 		d.DbReplicatorStats[replicationID] = &DbReplicatorStats{
 			NumAttachmentBytesPushed:    NewIntStat(SubsystemReplication, "sgr_num_attachment_bytes_pushed", labelKeys, labelVals, prometheus.CounterValue, 0),
 			NumAttachmentPushed:         NewIntStat(SubsystemReplication, "sgr_num_attachments_pushed", labelKeys, labelVals, prometheus.CounterValue, 0),
+			NumAttachmentPushedKnown:    NewIntStat(SubsystemReplication, "sgr_num_attachments_pushed_known", labelKeys, labelVals, prometheus.CounterValue, 0),
 			NumDocPushed:                NewIntStat(SubsystemReplication, "sgr_num_docs_pushed", labelKeys, labelVals, prometheus.CounterValue, 0),
 			NumDocsFailedToPush:         NewIntStat(SubsystemReplication, "sgr_num_docs_failed_to_push", labelKeys, labelVals, prometheus.CounterValue, 0),
 			PushConflictCount:           NewIntStat(SubsystemReplication, "sgr_push_conflict_count", labelKeys, labelVals, prometheus.CounterValue, 0),
@@ -786,6 +1170,7 @@ func (d *DbStats) DBReplicatorStats(replicationID string) *DbReplicatorStats {
 			NumReconnectsAbortedPush:    NewIntStat(SubsystemReplication, "sgr_num_reconnects_aborted_push", labelKeys, labelVals, prometheus.CounterValue, 0),
 			NumAttachmentBytesPulled:    NewIntStat(SubsystemReplication, "sgr_num_attachment_bytes_pulled", labelKeys, labelVals, prometheus.CounterValue, 0),
 			NumAttachmentsPulled:        NewIntStat(SubsystemReplication, "sgr_num_attachments_pulled", labelKeys, labelVals, prometheus.CounterValue, 0),
+			NumAttachmentsPulledKnown:   NewIntStat(SubsystemReplication, "sgr_num_attachments_pulled_known", labelKeys, labelVals, prometheus.CounterValue, 0),
 			PulledCount:                 NewIntStat(SubsystemReplication, "sgr_num_docs_pulled", labelKeys, labelVals, prometheus.CounterValue, 0),
 			PurgedCount:                 NewIntStat(SubsystemReplication, "sgr_num_docs_purged", labelKeys, labelVals, prometheus.CounterValue, 0),
 			FailedToPullCount:           NewIntStat(SubsystemReplication, "sgr_num_docs_failed_to_pull", labelKeys, labelVals, prometheus.CounterValue, 0),
@@ -807,6 +1192,7 @@ func (d *DbStats) DBReplicatorStats(replicationID string) *DbReplicatorStats {
 func (dbr *DbReplicatorStats) Reset() {
 	dbr.NumAttachmentBytesPushed.Set(0)
 	dbr.NumAttachmentPushed.Set(0)
+	dbr.NumAttachmentPushedKnown.Set(0)
 	dbr.NumDocPushed.Set(0)
 	dbr.NumDocsFailedToPush.Set(0)
 	dbr.PushConflictCount.Set(0)
@@ -815,6 +1201,7 @@ func (dbr *DbReplicatorStats) Reset() {
 	dbr.DocsCheckedSent.Set(0)
 	dbr.NumAttachmentBytesPulled.Set(0)
 	dbr.NumAttachmentsPulled.Set(0)
+	dbr.NumAttachmentsPulledKnown.Set(0)
 	dbr.PulledCount.Set(0)
 	dbr.PurgedCount.Set(0)
 	dbr.FailedToPullCount.Set(0)
@@ -826,6 +1213,29 @@ func (dbr *DbReplicatorStats) Reset() {
 	dbr.ConflictResolvedMergedCount.Set(0)
 }
 
+// ChannelWebhook returns the delivery stats of the channel webhook endpoint, identified by its URL with any password
+// redacted.
+func (d *DbStats) ChannelWebhook(endpoint string) *ChannelWebhookStats {
+	if d.ChannelWebhookStats == nil {
+		d.ChannelWebhookStats = map[string]*ChannelWebhookStats{}
+	}
+
+	if _, ok := d.ChannelWebhookStats[endpoint]; !ok {
+		labelKeys := []string{DatabaseLabelKey, ChannelWebhookLabelKey}
+		labelVals := []string{d.dbName, endpoint}
+		d.ChannelWebhookStats[endpoint] = &ChannelWebhookStats{
+			CircuitOpen: NewIntStat(SubsystemDatabaseKey, "channel_webhook_circuit_open", labelKeys, labelVals, prometheus.GaugeValue, 0),
+			Delivered:   NewIntStat(SubsystemDatabaseKey, "channel_webhook_delivered", labelKeys, labelVals, prometheus.CounterValue, 0),
+			Dropped:     NewIntStat(SubsystemDatabaseKey, "channel_webhook_dropped", labelKeys, labelVals, prometheus.CounterValue, 0),
+			Failed:      NewIntStat(SubsystemDatabaseKey, "channel_webhook_failed", labelKeys, labelVals, prometheus.CounterValue, 0),
+			Retries:     NewIntStat(SubsystemDatabaseKey, "channel_webhook_retries", labelKeys, labelVals, prometheus.CounterValue, 0),
+			Skipped:     NewIntStat(SubsystemDatabaseKey, "channel_webhook_skipped", labelKeys, labelVals, prometheus.CounterValue, 0),
+		}
+	}
+
+	return d.ChannelWebhookStats[endpoint]
+}
+
 func (d *DbStats) Security() *SecurityStats {
 	return d.SecurityStats
 }
@@ -835,12 +1245,20 @@ func (d *DbStats) InitSharedBucketImportStats() {
 		labelKeys := []string{DatabaseLabelKey}
 		labelVals := []string{d.dbName}
 		d.SharedBucketImportStats = &SharedBucketImportStats{
-			ImportCount:          NewIntStat(SubsystemSharedBucketImport, "import_count", labelKeys, labelVals, prometheus.CounterValue, 0),
-			ImportCancelCAS:      NewIntStat(SubsystemSharedBucketImport, "import_cancel_cas", labelKeys, labelVals, prometheus.CounterValue, 0),
-			ImportErrorCount:     NewIntStat(SubsystemSharedBucketImport, "import_error_count", labelKeys, labelVals, prometheus.CounterValue, 0),
-			ImportProcessingTime: NewIntStat(SubsystemSharedBucketImport, "import_processing_time", labelKeys, labelVals, prometheus.GaugeValue, 0),
-			ImportHighSeq:        NewIntStat(SubsystemSharedBucketImport, "import_high_seq", labelKeys, labelVals, prometheus.CounterValue, 0),
-			ImportPartitions:     NewIntStat(SubsystemSharedBucketImport, "import_partitions", labelKeys, labelVals, prometheus.GaugeValue, 0),
+			ImportCount:                NewIntStat(SubsystemSharedBucketImport, "import_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+			ImportCancelCAS:            NewIntStat(SubsystemSharedBucketImport, "import_cancel_cas", labelKeys, labelVals, prometheus.CounterValue, 0),
+			ImportErrorCount:           NewIntStat(SubsystemSharedBucketImport, "import_error_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+			ImportProcessingTime:       NewIntStat(SubsystemSharedBucketImport, "import_processing_time", labelKeys, labelVals, prometheus.GaugeValue, 0),
+			ImportHighSeq:              NewIntStat(SubsystemSharedBucketImport, "import_high_seq", labelKeys, labelVals, prometheus.CounterValue, 0),
+			ImportPartitions:           NewIntStat(SubsystemSharedBucketImport, "import_partitions", labelKeys, labelVals, prometheus.GaugeValue, 0),
+			ImportLargeDocCount:        NewIntStat(SubsystemSharedBucketImport, "import_large_doc_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+			ImportMaxVbLag:             NewIntStat(SubsystemSharedBucketImport, "import_max_vb_lag", labelKeys, labelVals, prometheus.GaugeValue, 0),
+			ImportCheckpointsCollected: NewIntStat(SubsystemSharedBucketImport, "import_checkpoints_collected", labelKeys, labelVals, prometheus.CounterValue, 0),
+			ImportSuppressedCount:      NewIntStat(SubsystemSharedBucketImport, "import_suppressed_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+			ImportInFlightBytes:        NewIntStat(SubsystemSharedBucketImport, "import_in_flight_bytes", labelKeys, labelVals, prometheus.GaugeValue, 0),
+			ImportBudgetWaitCount:      NewIntStat(SubsystemSharedBucketImport, "import_budget_wait_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+			ImportBudgetDeferredCount:  NewIntStat(SubsystemSharedBucketImport, "import_budget_deferred_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+			ImportTime:                 NewHistogramStat(SubsystemSharedBucketImport, "import_time", labelKeys, labelVals, FeedStageTimeBuckets),
 		}
 	}
 }
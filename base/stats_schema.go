@@ -0,0 +1,136 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Stats are described by tags on their fields in the stats structures, alongside their json tag:
+//
+//	kind: how the stat behaves - one of StatKinds
+//	unit: what the stat's value measures - one of StatUnits
+//	help: a short description of the stat
+//
+// Fields holding other stats structures, or maps of them, are walked to find the stats they contain.  Any field that
+// is neither a stats structure nor tagged as a stat is an error, so new stats can't be added without describing them.
+
+// StatKinds are the valid kind tags of a stat.
+var StatKinds = []string{"counter", "gauge", "histogram", "mean"}
+
+// StatUnits are the valid unit tags of a stat.
+var StatUnits = []string{"boolean", "bytes", "count", "milliseconds", "nanoseconds", "percent", "ratio", "seconds", "sequence"}
+
+// StatSchemaWildcard stands in for map keys, such as database or replication names, in stat paths.
+const StatSchemaWildcard = "*"
+
+// StatSchema describes a stat in the expvar stats output.
+type StatSchema struct {
+	Path        string `json:"path"` // Dot separated path of the stat within the expvar stats output, with map keys as StatSchemaWildcard
+	Kind        string `json:"kind"`
+	Unit        string `json:"unit"`
+	Description string `json:"description"`
+}
+
+// Types of individual stats, which must be tagged
+var statTypes = map[reflect.Type]bool{
+	reflect.TypeOf(&SgwIntStat{}):       true,
+	reflect.TypeOf(&SgwFloatStat{}):     true,
+	reflect.TypeOf(&ShardedIntStat{}):   true,
+	reflect.TypeOf(&SgwHistogramStat{}): true,
+	reflect.TypeOf(&SgwDurStat{}):       true,
+}
+
+// SgwStatsSchema returns the schema of all stats that may be published under SgwStats, sorted by path.  Stats in
+// optional groups, such as delta sync or import stats, are included whether or not any database has them enabled.
+func SgwStatsSchema() ([]StatSchema, error) {
+	return StatsSchema(reflect.TypeOf(SgwStats{}))
+}
+
+// StatsSchema returns the schema of the stats in the given stats structure type, sorted by path.  Returns an error
+// for any stat that isn't fully tagged.
+func StatsSchema(statsType reflect.Type) ([]StatSchema, error) {
+	var schema []StatSchema
+	if err := walkStatsSchema(statsType, "", &schema); err != nil {
+		return nil, err
+	}
+	sort.Slice(schema, func(i, j int) bool {
+		return schema[i].Path < schema[j].Path
+	})
+	return schema, nil
+}
+
+func walkStatsSchema(t reflect.Type, pathPrefix string, schema *[]StatSchema) error {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			return fmt.Errorf("stats field %s.%s has no json name", t.Name(), field.Name)
+		}
+		path := pathPrefix + name
+
+		// Tagged fields are stats, whatever their type - some are maps keyed by channel or replication
+		if _, ok := field.Tag.Lookup("kind"); ok || statTypes[field.Type] {
+			stat, err := statSchemaFromTags(path, field.Tag)
+			if err != nil {
+				return fmt.Errorf("stats field %s.%s: %w", t.Name(), field.Name, err)
+			}
+			*schema = append(*schema, stat)
+			continue
+		}
+
+		var err error
+		switch {
+		case field.Type == reflect.TypeOf(&QueryStats{}):
+			// Query stats are published flattened, with the query name prefixed to the name of each stat
+			err = walkStatsSchema(reflect.TypeOf(QueryStat{}), path+"."+StatSchemaWildcard+"_", schema)
+		case field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct:
+			err = walkStatsSchema(field.Type, path+".", schema)
+		case field.Type.Kind() == reflect.Map && field.Type.Key().Kind() == reflect.String &&
+			field.Type.Elem().Kind() == reflect.Ptr && field.Type.Elem().Elem().Kind() == reflect.Struct:
+			err = walkStatsSchema(field.Type.Elem(), path+"."+StatSchemaWildcard+".", schema)
+		default:
+			err = fmt.Errorf("stats field %s.%s of type %s is neither a stats structure nor tagged as a stat", t.Name(), field.Name, field.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func statSchemaFromTags(path string, tag reflect.StructTag) (StatSchema, error) {
+	stat := StatSchema{
+		Path:        path,
+		Kind:        tag.Get("kind"),
+		Unit:        tag.Get("unit"),
+		Description: tag.Get("help"),
+	}
+	if !StringSliceContains(StatKinds, stat.Kind) {
+		return stat, fmt.Errorf("invalid kind tag %q, must be one of %v", stat.Kind, StatKinds)
+	}
+	if !StringSliceContains(StatUnits, stat.Unit) {
+		return stat, fmt.Errorf("invalid unit tag %q, must be one of %v", stat.Unit, StatUnits)
+	}
+	if stat.Description == "" {
+		return stat, fmt.Errorf("missing help tag")
+	}
+	return stat, nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSgwStatsSchema ensures every stat is tagged with its kind, unit and description, and that the schema covers
+// stats in each group.  A failure here usually means a new stat is missing its tags.
+func TestSgwStatsSchema(t *testing.T) {
+	schema, err := SgwStatsSchema()
+	require.NoError(t, err)
+
+	statsByPath := make(map[string]StatSchema, len(schema))
+	for _, stat := range schema {
+		_, duplicate := statsByPath[stat.Path]
+		assert.False(t, duplicate, "Duplicate stat path %s", stat.Path)
+		statsByPath[stat.Path] = stat
+	}
+
+	expected := []StatSchema{
+		{Path: "global.resource_utilization.error_count", Kind: "counter", Unit: "count"},
+		{Path: "global.resource_utilization.uptime", Kind: "counter", Unit: "nanoseconds"},
+		{Path: "per_db.*.cache.high_seq_stable", Kind: "counter", Unit: "sequence"},
+		{Path: "per_db.*.cache.pending_seq_len", Kind: "gauge", Unit: "count"},
+		{Path: "per_db.*.cache.pending_seq_wait", Kind: "histogram", Unit: "milliseconds"},
+		{Path: "per_db.*.cache.feed_stage_lock_wait_time", Kind: "histogram", Unit: "nanoseconds"},
+		{Path: "per_db.*.cache.chan_cache_channel_access", Kind: "counter", Unit: "count"},
+		{Path: "per_db.*.cbl_replication_pull.changes_compression_ratio", Kind: "gauge", Unit: "ratio"},
+		{Path: "per_db.*.channel_webhooks.*.circuit_open", Kind: "gauge", Unit: "boolean"},
+		{Path: "per_db.*.database.dcp_received_time", Kind: "counter", Unit: "nanoseconds"},
+		{Path: "per_db.*.delta_sync.deltas_sent", Kind: "counter", Unit: "count"},
+		{Path: "per_db.*.gsi_views.*_query_count", Kind: "counter", Unit: "count"},
+		{Path: "per_db.*.replications.*.sgr_num_docs_pushed", Kind: "counter", Unit: "count"},
+		{Path: "per_db.*.shared_bucket_import.import_in_flight_bytes", Kind: "gauge", Unit: "bytes"},
+		{Path: "per_db.*.shared_bucket_import.import_time", Kind: "histogram", Unit: "nanoseconds"},
+		{Path: "per_replication", Kind: "counter", Unit: "count"},
+	}
+	for _, expectedStat := range expected {
+		stat, ok := statsByPath[expectedStat.Path]
+		if !assert.True(t, ok, "Missing stat %s", expectedStat.Path) {
+			continue
+		}
+		assert.Equal(t, expectedStat.Kind, stat.Kind, "Kind of %s", stat.Path)
+		assert.Equal(t, expectedStat.Unit, stat.Unit, "Unit of %s", stat.Path)
+		assert.NotEmpty(t, stat.Description, "Description of %s", stat.Path)
+	}
+}
+
+func TestStatsSchemaRequiresTags(t *testing.T) {
+	type untaggedStats struct {
+		Tagged   *SgwIntStat `json:"tagged" kind:"counter" unit:"count" help:"A tagged stat"`
+		Untagged *SgwIntStat `json:"untagged"`
+	}
+	type invalidKindStats struct {
+		Stat *SgwIntStat `json:"stat" kind:"total" unit:"count" help:"A stat"`
+	}
+	type invalidUnitStats struct {
+		Stat *SgwIntStat `json:"stat" kind:"gauge" unit:"widgets" help:"A stat"`
+	}
+	type missingHelpStats struct {
+		Stat *SgwIntStat `json:"stat" kind:"gauge" unit:"count"`
+	}
+	type unknownFieldStats struct {
+		Value int64 `json:"value"`
+	}
+	type nestedUntaggedStats struct {
+		Group map[string]*untaggedStats `json:"group"`
+	}
+
+	for _, statsType := range []interface{}{
+		untaggedStats{},
+		invalidKindStats{},
+		invalidUnitStats{},
+		missingHelpStats{},
+		unknownFieldStats{},
+		nestedUntaggedStats{},
+	} {
+		t.Run(reflect.TypeOf(statsType).Name(), func(t *testing.T) {
+			_, err := StatsSchema(reflect.TypeOf(statsType))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestStatsSchemaKindsMatchPrometheus ensures the kind tags of stats agree with the value types they're registered
+// with Prometheus as.
+func TestStatsSchemaKindsMatchPrometheus(t *testing.T) {
+	sgwStats := NewSyncGatewayStats()
+	dbStats := sgwStats.NewDBStats("db", true, true, false, "query")
+	dbStats.DBReplicatorStats("replication")
+	dbStats.ChannelWebhook("http://example.com")
+
+	prometheusKinds := map[prometheus.ValueType]string{
+		prometheus.CounterValue: "counter",
+		prometheus.GaugeValue:   "gauge",
+	}
+
+	var checkKinds func(v reflect.Value)
+	checkKinds = func(v reflect.Value) {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return
+			}
+			v = v.Elem()
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			kind := field.Tag.Get("kind")
+			switch stat := v.Field(i).Interface().(type) {
+			case *SgwIntStat:
+				assert.Equal(t, prometheusKinds[stat.statValueType], kind, "Kind of %s", stat.statFQN)
+			case *SgwFloatStat:
+				assert.Equal(t, prometheusKinds[stat.statValueType], kind, "Kind of %s", stat.statFQN)
+			case *ShardedIntStat:
+				assert.Equal(t, prometheusKinds[stat.statValueType], kind, "Kind of %s", stat.statFQN)
+			case *SgwDurStat:
+				assert.Equal(t, prometheusKinds[stat.statValueType], kind, "Kind of %s", stat.statFQN)
+			case *SgwHistogramStat:
+				assert.Equal(t, "histogram", kind, "Kind of %s", stat.statFQN)
+			case *QueryStats:
+				for _, queryStat := range stat.Stats {
+					checkKinds(reflect.ValueOf(queryStat))
+				}
+			default:
+				fieldValue := v.Field(i)
+				switch {
+				case kind != "":
+				case fieldValue.Kind() == reflect.Map:
+					for _, key := range fieldValue.MapKeys() {
+						checkKinds(fieldValue.MapIndex(key))
+					}
+				case fieldValue.Kind() == reflect.Ptr:
+					checkKinds(fieldValue)
+				}
+			}
+		}
+	}
+	checkKinds(reflect.ValueOf(sgwStats))
+}
@@ -12,6 +12,7 @@ package base
 
 import (
 	"expvar"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -131,6 +132,84 @@ func TestSetIfMax(t *testing.T) {
 	assert.Equal(t, float64(100), sgwStats.GlobalStats.ResourceUtilizationStats().CpuPercentUtil.Value())
 }
 
+// BenchmarkFeedStatAddConcurrent compares contention on a single counter with a sharded counter, for 16 concurrent feed
+// workers each adding with their own vbucket.
+func BenchmarkFeedStatAddConcurrent(b *testing.B) {
+	const numWorkers = 16
+	runWorkers := func(b *testing.B, add func(vbNo uint16)) {
+		var wg sync.WaitGroup
+		wg.Add(numWorkers)
+		b.ResetTimer()
+		for w := 0; w < numWorkers; w++ {
+			go func(vbNo uint16) {
+				defer wg.Done()
+				for n := 0; n < b.N/numWorkers; n++ {
+					add(vbNo)
+				}
+			}(uint16(w))
+		}
+		wg.Wait()
+	}
+
+	b.Run("SgwIntStat", func(b *testing.B) {
+		stat := &SgwIntStat{}
+		runWorkers(b, func(vbNo uint16) { stat.Add(1) })
+	})
+	b.Run("ShardedIntStat", func(b *testing.B) {
+		stat := &ShardedIntStat{flushEvery: DefaultShardedIntStatFlushEvery}
+		runWorkers(b, func(vbNo uint16) { stat.Add(vbNo, 1) })
+	})
+}
+
+// TestShardedIntStatNoLostCounts ensures that counts added concurrently with reads and flushes, including the flushes
+// made every flushEvery adds to a shard, are neither lost nor double counted.
+func TestShardedIntStatNoLostCounts(t *testing.T) {
+	const numWorkers = 16
+	const addsPerWorker = 10000
+	stat := &ShardedIntStat{Val: 5, flushEvery: 7}
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for w := 0; w < numWorkers; w++ {
+		go func(vbNo uint16) {
+			defer wg.Done()
+			for n := 0; n < addsPerWorker; n++ {
+				stat.Add(vbNo, 1)
+			}
+		}(uint16(w * 100)) // Spread over vbuckets that map to the same shard, as well as distinct shards
+	}
+
+	// Flush and read while the workers are adding - reads must never go backwards
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	lastValue := stat.Value()
+	for flushing := true; flushing; {
+		select {
+		case <-done:
+			flushing = false
+		default:
+			stat.Flush()
+			value := stat.Value()
+			assert.GreaterOrEqual(t, value, lastValue)
+			lastValue = value
+		}
+	}
+
+	expected := int64(5 + numWorkers*addsPerWorker)
+	assert.Equal(t, expected, stat.Value())
+	assert.Equal(t, "160005", stat.String())
+
+	// Flush at shutdown moves everything into the total, without changing the value
+	stat.Flush()
+	assert.Equal(t, expected, stat.Val)
+	assert.Equal(t, expected, stat.Value())
+	stat.Flush()
+	assert.Equal(t, expected, stat.Value())
+}
+
 func initExpvarBaseEquivalent() *expvar.Map {
 	expvarMap := new(expvar.Map).Init()
 	expvarMap.Set("global", new(expvar.Map).Init())
@@ -162,3 +241,20 @@ func initExpvarBaseEquivalent() *expvar.Map {
 
 	return expvarMap
 }
+
+func TestHistogramStat(t *testing.T) {
+	stat := &SgwHistogramStat{upperBounds: []int64{10, 100}, counts: make([]uint64, 3)}
+	for _, value := range []int64{1, 10, 11, 100, 1000} {
+		stat.Observe(value)
+	}
+
+	snapshot := stat.Snapshot()
+	assert.Equal(t, uint64(5), snapshot.Count)
+	assert.Equal(t, int64(1122), snapshot.Sum)
+	assert.Equal(t, []uint64{2, 2, 1}, snapshot.Counts)
+	assert.Equal(t, map[float64]uint64{10: 2, 100: 4}, snapshot.cumulativeBuckets())
+
+	data, err := stat.MarshalJSON()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"count":5,"sum":1122,"upper_bounds":[10,100],"counts":[2,2,1]}`, string(data))
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Tracer starts spans timing the stages of a request.  A span's parent is the span in the context it's started with,
+// and the returned context carries the new span, so that spans for nested stages are started from it.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string, attributes ...SpanAttribute) (context.Context, Span)
+}
+
+// Span is a timed stage of a request, started by a Tracer.
+type Span interface {
+	SetAttributes(attributes ...SpanAttribute)
+	End()
+}
+
+// SpanAttribute annotates a span.  Values mustn't include user data, as spans are exported without redaction.
+type SpanAttribute struct {
+	Key   string
+	Value interface{}
+}
+
+// tracerHolder wraps the tracer, as atomic.Value requires every value stored to have the same concrete type.
+type tracerHolder struct {
+	tracer Tracer
+}
+
+var tracer atomic.Value
+
+func init() {
+	tracer.Store(tracerHolder{tracer: noopTracer{}})
+}
+
+// SetTracer sets the tracer spans are started with.  A nil tracer disables tracing, which is the default.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracer.Store(tracerHolder{tracer: t})
+}
+
+// StartSpan starts a span with the current tracer, as a child of any span in ctx.  The span must be ended by the caller.
+func StartSpan(ctx context.Context, name string, attributes ...SpanAttribute) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return tracer.Load().(tracerHolder).tracer.StartSpan(ctx, name, attributes...)
+}
+
+// noopTracer starts spans that aren't recorded.
+type noopTracer struct{}
+
+func (noopTracer) StartSpan(ctx context.Context, name string, attributes ...SpanAttribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attributes ...SpanAttribute) {}
+
+func (noopSpan) End() {}
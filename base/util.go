@@ -306,6 +306,16 @@ func SecondsToCbsExpiry(ttl int) uint32 {
 	return DurationToCbsExpiry(time.Duration(ttl) * time.Second)
 }
 
+// ExpiryValue is a document expiry in seconds, relative to the time of the write.  Zero means the document doesn't
+// expire.
+type ExpiryValue uint32
+
+// CbsExpiry returns the expiry formatted as required by CBS - expiries of more than 30 days must be sent to CBS as an
+// absolute unix time, otherwise they're interpreted as a time in 1970 and the document expires immediately.
+func (e ExpiryValue) CbsExpiry() uint32 {
+	return SecondsToCbsExpiry(int(e))
+}
+
 // CbsExpiryToTime takes a CBS expiry and returns as a time
 func CbsExpiryToTime(expiry uint32) time.Time {
 	if expiry <= kMaxDeltaTtl {
@@ -445,7 +455,7 @@ func RetryLoopCtx(description string, worker RetryWorker, sleeper RetrySleeper,
 
 		select {
 		case <-ctx.Done():
-			return fmt.Errorf("Retry loop for %v closed based on context", description), nil
+			return fmt.Errorf("Retry loop for %v closed based on context: %w", description, ctx.Err()), nil
 		case <-time.After(time.Millisecond * time.Duration(sleepMs)):
 		}
 
@@ -457,10 +467,20 @@ func RetryLoopCtx(description string, worker RetryWorker, sleeper RetrySleeper,
 // A version of RetryLoop that returns a strongly typed cas as uint64, to avoid interface conversion overhead for
 // high throughput operations.
 func RetryLoopCas(description string, worker RetryCasWorker, sleeper RetrySleeper) (error, uint64) {
+	return RetryLoopCasCtx(description, worker, sleeper, context.Background())
+}
+
+// RetryLoopCasCtx is a version of RetryLoopCas that stops retrying once ctx is done, returning ctx.Err().  The context
+// is checked before each attempt, and while sleeping between attempts.
+func RetryLoopCasCtx(description string, worker RetryCasWorker, sleeper RetrySleeper, ctx context.Context) (error, uint64) {
 
 	numAttempts := 1
 
 	for {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			Debugf(KeyAll, "RetryLoopCas for %v stopped after %v attempts: %v", description, numAttempts-1, ctxErr)
+			return ctxErr, 0
+		}
 		shouldRetry, err, value := worker()
 		if !shouldRetry {
 			if err != nil {
@@ -478,7 +498,12 @@ func RetryLoopCas(description string, worker RetryCasWorker, sleeper RetrySleepe
 		}
 		Debugf(KeyAll, "RetryLoopCas retrying %v after %v ms.", description, sleepMs)
 
-		<-time.After(time.Millisecond * time.Duration(sleepMs))
+		select {
+		case <-ctx.Done():
+			Debugf(KeyAll, "RetryLoopCas for %v stopped after %v attempts: %v", description, numAttempts, ctx.Err())
+			return ctx.Err(), 0
+		case <-time.After(time.Millisecond * time.Duration(sleepMs)):
+		}
 
 		numAttempts += 1
 
@@ -808,6 +833,11 @@ func IntPtr(i int) *int {
 	return &i
 }
 
+// Int64Ptr returns a pointer to the given int64 literal.
+func Int64Ptr(i int64) *int64 {
+	return &i
+}
+
 // BoolPtr returns a pointer to the given bool literal.
 func BoolPtr(b bool) *bool {
 	return &b
@@ -1027,6 +1057,13 @@ func HexCasToUint64(cas string) uint64 {
 	return binary.LittleEndian.Uint64(casBytes[0:8])
 }
 
+// HLCCasToTime returns the physical time encoded in a hybrid logical clock cas, as assigned by Couchbase Server 4.6 and
+// later - nanoseconds since the epoch, with the low 16 bits holding a logical counter.  Cas values assigned by older
+// servers (or walrus) are counters, and decode to times close to the epoch.
+func HLCCasToTime(cas uint64) time.Time {
+	return time.Unix(0, int64(cas&^0xFFFF))
+}
+
 func Crc32cHash(input []byte) uint32 {
 	// crc32.MakeTable already ensures singleton table creation, so shouldn't need to cache.
 	table := crc32.MakeTable(crc32.Castagnoli)
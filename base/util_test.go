@@ -9,6 +9,7 @@
 package base
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -211,6 +212,42 @@ func TestRetryLoop(t *testing.T) {
 
 }
 
+// Validates that RetryLoopCasCtx stops retrying when the context is cancelled while sleeping between attempts.
+func TestRetryLoopCasCtx(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	numTimesInvoked := 0
+	worker := func() (shouldRetry bool, err error, value uint64) {
+		numTimesInvoked++
+		if numTimesInvoked == 3 {
+			cancel()
+		}
+		return true, fmt.Errorf("Fake error"), 0
+	}
+
+	// Without cancellation, the loop would sleep for a minute after the third attempt
+	sleeper := func(numAttempts int) (bool, int) {
+		if numAttempts < 3 {
+			return true, 0
+		}
+		return true, 60000
+	}
+
+	startTime := time.Now()
+	err, _ := RetryLoopCasCtx("TestRetryLoopCasCtx", worker, sleeper, ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 3, numTimesInvoked)
+	assert.Less(t, int64(time.Since(startTime)), int64(10*time.Second))
+
+	// A context that's already done prevents any attempt
+	numTimesInvoked = 0
+	err, _ = RetryLoopCasCtx("TestRetryLoopCasCtx", worker, sleeper, ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 0, numTimesInvoked)
+}
+
 func TestSyncSourceFromURL(t *testing.T) {
 	u, err := url.Parse("http://www.test.com:4985/mydb")
 	goassert.True(t, err == nil)
@@ -295,6 +332,17 @@ func TestCouchbaseURIToHttpURL(t *testing.T) {
 
 }
 
+func TestExpiryValueCbsExpiry(t *testing.T) {
+	assert.Equal(t, uint32(0), ExpiryValue(0).CbsExpiry())
+	assert.Equal(t, uint32(300), ExpiryValue(300).CbsExpiry())
+	assert.Equal(t, uint32(kMaxDeltaTtl), ExpiryValue(kMaxDeltaTtl).CbsExpiry())
+
+	// Expiries of more than 30 days are converted to an absolute time
+	ninetyDays := 90 * 24 * time.Hour
+	expiry := ExpiryValue(ninetyDays.Seconds()).CbsExpiry()
+	assert.WithinDuration(t, time.Now().Add(ninetyDays), time.Unix(int64(expiry), 0), time.Minute)
+}
+
 func TestReflectExpiry(t *testing.T) {
 	exp := time.Now().Add(time.Hour)
 
@@ -1414,6 +1462,19 @@ func TestGetRestrictedIntQuery(t *testing.T) {
 	assert.Equal(t, minValue, restricted)
 }
 
+func TestHLCCasToTime(t *testing.T) {
+	saved := time.Date(2021, time.June, 1, 12, 0, 0, 123456789, time.UTC)
+
+	// The logical counter in the low 16 bits is ignored
+	cas := uint64(saved.UnixNano())&^0xFFFF | 0x0042
+	decoded := HLCCasToTime(cas)
+	assert.WithinDuration(t, saved, decoded, 0x10000*time.Nanosecond)
+	assert.False(t, decoded.After(saved))
+
+	// Non-HLC cas values decode to times near the epoch
+	assert.True(t, HLCCasToTime(12345).Before(time.Unix(1, 0)))
+}
+
 func BenchmarkURLParse(b *testing.B) {
 	var basicAuthURLRegexp = regexp.MustCompilePOSIX(`:\/\/[^:/]+:[^@/]+@`)
 	b.ResetTimer()
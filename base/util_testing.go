@@ -12,11 +12,13 @@ package base
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -89,6 +91,17 @@ func GetTestBucket(t testing.TB) *TestBucket {
 	}
 }
 
+// GetExclusiveTestBucket returns a flushed test bucket that isn't shared with other tests, even when the test bucket
+// pool is running in namespace mode.  For tests depending on raw DCP, design doc or index behaviour.
+func GetExclusiveTestBucket(t testing.TB) *TestBucket {
+	bucket, spec, closeFn := GTestBucketPool.GetExclusiveTestBucketAndSpec(t)
+	return &TestBucket{
+		Bucket:     bucket,
+		BucketSpec: spec,
+		closeFn:    closeFn,
+	}
+}
+
 // Gets a Walrus bucket which will be persisted to a temporary directory
 // Returns both the test bucket which is persisted and a function which can be used to remove the created temporary
 // directory once the test has finished with it.
@@ -116,8 +129,8 @@ func GetTestBucketForDriver(t testing.TB, driver CouchbaseDriver) *TestBucket {
 	if driver == GoCBv2 {
 		// TODO: add GoCBv2 support to TestBucketPool.
 
-		// Reserve test bucket from pool
-		_, spec, closeFn := GTestBucketPool.GetTestBucketAndSpec(t)
+		// Reserve test bucket from pool.  The collection isn't namespaced, so the bucket must be exclusive.
+		_, spec, closeFn := GTestBucketPool.GetExclusiveTestBucketAndSpec(t)
 
 		spec.CouchbaseDriver = GoCBv2
 		if spec.Server == kTestCouchbaseServerURL {
@@ -334,6 +347,52 @@ func SetUpTestLogging(logLevel LogLevel, logKeys ...LogKey) (teardownFn func())
 	return setTestLogging(logLevel, caller, logKeys...)
 }
 
+// AssertLogContains asserts that the console logs produced by function f contain string s.
+func AssertLogContains(t testing.TB, s string, f func()) {
+	b := bytes.Buffer{}
+
+	// temporarily override logger output for the given function call, flushing any collated logs either side
+	FlushLogBuffers()
+	consoleLogger.logger.SetOutput(&b)
+	f()
+	FlushLogBuffers()
+	consoleLogger.logger.SetOutput(os.Stderr)
+
+	assert.Contains(t, b.String(), s)
+}
+
+// AssertLogRedacted runs f with user data redaction enabled, capturing the console log output, and asserts that each of
+// the given user data values only appears in the output wrapped in its own user data tags - a tag wrapping a value along
+// with other text (e.g. a formatted set of values) would be redacted as a single value.  Values should be distinctive
+// enough not to occur in the surrounding log text.
+func AssertLogRedacted(t testing.TB, userData []string, f func()) {
+	defer func(redactUserData bool) { RedactUserData = redactUserData }(RedactUserData)
+	RedactUserData = true
+
+	FlushLogBuffers()
+	output := CaptureConsoleLogOutput(func() {
+		f()
+		FlushLogBuffers()
+	})
+	require.NotEmpty(t, output, "No log output was captured")
+
+	// Remove every tagged value, so that any remaining occurrence is unredacted
+	untagged := userDataTagsRegexp.ReplaceAllString(output, "")
+	for _, value := range userData {
+		assert.NotContains(t, untagged, value, "Found unredacted user data %q in log output", value)
+	}
+	for _, tagged := range userDataTagsRegexp.FindAllString(output, -1) {
+		content := strings.TrimSuffix(strings.TrimPrefix(tagged, userDataPrefix), userDataSuffix)
+		for _, value := range userData {
+			if content != value && strings.Contains(content, value) {
+				assert.Fail(t, "Found user data tagged with other text", "%q tagged as %s", value, tagged)
+			}
+		}
+	}
+}
+
+var userDataTagsRegexp = regexp.MustCompile(regexp.QuoteMeta(userDataPrefix) + "(?s).*?" + regexp.QuoteMeta(userDataSuffix))
+
 // DisableTestLogging is an alias for SetUpTestLogging(LevelNone, KeyNone)
 // This function will panic if called multiple times without running the teardownFn.
 func DisableTestLogging() (teardownFn func()) {
@@ -359,6 +418,21 @@ func SetUpBenchmarkLogging(logLevel LogLevel, logKeys ...LogKey) (teardownFn fun
 	}
 }
 
+// CaptureConsoleLogOutput returns the console log output written while running f.
+func CaptureConsoleLogOutput(f func()) string {
+	var b bytes.Buffer
+	consoleLogger.logger.SetOutput(&b)
+	defer func() {
+		if consoleLogger.output != nil {
+			consoleLogger.logger.SetOutput(consoleLogger.output)
+		} else {
+			consoleLogger.logger.SetOutput(os.Stderr)
+		}
+	}()
+	f()
+	return b.String()
+}
+
 func setTestLogging(logLevel LogLevel, caller string, logKeys ...LogKey) (teardownFn func()) {
 	if GlobalTestLoggingSet.IsTrue() {
 		// noop, test log level is already set globally
@@ -489,6 +563,16 @@ type dataStore struct {
 
 // ForAllDataStores is used to run a test against multiple data stores (gocb bucket, gocb collection)
 func ForAllDataStores(t *testing.T, testCallback func(*testing.T, sgbucket.DataStore)) {
+	forAllDataStores(t, false, testCallback)
+}
+
+// ForAllExclusiveDataStores is ForAllDataStores for tests that can't share a bucket when the test bucket pool is in
+// namespace mode, such as tests managing design docs.
+func ForAllExclusiveDataStores(t *testing.T, testCallback func(*testing.T, sgbucket.DataStore)) {
+	forAllDataStores(t, true, testCallback)
+}
+
+func forAllDataStores(t *testing.T, exclusive bool, testCallback func(*testing.T, sgbucket.DataStore)) {
 	dataStores := make([]dataStore, 0)
 
 	if TestUseCouchbaseServer() {
@@ -504,9 +588,100 @@ func ForAllDataStores(t *testing.T, testCallback func(*testing.T, sgbucket.DataS
 
 	for _, dataStore := range dataStores {
 		t.Run(dataStore.name, func(t *testing.T) {
-			bucket := GetTestBucketForDriver(t, dataStore.driver)
+			var bucket *TestBucket
+			if exclusive && dataStore.driver == GoCB {
+				bucket = GetExclusiveTestBucket(t)
+			} else {
+				bucket = GetTestBucketForDriver(t, dataStore.driver)
+			}
 			defer bucket.Close()
 			testCallback(t, bucket)
 		})
 	}
 }
+
+// RecordingTracer is a Tracer that records the spans it starts, for tests asserting the spans started for a request.
+type RecordingTracer struct {
+	lock  sync.Mutex
+	spans []*RecordedSpan
+}
+
+// RecordedSpan is a span started by a RecordingTracer.  IDs are assigned from 1 in the order spans are started, and a
+// ParentID of zero identifies a root span.
+type RecordedSpan struct {
+	ID         int
+	ParentID   int
+	Name       string
+	Attributes map[string]interface{}
+	Ended      bool
+	tracer     *RecordingTracer
+}
+
+type recordedSpanKey struct{}
+
+var _ Tracer = &RecordingTracer{}
+
+// SetUpTestTracing sets a RecordingTracer as the tracer until the returned teardown function is called.
+func SetUpTestTracing() (*RecordingTracer, func()) {
+	recordingTracer := &RecordingTracer{}
+	SetTracer(recordingTracer)
+	return recordingTracer, func() { SetTracer(nil) }
+}
+
+func (rt *RecordingTracer) StartSpan(ctx context.Context, name string, attributes ...SpanAttribute) (context.Context, Span) {
+	span := &RecordedSpan{
+		Name:       name,
+		Attributes: make(map[string]interface{}),
+		tracer:     rt,
+	}
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*RecordedSpan); ok {
+		span.ParentID = parent.ID
+	}
+	rt.lock.Lock()
+	span.ID = len(rt.spans) + 1
+	rt.spans = append(rt.spans, span)
+	rt.lock.Unlock()
+	span.SetAttributes(attributes...)
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+// Spans returns copies of the spans started so far, in the order they were started.
+func (rt *RecordingTracer) Spans() []RecordedSpan {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	spans := make([]RecordedSpan, 0, len(rt.spans))
+	for _, span := range rt.spans {
+		spanCopy := *span
+		spanCopy.Attributes = make(map[string]interface{}, len(span.Attributes))
+		for key, value := range span.Attributes {
+			spanCopy.Attributes[key] = value
+		}
+		spans = append(spans, spanCopy)
+	}
+	return spans
+}
+
+// SpansNamed returns copies of the spans started so far with the given name.
+func (rt *RecordingTracer) SpansNamed(name string) []RecordedSpan {
+	var named []RecordedSpan
+	for _, span := range rt.Spans() {
+		if span.Name == name {
+			named = append(named, span)
+		}
+	}
+	return named
+}
+
+func (s *RecordedSpan) SetAttributes(attributes ...SpanAttribute) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	for _, attribute := range attributes {
+		s.Attributes[attribute.Key] = attribute.Value
+	}
+}
+
+func (s *RecordedSpan) End() {
+	s.tracer.lock.Lock()
+	s.Ended = true
+	s.tracer.lock.Unlock()
+}
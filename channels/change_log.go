@@ -30,19 +30,26 @@ const (
 )
 
 type LogEntry struct {
-	Sequence     uint64       // Sequence number
-	DocID        string       // Document ID
-	RevID        string       // Revision ID
-	Flags        uint8        // Deleted/Removed/Hidden flags
-	VbNo         uint16       // vbucket number
-	TimeSaved    time.Time    // Time doc revision was saved (just used for perf metrics)
-	TimeReceived time.Time    // Time received from tap feed
-	Channels     ChannelMap   // Channels this entry is in or was removed from
-	Skipped      bool         // Late arriving entry
-	Type         LogEntryType // Log entry type
-	Value        []byte       // Snapshot metadata (when Type=LogEntryCheckpoint)
-	PrevSequence uint64       // Sequence of previous active revision
-	IsPrincipal  bool         // Whether the log-entry is a tracking entry for a principal doc
+	Sequence        uint64       // Sequence number
+	DocID           string       // Document ID
+	RevID           string       // Revision ID
+	Flags           uint8        // Deleted/Removed/Hidden flags
+	VbNo            uint16       // vbucket number
+	Checksum        uint32       // Checksum of Sequence, DocID and RevID (channel cache entry checksums only)
+	TimeSaved       time.Time    // Time doc revision was saved (just used for perf metrics)
+	ServerTimeSaved time.Time    // Time the mutation was saved on the server, decoded from the feed's cas (zero when unknown)
+	TimeReceived    time.Time    // Time received from tap feed
+	Channels        ChannelMap   // Channels this entry is in or was removed from
+	Skipped         bool         // Late arriving entry
+	OutOfOrder      bool         // Cached after later sequences - late arriving, or abandoned as skipped and then received
+	FromQuery       bool         // Read from a channel query rather than the feed, so OutOfOrder isn't known
+	Type            LogEntryType // Log entry type
+	Value           []byte       // Snapshot metadata (when Type=LogEntryCheckpoint)
+	PrevSequence    uint64       // Sequence of previous active revision
+	IsPrincipal     bool         // Whether the log-entry is a tracking entry for a principal doc
+	RemovedAtRev    string       // Revision that removed the document from the channel (removal entries only)
+	TimeDeleted     time.Time    // Time the document was deleted (tombstone entries from the feed only)
+	Collection      string       // Collection the document belongs to, empty for the default collection
 }
 
 func (l LogEntry) String() string {
@@ -56,6 +63,22 @@ func (l LogEntry) String() string {
 	)
 }
 
+// RedactedString is the equivalent of String for output that doesn't pass through the logger's redaction (e.g.
+// diagnostic dumps), tagging the doc ID and channel names as user data based on the current redaction level.
+func (l LogEntry) RedactedString() string {
+	channelNames := l.Channels.KeySet()
+	sort.Strings(channelNames)
+	return fmt.Sprintf(
+		"seq: %d docid: %s revid: %s vbno: %d type: %v channels: %s",
+		l.Sequence,
+		base.UD(l.DocID).Redact(),
+		l.RevID,
+		l.VbNo,
+		l.Type,
+		base.UD(channelNames).Redact(),
+	)
+}
+
 type ChannelMap map[string]*ChannelRemoval
 type ChannelRemoval struct {
 	Seq     uint64 `json:"seq,omitempty"`
@@ -14,7 +14,9 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/couchbase/sync_gateway/base"
 	goassert "github.com/couchbaselabs/go.assert"
+	"github.com/stretchr/testify/assert"
 )
 
 func e(seq uint64, docid string, revid string) *LogEntry {
@@ -93,3 +95,24 @@ func TestSort(t *testing.T) {
 		goassert.Equals(t, entry.Sequence, expectedSeqs[i])
 	}
 }
+
+func TestLogEntryRedactedString(t *testing.T) {
+	defer func(redactUserData bool) { base.RedactUserData = redactUserData }(base.RedactUserData)
+
+	entry := LogEntry{
+		Sequence: 12,
+		DocID:    "doc1",
+		RevID:    "1-a",
+		Channels: ChannelMap{"ABC": nil, "DEF": nil},
+	}
+
+	base.RedactUserData = true
+	assert.Equal(t, "seq: 12 docid: <ud>doc1</ud> revid: 1-a vbno: 0 type: 0 channels: [ <ud>ABC</ud> <ud>DEF</ud> ]", entry.RedactedString())
+
+	base.RedactUserData = false
+	assert.Equal(t, "seq: 12 docid: doc1 revid: 1-a vbno: 0 type: 0 channels: [ ABC DEF ]", entry.RedactedString())
+
+	// String is left unredacted for use with the logger, which applies its own redaction
+	base.RedactUserData = true
+	assert.Equal(t, "seq: 12 docid: doc1 revid: 1-a vbno: 0 type: 0", entry.String())
+}
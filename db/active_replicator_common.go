@@ -13,6 +13,7 @@ package db
 import (
 	"context"
 	"expvar"
+	"fmt"
 	"sync"
 	"time"
 
@@ -143,6 +144,29 @@ func (a *activeReplicatorCommon) reconnectLoop() {
 	}
 }
 
+// checkRemoteChannelAccess verifies that the remote user has access to the channels in the replication's
+// sync_gateway/bychannel filter.  Inaccessible channels are logged as a warning.  When the channels are entirely outside
+// the remote user's grants, they're returned as an error instead if RejectInaccessibleChannels is set.
+func (a *activeReplicatorCommon) checkRemoteChannelAccess() error {
+	inaccessible, err := RemoteInaccessibleChannels(*a.config.RemoteDBURL, a.config.InsecureSkipVerify, a.config.FilterChannels)
+	if err != nil {
+		// Don't block the replication when the check itself can't be performed
+		base.InfofCtx(a.ctx, base.KeyReplicate, "Unable to check remote channel access for replication %s: %v", a.config.ID, err)
+		return nil
+	}
+
+	if len(inaccessible) == 0 {
+		return nil
+	}
+
+	if a.config.RejectInaccessibleChannels && len(inaccessible) == len(a.config.FilterChannels) {
+		return fmt.Errorf(ErrorInaccessibleChannelsFmt, inaccessible)
+	}
+
+	base.WarnfCtx(a.ctx, "Replication %s: "+ErrorInaccessibleChannelsFmt+" - changes in these channels won't be replicated", a.config.ID, base.UD(inaccessible))
+	return nil
+}
+
 // stopAndDisconnect runs _disconnect and _stop on the replicator, and sets the Stopped replication state.
 func (a *activeReplicatorCommon) stopAndDisconnect() error {
 	a.lock.Lock()
@@ -47,6 +47,9 @@ type ActiveReplicatorConfig struct {
 	Filter string
 	// FilterChannels are a set of channels to be used by the sync_gateway/bychannel filter.
 	FilterChannels []string
+	// RejectInaccessibleChannels fails the pull replication when the remote user doesn't have access to any of the
+	// FilterChannels, instead of only logging a warning.  Access to only some of them is always logged as a warning.
+	RejectInaccessibleChannels bool
 	// DocIDs limits the changes to only those doc IDs specified.
 	DocIDs []string
 	// ActiveOnly when true prevents changes being sent for tombstones on the initial replication.
@@ -216,5 +219,9 @@ func (arc *ActiveReplicatorConfig) Equals(other *ActiveReplicatorConfig) bool {
 		return false
 	}
 
+	if arc.RejectInaccessibleChannels != other.RejectInaccessibleChannels {
+		return false
+	}
+
 	return true
 }
@@ -59,6 +59,13 @@ func (apr *ActivePullReplicator) Start() error {
 }
 
 func (apr *ActivePullReplicator) _connect() error {
+	// Grants on the remote may have changed since the last connect, so access is re-checked on every (re)connect
+	if apr.config.Filter == base.ByChannelFilter {
+		if err := apr.checkRemoteChannelAccess(); err != nil {
+			return err
+		}
+	}
+
 	var err error
 	apr.blipSender, apr.blipSyncContext, err = connect(apr.activeReplicatorCommon, "-pull")
 	if err != nil {
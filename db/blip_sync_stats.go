@@ -122,6 +122,9 @@ func BlipSyncStatsForCBL(dbStats *base.DbStats) *BlipSyncStats {
 	blipStats.HandleGetAttachment = dbStats.CBLReplicationPull().AttachmentPullCount
 	blipStats.HandleGetAttachmentBytes = dbStats.CBLReplicationPull().AttachmentPullBytes
 
+	// Attachments pushed by the client that were already known, and only needed to be proved
+	blipStats.ProveAttachment = dbStats.CBLReplicationPush().AttachmentPushKnown
+
 	blipStats.HandleChangesResponseCount = dbStats.CBLReplicationPull().RequestChangesCount
 	blipStats.HandleChangesResponseTime = dbStats.CBLReplicationPull().RequestChangesTime
 	blipStats.HandleChangesSendRevCount = dbStats.CBLReplicationPull().RevSendCount
@@ -142,6 +145,7 @@ func BlipSyncStatsForSGRPush(replicationStats *base.DbReplicatorStats) *BlipSync
 
 	blipStats.HandleGetAttachmentBytes = replicationStats.NumAttachmentBytesPushed
 	blipStats.HandleGetAttachment = replicationStats.NumAttachmentPushed
+	blipStats.HandleProveAttachment = replicationStats.NumAttachmentPushedKnown
 
 	blipStats.SendRevCount = replicationStats.NumDocPushed
 	blipStats.SendRevErrorTotal = replicationStats.NumDocsFailedToPush
@@ -160,6 +164,7 @@ func BlipSyncStatsForSGRPull(replicationStats *base.DbReplicatorStats) *BlipSync
 
 	blipStats.GetAttachmentBytes = replicationStats.NumAttachmentBytesPulled
 	blipStats.GetAttachment = replicationStats.NumAttachmentsPulled
+	blipStats.ProveAttachment = replicationStats.NumAttachmentsPulledKnown
 	blipStats.HandleRevCount = replicationStats.PulledCount
 	blipStats.HandleRevDocsPurgedCount = replicationStats.PurgedCount
 	blipStats.HandleRevErrorCount = replicationStats.FailedToPullCount
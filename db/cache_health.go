@@ -0,0 +1,94 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	CacheHealthOK       = "ok"
+	CacheHealthDegraded = "degraded"
+)
+
+var (
+	CacheHealthSkippedAgeThreshold = time.Minute      // Age of the oldest skipped sequence at which the cache is considered degraded
+	CacheHealthBacklogThreshold    = uint64(10000)    // Number of feed sequences not yet stable in the cache at which the cache is considered degraded
+	CacheHealthInterval            = 5 * time.Second  // Time a computed cache health is reused, to keep health checks cheap
+	CacheHealthBaseRetryAfter      = time.Second      // Retry hint for a cache that's just reached a degraded threshold
+	CacheHealthMaxRetryAfter       = 60 * time.Second // Upper bound for retry hints
+)
+
+// CacheHealth summarizes the health of a database's change cache, for clients to back off when it's degraded.
+type CacheHealth struct {
+	Status       string `json:"health"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+}
+
+// CacheHealth returns the health of the database's change cache.  The result is recomputed at most once per
+// CacheHealthInterval.
+func (context *DatabaseContext) CacheHealth() CacheHealth {
+	context.cacheHealthLock.Lock()
+	defer context.cacheHealthLock.Unlock()
+
+	now := time.Now()
+	if !context.cacheHealthTime.IsZero() && now.Sub(context.cacheHealthTime) < CacheHealthInterval {
+		return context.cacheHealth
+	}
+
+	context.cacheHealth = context.changeCache.evaluateHealth(now)
+	context.cacheHealthTime = now
+	return context.cacheHealth
+}
+
+// evaluateHealth compares the oldest skipped sequence age, pending sequence count and feed backlog against their
+// degraded thresholds.  The cache is degraded when any of them reaches its threshold, and the retry hint scales
+// with how far the worst of them is over.
+func (c *changeCache) evaluateHealth(now time.Time) CacheHealth {
+
+	oldestSkippedAge, pending, backlog := c.healthSignals(now)
+
+	severity := float64(oldestSkippedAge) / float64(CacheHealthSkippedAgeThreshold)
+	if c.options.CachePendingSeqMaxNum > 0 {
+		severity = math.Max(severity, float64(pending)/float64(c.options.CachePendingSeqMaxNum))
+	}
+	if CacheHealthBacklogThreshold > 0 {
+		severity = math.Max(severity, float64(backlog)/float64(CacheHealthBacklogThreshold))
+	}
+
+	if severity < 1 {
+		return CacheHealth{Status: CacheHealthOK}
+	}
+
+	retryAfter := time.Duration(severity * float64(CacheHealthBaseRetryAfter))
+	if retryAfter > CacheHealthMaxRetryAfter {
+		retryAfter = CacheHealthMaxRetryAfter
+	}
+	return CacheHealth{
+		Status:       CacheHealthDegraded,
+		RetryAfterMs: int64(retryAfter / time.Millisecond),
+	}
+}
+
+// healthSignals returns the age of the oldest skipped sequence, the number of pending sequences, and the number of
+// sequences seen on the feed that aren't yet stable in the cache.
+func (c *changeCache) healthSignals(now time.Time) (oldestSkippedAge time.Duration, pending int, backlog uint64) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	oldestSkippedAge = c.skippedSeqs.getOldestAge(now)
+	pending = len(c.pendingLogs)
+	if maxStable := c._getMaxStableCached(); c.internalStats.highSeqFeed > maxStable {
+		backlog = c.internalStats.highSeqFeed - maxStable
+	}
+	return oldestSkippedAge, pending, backlog
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+const (
+	cacheMemoryHighWatermarkPercent = 90 // Channel cache memory is reduced once usage reaches this percent of a limit
+	cacheMemoryLowWatermarkPercent  = 75 // Percent of a limit channel cache memory is reduced to
+)
+
+// CacheMemoryGovernor limits the estimated memory used by the channel caches of every database on the server.  When
+// usage reaches the high watermark of the server's limit, each database is asked to reduce its usage in proportion to
+// its share of the total, by evicting idle channel caches and pruning the rest, so that the total falls to the low
+// watermark.  Databases' own limits (ChannelCacheOptions.MaxMemoryBytes) are enforced first.
+type CacheMemoryGovernor struct {
+	maxBytes     int64            // Server-wide limit, zero for none.  Accessed atomically
+	usageStat    *base.SgwIntStat // Server-wide usage gauge, optional
+	pressureStat *base.SgwIntStat // Server-wide usage as percent of the limit gauge, optional
+}
+
+func NewCacheMemoryGovernor(maxBytes int64, usageStat, pressureStat *base.SgwIntStat) *CacheMemoryGovernor {
+	g := &CacheMemoryGovernor{usageStat: usageStat, pressureStat: pressureStat}
+	g.SetMaxBytes(maxBytes)
+	return g
+}
+
+// SetMaxBytes replaces the server-wide limit, applied on the next call to Govern.
+func (g *CacheMemoryGovernor) SetMaxBytes(maxBytes int64) {
+	atomic.StoreInt64(&g.maxBytes, maxBytes)
+}
+
+// MaxBytes returns the server-wide limit.
+func (g *CacheMemoryGovernor) MaxBytes() int64 {
+	return atomic.LoadInt64(&g.maxBytes)
+}
+
+// Govern measures the channel cache memory used by the given databases, updating their usage stats and the
+// server-wide usage and pressure stats, and reduces usage over the databases' or server's limits.  Databases whose
+// channel cache doesn't account for its memory are left out of the total, and only logged.  Called periodically with
+// every database on the server.
+func (g *CacheMemoryGovernor) Govern(databases []*DatabaseContext) {
+
+	type dbUsage struct {
+		db    *DatabaseContext
+		bytes int64
+	}
+	usages := make([]dbUsage, 0, len(databases))
+	var total int64
+	for _, database := range databases {
+		changeCache := database.changeCache
+		if changeCache == nil || changeCache.IsStopped() {
+			continue
+		}
+		bytes, ok := changeCache.CacheMemoryUsage()
+		if !ok {
+			if g.MaxBytes() > 0 || changeCache.maxCacheMemoryBytes() > 0 {
+				base.Infof(base.KeyCache, "Channel cache memory accounting unavailable for database %s - memory limits not enforced", base.MD(database.Name))
+			}
+			continue
+		}
+		if limit := changeCache.maxCacheMemoryBytes(); limit > 0 && bytes >= limit*cacheMemoryHighWatermarkPercent/100 {
+			bytes, _ = changeCache.ReduceCacheMemory(limit * cacheMemoryLowWatermarkPercent / 100)
+		}
+		database.DbStats.Cache().ChannelCacheMemoryBytes.Set(bytes)
+		usages = append(usages, dbUsage{db: database, bytes: bytes})
+		total += bytes
+	}
+
+	maxBytes := g.MaxBytes()
+	var pressure int64
+	if maxBytes > 0 {
+		pressure = total * 100 / maxBytes
+	}
+	if g.pressureStat != nil {
+		g.pressureStat.Set(pressure)
+	}
+	if maxBytes <= 0 || total < maxBytes*cacheMemoryHighWatermarkPercent/100 {
+		if g.usageStat != nil {
+			g.usageStat.Set(total)
+		}
+		return
+	}
+
+	// Reduce each database by its share of the excess, largest consumer first
+	excess := total - maxBytes*cacheMemoryLowWatermarkPercent/100
+	base.Infof(base.KeyCache, "Channel cache memory estimated at %d bytes is %d%% of the limit of %d bytes - reducing by %d bytes across %d databases",
+		total, pressure, maxBytes, excess, len(usages))
+	sort.Slice(usages, func(i, j int) bool { return usages[i].bytes > usages[j].bytes })
+	var reducedTotal int64
+	for _, usage := range usages {
+		share := int64(float64(excess) * float64(usage.bytes) / float64(total))
+		bytes := usage.bytes
+		if share > 0 {
+			bytes, _ = usage.db.changeCache.ReduceCacheMemory(usage.bytes - share)
+			usage.db.DbStats.Cache().ChannelCacheMemoryBytes.Set(bytes)
+		}
+		reducedTotal += bytes
+	}
+	if g.usageStat != nil {
+		g.usageStat.Set(reducedTotal)
+	}
+}
+
+// CacheMemoryUsage returns the estimated memory used by the database's channel caches, and false if the channel cache
+// doesn't account for its memory.
+func (c *changeCache) CacheMemoryUsage() (bytes int64, ok bool) {
+	accountant, ok := c.channelCache.(cacheMemoryAccountant)
+	if !ok {
+		return 0, false
+	}
+	return accountant.memoryUsage(), true
+}
+
+// ReduceCacheMemory reduces the estimated memory used by the database's channel caches to at most targetBytes where
+// possible, returning the estimated memory used afterwards - see channelCacheImpl.reduceMemory.
+func (c *changeCache) ReduceCacheMemory(targetBytes int64) (bytes int64, ok bool) {
+	accountant, ok := c.channelCache.(cacheMemoryAccountant)
+	if !ok {
+		return 0, false
+	}
+	return accountant.reduceMemory(targetBytes), true
+}
+
+// maxCacheMemoryBytes returns the database's own channel cache memory limit, zero for none.
+func (c *changeCache) maxCacheMemoryBytes() int64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.options.MaxMemoryBytes
+}
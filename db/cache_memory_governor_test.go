@@ -0,0 +1,144 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupCacheMemoryTestDB creates a database with numChannels channel caches, each holding entriesPerChannel entries,
+// which can be pruned to a single entry.  Databases are named so that several can be open at once without their stats
+// colliding.
+func setupCacheMemoryTestDB(t *testing.T, name string, numChannels, entriesPerChannel int) *DatabaseContext {
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.ChannelCacheMinLength = 1
+	context, err := NewDatabaseContext(name, base.GetTestBucket(t), false, DatabaseContextOptions{CacheOptions: &cacheOptions})
+	require.NoError(t, err)
+
+	seq := uint64(1)
+	for i := 0; i < numChannels; i++ {
+		channelName := fmt.Sprintf("channel-%d", i)
+		context.changeCache.getChannelCache().getSingleChannelCache(NewDefaultChannelID(channelName))
+		for j := 0; j < entriesPerChannel; j++ {
+			context.changeCache.processEntry(logEntry(seq, fmt.Sprintf("doc-%d-%d", i, j), "1-a", []string{channelName}))
+			seq++
+		}
+	}
+	return context
+}
+
+func cacheMemoryUsage(t *testing.T, context *DatabaseContext) int64 {
+	bytes, ok := context.changeCache.CacheMemoryUsage()
+	require.True(t, ok)
+	return bytes
+}
+
+// Validates that when the server-wide limit is approached, databases are reduced in proportion to their usage, so that
+// the larger consumer shrinks most, and that the usage and pressure stats are published.
+func TestCacheMemoryGovernorReducesLargerConsumerFirst(t *testing.T) {
+
+	large := setupCacheMemoryTestDB(t, "large", 10, 40)
+	defer large.Close()
+	small := setupCacheMemoryTestDB(t, "small", 2, 10)
+	defer small.Close()
+
+	usageStat, pressureStat := &base.SgwIntStat{}, &base.SgwIntStat{}
+	governor := NewCacheMemoryGovernor(0, usageStat, pressureStat)
+
+	// No limit - usage is published, and nothing is reduced
+	largeBefore, smallBefore := cacheMemoryUsage(t, large), cacheMemoryUsage(t, small)
+	require.Greater(t, largeBefore, 10*smallBefore)
+	governor.Govern([]*DatabaseContext{small, large})
+	assert.Equal(t, largeBefore+smallBefore, usageStat.Value())
+	assert.Equal(t, int64(0), pressureStat.Value())
+	assert.Equal(t, largeBefore, large.DbStats.Cache().ChannelCacheMemoryBytes.Value())
+	assert.Equal(t, smallBefore, small.DbStats.Cache().ChannelCacheMemoryBytes.Value())
+
+	// Under the high watermark - nothing is reduced
+	governor.SetMaxBytes((largeBefore + smallBefore) * 2)
+	governor.Govern([]*DatabaseContext{small, large})
+	assert.Equal(t, largeBefore, cacheMemoryUsage(t, large))
+	assert.Equal(t, smallBefore, cacheMemoryUsage(t, small))
+
+	// Over the high watermark - both databases are reduced, the larger by more, to within the low watermark.  Every
+	// channel has been recently used, so caches are pruned rather than evicted.
+	maxBytes := (largeBefore + smallBefore) / 2
+	governor.SetMaxBytes(maxBytes)
+	governor.Govern([]*DatabaseContext{small, large})
+	assert.Equal(t, (largeBefore+smallBefore)*100/maxBytes, pressureStat.Value())
+	largeAfter, smallAfter := cacheMemoryUsage(t, large), cacheMemoryUsage(t, small)
+	assert.Less(t, smallAfter, smallBefore)
+	assert.Greater(t, largeBefore-largeAfter, smallBefore-smallAfter)
+	assert.LessOrEqual(t, largeAfter+smallAfter, maxBytes*cacheMemoryLowWatermarkPercent/100)
+	assert.Equal(t, largeAfter+smallAfter, usageStat.Value())
+	assert.Equal(t, largeAfter, large.DbStats.Cache().ChannelCacheMemoryBytes.Value())
+	assert.Equal(t, int64(10), large.DbStats.Cache().ChannelCacheNumChannels.Value())
+}
+
+// Validates that idle channel caches are evicted before recently used caches are pruned, and that a database's own
+// limit is enforced without a server-wide limit.
+func TestCacheMemoryGovernorEvictsIdleChannels(t *testing.T) {
+
+	context := setupCacheMemoryTestDB(t, "db", 4, 20)
+	defer context.Close()
+	before := cacheMemoryUsage(t, context)
+
+	// Mark two channels as idle, as compaction would.  Evicting them is enough to reach the low watermark of the
+	// database's limit, so the others aren't pruned.
+	for _, channelName := range []string{"channel-0", "channel-1"} {
+		singleCache, ok := context.changeCache.getChannelCache().(*channelCacheImpl).getActiveChannelCache(NewDefaultChannelID(channelName))
+		require.True(t, ok)
+		singleCache.recentlyUsed.Set(false)
+	}
+
+	context.changeCache.lock.Lock()
+	context.changeCache.options.MaxMemoryBytes = before * 70 / 100
+	context.changeCache.lock.Unlock()
+	governor := NewCacheMemoryGovernor(0, nil, nil)
+	governor.Govern([]*DatabaseContext{context})
+
+	cacheStats := context.DbStats.Cache()
+	assert.Equal(t, int64(2), cacheStats.ChannelCacheChannelsEvictedInactive.Value())
+	assert.Equal(t, int64(2), cacheStats.ChannelCacheNumChannels.Value())
+	after := cacheMemoryUsage(t, context)
+	assert.Equal(t, before/2, after)
+	assert.Equal(t, after, cacheStats.ChannelCacheMemoryBytes.Value())
+}
+
+// nonAccountingChannelCache hides the memory accounting of the wrapped channel cache.
+type nonAccountingChannelCache struct {
+	ChannelCache
+}
+
+// Validates that a database whose channel cache doesn't account for its memory is logged and skipped.
+func TestCacheMemoryGovernorAccountingUnavailable(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache)()
+
+	context := setupCacheMemoryTestDB(t, "db", 1, 10)
+	defer context.Close()
+	channelCache := context.changeCache.channelCache
+	context.changeCache.channelCache = nonAccountingChannelCache{channelCache}
+	defer func() { context.changeCache.channelCache = channelCache }()
+
+	usageStat := &base.SgwIntStat{}
+	governor := NewCacheMemoryGovernor(1, usageStat, nil)
+	base.AssertLogContains(t, "Channel cache memory accounting unavailable", func() {
+		governor.Govern([]*DatabaseContext{context})
+	})
+	assert.Equal(t, int64(0), usageStat.Value())
+	assert.Equal(t, int64(0), context.DbStats.Cache().ChannelCacheMemoryBytes.Value())
+}
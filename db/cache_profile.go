@@ -0,0 +1,77 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Names of the cache profiles selectable with a database's cache_profile.
+const (
+	CacheProfileLowMemory      = "low_memory"      // Smaller and fewer channel caches, for memory constrained nodes
+	CacheProfileBalanced       = "balanced"        // The default cache options
+	CacheProfileHighThroughput = "high_throughput" // Larger and more channel caches, and more tolerance of sequence gaps under heavy write load
+)
+
+// cacheProfiles are the vetted combinations of cache options for each profile, applied over DefaultCacheOptions.
+var cacheProfiles = map[string]func(options *CacheOptions){
+	CacheProfileLowMemory: func(options *CacheOptions) {
+		options.ChannelCacheMinLength = 20
+		options.ChannelCacheMaxLength = 100
+		options.ChannelCacheAge = 30 * time.Second
+		options.MaxNumChannels = 5000
+		options.CachePendingSeqMaxNum = 2500
+	},
+	CacheProfileBalanced: func(options *CacheOptions) {},
+	CacheProfileHighThroughput: func(options *CacheOptions) {
+		options.ChannelCacheMinLength = 100
+		options.ChannelCacheMaxLength = 2000
+		options.ChannelCacheAge = 120 * time.Second
+		options.MaxNumChannels = 200000
+		options.CachePendingSeqMaxNum = 50000
+		options.CachePendingSeqMaxWait = 10 * time.Second
+	},
+}
+
+// CacheProfileNames returns the names of the available cache profiles, in alphabetical order.
+func CacheProfileNames() []string {
+	names := make([]string, 0, len(cacheProfiles))
+	for name := range cacheProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CacheProfileOptions returns DefaultCacheOptions with the given profile's values applied, or just the defaults for
+// an empty profile name.  The number of channel caches is an enterprise edition setting, so isn't changed by a profile
+// in community edition.
+func CacheProfileOptions(profile string) (CacheOptions, error) {
+	options := DefaultCacheOptions()
+	if profile == "" {
+		return options, nil
+	}
+	applyProfile, ok := cacheProfiles[profile]
+	if !ok {
+		return options, fmt.Errorf("unknown cache profile %q - must be one of %s", profile, strings.Join(CacheProfileNames(), ", "))
+	}
+	applyProfile(&options)
+	if !base.IsEnterpriseEdition() {
+		options.MaxNumChannels = DefaultChannelCacheMaxNumber
+	}
+	options.Profile = profile
+	return options, nil
+}
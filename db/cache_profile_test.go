@@ -0,0 +1,66 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheProfileOptions(t *testing.T) {
+
+	options, err := CacheProfileOptions("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultCacheOptions(), options)
+
+	options, err = CacheProfileOptions(CacheProfileBalanced)
+	require.NoError(t, err)
+	expected := DefaultCacheOptions()
+	expected.Profile = CacheProfileBalanced
+	assert.Equal(t, expected, options)
+
+	expectedMaxNumChannels := func(eeValue int) int {
+		if base.IsEnterpriseEdition() {
+			return eeValue
+		}
+		return DefaultChannelCacheMaxNumber
+	}
+
+	options, err = CacheProfileOptions(CacheProfileLowMemory)
+	require.NoError(t, err)
+	expected = DefaultCacheOptions()
+	expected.Profile = CacheProfileLowMemory
+	expected.ChannelCacheMinLength = 20
+	expected.ChannelCacheMaxLength = 100
+	expected.ChannelCacheAge = 30 * time.Second
+	expected.MaxNumChannels = expectedMaxNumChannels(5000)
+	expected.CachePendingSeqMaxNum = 2500
+	assert.Equal(t, expected, options)
+
+	options, err = CacheProfileOptions(CacheProfileHighThroughput)
+	require.NoError(t, err)
+	expected = DefaultCacheOptions()
+	expected.Profile = CacheProfileHighThroughput
+	expected.ChannelCacheMinLength = 100
+	expected.ChannelCacheMaxLength = 2000
+	expected.ChannelCacheAge = 120 * time.Second
+	expected.MaxNumChannels = expectedMaxNumChannels(200000)
+	expected.CachePendingSeqMaxNum = 50000
+	expected.CachePendingSeqMaxWait = 10 * time.Second
+	assert.Equal(t, expected, options)
+
+	_, err = CacheProfileOptions("huge")
+	assert.EqualError(t, err, `unknown cache profile "huge" - must be one of balanced, high_throughput, low_memory`)
+}
@@ -11,12 +11,16 @@ licenses/APL2.txt.
 package db
 
 import (
+	"bytes"
 	"container/heap"
-	"container/list"
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
+	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -32,14 +36,59 @@ const (
 	DefaultCachePendingSeqMaxNum  = 10000            // Max number of waiting sequences
 	DefaultCachePendingSeqMaxWait = 5 * time.Second  // Max time we'll wait for a pending sequence before sending to missed queue
 	DefaultSkippedSeqMaxWait      = 60 * time.Minute // Max time we'll wait for an entry in the missing before purging
+	DefaultFeedLagWarnThreshold   = time.Minute      // Feed latency above which a change is counted and warned about
 	QueryTombstoneBatch           = 250              // Max number of tombstones checked per query during Compact
 )
 
-var SkippedSeqCleanViewBatch = 50 // Max number of sequences checked per query during CleanSkippedSequence.  Var to support testing
+// Min time we'll wait for a pending sequence before sending to missed queue, when the wait is adaptive
+const DefaultCachePendingSeqMinWait = 100 * time.Millisecond
 
-// Enable keeping a channel-log for the "*" channel (channel.UserStarChannel). The only time this channel is needed is if
-// someone has access to "*" (e.g. admin-party) and tracks its changes feed.
-var EnableStarChannelLog = true
+var SkippedSeqCleanViewBatch = 50 // Max number of sequences checked per view or N1QL query during CleanSkippedSequence.  Var to support testing
+
+// Number of skipped sequences read per acquisition of the skipped sequence lock when iterating over the list.  Var to
+// support testing
+var SkippedSeqIterationPageSize = 1000
+
+// Max number of feed parse failures retained for diagnostics when strict feed parsing is enabled
+var MaxCacheParseFailures = 100
+
+// Default number of skipped sequences included in cache diagnostics
+const DefaultSkippedSeqReportCount = 100
+
+// Default number of channel caches included in each page of cache diagnostics
+const DefaultChannelCacheReportCount = 100
+
+// Max number of changed channels buffered for notification while the change cache has no notifyChange callback
+var MaxUnnotifiedChannels = 10000
+
+// Max wait for feed events in progress to return when the cache is stopped.  Var to support testing
+var FeedEventsStopMaxWait = 30 * time.Second
+
+// Interval at which the sharded feed stats are flushed, along with the caching stats accrued by cache housekeeping.
+// Var to support testing
+var FeedStatsFlushInterval = 500 * time.Millisecond
+
+// Max number of unreceived sequences tracked as gaps by a cache bypassing sequence buffering, to tell late arriving
+// sequences from duplicates.  The oldest gaps over the limit are moved to the skipped sequence queue
+var MaxUnbufferedGaps = 10000
+
+// Minimum interval between warnings for principal docs that can't be unmarshalled on the feed
+var PrincipalParseWarnInterval = time.Minute
+
+// Minimum interval between warnings for changes received with feed latency above the warn threshold
+var FeedLagWarnInterval = time.Minute
+
+// The number of most recently received changes the mean feed lag stat is calculated over
+const feedLagMeanCapacity = 1000
+
+// Sequences a change can arrive ahead of nextSequence before it's reported as a sequence gap
+var SequenceGapWarnThreshold uint64 = 1000
+
+// Minimum interval between warnings for sequence gaps
+var SequenceGapWarnInterval = 30 * time.Second
+
+// Max number of times GetChanges is retried when the cache is cleared while changes are being read
+var MaxCacheGenerationRetries = 3
 
 // Manages a cache of the recent change history of all channels.
 //
@@ -49,17 +98,34 @@ var EnableStarChannelLog = true
 //    - Perform sequence buffering to ensure documents are received in sequence order
 //    - Propagating DCP changes down to appropriate channel caches
 type changeCache struct {
-	context            *DatabaseContext
+	backingStore       cacheBackingStore       // Database operations used by the cache
+	dbName             string                  // Database name, for logging
+	dbStats            *base.DbStats           // Stats for the database
+	dbOptions          *DatabaseContextOptions // Database options
 	logsDisabled       bool                    // If true, ignore incoming tap changes
+	paused             bool                    // Set by Pause - entries update the sequence state, but aren't cached.  Guarded by lock
+	pauseStatus        CachePauseStatus        // Sequences the cache was last paused and resumed at.  Guarded by lock
+	pausedChannels     base.Set                // Channels changed while paused, notified on resume.  Guarded by lock
 	nextSequence       uint64                  // Next consecutive sequence number to add.  State variable for sequence buffering tracking.  Should use getNextSequence() rather than accessing directly.
 	initialSequence    uint64                  // DB's current sequence at startup time. Should use getInitialSequence() rather than accessing directly.
-	receivedSeqs       map[uint64]struct{}     // Set of all sequences received
+	receivedSeqs       map[uint64]struct{}     // Sequences received but not yet cached - removed once cached, so bounded by pendingLogs
 	pendingLogs        LogPriorityQueue        // Out-of-sequence entries waiting to be cached
-	notifyChange       func(base.Set)          // Client callback that notifies of channel changes
+	pendingLogsBytes   int64                   // Estimated memory used by pendingLogs - see estimatedPendingEntryBytes
+	notifyChange       func(base.Set)          // Client callback that notifies of channel changes.  Should use SetNotifyChange rather than assigning directly
+	notifyLock         sync.RWMutex            // Coordinates access to notifyChange and unnotified
+	unnotified         base.Set                // Changed channels buffered while there's no notifyChange callback
+	notifyDebouncer    *notifyPacer            // Batches notifications over NotificationDebounceInterval, when set
 	stopped            bool                    // Set by the Stop method
+	feedEventsStopped  bool                    // Set once DocChanged stops accepting feed events.  Guarded by lock
+	feedEventsInFlight sync.WaitGroup          // DocChanged calls in progress
 	skippedSeqs        *SkippedSequenceList    // Skipped sequences still pending on the TAP feed
+	cleanSkippedLock   sync.Mutex              // Serializes CleanSkippedSequenceQueue runs
+	skippedLimitClean  int32                   // Set while the oldest skipped sequences are cleaned for exceeding CacheSkippedSeqMaxNum.  Accessed atomically
+	unbufferedGaps     *SkippedSequenceList    // Unreceived sequences below nextSequence, when bypassing sequence buffering.  Guarded by lock
+	isAllocated        func(uint64) bool       // Whether a sequence could have been allocated by this node, to detect other writers when bypassing sequence buffering.  Optional
 	lock               sync.RWMutex            // Coordinates access to struct fields
 	options            CacheOptions            // Cache config
+	optionsModified    map[string]time.Time    // Time each cache option was last updated at runtime, by CacheOptions field name.  Guarded by lock
 	terminator         chan bool               // Signal termination of background goroutines
 	backgroundTasks    []BackgroundTask        // List of background tasks.
 	initTime           time.Time               // Cache init time - used for latency calculations
@@ -67,32 +133,158 @@ type changeCache struct {
 	lastAddPendingTime int64                   // The most recent time _addPendingLogs was run, as epoch time
 	internalStats      changeCacheStats        // Running stats for the change cache.  Only applied to expvars on a call to changeCache.updateStats
 	cfgEventCallback   base.CfgEventNotifyFunc // Callback for Cfg updates recieved over the caching feed
+	parseFailures      []CacheParseFailure     // Most recent feed parse failures, retained when strict feed parsing is enabled
+	parseFailuresLock  sync.Mutex              // Coordinates access to parseFailures
+	lastPrincipalWarn  int64                   // The most recent time a principal parse failure was logged at warn, as epoch time
+	lastFeedLagWarn    int64                   // The most recent time high feed latency was logged at warn, as epoch time
+	lastGapWarn        int64                   // The most recent time a sequence gap was logged at warn, as epoch time.  Guarded by lock
+	inSequenceGap      bool                    // Set while pendingLogs is waiting on a sequence gap - see _recordSequenceGap.  Guarded by lock
+	generation         uint64                  // Incremented before and after each Clear - odd while a Clear is in progress.  Accessed atomically
+	nonMobileReporter  *base.LogCoalescer      // Summarizes feed documents ignored for not having valid sync data
+	emptyMetaReporter  *base.LogCoalescer      // Summarizes feed documents with unexpected empty metadata
+	channelVerifier    *channelVerifier        // Verifies the channels of a sample of cached revisions, when enabled
+	resyncAdvisor      *resyncAdvisor          // Notified of abandoned sequences, when enabled
+	channelWebhooks    *channelWebhookNotifier // Summarises cached entries for channel webhooks, when configured
+	sequenceWaitLock   sync.Mutex              // Coordinates access to sequenceWaitChan
+	sequenceWaitChan   chan struct{}           // Closed to wake sequence waiters when nextSequence advances or skipped sequences are removed.  Created on demand
+	sequenceClock      sequenceClock           // Estimates the time at which a sequence was current
+	pendingSeqMaxWait  time.Duration           // Max wait for a pending sequence - CachePendingSeqMaxWait unless adaptive.  Guarded by lock
+	pendingWaitWindow  pendingWaitWindow       // Pending waits observed since the pending wait was last adapted.  Guarded by lock
+	handoff            *cacheHandoff           // Validated cache handoff snapshot to seed channel caches from on Start, if any
+	stageSampler       *feedStageSampler       // Selects the feed events whose processing stages are timed
+	cachingStats       feedCachingStats        // Caching stats accrued while holding lock, recorded to the sharded stats once it's released.  Guarded by lock
+}
+
+// feedCachingStats are the caching count and time for the feed entries cached while holding the cache lock.
+type feedCachingStats struct {
+	count int64
+	nanos int64
+}
+
+// cacheBackingStore is the subset of database operations used by the changeCache.  DatabaseContext is the
+// production implementation - tests may supply a lightweight fake to exercise the cache without a bucket.
+type cacheBackingStore interface {
+	ChannelQueryHandler
+	LastSequence() (uint64, error)
+	getChangesForSequences(ctx context.Context, sequences []uint64) (LogEntries, error)
+	GetDocument(docid string, unmarshalLevel DocumentUnmarshalLevel) (*Document, error)
+	UseXattrs() bool
+	checkForUpgrade(key string, unmarshalLevel DocumentUnmarshalLevel) (*Document, *sgbucket.BucketDocument)
 }
 
 type changeCacheStats struct {
-	highSeqFeed   uint64
-	pendingSeqLen int
-	maxPending    int
+	highSeqFeed uint64
+	maxPending  int
+	maxFeedLag  int64                  // Highest feed latency since the last updateStats, in ms.  Accessed atomically, as it's updated outside lock
+	feedLagMean base.IntRollingMeanVar // Mean feed latency of the most recently received changes, in ms
 }
 
 func (c *changeCache) updateStats() {
 
 	c.lock.Lock()
 
-	c.context.DbStats.Database().HighSeqFeed.SetIfMax(int64(c.internalStats.highSeqFeed))
-	c.context.DbStats.Cache().PendingSeqLen.Set(int64(c.internalStats.pendingSeqLen))
-	c.context.DbStats.CBLReplicationPull().MaxPending.SetIfMax(int64(c.internalStats.maxPending))
-	c.context.DbStats.Cache().HighSeqStable.Set(int64(c._getMaxStableCached()))
+	c.dbStats.Database().HighSeqFeed.SetIfMax(int64(c.internalStats.highSeqFeed))
+	c.dbStats.CBLReplicationPull().MaxPending.SetIfMax(int64(c.internalStats.maxPending))
+	c.dbStats.Cache().HighSeqStable.Set(int64(c._getMaxStableCached()))
+	c.dbStats.Cache().MaxFeedLag.Set(atomic.SwapInt64(&c.internalStats.maxFeedLag, 0))
+	c.dbStats.Cache().MeanFeedLag.Set(c.internalStats.feedLagMean.Value())
 
 	c.lock.Unlock()
 }
 
+// _updatePendingStats updates the pending sequence gauges, following any change to pendingLogs, and closes any sequence
+// gap once pendingLogs is drained.  Requires the caller to hold c.lock.
+func (c *changeCache) _updatePendingStats() {
+	c.dbStats.Cache().PendingSeqLen.Set(int64(len(c.pendingLogs)))
+	c.dbStats.Cache().PendingSeqBytes.Set(c.pendingLogsBytes)
+	if c.inSequenceGap && len(c.pendingLogs) == 0 {
+		c.inSequenceGap = false
+		c.dbStats.Cache().SequenceGap.Set(0)
+	}
+}
+
+// _recordSequenceGap records a gap when a sequence arrives more than SequenceGapWarnThreshold ahead of nextSequence.
+// The sequence_gap stat reports the largest gap since pendingLogs was last drained, and max_sequence_gap the largest
+// gap seen.  Each gap is warned about once, at most once per SequenceGapWarnInterval, rather than for each sequence
+// deferred behind it.  Requires the caller to hold c.lock.
+func (c *changeCache) _recordSequenceGap(sequence uint64, numPending int) {
+	gap := sequence - c.nextSequence
+	if gap <= SequenceGapWarnThreshold {
+		return
+	}
+	cacheStats := c.dbStats.Cache()
+	cacheStats.SequenceGap.SetIfMax(int64(gap))
+	cacheStats.MaxSequenceGap.SetIfMax(int64(gap))
+	if c.inSequenceGap {
+		return
+	}
+	c.inSequenceGap = true
+
+	now := time.Now().UnixNano()
+	if now-c.lastGapWarn >= int64(SequenceGapWarnInterval) {
+		c.lastGapWarn = now
+		base.Warnf("Sequence gap of %d for database %s - received #%d while waiting for #%d, with %d sequences pending",
+			gap, base.MD(c.dbName), sequence, c.nextSequence, numPending)
+	} else {
+		base.Infof(base.KeyCache, "Sequence gap of %d - received #%d while waiting for #%d, with %d sequences pending",
+			gap, sequence, c.nextSequence, numPending)
+	}
+}
+
+// Estimated memory used by each entry of a LogEntry's channel map, other than the channel name.
+const channelMapEntryOverheadBytes = 50
+
+// estimatedPendingEntryBytes returns the estimated memory used by a pending entry - that of a cached entry, plus its
+// channel map.
+func estimatedPendingEntryBytes(entry *LogEntry) int64 {
+	bytes := estimatedLogEntryBytes(entry)
+	for channelName := range entry.Channels {
+		bytes += int64(channelMapEntryOverheadBytes + len(channelName))
+	}
+	return bytes
+}
+
+// _pushPendingLog adds change to pendingLogs, accounting for its memory.  Requires the caller to hold c.lock.
+func (c *changeCache) _pushPendingLog(change *LogEntry) {
+	heap.Push(&c.pendingLogs, change)
+	c.pendingLogsBytes += estimatedPendingEntryBytes(change)
+}
+
+// _popPendingLog removes and returns the lowest sequence in pendingLogs, accounting for its memory.  Requires the
+// caller to hold c.lock.
+func (c *changeCache) _popPendingLog() *LogEntry {
+	change := heap.Pop(&c.pendingLogs).(*LogEntry)
+	c.pendingLogsBytes -= estimatedPendingEntryBytes(change)
+	return change
+}
+
+// _pendingLimitExceeded returns true when pendingLogs holds more entries than CachePendingSeqMaxNum, or more memory
+// than CachePendingSeqMaxBytes, so that the oldest missing sequence should be skipped.  Requires the caller to hold
+// c.lock.
+func (c *changeCache) _pendingLimitExceeded() bool {
+	if len(c.pendingLogs) > c.options.CachePendingSeqMaxNum {
+		return true
+	}
+	return c.options.CachePendingSeqMaxBytes > 0 && c.pendingLogsBytes > c.options.CachePendingSeqMaxBytes
+}
+
+// updateSkippedStats updates the skipped sequence gauges, following any change to skippedSeqs.  Also refreshes the age
+// of the oldest skipped sequence as entries arrive.
+func (c *changeCache) updateSkippedStats() {
+	c.dbStats.Cache().SkippedSeqLen.Set(c.skippedSeqs.getNumSequences())
+	c.dbStats.Cache().OldestSkippedSeqAge.Set(int64(c.skippedSeqs.getOldestAge(time.Now()) / time.Second))
+}
+
 type LogEntry channels.LogEntry
 
 func (l LogEntry) String() string {
 	return channels.LogEntry(l).String()
 }
 
+func (l LogEntry) RedactedString() string {
+	return channels.LogEntry(l).RedactedString()
+}
+
 func (entry *LogEntry) IsRemoved() bool {
 	return entry.Flags&channels.Removed != 0
 }
@@ -119,9 +311,48 @@ type LogEntries []*LogEntry
 // A priority-queue of LogEntries, kept ordered by increasing sequence #.
 type LogPriorityQueue []*LogEntry
 
+// CacheParseFailure identifies a feed document whose sync metadata couldn't be unmarshalled by the cache.
+type CacheParseFailure struct {
+	DocID string    `json:"doc_id"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
 type SkippedSequence struct {
-	seq       uint64
-	timeAdded time.Time
+	seq        uint64
+	timeAdded  time.Time
+	pendingSeq uint64 // Lowest pending sequence at the time seq was skipped
+	pendingLen int    // Number of pending sequences at the time seq was skipped
+}
+
+func (s SkippedSequence) String() string {
+	return fmt.Sprintf("seq: %d added: %s pending: %d (lowest #%d)", s.seq, s.timeAdded.Format(time.RFC3339), s.pendingLen, s.pendingSeq)
+}
+
+// SkippedSequenceInfo describes a skipped sequence, along with the state of the pending queue that caused it to be
+// skipped.
+type SkippedSequenceInfo struct {
+	Sequence   uint64    `json:"seq"`
+	TimeAdded  time.Time `json:"time_added"`
+	PendingSeq uint64    `json:"pending_seq"` // Lowest pending sequence at the time the sequence was skipped
+	PendingLen int       `json:"pending_len"` // Number of pending sequences at the time the sequence was skipped
+}
+
+// SkippedSequenceReport describes the change cache's skipped sequences and sequence buffering state, for diagnosing a
+// stable sequence that isn't advancing.
+type SkippedSequenceReport struct {
+	NextSequence   uint64                `json:"next_sequence"`              // Next sequence to be cached in order
+	NumSkipped     int64                 `json:"num_skipped"`                // Number of skipped sequences
+	Skipped        []SkippedSequenceInfo `json:"skipped"`                    // Up to the requested number of skipped sequences, oldest first
+	PendingLen     int                   `json:"pending_len"`                // Number of sequences received out of order, awaiting earlier sequences
+	PendingLowSeq  uint64                `json:"pending_low_seq,omitempty"`  // Lowest pending sequence
+	PendingHighSeq uint64                `json:"pending_high_seq,omitempty"` // Highest pending sequence
+	Pending        string                `json:"pending,omitempty"`          // Pending entries in sequence order, with doc IDs and channels tagged as user data
+}
+
+// RedactedString is provided for parity with LogEntry - skipped sequences don't carry any user data.
+func (s SkippedSequence) RedactedString() string {
+	return s.String()
 }
 
 type CacheOptions struct {
@@ -129,13 +360,54 @@ type CacheOptions struct {
 	CachePendingSeqMaxWait time.Duration // Max wait for pending sequence before skipping
 	CachePendingSeqMaxNum  int           // Max number of pending sequences before skipping
 	CacheSkippedSeqMaxWait time.Duration // Max wait for skipped sequence before abandoning
+	CacheSkippedSeqMaxNum  int           // Max number of skipped sequences before the oldest are abandoned early.  Zero means no limit
+	SequenceWaitTimeout    time.Duration // Max wait for a sequence to be cached, when a request waits for it
+	FeedLagWarnThreshold   time.Duration // Feed latency above which a change is counted and warned about
+	Profile                string        // Name of the cache profile the options are based on, if any
+
+	// BypassSequenceBuffering caches changes as they arrive rather than in sequence order, for databases with a single
+	// writer node.  Changes arriving after a later sequence are cached as late sequences, without holding back the
+	// stable sequence unless they take longer than the pending wait to arrive.  Requires that no other node allocates
+	// sequences - see validateSequenceBufferingBypass.
+	BypassSequenceBuffering bool
+
+	// AdaptivePendingSeqMaxWait adapts the max wait for a pending sequence to the delays of sequences arriving out of
+	// order, between CachePendingSeqMinWait and CachePendingSeqMaxWait - see adaptPendingSeqMaxWait.  Disabled by a
+	// runtime update of CachePendingSeqMaxWait.
+	AdaptivePendingSeqMaxWait bool
+	CachePendingSeqMinWait    time.Duration // Min wait for pending sequence before skipping, when adaptive
+
+	// NotificationDebounceInterval batches change notifications under heavy write load - changed channels are
+	// accumulated and notified at most once per interval.  Zero notifies each change immediately.
+	NotificationDebounceInterval time.Duration
+
+	// CachePendingSeqMaxBytes caps the estimated memory used by pending sequences, as CachePendingSeqMaxNum caps their
+	// number - entries for docs in many channels can be large.  Zero means no limit.
+	CachePendingSeqMaxBytes int64
+
+	// Handoff hands the largest channel caches over to the next node to start, when the node is shut down gracefully -
+	// see cacheHandoff.
+	Handoff CacheHandoffOptions
+
+	// FeedStageSampleRate samples one in every FeedStageSampleRate feed events to record the time spent in each stage
+	// of processing them, in the feed_stage_* cache stats.  Zero disables sampling.
+	FeedStageSampleRate int
 }
 
 func DefaultCacheOptions() CacheOptions {
 	return CacheOptions{
 		CachePendingSeqMaxWait: DefaultCachePendingSeqMaxWait,
 		CachePendingSeqMaxNum:  DefaultCachePendingSeqMaxNum,
+		CachePendingSeqMinWait: DefaultCachePendingSeqMinWait,
 		CacheSkippedSeqMaxWait: DefaultSkippedSeqMaxWait,
+		SequenceWaitTimeout:    base.DefaultWaitForSequence,
+		FeedLagWarnThreshold:   DefaultFeedLagWarnThreshold,
+		FeedStageSampleRate:    DefaultFeedStageSampleRate,
+		Handoff: CacheHandoffOptions{
+			MaxChannels: DefaultCacheHandoffMaxChannels,
+			MaxBytes:    DefaultCacheHandoffMaxBytes,
+			MaxAge:      DefaultCacheHandoffMaxAge,
+		},
 		ChannelCacheOptions: ChannelCacheOptions{
 			ChannelCacheAge:             DefaultChannelCacheAge,
 			ChannelCacheMinLength:       DefaultChannelCacheMinLength,
@@ -144,6 +416,7 @@ func DefaultCacheOptions() CacheOptions {
 			CompactHighWatermarkPercent: DefaultCompactHighWatermarkPercent,
 			CompactLowWatermarkPercent:  DefaultCompactLowWatermarkPercent,
 			ChannelQueryLimit:           DefaultQueryPaginationLimit,
+			EnableStarChannel:           true,
 		},
 	}
 }
@@ -156,13 +429,29 @@ func DefaultCacheOptions() CacheOptions {
 // After calling Init(), you must call .Start() to start useing the cache, otherwise it will be in a locked state
 // and callers will block on trying to obtain the lock.
 func (c *changeCache) Init(dbcontext *DatabaseContext, notifyChange func(base.Set), options *CacheOptions) error {
-	c.context = dbcontext
+	return c.init(dbcontext.Name, dbcontext.DbStats, &dbcontext.Options, dbcontext, dbcontext.activeChannels, notifyChange, options)
+}
+
+// init initializes the changeCache against the given backing store, for use by Init and by tests that don't
+// require a full database.
+func (c *changeCache) init(dbName string, dbStats *base.DbStats, dbOptions *DatabaseContextOptions, backingStore cacheBackingStore,
+	activeChannels *channels.ActiveChannels, notifyChange func(base.Set), options *CacheOptions) error {
+	c.backingStore = backingStore
+	c.dbName = dbName
+	c.dbStats = dbStats
+	c.dbOptions = dbOptions
+	c.nonMobileReporter = base.NewLogCoalescer(base.LevelInfo, base.KeyCache, "changeCache: Ignored docs without valid sync data",
+		base.DefaultLogCoalesceInterval, c.dbStats.Cache().NonMobileIgnoredCount)
+	c.emptyMetaReporter = base.NewLogCoalescer(base.LevelWarn, base.KeyAll, "changeCache: Unexpected empty metadata when processing feed events",
+		base.DefaultLogCoalesceInterval, c.dbStats.Cache().EmptyMetadataCount)
 
 	c.notifyChange = notifyChange
 	c.receivedSeqs = make(map[uint64]struct{})
 	c.terminator = make(chan bool)
 	c.initTime = time.Now()
+	c.internalStats.feedLagMean = base.NewIntRollingMeanVar(feedLagMeanCapacity)
 	c.skippedSeqs = NewSkippedSequenceList()
+	c.unbufferedGaps = NewSkippedSequenceList()
 	c.lastAddPendingTime = time.Now().UnixNano()
 
 	// init cache options
@@ -171,25 +460,57 @@ func (c *changeCache) Init(dbcontext *DatabaseContext, notifyChange func(base.Se
 	} else {
 		c.options = DefaultCacheOptions()
 	}
+	c.options.ChannelCacheOptions.EntryChecksums = dbOptions.UnsupportedOptions.CacheEntryChecksums
+	if dbOptions.FilterExpiredTombstones {
+		c.options.ChannelCacheOptions.ExpiredTombstoneAge = dbOptions.ClientPartitionWindow
+	}
+	if c.options.AdaptivePendingSeqMaxWait && (c.options.CachePendingSeqMinWait <= 0 || c.options.CachePendingSeqMinWait > c.options.CachePendingSeqMaxWait) {
+		base.Warnf("Pending sequence min wait %v for database %s isn't between zero and max wait - using max wait %v",
+			c.options.CachePendingSeqMinWait, base.MD(c.dbName), c.options.CachePendingSeqMaxWait)
+		c.options.CachePendingSeqMinWait = c.options.CachePendingSeqMaxWait
+	}
+	c._setPendingSeqMaxWait(c.options.CachePendingSeqMaxWait)
+	c.notifyDebouncer = newFixedNotifyPacer(c.options.NotificationDebounceInterval, c.sendNotification)
+	c.stageSampler = newFeedStageSampler(c.options.FeedStageSampleRate)
+	c.pendingWaitWindow.baseline = c.dbStats.Cache().PendingSeqWait.Snapshot()
 
-	channelCache, err := NewChannelCacheForContext(c.options.ChannelCacheOptions, c.context)
+	channelCache, err := newChannelCache(c.dbName, c.options.ChannelCacheOptions, c.backingStore, activeChannels, c.dbStats.Cache())
 	if err != nil {
 		return err
 	}
 	c.channelCache = channelCache
 
-	base.Infof(base.KeyCache, "Initializing changes cache for database %s with options %+v", base.UD(dbcontext.Name), c.options)
+	base.Infof(base.KeyCache, "Initializing changes cache for database %s with options %+v", base.UD(c.dbName), c.options)
 
 	heap.Init(&c.pendingLogs)
 
-	// background tasks that perform housekeeping duties on the cache
-	bgt, err := NewBackgroundTask("InsertPendingEntries", c.context.Name, c.InsertPendingEntries, c.options.CachePendingSeqMaxWait/2, c.terminator)
+	// background tasks that perform housekeeping duties on the cache.  An adaptive pending wait can drop to the min
+	// wait, so pending entries are checked at half that interval.
+	insertPendingInterval := c.options.CachePendingSeqMaxWait / 2
+	if c.options.AdaptivePendingSeqMaxWait {
+		insertPendingInterval = c.options.CachePendingSeqMinWait / 2
+	}
+	bgt, err := NewBackgroundTask("InsertPendingEntries", c.dbName, c.InsertPendingEntries, insertPendingInterval, c.terminator)
+	if err != nil {
+		return err
+	}
+	c.backgroundTasks = append(c.backgroundTasks, bgt)
+
+	if c.options.AdaptivePendingSeqMaxWait {
+		bgt, err = NewBackgroundTask("AdaptPendingSeqMaxWait", c.dbName, c.adaptPendingSeqMaxWait, AdaptivePendingSeqWaitInterval, c.terminator)
+		if err != nil {
+			return err
+		}
+		c.backgroundTasks = append(c.backgroundTasks, bgt)
+	}
+
+	bgt, err = NewBackgroundTask("CleanSkippedSequenceQueue", c.dbName, c.CleanSkippedSequenceQueue, c.options.CacheSkippedSeqMaxWait/2, c.terminator)
 	if err != nil {
 		return err
 	}
 	c.backgroundTasks = append(c.backgroundTasks, bgt)
 
-	bgt, err = NewBackgroundTask("CleanSkippedSequenceQueue", c.context.Name, c.CleanSkippedSequenceQueue, c.options.CacheSkippedSeqMaxWait/2, c.terminator)
+	bgt, err = NewBackgroundTask("FlushFeedStats", c.dbName, c.flushFeedStats, FeedStatsFlushInterval, c.terminator)
 	if err != nil {
 		return err
 	}
@@ -211,6 +532,13 @@ func (c *changeCache) Start(initialSequence uint64) error {
 	// Set initial sequence for cache (validFrom)
 	c.channelCache.Init(initialSequence)
 
+	// Seed channel caches from the handoff snapshot before any feed events are processed, so that none are missed by a
+	// seeded cache
+	if c.handoff != nil {
+		c._seedFromHandoff(c.handoff, initialSequence)
+		c.handoff = nil
+	}
+
 	return nil
 }
 
@@ -221,16 +549,33 @@ func (c *changeCache) Stop() {
 		return
 	}
 
+	// Feed events are normally stopped by DatabaseContext.Close before the cache is stopped.  No-op if so.
+	if !c.stopFeedEvents(FeedEventsStopMaxWait) {
+		base.Warnf("Timeout after %v waiting for feed events in progress while stopping the change cache for database %s", FeedEventsStopMaxWait, base.MD(c.dbName))
+	}
+
 	// Signal to background goroutines that the changeCache has been stopped, so they can exit
 	// their loop
 	close(c.terminator)
 
 	// Wait for changeCache background tasks to finish.
-	waitForBGTCompletion(BGTCompletionMaxWait, c.backgroundTasks, c.context.Name)
+	waitForBGTCompletion(BGTCompletionMaxWait, c.backgroundTasks, c.dbName)
+
+	// Notify any changes still awaiting the end of the debounce interval
+	if c.notifyDebouncer != nil {
+		c.notifyDebouncer.drain()
+	}
 
 	// Stop the channel cache and it's background tasks.
 	c.channelCache.Stop()
 
+	// Report any coalesced feed warnings not yet logged
+	c.nonMobileReporter.Flush()
+	c.emptyMetaReporter.Flush()
+
+	// Fold the sharded feed stats into their totals
+	_ = c.flushFeedStats(context.Background())
+
 	c.lock.Lock()
 	c.logsDisabled = true
 	c.lock.Unlock()
@@ -246,32 +591,80 @@ func (c *changeCache) setStopped() bool {
 	return true
 }
 
+// stopFeedEvents makes subsequent DocChanged calls no-ops, and waits up to maxWait for those in progress to return.
+// Returns false if they hadn't returned by maxWait.
+func (c *changeCache) stopFeedEvents(maxWait time.Duration) bool {
+	c.lock.Lock()
+	c.feedEventsStopped = true
+	c.lock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		c.feedEventsInFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return true
+	case <-time.After(maxWait):
+		return false
+	}
+}
+
+// startFeedEvent registers a DocChanged call in progress, which must call feedEventsInFlight.Done on return.  Returns
+// false once feed events have been stopped.
+func (c *changeCache) startFeedEvent() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.feedEventsStopped {
+		return false
+	}
+	c.feedEventsInFlight.Add(1)
+	return true
+}
+
 func (c *changeCache) IsStopped() bool {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	return c.stopped
 }
 
-// Empty out all channel caches.
+// Empty out all channel caches.  Returns base.ErrDatabaseClosed once the cache has been stopped.
 func (c *changeCache) Clear() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	if c.stopped {
+		return base.ErrDatabaseClosed
+	}
+
+	// Bump the generation on both sides of the reset, so that concurrent GetChanges calls that may have read from the
+	// caches being replaced can detect it and retry
+	atomic.AddUint64(&c.generation, 1)
+	defer atomic.AddUint64(&c.generation, 1)
 
 	// Reset initialSequence so that any new channel caches have their validFrom set to the current last sequence
 	// the point at which the change cache was initialized / re-initialized.
 	// No need to touch c.nextSequence here, because we don't want to touch the sequence buffering state.
 	var err error
-	c.initialSequence, err = c.context.LastSequence()
+	c.initialSequence, err = c.backingStore.LastSequence()
 	if err != nil {
 		return err
 	}
 
 	c.pendingLogs = nil
+	c.pendingLogsBytes = 0
 	heap.Init(&c.pendingLogs)
+	c.receivedSeqs = make(map[uint64]struct{})
+	c._updatePendingStats()
 
 	c.initTime = time.Now()
 
 	c.channelCache.Clear()
+
+	// Feeds waiting on changes batched for notification are woken now, rather than at the end of the interval
+	if c.notifyDebouncer != nil {
+		c.notifyDebouncer.flush()
+	}
 	return nil
 }
 
@@ -282,21 +675,93 @@ func (c *changeCache) EnableChannelIndexing(enable bool) {
 	c.lock.Unlock()
 }
 
-// Triggers addPendingLogs if it hasn't been run in CachePendingSeqMaxWait.  Error returned to fulfil BackgroundTaskFunc signature.
+// SetNotifyChange replaces the callback notified of channel changes, for use when the changes listener is recreated.
+// While there's no callback, changed channels are buffered (up to MaxUnnotifiedChannels), and are notified to the next
+// callback set.  Once SetNotifyChange returns, notifications are no longer sent to the previous callback.
+func (c *changeCache) SetNotifyChange(notifyChange func(base.Set)) {
+	c.notifyLock.Lock()
+	defer c.notifyLock.Unlock()
+	c.notifyChange = notifyChange
+	if notifyChange != nil && len(c.unnotified) > 0 {
+		base.Debugf(base.KeyCache, "Notifying %d channels changed while there was no notifyChange callback", len(c.unnotified))
+		notifyChange(c.unnotified)
+		c.unnotified = nil
+	}
+}
+
+// notifyChanged notifies the notifyChange callback of changed channels, or buffers them when there's no callback.
+// When NotificationDebounceInterval is set, changed channels are batched, and notified at the end of the interval.
+func (c *changeCache) notifyChanged(changedChannels base.Set) {
+	if c.notifyDebouncer != nil {
+		c.notifyDebouncer.Notify(changedChannels)
+		return
+	}
+	if len(changedChannels) == 0 {
+		return
+	}
+	c.sendNotification(changedChannels)
+}
+
+// sendNotification notifies the notifyChange callback of changed channels, or buffers them when there's no callback.
+func (c *changeCache) sendNotification(changedChannels base.Set) {
+	c.notifyLock.RLock()
+	if c.notifyChange != nil {
+		c.notifyChange(changedChannels)
+		c.notifyLock.RUnlock()
+		return
+	}
+	c.notifyLock.RUnlock()
+
+	c.notifyLock.Lock()
+	defer c.notifyLock.Unlock()
+	if c.notifyChange != nil {
+		c.notifyChange(changedChannels)
+		return
+	}
+	if c.unnotified == nil {
+		c.unnotified = make(base.Set, len(changedChannels))
+	}
+	dropped := 0
+	for channelName := range changedChannels {
+		if len(c.unnotified) >= MaxUnnotifiedChannels && !c.unnotified.Contains(channelName) {
+			dropped++
+			continue
+		}
+		c.unnotified.Add(channelName)
+	}
+	if dropped > 0 {
+		base.Infof(base.KeyCache, "Dropped notification of %d changed channels - %d channels already awaiting a notifyChange callback", dropped, len(c.unnotified))
+	}
+}
+
+// Triggers addPendingLogs if it hasn't been run in the pending wait.  Error returned to fulfil BackgroundTaskFunc signature.
 func (c *changeCache) InsertPendingEntries(ctx context.Context) error {
 
 	lastAddPendingLogsTime := atomic.LoadInt64(&c.lastAddPendingTime)
-	if time.Since(time.Unix(0, lastAddPendingLogsTime)) < c.options.CachePendingSeqMaxWait {
+	c.lock.RLock()
+	maxWait := c.pendingSeqMaxWait
+	bypassBuffering := c.options.BypassSequenceBuffering
+	c.lock.RUnlock()
+
+	// When bypassing sequence buffering, gaps take the place of pending sequences, so are skipped after the same wait
+	if bypassBuffering {
+		c.lock.Lock()
+		c._skipUnbufferedGaps(math.MaxInt64, time.Now().Add(-maxWait))
+		c.lock.Unlock()
+		return nil
+	}
+
+	if time.Since(time.Unix(0, lastAddPendingLogsTime)) < maxWait {
 		return nil
 	}
 
 	// Trigger _addPendingLogs to process any entries that have been pending too long:
 	c.lock.Lock()
 	changedChannels := c._addPendingLogs()
-	if c.notifyChange != nil && len(changedChannels) > 0 {
-		c.notifyChange(changedChannels)
-	}
+	c.notifyChanged(changedChannels)
+	cached := c._takeCachingStats()
 	c.lock.Unlock()
+	c.recordCachingStats(0, cached)
 
 	return nil
 }
@@ -304,43 +769,114 @@ func (c *changeCache) InsertPendingEntries(ctx context.Context) error {
 // Cleanup function, invoked periodically.
 // Removes skipped entries from skippedSeqs that have been waiting longer
 // than MaxChannelLogMissingWaitTime from the queue.  Attempts view retrieval
-// prior to removal.  Only locks skipped sequence queue to build the initial set (GetSkippedSequencesOlderThanMaxWait)
-// and subsequent removal (RemoveSkipped).
+// prior to removal.  Only locks skipped sequence queue to build the initial set (GetSkippedSequencesOlderThanMaxWait,
+// which reads the queue in pages) and subsequent removal (RemoveSkipped).  Runs are serialized, as they may also be triggered on demand.
 func (c *changeCache) CleanSkippedSequenceQueue(ctx context.Context) error {
 
-	oldSkippedSequences := c.GetSkippedSequencesOlderThanMaxWait()
-	if len(oldSkippedSequences) == 0 {
+	c.cleanSkippedLock.Lock()
+	defer c.cleanSkippedLock.Unlock()
+
+	oldSkippedRanges := c.GetSkippedSequencesOlderThanMaxWait()
+	if len(oldSkippedRanges) == 0 {
 		return nil
 	}
 
-	base.InfofCtx(ctx, base.KeyCache, "Starting CleanSkippedSequenceQueue, found %d skipped sequences older than max wait for database %s", len(oldSkippedSequences), base.MD(c.context.Name))
+	base.InfofCtx(ctx, base.KeyCache, "Starting CleanSkippedSequenceQueue, found %d skipped sequences older than max wait for database %s", numSkippedSequences(oldSkippedRanges), base.MD(c.dbName))
+	c._cleanSkippedSequences(ctx, oldSkippedRanges)
+	return nil
+}
+
+// cleanSkippedSequencesOverLimit cleans the oldest skipped sequences early when the skipped sequence queue holds more
+// than CacheSkippedSeqMaxNum sequences, so that a large range of sequences lost by the feed doesn't hold back the
+// stable sequence, and consume memory, for the full skipped sequence max wait.  The oldest sequences over the limit are
+// cached when found by query, and otherwise abandoned, as by CleanSkippedSequenceQueue.  The excess is cleaned in pages
+// of SkippedSeqIterationPageSize sequences, so that a large lost range isn't read from the queue all at once.
+func (c *changeCache) cleanSkippedSequencesOverLimit(ctx context.Context) {
+
+	c.cleanSkippedLock.Lock()
+	defer c.cleanSkippedLock.Unlock()
+
+	c.lock.RLock()
+	maxNum := c.options.CacheSkippedSeqMaxNum
+	c.lock.RUnlock()
+	if maxNum <= 0 {
+		return
+	}
+	excess := c.skippedSeqs.getNumSequences() - int64(maxNum)
+	if excess <= 0 {
+		return
+	}
+
+	base.WarnfCtx(ctx, "Skipped sequence queue for database %s holds %d sequences over the limit of %d - the oldest will be abandoned early unless found by query", base.MD(c.dbName), excess, maxNum)
+	for excess > 0 && !c.IsStopped() {
+		pageSize := int64(SkippedSeqIterationPageSize)
+		if excess < pageSize {
+			pageSize = excess
+		}
+		c._cleanSkippedSequences(ctx, c.skippedSeqs.getOldestSequences(pageSize))
+
+		// Sequences whose query failed are left in the queue, so stop rather than re-query them until the next clean
+		remaining := c.skippedSeqs.getNumSequences() - int64(maxNum)
+		if remaining >= excess {
+			return
+		}
+		excess = remaining
+	}
+}
+
+// triggerSkippedSequenceLimitClean starts cleanSkippedSequencesOverLimit, unless it's already running.  The clean
+// queries for and caches sequences, so runs in its own goroutine, as skipped sequences are pushed holding the cache lock.
+func (c *changeCache) triggerSkippedSequenceLimitClean() {
+	if !atomic.CompareAndSwapInt32(&c.skippedLimitClean, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&c.skippedLimitClean, 0)
+		if c.IsStopped() {
+			return
+		}
+		c.cleanSkippedSequencesOverLimit(context.Background())
+	}()
+}
+
+// _cleanSkippedSequences queries for the sequences in the given skipped ranges, caching those found and abandoning the
+// rest.  Sequences whose query fails are left in the skipped sequence queue.  Requires cleanSkippedLock.
+func (c *changeCache) _cleanSkippedSequences(ctx context.Context, oldSkippedRanges []skippedSequenceRange) {
 
 	var foundEntries []*LogEntry
 	var pendingRemovals []uint64
+	var retainedCount int
+
+	if c.dbOptions.UnsupportedOptions.DisableCleanSkippedQuery == true {
+		for _, skippedRange := range oldSkippedRanges {
+			for seq := skippedRange.start; seq <= skippedRange.end; seq++ {
+				pendingRemovals = append(pendingRemovals, seq)
+			}
+		}
+		oldSkippedRanges = nil
+	}
 
-	if c.context.Options.UnsupportedOptions.DisableCleanSkippedQuery == true {
-		pendingRemovals = append(pendingRemovals, oldSkippedSequences...)
-		oldSkippedSequences = nil
+	// Batches are additionally bounded by the database's query page size
+	batchSize := SkippedSeqCleanViewBatch
+	if c.options.ChannelQueryLimit > 0 && c.options.ChannelQueryLimit < batchSize {
+		batchSize = c.options.ChannelQueryLimit
 	}
 
-	for len(oldSkippedSequences) > 0 {
+	for len(oldSkippedRanges) > 0 {
 		var skippedSeqBatch []uint64
-		if len(oldSkippedSequences) >= SkippedSeqCleanViewBatch {
-			skippedSeqBatch = oldSkippedSequences[0:SkippedSeqCleanViewBatch]
-			oldSkippedSequences = oldSkippedSequences[SkippedSeqCleanViewBatch:]
-		} else {
-			skippedSeqBatch = oldSkippedSequences[0:]
-			oldSkippedSequences = nil
-		}
+		skippedSeqBatch, oldSkippedRanges = nextSkippedBatch(oldSkippedRanges, batchSize)
 
-		base.InfofCtx(ctx, base.KeyCache, "Issuing skipped sequence clean query for %d sequences, %d remain pending (db:%s).", len(skippedSeqBatch), len(oldSkippedSequences), base.MD(c.context.Name))
+		base.InfofCtx(ctx, base.KeyCache, "Issuing skipped sequence clean query for %d sequences, %d remain pending (db:%s).", len(skippedSeqBatch), numSkippedSequences(oldSkippedRanges), base.MD(c.dbName))
 		// Note: The view query is only going to hit for active revisions - sequences associated with inactive revisions
 		//       aren't indexed by the channel view.  This means we can potentially miss channel removals:
 		//       when an older revision is missed by the TAP feed, and a channel is removed in that revision,
 		//       the doc won't be flagged as removed from that channel in the in-memory channel cache.
-		entries, err := c.context.getChangesForSequences(ctx, skippedSeqBatch)
+		// A query error doesn't establish that the sequences don't exist, so they're left in the skipped sequence queue
+		// to be queried again by the next clean, rather than abandoned.
+		entries, err := c.backingStore.getChangesForSequences(ctx, skippedSeqBatch)
 		if err != nil {
-			base.WarnfCtx(ctx, "Error retrieving sequences via query during skipped sequence clean - #%d sequences treated as not found: %v", len(skippedSeqBatch), err)
+			base.WarnfCtx(ctx, "Error retrieving sequences via query during skipped sequence clean - #%d sequences will be retried by the next clean: %v", len(skippedSeqBatch), err)
+			retainedCount += len(skippedSeqBatch)
 			continue
 		}
 
@@ -354,7 +890,11 @@ func (c *changeCache) CleanSkippedSequenceQueue(ctx context.Context) error {
 		// Add queried sequences not in the resultset to pendingRemovals
 		for _, skippedSeq := range skippedSeqBatch {
 			if _, ok := foundMap[skippedSeq]; !ok {
-				base.Warnf("Skipped Sequence %d didn't show up in MaxChannelLogMissingWaitTime, and isn't available from a * channel query.  If it's a valid sequence, it won't be replicated until Sync Gateway is restarted.", skippedSeq)
+				var origin string
+				if skipped, ok := c.skippedSeqs.get(skippedSeq); ok {
+					origin = fmt.Sprintf(" (skipped at %s with %d pending sequences, lowest #%d)", skipped.TimeAdded.Format(time.RFC3339), skipped.PendingLen, skipped.PendingSeq)
+				}
+				base.Warnf("Skipped Sequence %d%s didn't show up in MaxChannelLogMissingWaitTime, and isn't available from a * channel query.  If it's a valid sequence, it won't be replicated until Sync Gateway is restarted.", skippedSeq, origin)
 				pendingRemovals = append(pendingRemovals, skippedSeq)
 			}
 		}
@@ -366,7 +906,7 @@ func (c *changeCache) CleanSkippedSequenceQueue(ctx context.Context) error {
 		entry.Skipped = true
 		// Need to populate the actual channels for this entry - the entry returned from the * channel
 		// view will only have the * channel
-		doc, err := c.context.GetDocument(entry.DocID, DocUnmarshalNoHistory)
+		doc, err := c.backingStore.GetDocument(entry.DocID, DocUnmarshalNoHistory)
 		if err != nil {
 			base.WarnfCtx(ctx, "Unable to retrieve doc when processing skipped document %q: abandoning sequence %d", base.UD(entry.DocID), entry.Sequence)
 			continue
@@ -379,46 +919,63 @@ func (c *changeCache) CleanSkippedSequenceQueue(ctx context.Context) error {
 
 	// Since the calls to processEntry() above may unblock pending sequences, if there were any changed channels we need
 	// to notify any change listeners that are working changes feeds for these channels
-	if c.notifyChange != nil && len(changedChannelsCombined) > 0 {
-		c.notifyChange(changedChannelsCombined)
-	}
+	c.notifyChanged(changedChannelsCombined)
 
 	// Purge sequences not found from the skipped sequence queue
 	numRemoved := c.RemoveSkippedSequences(ctx, pendingRemovals)
-	c.context.DbStats.Cache().AbandonedSeqs.Add(numRemoved)
+	c.dbStats.Cache().AbandonedSeqs.Add(numRemoved)
+	c.resyncAdvisor.record(resyncSignalAbandonedSeqs, numRemoved)
 
-	base.InfofCtx(ctx, base.KeyCache, "CleanSkippedSequenceQueue complete.  Found:%d, Not Found:%d for database %s.", len(foundEntries), len(pendingRemovals), base.MD(c.context.Name))
-	return nil
+	base.InfofCtx(ctx, base.KeyCache, "CleanSkippedSequenceQueue complete.  Found:%d, Not Found:%d, Query Failed:%d for database %s.", len(foundEntries), len(pendingRemovals), retainedCount, base.MD(c.dbName))
 }
 
 //////// ADDING CHANGES:
 
 // Note that DocChanged may be executed concurrently for multiple events (in the DCP case, DCP events
 // originating from multiple vbuckets).  Only processEntry is locking - all other functionality needs to support
-// concurrent processing.
+// concurrent processing.  Events received once feed events have been stopped, while the database is closing, are
+// ignored.
 func (c *changeCache) DocChanged(event sgbucket.FeedEvent) {
 
+	if !c.startFeedEvent() {
+		return
+	}
+	defer c.feedEventsInFlight.Done()
+
 	docID := string(event.Key)
 	docJSON := event.Value
 	changedChannelsCombined := base.Set{}
 
 	// ** This method does not directly access any state of c, so it doesn't lock.
+	// Principal docs and unused sequence notifications only exist to keep the sequence stream contiguous.  They're
+	// always processed inline on the caching feed (never via import), so that they advance nextSequence as soon as
+	// they arrive.
 	// Is this a user/role doc?
 	if strings.HasPrefix(docID, base.UserPrefix) {
 		c.processPrincipalDoc(docID, docJSON, true, event.TimeReceived)
+		c.metadataEventProcessed(event.TimeReceived)
 		return
 	} else if strings.HasPrefix(docID, base.RolePrefix) {
 		c.processPrincipalDoc(docID, docJSON, false, event.TimeReceived)
+		c.metadataEventProcessed(event.TimeReceived)
 		return
 	}
 
 	// Is this an unused sequence notification?
 	if strings.HasPrefix(docID, base.UnusedSeqPrefix) {
 		c.processUnusedSequence(docID, event.TimeReceived)
+		c.metadataEventProcessed(event.TimeReceived)
 		return
 	}
 	if strings.HasPrefix(docID, base.UnusedSeqRangePrefix) {
 		c.processUnusedSequenceRange(docID)
+		c.metadataEventProcessed(event.TimeReceived)
+		return
+	}
+
+	// Is this a purge marker written by the node handling a purge?
+	if strings.HasPrefix(docID, base.PurgeMarkerPrefix) {
+		c.processPurgeMarker(docID, docJSON)
 		return
 	}
 
@@ -435,27 +992,52 @@ func (c *changeCache) DocChanged(event sgbucket.FeedEvent) {
 		return
 	}
 
+	// A delete whose only xattrs aren't Sync Gateway's is a purge - by tombstone compaction, or the server's metadata
+	// purge - since system xattrs other than the sync xattr survive it.  There's no sync metadata to cache, and the
+	// empty body shouldn't be parsed for any.
+	if event.Opcode == sgbucket.FeedOpDeletion && event.DataType&base.MemcachedDataTypeXattr != 0 {
+		if _, syncXattr, _, err := parseXattrStreamData(base.SyncXattrName, "", docJSON); err == nil && len(syncXattr) == 0 {
+			base.Debugf(base.KeyCache, "Ignoring delete mutation for %s - no Sync Gateway xattr remains.", base.UD(docID))
+			return
+		}
+	}
+
 	// If this is a binary document (and not one of the above types), we can ignore.  Currently only performing this check when xattrs
 	// are enabled, because walrus doesn't support DataType on feed.
-	if c.context.UseXattrs() && event.DataType == base.MemcachedDataTypeRaw {
+	if c.backingStore.UseXattrs() && event.DataType == base.MemcachedDataTypeRaw {
 		return
 	}
 
+	// Time the stages of processing a sample of events - the clock is only read for sampled events
+	var stageStart time.Time
+	sampled := c.stageSampler.sample()
+	if sampled {
+		stageStart = time.Now()
+	}
+
 	// First unmarshal the doc (just its metadata, to save time/memory):
-	syncData, rawBody, _, rawUserXattr, err := UnmarshalDocumentSyncDataFromFeed(docJSON, event.DataType, c.context.Options.UserXattrKey, false)
+	syncData, rawBody, _, rawUserXattr, err := UnmarshalDocumentSyncDataFromFeed(docJSON, event.DataType, c.dbOptions.UserXattrKey, false)
+	if sampled {
+		stageStart = observeStage(c.dbStats.Cache().FeedStageUnmarshalTime, stageStart)
+	}
 	if err != nil {
 		// Avoid log noise related to failed unmarshaling of binary documents.
 		if event.DataType != base.MemcachedDataTypeRaw {
-			base.Debugf(base.KeyCache, "Unable to unmarshal sync metadata for feed document %q.  Will not be included in channel cache.  Error: %v", base.UD(docID), err)
+			if c.dbOptions.UnsupportedOptions.StrictFeedParsing {
+				c.recordParseFailure(docID, err)
+			} else {
+				base.Debugf(base.KeyCache, "Unable to unmarshal sync metadata for feed document %q.  Will not be included in channel cache.  Error: %v", base.UD(docID), err)
+			}
 		}
 		if err == base.ErrEmptyMetadata {
-			base.Warnf("Unexpected empty metadata when processing feed event.  docid: %s opcode: %v datatype:%v", base.UD(event.Key), event.Opcode, event.DataType)
+			base.Debugf(base.KeyCache, "Unexpected empty metadata when processing feed event.  docid: %s opcode: %v datatype:%v", base.UD(event.Key), event.Opcode, event.DataType)
+			c.emptyMetaReporter.Add(docID)
 		}
 		return
 	}
 
 	// If using xattrs and this isn't an SG write, we shouldn't attempt to cache.
-	if c.context.UseXattrs() {
+	if c.backingStore.UseXattrs() {
 		if syncData == nil {
 			return
 		}
@@ -467,37 +1049,37 @@ func (c *changeCache) DocChanged(event sgbucket.FeedEvent) {
 
 	// If not using xattrs and no sync metadata found, check whether we're mid-upgrade and attempting to read a doc w/ metadata stored in xattr
 	// before ignoring the mutation.
-	if !c.context.UseXattrs() && !syncData.HasValidSyncData() {
-		migratedDoc, _ := c.context.checkForUpgrade(docID, DocUnmarshalNoHistory)
+	if !c.backingStore.UseXattrs() && !syncData.HasValidSyncData() {
+		migratedDoc, _ := c.backingStore.checkForUpgrade(docID, DocUnmarshalNoHistory)
 		if migratedDoc != nil && migratedDoc.Cas == event.Cas {
 			base.Infof(base.KeyCache, "Found mobile xattr on doc %q without %s property - caching, assuming upgrade in progress.", base.UD(docID), base.SyncPropertyName)
 			syncData = &migratedDoc.SyncData
 		} else {
-			base.Infof(base.KeyCache, "changeCache: Doc %q does not have valid sync data.", base.UD(docID))
-			c.context.DbStats.Cache().NonMobileIgnoredCount.Add(1)
+			base.Debugf(base.KeyCache, "changeCache: Doc %q does not have valid sync data.", base.UD(docID))
+			c.nonMobileReporter.Add(docID)
 			return
 		}
 	}
+	if sampled {
+		observeStage(c.dbStats.Cache().FeedStageImportCheckTime, stageStart)
+	}
 
 	if syncData.Sequence <= c.getInitialSequence() {
+		c.dbStats.Cache().DiscardedFeedSeqCount.Add(1)
+		// A change the channel caches seeded from a handoff snapshot should hold, but don't, invalidates the snapshot
+		c.channelCache.verifySeededChannels(docID, syncData.Sequence, syncData.Channels)
 		return // DCP is sending us an old value from before I started up; ignore it
 	}
 
-	// Measure feed latency from timeSaved or the time we started working the feed, whichever is later
-	var feedLatency time.Duration
-	if !syncData.TimeSaved.IsZero() {
-		if syncData.TimeSaved.After(c.initTime) {
-			feedLatency = time.Since(syncData.TimeSaved)
-		} else {
-			feedLatency = time.Since(c.initTime)
-		}
-		// Record latency when greater than zero
-		feedNano := feedLatency.Nanoseconds()
-		if feedNano > 0 {
-			c.context.DbStats.Database().DCPReceivedTime.Add(feedNano)
-		}
+	// Measure feed latency from the server's mutation time (or timeSaved, when the cas doesn't provide one), or the
+	// time we started working the feed, whichever is later
+	serverTimeSaved := casServerTimeSaved(event.Cas, event.TimeReceived)
+	feedLatency := measureFeedLatency(serverTimeSaved, syncData.TimeSaved, c.initTime, time.Now())
+	// Record latency when greater than zero
+	if feedNano := feedLatency.Nanoseconds(); feedNano > 0 {
+		c.dbStats.Database().DCPReceivedTime.Add(event.VbNo, feedNano)
 	}
-	c.context.DbStats.Database().DCPReceivedCount.Add(1)
+	c.dbStats.Database().DCPReceivedCount.Add(event.VbNo, 1)
 
 	// If the doc update wasted any sequences due to conflicts, add empty entries for them:
 	for _, seq := range syncData.UnusedSequences {
@@ -505,6 +1087,7 @@ func (c *changeCache) DocChanged(event sgbucket.FeedEvent) {
 		change := &LogEntry{
 			Sequence:     seq,
 			TimeReceived: event.TimeReceived,
+			VbNo:         event.VbNo,
 		}
 		changedChannels := c.processEntry(change)
 		changedChannelsCombined = changedChannelsCombined.Update(changedChannels)
@@ -528,6 +1111,7 @@ func (c *changeCache) DocChanged(event sgbucket.FeedEvent) {
 				change := &LogEntry{
 					Sequence:     seq,
 					TimeReceived: event.TimeReceived,
+					VbNo:         event.VbNo,
 				}
 
 				//if the doc was removed from one or more channels at this sequence
@@ -546,32 +1130,74 @@ func (c *changeCache) DocChanged(event sgbucket.FeedEvent) {
 
 	// Now add the entry for the new doc revision:
 	change := &LogEntry{
-		Sequence:     syncData.Sequence,
-		DocID:        docID,
-		RevID:        syncData.CurrentRev,
-		Flags:        syncData.Flags,
-		TimeReceived: event.TimeReceived,
-		TimeSaved:    syncData.TimeSaved,
-		Channels:     syncData.Channels,
+		Sequence:        syncData.Sequence,
+		DocID:           docID,
+		RevID:           syncData.CurrentRev,
+		Flags:           syncData.Flags,
+		TimeReceived:    event.TimeReceived,
+		TimeSaved:       syncData.TimeSaved,
+		ServerTimeSaved: serverTimeSaved,
+		Channels:        syncData.Channels,
+		VbNo:            event.VbNo,
+	}
+	if syncData.TombstonedAt > 0 {
+		change.TimeDeleted = time.Unix(syncData.TombstonedAt, 0)
 	}
 
-	millisecondLatency := int(feedLatency / time.Millisecond)
+	c.recordFeedLag(change, feedLatency)
 
-	// If latency is larger than 1 minute or is negative there is likely an issue and this should be clear to the user
-	if millisecondLatency >= 60*1000 {
-		base.Infof(base.KeyDCP, "Received #%d after %3dms (%q / %q)", change.Sequence, millisecondLatency, base.UD(change.DocID), change.RevID)
+	var changedChannels base.Set
+	if sampled {
+		changedChannels = c.processEntryTimed(change)
 	} else {
-		base.Debugf(base.KeyDCP, "Received #%d after %3dms (%q / %q)", change.Sequence, millisecondLatency, base.UD(change.DocID), change.RevID)
+		changedChannels = c.processEntry(change)
 	}
-
-	changedChannels := c.processEntry(change)
 	changedChannelsCombined = changedChannelsCombined.Update(changedChannels)
 
+	if c.channelVerifier != nil {
+		c.channelVerifier.sample(docID, syncData.CurrentRev)
+	}
+
 	// Notify change listeners for all of the changed channels
-	if c.notifyChange != nil && len(changedChannelsCombined) > 0 {
-		c.notifyChange(changedChannelsCombined)
+	if sampled {
+		stageStart = time.Now()
+	}
+	c.notifyChanged(changedChannelsCombined)
+	if sampled {
+		observeStage(c.dbStats.Cache().FeedStageNotifyTime, stageStart)
+	}
+
+}
+
+// recordParseFailure is used in strict feed parsing mode to make sync metadata unmarshal failures visible.  The failure
+// is counted, logged at warn, and retained in a bounded list that can be retrieved via the _cache endpoint.
+func (c *changeCache) recordParseFailure(docID string, err error) {
+	base.Warnf("Unable to unmarshal sync metadata for feed document %q.  Will not be included in channel cache.  Error: %v", base.UD(docID), err)
+	c.dbStats.Cache().FeedParseErrorCount.Add(1)
+
+	failure := CacheParseFailure{
+		DocID: docID,
+		Error: err.Error(),
+		Time:  time.Now(),
 	}
 
+	c.parseFailuresLock.Lock()
+	if MaxCacheParseFailures > 0 && len(c.parseFailures) >= MaxCacheParseFailures {
+		// Drop the oldest failure to make room
+		copy(c.parseFailures, c.parseFailures[1:])
+		c.parseFailures = c.parseFailures[:len(c.parseFailures)-1]
+	}
+	c.parseFailures = append(c.parseFailures, failure)
+	c.parseFailuresLock.Unlock()
+}
+
+// GetParseFailures returns a copy of the retained feed parse failures, oldest first.
+func (c *changeCache) GetParseFailures() []CacheParseFailure {
+	c.parseFailuresLock.Lock()
+	defer c.parseFailuresLock.Unlock()
+	failures := make([]CacheParseFailure, len(c.parseFailures))
+	copy(failures, c.parseFailures)
+	return failures
 }
 
 // Simplified principal limited to properties needed by caching
@@ -580,78 +1206,322 @@ type cachePrincipal struct {
 	Sequence uint64 `json:"sequence"`
 }
 
+// Remove removes the docs' entries received before startTime from the channel caches.  No-op once the cache has been
+// stopped.
 func (c *changeCache) Remove(docIDs []string, startTime time.Time) (count int) {
+	if c.IsStopped() {
+		return 0
+	}
 	return c.channelCache.Remove(docIDs, startTime)
 }
 
-// Principals unmarshalled during caching don't need to instantiate a real principal - we're just using name and seq from the document
-func (c *changeCache) unmarshalCachePrincipal(docJSON []byte) (cachePrincipal, error) {
-	var principal cachePrincipal
-	err := base.JSONUnmarshal(docJSON, &principal)
-	return principal, err
-}
-
-// Process unused sequence notification.  Extracts sequence from docID and sends to cache for buffering
-func (c *changeCache) processUnusedSequence(docID string, timeReceived time.Time) {
-	sequenceStr := strings.TrimPrefix(docID, base.UnusedSeqPrefix)
-	sequence, err := strconv.ParseUint(sequenceStr, 10, 64)
-	if err != nil {
-		base.Warnf("Unable to identify sequence number for unused sequence notification with key: %s, error: %v", base.UD(docID), err)
+// RevisionsPruned updates the channel cache entries for doc that reference revisions pruned from its rev tree by the
+// update that was just written, so that changes feeds don't return revisions that can no longer be fetched.  Entries
+// superseded by the update are dropped, and others - for channels the doc was previously removed from - are replaced
+// with entries for its current revision.  Only this node's cache is updated.  Other nodes drop superseded entries when
+// the update is received over the caching feed.
+func (c *changeCache) RevisionsPruned(doc *Document, prunedRevIDs []string) {
+	if len(prunedRevIDs) == 0 {
 		return
 	}
-	c.releaseUnusedSequence(sequence, timeReceived)
-
+	dropped, replaced := c.channelCache.RevisionsPruned(doc.ID, base.SetFromArray(prunedRevIDs), doc.CurrentRev, doc.Sequence, doc.Channels)
+	if dropped > 0 || replaced > 0 {
+		base.Debugf(base.KeyCache, "Pruning revisions of doc %q dropped %d and replaced %d cached entries", base.UD(doc.ID), dropped, replaced)
+	}
 }
 
-func (c *changeCache) releaseUnusedSequence(sequence uint64, timeReceived time.Time) {
-	change := &LogEntry{
-		Sequence:     sequence,
-		TimeReceived: timeReceived,
+// Process purge marker.  Removes the purged document from the channel caches, for entries up to the purge sequence
+// stored in the marker body.  Sequences are compared rather than times, as the marker was written by another node.
+func (c *changeCache) processPurgeMarker(docID string, body []byte) {
+	purgedDocID := strings.TrimPrefix(docID, base.PurgeMarkerPrefix)
+	if len(body) < 8 {
+		base.Warnf("Unable to identify purge sequence for purge marker with key: %s", base.UD(docID))
+		return
 	}
-	base.Infof(base.KeyCache, "Received #%d (unused sequence)", sequence)
+	purgeSeq := binary.LittleEndian.Uint64(body)
+	count := c.channelCache.RemoveUpToSequence([]string{purgedDocID}, purgeSeq)
+	base.Debugf(base.KeyCache, "Removed %d cache entries for purged doc %q", count, base.UD(purgedDocID))
+}
 
-	// Since processEntry may unblock pending sequences, if there were any changed channels we need
-	// to notify any change listeners that are working changes feeds for these channels
-	changedChannels := c.processEntry(change)
-	if c.notifyChange != nil && len(changedChannels) > 0 {
-		c.notifyChange(changedChannels)
-	}
+// RollbackSequenceRange discards the cached state for sequences fromSeq through toSeq (inclusive), for use when those
+// sequences were received on the feed but have since been rolled back by the server, and so no longer exist in the
+// bucket.  Returns the number of pending and cached entries removed.
+func (c *changeCache) RollbackSequenceRange(fromSeq, toSeq uint64) int {
+	return c.rollback(fmt.Sprintf("sequences #%d-#%d", fromSeq, toSeq), func(entry *LogEntry) bool {
+		return entry.Sequence >= fromSeq && entry.Sequence <= toSeq
+	})
 }
 
-// Process unused sequence notification.  Extracts sequence from docID and sends to cache for buffering
-func (c *changeCache) processUnusedSequenceRange(docID string) {
-	// _sync:unusedSequences:fromSeq:toSeq
-	sequences := strings.Split(docID, ":")
-	if len(sequences) != 4 {
-		return
-	}
+// processVbucketRollback handles a rollback of the feed for vbucket vbNo.  Cache entries don't record vbucket
+// sequence numbers, so the rolled back mutations can't be distinguished from earlier mutations for the vbucket - all of
+// the vbucket's entries are discarded.  Existing documents are then served by query, and discarded pending sequences
+// that do still exist are recovered by skipped sequence handling.
+func (c *changeCache) processVbucketRollback(vbNo uint16) {
+	c.rollback(fmt.Sprintf("vbucket %d", vbNo), func(entry *LogEntry) bool {
+		return entry.VbNo == vbNo
+	})
+}
 
-	fromSequence, err := strconv.ParseUint(sequences[2], 10, 64)
-	if err != nil {
-		base.Warnf("Unable to identify from sequence number for unused sequences notification with key: %s, error:", base.UD(docID), err)
-		return
+// rollback removes the entries for which isRolledBack returns true from pendingLogs, receivedSeqs and the channel
+// caches, and notifies listeners of the affected channels.  Returns the number of entries removed.
+func (c *changeCache) rollback(description string, isRolledBack func(*LogEntry) bool) int {
+	c.lock.Lock()
+	pendingRemoved := 0
+	pendingLogs := make(LogPriorityQueue, 0, len(c.pendingLogs))
+	for _, entry := range c.pendingLogs {
+		if isRolledBack(entry) {
+			delete(c.receivedSeqs, entry.Sequence)
+			c.pendingLogsBytes -= estimatedPendingEntryBytes(entry)
+			pendingRemoved++
+			continue
+		}
+		pendingLogs = append(pendingLogs, entry)
 	}
-	toSequence, err := strconv.ParseUint(sequences[3], 10, 64)
-	if err != nil {
-		base.Warnf("Unable to identify to sequence number for unused sequence notification with key: %s, error:", base.UD(docID), err)
-		return
+	if pendingRemoved > 0 {
+		heap.Init(&pendingLogs)
+		c.pendingLogs = pendingLogs
+		c._updatePendingStats()
 	}
+	cachedRemoved, rolledBackChannels := c.channelCache.Rollback(isRolledBack)
+	c.lock.Unlock()
 
-	// TODO: There should be a more efficient way to do this
-	for seq := fromSequence; seq <= toSequence; seq++ {
-		c.releaseUnusedSequence(seq, time.Now())
-	}
+	c.dbStats.Cache().RollbackCount.Add(1)
+	c.dbStats.Cache().RolledBackEntryCount.Add(int64(pendingRemoved + cachedRemoved))
+	base.Infof(base.KeyCache, "Rolled back %s for database %s - removed %d pending entries, and %d cached entries from %d channels",
+		description, base.MD(c.dbName), pendingRemoved, cachedRemoved, len(rolledBackChannels))
+
+	c.notifyChanged(base.SetFromArray(rolledBackChannels))
+	return pendingRemoved + cachedRemoved
 }
 
-func (c *changeCache) processPrincipalDoc(docID string, docJSON []byte, isUser bool, timeReceived time.Time) {
+// Principals unmarshalled during caching don't need to instantiate a real principal - we're just using name and seq from the document
+func (c *changeCache) unmarshalCachePrincipal(docJSON []byte) (cachePrincipal, error) {
+	var principal cachePrincipal
+	err := base.JSONUnmarshal(docJSON, &principal)
+	return principal, err
+}
 
-	// Currently the cache isn't really doing much with user docs; mostly it needs to know about
-	// them because they have sequence numbers, so without them the sequence of sequences would
-	// have gaps in it, causing later sequences to get stuck in the queue.
-	princ, err := c.unmarshalCachePrincipal(docJSON)
-	if err != nil {
-		base.Warnf("changeCache: Error unmarshaling doc %q: %v", base.UD(docID), err)
-		return
+// extractPrincipalSequence attempts to identify the sequence of a principal doc that can't be unmarshalled as a
+// principal, by reading only the top-level sequence property.  Falls back to reading the doc's top-level properties
+// up to the point it stops being valid JSON, so that a sequence property nested in another property isn't matched.
+func extractPrincipalSequence(docJSON []byte) (sequence uint64, ok bool) {
+	var partial struct {
+		Sequence uint64 `json:"sequence"`
+	}
+	if err := base.JSONUnmarshal(docJSON, &partial); err == nil {
+		return partial.Sequence, partial.Sequence > 0
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(docJSON))
+	decoder.UseNumber()
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return 0, false
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return 0, false
+		}
+		if key != "sequence" {
+			if err := skipJSONValue(decoder); err != nil {
+				return 0, false
+			}
+			continue
+		}
+		value, err := decoder.Token()
+		if err != nil {
+			return 0, false
+		}
+		number, isNumber := value.(json.Number)
+		if !isNumber {
+			return 0, false
+		}
+		sequence, err := strconv.ParseUint(number.String(), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return sequence, sequence > 0
+	}
+	return 0, false
+}
+
+// skipJSONValue reads the next value from decoder, including all the tokens of an object or array value.
+func skipJSONValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// warnPrincipalParseFailure logs principal parse failures at warn at most once per PrincipalParseWarnInterval, and
+// at debug otherwise, to avoid flooding the logs when many principal docs are corrupt.
+func (c *changeCache) warnPrincipalParseFailure(format string, args ...interface{}) {
+	now := time.Now().UnixNano()
+	lastWarn := atomic.LoadInt64(&c.lastPrincipalWarn)
+	if now-lastWarn >= int64(PrincipalParseWarnInterval) && atomic.CompareAndSwapInt64(&c.lastPrincipalWarn, lastWarn, now) {
+		base.Warnf(format, args...)
+	} else {
+		base.Debugf(base.KeyCache, format, args...)
+	}
+}
+
+// recordFeedLag adds the feed latency of a change to the max and mean feed lag stats, and logs it at debug.  Changes with latency above the warn threshold are counted,
+// and logged at warn at most once per FeedLagWarnInterval.
+func (c *changeCache) recordFeedLag(change *LogEntry, feedLatency time.Duration) {
+	millisecondLatency := int64(feedLatency / time.Millisecond)
+	for {
+		maxFeedLag := atomic.LoadInt64(&c.internalStats.maxFeedLag)
+		if millisecondLatency <= maxFeedLag || atomic.CompareAndSwapInt64(&c.internalStats.maxFeedLag, maxFeedLag, millisecondLatency) {
+			break
+		}
+	}
+	c.internalStats.feedLagMean.AddValue(millisecondLatency)
+
+	c.lock.RLock()
+	warnThreshold := c.options.FeedLagWarnThreshold
+	c.lock.RUnlock()
+	if warnThreshold <= 0 || feedLatency <= warnThreshold {
+		base.Debugf(base.KeyDCP, "Received #%d after %3dms (%q / %q)", change.Sequence, millisecondLatency, base.UD(change.DocID), change.RevID)
+		return
+	}
+
+	c.dbStats.Cache().HighFeedLag.Add(1)
+	now := time.Now().UnixNano()
+	lastWarn := atomic.LoadInt64(&c.lastFeedLagWarn)
+	if now-lastWarn >= int64(FeedLagWarnInterval) && atomic.CompareAndSwapInt64(&c.lastFeedLagWarn, lastWarn, now) {
+		base.Warnf("Received #%d after %dms (%q / %q), above the feed lag warn threshold of %v.  %d changes have exceeded the threshold.",
+			change.Sequence, millisecondLatency, base.UD(change.DocID), change.RevID, warnThreshold, c.dbStats.Cache().HighFeedLag.Value())
+	} else {
+		base.Debugf(base.KeyDCP, "Received #%d after %3dms (%q / %q)", change.Sequence, millisecondLatency, base.UD(change.DocID), change.RevID)
+	}
+}
+
+// Process unused sequence notification.  Extracts sequence from docID and sends to cache for buffering
+func (c *changeCache) processUnusedSequence(docID string, timeReceived time.Time) {
+	doc, err := parseUnusedSequenceDocKey(docID)
+	if err != nil {
+		base.Warnf("Unable to identify sequence number for unused sequence notification with key: %s, error: %v", base.UD(docID), err)
+		return
+	}
+	c.releaseUnusedSequence(doc.fromSequence, timeReceived)
+
+}
+
+func (c *changeCache) releaseUnusedSequence(sequence uint64, timeReceived time.Time) {
+	change := &LogEntry{
+		Sequence:     sequence,
+		TimeReceived: timeReceived,
+	}
+	base.Infof(base.KeyCache, "Received #%d (unused sequence)", sequence)
+
+	// Since processEntry may unblock pending sequences, if there were any changed channels we need
+	// to notify any change listeners that are working changes feeds for these channels
+	changedChannels := c.processEntry(change)
+	c.notifyChanged(changedChannels)
+}
+
+// Process unused sequence range notification.  Extracts sequences from docID and sends to cache for buffering
+func (c *changeCache) processUnusedSequenceRange(docID string) {
+	// _sync:unusedSeqs:fromSeq:toSeq
+	doc, err := parseUnusedSequenceDocKey(docID)
+	if err != nil {
+		base.Warnf("Unable to identify sequence numbers for unused sequences notification with key: %s, error: %v", base.UD(docID), err)
+		return
+	}
+
+	// TODO: There should be a more efficient way to do this
+	for seq := doc.fromSequence; seq <= doc.toSequence; seq++ {
+		c.releaseUnusedSequence(seq, time.Now())
+	}
+}
+
+// Server clock skew tolerated when validating a mutation time decoded from a feed event's cas.  Times further ahead of
+// the time the event was received are treated as implausible.
+const maxServerTimeSkew = 5 * time.Second
+
+// Mutation times decoded from a feed event's cas that are earlier than this aren't hybrid logical clock based (older
+// servers, walrus), and are ignored.
+var minServerTimeSaved = time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// casServerTimeSaved returns the time a mutation was saved on the server, decoded from its hybrid logical clock cas, or
+// zero when the cas doesn't hold a plausible time.  Times slightly ahead of timeReceived (within maxServerTimeSkew) are
+// clamped to timeReceived.
+func casServerTimeSaved(cas uint64, timeReceived time.Time) time.Time {
+	if cas == 0 {
+		return time.Time{}
+	}
+	if timeReceived.IsZero() {
+		timeReceived = time.Now()
+	}
+	serverTime := base.HLCCasToTime(cas)
+	if serverTime.Before(minServerTimeSaved) || serverTime.After(timeReceived.Add(maxServerTimeSkew)) {
+		return time.Time{}
+	}
+	if serverTime.After(timeReceived) {
+		return timeReceived
+	}
+	return serverTime
+}
+
+// measureFeedLatency returns the time between a mutation being saved and now.  It's measured from the server's
+// mutation time when known, falling back to the sync metadata's timeSaved, and from initTime for mutations saved before
+// the cache was initialized.  Returns zero when neither time is known, or when clock skew puts the time saved after now.
+func measureFeedLatency(serverTimeSaved, timeSaved, initTime, now time.Time) time.Duration {
+	saved := serverTimeSaved
+	if saved.IsZero() {
+		saved = timeSaved
+	}
+	if saved.IsZero() {
+		return 0
+	}
+	if saved.Before(initTime) {
+		saved = initTime
+	}
+	if saved.After(now) {
+		return 0
+	}
+	return now.Sub(saved)
+}
+
+// metadataEventProcessed updates stats for a processed principal doc or unused sequence notification.  Latency is
+// measured from the time the event was received on the feed.
+func (c *changeCache) metadataEventProcessed(timeReceived time.Time) {
+	c.dbStats.Cache().MetadataEventCount.Add(1)
+	if !timeReceived.IsZero() {
+		c.dbStats.Cache().MetadataEventTime.Add(time.Since(timeReceived).Nanoseconds())
+	}
+}
+
+func (c *changeCache) processPrincipalDoc(docID string, docJSON []byte, isUser bool, timeReceived time.Time) {
+
+	// Currently the cache isn't really doing much with user docs; mostly it needs to know about
+	// them because they have sequence numbers, so without them the sequence of sequences would
+	// have gaps in it, causing later sequences to get stuck in the queue.
+	princ, err := c.unmarshalCachePrincipal(docJSON)
+	if err != nil {
+		c.dbStats.Cache().PrincipalParseErrorCount.Add(1)
+		sequence, ok := extractPrincipalSequence(docJSON)
+		if !ok {
+			c.warnPrincipalParseFailure("changeCache: Error unmarshaling principal doc %q, unable to identify sequence: %v", base.UD(docID), err)
+			return
+		}
+		c.warnPrincipalParseFailure("changeCache: Error unmarshaling principal doc %q, releasing sequence #%d: %v", base.UD(docID), sequence, err)
+		if sequence > c.getInitialSequence() {
+			c.releaseUnusedSequence(sequence, timeReceived)
+		}
+		return
 	}
 	sequence := princ.Sequence
 
@@ -674,18 +1544,73 @@ func (c *changeCache) processPrincipalDoc(docID string, docJSON []byte, isUser b
 	base.Infof(base.KeyDCP, "Received #%d (%q)", change.Sequence, base.UD(change.DocID))
 
 	changedChannels := c.processEntry(change)
-	if c.notifyChange != nil && len(changedChannels) > 0 {
-		c.notifyChange(changedChannels)
-	}
+	c.notifyChanged(changedChannels)
 }
 
-// Handles a newly-arrived LogEntry.
+// Handles a newly-arrived LogEntry.  The caching stats for the entries cached are recorded once the lock is released,
+// to the shard for the entry's vbucket.
 func (c *changeCache) processEntry(change *LogEntry) base.Set {
 	c.lock.Lock()
-	defer c.lock.Unlock()
+	changedChannels := c._processEntry(change)
+	cached := c._takeCachingStats()
+	c.lock.Unlock()
+	c.recordCachingStats(change.VbNo, cached)
+	return changedChannels
+}
+
+// processEntryTimed is processEntry for a sampled feed event, recording the time spent waiting for the cache lock
+// separately from the time the lock is held to process the entry.
+func (c *changeCache) processEntryTimed(change *LogEntry) base.Set {
+	cacheStats := c.dbStats.Cache()
+	start := time.Now()
+	c.lock.Lock()
+	locked := observeStage(cacheStats.FeedStageLockWaitTime, start)
+	changedChannels := c._processEntry(change)
+	cached := c._takeCachingStats()
+	observeStage(cacheStats.FeedStageLockHeldTime, locked)
+	c.lock.Unlock()
+	c.recordCachingStats(change.VbNo, cached)
+	return changedChannels
+}
+
+// _takeCachingStats returns and resets the caching stats accrued while holding the lock.  Requires lock.
+func (c *changeCache) _takeCachingStats() feedCachingStats {
+	cached := c.cachingStats
+	c.cachingStats = feedCachingStats{}
+	return cached
+}
+
+// recordCachingStats adds caching stats to the sharded stats' shards for vbNo.  Mustn't be called while holding lock,
+// so that feed workers only contend on the shards of their own vbuckets.
+func (c *changeCache) recordCachingStats(vbNo uint16, cached feedCachingStats) {
+	if cached.count == 0 {
+		return
+	}
+	c.dbStats.Database().DCPCachingCount.Add(vbNo, cached.count)
+	c.dbStats.Database().DCPCachingTime.Add(vbNo, cached.nanos)
+}
+
+// flushFeedStats records the caching stats accrued by cache housekeeping, such as caching pending entries once they've
+// waited long enough, then folds the sharded feed stats into their totals.
+func (c *changeCache) flushFeedStats(ctx context.Context) error {
+	c.lock.Lock()
+	cached := c._takeCachingStats()
+	c.lock.Unlock()
+	c.recordCachingStats(0, cached)
+
+	dbStats := c.dbStats.Database()
+	for _, stat := range []*base.ShardedIntStat{dbStats.DCPReceivedCount, dbStats.DCPReceivedTime, dbStats.DCPCachingCount, dbStats.DCPCachingTime} {
+		stat.Flush()
+	}
+	return nil
+}
+
+// _processEntry adds a feed entry to the cache, or buffers it until the sequences before it arrive.  Requires lock.
+func (c *changeCache) _processEntry(change *LogEntry) base.Set {
 	if c.logsDisabled {
 		return nil
 	}
+	defer c.updateSkippedStats()
 
 	sequence := change.Sequence
 	if change.Sequence > c.internalStats.highSeqFeed {
@@ -695,17 +1620,14 @@ func (c *changeCache) processEntry(change *LogEntry) base.Set {
 	// Duplicate handling - there are a few cases where processEntry can be called multiple times for a sequence:
 	//   - recentSequences for rapidly updated documents
 	//   - principal mutations that don't increment sequence
+	//   - unused sequence notifications released again by a startup scan
 	// We can cancel processing early in these scenarios.
-	// Check if this is a duplicate of an already processed sequence
-	if sequence < c.nextSequence && !c.WasSkipped(sequence) {
+	if c._isReceived(sequence) {
 		base.Debugf(base.KeyCache, "  Ignoring duplicate of #%d", sequence)
 		return nil
 	}
-
-	// Check if this is a duplicate of a pending sequence
-	if _, found := c.receivedSeqs[sequence]; found {
-		base.Debugf(base.KeyCache, "  Ignoring duplicate of #%d", sequence)
-		return nil
+	if c.options.BypassSequenceBuffering {
+		return c._addUnbufferedEntry(change)
 	}
 	c.receivedSeqs[sequence] = struct{}{}
 
@@ -717,9 +1639,9 @@ func (c *changeCache) processEntry(change *LogEntry) base.Set {
 		changedChannels = changedChannels.Update(c._addPendingLogs())
 	} else if sequence > c.nextSequence {
 		// There's a missing sequence (or several), so put this one on ice until it arrives:
-		heap.Push(&c.pendingLogs, change)
+		c._pushPendingLog(change)
 		numPending := len(c.pendingLogs)
-		c.internalStats.pendingSeqLen = numPending
+		c._updatePendingStats()
 		if base.LogDebugEnabled(base.KeyCache) {
 			base.Debugf(base.KeyCache, "  Deferring #%d (%d now waiting for #%d...#%d) doc %q / %q",
 				sequence, numPending, c.nextSequence, c.pendingLogs[0].Sequence-1, base.UD(change.DocID), change.RevID)
@@ -728,39 +1650,140 @@ func (c *changeCache) processEntry(change *LogEntry) base.Set {
 		if numPending > c.internalStats.maxPending {
 			c.internalStats.maxPending = numPending
 		}
+		c._recordSequenceGap(sequence, numPending)
 
-		if numPending > c.options.CachePendingSeqMaxNum {
+		if c._pendingLimitExceeded() {
 			// Too many pending; add the oldest one:
 			changedChannels = c._addPendingLogs()
 		}
 	} else if sequence > c.initialSequence {
-		// Out-of-order sequence received!
-		// Remove from skipped sequence queue
-		if !c.WasSkipped(sequence) {
-			// Error removing from skipped sequences
-			base.Infof(base.KeyCache, "  Received unexpected out-of-order change - not in skippedSeqs (seq %d, expecting %d) doc %q / %q", sequence, c.nextSequence, base.UD(change.DocID), change.RevID)
-		} else {
-			base.Infof(base.KeyCache, "  Received previously skipped out-of-order change (seq %d, expecting %d) doc %q / %q ", sequence, c.nextSequence, base.UD(change.DocID), change.RevID)
-			change.Skipped = true
-		}
+		// Out-of-order sequence received!  _isReceived only admits a sequence below nextSequence when it's in the
+		// skipped sequence queue, so it's cached as a late sequence even if CleanSkippedSequenceQueue abandons it
+		// concurrently.
+		change.OutOfOrder = true
+		change.Skipped = true
 
 		changedChannels = changedChannels.UpdateWithSlice(c._addToCache(change))
 		// Add to cache before removing from skipped, to ensure lowSequence doesn't get incremented until results are available
-		// in cache
-		err := c.RemoveSkipped(sequence)
-		if err != nil {
-			base.Debugf(base.KeyCache, "Error removing skipped sequence: #%d from cache: %v", sequence, err)
+		// in cache.  The check and removal are atomic, so that the sequence is either removed here, or was abandoned by
+		// CleanSkippedSequenceQueue - never both.
+		if c.CheckAndRemoveSkipped(sequence) {
+			base.Infof(base.KeyCache, "  Received previously skipped out-of-order change (seq %d, expecting %d) doc %q / %q ", sequence, c.nextSequence, base.UD(change.DocID), change.RevID)
+			c.pendingWaitWindow.recovered++
+		} else {
+			base.Infof(base.KeyCache, "  Received out-of-order change no longer in skippedSeqs - abandoned while being cached (seq %d, expecting %d) doc %q / %q", sequence, c.nextSequence, base.UD(change.DocID), change.RevID)
+		}
+	}
+	return changedChannels
+}
+
+// isReceived returns true if sequence has already been processed by the cache, or is pending.
+func (c *changeCache) isReceived(sequence uint64) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c._isReceived(sequence)
+}
+
+// _isReceived returns true if sequence has already been processed by the cache, or is pending.  Requires c.lock.
+func (c *changeCache) _isReceived(sequence uint64) bool {
+	// Check if this is a duplicate of an already processed sequence
+	if sequence < c.nextSequence && !c.WasSkipped(sequence) && !c.unbufferedGaps.Contains(sequence) {
+		return true
+	}
+
+	// Check if this is a duplicate of a pending sequence
+	_, found := c.receivedSeqs[sequence]
+	return found
+}
+
+// _addUnbufferedEntry caches a change immediately, when bypassing sequence buffering, returning the changed channels.
+// Sequences skipped over by the change are tracked as gaps, so that a change arriving for one of them later (as when
+// the feed reorders changes across vbuckets) is cached as a late sequence - continuous changes feeds that have already
+// passed it still send it.  Gaps that don't arrive within the pending wait are moved to the skipped sequence queue, so
+// are found by CleanSkippedSequenceQueue if the feed missed them.  A change for a sequence this node didn't allocate
+// shows there's another writer, so sequence buffering is resumed.  Requires c.lock.
+func (c *changeCache) _addUnbufferedEntry(change *LogEntry) base.Set {
+	sequence := change.Sequence
+	if sequence > c.initialSequence && c.isAllocated != nil && !c.isAllocated(sequence) {
+		c._resumeSequenceBuffering(fmt.Sprintf("#%d wasn't allocated by this node", sequence))
+		return c._processEntry(change)
+	}
+
+	if sequence >= c.nextSequence || c.nextSequence == 0 {
+		if c.nextSequence != 0 && sequence > c.nextSequence {
+			c._addUnbufferedGap(c.nextSequence, sequence-1)
 		}
+		return base.SetFromArray(c._addToCache(change))
+	}
+
+	// Below nextSequence and not a duplicate, so a tracked gap, or a gap since moved to the skipped sequence queue
+	c.dbStats.Cache().UnbufferedLateSeqCount.Add(1)
+	base.Debugf(base.KeyCache, "  Received late #%d (expecting %d) doc %q / %q", sequence, c.nextSequence, base.UD(change.DocID), change.RevID)
+	change.OutOfOrder = true
+	change.Skipped = true
+	changedChannels := base.SetFromArray(c._addToCache(change))
+	// As in _processEntry, a skipped sequence is only removed once cached, so the stable sequence isn't moved past it
+	// before it's available
+	if !c.unbufferedGaps.CheckAndRemove(sequence) {
+		c.CheckAndRemoveSkipped(sequence)
 	}
 	return changedChannels
 }
 
+// _addUnbufferedGap tracks the sequences from start to end (inclusive) as gaps, moving the oldest gaps to the skipped
+// sequence queue when more than MaxUnbufferedGaps are tracked.  Requires c.lock.
+func (c *changeCache) _addUnbufferedGap(start, end uint64) {
+	if err := c.unbufferedGaps.PushRange(start, end, time.Now(), end+1, 0); err != nil {
+		base.Infof(base.KeyCache, "Error tracking unreceived sequences #%d to #%d: %v", start, end, err)
+		return
+	}
+	if MaxUnbufferedGaps > 0 && c.unbufferedGaps.getNumSequences() > int64(MaxUnbufferedGaps) {
+		c._skipUnbufferedGaps(int64(MaxUnbufferedGaps), time.Time{})
+	}
+}
+
+// _skipUnbufferedGaps moves the oldest gaps to the skipped sequence queue while more than maxNum are tracked, or the
+// oldest were tracked before addedBefore.  Whole ranges of gaps are moved.  Skipped sequences are cached when they
+// arrive late, or are found by CleanSkippedSequenceQueue, so no sequence is lost.  Requires c.lock.
+func (c *changeCache) _skipUnbufferedGaps(maxNum int64, addedBefore time.Time) {
+	for _, gap := range c.unbufferedGaps.popOldestRanges(maxNum, addedBefore) {
+		base.Infof(base.KeyCache, "Unreceived sequences #%d to #%d moved to the skipped sequence queue", gap.start, gap.end)
+		c.dbStats.Cache().NumSkippedSeqs.Add(gap.numSequences())
+		c.pushSkippedRange(gap.start, gap.end, time.Now(), gap.pendingSeq, gap.pendingLen)
+	}
+}
+
+// resumeSequenceBuffering stops bypassing sequence buffering - see _resumeSequenceBuffering.
+func (c *changeCache) resumeSequenceBuffering(reason string) {
+	c.lock.Lock()
+	c._resumeSequenceBuffering(reason)
+	c.lock.Unlock()
+}
+
+// _resumeSequenceBuffering stops bypassing sequence buffering when another node is found to write to the database, as
+// buffering is only safe to bypass for a single writer.  Tracked gaps are moved to the skipped sequence queue, to be
+// handled as any other skipped sequence.  Requires c.lock.
+func (c *changeCache) _resumeSequenceBuffering(reason string) {
+	if !c.options.BypassSequenceBuffering {
+		return
+	}
+	base.Warnf("Sequence buffering is bypassed for database %s, which requires a single writer, but %s - resuming sequence buffering", base.MD(c.dbName), reason)
+	c.options.BypassSequenceBuffering = false
+	c._skipUnbufferedGaps(-1, time.Time{})
+}
+
 // Adds an entry to the appropriate channels' caches, returning the affected channels.  lateSequence
 // flag indicates whether it was a change arriving out of sequence
 func (c *changeCache) _addToCache(change *LogEntry) []string {
 
 	if change.Sequence >= c.nextSequence {
 		c.nextSequence = change.Sequence + 1
+		c.notifySequenceWaiters()
+		if !change.ServerTimeSaved.IsZero() {
+			c.sequenceClock.record(change.Sequence, change.ServerTimeSaved)
+		} else {
+			c.sequenceClock.record(change.Sequence, change.TimeReceived)
+		}
 	}
 	delete(c.receivedSeqs, change.Sequence)
 
@@ -769,6 +1792,14 @@ func (c *changeCache) _addToCache(change *LogEntry) []string {
 		return nil
 	}
 
+	// While paused, principals aren't cached either, so that the high cache sequence stays at the pause sequence
+	if c.paused {
+		if !change.IsPrincipal {
+			c._addPausedChange(change)
+		}
+		return nil
+	}
+
 	if change.IsPrincipal {
 		c.channelCache.AddPrincipal(change)
 		return nil
@@ -780,10 +1811,11 @@ func (c *changeCache) _addToCache(change *LogEntry) []string {
 	if base.LogDebugEnabled(base.KeyDCP) {
 		base.Debugf(base.KeyDCP, " #%d ==> channels %v", change.Sequence, base.UD(updatedChannels))
 	}
+	c.channelWebhooks.recordEntry(updatedChannels, change.Sequence)
 
 	if !change.TimeReceived.IsZero() {
-		c.context.DbStats.Database().DCPCachingCount.Add(1)
-		c.context.DbStats.Database().DCPCachingTime.Add(time.Since(change.TimeReceived).Nanoseconds())
+		c.cachingStats.count++
+		c.cachingStats.nanos += time.Since(change.TimeReceived).Nanoseconds()
 	}
 
 	return updatedChannels
@@ -792,33 +1824,61 @@ func (c *changeCache) _addToCache(change *LogEntry) []string {
 // Add the first change(s) from pendingLogs if they're the next sequence.  If not, and we've been
 // waiting too long for nextSequence, move nextSequence to skipped queue.
 // Returns the channels that changed.
+//
+// The wait of each change whose gap filled naturally is recorded in the pending wait distribution.  Changes popped once
+// nextSequence has been skipped forward are only counted, as their wait was cut short by the skip.
 func (c *changeCache) _addPendingLogs() base.Set {
 	var changedChannels base.Set
+	skippedForward := false
 
 	for len(c.pendingLogs) > 0 {
 		change := c.pendingLogs[0]
 		isNext := change.Sequence == c.nextSequence
 		if isNext {
-			heap.Pop(&c.pendingLogs)
+			c._popPendingLog()
+			if skippedForward {
+				c.dbStats.Cache().PendingSeqSkippedForwardCount.Add(1)
+			} else if !change.TimeReceived.IsZero() {
+				c.dbStats.Cache().PendingSeqWait.Observe(time.Since(change.TimeReceived).Milliseconds())
+			}
 			changedChannels = changedChannels.UpdateWithSlice(c._addToCache(change))
-		} else if len(c.pendingLogs) > c.options.CachePendingSeqMaxNum || time.Since(c.pendingLogs[0].TimeReceived) >= c.options.CachePendingSeqMaxWait {
-			c.context.DbStats.Cache().NumSkippedSeqs.Add(1)
-			c.PushSkipped(c.nextSequence)
-			c.nextSequence++
+		} else if c._pendingLimitExceeded() || time.Since(c.pendingLogs[0].TimeReceived) >= c.pendingSeqMaxWait {
+			// The whole gap up to the lowest pending sequence is skipped as a single range
+			c.dbStats.Cache().NumSkippedSeqs.Add(int64(change.Sequence - c.nextSequence))
+			c.pushSkippedRange(c.nextSequence, change.Sequence-1, time.Now(), change.Sequence, len(c.pendingLogs))
+			c.nextSequence = change.Sequence
+			skippedForward = true
 		} else {
 			break
 		}
 	}
 
-	c.internalStats.pendingSeqLen = len(c.pendingLogs)
+	c._updatePendingStats()
 
 	atomic.StoreInt64(&c.lastAddPendingTime, time.Now().UnixNano())
 	return changedChannels
 }
 
-func (c *changeCache) GetStableSequence(docID string) SequenceID {
-	// Stable sequence is independent of docID in changeCache
-	return SequenceID{Seq: c.LastSequence()}
+// StableSequenceProvider returns the stable sequence for a document - a sequence that has been seen on the feed by
+// this node, and so will eventually be seen by all nodes.
+type StableSequenceProvider interface {
+	GetStableSequence(docID string) SequenceID
+}
+
+// intSequenceProvider is the StableSequenceProvider for integer sequences, based on the change cache.  Stable sequence
+// is independent of docID.  In safe mode, the stable sequence doesn't advance past the oldest outstanding skipped
+// sequence, for consumers that must not observe sequences ahead of an unrecovered gap.  Safe mode is enabled for the
+// database by the safe_stable_sequence unsupported option.
+type intSequenceProvider struct {
+	changeCache *changeCache
+	safe        bool
+}
+
+func (p *intSequenceProvider) GetStableSequence(docID string) SequenceID {
+	if p.safe {
+		return SequenceID{Seq: p.changeCache.getMaxStableCached()}
+	}
+	return SequenceID{Seq: p.changeCache.LastSequence()}
 }
 
 func (c *changeCache) getChannelCache() ChannelCache {
@@ -827,12 +1887,57 @@ func (c *changeCache) getChannelCache() ChannelCache {
 
 //////// CHANGE ACCESS:
 
+// GetChanges returns changes for the given channel, in options.Collection, from the channel cache.  When the cache is
+// cleared while the changes are being read, the read is retried against the new caches, so that entries from a replaced
+// cache aren't returned.
 func (c *changeCache) GetChanges(channelName string, options ChangesOptions) ([]*LogEntry, error) {
+	stream, err := c.readChanges(channelName, options)
+	if err != nil {
+		return nil, err
+	}
+	return stream.entries(), nil
+}
 
-	if c.IsStopped() {
-		return nil, base.HTTPErrorf(503, "Database closed")
+// GetChangesStream invokes callback with each of the changes GetChanges would return, in sequence order, until
+// callback returns false.  Changes are streamed from a snapshot of the channel's cache, so the caller can process each
+// entry as it's produced without the cache being locked.  Returns ErrChangesStreamTerminated if options.Terminator is
+// closed before streaming completes.
+func (c *changeCache) GetChangesStream(channelName string, options ChangesOptions, callback func(*LogEntry) bool) error {
+	stream, err := c.readChanges(channelName, options)
+	if err != nil {
+		return err
 	}
-	return c.channelCache.GetChanges(channelName, options)
+	return stream.forEach(options.Terminator, callback)
+}
+
+// readChanges reads the changes for GetChanges and GetChangesStream, retrying when the cache is cleared during the read.
+func (c *changeCache) readChanges(channelName string, options ChangesOptions) (changeStream, error) {
+
+	var span base.Span
+	options.Ctx, span = base.StartSpan(options.Ctx, "cache.get_changes", base.SpanAttribute{Key: "limit", Value: options.Limit})
+	defer span.End()
+
+	for attempt := 0; attempt <= MaxCacheGenerationRetries; attempt++ {
+		if c.IsStopped() {
+			return changeStream{}, base.ErrDatabaseClosed
+		}
+
+		generation := atomic.LoadUint64(&c.generation)
+		if generation%2 == 1 {
+			// Clear in progress - wait for it to complete
+			c.lock.RLock()
+			c.lock.RUnlock()
+			continue
+		}
+
+		stream, err := c.channelCache.readChanges(NewChannelID(options.Collection, channelName), options)
+		if err != nil || atomic.LoadUint64(&c.generation) == generation {
+			return stream, err
+		}
+		base.Debugf(base.KeyCache, "Channel cache cleared while reading changes for channel %q - retrying", base.UD(channelName))
+	}
+
+	return changeStream{}, base.HTTPErrorf(503, "Channel cache was repeatedly cleared while reading changes - retry the request")
 }
 
 // Returns the sequence number the cache is up-to-date with.
@@ -854,6 +1959,7 @@ func (c *changeCache) getOldestSkippedSequence() uint64 {
 func (c *changeCache) _setInitialSequence(initialSequence uint64) {
 	c.initialSequence = initialSequence
 	c.nextSequence = initialSequence + 1
+	c.notifySequenceWaiters()
 }
 
 // Concurrent-safe get value of nextSequence
@@ -878,6 +1984,18 @@ func (h LogPriorityQueue) Len() int           { return len(h) }
 func (h LogPriorityQueue) Less(i, j int) bool { return h[i].Sequence < h[j].Sequence }
 func (h LogPriorityQueue) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
 
+// RedactedString returns the pending entries in sequence order, using LogEntry.RedactedString.
+func (h LogPriorityQueue) RedactedString() string {
+	sorted := make(LogPriorityQueue, len(h))
+	copy(sorted, h)
+	sort.Sort(sorted)
+	entries := make([]string, 0, len(sorted))
+	for _, entry := range sorted {
+		entries = append(entries, "{"+entry.RedactedString()+"}")
+	}
+	return "[" + strings.Join(entries, ", ") + "]"
+}
+
 func (h *LogPriorityQueue) Push(x interface{}) {
 	*h = append(*h, x.(*LogEntry))
 }
@@ -894,14 +2012,30 @@ func (h *LogPriorityQueue) Pop() interface{} {
 
 func (c *changeCache) RemoveSkipped(x uint64) error {
 	err := c.skippedSeqs.Remove(x)
-	c.context.DbStats.Cache().SkippedSeqLen.Set(int64(c.skippedSeqs.skippedList.Len()))
+	c.updateSkippedStats()
+	if err == nil {
+		c.notifySequenceWaiters()
+	}
 	return err
 }
 
+// CheckAndRemoveSkipped removes x from the skipped sequence queue, returning whether it was present.
+func (c *changeCache) CheckAndRemoveSkipped(x uint64) bool {
+	removed := c.skippedSeqs.CheckAndRemove(x)
+	c.updateSkippedStats()
+	if removed {
+		c.notifySequenceWaiters()
+	}
+	return removed
+}
+
 // Removes a set of sequences.  Logs warning on removal error, returns count of successfully removed.
 func (c *changeCache) RemoveSkippedSequences(ctx context.Context, sequences []uint64) (removedCount int64) {
 	numRemoved := c.skippedSeqs.RemoveSequences(ctx, sequences)
-	c.context.DbStats.Cache().SkippedSeqLen.Set(int64(c.skippedSeqs.skippedList.Len()))
+	c.updateSkippedStats()
+	if numRemoved > 0 {
+		c.notifySequenceWaiters()
+	}
 	return numRemoved
 }
 
@@ -909,62 +2043,161 @@ func (c *changeCache) WasSkipped(x uint64) bool {
 	return c.skippedSeqs.Contains(x)
 }
 
-func (c *changeCache) PushSkipped(sequence uint64) {
-	err := c.skippedSeqs.Push(&SkippedSequence{seq: sequence, timeAdded: time.Now()})
+func (c *changeCache) PushSkipped(skipped *SkippedSequence) {
+	c.pushSkippedRange(skipped.seq, skipped.seq, skipped.timeAdded, skipped.pendingSeq, skipped.pendingLen)
+}
+
+// pushSkippedRange pushes the sequences from start to end (inclusive) to the skipped sequence queue as a single range.
+func (c *changeCache) pushSkippedRange(start, end uint64, timeAdded time.Time, pendingSeq uint64, pendingLen int) {
+	err := c.skippedSeqs.PushRange(start, end, timeAdded, pendingSeq, pendingLen)
 	if err != nil {
-		base.Infof(base.KeyCache, "Error pushing skipped sequence: %d, %v", sequence, err)
+		base.Infof(base.KeyCache, "Error pushing skipped sequences: #%d to #%d, %v", start, end, err)
 		return
 	}
-	c.context.DbStats.Cache().SkippedSeqLen.Set(int64(c.skippedSeqs.skippedList.Len()))
+	c.updateSkippedStats()
+	if maxNum := c.options.CacheSkippedSeqMaxNum; maxNum > 0 && c.skippedSeqs.getNumSequences() > int64(maxNum) {
+		c.triggerSkippedSequenceLimitClean()
+	}
 }
 
-func (c *changeCache) GetSkippedSequencesOlderThanMaxWait() (oldSequences []uint64) {
-	return c.skippedSeqs.getOlderThan(c.options.CacheSkippedSeqMaxWait)
+// GetSkippedSequences returns the details of up to limit skipped sequences, oldest first.
+func (c *changeCache) GetSkippedSequences(limit int) []SkippedSequenceInfo {
+	return c.skippedSeqs.getInfo(limit)
 }
 
-// waitForSequence blocks up to maxWaitTime until the given sequence has been received.
-func (c *changeCache) waitForSequence(ctx context.Context, sequence uint64, maxWaitTime time.Duration) error {
-	startTime := time.Now()
+// GetSkippedSequenceReport returns the details of up to limit skipped sequences, oldest first, along with the state of
+// sequence buffering.  The skipped sequences are read in pages, so that the skipped sequence lock is only held briefly
+// on a busy system - the buffering state is read separately, so may have advanced beyond the skipped sequences.
+func (c *changeCache) GetSkippedSequenceReport(limit int) (*SkippedSequenceReport, error) {
+	if c.IsStopped() {
+		return nil, base.ErrDatabaseClosed
+	}
 
-	worker := func() (bool, error, interface{}) {
-		if c.getNextSequence() >= sequence+1 {
-			base.Debugf(base.KeyCache, "waitForSequence(%d) took %v", sequence, time.Since(startTime))
-			return false, nil, nil
+	report := &SkippedSequenceReport{Skipped: make([]SkippedSequenceInfo, 0)}
+	c.lock.RLock()
+	report.NextSequence = c.nextSequence
+	report.PendingLen = len(c.pendingLogs)
+	pending := make(LogPriorityQueue, report.PendingLen)
+	copy(pending, c.pendingLogs)
+	if report.PendingLen > 0 {
+		report.PendingLowSeq = c.pendingLogs[0].Sequence
+		for _, entry := range c.pendingLogs {
+			if entry.Sequence > report.PendingHighSeq {
+				report.PendingHighSeq = entry.Sequence
+			}
 		}
-		// retry
-		return true, nil, nil
+	}
+	c.lock.RUnlock()
+	if len(pending) > 0 {
+		report.Pending = pending.RedactedString()
 	}
 
-	ctx, cancel := context.WithDeadline(ctx, startTime.Add(maxWaitTime))
-	sleeper := base.SleeperFuncCtx(base.CreateMaxDoublingSleeperFunc(math.MaxInt64, 1, 100), ctx)
-	err, _ := base.RetryLoop(fmt.Sprintf("waitForSequence(%d)", sequence), worker, sleeper)
-	cancel()
-	return err
+	report.NumSkipped = c.skippedSeqs.getNumSequences()
+	report.Skipped = c.skippedSeqs.getInfo(limit)
+	return report, nil
+}
+
+// ListChannelCaches returns a page of the channel cache listing - see channelCacheImpl.ListChannelCaches.
+func (c *changeCache) ListChannelCaches(sortKey string, limit int, cursor string) ([]ChannelCacheInfo, string, error) {
+	if c.IsStopped() {
+		return nil, "", base.ErrDatabaseClosed
+	}
+	return c.channelCache.ListChannelCaches(sortKey, limit, cursor)
+}
+
+// GetChannelCacheContents returns entries from a single channel's cache - see channelCacheImpl.GetChannelCacheContents.
+func (c *changeCache) GetChannelCacheContents(channelID ChannelID, since uint64, limit int) (*ChannelCacheContents, bool) {
+	return c.channelCache.GetChannelCacheContents(channelID, since, limit)
+}
+
+// RemoveChannelCache drops a single channel's cache, to be rebuilt on next use - see channelCacheImpl.RemoveChannelCache.
+func (c *changeCache) RemoveChannelCache(channelID ChannelID) bool {
+	return c.channelCache.RemoveChannelCache(channelID)
+}
+
+func (c *changeCache) GetSkippedSequencesOlderThanMaxWait() (oldRanges []skippedSequenceRange) {
+	c.lock.RLock()
+	maxWait := c.options.CacheSkippedSeqMaxWait
+	c.lock.RUnlock()
+	return c.skippedSeqs.getOlderThan(maxWait)
+}
+
+// waitForSequence blocks up to maxWaitTime until the given sequence has been received.
+func (c *changeCache) waitForSequence(ctx context.Context, sequence uint64, maxWaitTime time.Duration) error {
+	return c.waitForSequenceCondition(ctx, "waitForSequence", sequence, maxWaitTime, func() bool {
+		return c.getNextSequence() >= sequence+1
+	})
 }
 
 // waitForSequenceNotSkipped blocks up to maxWaitTime until the given sequence has been received or skipped.
 func (c *changeCache) waitForSequenceNotSkipped(ctx context.Context, sequence uint64, maxWaitTime time.Duration) error {
-	startTime := time.Now()
+	return c.waitForSequenceCondition(ctx, "waitForSequenceNotSkipped", sequence, maxWaitTime, func() bool {
+		return c.getNextSequence() >= sequence+1 && !c.skippedSeqs.Contains(sequence)
+	})
+}
 
-	worker := func() (bool, error, interface{}) {
-		if c.getNextSequence() >= sequence+1 {
-			foundInMissing := c.skippedSeqs.Contains(sequence)
-			if !foundInMissing {
-				base.Debugf(base.KeyCache, "waitForSequenceNotSkipped(%d) took %v", sequence, time.Since(startTime))
-				return false, nil, nil
-			}
+// waitForSequenceCondition blocks up to maxWaitTime until isCached returns true, rechecking whenever the sequence
+// waiters are notified.  On timeout, returns an error describing the state of the cache.
+func (c *changeCache) waitForSequenceCondition(ctx context.Context, name string, sequence uint64, maxWaitTime time.Duration, isCached func() bool) error {
+	startTime := time.Now()
+	timer := time.NewTimer(maxWaitTime)
+	defer timer.Stop()
+
+	for {
+		// Get the wait channel before checking, so that a notification between the check and the wait isn't missed
+		changed := c.getSequenceWaitChan()
+		if isCached() {
+			base.Debugf(base.KeyCache, "%s(%d) took %v", name, sequence, time.Since(startTime))
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return c.sequenceWaitTimeoutError(sequence, maxWaitTime)
 		}
-		// retry
-		return true, nil, nil
+	}
+}
+
+// sequenceWaitTimeoutError counts a sequence wait timeout, and returns an error describing the state of the cache.
+func (c *changeCache) sequenceWaitTimeoutError(sequence uint64, maxWaitTime time.Duration) error {
+	c.dbStats.Cache().SequenceWaitTimeoutCount.Add(1)
+
+	c.lock.RLock()
+	pendingLen := len(c.pendingLogs)
+	c.lock.RUnlock()
+	oldestSkippedAge := time.Duration(0)
+	if oldestSkipped := c.GetSkippedSequences(1); len(oldestSkipped) > 0 {
+		oldestSkippedAge = time.Since(oldestSkipped[0].TimeAdded).Round(time.Millisecond)
 	}
 
-	ctx, cancel := context.WithDeadline(ctx, startTime.Add(maxWaitTime))
-	sleeper := base.SleeperFuncCtx(base.CreateMaxDoublingSleeperFunc(math.MaxInt64, 1, 100), ctx)
-	err, _ := base.RetryLoop(fmt.Sprintf("waitForSequenceNotSkipped(%d)", sequence), worker, sleeper)
-	cancel()
+	err := base.HTTPErrorf(http.StatusServiceUnavailable, "Timed out after %v waiting for sequence %d to be cached - last cached sequence: %d, pending sequences: %d, oldest skipped sequence age: %v",
+		maxWaitTime, sequence, c.LastSequence(), pendingLen, oldestSkippedAge)
+	base.Infof(base.KeyCache, "%v", err)
 	return err
 }
 
+// getSequenceWaitChan returns a channel that's closed on the next notifySequenceWaiters.
+func (c *changeCache) getSequenceWaitChan() chan struct{} {
+	c.sequenceWaitLock.Lock()
+	defer c.sequenceWaitLock.Unlock()
+	if c.sequenceWaitChan == nil {
+		c.sequenceWaitChan = make(chan struct{})
+	}
+	return c.sequenceWaitChan
+}
+
+// notifySequenceWaiters wakes any sequence waiters, to recheck whether their sequence has been cached.
+func (c *changeCache) notifySequenceWaiters() {
+	c.sequenceWaitLock.Lock()
+	if c.sequenceWaitChan != nil {
+		close(c.sequenceWaitChan)
+		c.sequenceWaitChan = nil
+	}
+	c.sequenceWaitLock.Unlock()
+}
+
 func (c *changeCache) getMaxStableCached() uint64 {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
@@ -979,32 +2212,149 @@ func (c *changeCache) _getMaxStableCached() uint64 {
 	return c.nextSequence - 1
 }
 
-// SkippedSequenceList stores the set of skipped sequences as an ordered list of *SkippedSequence with an associated map
-// for sequence-based lookup.
+// skippedSequenceRange is a run of contiguous skipped sequences, skipped together.
+type skippedSequenceRange struct {
+	start      uint64    // First sequence in the range
+	end        uint64    // Last sequence in the range (inclusive)
+	timeAdded  time.Time // Time the first sequence in the range was skipped
+	pendingSeq uint64    // Lowest pending sequence at the time the range was skipped
+	pendingLen int       // Number of pending sequences at the time the range was skipped
+}
+
+// numSequences returns the number of sequences in the range.
+func (r *skippedSequenceRange) numSequences() int64 {
+	return int64(r.end - r.start + 1)
+}
+
+// info returns the details of skipped sequence seq, which is in the range.
+func (r *skippedSequenceRange) info(seq uint64) SkippedSequenceInfo {
+	return SkippedSequenceInfo{
+		Sequence:   seq,
+		TimeAdded:  r.timeAdded,
+		PendingSeq: r.pendingSeq,
+		PendingLen: r.pendingLen,
+	}
+}
+
+// SkippedSequenceList stores the set of skipped sequences as an ordered slice of ranges of contiguous sequences, so
+// that a large block of skipped sequences (e.g. sequences lost on failover) doesn't need an entry per sequence.  A
+// pushed sequence extends the last range when it directly follows it and was skipped for the same pending sequence, as
+// happens when a run of sequences is skipped at once.  Ranges are split when an interior sequence is removed.
 type SkippedSequenceList struct {
-	skippedList *list.List               // Ordered list of skipped sequences
-	skippedMap  map[uint64]*list.Element // Map from sequence to list elements
-	lock        sync.RWMutex             // Coordinates access to skippedSequenceList
+	ranges       []*skippedSequenceRange // Ranges in sequence order, which is also the order they were skipped in
+	numSequences int64                   // Number of sequences across all ranges
+	lock         sync.RWMutex            // Coordinates access to skippedSequenceList
 }
 
 func NewSkippedSequenceList() *SkippedSequenceList {
-	return &SkippedSequenceList{
-		skippedMap:  map[uint64]*list.Element{},
-		skippedList: list.New(),
-	}
+	return &SkippedSequenceList{}
+}
+
+// _find returns the index of the range containing x, or of the range x would be inserted before when not found.
+// Expects callers to hold l.lock.
+func (l *SkippedSequenceList) _find(x uint64) (index int, found bool) {
+	index = sort.Search(len(l.ranges), func(i int) bool { return l.ranges[i].end >= x })
+	return index, index < len(l.ranges) && l.ranges[index].start <= x
 }
 
-// getOldest returns the sequence of the first element in the skippedSequenceList
+// getOldest returns the first sequence in the skippedSequenceList
 func (l *SkippedSequenceList) getOldest() (oldestSkippedSeq uint64) {
 	l.lock.RLock()
-	if firstElement := l.skippedList.Front(); firstElement != nil {
-		value := firstElement.Value.(*SkippedSequence)
-		oldestSkippedSeq = value.seq
+	if len(l.ranges) > 0 {
+		oldestSkippedSeq = l.ranges[0].start
 	}
 	l.lock.RUnlock()
 	return oldestSkippedSeq
 }
 
+// getOldestAge returns how long the first sequence in the skippedSequenceList has been skipped, or zero when the
+// list is empty.
+func (l *SkippedSequenceList) getOldestAge(now time.Time) (oldestAge time.Duration) {
+	l.lock.RLock()
+	if len(l.ranges) > 0 {
+		oldestAge = now.Sub(l.ranges[0].timeAdded)
+	}
+	l.lock.RUnlock()
+	return oldestAge
+}
+
+// getNumSequences returns the number of skipped sequences.
+func (l *SkippedSequenceList) getNumSequences() int64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.numSequences
+}
+
+// get returns the details of skipped sequence x, if present.
+func (l *SkippedSequenceList) get(x uint64) (info SkippedSequenceInfo, ok bool) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	index, found := l._find(x)
+	if !found {
+		return info, false
+	}
+	return l.ranges[index].info(x), true
+}
+
+// ForEach calls callback with each skipped sequence and the time it was skipped, oldest first, until callback returns
+// false.  The list is read in pages of SkippedSeqIterationPageSize sequences, holding the read lock only while each
+// page is copied, so a large list isn't copied in full, and callback may update the list.  Concurrent updates are seen
+// at page granularity: sequences removed before their page is read are skipped, and sequences pushed before their
+// page is read are included, but a sequence removed after its page is read is still passed to callback.
+func (l *SkippedSequenceList) ForEach(callback func(seq uint64, added time.Time) bool) {
+	l.forEachInfo(func(info SkippedSequenceInfo) bool {
+		return callback(info.Sequence, info.TimeAdded)
+	})
+}
+
+// forEachInfo is ForEach, passing callback the details of each skipped sequence.
+func (l *SkippedSequenceList) forEachInfo(callback func(info SkippedSequenceInfo) bool) {
+	pageSize := SkippedSeqIterationPageSize
+	if pageSize < 1 {
+		pageSize = 1
+	}
+	page := make([]SkippedSequenceInfo, 0, pageSize)
+	var from uint64
+	for {
+		page = l.readPage(from, page[:0])
+		if len(page) == 0 {
+			return
+		}
+		for _, info := range page {
+			if !callback(info) {
+				return
+			}
+		}
+		from = page[len(page)-1].Sequence + 1
+	}
+}
+
+// readPage appends the details of skipped sequences from sequence from onwards to page, up to its capacity.
+func (l *SkippedSequenceList) readPage(from uint64, page []SkippedSequenceInfo) []SkippedSequenceInfo {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	for index, _ := l._find(from); index < len(l.ranges) && len(page) < cap(page); index++ {
+		skippedRange := l.ranges[index]
+		for seq := base.MaxUint64(skippedRange.start, from); seq <= skippedRange.end && len(page) < cap(page); seq++ {
+			page = append(page, skippedRange.info(seq))
+		}
+	}
+	return page
+}
+
+// getInfo returns the details of up to limit skipped sequences, oldest first.
+func (l *SkippedSequenceList) getInfo(limit int) []SkippedSequenceInfo {
+	skipped := make([]SkippedSequenceInfo, 0)
+	if limit <= 0 {
+		return skipped
+	}
+	l.forEachInfo(func(info SkippedSequenceInfo) bool {
+		skipped = append(skipped, info)
+		return len(skipped) < limit
+	})
+	return skipped
+}
+
 // Removes a single entry from the list.
 func (l *SkippedSequenceList) Remove(x uint64) error {
 	l.lock.Lock()
@@ -1013,6 +2363,14 @@ func (l *SkippedSequenceList) Remove(x uint64) error {
 	return err
 }
 
+// CheckAndRemove removes x from the list, returning whether it was present.  Membership is checked and the entry
+// removed under a single lock, so of concurrent removals of the same sequence, only one finds it.
+func (l *SkippedSequenceList) CheckAndRemove(x uint64) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l._remove(x) == nil
+}
+
 func (l *SkippedSequenceList) RemoveSequences(ctx context.Context, sequences []uint64) (removedCount int64) {
 	l.lock.Lock()
 	for _, seq := range sequences {
@@ -1027,67 +2385,168 @@ func (l *SkippedSequenceList) RemoveSequences(ctx context.Context, sequences []u
 	return removedCount
 }
 
-// Removes an entry from the list.  Expects callers to hold l.lock.Lock
+// Removes an entry from the list, shrinking or splitting the range containing it.  Expects callers to hold
+// l.lock.Lock
 func (l *SkippedSequenceList) _remove(x uint64) error {
-	if listElement, ok := l.skippedMap[x]; ok {
-		l.skippedList.Remove(listElement)
-		delete(l.skippedMap, x)
-		return nil
-	} else {
+	index, found := l._find(x)
+	if !found {
 		return errors.New("Value not found")
 	}
+
+	skippedRange := l.ranges[index]
+	switch {
+	case skippedRange.start == skippedRange.end:
+		l._removeRange(index)
+	case x == skippedRange.start:
+		skippedRange.start++
+	case x == skippedRange.end:
+		skippedRange.end--
+	default:
+		upper := *skippedRange
+		upper.start = x + 1
+		skippedRange.end = x - 1
+		l.ranges = append(l.ranges, nil)
+		copy(l.ranges[index+2:], l.ranges[index+1:])
+		l.ranges[index+1] = &upper
+	}
+	l.numSequences--
+	return nil
 }
 
-// Contains does a simple search to detect presence
+// _removeRange removes the range at index.  Sequences are usually resolved in order, so removal of the first range
+// doesn't copy the remaining ranges.  Expects callers to hold l.lock.Lock
+func (l *SkippedSequenceList) _removeRange(index int) {
+	if index == 0 {
+		l.ranges[0] = nil
+		l.ranges = l.ranges[1:]
+		return
+	}
+	copy(l.ranges[index:], l.ranges[index+1:])
+	l.ranges[len(l.ranges)-1] = nil
+	l.ranges = l.ranges[:len(l.ranges)-1]
+}
+
+// Contains does a binary search of the ranges to detect presence
 func (l *SkippedSequenceList) Contains(x uint64) bool {
 	l.lock.RLock()
-	_, ok := l.skippedMap[x]
+	_, found := l._find(x)
 	l.lock.RUnlock()
-	return ok
+	return found
 }
 
 // Push sequence to the end of SkippedSequenceList.  Validates sequence ordering in list.
 func (l *SkippedSequenceList) Push(x *SkippedSequence) (err error) {
+	return l.PushRange(x.seq, x.seq, x.timeAdded, x.pendingSeq, x.pendingLen)
+}
+
+// PushRange pushes the sequences from start to end (inclusive) to the end of SkippedSequenceList.  Validates sequence
+// ordering in list.
+func (l *SkippedSequenceList) PushRange(start, end uint64, timeAdded time.Time, pendingSeq uint64, pendingLen int) (err error) {
+
+	if end < start {
+		return errors.New("Can't push range ending before its start")
+	}
 
 	l.lock.Lock()
-	validPush := false
-	lastElement := l.skippedList.Back()
-	if lastElement == nil {
-		validPush = true
-	} else {
-		lastSkipped, _ := lastElement.Value.(*SkippedSequence)
-		if lastSkipped.seq < x.seq {
-			validPush = true
+	defer l.lock.Unlock()
+
+	numSequences := int64(end - start + 1)
+	if len(l.ranges) > 0 {
+		lastRange := l.ranges[len(l.ranges)-1]
+		if start <= lastRange.end {
+			return errors.New("Can't push sequence lower than existing maximum")
+		}
+		if start == lastRange.end+1 && pendingSeq == lastRange.pendingSeq {
+			lastRange.end = end
+			l.numSequences += numSequences
+			return nil
 		}
 	}
 
-	if validPush {
-		newListElement := l.skippedList.PushBack(x)
-		l.skippedMap[x.seq] = newListElement
-	} else {
-		err = errors.New("Can't push sequence lower than existing maximum")
-	}
+	l.ranges = append(l.ranges, &skippedSequenceRange{
+		start:      start,
+		end:        end,
+		timeAdded:  timeAdded,
+		pendingSeq: pendingSeq,
+		pendingLen: pendingLen,
+	})
+	l.numSequences += numSequences
+	return nil
+}
 
-	l.lock.Unlock()
-	return err
+// popOldestRanges removes and returns the oldest ranges while the list holds more than maxNum sequences, or the oldest
+// range was added before addedBefore.  Whole ranges are removed, so the list may be left with fewer than maxNum.
+func (l *SkippedSequenceList) popOldestRanges(maxNum int64, addedBefore time.Time) []skippedSequenceRange {
+	l.lock.Lock()
+	defer l.lock.Unlock()
 
+	var popped []skippedSequenceRange
+	for len(l.ranges) > 0 && (l.numSequences > maxNum || l.ranges[0].timeAdded.Before(addedBefore)) {
+		oldest := l.ranges[0]
+		popped = append(popped, *oldest)
+		l.numSequences -= oldest.numSequences()
+		l._removeRange(0)
+	}
+	return popped
 }
 
-// getOldest returns a slice of sequences older than the specified duration of the first element in the skippedSequenceList
-func (l *SkippedSequenceList) getOlderThan(skippedExpiry time.Duration) []uint64 {
+// getOldestSequences returns copies of the ranges holding up to limit of the earliest skipped sequences, with the last
+// range truncated at the limit.
+func (l *SkippedSequenceList) getOldestSequences(limit int64) []skippedSequenceRange {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
 
+	var oldestRanges []skippedSequenceRange
+	for _, skippedRange := range l.ranges {
+		if limit <= 0 {
+			break
+		}
+		oldest := *skippedRange
+		if oldest.numSequences() > limit {
+			oldest.end = oldest.start + uint64(limit) - 1
+		}
+		limit -= oldest.numSequences()
+		oldestRanges = append(oldestRanges, oldest)
+	}
+	return oldestRanges
+}
+
+// getOlderThan returns copies of the ranges skipped longer ago than the specified duration
+func (l *SkippedSequenceList) getOlderThan(skippedExpiry time.Duration) []skippedSequenceRange {
 	l.lock.RLock()
-	oldSequences := make([]uint64, 0)
-	for e := l.skippedList.Front(); e != nil; e = e.Next() {
-		skippedSeq := e.Value.(*SkippedSequence)
-		if time.Since(skippedSeq.timeAdded) > skippedExpiry {
-			oldSequences = append(oldSequences, skippedSeq.seq)
-		} else {
-			// skippedSeqs are ordered by arrival time, so can stop iterating once we find one
-			// still inside the time window
+	defer l.lock.RUnlock()
+
+	var oldRanges []skippedSequenceRange
+	for _, skippedRange := range l.ranges {
+		// ranges are ordered by arrival time, so can stop iterating once we find one still inside the time window
+		if time.Since(skippedRange.timeAdded) <= skippedExpiry {
 			break
 		}
+		oldRanges = append(oldRanges, *skippedRange)
 	}
-	l.lock.RUnlock()
-	return oldSequences
+	return oldRanges
+}
+
+// numSkippedSequences returns the number of sequences across ranges.
+func numSkippedSequences(ranges []skippedSequenceRange) (numSequences int64) {
+	for i := range ranges {
+		numSequences += ranges[i].numSequences()
+	}
+	return numSequences
+}
+
+// nextSkippedBatch returns up to batchSize sequences from the start of ranges, and the ranges left once they're taken.
+func nextSkippedBatch(ranges []skippedSequenceRange, batchSize int) (batch []uint64, remaining []skippedSequenceRange) {
+	for len(ranges) > 0 && len(batch) < batchSize {
+		skippedRange := ranges[0]
+		for ; skippedRange.start <= skippedRange.end && len(batch) < batchSize; skippedRange.start++ {
+			batch = append(batch, skippedRange.start)
+		}
+		if skippedRange.start > skippedRange.end {
+			ranges = ranges[1:]
+		} else {
+			ranges[0] = skippedRange
+		}
+	}
+	return batch, ranges
 }
@@ -0,0 +1,136 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// CacheInvariantReport lists the violations of the change cache's sequence buffering and channel cache invariants
+// found by CollectCacheInvariantReport.  A report without violations is empty.  Sequences are listed in ascending order
+// and doc IDs in the order they're cached, so that reports collected after successive phases of a test diff cleanly.
+type CacheInvariantReport struct {
+	PendingHeapInvalid   bool                `json:"pending_heap_invalid,omitempty"`    // pendingLogs isn't ordered as a heap
+	DuplicatePending     []uint64            `json:"duplicate_pending,omitempty"`       // Sequences pending more than once
+	PendingNotReceived   []uint64            `json:"pending_not_received,omitempty"`    // Pending sequences missing from receivedSeqs
+	ReceivedNotPending   []uint64            `json:"received_not_pending,omitempty"`    // receivedSeqs entries that aren't pending
+	PendingBeforeNext    []uint64            `json:"pending_before_next,omitempty"`     // Pending sequences before nextSequence, which should already have been cached
+	SkippedListUnordered bool                `json:"skipped_list_unordered,omitempty"`  // The skipped sequence ranges aren't in ascending order, or overlap
+	SkippedLenMismatch   bool                `json:"skipped_len_mismatch,omitempty"`    // The skipped sequence count doesn't match the ranges
+	SkippedNotBeforeNext []uint64            `json:"skipped_not_before_next,omitempty"` // First sequence at or after nextSequence of each skipped range
+	CachedNotBeforeNext  uint64              `json:"cached_not_before_next,omitempty"`  // The high cache sequence, when nextSequence hasn't advanced past it
+	UnorderedChannels    map[string]uint64   `json:"unordered_channels,omitempty"`      // First entry of each channel cache that isn't after the entry preceding it, keyed by tagged channel name
+	DuplicateChannelDocs map[string][]string `json:"duplicate_channel_docs,omitempty"`  // Docs cached more than once in each channel cache, keyed by tagged channel name
+}
+
+// Empty returns true if the report has no violations.
+func (r *CacheInvariantReport) Empty() bool {
+	return r.Equal(&CacheInvariantReport{})
+}
+
+// Equal returns true if the reports have the same violations.
+func (r *CacheInvariantReport) Equal(other *CacheInvariantReport) bool {
+	return reflect.DeepEqual(r, other)
+}
+
+// String returns the report as JSON, for test output.
+func (r *CacheInvariantReport) String() string {
+	data, err := base.JSONMarshal(r)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// CollectCacheInvariantReport checks the invariants of the cache's sequence buffering state and cached channel entries
+// (intended for test and diagnostic usage).  The buffering state is checked under the cache's lock, so is consistent,
+// but channel caches are checked afterwards, one at a time.
+func CollectCacheInvariantReport(cache *changeCache) *CacheInvariantReport {
+	report := &CacheInvariantReport{}
+
+	cache.lock.RLock()
+	nextSequence := cache.nextSequence
+	pending := make(map[uint64]struct{}, len(cache.pendingLogs))
+	for i, entry := range cache.pendingLogs {
+		// Each entry must not be before its parent in the heap
+		if i > 0 && cache.pendingLogs.Less(i, (i-1)/2) {
+			report.PendingHeapInvalid = true
+		}
+		if _, found := pending[entry.Sequence]; found {
+			report.DuplicatePending = append(report.DuplicatePending, entry.Sequence)
+		}
+		pending[entry.Sequence] = struct{}{}
+		if _, found := cache.receivedSeqs[entry.Sequence]; !found {
+			report.PendingNotReceived = append(report.PendingNotReceived, entry.Sequence)
+		}
+		if entry.Sequence < nextSequence {
+			report.PendingBeforeNext = append(report.PendingBeforeNext, entry.Sequence)
+		}
+	}
+	for sequence := range cache.receivedSeqs {
+		if _, found := pending[sequence]; !found {
+			report.ReceivedNotPending = append(report.ReceivedNotPending, sequence)
+		}
+	}
+	cache.lock.RUnlock()
+
+	cache.skippedSeqs.lock.RLock()
+	var numSkipped int64
+	for i, skippedRange := range cache.skippedSeqs.ranges {
+		if skippedRange.start > skippedRange.end || (i > 0 && skippedRange.start <= cache.skippedSeqs.ranges[i-1].end) {
+			report.SkippedListUnordered = true
+			continue
+		}
+		numSkipped += int64(skippedRange.end-skippedRange.start) + 1
+		if nextSequence > 0 && skippedRange.end >= nextSequence {
+			report.SkippedNotBeforeNext = append(report.SkippedNotBeforeNext, base.MaxUint64(skippedRange.start, nextSequence))
+		}
+	}
+	report.SkippedLenMismatch = numSkipped != cache.skippedSeqs.numSequences
+	cache.skippedSeqs.lock.RUnlock()
+
+	// The high cache sequence only advances as sequences are cached, so may be read after nextSequence
+	if highCacheSequence := cache.channelCache.GetHighCacheSequence(); nextSequence > 0 && highCacheSequence >= nextSequence {
+		report.CachedNotBeforeNext = highCacheSequence
+	}
+
+	cache.channelCache.forEachCachedChannel(func(channelID ChannelID, entries []*LogEntry) bool {
+		channelName := base.UD(channelID.String()).Redact()
+		docs := make(map[string]struct{}, len(entries))
+		for i, entry := range entries {
+			if i > 0 && entry.Sequence <= entries[i-1].Sequence {
+				if report.UnorderedChannels == nil {
+					report.UnorderedChannels = make(map[string]uint64)
+				}
+				if _, found := report.UnorderedChannels[channelName]; !found {
+					report.UnorderedChannels[channelName] = entry.Sequence
+				}
+			}
+			if _, found := docs[entry.DocID]; found {
+				if report.DuplicateChannelDocs == nil {
+					report.DuplicateChannelDocs = make(map[string][]string)
+				}
+				report.DuplicateChannelDocs[channelName] = append(report.DuplicateChannelDocs[channelName], base.UD(entry.DocID).Redact())
+			}
+			docs[entry.DocID] = struct{}{}
+		}
+		return true
+	})
+
+	for _, sequences := range [][]uint64{report.DuplicatePending, report.PendingNotReceived, report.ReceivedNotPending,
+		report.PendingBeforeNext} {
+		sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+	}
+	return report
+}
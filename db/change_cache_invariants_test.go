@@ -0,0 +1,109 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Validates that a cache in normal use satisfies its invariants, and that violations seeded into its buffering state
+// and channel caches are each reported.
+func TestCollectCacheInvariantReport(t *testing.T) {
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxWait = time.Hour
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &cacheOptions)
+	defer cache.Stop()
+	cache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("ABC"))
+
+	// 3, 4 and 5 are pending until 2 is skipped, once the pending limit is reached
+	cache.processEntry(logEntry(1, "doc1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(5, "doc5", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(4, "doc4", "1-a", []string{"ABC"}))
+	AssertCacheInvariants(t, cache)
+	cache.processEntry(logEntry(3, "doc3", "1-a", []string{"ABC"}))
+	cache.lock.Lock()
+	maxNum := cache.options.CachePendingSeqMaxNum
+	cache.options.CachePendingSeqMaxNum = 0
+	cache._addPendingLogs()
+	cache.options.CachePendingSeqMaxNum = maxNum
+	cache.lock.Unlock()
+	require.Equal(t, uint64(6), cache.getNextSequence())
+	require.Equal(t, uint64(2), cache.getOldestSkippedSequence())
+	cache.processEntry(logEntry(8, "doc8", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(7, "doc7", "1-a", []string{"ABC"}))
+	AssertCacheInvariants(t, cache)
+	report := CollectCacheInvariantReport(cache)
+	assert.True(t, report.Empty())
+	assert.Equal(t, "{}", report.String())
+
+	// Seed violations of the buffering state
+	cache.lock.Lock()
+	cache.pendingLogs = append(cache.pendingLogs, logEntry(7, "doc7", "1-a", nil), logEntry(1, "doc1", "1-a", nil))
+	delete(cache.receivedSeqs, 8)
+	cache.receivedSeqs[20] = struct{}{}
+	cache.lock.Unlock()
+	require.NoError(t, cache.skippedSeqs.Push(&SkippedSequence{seq: 10, timeAdded: time.Now()}))
+	cache.skippedSeqs.lock.Lock()
+	cache.skippedSeqs.numSequences++
+	cache.skippedSeqs.lock.Unlock()
+
+	// Seed an unordered entry and a duplicate doc into the channel cache
+	singleCache := cache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("ABC")).(*singleChannelCacheImpl)
+	singleCache.lock.Lock()
+	singleCache._copyLogs()
+	singleCache.logs = append(singleCache.logs, logEntry(4, "doc4", "2-a", []string{"ABC"}))
+	singleCache._publishSnapshot()
+	singleCache.lock.Unlock()
+
+	expected := &CacheInvariantReport{
+		PendingHeapInvalid:   true,
+		DuplicatePending:     []uint64{7},
+		PendingNotReceived:   []uint64{1, 8},
+		ReceivedNotPending:   []uint64{20},
+		PendingBeforeNext:    []uint64{1},
+		SkippedLenMismatch:   true,
+		SkippedNotBeforeNext: []uint64{10},
+		UnorderedChannels:    map[string]uint64{base.UD("ABC").Redact(): 4},
+		DuplicateChannelDocs: map[string][]string{base.UD("ABC").Redact(): {base.UD("doc4").Redact()}},
+	}
+	report = CollectCacheInvariantReport(cache)
+	assert.False(t, report.Empty())
+	assert.True(t, expected.Equal(report), "Unexpected report: %s", report)
+
+	// An out of order skipped sequence list and a high cache sequence beyond nextSequence
+	cache.skippedSeqs.lock.Lock()
+	cache.skippedSeqs.ranges[0], cache.skippedSeqs.ranges[1] = cache.skippedSeqs.ranges[1], cache.skippedSeqs.ranges[0]
+	cache.skippedSeqs.lock.Unlock()
+	cache.lock.Lock()
+	cache.nextSequence = 5
+	cache.lock.Unlock()
+	report = CollectCacheInvariantReport(cache)
+	assert.True(t, report.SkippedListUnordered)
+	assert.Equal(t, uint64(5), report.CachedNotBeforeNext)
+	assert.False(t, expected.Equal(report))
+}
+
+// Validates that reports can be compared, so that reports collected in successive phases of a test can be diffed.
+func TestCacheInvariantReportEqual(t *testing.T) {
+
+	report := &CacheInvariantReport{DuplicatePending: []uint64{3}}
+	assert.False(t, report.Empty())
+	assert.True(t, report.Equal(&CacheInvariantReport{DuplicatePending: []uint64{3}}))
+	assert.False(t, report.Equal(&CacheInvariantReport{DuplicatePending: []uint64{4}}))
+	assert.False(t, report.Equal(&CacheInvariantReport{}))
+	assert.Equal(t, `{"duplicate_pending":[3]}`, report.String())
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// CacheOptions field names of the cache options that can be updated at runtime, used to report when they were last
+// updated.
+const (
+	CacheOptionPendingSeqMaxWait    = "CachePendingSeqMaxWait"
+	CacheOptionPendingSeqMaxNum     = "CachePendingSeqMaxNum"
+	CacheOptionSkippedSeqMaxWait    = "CacheSkippedSeqMaxWait"
+	CacheOptionFeedLagWarnThreshold = "FeedLagWarnThreshold"
+
+	CacheOptionChannelNameNormalization = "ChannelNameNormalization"
+)
+
+// CacheOptionsUpdate identifies the cache options to update at runtime.  Nil fields are left unchanged.  Updated
+// options aren't persisted, and revert to the database config when the database is reloaded.
+type CacheOptionsUpdate struct {
+	CachePendingSeqMaxWait *time.Duration
+	CachePendingSeqMaxNum  *int
+	CacheSkippedSeqMaxWait *time.Duration
+	FeedLagWarnThreshold   *time.Duration
+
+	// ChannelNameNormalization changes the channel name normalization policy.  Enabling the lowercase policy merges
+	// existing caches of channels whose names differ only by case.
+	ChannelNameNormalization *string
+}
+
+// GetOptions returns a copy of the cache's in-memory options.
+func (c *changeCache) GetOptions() CacheOptions {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.options
+}
+
+// UpdateOptions applies update to the cache's in-memory options.  Updates apply to the next pending or skipped
+// sequence check, or change received on the feed - the intervals of the background tasks that check pending and skipped sequences are set when the
+// cache is initialized, and aren't changed.
+func (c *changeCache) UpdateOptions(update CacheOptionsUpdate) error {
+	if update.CachePendingSeqMaxWait != nil && *update.CachePendingSeqMaxWait <= 0 {
+		return base.HTTPErrorf(http.StatusBadRequest, "Pending sequence max wait must be greater than zero")
+	}
+	if update.CachePendingSeqMaxNum != nil && *update.CachePendingSeqMaxNum <= 0 {
+		return base.HTTPErrorf(http.StatusBadRequest, "Pending sequence max num must be greater than zero")
+	}
+	if update.CacheSkippedSeqMaxWait != nil && *update.CacheSkippedSeqMaxWait <= 0 {
+		return base.HTTPErrorf(http.StatusBadRequest, "Skipped sequence max wait must be greater than zero")
+	}
+	if update.FeedLagWarnThreshold != nil && *update.FeedLagWarnThreshold <= 0 {
+		return base.HTTPErrorf(http.StatusBadRequest, "Feed lag warn threshold must be greater than zero")
+	}
+	if update.ChannelNameNormalization != nil {
+		if err := ValidateChannelNameNormalization(*update.ChannelNameNormalization); err != nil {
+			return base.HTTPErrorf(http.StatusBadRequest, "Invalid channel name normalization: %v", err)
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	modified := func(option string) {
+		if c.optionsModified == nil {
+			c.optionsModified = make(map[string]time.Time)
+		}
+		c.optionsModified[option] = now
+	}
+	if update.CachePendingSeqMaxWait != nil {
+		// A manual override of the pending wait stops it being adapted
+		c.options.CachePendingSeqMaxWait = *update.CachePendingSeqMaxWait
+		c.options.AdaptivePendingSeqMaxWait = false
+		c._setPendingSeqMaxWait(c.options.CachePendingSeqMaxWait)
+		modified(CacheOptionPendingSeqMaxWait)
+	}
+	if update.CachePendingSeqMaxNum != nil {
+		c.options.CachePendingSeqMaxNum = *update.CachePendingSeqMaxNum
+		modified(CacheOptionPendingSeqMaxNum)
+	}
+	if update.CacheSkippedSeqMaxWait != nil {
+		c.options.CacheSkippedSeqMaxWait = *update.CacheSkippedSeqMaxWait
+		modified(CacheOptionSkippedSeqMaxWait)
+	}
+	if update.FeedLagWarnThreshold != nil {
+		c.options.FeedLagWarnThreshold = *update.FeedLagWarnThreshold
+		modified(CacheOptionFeedLagWarnThreshold)
+	}
+	if update.ChannelNameNormalization != nil {
+		c.options.ChannelCacheOptions.ChannelNameNormalization = *update.ChannelNameNormalization
+		merged := c.channelCache.setChannelNameNormalization(*update.ChannelNameNormalization)
+		base.Infof(base.KeyCache, "Channel name normalization for database %s set to %q - %d channel caches merged or dropped",
+			base.MD(c.dbName), *update.ChannelNameNormalization, merged)
+		modified(CacheOptionChannelNameNormalization)
+	}
+	base.Infof(base.KeyCache, "Updated changes cache options for database %s: %+v", base.MD(c.dbName), c.options)
+	return nil
+}
+
+// OptionsModified returns the time each cache option was last updated at runtime, by CacheOptions field name.
+// Options that haven't been updated since the cache was initialized aren't included.
+func (c *changeCache) OptionsModified() map[string]time.Time {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	modified := make(map[string]time.Time, len(c.optionsModified))
+	for option, t := range c.optionsModified {
+		modified[option] = t
+	}
+	return modified
+}
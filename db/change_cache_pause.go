@@ -0,0 +1,94 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// CachePauseStatus reports whether the change cache is paused, and the sequences it was last paused and resumed at.
+type CachePauseStatus struct {
+	Paused    bool   `json:"paused"`
+	PausedAt  uint64 `json:"paused_at,omitempty"`  // Last sequence cached before the cache was paused
+	ResumedAt uint64 `json:"resumed_at,omitempty"` // Last sequence received while paused - channel caches are valid from the following sequence
+}
+
+// Pause stops caching feed entries, to shed channel cache load.  While paused, entries are still received and
+// buffered as usual, so the sequence state stays current, but aren't added to the channel caches - changes feeds are
+// served up to the sequence the cache was paused at, and aren't notified of later changes until the cache is resumed.
+// A no-op if the cache is already paused.
+func (c *changeCache) Pause() CachePauseStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.paused {
+		c.paused = true
+		c.pauseStatus = CachePauseStatus{Paused: true, PausedAt: c.channelCache.GetHighCacheSequence()}
+		base.Infof(base.KeyCache, "Paused change cache for %s at #%d", base.MD(c.dbName), c.pauseStatus.PausedAt)
+	}
+	return c.pauseStatus
+}
+
+// Resume resumes caching feed entries after Pause.  The entries received while paused weren't cached, so the channel
+// caches are revalidated from the sequence following the last received - changes requests since an earlier sequence
+// are backfilled by query, rather than skipping the entries received while paused.  The changes feeds of the channels
+// changed while paused are then notified.  A no-op if the cache isn't paused.
+func (c *changeCache) Resume() CachePauseStatus {
+	c.lock.Lock()
+	if !c.paused {
+		defer c.lock.Unlock()
+		return c.pauseStatus
+	}
+	resumedAt := c.nextSequence - 1
+	if c.pauseStatus.PausedAt > resumedAt {
+		resumedAt = c.pauseStatus.PausedAt
+	}
+	c.channelCache.Revalidate(resumedAt + 1)
+	c.paused = false
+	c.pauseStatus.Paused = false
+	c.pauseStatus.ResumedAt = resumedAt
+	status := c.pauseStatus
+	changedChannels := c.pausedChannels
+	c.pausedChannels = nil
+	c.lock.Unlock()
+
+	base.Infof(base.KeyCache, "Resumed change cache for %s at #%d, notifying %d channels changed while paused",
+		base.MD(c.dbName), resumedAt, len(changedChannels))
+	c.notifyChanged(changedChannels)
+	return status
+}
+
+// PauseStatus returns whether the cache is paused, and the sequences it was last paused and resumed at.
+func (c *changeCache) PauseStatus() CachePauseStatus {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.pauseStatus
+}
+
+// _addPausedChange records the channels of a change received while paused, to be notified on resume.  The number of
+// channels recorded is bounded by MaxUnnotifiedChannels.  Requires the caller to hold c.lock.
+func (c *changeCache) _addPausedChange(change *LogEntry) {
+	if c.pausedChannels == nil {
+		c.pausedChannels = base.Set{}
+	}
+	add := func(channelID ChannelID) {
+		key := channelID.String()
+		if len(c.pausedChannels) < MaxUnnotifiedChannels || c.pausedChannels.Contains(key) {
+			c.pausedChannels.Add(key)
+		}
+	}
+	for channelName, removal := range change.Channels {
+		if removal == nil || removal.Seq == change.Sequence {
+			add(logEntryChannelID(change, channelName))
+		}
+	}
+	add(logEntryChannelID(change, channels.UserStarChannel))
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Interval at which an adaptive pending wait is re-evaluated.  Var to support testing
+var AdaptivePendingSeqWaitInterval = 30 * time.Second
+
+const (
+	adaptivePendingSeqWaitFactor = 2    // Multiple of the observed delay used as the pending wait
+	adaptivePendingSeqMinSamples = 1000 // Out of order sequences observed before the pending wait is adapted
+)
+
+// pendingWaitWindow tracks the out of order sequences observed since the pending wait was last adapted.
+type pendingWaitWindow struct {
+	baseline          base.HistogramSnapshot // Pending wait distribution when the window started
+	recovered         uint64                 // Skipped sequences received since the cache was initialized
+	baselineRecovered uint64                 // Skipped sequences received when the window started
+}
+
+// adaptPendingSeqMaxWait sets the pending wait to adaptivePendingSeqWaitFactor times the 99.9th percentile delay of the
+// sequences that arrived out of order since it was last adapted, bounded by CachePendingSeqMinWait and
+// CachePendingSeqMaxWait.  Delays are observed for sequences that filled a gap before the pending wait expired.  Skipped
+// sequences that are received later are counted as delays beyond the pending wait - when they're over 0.1% of those
+// observed, the percentile is beyond the pending wait, so the wait is increased by the factor.  Observations accumulate
+// until there are at least adaptivePendingSeqMinSamples.  Error returned to fulfil BackgroundTaskFunc signature.
+func (c *changeCache) adaptPendingSeqMaxWait(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.options.AdaptivePendingSeqMaxWait {
+		return nil
+	}
+
+	window := &c.pendingWaitWindow
+	current := c.dbStats.Cache().PendingSeqWait.Snapshot()
+	recovered := window.recovered - window.baselineRecovered
+	total := current.Count - window.baseline.Count + recovered
+	if total < adaptivePendingSeqMinSamples {
+		return nil
+	}
+
+	// Find the bucket holding the percentile, ranking received skipped sequences as the longest delays
+	rank := (total*999 + 999) / 1000
+	wait := c.pendingSeqMaxWait * adaptivePendingSeqWaitFactor
+	var cumulative uint64
+	for i, upperBound := range current.UpperBounds {
+		cumulative += current.Counts[i] - window.baseline.Counts[i]
+		if cumulative >= rank {
+			wait = time.Duration(upperBound) * time.Millisecond * adaptivePendingSeqWaitFactor
+			break
+		}
+	}
+	if wait < c.options.CachePendingSeqMinWait {
+		wait = c.options.CachePendingSeqMinWait
+	} else if wait > c.options.CachePendingSeqMaxWait {
+		wait = c.options.CachePendingSeqMaxWait
+	}
+
+	if wait != c.pendingSeqMaxWait {
+		base.InfofCtx(ctx, base.KeyCache, "Adapted pending sequence max wait for database %s from %v to %v - %d sequences arrived out of order, %d after being skipped",
+			base.MD(c.dbName), c.pendingSeqMaxWait, wait, total, recovered)
+		c._setPendingSeqMaxWait(wait)
+	}
+	window.baseline = current
+	window.baselineRecovered = window.recovered
+	return nil
+}
+
+// _setPendingSeqMaxWait sets the max wait for a pending sequence, and its gauge.  Requires c.lock.
+func (c *changeCache) _setPendingSeqMaxWait(wait time.Duration) {
+	c.pendingSeqMaxWait = wait
+	c.dbStats.Cache().PendingSeqMaxWait.Set(int64(wait / time.Millisecond))
+}
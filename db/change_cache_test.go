@@ -15,7 +15,10 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -74,15 +77,123 @@ func logEntry(seq uint64, docid string, revid string, channelNames []string) *Lo
 	return entry
 }
 
+// testCacheBackingStore is an in-memory cacheBackingStore, to exercise changeCache buffering without a database.
+type testCacheBackingStore struct {
+	docs         map[string]*Document // Documents by docID
+	lastSequence uint64               // Returned by LastSequence
+	lock         sync.RWMutex         // Coordinates access to docs
+}
+
+func newTestCacheBackingStore() *testCacheBackingStore {
+	return &testCacheBackingStore{docs: make(map[string]*Document)}
+}
+
+// addDoc adds a document "doc-[sequence]" in the specified channels
+func (s *testCacheBackingStore) addDoc(sequence uint64, channelNames []string) {
+	doc := NewDocument(fmt.Sprintf("doc-%d", sequence))
+	doc.CurrentRev = "1-a"
+	doc.Sequence = sequence
+	doc.Channels = make(channels.ChannelMap)
+	for _, channelName := range channelNames {
+		doc.Channels[channelName] = nil
+	}
+	s.lock.Lock()
+	s.docs[doc.ID] = doc
+	if sequence > s.lastSequence {
+		s.lastSequence = sequence
+	}
+	s.lock.Unlock()
+}
+
+func (s *testCacheBackingStore) docLogEntry(doc *Document) *LogEntry {
+	return &LogEntry{
+		Sequence:     doc.Sequence,
+		DocID:        doc.ID,
+		RevID:        doc.CurrentRev,
+		Channels:     doc.Channels,
+		TimeReceived: time.Now(),
+	}
+}
+
+func (s *testCacheBackingStore) getChangesInChannelFromQuery(ctx context.Context, channelName string, startSeq, endSeq uint64, limit int, activeOnly bool) (LogEntries, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var entries LogEntries
+	for _, doc := range s.docs {
+		if _, ok := doc.Channels[channelName]; !ok && channelName != channels.UserStarChannel {
+			continue
+		}
+		if doc.Sequence < startSeq || (endSeq > 0 && doc.Sequence > endSeq) {
+			continue
+		}
+		entries = append(entries, s.docLogEntry(doc))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Sequence < entries[j].Sequence })
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (s *testCacheBackingStore) LastSequence() (uint64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.lastSequence, nil
+}
+
+func (s *testCacheBackingStore) getChangesForSequences(ctx context.Context, sequences []uint64) (LogEntries, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var entries LogEntries
+	for _, doc := range s.docs {
+		for _, sequence := range sequences {
+			if doc.Sequence == sequence {
+				entries = append(entries, s.docLogEntry(doc))
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Sequence < entries[j].Sequence })
+	return entries, nil
+}
+
+func (s *testCacheBackingStore) GetDocument(docid string, unmarshalLevel DocumentUnmarshalLevel) (*Document, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	doc, ok := s.docs[docid]
+	if !ok {
+		return nil, base.ErrNotFound
+	}
+	return doc, nil
+}
+
+func (s *testCacheBackingStore) UseXattrs() bool {
+	return false
+}
+
+func (s *testCacheBackingStore) checkForUpgrade(key string, unmarshalLevel DocumentUnmarshalLevel) (*Document, *sgbucket.BucketDocument) {
+	return nil, nil
+}
+
+// newTestChangeCache returns a started changeCache backed by store, for tests that don't require a database.  Callers
+// must Stop the cache.
+func newTestChangeCache(t testing.TB, store cacheBackingStore, options *CacheOptions) *changeCache {
+	dbStats := base.NewSyncGatewayStats().NewDBStats("", false, false, false)
+	cache := &changeCache{}
+	require.NoError(t, cache.init("db", dbStats, &DatabaseContextOptions{}, store,
+		channels.NewActiveChannels(dbStats.Cache().NumActiveChannels), nil, options))
+	require.NoError(t, cache.Start(0))
+	return cache
+}
+
 func TestSkippedSequenceList(t *testing.T) {
 
 	skipList := NewSkippedSequenceList()
 	//Push values
-	assert.NoError(t, skipList.Push(&SkippedSequence{4, time.Now()}))
-	assert.NoError(t, skipList.Push(&SkippedSequence{7, time.Now()}))
-	assert.NoError(t, skipList.Push(&SkippedSequence{8, time.Now()}))
-	assert.NoError(t, skipList.Push(&SkippedSequence{12, time.Now()}))
-	assert.NoError(t, skipList.Push(&SkippedSequence{18, time.Now()}))
+	assert.NoError(t, skipList.Push(&SkippedSequence{seq: 4, timeAdded: time.Now()}))
+	assert.NoError(t, skipList.Push(&SkippedSequence{seq: 7, timeAdded: time.Now()}))
+	assert.NoError(t, skipList.Push(&SkippedSequence{seq: 8, timeAdded: time.Now()}))
+	assert.NoError(t, skipList.Push(&SkippedSequence{seq: 12, timeAdded: time.Now()}))
+	assert.NoError(t, skipList.Push(&SkippedSequence{seq: 18, timeAdded: time.Now()}))
 	assert.True(t, verifySkippedSequences(skipList, []uint64{4, 7, 8, 12, 18}))
 
 	// Retrieval of low value
@@ -108,11 +219,215 @@ func TestSkippedSequenceList(t *testing.T) {
 	assert.True(t, verifySkippedSequences(skipList, []uint64{7}))
 
 	// Add an out-of-sequence entry (make sure bad sequencing doesn't throw us into an infinite loop)
-	assert.Error(t, skipList.Push(&SkippedSequence{6, time.Now()}))
-	assert.NoError(t, skipList.Push(&SkippedSequence{9, time.Now()}))
+	assert.Error(t, skipList.Push(&SkippedSequence{seq: 6, timeAdded: time.Now()}))
+	assert.NoError(t, skipList.Push(&SkippedSequence{seq: 9, timeAdded: time.Now()}))
 	assert.True(t, verifySkippedSequences(skipList, []uint64{7, 9}))
 }
 
+// Validates that contiguous skipped sequences are stored as ranges, which are shrunk and split as sequences are
+// removed.
+func TestSkippedSequenceListRanges(t *testing.T) {
+
+	skipList := NewSkippedSequenceList()
+	addedTime := time.Now().Add(-time.Hour)
+	for seq := uint64(10); seq <= 20; seq++ {
+		require.NoError(t, skipList.Push(&SkippedSequence{seq: seq, timeAdded: addedTime, pendingSeq: 21, pendingLen: 5}))
+	}
+	// Sequences skipped for another pending sequence start a new range, even when contiguous
+	require.NoError(t, skipList.Push(&SkippedSequence{seq: 21, timeAdded: time.Now(), pendingSeq: 30, pendingLen: 1}))
+	require.NoError(t, skipList.Push(&SkippedSequence{seq: 25, timeAdded: time.Now(), pendingSeq: 30, pendingLen: 1}))
+	require.Len(t, skipList.ranges, 3)
+	assert.Equal(t, int64(13), skipList.getNumSequences())
+	assert.Error(t, skipList.Push(&SkippedSequence{seq: 22, timeAdded: time.Now()}))
+
+	info, ok := skipList.get(15)
+	require.True(t, ok)
+	assert.Equal(t, SkippedSequenceInfo{Sequence: 15, TimeAdded: addedTime, PendingSeq: 21, PendingLen: 5}, info)
+	assert.False(t, skipList.Contains(9))
+	assert.False(t, skipList.Contains(22))
+	assert.True(t, skipList.Contains(21))
+
+	// Removal from either end shrinks the range, and interior removal splits it
+	require.NoError(t, skipList.Remove(10))
+	require.NoError(t, skipList.Remove(20))
+	require.NoError(t, skipList.Remove(15))
+	assert.Error(t, skipList.Remove(15))
+	require.Len(t, skipList.ranges, 4)
+	assert.True(t, verifySkippedSequences(skipList, []uint64{11, 12, 13, 14, 16, 17, 18, 19, 21, 25}))
+	assert.Equal(t, uint64(11), skipList.getOldest())
+	info, ok = skipList.get(16)
+	require.True(t, ok)
+	assert.Equal(t, addedTime, info.TimeAdded)
+
+	olderThan := skipList.getOlderThan(time.Minute)
+	assert.Len(t, olderThan, 2)
+	assert.Equal(t, []uint64{11, 12, 13, 14, 16, 17, 18, 19}, skippedRangeSequences(olderThan))
+
+	// The oldest sequences are returned as ranges, with the last truncated at the limit
+	oldest := skipList.getOldestSequences(6)
+	assert.Len(t, oldest, 2)
+	assert.Equal(t, []uint64{11, 12, 13, 14, 16, 17}, skippedRangeSequences(oldest))
+	assert.Equal(t, []uint64{11, 12, 13, 14, 16, 17, 18, 19, 21, 25}, skippedRangeSequences(skipList.getOldestSequences(100)))
+	assert.Empty(t, skipList.getOldestSequences(0))
+	infos := skipList.getInfo(5)
+	require.Len(t, infos, 5)
+	assert.Equal(t, uint64(16), infos[4].Sequence)
+
+	// Removing every sequence of a range removes the range
+	assert.Equal(t, int64(5), skipList.RemoveSequences(context.Background(), []uint64{11, 12, 13, 14, 21}))
+	require.Len(t, skipList.ranges, 2)
+	assert.True(t, verifySkippedSequences(skipList, []uint64{16, 17, 18, 19, 25}))
+	assert.Equal(t, uint64(16), skipList.getOldest())
+}
+
+// Validates that skipped ranges are split into query batches without expanding ranges beyond the batch.
+func TestNextSkippedBatch(t *testing.T) {
+	ranges := []skippedSequenceRange{{start: 5, end: 9}, {start: 12, end: 12}, {start: 20, end: 21}}
+	assert.Equal(t, int64(8), numSkippedSequences(ranges))
+
+	batch, ranges := nextSkippedBatch(ranges, 3)
+	assert.Equal(t, []uint64{5, 6, 7}, batch)
+	batch, ranges = nextSkippedBatch(ranges, 3)
+	assert.Equal(t, []uint64{8, 9, 12}, batch)
+	assert.Equal(t, int64(2), numSkippedSequences(ranges))
+	batch, ranges = nextSkippedBatch(ranges, 3)
+	assert.Equal(t, []uint64{20, 21}, batch)
+	assert.Empty(t, ranges)
+}
+
+// Validates iteration over skipped sequences in pages, including early exit and removal by the callback.
+func TestSkippedSequenceListForEach(t *testing.T) {
+
+	defer func(pageSize int) { SkippedSeqIterationPageSize = pageSize }(SkippedSeqIterationPageSize)
+	SkippedSeqIterationPageSize = 3
+
+	skipList := NewSkippedSequenceList()
+	addedTime := time.Now().Add(-time.Hour)
+	for seq := uint64(10); seq <= 17; seq++ {
+		require.NoError(t, skipList.Push(&SkippedSequence{seq: seq, timeAdded: addedTime, pendingSeq: 18}))
+	}
+	require.NoError(t, skipList.Push(&SkippedSequence{seq: 20, timeAdded: time.Now(), pendingSeq: 21}))
+
+	collect := func(callback func(seq uint64) bool) []uint64 {
+		var sequences []uint64
+		skipList.ForEach(func(seq uint64, added time.Time) bool {
+			sequences = append(sequences, seq)
+			return callback(seq)
+		})
+		return sequences
+	}
+	assert.Equal(t, []uint64{10, 11, 12, 13, 14, 15, 16, 17, 20}, collect(func(uint64) bool { return true }))
+	assert.Equal(t, []uint64{10, 11, 12, 13}, collect(func(seq uint64) bool { return seq < 13 }))
+
+	var added []time.Time
+	skipList.ForEach(func(seq uint64, addedAt time.Time) bool {
+		added = append(added, addedAt)
+		return true
+	})
+	require.Len(t, added, 9)
+	assert.Equal(t, addedTime, added[0])
+	assert.True(t, added[8].After(addedTime))
+
+	// Sequences removed by the callback are skipped once their page is read, but not within the current page
+	visited := collect(func(seq uint64) bool {
+		if seq == 10 {
+			require.NoError(t, skipList.Remove(11))
+			require.NoError(t, skipList.Remove(14))
+		}
+		return true
+	})
+	assert.Equal(t, []uint64{10, 11, 12, 13, 15, 16, 17, 20}, visited)
+	assert.True(t, verifySkippedSequences(skipList, []uint64{10, 12, 13, 15, 16, 17, 20}))
+
+	assert.Len(t, skipList.getInfo(4), 4)
+	assert.Len(t, skipList.getInfo(100), 7)
+	assert.Empty(t, skipList.getInfo(0))
+	assert.Equal(t, []uint64{10, 12, 13, 15, 16, 17}, skippedRangeSequences(skipList.getOlderThan(time.Minute)))
+	assert.Empty(t, NewSkippedSequenceList().getInfo(10))
+}
+
+// Iterates over skipped sequences while they're concurrently removed and pushed, and validates that every sequence
+// visited is in order, and was skipped before or during the iteration.  Intended to be run with the race detector.
+func TestSkippedSequenceListConcurrentForEach(t *testing.T) {
+
+	defer func(pageSize int) { SkippedSeqIterationPageSize = pageSize }(SkippedSeqIterationPageSize)
+	SkippedSeqIterationPageSize = 10
+
+	const numSequences = 5000
+	skipList := NewSkippedSequenceList()
+	for seq := uint64(1); seq <= numSequences; seq += 2 {
+		require.NoError(t, skipList.Push(&SkippedSequence{seq: seq, timeAdded: time.Now()}))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for seq := uint64(1); seq <= numSequences; seq += 4 {
+			assert.NoError(t, skipList.Remove(seq))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for seq := uint64(numSequences + 1); seq <= 2*numSequences; seq += 2 {
+			assert.NoError(t, skipList.Push(&SkippedSequence{seq: seq, timeAdded: time.Now()}))
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		var previous uint64
+		skipList.ForEach(func(seq uint64, added time.Time) bool {
+			assert.Greater(t, seq, previous)
+			assert.Equal(t, uint64(1), seq%2, "Sequence %d was never skipped", seq)
+			previous = seq
+			return true
+		})
+	}
+	wg.Wait()
+
+	// Once updates are complete, iteration sees exactly the remaining sequences
+	var remaining []uint64
+	skipList.ForEach(func(seq uint64, added time.Time) bool {
+		remaining = append(remaining, seq)
+		return true
+	})
+	require.Len(t, remaining, int(skipList.getNumSequences()))
+	for _, seq := range remaining {
+		if seq <= numSequences {
+			assert.NotEqual(t, uint64(1), seq%4, "Removed sequence %d was visited", seq)
+		}
+	}
+}
+
+// Benchmarks skipping a block of 1M contiguous sequences, as when sequences are lost on failover, and then resolving
+// them in order.  Contiguous sequences are stored as a single range, in contrast to the same number of sequences
+// skipped individually.
+func BenchmarkSkippedSequenceListPushRemove(b *testing.B) {
+
+	const numSequences = 1000000
+	for _, test := range []struct {
+		name string
+		step uint64 // Increment between skipped sequences
+	}{
+		{"contiguous", 1},
+		{"noncontiguous", 2},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				skipList := NewSkippedSequenceList()
+				timeAdded := time.Now()
+				for seq := uint64(1); seq <= numSequences*test.step; seq += test.step {
+					_ = skipList.Push(&SkippedSequence{seq: seq, timeAdded: timeAdded})
+				}
+				for seq := uint64(1); seq <= numSequences*test.step; seq += test.step {
+					_ = skipList.Remove(seq)
+				}
+			}
+		})
+	}
+}
+
 func TestLateSequenceHandling(t *testing.T) {
 
 	context := setupTestDBWithCacheOptions(t, DefaultCacheOptions())
@@ -307,7 +622,7 @@ func TestLateSequenceErrorRecovery(t *testing.T) {
 	// Modify the cache's late logs to remove the changes feed's lateFeedHandler sequence from the
 	// cache's lateLogs.  This will trigger an error on the next feed iteration, which should trigger
 	// rollback to resend all changes since low sequence (1)
-	abcCache := db.changeCache.getChannelCache().getSingleChannelCache("ABC").(*singleChannelCacheImpl)
+	abcCache := db.changeCache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("ABC")).(*singleChannelCacheImpl)
 	abcCache.lateLogs[0].logEntry.Sequence = 1
 
 	// Write sequence 3.  Error should trigger rollback that resends everything since low sequence (1)
@@ -480,11 +795,6 @@ func WriteDirect(db *Database, channelArray []string, sequence uint64) {
 	WriteDirectWithKey(db, docId, channelArray, sequence)
 }
 
-func WriteUserDirect(db *Database, username string, sequence uint64) {
-	docId := base.UserPrefix + username
-	_, _ = db.Bucket.Add(docId, 0, Body{"sequence": sequence, "name": username})
-}
-
 func WriteDirectWithKey(db *Database, key string, channelArray []string, sequence uint64) {
 
 	if base.TestUseXattrs() {
@@ -540,39 +850,38 @@ func WriteDirectWithChannelGrant(db *Database, channelArray []string, sequence u
 // Test notification when buffered entries are processed after a user doc arrives.
 func TestChannelCacheBufferingWithUserDoc(t *testing.T) {
 
-	if base.TestUseXattrs() {
-		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
-	}
-
 	defer base.SetUpTestLogging(base.LevelDebug, base.KeyCache, base.KeyChanges, base.KeyDCP)()
 
-	db := setupTestDB(t)
-	defer db.Close()
-	db.ChannelMapper = channels.NewDefaultChannelMapper()
-
-	// Simulate seq 1 (user doc) being delayed - write 2 first
-	WriteDirect(db, []string{"ABC"}, 2)
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), nil)
+	defer cache.Stop()
 
-	// Start wait for doc in ABC
-	waiter := db.mutationListener.NewWaiterWithChannels(channels.SetOf(t, "ABC"), nil)
+	notified := make(chan base.Set, 10)
+	cache.SetNotifyChange(func(changedChannels base.Set) {
+		notified <- changedChannels
+	})
 
-	successChan := make(chan bool)
-	go func() {
-		waiter.Wait()
-		close(successChan)
-	}()
+	// Simulate seq 1 (user doc) being delayed - process 2 first
+	cache.processEntry(logEntry(2, "doc-2", "1-a", []string{"ABC"}))
+	assert.Equal(t, uint64(1), cache.getNextSequence())
 
 	// Simulate a user doc update
-	WriteUserDirect(db, "bernard", 1)
+	cache.DocChanged(sgbucket.FeedEvent{
+		Opcode:       sgbucket.FeedOpMutation,
+		Synchronous:  true,
+		Key:          []byte(base.UserPrefix + "bernard"),
+		Value:        []byte(`{"name":"bernard","sequence":1}`),
+		DataType:     base.MemcachedDataTypeJSON,
+		TimeReceived: time.Now(),
+	})
 
 	// Wait 3 seconds for notification, else fail the test.
 	select {
-	case <-successChan:
-		log.Println("notification successful")
+	case changedChannels := <-notified:
+		assert.True(t, changedChannels.Contains("ABC"))
 	case <-time.After(time.Second * 3):
 		t.Fatal("No notification after 3 seconds")
 	}
-
+	assert.Equal(t, uint64(3), cache.getNextSequence())
 }
 
 // Test backfill of late arriving sequences to the channel caches
@@ -617,16 +926,16 @@ func TestChannelCacheBackfill(t *testing.T) {
 	WriteDirect(db, []string{"CBS"}, 7)
 	require.NoError(t, db.changeCache.waitForSequence(context.TODO(), 7, base.DefaultWaitForSequence))
 	// verify insert at start (PBS)
-	pbsCache := db.changeCache.getChannelCache().getSingleChannelCache("PBS").(*singleChannelCacheImpl)
+	pbsCache := db.changeCache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("PBS")).(*singleChannelCacheImpl)
 	goassert.True(t, verifyCacheSequences(pbsCache, []uint64{3, 5, 6}))
 	// verify insert at middle (ABC)
-	abcCache := db.changeCache.getChannelCache().getSingleChannelCache("ABC").(*singleChannelCacheImpl)
+	abcCache := db.changeCache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("ABC")).(*singleChannelCacheImpl)
 	goassert.True(t, verifyCacheSequences(abcCache, []uint64{1, 2, 3, 5, 6}))
 	// verify insert at end (NBC)
-	nbcCache := db.changeCache.getChannelCache().getSingleChannelCache("NBC").(*singleChannelCacheImpl)
+	nbcCache := db.changeCache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("NBC")).(*singleChannelCacheImpl)
 	goassert.True(t, verifyCacheSequences(nbcCache, []uint64{1, 3}))
 	// verify insert to empty cache (TBS)
-	tbsCache := db.changeCache.getChannelCache().getSingleChannelCache("TBS").(*singleChannelCacheImpl)
+	tbsCache := db.changeCache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("TBS")).(*singleChannelCacheImpl)
 	goassert.True(t, verifyCacheSequences(tbsCache, []uint64{3}))
 
 	// verify changes has three entries (needs to resend all since previous LowSeq, which
@@ -804,6 +1113,45 @@ func TestLowSequenceHandling(t *testing.T) {
 
 }
 
+// Verify safe stable sequence doesn't advance past an outstanding skipped sequence, and advances once it's recovered
+func TestStableSequenceProvider(t *testing.T) {
+
+	if base.TestUseXattrs() {
+		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
+	}
+
+	defer base.SetUpTestLogging(base.LevelDebug, base.KeyCache)()
+
+	cacheOptions := shortWaitCache()
+	db := setupTestDBWithOptions(t, DatabaseContextOptions{
+		CacheOptions:       &cacheOptions,
+		UnsupportedOptions: UnsupportedOptions{SafeStableSequence: true},
+	})
+	defer db.Close()
+
+	provider := &intSequenceProvider{changeCache: db.changeCache}
+	safeProvider := db.stableSequence
+
+	// Simulate seq 3 and 4 being delayed - write 1,2,5,6
+	WriteDirect(db, []string{"ABC"}, 1)
+	WriteDirect(db, []string{"ABC"}, 2)
+	WriteDirect(db, []string{"ABC"}, 5)
+	WriteDirect(db, []string{"ABC"}, 6)
+	require.NoError(t, db.changeCache.waitForSequence(context.TODO(), 6, base.DefaultWaitForSequence))
+
+	assert.Equal(t, uint64(6), provider.GetStableSequence("doc-1").Seq)
+	assert.Equal(t, uint64(2), safeProvider.GetStableSequence("doc-1").Seq)
+
+	// Recover the skipped sequences
+	WriteDirect(db, []string{"ABC"}, 3)
+	WriteDirect(db, []string{"ABC"}, 4)
+	require.NoError(t, db.changeCache.waitForSequenceNotSkipped(context.TODO(), 4, base.DefaultWaitForSequence))
+	require.NoError(t, db.changeCache.waitForSequenceNotSkipped(context.TODO(), 3, base.DefaultWaitForSequence))
+
+	assert.Equal(t, uint64(6), provider.GetStableSequence("doc-1").Seq)
+	assert.Equal(t, uint64(6), safeProvider.GetStableSequence("doc-1").Seq)
+}
+
 // Test low sequence handling of late arriving sequences to a continuous changes feed, when the
 // user doesn't have visibility to some of the late arriving sequences
 func TestLowSequenceHandlingAcrossChannels(t *testing.T) {
@@ -1062,6 +1410,92 @@ func TestChannelQueryCancellation(t *testing.T) {
 	assert.Equal(t, initialQueryCount+1, finalQueryCount)
 }
 
+// Validates that the results of a cache backfill query are merged into the channel cache, so that repeating a request
+// for a cold channel is served from the cache without another query.
+func TestChannelQueryResultsMergedIntoCache(t *testing.T) {
+
+	if !base.UnitTestUrlIsWalrus() {
+		t.Skip("Skip test with LeakyBucket dependency test when running in integration")
+	}
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache)()
+
+	var queryCount int32
+	queryCallbackConfig := base.LeakyBucketConfig{
+		PostQueryCallback: func(ddoc, viewName string, params map[string]interface{}) {
+			atomic.AddInt32(&queryCount, 1)
+		},
+		PostN1QLQueryCallback: func() {
+			atomic.AddInt32(&queryCount, 1)
+		},
+	}
+
+	db := setupTestLeakyDBWithCacheOptions(t, DefaultCacheOptions(), queryCallbackConfig)
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+	defer db.Close()
+
+	for i := 1; i <= 4; i++ {
+		_, _, err := db.Put(fmt.Sprintf("key%d", i), Body{"channels": "ABC"})
+		require.NoError(t, err)
+	}
+	revID, _, err := db.Put("deleted", Body{"channels": "ABC"})
+	require.NoError(t, err)
+	_, err = db.DeleteDoc("deleted", revID)
+	require.NoError(t, err)
+	require.NoError(t, db.changeCache.waitForSequence(context.TODO(), 6, base.DefaultWaitForSequence))
+
+	cacheStats := db.DbStats.Cache()
+
+	// Active-only results are pushed down to the query, and aren't complete, so aren't merged into the cache
+	require.NoError(t, db.FlushChannelCache())
+	activeOnlyOptions := ChangesOptions{Since: SequenceID{Seq: 0}, ActiveOnly: true}
+	for i := 0; i < 2; i++ {
+		initialQueryCount := atomic.LoadInt32(&queryCount)
+		initialMerged := cacheStats.ChannelCacheBackfillMerged.Value()
+		changes, err := db.GetChanges(base.SetOf("ABC"), activeOnlyOptions)
+		require.NoError(t, err)
+		require.Len(t, changes, 4)
+		assert.Greater(t, atomic.LoadInt32(&queryCount), initialQueryCount)
+		assert.Equal(t, initialMerged, cacheStats.ChannelCacheBackfillMerged.Value())
+	}
+
+	// Flush the cache, so that the channel is cold
+	require.NoError(t, db.FlushChannelCache())
+
+	options := ChangesOptions{Since: SequenceID{Seq: 0}}
+	initialQueryCount := atomic.LoadInt32(&queryCount)
+	initialMisses := cacheStats.ChannelCacheMisses.Value()
+	initialMerged := cacheStats.ChannelCacheBackfillMerged.Value()
+	changes, err := db.GetChanges(base.SetOf("ABC"), options)
+	require.NoError(t, err)
+	assert.Greater(t, atomic.LoadInt32(&queryCount), initialQueryCount)
+	assert.Equal(t, initialMisses+1, cacheStats.ChannelCacheMisses.Value())
+	assert.Equal(t, initialMerged+5, cacheStats.ChannelCacheBackfillMerged.Value())
+
+	// The identical request is served from the cache, as are active-only requests
+	for _, activeOnly := range []bool{false, true} {
+		options.ActiveOnly = activeOnly
+		initialQueryCount = atomic.LoadInt32(&queryCount)
+		initialMisses = cacheStats.ChannelCacheMisses.Value()
+		initialHits := cacheStats.ChannelCacheHits.Value()
+		cachedChanges, err := db.GetChanges(base.SetOf("ABC"), options)
+		require.NoError(t, err)
+		assert.Equal(t, initialQueryCount, atomic.LoadInt32(&queryCount), "Unexpected query for activeOnly=%t", activeOnly)
+		assert.Equal(t, initialMisses, cacheStats.ChannelCacheMisses.Value())
+		assert.Greater(t, cacheStats.ChannelCacheHits.Value(), initialHits)
+
+		if !activeOnly {
+			require.Len(t, cachedChanges, 5)
+			for i, change := range changes {
+				assert.Equal(t, change.Seq, cachedChanges[i].Seq)
+				assert.Equal(t, change.ID, cachedChanges[i].ID)
+			}
+		} else {
+			require.Len(t, cachedChanges, 4)
+		}
+	}
+}
+
 func TestLowSequenceHandlingNoDuplicates(t *testing.T) {
 	// TODO: Disabled until https://github.com/couchbase/sync_gateway/issues/3056 is fixed.
 	t.Skip("WARNING: TEST DISABLED")
@@ -1245,79 +1679,6 @@ func TestChannelRace(t *testing.T) {
 	close(options.Terminator)
 }
 
-// Test retrieval of skipped sequence using view.  Unit test catches panic, but we don't currently have a way
-// to simulate an entry that makes it to the bucket (and so is accessible to the view), but doesn't show up on the TAP feed.
-// Cache logging in this test validates that view retrieval is working because of TAP latency (item is in the bucket, but hasn't
-// been seen on the TAP feed yet).  Longer term could consider enhancing leaky bucket to 'miss' the entry on the tap feed.
-func TestSkippedViewRetrieval(t *testing.T) {
-
-	if base.TestUseXattrs() {
-		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
-	}
-
-	defer base.SetUpTestLogging(base.LevelDebug, base.KeyCache)()
-
-	originalBatchSize := SkippedSeqCleanViewBatch
-	SkippedSeqCleanViewBatch = 4
-	defer func() {
-		SkippedSeqCleanViewBatch = originalBatchSize
-	}()
-
-	// Use leaky bucket to have the tap feed 'lose' document 3
-	leakyConfig := base.LeakyBucketConfig{
-		TapFeedMissingDocs: []string{"doc-3", "doc-7", "doc-10", "doc-13", "doc-14"},
-	}
-	db := setupTestLeakyDBWithCacheOptions(t, DefaultCacheOptions(), leakyConfig)
-	defer db.Close()
-	db.ChannelMapper = channels.NewDefaultChannelMapper()
-
-	// Allow db to initialize and run initial CleanSkippedSequenceQueue
-	time.Sleep(10 * time.Millisecond)
-
-	// Write sequences direct
-	WriteDirect(db, []string{"ABC"}, 1)
-	WriteDirect(db, []string{"ABC"}, 2)
-	WriteDirect(db, []string{"ABC"}, 3)
-	WriteDirect(db, []string{"ABC"}, 7)
-	WriteDirect(db, []string{"ABC"}, 10)
-	WriteDirect(db, []string{"ABC"}, 13)
-	WriteDirect(db, []string{"ABC"}, 14)
-	WriteDirect(db, []string{"ABC"}, 15)
-
-	//db.Options.UnsupportedOptions.DisableCleanSkippedQuery = true
-	changeCache := db.changeCache
-
-	// Artificially add skipped sequences to queue, and back date skipped entry by 2 hours to trigger attempted view retrieval during Clean call
-	// Sequences '3', '7', '10', '13' and '14' exist, should be found.
-	require.NoError(t, changeCache.skippedSeqs.Push(&SkippedSequence{3, time.Now().Add(time.Duration(time.Hour * -2))}))
-	require.NoError(t, changeCache.skippedSeqs.Push(&SkippedSequence{5, time.Now().Add(time.Duration(time.Hour * -2))}))
-	require.NoError(t, changeCache.skippedSeqs.Push(&SkippedSequence{6, time.Now().Add(time.Duration(time.Hour * -2))}))
-	require.NoError(t, changeCache.skippedSeqs.Push(&SkippedSequence{7, time.Now().Add(time.Duration(time.Hour * -2))}))
-	require.NoError(t, changeCache.skippedSeqs.Push(&SkippedSequence{10, time.Now().Add(time.Duration(time.Hour * -2))}))
-	require.NoError(t, changeCache.skippedSeqs.Push(&SkippedSequence{11, time.Now().Add(time.Duration(time.Hour * -2))}))
-	require.NoError(t, changeCache.skippedSeqs.Push(&SkippedSequence{12, time.Now().Add(time.Duration(time.Hour * -2))}))
-	require.NoError(t, changeCache.skippedSeqs.Push(&SkippedSequence{13, time.Now().Add(time.Duration(time.Hour * -2))}))
-	require.NoError(t, changeCache.skippedSeqs.Push(&SkippedSequence{14, time.Now().Add(time.Duration(time.Hour * -2))}))
-	cleanErr := changeCache.CleanSkippedSequenceQueue(db.Ctx)
-	assert.NoError(t, cleanErr, "CleanSkippedSequenceQueue returned error")
-
-	// Validate expected entries
-	require.NoError(t, db.changeCache.waitForSequence(context.TODO(), 15, base.DefaultWaitForSequence))
-	entries, err := db.changeCache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 2}})
-	assert.NoError(t, err, "Get Changes returned error")
-	goassert.Equals(t, len(entries), 6)
-	log.Printf("entries: %v", entries)
-	if len(entries) == 6 {
-		goassert.Equals(t, entries[0].DocID, "doc-3")
-		goassert.Equals(t, entries[1].DocID, "doc-7")
-		goassert.Equals(t, entries[2].DocID, "doc-10")
-		goassert.Equals(t, entries[3].DocID, "doc-13")
-		goassert.Equals(t, entries[4].DocID, "doc-14")
-		goassert.Equals(t, entries[5].DocID, "doc-15")
-	}
-
-}
-
 // Test that housekeeping goroutines get terminated when change cache is stopped
 func TestStopChangeCache(t *testing.T) {
 
@@ -1327,33 +1688,28 @@ func TestStopChangeCache(t *testing.T) {
 
 	defer base.SetUpTestLogging(base.LevelDebug, base.KeyChanges, base.KeyDCP)()
 
-	if base.TestUseXattrs() {
-		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
-	}
-
 	// Setup short-wait cache to ensure cleanup goroutines fire often
 	cacheOptions := DefaultCacheOptions()
 	cacheOptions.CachePendingSeqMaxWait = 10 * time.Millisecond
 	cacheOptions.CachePendingSeqMaxNum = 50
 	cacheOptions.CacheSkippedSeqMaxWait = 1 * time.Second
 
-	// Use leaky bucket to have the tap feed 'lose' document 3
-	leakyConfig := base.LeakyBucketConfig{
-		TapFeedMissingDocs: []string{"doc-3"},
+	// Sequence 3 exists, but is missed by the feed
+	store := newTestCacheBackingStore()
+	for _, sequence := range []uint64{1, 2, 3} {
+		store.addDoc(sequence, []string{"ABC"})
 	}
-	db := setupTestLeakyDBWithCacheOptions(t, cacheOptions, leakyConfig)
+	cache := newTestChangeCache(t, store, &cacheOptions)
 
-	// Write sequences direct
-	WriteDirect(db, []string{"ABC"}, 1)
-	WriteDirect(db, []string{"ABC"}, 2)
-	WriteDirect(db, []string{"ABC"}, 3)
+	cache.processEntry(logEntry(1, "doc-1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(2, "doc-2", "1-a", []string{"ABC"}))
 
 	// Artificially add 3 skipped, and back date skipped entry by 2 hours to trigger attempted view retrieval during Clean call
-	err := db.changeCache.skippedSeqs.Push(&SkippedSequence{3, time.Now().Add(time.Duration(time.Hour * -2))})
+	err := cache.skippedSeqs.Push(&SkippedSequence{seq: 3, timeAdded: time.Now().Add(time.Duration(time.Hour * -2))})
 	require.NoError(t, err)
 
-	// tear down the DB.  Should stop the cache before view retrieval of the skipped sequence is attempted.
-	db.Close()
+	// Stop the cache.  Should stop before view retrieval of the skipped sequence is attempted.
+	cache.Stop()
 
 	// Hang around a while to see if the housekeeping tasks fire and panic
 	time.Sleep(1 * time.Second)
@@ -1397,7 +1753,7 @@ func TestChannelCacheSize(t *testing.T) {
 	goassert.Equals(t, len(changes), 750)
 
 	// Validate that cache stores the expected number of values
-	abcCache := db.changeCache.getChannelCache().getSingleChannelCache("ABC").(*singleChannelCacheImpl)
+	abcCache := db.changeCache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("ABC")).(*singleChannelCacheImpl)
 	goassert.Equals(t, len(abcCache.logs), 600)
 }
 
@@ -1411,25 +1767,37 @@ func shortWaitCache() CacheOptions {
 	return cacheOptions
 }
 
+// skippedRangeSequences returns the sequences in ranges, in order.
+func skippedRangeSequences(ranges []skippedSequenceRange) []uint64 {
+	var sequences []uint64
+	for _, skippedRange := range ranges {
+		for seq := skippedRange.start; seq <= skippedRange.end; seq++ {
+			sequences = append(sequences, seq)
+		}
+	}
+	return sequences
+}
+
 func verifySkippedSequences(list *SkippedSequenceList, sequences []uint64) bool {
-	if len(list.skippedMap) != len(sequences) {
-		log.Printf("verifySkippedSequences: skippedMap size (%v) not equals to sequences size (%v)",
-			len(list.skippedMap), len(sequences))
+	if list.numSequences != int64(len(sequences)) {
+		log.Printf("verifySkippedSequences: numSequences (%v) not equals to sequences size (%v)",
+			list.numSequences, len(sequences))
 		return false
 	}
 
 	i := -1
-	for e := list.skippedList.Front(); e != nil; e = e.Next() {
-		i++
-		skippedSeq := e.Value.(*SkippedSequence)
-		if skippedSeq.seq != sequences[i] {
-			log.Printf("verifySkippedSequences: sequence mismatch at index %v, queue=%v, sequences=%v",
-				i, skippedSeq.seq, sequences[i])
-			return false
+	for _, skippedRange := range list.ranges {
+		for seq := skippedRange.start; seq <= skippedRange.end; seq++ {
+			i++
+			if i >= len(sequences) || seq != sequences[i] {
+				log.Printf("verifySkippedSequences: sequence mismatch at index %v, queue=%v, sequences=%v",
+					i, seq, sequences)
+				return false
+			}
 		}
 	}
 	if i != len(sequences)-1 {
-		log.Printf("verifySkippedSequences: skippedList size (%d) not equals to sequences size (%d)",
+		log.Printf("verifySkippedSequences: skipped ranges size (%d) not equals to sequences size (%d)",
 			i+1, len(sequences))
 		return false
 	}
@@ -1582,27 +1950,23 @@ func TestLateArrivingSequenceTriggersOnChange(t *testing.T) {
 
 	// -------- Test setup ----------------
 
-	if base.TestUseXattrs() {
-		t.Skip("This test only works with in-document metadata")
-	}
-
 	// Enable relevant logging
 	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache, base.KeyChanges)()
 
-	// Create a test db that uses channel cache
+	// Create a test cache that uses channel cache
 	options := DefaultCacheOptions()
 	options.ChannelCacheOptions.ChannelCacheMinLength = 600
 	options.ChannelCacheOptions.ChannelCacheMaxLength = 600
 
-	db := setupTestDBWithCacheOptions(t, options)
-	defer db.Close()
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &options)
+	defer cache.Stop()
 
 	// -------- Setup notifyChange callback ----------------
 
 	//  Detect whether the 2nd was ignored using an notifyChange listener callback and make sure it was not added to the ABC channel
 	waitForOnChangeCallback := sync.WaitGroup{}
 	waitForOnChangeCallback.Add(1)
-	db.changeCache.notifyChange = func(channels base.Set) {
+	cache.SetNotifyChange(func(channels base.Set) {
 		// defer waitForOnChangeCallback.Done()
 		log.Printf("channelsChanged: %v", channels)
 		// goassert.True(t, channels.Contains("ABC"))
@@ -1610,7 +1974,7 @@ func TestLateArrivingSequenceTriggersOnChange(t *testing.T) {
 			waitForOnChangeCallback.Done()
 		}
 
-	}
+	})
 
 	// -------- Perform actions ----------------
 
@@ -1651,7 +2015,7 @@ func TestLateArrivingSequenceTriggersOnChange(t *testing.T) {
 		Key:         []byte(doc2Id),
 		Value:       doc2Bytes,
 	}
-	db.changeCache.DocChanged(feedEventDoc2)
+	cache.DocChanged(feedEventDoc2)
 
 	// Send feed event for doc1. This should trigger caching for doc2, and trigger notifyChange for channel ABC.
 	feedEventDoc1 := sgbucket.FeedEvent{
@@ -1659,7 +2023,7 @@ func TestLateArrivingSequenceTriggersOnChange(t *testing.T) {
 		Key:         []byte(doc1Id),
 		Value:       doc1Bytes,
 	}
-	db.changeCache.DocChanged(feedEventDoc1)
+	cache.DocChanged(feedEventDoc1)
 
 	// -------- Wait for waitgroup ----------------
 
@@ -1839,61 +2203,1087 @@ func TestMaxChannelCacheConfig(t *testing.T) {
 // Validates InsertPendingEntries timing
 func TestChangeCache_InsertPendingEntries(t *testing.T) {
 
-	if base.TestUseXattrs() {
-		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
-	}
-
 	defer base.SetUpTestLogging(base.LevelDebug, base.KeyCache, base.KeyChanges)()
 
 	cacheOptions := DefaultCacheOptions()
 	cacheOptions.CachePendingSeqMaxWait = 100 * time.Millisecond
 
-	db := setupTestDBWithCacheOptions(t, cacheOptions)
-	defer db.Close()
-
-	db.ChannelMapper = channels.NewDefaultChannelMapper()
-
-	// Create a user with access to some channels
-	authenticator := db.Authenticator()
-	user, _ := authenticator.NewUser("naomi", "letmein", channels.SetOf(t, "ABC", "PBS", "NBC", "TBS"))
-	require.NoError(t, authenticator.Save(user))
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &cacheOptions)
+	defer cache.Stop()
 
-	// Simulate seq 3 + 4 being delayed - write 1,2,5,6
-	WriteDirect(db, []string{"ABC", "NBC"}, 1)
-	WriteDirect(db, []string{"ABC"}, 2)
-	WriteDirect(db, []string{"ABC", "PBS"}, 5)
-	WriteDirect(db, []string{"ABC", "PBS"}, 6)
+	// Simulate seq 3 + 4 being delayed - process 1,2,5,6
+	cache.processEntry(testLogEntryForChannels(1, []string{"ABC", "NBC"}))
+	cache.processEntry(testLogEntryForChannels(2, []string{"ABC"}))
+	cache.processEntry(testLogEntryForChannels(5, []string{"ABC", "PBS"}))
+	cache.processEntry(testLogEntryForChannels(6, []string{"ABC", "PBS"}))
+	assert.Equal(t, uint64(3), cache.getNextSequence())
 
 	// wait for InsertPendingEntries to fire, move 3 and 4 to skipped and get seqs 5 + 6
-	require.NoError(t, db.changeCache.waitForSequence(context.TODO(), 6, base.DefaultWaitForSequence))
+	require.NoError(t, cache.waitForSequence(context.TODO(), 6, base.DefaultWaitForSequence))
+	assert.True(t, cache.WasSkipped(3))
+	assert.True(t, cache.WasSkipped(4))
 
+	entries, err := cache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, uint64(5), entries[2].Sequence)
+	assert.Equal(t, uint64(6), entries[3].Sequence)
 }
 
-// Generator for processEntry
-type testProcessEntryFeed struct {
-	nextSeq     uint64
-	channelMaps []channels.ChannelMap
-	sources     []uint64 // Used for non-sequential sequence delivery when numSources > 1
-	fixedTime   time.Time
+// Validates that pending changes whose gap fills naturally record their wait, and that those promoted by skipping
+// forward are counted separately.
+func TestPendingSeqWaitStats(t *testing.T) {
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxWait = 100 * time.Millisecond
+
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &cacheOptions)
+	defer cache.Stop()
+	cacheStats := cache.dbStats.Cache()
+
+	// 3 waits for 2, which arrives before the pending wait expires
+	cache.processEntry(logEntry(1, "doc1", "1-a", []string{"ABC"}))
+	delayed := logEntry(3, "doc3", "1-a", []string{"ABC"})
+	delayed.TimeReceived = time.Now().Add(-20 * time.Millisecond)
+	cache.processEntry(delayed)
+	cache.processEntry(logEntry(2, "doc2", "1-a", []string{"ABC"}))
+	assert.Equal(t, uint64(4), cache.getNextSequence())
+	assert.Equal(t, uint64(1), cacheStats.PendingSeqWait.Count())
+	snapshot := cacheStats.PendingSeqWait.Snapshot()
+	assert.GreaterOrEqual(t, snapshot.Sum, int64(20))
+	assert.Equal(t, int64(0), cacheStats.PendingSeqSkippedForwardCount.Value())
+
+	// 4 and 5 never arrive - 6 and 7 are promoted once the pending wait expires
+	cache.processEntry(logEntry(6, "doc6", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(7, "doc7", "1-a", []string{"ABC"}))
+	require.NoError(t, cache.waitForSequence(context.TODO(), 7, base.DefaultWaitForSequence))
+	assert.Equal(t, int64(2), cacheStats.PendingSeqSkippedForwardCount.Value())
+	assert.Equal(t, uint64(1), cacheStats.PendingSeqWait.Count())
+	assert.Equal(t, int64(2), cacheStats.NumSkippedSeqs.Value())
 }
 
-func NewTestProcessEntryFeed(numChannels int, numSources int) *testProcessEntryFeed {
+// Validates that receivedSeqs only holds pending sequences, so doesn't grow with the number of sequences processed,
+// and that duplicates are detected both while their sequence is pending and after it's been cached.
+func TestReceivedSeqsBounded(t *testing.T) {
 
-	feed := &testProcessEntryFeed{
-		fixedTime: time.Now(),
-		sources:   make([]uint64, numSources),
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxWait = time.Hour
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &cacheOptions)
+	defer cache.Stop()
+
+	// Each pair arrives out of order, so is pending until the earlier sequence arrives
+	for seq := uint64(1); seq < 1000; seq += 2 {
+		cache.processEntry(logEntry(seq+1, fmt.Sprintf("doc%d", seq+1), "1-a", []string{"ABC"}))
+		assert.Len(t, cache.receivedSeqs, 1)
+		cache.processEntry(logEntry(seq, fmt.Sprintf("doc%d", seq), "1-a", []string{"ABC"}))
+		assert.Len(t, cache.receivedSeqs, 0)
 	}
+	assert.Equal(t, uint64(1001), cache.getNextSequence())
+
+	// Duplicates of pending sequences
+	cache.processEntry(logEntry(1002, "doc1002", "1-a", []string{"ABC"}))
+	assert.Nil(t, cache.processEntry(logEntry(1002, "doc1002", "1-a", []string{"ABC"})))
+	assert.Len(t, cache.pendingLogs, 1)
+	assert.Len(t, cache.receivedSeqs, 1)
+
+	// Duplicates of cached sequences, recently and long since cached
+	assert.Nil(t, cache.processEntry(logEntry(1000, "doc1000", "1-a", []string{"ABC"})))
+	assert.Nil(t, cache.processEntry(logEntry(1, "doc1", "1-a", []string{"ABC"})))
+	assert.Len(t, cache.receivedSeqs, 1)
+
+	cache.processEntry(logEntry(1001, "doc1001", "1-a", []string{"ABC"}))
+	assert.Equal(t, uint64(1003), cache.getNextSequence())
+	assert.Len(t, cache.receivedSeqs, 0)
+	AssertCacheInvariants(t, cache)
+}
 
-	feed.channelMaps = make([]channels.ChannelMap, numChannels)
-	for i := 0; i < numChannels; i++ {
-		channelName := fmt.Sprintf("channel_%d", i)
-		feed.channelMaps[i] = channels.ChannelMap{
-			channelName: nil,
-		}
+// Validates that a cache bypassing sequence buffering caches changes as they arrive, caching changes for sequences it
+// has already passed as late sequences, and ignoring duplicates.
+func TestBypassSequenceBuffering(t *testing.T) {
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.BypassSequenceBuffering = true
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &cacheOptions)
+	defer cache.Stop()
+	cache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("ABC"))
+
+	// 3 and 5 are cached without waiting for 2 and 4
+	cache.processEntry(logEntry(1, "doc1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(3, "doc3", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(5, "doc5", "1-a", []string{"ABC"}))
+	assert.Equal(t, uint64(6), cache.getNextSequence())
+	assert.Equal(t, 0, len(cache.pendingLogs))
+	assert.Equal(t, int64(2), cache.unbufferedGaps.getNumSequences())
+	assert.True(t, cache.unbufferedGaps.Contains(2))
+	assert.True(t, cache.unbufferedGaps.Contains(4))
+
+	// Late changes are cached, and duplicates ignored
+	changedChannels := cache.processEntry(logEntry(4, "doc4", "1-a", []string{"ABC"}))
+	assert.True(t, changedChannels.Contains("ABC"))
+	assert.Nil(t, cache.processEntry(logEntry(4, "doc4", "1-a", []string{"ABC"})))
+	assert.Nil(t, cache.processEntry(logEntry(3, "doc3", "1-a", []string{"ABC"})))
+	cache.processEntry(logEntry(2, "doc2", "1-a", []string{"ABC"}))
+	assert.Equal(t, int64(0), cache.unbufferedGaps.getNumSequences())
+	assert.Equal(t, int64(2), cache.dbStats.Cache().UnbufferedLateSeqCount.Value())
+
+	// Nothing is skipped, so the stable sequence is the last sequence received
+	assert.Equal(t, uint64(0), cache.getOldestSkippedSequence())
+	assert.Equal(t, int64(0), cache.dbStats.Cache().NumSkippedSeqs.Value())
+	assert.Equal(t, uint64(5), cache.LastSequence())
+
+	entries, err := cache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	for i, entry := range entries {
+		assert.Equal(t, uint64(i+1), entry.Sequence)
+		assert.Equal(t, entry.Sequence == 2 || entry.Sequence == 4, entry.OutOfOrder)
+	}
+	AssertCacheInvariants(t, cache)
+
+	// The oldest gaps over the limit are moved to the skipped sequence queue, and are still cached when they arrive
+	defer func(maxGaps int) { MaxUnbufferedGaps = maxGaps }(MaxUnbufferedGaps)
+	MaxUnbufferedGaps = 3
+	cache.processEntry(logEntry(8, "doc8", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(12, "doc12", "1-a", []string{"ABC"}))
+	assert.Equal(t, int64(3), cache.unbufferedGaps.getNumSequences())
+	assert.Equal(t, uint64(6), cache.getOldestSkippedSequence())
+	assert.Equal(t, int64(2), cache.skippedSeqs.getNumSequences())
+	assert.NotNil(t, cache.processEntry(logEntry(6, "doc6", "1-a", []string{"ABC"})))
+	assert.NotNil(t, cache.processEntry(logEntry(9, "doc9", "1-a", []string{"ABC"})))
+	assert.Nil(t, cache.processEntry(logEntry(6, "doc6", "1-a", []string{"ABC"})))
+	assert.Equal(t, uint64(7), cache.getOldestSkippedSequence())
+	assert.Equal(t, int64(2), cache.unbufferedGaps.getNumSequences())
+	AssertCacheInvariants(t, cache)
+
+	// Bypassing buffering is refused when other nodes may allocate sequences
+	for _, options := range []DatabaseContextOptions{
+		{CacheOptions: &cacheOptions, EnableXattr: true},
+		{CacheOptions: &cacheOptions, SGReplicateOptions: SGReplicateOptions{Enabled: true}},
+	} {
+		assert.Error(t, validateSequenceBufferingBypass(options))
 	}
+	assert.NoError(t, validateSequenceBufferingBypass(DatabaseContextOptions{CacheOptions: &cacheOptions}))
+}
 
-	feed.reset()
-	return feed
+// Validates that gaps that don't arrive within the pending wait are moved to the skipped sequence queue, and are cached
+// when found by CleanSkippedSequenceQueue, so that no sequence is lost when bypassing sequence buffering.
+func TestBypassSequenceBufferingSkipsExpiredGaps(t *testing.T) {
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.BypassSequenceBuffering = true
+	cacheOptions.CachePendingSeqMaxWait = 20 * time.Millisecond
+	cacheOptions.CacheSkippedSeqMaxWait = time.Hour
+	store := newTestCacheBackingStore()
+	cache := newTestChangeCache(t, store, &cacheOptions)
+	defer cache.Stop()
+	cache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("ABC"))
+
+	// The feed misses 2, which is found by query once skipped
+	store.addDoc(2, []string{"ABC"})
+	cache.processEntry(logEntry(1, "doc1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(3, "doc3", "1-a", []string{"ABC"}))
+	require.Eventually(t, func() bool {
+		return cache.WasSkipped(2)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(0), cache.unbufferedGaps.getNumSequences())
+	assert.Equal(t, int64(1), cache.dbStats.Cache().NumSkippedSeqs.Value())
+
+	cache.cleanSkippedLock.Lock()
+	cache._cleanSkippedSequences(context.TODO(), cache.skippedSeqs.getOldestSequences(1))
+	cache.cleanSkippedLock.Unlock()
+	assert.False(t, cache.WasSkipped(2))
+	entries, err := cache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, uint64(2), entries[1].Sequence)
+	assert.True(t, entries[1].Skipped)
+}
+
+// Validates that a cache bypassing sequence buffering resumes buffering when it receives a sequence this node didn't
+// allocate, with its gaps moved to the skipped sequence queue, and that the sequence allocator reports sequences
+// allocated by another node.
+func TestBypassSequenceBufferingOtherWriter(t *testing.T) {
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.BypassSequenceBuffering = true
+	cacheOptions.CachePendingSeqMaxWait = time.Hour
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &cacheOptions)
+	defer cache.Stop()
+	allocatedMax := uint64(5)
+	cache.isAllocated = func(sequence uint64) bool { return sequence <= allocatedMax }
+
+	cache.processEntry(logEntry(1, "doc1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(3, "doc3", "1-a", []string{"ABC"}))
+	assert.True(t, cache.GetOptions().BypassSequenceBuffering)
+
+	// 7 wasn't allocated by this node, so is buffered until 4-6 arrive, and 2 is now skipped
+	assert.Nil(t, cache.processEntry(logEntry(7, "doc7", "1-a", []string{"ABC"})))
+	assert.False(t, cache.GetOptions().BypassSequenceBuffering)
+	assert.Equal(t, 1, len(cache.pendingLogs))
+	assert.True(t, cache.WasSkipped(2))
+	assert.Equal(t, int64(0), cache.unbufferedGaps.getNumSequences())
+	assert.Equal(t, uint64(4), cache.getNextSequence())
+
+	cache.processEntry(logEntry(2, "doc2", "1-a", []string{"ABC"}))
+	for seq := uint64(4); seq <= 6; seq++ {
+		cache.processEntry(logEntry(seq, fmt.Sprintf("doc%d", seq), "1-a", []string{"ABC"}))
+	}
+	assert.Equal(t, uint64(8), cache.getNextSequence())
+	assert.Equal(t, uint64(0), cache.getOldestSkippedSequence())
+	AssertCacheInvariants(t, cache)
+
+	// The allocator reports an incr of the sequence counter by another node between its reservations
+	testBucket := base.GetTestBucket(t)
+	defer testBucket.Close()
+	allocator, err := newSequenceAllocator(testBucket, base.NewSyncGatewayStats().NewDBStats("", false, false, false).Database())
+	require.NoError(t, err)
+	defer allocator.Stop()
+	otherWriters := 0
+	allocator.onOtherWriter = func() { otherWriters++ }
+
+	sequence, err := allocator.nextSequence()
+	require.NoError(t, err)
+	assert.True(t, allocator.isAllocated(sequence))
+	assert.False(t, allocator.isAllocated(sequence+1))
+	for i := 0; i < 20; i++ {
+		_, err = allocator.nextSequence()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 0, otherWriters)
+
+	_, err = testBucket.Incr(base.SyncSeqKey, 1, 1, 0)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		_, err = allocator.nextSequence()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, otherWriters)
+}
+
+// Validates that continuous changes feeds deliver every sequence when sequence buffering is bypassed, including
+// sequences that arrive after the feed has passed them.
+func TestBypassSequenceBufferingContinuousChanges(t *testing.T) {
+
+	if base.TestUseXattrs() {
+		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
+	}
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache, base.KeyChanges)()
+
+	cacheOptions := shortWaitCache()
+	cacheOptions.BypassSequenceBuffering = true
+	db := setupTestDBWithCacheOptions(t, cacheOptions)
+	defer db.Close()
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+
+	authenticator := db.Authenticator()
+	user, err := authenticator.NewUser("naomi", "letmein", channels.SetOf(t, "ABC", "PBS"))
+	require.NoError(t, err)
+	require.NoError(t, authenticator.Save(user))
+	db.user, err = authenticator.GetUser("naomi")
+	require.NoError(t, err)
+
+	// WriteDirect doesn't allocate the sequences it writes, so they'd be taken for another writer's
+	db.changeCache.lock.Lock()
+	db.changeCache.isAllocated = nil
+	db.changeCache.lock.Unlock()
+
+	// 3 and 4 are delayed
+	WriteDirect(db, []string{"ABC"}, 1)
+	WriteDirect(db, []string{"ABC"}, 2)
+	WriteDirect(db, []string{"PBS"}, 5)
+	require.NoError(t, db.changeCache.waitForSequence(context.TODO(), 5, base.DefaultWaitForSequence))
+
+	options := ChangesOptions{Since: SequenceID{Seq: 0}, Terminator: make(chan bool), Continuous: true, Wait: true}
+	defer close(options.Terminator)
+	feed, err := db.MultiChangesFeed(base.SetOf("*"), options)
+	require.NoError(t, err)
+
+	expectedDocs := base.SetOf("doc-1", "doc-2", "doc-3", "doc-4", "doc-5", "doc-6")
+	receivedDocs := base.Set{}
+	readUntil := func(count int) {
+		for len(receivedDocs) < count {
+			entry, err := readNextFromFeed(feed, 5*time.Second)
+			require.NoError(t, err)
+			require.NotNil(t, entry, "Feed ended after %d docs", len(receivedDocs))
+			if expectedDocs.Contains(entry.ID) {
+				assert.Equal(t, uint64(0), entry.Seq.LowSeq, "Unexpected low sequence for %s", entry.Seq)
+				receivedDocs.Add(entry.ID)
+			}
+		}
+	}
+	readUntil(3)
+
+	// The delayed sequences arrive after the feed has passed them, around a later sequence
+	WriteDirect(db, []string{"ABC"}, 4)
+	WriteDirect(db, []string{"PBS"}, 6)
+	WriteDirect(db, []string{"ABC", "PBS"}, 3)
+	readUntil(6)
+	assert.Equal(t, expectedDocs, receivedDocs)
+	assert.Equal(t, int64(2), db.DbStats.Cache().UnbufferedLateSeqCount.Value())
+	assert.Equal(t, int64(0), db.DbStats.Cache().NumSkippedSeqs.Value())
+}
+
+// Validates that CleanSkippedSequenceQueue caches skipped sequences found by query, and abandons the rest
+func TestCleanSkippedSequenceQueue(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelDebug, base.KeyCache)()
+
+	originalBatchSize := SkippedSeqCleanViewBatch
+	SkippedSeqCleanViewBatch = 4
+	defer func() {
+		SkippedSeqCleanViewBatch = originalBatchSize
+	}()
+
+	// Sequences 3, 7, 10, 13 and 14 exist, but are missed by the feed
+	store := newTestCacheBackingStore()
+	for _, sequence := range []uint64{1, 2, 3, 7, 10, 13, 14, 15} {
+		store.addDoc(sequence, []string{"ABC"})
+	}
+
+	// Skip immediately when a sequence arrives out of order
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxNum = 0
+	cache := newTestChangeCache(t, store, &cacheOptions)
+	defer cache.Stop()
+
+	cache.processEntry(logEntry(1, "doc-1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(2, "doc-2", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(15, "doc-15", "1-a", []string{"ABC"}))
+	for sequence := uint64(3); sequence < 15; sequence++ {
+		require.True(t, cache.WasSkipped(sequence), "Expected sequence %d to be skipped", sequence)
+	}
+
+	// Back date skipped entries by 2 hours to trigger retrieval during Clean call
+	cache.skippedSeqs.lock.Lock()
+	for _, skippedRange := range cache.skippedSeqs.ranges {
+		skippedRange.timeAdded = time.Now().Add(-2 * time.Hour)
+	}
+	cache.skippedSeqs.lock.Unlock()
+
+	require.NoError(t, cache.CleanSkippedSequenceQueue(context.TODO()))
+
+	entries, err := cache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 2}})
+	require.NoError(t, err)
+	var docIDs []string
+	for _, entry := range entries {
+		docIDs = append(docIDs, entry.DocID)
+	}
+	assert.Equal(t, []string{"doc-3", "doc-7", "doc-10", "doc-13", "doc-14", "doc-15"}, docIDs)
+	assert.Equal(t, int64(0), cache.skippedSeqs.getNumSequences())
+	assert.Equal(t, int64(7), cache.dbStats.Cache().AbandonedSeqs.Value())
+}
+
+// Validates that when the skipped sequence queue grows past CacheSkippedSeqMaxNum, the oldest skipped sequences are
+// cached when found by query, and otherwise abandoned, without waiting for the skipped sequence max wait.
+func TestSkippedSequenceQueueMaxNum(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelDebug, base.KeyCache)()
+
+	// Clean the excess in pages of 3 sequences
+	defer func(pageSize int) { SkippedSeqIterationPageSize = pageSize }(SkippedSeqIterationPageSize)
+	SkippedSeqIterationPageSize = 3
+
+	// Sequences 3, 7 and 10 exist, but are missed by the feed
+	store := newTestCacheBackingStore()
+	for _, sequence := range []uint64{1, 2, 3, 7, 10, 15} {
+		store.addDoc(sequence, []string{"ABC"})
+	}
+
+	// Skip immediately when a sequence arrives out of order, and allow up to 5 skipped sequences
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxNum = 0
+	cacheOptions.CacheSkippedSeqMaxNum = 5
+	cache := newTestChangeCache(t, store, &cacheOptions)
+	defer cache.Stop()
+
+	cache.processEntry(logEntry(1, "doc-1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(2, "doc-2", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(15, "doc-15", "1-a", []string{"ABC"}))
+
+	// Sequences 3-14 are skipped, so the oldest 7 (3-9) are cleaned over 3 pages - 3 and 7 are found, the rest abandoned
+	cacheStats := cache.dbStats.Cache()
+	_, ok := base.WaitForStat(cacheStats.AbandonedSeqs.Value, 5)
+	require.True(t, ok)
+	for sequence := uint64(3); sequence < 10; sequence++ {
+		assert.False(t, cache.WasSkipped(sequence), "Expected sequence %d to no longer be skipped", sequence)
+	}
+	for sequence := uint64(10); sequence < 15; sequence++ {
+		assert.True(t, cache.WasSkipped(sequence), "Expected sequence %d to remain skipped", sequence)
+	}
+	assert.Equal(t, int64(5), cache.skippedSeqs.getNumSequences())
+
+	entries, err := cache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 2}})
+	require.NoError(t, err)
+	var docIDs []string
+	for _, entry := range entries {
+		docIDs = append(docIDs, entry.DocID)
+	}
+	assert.Equal(t, []string{"doc-3", "doc-7", "doc-15"}, docIDs)
+
+	// Skipped sequences within the limit aren't cleaned early
+	cache.cleanSkippedSequencesOverLimit(context.TODO())
+	assert.Equal(t, int64(5), cache.skippedSeqs.getNumSequences())
+	assert.Equal(t, int64(5), cacheStats.AbandonedSeqs.Value())
+}
+
+// unavailableSequenceQueryStore is a testCacheBackingStore whose sequence query fails while unavailable is set.
+type unavailableSequenceQueryStore struct {
+	*testCacheBackingStore
+	unavailable base.AtomicBool
+}
+
+func (s *unavailableSequenceQueryStore) getChangesForSequences(ctx context.Context, sequences []uint64) (LogEntries, error) {
+	if s.unavailable.IsTrue() {
+		return nil, errors.New("query service unavailable")
+	}
+	return s.testCacheBackingStore.getChangesForSequences(ctx, sequences)
+}
+
+// Validates that skipped sequences are left in the queue when the clean query fails, rather than abandoned, and are
+// found or abandoned by the next clean once the query succeeds.
+func TestCleanSkippedSequenceQueueQueryError(t *testing.T) {
+
+	originalBatchSize := SkippedSeqCleanViewBatch
+	SkippedSeqCleanViewBatch = 2
+	defer func() {
+		SkippedSeqCleanViewBatch = originalBatchSize
+	}()
+
+	store := &unavailableSequenceQueryStore{testCacheBackingStore: newTestCacheBackingStore()}
+	store.unavailable.Set(true)
+	for _, sequence := range []uint64{1, 3, 5} {
+		store.addDoc(sequence, []string{"ABC"})
+	}
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxNum = 0
+	cache := newTestChangeCache(t, store, &cacheOptions)
+	defer cache.Stop()
+
+	cache.processEntry(logEntry(1, "doc-1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(5, "doc-5", "1-a", []string{"ABC"}))
+	require.Equal(t, int64(3), cache.skippedSeqs.getNumSequences())
+
+	backdateSkipped := func() {
+		cache.skippedSeqs.lock.Lock()
+		for _, skippedRange := range cache.skippedSeqs.ranges {
+			skippedRange.timeAdded = time.Now().Add(-2 * time.Hour)
+		}
+		cache.skippedSeqs.lock.Unlock()
+	}
+
+	// Every batch fails - nothing is abandoned
+	backdateSkipped()
+	require.NoError(t, cache.CleanSkippedSequenceQueue(context.TODO()))
+	assert.Equal(t, int64(3), cache.skippedSeqs.getNumSequences())
+	assert.Equal(t, int64(0), cache.dbStats.Cache().AbandonedSeqs.Value())
+
+	// Once the query succeeds, the existing sequence is cached and the others abandoned
+	store.unavailable.Set(false)
+	require.NoError(t, cache.CleanSkippedSequenceQueue(context.TODO()))
+	assert.Equal(t, int64(0), cache.skippedSeqs.getNumSequences())
+	assert.Equal(t, int64(2), cache.dbStats.Cache().AbandonedSeqs.Value())
+}
+
+// countingChannelCache is a ChannelCache that counts the number of times each sequence is added to it.
+type countingChannelCache struct {
+	ChannelCache
+	added map[uint64]int
+	lock  sync.Mutex
+}
+
+func (c *countingChannelCache) AddToCache(change *LogEntry) []string {
+	c.lock.Lock()
+	c.added[change.Sequence]++
+	c.lock.Unlock()
+	return c.ChannelCache.AddToCache(change)
+}
+
+func TestSkippedSequenceCheckAndRemove(t *testing.T) {
+	skipList := NewSkippedSequenceList()
+	require.NoError(t, skipList.Push(&SkippedSequence{seq: 4, timeAdded: time.Now()}))
+	require.NoError(t, skipList.Push(&SkippedSequence{seq: 5, timeAdded: time.Now()}))
+	assert.True(t, skipList.CheckAndRemove(4))
+	assert.False(t, skipList.CheckAndRemove(4))
+	assert.False(t, skipList.CheckAndRemove(6))
+	assert.True(t, verifySkippedSequences(skipList, []uint64{5}))
+}
+
+// Validates that a skipped sequence received while CleanSkippedSequenceQueue runs is cached exactly once, and is
+// either recovered or abandoned - never both.
+func TestProcessSkippedSequenceDuringClean(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelWarn, base.KeyCache)()
+
+	// Sequences 2-99 are skipped.  The even sequences are found by the clean query, and the odd ones abandoned unless
+	// they're received first
+	store := newTestCacheBackingStore()
+	for sequence := uint64(1); sequence <= 100; sequence++ {
+		if sequence%2 == 0 || sequence == 1 {
+			store.addDoc(sequence, []string{"ABC"})
+		}
+	}
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxNum = 0
+	cache := newTestChangeCache(t, store, &cacheOptions)
+	defer cache.Stop()
+
+	countingCache := &countingChannelCache{added: make(map[uint64]int)}
+	cache.lock.Lock()
+	countingCache.ChannelCache = cache.channelCache
+	cache.channelCache = countingCache
+	cache.lock.Unlock()
+	cache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("ABC"))
+
+	cache.processEntry(logEntry(1, "doc-1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(100, "doc-100", "1-a", []string{"ABC"}))
+	require.Equal(t, int64(98), cache.skippedSeqs.getNumSequences())
+
+	cache.skippedSeqs.lock.Lock()
+	for _, skippedRange := range cache.skippedSeqs.ranges {
+		skippedRange.timeAdded = time.Now().Add(-2 * time.Hour)
+	}
+	cache.skippedSeqs.lock.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, cache.CleanSkippedSequenceQueue(context.TODO()))
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for repeat := 0; repeat < 3; repeat++ {
+				for sequence := uint64(2); sequence < 100; sequence++ {
+					cache.processEntry(logEntry(sequence, fmt.Sprintf("doc-%d", sequence), "1-a", []string{"ABC"}))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// An odd sequence abandoned before it's received is dropped as a duplicate, so isn't cached
+	var cachedCount, droppedCount int
+	for sequence := uint64(1); sequence <= 100; sequence++ {
+		switch added := countingCache.added[sequence]; {
+		case added == 1:
+			cachedCount++
+		case added == 0 && sequence%2 == 1:
+			droppedCount++
+		default:
+			assert.Fail(t, "Unexpected caching", "Sequence %d cached %d times", sequence, added)
+		}
+	}
+	_, entries, ok := cache.getChannelCache().getCachedEntries(NewDefaultChannelID("ABC"))
+	require.True(t, ok)
+	assert.Len(t, entries, cachedCount)
+
+	assert.Equal(t, int64(0), cache.skippedSeqs.getNumSequences())
+	cache.lock.RLock()
+	recovered := cache.pendingWaitWindow.recovered
+	cache.lock.RUnlock()
+	abandoned := cache.dbStats.Cache().AbandonedSeqs.Value()
+	assert.Equal(t, uint64(98), recovered+uint64(abandoned))
+	assert.True(t, abandoned <= 49, "Only sequences missing from the store can be abandoned, got %d", abandoned)
+	assert.True(t, int64(droppedCount) <= abandoned)
+}
+
+// Validates that the estimated memory used by pending sequences is tracked as they're pushed and popped, and that
+// exceeding CachePendingSeqMaxBytes skips the missing sequence when the count limit isn't reached.
+func TestPendingSeqMaxBytes(t *testing.T) {
+
+	channelNames := make([]string, 100)
+	for i := range channelNames {
+		channelNames[i] = fmt.Sprintf("channel-%d", i)
+	}
+	entryBytes := estimatedPendingEntryBytes(logEntry(3, "doc3", "1-a", channelNames))
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxWait = time.Hour
+	cacheOptions.CachePendingSeqMaxBytes = entryBytes*2 + entryBytes/2
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &cacheOptions)
+	defer cache.Stop()
+	cacheStats := cache.dbStats.Cache()
+
+	// Two pending entries are within the limit, while 2 is missing
+	cache.processEntry(logEntry(1, "doc1", "1-a", channelNames))
+	cache.processEntry(logEntry(3, "doc3", "1-a", channelNames))
+	cache.processEntry(logEntry(4, "doc4", "1-a", channelNames))
+	assert.Equal(t, uint64(2), cache.getNextSequence())
+	assert.Equal(t, int64(2), cacheStats.PendingSeqLen.Value())
+	assert.Equal(t, entryBytes*2, cacheStats.PendingSeqBytes.Value())
+	assert.Equal(t, int64(0), cacheStats.NumSkippedSeqs.Value())
+
+	// A third exceeds it, well short of the count limit - 2 is skipped and the pending entries cached
+	cache.processEntry(logEntry(5, "doc5", "1-a", channelNames))
+	require.Less(t, 3, cacheOptions.CachePendingSeqMaxNum)
+	assert.Equal(t, uint64(6), cache.getNextSequence())
+	assert.Equal(t, uint64(2), cache.getOldestSkippedSequence())
+	assert.Equal(t, int64(1), cacheStats.NumSkippedSeqs.Value())
+	assert.Equal(t, int64(0), cacheStats.PendingSeqLen.Value())
+	assert.Equal(t, int64(0), cacheStats.PendingSeqBytes.Value())
+
+	// Entries in few channels are small enough that the same number are held pending
+	cache.processEntry(logEntry(7, "doc7", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(8, "doc8", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(9, "doc9", "1-a", []string{"ABC"}))
+	assert.Equal(t, uint64(6), cache.getNextSequence())
+	assert.Equal(t, int64(3), cacheStats.PendingSeqLen.Value())
+	assert.Equal(t, 3*estimatedPendingEntryBytes(logEntry(7, "doc7", "1-a", []string{"ABC"})), cacheStats.PendingSeqBytes.Value())
+
+	// Once the gap is filled, the popped entries are no longer accounted for
+	cache.processEntry(logEntry(6, "doc6", "1-a", []string{"ABC"}))
+	assert.Equal(t, uint64(10), cache.getNextSequence())
+	assert.Equal(t, int64(0), cacheStats.PendingSeqBytes.Value())
+	assert.Equal(t, int64(1), cacheStats.NumSkippedSeqs.Value())
+}
+
+// Validates that an adaptive pending wait follows the delays of sequences arriving out of order within its bounds, as
+// ordering shifts from tight to loose, and that a manual override of the pending wait stops it adapting.
+func TestAdaptivePendingSeqMaxWait(t *testing.T) {
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.AdaptivePendingSeqMaxWait = true
+	cacheOptions.CachePendingSeqMinWait = 50 * time.Millisecond
+	cacheOptions.CachePendingSeqMaxWait = 5 * time.Second
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &cacheOptions)
+	defer cache.Stop()
+	cacheStats := cache.dbStats.Cache()
+	assert.Equal(t, int64(5000), cacheStats.PendingSeqMaxWait.Value())
+
+	observe := func(count int, delay int64) {
+		for i := 0; i < count; i++ {
+			cacheStats.PendingSeqWait.Observe(delay)
+		}
+	}
+	adapt := func() time.Duration {
+		require.NoError(t, cache.adaptPendingSeqMaxWait(context.TODO()))
+		cache.lock.RLock()
+		defer cache.lock.RUnlock()
+		assert.Equal(t, int64(cache.pendingSeqMaxWait/time.Millisecond), cacheStats.PendingSeqMaxWait.Value())
+		return cache.pendingSeqMaxWait
+	}
+
+	// Too few observations to adapt
+	observe(10, 1)
+	assert.Equal(t, 5*time.Second, adapt())
+
+	// Tight ordering - bounded by the min wait
+	observe(2000, 8)
+	assert.Equal(t, 50*time.Millisecond, adapt())
+
+	// Loose ordering - sequences delayed beyond the pending wait are skipped, then received
+	cache.processEntry(logEntry(1, "doc1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(3, "doc3", "1-a", []string{"ABC"}))
+	cache.lock.Lock()
+	maxNum := cache.options.CachePendingSeqMaxNum
+	cache.options.CachePendingSeqMaxNum = 0
+	cache._addPendingLogs()
+	cache.options.CachePendingSeqMaxNum = maxNum
+	cache.lock.Unlock()
+	cache.processEntry(logEntry(2, "doc2", "1-a", []string{"ABC"}))
+	cache.lock.Lock()
+	assert.Equal(t, uint64(1), cache.pendingWaitWindow.recovered)
+	cache.pendingWaitWindow.recovered += 10
+	cache.lock.Unlock()
+	observe(1989, 40)
+	assert.Equal(t, 100*time.Millisecond, adapt())
+
+	// Once the pending wait covers the delays, it's set from them - bounded by the max wait
+	observe(2000, 200)
+	assert.Equal(t, 500*time.Millisecond, adapt())
+	observe(2000, 40000)
+	assert.Equal(t, 5*time.Second, adapt())
+
+	// A manual override wins
+	maxWait := time.Second
+	require.NoError(t, cache.UpdateOptions(CacheOptionsUpdate{CachePendingSeqMaxWait: &maxWait}))
+	observe(2000, 8)
+	assert.Equal(t, time.Second, adapt())
+}
+
+// Validates that the pending and skipped sequence gauges are updated as a gap is processed, rather than only on the
+// periodic stats update, and that Clear resets them.
+func TestPendingAndSkippedSequenceStats(t *testing.T) {
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxWait = time.Hour
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &cacheOptions)
+	defer cache.Stop()
+	cacheStats := cache.dbStats.Cache()
+
+	// 3 and 4 are pending until 2 arrives or is skipped
+	cache.processEntry(logEntry(1, "doc1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(3, "doc3", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(4, "doc4", "1-a", []string{"ABC"}))
+	assert.Equal(t, int64(2), cacheStats.PendingSeqLen.Value())
+	assert.Equal(t, int64(0), cacheStats.SkippedSeqLen.Value())
+
+	// Skip 2 once the pending limit is reached
+	cache.lock.Lock()
+	maxNum := cache.options.CachePendingSeqMaxNum
+	cache.options.CachePendingSeqMaxNum = 0
+	cache._addPendingLogs()
+	cache.options.CachePendingSeqMaxNum = maxNum
+	cache.lock.Unlock()
+	assert.Equal(t, int64(0), cacheStats.PendingSeqLen.Value())
+	assert.Equal(t, int64(1), cacheStats.SkippedSeqLen.Value())
+	assert.Equal(t, int64(0), cacheStats.OldestSkippedSeqAge.Value())
+
+	// The oldest skipped age is refreshed as entries are processed
+	cache.skippedSeqs.lock.Lock()
+	cache.skippedSeqs.ranges[0].timeAdded = time.Now().Add(-2 * time.Hour)
+	cache.skippedSeqs.lock.Unlock()
+	cache.processEntry(logEntry(5, "doc5", "1-a", []string{"ABC"}))
+	assert.GreaterOrEqual(t, cacheStats.OldestSkippedSeqAge.Value(), int64(2*time.Hour/time.Second))
+
+	// The store doesn't have 2, so it's abandoned
+	require.NoError(t, cache.CleanSkippedSequenceQueue(context.TODO()))
+	assert.Equal(t, int64(0), cacheStats.SkippedSeqLen.Value())
+	assert.Equal(t, int64(0), cacheStats.OldestSkippedSeqAge.Value())
+	assert.Equal(t, int64(1), cacheStats.AbandonedSeqs.Value())
+
+	// Clear discards pending sequences
+	cache.processEntry(logEntry(7, "doc7", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(8, "doc8", "1-a", []string{"ABC"}))
+	assert.Equal(t, int64(2), cacheStats.PendingSeqLen.Value())
+	require.NoError(t, cache.Clear())
+	assert.Equal(t, int64(0), cacheStats.PendingSeqLen.Value())
+	assert.Len(t, cache.receivedSeqs, 0)
+	AssertCacheInvariants(t, cache)
+}
+
+// Validates that the skipped sequence report lists skipped sequences along with the sequence buffering state, with
+// pending doc IDs tagged as user data, and that it's unavailable once the cache is stopped.
+func TestGetSkippedSequenceReport(t *testing.T) {
+	defer func(redactUserData bool) { base.RedactUserData = redactUserData }(base.RedactUserData)
+	base.RedactUserData = true
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxWait = time.Hour
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &cacheOptions)
+	defer cache.Stop()
+
+	report, err := cache.GetSkippedSequenceReport(DefaultSkippedSeqReportCount)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), report.NextSequence)
+	assert.Equal(t, int64(0), report.NumSkipped)
+	assert.Len(t, report.Skipped, 0)
+	assert.Equal(t, 0, report.PendingLen)
+
+	// 2 and 3 are skipped once the pending limit is reached, leaving 6 and 8 pending
+	cache.processEntry(logEntry(1, "doc1", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(4, "doc4", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(6, "doc6", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(8, "doc8", "1-a", []string{"ABC"}))
+	cache.lock.Lock()
+	maxNum := cache.options.CachePendingSeqMaxNum
+	cache.options.CachePendingSeqMaxNum = 2
+	cache._addPendingLogs()
+	cache.options.CachePendingSeqMaxNum = maxNum
+	cache.lock.Unlock()
+
+	report, err = cache.GetSkippedSequenceReport(DefaultSkippedSeqReportCount)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), report.NextSequence)
+	assert.Len(t, cache.skippedSeqs.ranges, 1)
+	assert.Equal(t, int64(2), cache.dbStats.Cache().NumSkippedSeqs.Value())
+	assert.Equal(t, int64(2), report.NumSkipped)
+	require.Len(t, report.Skipped, 2)
+	for i, skipped := range report.Skipped {
+		assert.Equal(t, uint64(i+2), skipped.Sequence)
+		assert.Equal(t, uint64(4), skipped.PendingSeq)
+		assert.Equal(t, 3, skipped.PendingLen)
+		assert.False(t, skipped.TimeAdded.IsZero())
+	}
+	assert.Equal(t, 2, report.PendingLen)
+	assert.Equal(t, uint64(6), report.PendingLowSeq)
+	assert.Equal(t, uint64(8), report.PendingHighSeq)
+	assert.Equal(t, "[{seq: 6 docid: <ud>doc6</ud> revid: 1-a vbno: 0 type: 0 channels: [ <ud>ABC</ud> ]}, "+
+		"{seq: 8 docid: <ud>doc8</ud> revid: 1-a vbno: 0 type: 0 channels: [ <ud>ABC</ud> ]}]", report.Pending)
+
+	// The skipped sequences listed are limited, but still counted
+	report, err = cache.GetSkippedSequenceReport(1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), report.NumSkipped)
+	require.Len(t, report.Skipped, 1)
+	assert.Equal(t, uint64(2), report.Skipped[0].Sequence)
+
+	cache.Stop()
+	_, err = cache.GetSkippedSequenceReport(DefaultSkippedSeqReportCount)
+	assert.Equal(t, base.ErrDatabaseClosed, err)
+}
+
+// Validates that documents without sync data on the feed are reported in a single summary line, and counted.
+func TestNonMobileDocsCoalescedWarning(t *testing.T) {
+	if base.GlobalTestLoggingSet.IsTrue() {
+		t.Skip("Test does not work when a global test log level is set")
+	}
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache)()
+
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), nil)
+	defer cache.Stop()
+
+	output := base.CaptureConsoleLogOutput(func() {
+		for i := 0; i < 1000; i++ {
+			cache.DocChanged(sgbucket.FeedEvent{
+				Opcode: sgbucket.FeedOpMutation,
+				Key:    []byte(fmt.Sprintf("legacy%d", i)),
+				Value:  []byte(`{"foo": "bar"}`),
+			})
+		}
+		cache.nonMobileReporter.Flush()
+	})
+	assert.Equal(t, 1, strings.Count(output, "Ignored docs without valid sync data"))
+	assert.Contains(t, output, "1000 occurrences")
+	assert.Equal(t, int64(1000), cache.dbStats.Cache().NonMobileIgnoredCount.Value())
+}
+
+// Simulates a feed redelivering mutations from before the cache's initial sequence (e.g. a stale DCP checkpoint), and
+// validates that the discarded mutations are counted.
+func TestDiscardedFeedSeqCount(t *testing.T) {
+
+	dbStats := base.NewSyncGatewayStats().NewDBStats("", false, false, false)
+	cache := &changeCache{}
+	require.NoError(t, cache.init("db", dbStats, &DatabaseContextOptions{}, newTestCacheBackingStore(),
+		channels.NewActiveChannels(dbStats.Cache().NumActiveChannels), nil, nil))
+	require.NoError(t, cache.Start(100))
+	defer cache.Stop()
+
+	for seq := 1; seq <= 150; seq++ {
+		cache.DocChanged(sgbucket.FeedEvent{
+			Opcode:      sgbucket.FeedOpMutation,
+			Synchronous: true,
+			Key:         []byte(fmt.Sprintf("doc-%d", seq)),
+			Value:       []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d]}}`, seq, seq)),
+			DataType:    base.MemcachedDataTypeJSON,
+		})
+	}
+
+	assert.Equal(t, int64(100), dbStats.Cache().DiscardedFeedSeqCount.Value())
+	assert.Equal(t, uint64(150), cache.getNextSequence()-1)
+}
+
+// Interleaves document mutations with principal docs and unused sequence notifications, and validates that the
+// metadata events fill their sequence gaps immediately rather than leaving document entries pending.
+func TestMetadataEventsAdvanceNextSequence(t *testing.T) {
+
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), nil)
+	defer cache.Stop()
+
+	docEvent := func(seq uint64) sgbucket.FeedEvent {
+		return sgbucket.FeedEvent{
+			Opcode:       sgbucket.FeedOpMutation,
+			Synchronous:  true,
+			Key:          []byte(fmt.Sprintf("doc-%d", seq)),
+			Value:        []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d]}}`, seq, seq)),
+			DataType:     base.MemcachedDataTypeJSON,
+			TimeReceived: time.Now(),
+		}
+	}
+	metadataEvent := func(key string, value string) sgbucket.FeedEvent {
+		return sgbucket.FeedEvent{
+			Opcode:       sgbucket.FeedOpMutation,
+			Synchronous:  true,
+			Key:          []byte(key),
+			Value:        []byte(value),
+			DataType:     base.MemcachedDataTypeJSON,
+			TimeReceived: time.Now(),
+		}
+	}
+
+	events := []sgbucket.FeedEvent{
+		docEvent(1),
+		metadataEvent(base.UserPrefix+"alice", `{"name":"alice","sequence":2}`),
+		docEvent(3),
+		metadataEvent(base.UnusedSeqPrefix+"4", ``),
+		docEvent(5),
+		metadataEvent(base.RolePrefix+"admins", `{"name":"admins","sequence":6}`),
+		metadataEvent(base.UnusedSeqRangePrefix+"7:9", ``),
+		docEvent(10),
+	}
+	for _, event := range events {
+		cache.DocChanged(event)
+		cache.lock.RLock()
+		pendingLen := len(cache.pendingLogs)
+		cache.lock.RUnlock()
+		assert.Equal(t, 0, pendingLen, "Unexpected pending entries after %s", event.Key)
+	}
+
+	assert.Equal(t, uint64(11), cache.getNextSequence())
+	assert.Equal(t, int64(4), cache.dbStats.Cache().MetadataEventCount.Value())
+	assert.True(t, cache.dbStats.Cache().MetadataEventTime.Value() > 0)
+}
+
+func TestLogPriorityQueueRedactedString(t *testing.T) {
+	defer func(redactUserData bool) { base.RedactUserData = redactUserData }(base.RedactUserData)
+
+	pending := LogPriorityQueue{
+		{Sequence: 7, DocID: "doc7", RevID: "1-a"},
+		{Sequence: 5, DocID: "doc5", RevID: "1-b"},
+	}
+
+	base.RedactUserData = true
+	assert.Equal(t, "[{seq: 5 docid: <ud>doc5</ud> revid: 1-b vbno: 0 type: 0 channels: [ ]}, "+
+		"{seq: 7 docid: <ud>doc7</ud> revid: 1-a vbno: 0 type: 0 channels: [ ]}]", pending.RedactedString())
+
+	base.RedactUserData = false
+	assert.Equal(t, "[{seq: 5 docid: doc5 revid: 1-b vbno: 0 type: 0 channels: [ ]}, "+
+		"{seq: 7 docid: doc7 revid: 1-a vbno: 0 type: 0 channels: [ ]}]", pending.RedactedString())
+
+	// Ordering of the queue itself is left unchanged
+	assert.Equal(t, uint64(7), pending[0].Sequence)
+}
+
+// Verify that a purge marker written by one node removes the purged doc from another node's channel cache
+func TestPurgeMarkerPropagation(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelDebug, base.KeyCache, base.KeyCRUD)()
+
+	tBucket := base.GetTestBucket(t)
+	db1 := setupTestDBForBucket(t, tBucket)
+	defer db1.Close()
+	db1.ChannelMapper = channels.NewDefaultChannelMapper()
+
+	cacheOptions := DefaultCacheOptions()
+	context2, err := NewDatabaseContext("db2", tBucket.NoCloseClone(), false, DatabaseContextOptions{CacheOptions: &cacheOptions})
+	require.NoError(t, err)
+	defer context2.Close()
+
+	_, doc, err := db1.Put("doc1", Body{"channels": []string{"ABC"}})
+	require.NoError(t, err)
+	require.NoError(t, context2.changeCache.waitForSequence(context.TODO(), doc.Sequence, base.DefaultWaitForSequence))
+
+	// Initialize the channel cache on the second node
+	entries, err := context2.changeCache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Len(t, context2.changeCache.getChannelCache().GetCachedChanges(NewDefaultChannelID("ABC")), 1)
+
+	// Purge on the first node, and write the marker
+	startTime := time.Now()
+	require.NoError(t, db1.Purge("doc1"))
+	db1.changeCache.Remove([]string{"doc1"}, startTime)
+	require.NoError(t, db1.WritePurgeMarker("doc1"))
+
+	// Wait for the marker to be processed by the second node
+	removed := false
+	for i := 0; i < 100; i++ {
+		if len(context2.changeCache.getChannelCache().GetCachedChanges(NewDefaultChannelID("ABC"))) == 0 {
+			removed = true
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.True(t, removed, "Purged doc wasn't removed from second node's cache")
+
+	// The marker itself must not be cached
+	assert.Len(t, context2.changeCache.getChannelCache().GetCachedChanges(NewDefaultChannelID(channels.UserStarChannel)), 0)
+}
+
+// Verifies that feed documents with unparseable sync metadata are surfaced when strict feed parsing is enabled, and
+// dropped quietly otherwise.
+func TestStrictFeedParsing(t *testing.T) {
+
+	defer func(maxFailures int) { MaxCacheParseFailures = maxFailures }(MaxCacheParseFailures)
+	MaxCacheParseFailures = 2
+
+	corruptDocEvent := func(docID string) sgbucket.FeedEvent {
+		return sgbucket.FeedEvent{
+			Synchronous: true,
+			Key:         []byte(docID),
+			Value:       []byte(`{"_sync":{"rev":"1-abc","sequence":"not-a-number"}}`),
+			DataType:    base.MemcachedDataTypeJSON,
+		}
+	}
+
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%t", strict), func(t *testing.T) {
+			cacheOptions := DefaultCacheOptions()
+			db := setupTestDBWithOptions(t, DatabaseContextOptions{
+				CacheOptions:       &cacheOptions,
+				UnsupportedOptions: UnsupportedOptions{StrictFeedParsing: strict},
+			})
+			defer db.Close()
+
+			db.changeCache.DocChanged(corruptDocEvent("corrupt1"))
+
+			failures := db.changeCache.GetParseFailures()
+			if !strict {
+				assert.Len(t, failures, 0)
+				assert.Equal(t, int64(0), db.DbStats.Cache().FeedParseErrorCount.Value())
+				return
+			}
+
+			require.Len(t, failures, 1)
+			assert.Equal(t, "corrupt1", failures[0].DocID)
+			assert.NotEmpty(t, failures[0].Error)
+			assert.Equal(t, int64(1), db.DbStats.Cache().FeedParseErrorCount.Value())
+
+			// The retained list is bounded, dropping the oldest failures first
+			db.changeCache.DocChanged(corruptDocEvent("corrupt2"))
+			db.changeCache.DocChanged(corruptDocEvent("corrupt3"))
+			failures = db.changeCache.GetParseFailures()
+			require.Len(t, failures, 2)
+			assert.Equal(t, "corrupt2", failures[0].DocID)
+			assert.Equal(t, "corrupt3", failures[1].DocID)
+			assert.Equal(t, int64(3), db.DbStats.Cache().FeedParseErrorCount.Value())
+		})
+	}
+}
+
+// Verifies that the deletion feed events of purged tombstones, which retain no sync xattr, are ignored rather than
+// parsed for sync metadata.
+func TestDocChangedIgnoresPurgedTombstone(t *testing.T) {
+
+	cacheOptions := DefaultCacheOptions()
+	db := setupTestDBWithOptions(t, DatabaseContextOptions{
+		CacheOptions:       &cacheOptions,
+		UnsupportedOptions: UnsupportedOptions{StrictFeedParsing: true},
+	})
+	defer db.Close()
+
+	// A purge leaves other system xattrs in place, with an empty body
+	db.changeCache.DocChanged(sgbucket.FeedEvent{
+		Opcode:      sgbucket.FeedOpDeletion,
+		Synchronous: true,
+		Key:         []byte("purged"),
+		Value:       makeFeedBytes("_other", `{"foo":"bar"}`, ""),
+		DataType:    base.MemcachedDataTypeXattr,
+	})
+	assert.Len(t, db.changeCache.GetParseFailures(), 0)
+	assert.Equal(t, int64(0), db.DbStats.Cache().FeedParseErrorCount.Value())
+}
+
+// Generator for processEntry
+type testProcessEntryFeed struct {
+	nextSeq     uint64
+	channelMaps []channels.ChannelMap
+	sources     []uint64 // Used for non-sequential sequence delivery when numSources > 1
+	fixedTime   time.Time
+}
+
+func NewTestProcessEntryFeed(numChannels int, numSources int) *testProcessEntryFeed {
+
+	feed := &testProcessEntryFeed{
+		fixedTime: time.Now(),
+		sources:   make([]uint64, numSources),
+	}
+
+	feed.channelMaps = make([]channels.ChannelMap, numChannels)
+	for i := 0; i < numChannels; i++ {
+		channelName := fmt.Sprintf("channel_%d", i)
+		feed.channelMaps[i] = channels.ChannelMap{
+			channelName: nil,
+		}
+	}
+
+	feed.reset()
+	return feed
 }
 
 // Reset sets nextSeq to 1, and reseeds sources
@@ -1936,39 +3326,64 @@ func (f *testProcessEntryFeed) Next() *LogEntry {
 func BenchmarkProcessEntry(b *testing.B) {
 	defer base.SetUpBenchmarkLogging(base.LevelError, base.KeyCache, base.KeyChanges)()
 	processEntryBenchmarks := []struct {
-		name           string
-		feed           *testProcessEntryFeed
-		warmCacheCount int
+		name            string
+		feed            *testProcessEntryFeed
+		warmCacheCount  int
+		bypassBuffering bool
 	}{
 		{
 			"SingleThread_OrderedFeed_NoActiveChannels",
 			NewTestProcessEntryFeed(100, 1),
 			0,
+			false,
 		},
 		{
 			"SingleThread_OrderedFeed_ActiveChannels",
 			NewTestProcessEntryFeed(100, 1),
 			100,
+			false,
 		},
 		{
 			"SingleThread_OrderedFeed_ManyActiveChannels",
 			NewTestProcessEntryFeed(35000, 1),
 			35000,
+			false,
 		},
 		{
 			"SingleThread_NonOrderedFeed_NoActiveChannels",
 			NewTestProcessEntryFeed(100, 10),
 			0,
+			false,
 		},
 		{
 			"SingleThread_NonOrderedFeed_ActiveChannels",
 			NewTestProcessEntryFeed(100, 10),
 			100,
+			false,
 		},
 		{
 			"SingleThread_NonOrderedFeed_ManyActiveChannels",
 			NewTestProcessEntryFeed(35000, 10),
 			35000,
+			false,
+		},
+		{
+			"SingleThread_OrderedFeed_ActiveChannels_BypassBuffering",
+			NewTestProcessEntryFeed(100, 1),
+			100,
+			true,
+		},
+		{
+			"SingleThread_NonOrderedFeed_ActiveChannels_BypassBuffering",
+			NewTestProcessEntryFeed(100, 10),
+			100,
+			true,
+		},
+		{
+			"SingleThread_NonOrderedFeed_ManyActiveChannels_BypassBuffering",
+			NewTestProcessEntryFeed(35000, 10),
+			35000,
+			true,
 		},
 	}
 
@@ -1978,8 +3393,10 @@ func BenchmarkProcessEntry(b *testing.B) {
 			require.NoError(b, err)
 			defer context.Close()
 
+			cacheOptions := DefaultCacheOptions()
+			cacheOptions.BypassSequenceBuffering = bm.bypassBuffering
 			changeCache := &changeCache{}
-			if err := changeCache.Init(context, nil, nil); err != nil {
+			if err := changeCache.Init(context, nil, &cacheOptions); err != nil {
 				log.Printf("Init failed for changeCache: %v", err)
 				b.Fail()
 			}
@@ -2012,6 +3429,53 @@ func BenchmarkProcessEntry(b *testing.B) {
 	}
 }
 
+// BenchmarkSequenceBufferingThroughput compares the rate at which caches with and without sequence buffering cache the
+// changes received by 16 concurrent feed workers.  Each worker receives the sequences of its own vbuckets in order, so
+// the sequences interleaved across workers arrive out of order, as they do over DCP.
+func BenchmarkSequenceBufferingThroughput(b *testing.B) {
+	defer base.SetUpBenchmarkLogging(base.LevelError, base.KeyCache, base.KeyChanges)()
+	const numWorkers = 16
+	for _, bypassBuffering := range []bool{false, true} {
+		b.Run(fmt.Sprintf("BypassBuffering=%t", bypassBuffering), func(b *testing.B) {
+			cacheOptions := DefaultCacheOptions()
+			cacheOptions.BypassSequenceBuffering = bypassBuffering
+			cache := newTestChangeCache(b, newTestCacheBackingStore(), &cacheOptions)
+			defer cache.Stop()
+
+			channelMaps := make([]channels.ChannelMap, 100)
+			for i := range channelMaps {
+				channelName := fmt.Sprintf("channel_%d", i)
+				channelMaps[i] = channels.ChannelMap{channelName: nil}
+				cache.getChannelCache().getSingleChannelCache(NewDefaultChannelID(channelName))
+			}
+			timeReceived := time.Now()
+
+			var wg sync.WaitGroup
+			wg.Add(numWorkers)
+			b.ResetTimer()
+			for w := 0; w < numWorkers; w++ {
+				go func(worker int) {
+					defer wg.Done()
+					for seq := uint64(worker + 1); seq <= uint64(b.N); seq += numWorkers {
+						cache.processEntry(&LogEntry{
+							Sequence:     seq,
+							DocID:        fmt.Sprintf("doc_%d", seq),
+							RevID:        "1-abcdefabcdefabcdef",
+							Channels:     channelMaps[seq%uint64(len(channelMaps))],
+							TimeReceived: timeReceived,
+						})
+					}
+				}(w)
+			}
+			wg.Wait()
+			b.StopTimer()
+
+			// Every sequence is cached in both modes
+			require.NoError(b, cache.waitForSequence(context.TODO(), uint64(b.N), base.DefaultWaitForSequence))
+		})
+	}
+}
+
 type testDocChangedFeed struct {
 	nextSeq      uint64
 	channelNames []string
@@ -2240,3 +3704,955 @@ func BenchmarkDocChanged(b *testing.B) {
 		})
 	}
 }
+
+// newStageSamplingTestCache returns a started change cache sampling one in every sampleRate feed events for stage
+// timing, without a bucket.
+func newStageSamplingTestCache(tb testing.TB, sampleRate int) (*changeCache, *base.CacheStats) {
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.FeedStageSampleRate = sampleRate
+	cache := newTestChangeCache(tb, newTestCacheBackingStore(), &cacheOptions)
+	return cache, cache.dbStats.Cache()
+}
+
+func TestFeedStageSampler(t *testing.T) {
+	var nilSampler *feedStageSampler
+	assert.False(t, nilSampler.sample())
+
+	disabled := newFeedStageSampler(0)
+	everyThird := newFeedStageSampler(3)
+	var sampled []int
+	for i := 1; i <= 9; i++ {
+		assert.False(t, disabled.sample())
+		if everyThird.sample() {
+			sampled = append(sampled, i)
+		}
+	}
+	assert.Equal(t, []int{3, 6, 9}, sampled)
+}
+
+// Validates that when every feed event is sampled, each processing stage records a plausible time for each event.
+func TestFeedStageTiming(t *testing.T) {
+
+	cache, cacheStats := newStageSamplingTestCache(t, 1)
+	defer cache.Stop()
+
+	const numEvents = 10
+	feed := NewTestDocChangedFeed(10, 1)
+	for i := 0; i < numEvents; i++ {
+		cache.DocChanged(feed.Next())
+	}
+	require.NoError(t, cache.waitForSequence(context.TODO(), numEvents, base.DefaultWaitForSequence))
+
+	stages := map[string]*base.SgwHistogramStat{
+		"unmarshal":    cacheStats.FeedStageUnmarshalTime,
+		"import check": cacheStats.FeedStageImportCheckTime,
+		"lock wait":    cacheStats.FeedStageLockWaitTime,
+		"lock held":    cacheStats.FeedStageLockHeldTime,
+		"notify":       cacheStats.FeedStageNotifyTime,
+	}
+	for name, stat := range stages {
+		snapshot := stat.Snapshot()
+		assert.Equal(t, uint64(numEvents), snapshot.Count, "Samples of %s stage", name)
+		assert.Greater(t, snapshot.Sum, int64(0), "Time in %s stage", name)
+		assert.Less(t, snapshot.Sum, int64(numEvents*time.Second), "Time in %s stage", name)
+	}
+
+	// Unsampled events aren't timed
+	unsampledCache, unsampledStats := newStageSamplingTestCache(t, 0)
+	defer unsampledCache.Stop()
+	feed.reset()
+	for i := 0; i < numEvents; i++ {
+		unsampledCache.DocChanged(feed.Next())
+	}
+	require.NoError(t, unsampledCache.waitForSequence(context.TODO(), numEvents, base.DefaultWaitForSequence))
+	assert.Equal(t, uint64(0), unsampledStats.FeedStageUnmarshalTime.Count())
+	assert.Equal(t, uint64(0), unsampledStats.FeedStageLockWaitTime.Count())
+}
+
+// Compares the cost of processing feed events with stage timing disabled, and at the default sample rate.
+func BenchmarkDocChangedStageSampling(b *testing.B) {
+	defer base.SetUpBenchmarkLogging(base.LevelError, base.KeyCache, base.KeyChanges)()
+	for _, sampleRate := range []int{0, DefaultFeedStageSampleRate} {
+		b.Run(fmt.Sprintf("SampleRate_%d", sampleRate), func(b *testing.B) {
+			cache, _ := newStageSamplingTestCache(b, sampleRate)
+			defer cache.Stop()
+			feed := NewTestDocChangedFeed(100, 1)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.DocChanged(feed.Next())
+			}
+		})
+	}
+}
+
+func TestExtractPrincipalSequence(t *testing.T) {
+	testCases := []struct {
+		name             string
+		docJSON          string
+		expectedSequence uint64
+		expectedOk       bool
+	}{
+		{"valid json, invalid name", `{"name":123,"sequence":15}`, 15, true},
+		{"truncated json", `{"name":"alice","sequence": 20,"all_channels":`, 20, true},
+		{"missing sequence", `{"name":"alice"`, 0, false},
+		{"non-numeric sequence", `{"name":"alice","sequence":"abc"`, 0, false},
+		{"zero sequence", `{"name":"alice","sequence":0`, 0, false},
+		{"not json", `corrupt`, 0, false},
+		{"nested sequence before top-level", `{"name":"alice","explicit_channels":{"sequence":5},"sequence":25,"all_channels":`, 25, true},
+		{"nested sequence only", `{"name":"alice","explicit_channels":{"sequence":5},"all_channels":`, 0, false},
+		{"nested sequence in array", `{"name":"alice","previous":[{"sequence":5}],"all_channels":`, 0, false},
+		{"sequence as string value", `{"name":"sequence","all_channels":`, 0, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sequence, ok := extractPrincipalSequence([]byte(tc.docJSON))
+			assert.Equal(t, tc.expectedOk, ok)
+			assert.Equal(t, tc.expectedSequence, sequence)
+		})
+	}
+}
+
+// Validates that a principal doc that can't be unmarshalled on the feed doesn't leave its sequence pending.
+func TestCorruptPrincipalDocDoesntStallFeed(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache)()
+
+	// Long pending wait, so that a sequence that's never released would block subsequent sequences for the
+	// duration of the test
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxWait = time.Hour
+
+	db := setupTestDBWithCacheOptions(t, cacheOptions)
+	defer db.Close()
+
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+
+	// Corrupt user doc that has been allocated a sequence
+	corruptSeq, err := db.sequences.nextSequence()
+	require.NoError(t, err)
+	corruptJSON := fmt.Sprintf(`{"name":"corrupt","sequence":%d,"all_channels":`, corruptSeq)
+	require.NoError(t, db.Bucket.SetRaw(base.UserPrefix+"corrupt", 0, []byte(corruptJSON)))
+
+	_, doc, err := db.Put("doc1", Body{"channels": []string{"ABC"}})
+	require.NoError(t, err)
+	require.Greater(t, doc.Sequence, corruptSeq)
+
+	require.NoError(t, db.changeCache.waitForSequence(context.TODO(), doc.Sequence, 5*time.Second))
+	assert.Equal(t, int64(1), db.DbStats.Cache().PrincipalParseErrorCount.Value())
+	assert.Equal(t, int64(0), db.DbStats.Cache().NumSkippedSeqs.Value())
+}
+
+// generationTaggingChannelCache appends an entry to each GetChanges result identifying the change cache generation
+// before and after the read, and invokes onRead (when set) between the read and returning.
+type generationTaggingChannelCache struct {
+	ChannelCache
+	changeCache *changeCache
+	onRead      func()
+	reads       int32
+}
+
+func (c *generationTaggingChannelCache) GetChanges(channelID ChannelID, options ChangesOptions) ([]*LogEntry, error) {
+	startGeneration := atomic.LoadUint64(&c.changeCache.generation)
+	changes, err := c.ChannelCache.GetChanges(channelID, options)
+	atomic.AddInt32(&c.reads, 1)
+	if c.onRead != nil {
+		c.onRead()
+	}
+	endGeneration := atomic.LoadUint64(&c.changeCache.generation)
+	tagged := append(append([]*LogEntry{}, changes...), &LogEntry{DocID: fmt.Sprintf("%d:%d", startGeneration, endGeneration)})
+	return tagged, err
+}
+
+// assertCurrentGeneration verifies the tag added by generationTaggingChannelCache identifies a read that didn't overlap a Clear.
+func assertCurrentGeneration(t *testing.T, changes []*LogEntry) {
+	require.NotEmpty(t, changes)
+	var startGeneration, endGeneration uint64
+	_, err := fmt.Sscanf(changes[len(changes)-1].DocID, "%d:%d", &startGeneration, &endGeneration)
+	require.NoError(t, err)
+	assert.Equal(t, startGeneration, endGeneration, "Changes read across a cache clear")
+	assert.Equal(t, uint64(0), startGeneration%2, "Changes read during a cache clear")
+}
+
+func TestGetChangesRetriesOnCacheClear(t *testing.T) {
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+	_, doc, err := db.Put("doc1", Body{"channels": []string{"ABC"}})
+	require.NoError(t, err)
+	require.NoError(t, db.changeCache.waitForSequence(context.TODO(), doc.Sequence, 5*time.Second))
+
+	var clearOnce sync.Once
+	taggingCache := &generationTaggingChannelCache{changeCache: db.changeCache}
+	taggingCache.onRead = func() {
+		clearOnce.Do(func() {
+			assert.NoError(t, db.changeCache.Clear())
+		})
+	}
+	db.changeCache.lock.Lock()
+	taggingCache.ChannelCache = db.changeCache.channelCache
+	db.changeCache.channelCache = taggingCache
+	db.changeCache.lock.Unlock()
+
+	// First read overlaps the clear, and is retried against the new caches
+	changes, err := db.changeCache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&taggingCache.reads))
+	assertCurrentGeneration(t, changes)
+
+	// Repeated clears return a retryable error
+	taggingCache.onRead = func() {
+		assert.NoError(t, db.changeCache.Clear())
+	}
+	_, err = db.changeCache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 0}})
+	assert.Error(t, err)
+	assert.Equal(t, int32(2+MaxCacheGenerationRetries+1), atomic.LoadInt32(&taggingCache.reads))
+}
+
+func TestConcurrentGetChangesAndClear(t *testing.T) {
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+	var lastSeq uint64
+	for i := 0; i < 10; i++ {
+		_, doc, err := db.Put(fmt.Sprintf("doc%d", i), Body{"channels": []string{"ABC"}})
+		require.NoError(t, err)
+		lastSeq = doc.Sequence
+	}
+	require.NoError(t, db.changeCache.waitForSequence(context.TODO(), lastSeq, 5*time.Second))
+
+	taggingCache := &generationTaggingChannelCache{changeCache: db.changeCache}
+	db.changeCache.lock.Lock()
+	taggingCache.ChannelCache = db.changeCache.channelCache
+	db.changeCache.channelCache = taggingCache
+	db.changeCache.lock.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, db.changeCache.Clear())
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				changes, err := db.changeCache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 0}})
+				if err != nil {
+					// Only the retryable error is expected
+					status, _ := base.ErrorAsHTTPStatus(err)
+					assert.Equal(t, 503, status)
+					continue
+				}
+				assertCurrentGeneration(t, changes)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Validates decoding of the server's mutation time from a feed event's cas, and its use for feed latency.
+func TestFeedLatencyFromServerTime(t *testing.T) {
+
+	timeReceived := time.Now()
+	hlcCas := func(t time.Time) uint64 {
+		return uint64(t.UnixNano()) &^ 0xFFFF
+	}
+
+	// Plausible HLC cas values decode to the mutation time
+	saved := timeReceived.Add(-2 * time.Second)
+	assert.WithinDuration(t, saved, casServerTimeSaved(hlcCas(saved), timeReceived), time.Millisecond)
+
+	// Small clock skew is clamped to the time received, larger skew is implausible
+	assert.Equal(t, timeReceived, casServerTimeSaved(hlcCas(timeReceived.Add(time.Second)), timeReceived))
+	assert.True(t, casServerTimeSaved(hlcCas(timeReceived.Add(time.Minute)), timeReceived).IsZero())
+
+	// Non-HLC cas values aren't plausible times
+	assert.True(t, casServerTimeSaved(0, timeReceived).IsZero())
+	assert.True(t, casServerTimeSaved(12345, timeReceived).IsZero())
+
+	initTime := timeReceived.Add(-time.Hour)
+	now := timeReceived.Add(time.Second)
+	timeSaved := timeReceived.Add(-time.Second)
+
+	// Server time is preferred, falling back to timeSaved, then unknown
+	assert.Equal(t, 3*time.Second, measureFeedLatency(saved, timeSaved, initTime, now))
+	assert.Equal(t, 2*time.Second, measureFeedLatency(time.Time{}, timeSaved, initTime, now))
+	assert.Equal(t, time.Duration(0), measureFeedLatency(time.Time{}, time.Time{}, initTime, now))
+
+	// Mutations saved before the cache was initialized are measured from initTime
+	assert.Equal(t, 2*time.Second, measureFeedLatency(saved, timeSaved, now.Add(-2*time.Second), now))
+
+	// Clock skew that puts timeSaved after now is clamped to zero
+	assert.Equal(t, time.Duration(0), measureFeedLatency(time.Time{}, now.Add(time.Second), initTime, now))
+
+	// Feed events record the server time on the log entry and in the feed latency stat
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), nil)
+	defer cache.Stop()
+	cache.initTime = initTime
+	_, err := cache.getChannelCache().GetChanges(NewDefaultChannelID("ABC"), ChangesOptions{})
+	require.NoError(t, err)
+
+	saved = time.Now().Add(-2 * time.Second)
+	cache.DocChanged(sgbucket.FeedEvent{
+		Opcode:       sgbucket.FeedOpMutation,
+		Synchronous:  true,
+		Key:          []byte("doc1"),
+		Value:        []byte(`{"_sync":{"rev":"1-a","sequence":1,"recent_sequences":[1],"channels":{"ABC":null}}}`),
+		DataType:     base.MemcachedDataTypeJSON,
+		Cas:          hlcCas(saved),
+		TimeReceived: time.Now(),
+	})
+	entries := cache.getChannelCache().GetCachedChanges(NewDefaultChannelID("ABC"))
+	require.Len(t, entries, 1)
+	assert.WithinDuration(t, saved, entries[0].ServerTimeSaved, time.Millisecond)
+	assert.GreaterOrEqual(t, cache.dbStats.Database().DCPReceivedTime.Value(), int64(2*time.Second))
+}
+
+// Validates that the caching stats of entries cached while the cache lock is held, including pending entries cached by
+// housekeeping, are recorded once it's released, and are folded into the stat totals by the periodic flush.
+func TestFeedCachingStats(t *testing.T) {
+
+	defer func(interval time.Duration) { FeedStatsFlushInterval = interval }(FeedStatsFlushInterval)
+	FeedStatsFlushInterval = 10 * time.Millisecond
+
+	options := DefaultCacheOptions()
+	options.CachePendingSeqMaxWait = 20 * time.Millisecond
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &options)
+	defer cache.Stop()
+	dbStats := cache.dbStats.Database()
+
+	docChanged := func(docID string, sequence uint64, vbNo uint16) {
+		cache.DocChanged(sgbucket.FeedEvent{
+			Opcode:       sgbucket.FeedOpMutation,
+			Synchronous:  true,
+			Key:          []byte(docID),
+			Value:        []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d],"channels":{"ABC":null}}}`, sequence, sequence)),
+			DataType:     base.MemcachedDataTypeJSON,
+			VbNo:         vbNo,
+			TimeReceived: time.Now(),
+		})
+	}
+
+	// 2 waits for 1, and both are counted once 1 is cached
+	docChanged("doc2", 2, 5)
+	assert.Equal(t, int64(0), dbStats.DCPCachingCount.Value())
+	docChanged("doc1", 1, 7)
+	assert.Equal(t, int64(2), dbStats.DCPCachingCount.Value())
+
+	// 4 is cached by housekeeping once it's waited too long for 3
+	docChanged("doc4", 4, 5)
+	require.Eventually(t, func() bool {
+		return dbStats.DCPCachingCount.Value() == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The periodic flush folds the shards into the totals
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&dbStats.DCPCachingCount.Val) == 3 && atomic.LoadInt64(&dbStats.DCPReceivedCount.Val) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(3), dbStats.DCPReceivedCount.Value())
+}
+
+// Validates that changes received with feed latency above the warn threshold are counted and warned about, at most
+// once per warn interval, and that the max lag gauge reports the highest latency in each stats interval.
+func TestFeedLagWarnThreshold(t *testing.T) {
+
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), nil)
+	defer cache.Stop()
+	cache.initTime = time.Now().Add(-time.Hour)
+	cacheStats := cache.dbStats.Cache()
+
+	// Feed latency is measured from the time saved, as the cas doesn't carry a server time
+	sendChange := func(seq uint64, lag time.Duration) {
+		timeSaved := time.Now().Add(-lag).Format(time.RFC3339Nano)
+		cache.DocChanged(sgbucket.FeedEvent{
+			Opcode:       sgbucket.FeedOpMutation,
+			Synchronous:  true,
+			Key:          []byte(fmt.Sprintf("doc%d", seq)),
+			Value:        []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d],"time_saved":"%s"}}`, seq, seq, timeSaved)),
+			DataType:     base.MemcachedDataTypeJSON,
+			TimeReceived: time.Now(),
+		})
+	}
+
+	// Under the default threshold
+	sendChange(1, 10*time.Second)
+	assert.Equal(t, int64(0), cacheStats.HighFeedLag.Value())
+	assert.Equal(t, int64(0), atomic.LoadInt64(&cache.lastFeedLagWarn))
+
+	// Over the threshold - the first is warned about, the next is rate limited
+	sendChange(2, 2*time.Minute)
+	assert.Equal(t, int64(1), cacheStats.HighFeedLag.Value())
+	lastWarn := atomic.LoadInt64(&cache.lastFeedLagWarn)
+	assert.NotZero(t, lastWarn)
+	sendChange(3, 3*time.Minute)
+	assert.Equal(t, int64(2), cacheStats.HighFeedLag.Value())
+	assert.Equal(t, lastWarn, atomic.LoadInt64(&cache.lastFeedLagWarn))
+
+	// The gauge reports the highest lag since the previous stats update
+	cache.updateStats()
+	assert.GreaterOrEqual(t, cacheStats.MaxFeedLag.Value(), int64(3*time.Minute/time.Millisecond))
+	assert.Less(t, cacheStats.MaxFeedLag.Value(), int64(4*time.Minute/time.Millisecond))
+	sendChange(4, 10*time.Second)
+	cache.updateStats()
+	assert.Less(t, cacheStats.MaxFeedLag.Value(), int64(time.Minute/time.Millisecond))
+
+	// The threshold can be raised at runtime
+	threshold := 5 * time.Minute
+	require.NoError(t, cache.UpdateOptions(CacheOptionsUpdate{FeedLagWarnThreshold: &threshold}))
+	sendChange(5, 3*time.Minute)
+	assert.Equal(t, int64(2), cacheStats.HighFeedLag.Value())
+	assert.Equal(t, uint64(5), cache.LastSequence())
+
+	invalid := time.Duration(0)
+	assert.Error(t, cache.UpdateOptions(CacheOptionsUpdate{FeedLagWarnThreshold: &invalid}))
+}
+
+// Validates that the mean feed lag stat reports the mean latency of received changes, with clock skew counted as zero.
+func TestMeanFeedLag(t *testing.T) {
+
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), nil)
+	defer cache.Stop()
+	cache.initTime = time.Now().Add(-time.Hour)
+	cacheStats := cache.dbStats.Cache()
+
+	sendChange := func(seq uint64, timeSaved time.Time) {
+		cache.DocChanged(sgbucket.FeedEvent{
+			Opcode:       sgbucket.FeedOpMutation,
+			Synchronous:  true,
+			Key:          []byte(fmt.Sprintf("doc%d", seq)),
+			Value:        []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d],"time_saved":"%s"}}`, seq, seq, timeSaved.Format(time.RFC3339Nano))),
+			DataType:     base.MemcachedDataTypeJSON,
+			TimeReceived: time.Now(),
+		})
+	}
+
+	sendChange(1, time.Now().Add(-20*time.Second))
+	sendChange(2, time.Now().Add(-40*time.Second))
+	cache.updateStats()
+	assert.GreaterOrEqual(t, cacheStats.MeanFeedLag.Value(), int64(30*time.Second/time.Millisecond))
+	assert.Less(t, cacheStats.MeanFeedLag.Value(), int64(31*time.Second/time.Millisecond))
+
+	// A change saved in the future brings the mean down, rather than making it negative
+	sendChange(3, time.Now().Add(time.Hour))
+	cache.updateStats()
+	assert.GreaterOrEqual(t, cacheStats.MeanFeedLag.Value(), int64(20*time.Second/time.Millisecond))
+	assert.Less(t, cacheStats.MeanFeedLag.Value(), int64(21*time.Second/time.Millisecond))
+	assert.Equal(t, uint64(3), cache.LastSequence())
+}
+
+// Validates that a sequence gap is reported by the gap stats, and warned about once per gap, at most once per warn
+// interval.
+func TestSequenceGapWarning(t *testing.T) {
+
+	defer func(threshold uint64) { SequenceGapWarnThreshold = threshold }(SequenceGapWarnThreshold)
+	SequenceGapWarnThreshold = 10
+
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), nil)
+	defer cache.Stop()
+	cacheStats := cache.dbStats.Cache()
+	warnCount := base.SyncGatewayStats.GlobalStats.ResourceUtilizationStats().WarnCount
+	processSequences := func(from, to uint64) {
+		for seq := from; seq <= to; seq++ {
+			cache.processEntry(logEntry(seq, fmt.Sprintf("doc%d", seq), "1-a", []string{"ABC"}))
+		}
+	}
+
+	// Under the threshold
+	processSequences(1, 1)
+	processSequences(5, 5)
+	assert.Equal(t, int64(0), cacheStats.SequenceGap.Value())
+	processSequences(2, 4)
+
+	// Each sequence deferred behind the gap extends it, but only the first is warned about
+	startWarnCount := warnCount.Value()
+	processSequences(20, 22)
+	assert.Equal(t, startWarnCount+1, warnCount.Value())
+	assert.Equal(t, int64(16), cacheStats.SequenceGap.Value())
+	assert.Equal(t, int64(16), cacheStats.MaxSequenceGap.Value())
+
+	// Filling the gap closes it
+	processSequences(6, 19)
+	assert.Equal(t, uint64(23), cache.getNextSequence())
+	assert.Equal(t, int64(0), cacheStats.SequenceGap.Value())
+	assert.Equal(t, int64(16), cacheStats.MaxSequenceGap.Value())
+
+	// A new gap within the warn interval is reported by the stats, but not warned about
+	processSequences(50, 50)
+	assert.Equal(t, startWarnCount+1, warnCount.Value())
+	assert.Equal(t, int64(27), cacheStats.SequenceGap.Value())
+	assert.Equal(t, int64(27), cacheStats.MaxSequenceGap.Value())
+}
+
+// Validates that rolled back sequences are removed from the cache, and subsequently served by query from the bucket.
+func TestChangeCacheRollback(t *testing.T) {
+	store := newTestCacheBackingStore()
+	for _, sequence := range []uint64{1, 2, 3, 4, 5} {
+		store.addDoc(sequence, []string{"ABC"})
+	}
+
+	cache := newTestChangeCache(t, store, nil)
+	defer cache.Stop()
+	_, err := cache.getChannelCache().GetChanges(NewDefaultChannelID("ABC"), ChangesOptions{})
+	require.NoError(t, err)
+
+	getChangesDocIDs := func() []string {
+		entries, err := cache.GetChanges("ABC", ChangesOptions{})
+		require.NoError(t, err)
+		var docIDs []string
+		for _, entry := range entries {
+			docIDs = append(docIDs, entry.DocID)
+		}
+		return docIDs
+	}
+
+	// Sequences 6-8 and pending sequence 10 are received on the feed, but then rolled back by the server
+	for sequence := uint64(1); sequence <= 8; sequence++ {
+		cache.processEntry(logEntry(sequence, fmt.Sprintf("doc-%d", sequence), "1-a", []string{"ABC"}))
+	}
+	cache.processEntry(logEntry(10, "doc-10", "1-a", []string{"ABC"}))
+	assert.Equal(t, []string{"doc-1", "doc-2", "doc-3", "doc-4", "doc-5", "doc-6", "doc-7", "doc-8"}, getChangesDocIDs())
+
+	assert.Equal(t, 4, cache.RollbackSequenceRange(6, 10))
+	assert.Equal(t, []string{"doc-1", "doc-2", "doc-3", "doc-4", "doc-5"}, getChangesDocIDs())
+	assert.Len(t, cache.pendingLogs, 0)
+	assert.Len(t, cache.receivedSeqs, 0)
+
+	// A rolled back sequence that's received again is cached
+	store.addDoc(9, []string{"ABC"})
+	store.addDoc(10, []string{"ABC"})
+	cache.processEntry(logEntry(9, "doc-9", "1-a", []string{"ABC"}))
+	cache.processEntry(logEntry(10, "doc-10", "1-a", []string{"ABC"}))
+	assert.Equal(t, []string{"doc-1", "doc-2", "doc-3", "doc-4", "doc-5", "doc-9", "doc-10"}, getChangesDocIDs())
+
+	// A DCP rollback for vbucket 1 removes the entries for mutations received on it, but not those for other vbuckets
+	docEvent := func(seq uint64, vbNo uint16) sgbucket.FeedEvent {
+		return sgbucket.FeedEvent{
+			Opcode:   sgbucket.FeedOpMutation,
+			Key:      []byte(fmt.Sprintf("doc-%d", seq)),
+			Value:    []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d],"channels":{"ABC":null}}}`, seq, seq)),
+			DataType: base.MemcachedDataTypeJSON,
+			VbNo:     vbNo,
+		}
+	}
+	store.addDoc(12, []string{"ABC"})
+	cache.DocChanged(docEvent(11, 1))
+	cache.DocChanged(docEvent(12, 2))
+	require.NoError(t, cache.waitForSequence(context.TODO(), 12, base.DefaultWaitForSequence))
+	assert.Equal(t, []string{"doc-1", "doc-2", "doc-3", "doc-4", "doc-5", "doc-9", "doc-10", "doc-11", "doc-12"}, getChangesDocIDs())
+
+	listener := &changeListener{OnRollback: cache.processVbucketRollback}
+	listener.ProcessFeedEvent(sgbucket.FeedEvent{Opcode: base.FeedOpRollback, VbNo: 1})
+	assert.Equal(t, []string{"doc-1", "doc-2", "doc-3", "doc-4", "doc-5", "doc-9", "doc-10", "doc-12"}, getChangesDocIDs())
+
+	assert.Equal(t, int64(2), cache.dbStats.Cache().RollbackCount.Value())
+	assert.Equal(t, int64(5), cache.dbStats.Cache().RolledBackEntryCount.Value())
+}
+
+// Validates that entries received while the cache is paused aren't cached, and that once resumed, changes requests
+// are backfilled with them by query rather than skipping them.
+func TestChangeCachePauseResume(t *testing.T) {
+	store := newTestCacheBackingStore()
+	for _, sequence := range []uint64{1, 2, 3} {
+		store.addDoc(sequence, []string{"ABC"})
+	}
+
+	cache := newTestChangeCache(t, store, nil)
+	defer cache.Stop()
+	_, err := cache.getChannelCache().GetChanges(NewDefaultChannelID("ABC"), ChangesOptions{})
+	require.NoError(t, err)
+
+	var notified base.Set
+	var notifyLock sync.Mutex
+	cache.SetNotifyChange(func(changedChannels base.Set) {
+		notifyLock.Lock()
+		notified = notified.Union(changedChannels)
+		notifyLock.Unlock()
+	})
+	getNotified := func() base.Set {
+		notifyLock.Lock()
+		defer notifyLock.Unlock()
+		changedChannels := notified
+		notified = nil
+		return changedChannels
+	}
+	getChangesDocIDs := func(since uint64) []string {
+		entries, err := cache.GetChanges("ABC", ChangesOptions{Since: SequenceID{Seq: since}})
+		require.NoError(t, err)
+		var docIDs []string
+		for _, entry := range entries {
+			docIDs = append(docIDs, entry.DocID)
+		}
+		return docIDs
+	}
+	processSequences := func(from, to uint64) {
+		for sequence := from; sequence <= to; sequence++ {
+			store.addDoc(sequence, []string{"ABC"})
+			cache.processEntry(logEntry(sequence, fmt.Sprintf("doc-%d", sequence), "1-a", []string{"ABC"}))
+		}
+	}
+
+	processSequences(1, 3)
+	assert.Equal(t, []string{"doc-1", "doc-2", "doc-3"}, getChangesDocIDs(0))
+
+	status := cache.Pause()
+	assert.Equal(t, CachePauseStatus{Paused: true, PausedAt: 3}, status)
+	assert.Equal(t, status, cache.Pause())
+	getNotified()
+
+	// Sequences received while paused are buffered, but not cached or notified
+	processSequences(4, 6)
+	assert.Equal(t, uint64(7), cache.getNextSequence())
+	assert.Equal(t, uint64(3), cache.getChannelCache().GetHighCacheSequence())
+	assert.Equal(t, []string{"doc-1", "doc-2", "doc-3"}, getChangesDocIDs(0))
+	assert.Len(t, getNotified(), 0)
+
+	// Once resumed, the entries received while paused are backfilled, and the changed channels notified
+	status = cache.Resume()
+	assert.Equal(t, CachePauseStatus{Paused: false, PausedAt: 3, ResumedAt: 6}, status)
+	assert.Equal(t, status, cache.PauseStatus())
+	assert.Equal(t, base.SetOf("ABC", channels.UserStarChannel), getNotified())
+	validFrom, entries, ok := cache.getChannelCache().getCachedEntries(NewDefaultChannelID("ABC"))
+	require.True(t, ok)
+	assert.Equal(t, uint64(7), validFrom)
+	assert.Len(t, entries, 0)
+	assert.Equal(t, []string{"doc-4", "doc-5", "doc-6"}, getChangesDocIDs(3))
+	assert.Equal(t, []string{"doc-1", "doc-2", "doc-3", "doc-4", "doc-5", "doc-6"}, getChangesDocIDs(0))
+
+	// Later entries are cached again
+	processSequences(7, 7)
+	assert.Equal(t, uint64(7), cache.getChannelCache().GetHighCacheSequence())
+	assert.Equal(t, []string{"doc-4", "doc-5", "doc-6", "doc-7"}, getChangesDocIDs(3))
+}
+
+// Validates that channel change notifications aren't lost when the notifyChange callback is replaced while the feed is
+// being processed.
+func TestChangeCacheSetNotifyChange(t *testing.T) {
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), nil)
+	defer cache.Stop()
+
+	feedDoc := func(sequence uint64) {
+		cache.DocChanged(sgbucket.FeedEvent{
+			Opcode:       sgbucket.FeedOpMutation,
+			Synchronous:  true,
+			Key:          []byte(fmt.Sprintf("doc-%d", sequence)),
+			Value:        []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d],"channels":{"chan-%d":null}}}`, sequence, sequence, sequence)),
+			DataType:     base.MemcachedDataTypeJSON,
+			TimeReceived: time.Now(),
+		})
+	}
+
+	var notifiedLock sync.Mutex
+	firstNotified, secondNotified := base.Set{}, base.Set{}
+	notifyTo := func(notified base.Set) func(base.Set) {
+		return func(changedChannels base.Set) {
+			notifiedLock.Lock()
+			for channelName := range changedChannels {
+				notified.Add(channelName)
+			}
+			notifiedLock.Unlock()
+		}
+	}
+
+	// Changes without a callback are buffered, and notified to the next callback
+	feedDoc(1)
+	assert.True(t, cache.unnotified.Contains("chan-1"))
+	cache.SetNotifyChange(notifyTo(firstNotified))
+	assert.True(t, firstNotified.Contains("chan-1"))
+	assert.Len(t, cache.unnotified, 0)
+
+	// Replace the callback mid-stream, with a window without a callback
+	numDocs := uint64(300)
+	halfwayFed := make(chan struct{})
+	allFed := make(chan struct{})
+	go func() {
+		for sequence := uint64(2); sequence <= numDocs; sequence++ {
+			feedDoc(sequence)
+			if sequence == numDocs/2 {
+				close(halfwayFed)
+			}
+		}
+		close(allFed)
+	}()
+	<-halfwayFed
+	cache.SetNotifyChange(nil)
+	cache.SetNotifyChange(notifyTo(secondNotified))
+	notifiedLock.Lock()
+	firstNotifiedCount := len(firstNotified)
+	notifiedLock.Unlock()
+	<-allFed
+
+	notifiedLock.Lock()
+	defer notifiedLock.Unlock()
+	assert.Equal(t, firstNotifiedCount, len(firstNotified), "Unexpected notification of replaced callback")
+	for sequence := uint64(1); sequence <= numDocs; sequence++ {
+		channelName := fmt.Sprintf("chan-%d", sequence)
+		assert.True(t, firstNotified.Contains(channelName) || secondNotified.Contains(channelName), "Missing notification for %s", channelName)
+	}
+}
+
+// Validates that debounced change notifications are batched until the end of the interval, and that changes batched
+// when the cache is cleared or stopped aren't lost.
+func TestChangeCacheNotificationDebounce(t *testing.T) {
+
+	feedDoc := func(cache *changeCache, sequence uint64, channelName string) {
+		cache.DocChanged(sgbucket.FeedEvent{
+			Opcode:       sgbucket.FeedOpMutation,
+			Synchronous:  true,
+			Key:          []byte(fmt.Sprintf("doc-%d", sequence)),
+			Value:        []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d],"channels":{%q:null}}}`, sequence, sequence, channelName)),
+			DataType:     base.MemcachedDataTypeJSON,
+			TimeReceived: time.Now(),
+		})
+	}
+
+	var notifiedLock sync.Mutex
+	var notifications []base.Set
+	notify := func(changedChannels base.Set) {
+		notifiedLock.Lock()
+		notifications = append(notifications, changedChannels)
+		notifiedLock.Unlock()
+	}
+	getNotifications := func() []base.Set {
+		notifiedLock.Lock()
+		defer notifiedLock.Unlock()
+		return append([]base.Set(nil), notifications...)
+	}
+
+	// Changes are notified once the interval ends
+	options := DefaultCacheOptions()
+	options.NotificationDebounceInterval = 10 * time.Millisecond
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), &options)
+	cache.SetNotifyChange(notify)
+	numDocs := uint64(100)
+	for sequence := uint64(1); sequence <= numDocs; sequence++ {
+		feedDoc(cache, sequence, fmt.Sprintf("chan-%d", sequence%3))
+	}
+	notified := base.Set{}
+	require.Eventually(t, func() bool {
+		for _, changedChannels := range getNotifications() {
+			notified.Update(changedChannels)
+		}
+		return len(notified) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, notified.Equals(base.SetOf("chan-0", "chan-1", "chan-2")))
+	assert.Less(t, len(getNotifications()), int(numDocs))
+	cache.Stop()
+
+	// Changes batched when the cache is cleared or stopped are notified immediately
+	notifiedLock.Lock()
+	notifications = nil
+	notifiedLock.Unlock()
+	options.NotificationDebounceInterval = time.Hour
+	cache = newTestChangeCache(t, newTestCacheBackingStore(), &options)
+	defer cache.Stop()
+	cache.SetNotifyChange(notify)
+	feedDoc(cache, 1, "ABC")
+	feedDoc(cache, 2, "DEF")
+	assert.Len(t, getNotifications(), 0)
+	require.NoError(t, cache.Clear())
+	require.Len(t, getNotifications(), 1)
+	assert.True(t, getNotifications()[0].Equals(base.SetOf("ABC", "DEF")))
+
+	feedDoc(cache, 3, "GHI")
+	assert.Len(t, getNotifications(), 1)
+	cache.Stop()
+	require.Len(t, getNotifications(), 2)
+	assert.True(t, getNotifications()[1].Equals(base.SetOf("GHI")))
+
+	// Once stopped, changes are notified without waiting for the interval
+	cache.notifyChanged(base.SetOf("JKL"))
+	require.Len(t, getNotifications(), 3)
+	assert.True(t, getNotifications()[2].Equals(base.SetOf("JKL")))
+}
+
+// Compares the notifyChange callbacks made for changes across a handful of channels, arriving at well over thousands
+// of changes a second, with and without notifications debounced.  Reported as notifies/op.
+func BenchmarkChangeCacheNotificationDebounce(b *testing.B) {
+	defer base.SetUpBenchmarkLogging(base.LevelError, base.KeyCache, base.KeyChanges)()
+	for _, interval := range []time.Duration{0, 10 * time.Millisecond, 100 * time.Millisecond} {
+		b.Run(fmt.Sprintf("Interval=%v", interval), func(b *testing.B) {
+			options := DefaultCacheOptions()
+			options.NotificationDebounceInterval = interval
+			cache := newTestChangeCache(b, newTestCacheBackingStore(), &options)
+			var notifyCount int64
+			cache.SetNotifyChange(func(base.Set) {
+				atomic.AddInt64(&notifyCount, 1)
+			})
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sequence := uint64(i + 1)
+				changedChannels := cache.processEntry(logEntry(sequence, fmt.Sprintf("doc-%d", sequence), "1-a", []string{fmt.Sprintf("chan-%d", i%5)}))
+				cache.notifyChanged(changedChannels)
+			}
+			cache.Stop()
+			b.StopTimer()
+			b.ReportMetric(float64(atomic.LoadInt64(&notifyCount))/float64(b.N), "notifies/op")
+		})
+	}
+}
+
+// Validates that a sequence wait that times out reports the state of the cache and is counted, and that waiters are
+// woken as soon as the sequence is cached.
+func TestChangeCacheWaitForSequenceTimeout(t *testing.T) {
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), nil)
+	defer cache.Stop()
+
+	feedDoc := func(sequence uint64) {
+		cache.DocChanged(sgbucket.FeedEvent{
+			Opcode:       sgbucket.FeedOpMutation,
+			Synchronous:  true,
+			Key:          []byte(fmt.Sprintf("doc-%d", sequence)),
+			Value:        []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d],"channels":{"ABC":null}}}`, sequence, sequence)),
+			DataType:     base.MemcachedDataTypeJSON,
+			TimeReceived: time.Now(),
+		})
+	}
+
+	// Withhold sequence 2, leaving sequence 3 pending
+	feedDoc(1)
+	feedDoc(3)
+
+	err := cache.waitForSequence(context.TODO(), 3, 50*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "waiting for sequence 3 to be cached - last cached sequence: 1, pending sequences: 1")
+	assert.Equal(t, int64(1), cache.dbStats.Cache().SequenceWaitTimeoutCount.Value())
+
+	// A waiter is woken once the withheld sequence arrives
+	waitErr := make(chan error)
+	go func() {
+		waitErr <- cache.waitForSequence(context.TODO(), 3, base.DefaultWaitForSequence)
+	}()
+	feedDoc(2)
+	select {
+	case err := <-waitErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sequence waiter wasn't woken when the sequence was cached")
+	}
+	assert.Equal(t, int64(1), cache.dbStats.Cache().SequenceWaitTimeoutCount.Value())
+}
+
+// Validates that the cache's methods return, or are no-ops with, the same database closed error once the cache has
+// been stopped, as are channel queries once the database's bucket has been closed.
+func TestStoppedCacheReturnsDatabaseClosed(t *testing.T) {
+
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), nil)
+	cache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("ABC"))
+	cache.processEntry(logEntry(1, "doc1", "1-a", []string{"ABC"}))
+	cache.Stop()
+
+	_, err := cache.GetChanges("ABC", ChangesOptions{})
+	assert.Equal(t, base.ErrDatabaseClosed, err)
+	assert.Equal(t, base.ErrDatabaseClosed, cache.Clear())
+	_, _, err = cache.ListChannelCaches(ChannelCacheSortSize, 10, "")
+	assert.Equal(t, base.ErrDatabaseClosed, err)
+	_, err = cache.AuditChannel(NewDefaultChannelID("ABC"), 0, 0, 0)
+	assert.Equal(t, base.ErrDatabaseClosed, err)
+	assert.Equal(t, 0, cache.Remove([]string{"doc1"}, time.Now()))
+
+	cache.DocChanged(sgbucket.FeedEvent{Opcode: sgbucket.FeedOpMutation, Key: []byte(base.UnusedSeqPrefix + "2")})
+	assert.Equal(t, uint64(2), cache.getNextSequence())
+
+	status, _ := base.ErrorAsHTTPStatus(err)
+	assert.Equal(t, 503, status)
+
+	db := setupTestDB(t)
+	defer db.Close()
+	bucket := db.Bucket
+	db.Bucket = nil
+	_, err = db.getChangesInChannelFromQuery(context.TODO(), "ABC", 0, 0, 0, false)
+	assert.Equal(t, base.ErrDatabaseClosed, err)
+	_, err = db.getChangesForSequences(context.TODO(), []uint64{1})
+	assert.Equal(t, base.ErrDatabaseClosed, err)
+	db.Bucket = bucket
+}
+
+// Validates that stopping feed events gives up waiting on a feed event that doesn't return, and that feed events started
+// after the stop are rejected.
+func TestStopFeedEventsTimeout(t *testing.T) {
+
+	cache := newTestChangeCache(t, newTestCacheBackingStore(), nil)
+	defer cache.Stop()
+
+	require.True(t, cache.startFeedEvent())
+	assert.False(t, cache.stopFeedEvents(10*time.Millisecond))
+	assert.False(t, cache.startFeedEvent())
+
+	cache.feedEventsInFlight.Done()
+	assert.True(t, cache.stopFeedEvents(5*time.Second))
+}
+
+// Validates that Close only reports its timed out phases as complete once they've all returned.
+func TestWaitForClosePhases(t *testing.T) {
+
+	defer func(maxWait time.Duration) { DatabaseClosePhaseMaxWait = maxWait }(DatabaseClosePhaseMaxWait)
+	DatabaseClosePhaseMaxWait = 10 * time.Millisecond
+
+	assert.Nil(t, runClosePhase("db", "complete", func() {}))
+
+	release := make(chan struct{})
+	unfinished := runClosePhase("db", "hang", func() { <-release })
+	require.NotNil(t, unfinished)
+	assert.False(t, waitForClosePhases([]<-chan struct{}{unfinished}, 10*time.Millisecond))
+
+	close(release)
+	assert.True(t, waitForClosePhases([]<-chan struct{}{unfinished}, 5*time.Second))
+	assert.True(t, waitForClosePhases(nil, 0))
+}
+
+// Runs a representative cache workload - buffering, caching, notification, reads, removal, audit and channel cache
+// removal - with detailed logging, and validates that channel names are only logged tagged as user data.
+func TestCacheLoggingRedactsChannelNames(t *testing.T) {
+	if base.GlobalTestLoggingSet.IsTrue() {
+		t.Skip("Test does not work when a global test log level is set")
+	}
+	defer base.SetUpTestLogging(base.LevelTrace, base.KeyCache, base.KeyChanges, base.KeyDCP)()
+
+	sensitiveChannels := []string{"secret-sales", "secret-payroll"}
+	store := newTestCacheBackingStore()
+	cache := newTestChangeCache(t, store, nil)
+	defer cache.Stop()
+	var listener changeListener
+	listener.Init("bucket")
+	cache.SetNotifyChange(listener.Notify)
+
+	base.AssertLogRedacted(t, sensitiveChannels, func() {
+		for _, channelName := range sensitiveChannels {
+			_, err := cache.GetChanges(channelName, ChangesOptions{Since: SequenceID{Seq: 0}})
+			require.NoError(t, err)
+		}
+
+		// Sequence 3 is buffered until 2 arrives
+		for _, sequence := range []uint64{1, 3, 2} {
+			store.addDoc(sequence, sensitiveChannels)
+			cache.DocChanged(sgbucket.FeedEvent{
+				Opcode:       sgbucket.FeedOpMutation,
+				Synchronous:  true,
+				Key:          []byte(fmt.Sprintf("doc-%d", sequence)),
+				Value:        []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d],"channels":{%q:null,%q:null}}}`, sequence, sequence, sensitiveChannels[0], sensitiveChannels[1])),
+				DataType:     base.MemcachedDataTypeJSON,
+				TimeReceived: time.Now(),
+			})
+		}
+		require.Equal(t, uint64(4), cache.getNextSequence())
+
+		entries, err := cache.GetChanges(sensitiveChannels[0], ChangesOptions{Since: SequenceID{Seq: 0}})
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		report, err := cache.AuditChannel(NewDefaultChannelID(sensitiveChannels[0]), 0, 0, 0)
+		require.NoError(t, err)
+		assert.True(t, report.Consistent)
+		assert.Equal(t, 2, cache.Remove([]string{"doc-1"}, time.Now()))
+		assert.True(t, cache.RemoveChannelCache(NewDefaultChannelID(sensitiveChannels[1])))
+	})
+}
@@ -13,8 +13,10 @@ package db
 import (
 	"expvar"
 	"math"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	sgbucket "github.com/couchbase/sg-bucket"
@@ -35,7 +37,9 @@ type changeListener struct {
 	terminateCheckCounter uint64                 // Termination Event counter; increments on every notifyCheckForTermination
 	keyCounts             map[string]uint64      // Latest count at which each doc key was updated
 	OnDocChanged          DocChangedFunc         // Called when change arrives on feed
+	OnRollback            func(vbNo uint16)      // Called when the feed for a vbucket is rolled back
 	terminator            chan bool              // Signal to cause cbdatasource bucketdatasource.Close() to be called, which removes dcp receiver
+	vbLag                 feedVbLagTracker       // Last event processed per vbucket
 }
 
 type DocChangedFunc func(event sgbucket.FeedEvent)
@@ -101,7 +105,8 @@ func (listener *changeListener) StartMutationFeed(bucket base.Bucket, dbStats *e
 }
 
 // ProcessFeedEvent is invoked for each mutate or delete event seen on the server's mutation feed (TAP or DCP).  Uses document
-// key to determine handling, based on whether the incoming mutation is an internal Sync Gateway document.
+// key to determine handling, based on whether the incoming mutation is an internal Sync Gateway document.  Also notifies
+// OnRollback of DCP vbucket rollbacks.
 func (listener *changeListener) ProcessFeedEvent(event sgbucket.FeedEvent) bool {
 	requiresCheckpointPersistence := true
 	if event.Opcode == sgbucket.FeedOpMutation || event.Opcode == sgbucket.FeedOpDeletion {
@@ -120,6 +125,10 @@ func (listener *changeListener) ProcessFeedEvent(event sgbucket.FeedEvent) bool
 			if listener.OnDocChanged != nil && event.Opcode == sgbucket.FeedOpMutation {
 				listener.OnDocChanged(event)
 			}
+		} else if strings.HasPrefix(key, base.PurgeMarkerPrefix) { // SG purge marker docs
+			if listener.OnDocChanged != nil && event.Opcode == sgbucket.FeedOpMutation {
+				listener.OnDocChanged(event)
+			}
 		} else if strings.HasPrefix(key, base.DCPCheckpointPrefix) { // SG DCP checkpoint docs
 			// Do not require checkpoint persistence when DCP checkpoint docs come back over DCP - otherwise
 			// we'll end up in a feedback loop for their vbucket if persistence is enabled
@@ -131,6 +140,11 @@ func (listener *changeListener) ProcessFeedEvent(event sgbucket.FeedEvent) bool
 				listener.OnDocChanged(event)
 			}
 		}
+		listener.vbLag.eventProcessed(event.VbNo, event.Cas, time.Now())
+	} else if event.Opcode == base.FeedOpRollback {
+		if listener.OnRollback != nil {
+			listener.OnRollback(event.VbNo)
+		}
 	}
 	return requiresCheckpointPersistence
 }
@@ -160,6 +174,11 @@ func (listener *changeListener) Stop() {
 		}
 	}
 
+	// Mutation feed wasn't started
+	if listener.FeedArgs.DoneChan == nil {
+		return
+	}
+
 	// Wait for mutation feed worker to terminate.
 	waitTime := MutationFeedStopMaxWait
 	select {
@@ -187,8 +206,8 @@ func (listener *changeListener) Notify(keys base.Set) {
 	for key := range keys {
 		listener.keyCounts[key] = listener.counter
 	}
-	base.Debugf(base.KeyChanges, "Notifying that %q changed (keys=%q) count=%d",
-		base.MD(listener.bucketName), base.UD(keys), listener.counter)
+	base.Debugf(base.KeyChanges, "Notifying that %q changed (keys=%s) count=%d",
+		base.MD(listener.bucketName), base.UD(keys.ToArray()), listener.counter)
 	listener.tapNotifier.Broadcast()
 	listener.tapNotifier.L.Unlock()
 }
@@ -393,3 +412,80 @@ func (waiter *ChangeWaiter) RefreshUserKeys(user auth.User) {
 func (db *Database) NewUserWaiter() *ChangeWaiter {
 	return db.mutationListener.NewWaiterWithChannels(base.Set{}, db.User())
 }
+
+// Number of vbuckets tracked by feedVbLagTracker
+const feedVbLagNumVbuckets = 1024
+
+// Default number of vbuckets included in vbucket lag reports
+const DefaultVbLagReportCount = 10
+
+// VbLag describes the most recent event processed by a mutation feed for a single vbucket.
+type VbLag struct {
+	VbNo      uint16  `json:"vb"`
+	LagSecs   float64 `json:"seconds_since_last_event"`
+	LastCas   uint64  `json:"last_cas"`
+	EventTime int64   `json:"last_event_time"` // Unix time in nanoseconds
+}
+
+// feedVbLagTracker tracks the cas and processing time of the last event processed per vbucket on a mutation feed,
+// to identify vbuckets whose processing is falling behind.  Updates are lock-free, as events for different vbuckets
+// may be processed concurrently.
+type feedVbLagTracker struct {
+	lastCas  [feedVbLagNumVbuckets]uint64
+	lastTime [feedVbLagNumVbuckets]int64
+}
+
+// eventProcessed records the processing of a feed event for the given vbucket.
+func (t *feedVbLagTracker) eventProcessed(vbNo uint16, cas uint64, processedAt time.Time) {
+	if vbNo >= feedVbLagNumVbuckets {
+		return
+	}
+	atomic.StoreUint64(&t.lastCas[vbNo], cas)
+	atomic.StoreInt64(&t.lastTime[vbNo], processedAt.UnixNano())
+}
+
+// laggards returns up to count vbuckets, ordered by time since their last processed event (longest first).  Vbuckets
+// that haven't processed any events aren't included.
+func (t *feedVbLagTracker) laggards(count int, now time.Time) []VbLag {
+	if count <= 0 {
+		return nil
+	}
+	vbLags := make([]VbLag, 0)
+	for vbNo := 0; vbNo < feedVbLagNumVbuckets; vbNo++ {
+		eventTime := atomic.LoadInt64(&t.lastTime[vbNo])
+		if eventTime == 0 {
+			continue
+		}
+		vbLags = append(vbLags, VbLag{
+			VbNo:      uint16(vbNo),
+			LagSecs:   now.Sub(time.Unix(0, eventTime)).Seconds(),
+			LastCas:   atomic.LoadUint64(&t.lastCas[vbNo]),
+			EventTime: eventTime,
+		})
+	}
+	sort.Slice(vbLags, func(i, j int) bool {
+		if vbLags[i].EventTime == vbLags[j].EventTime {
+			return vbLags[i].VbNo < vbLags[j].VbNo
+		}
+		return vbLags[i].EventTime < vbLags[j].EventTime
+	})
+	if len(vbLags) > count {
+		vbLags = vbLags[:count]
+	}
+	return vbLags
+}
+
+// maxLag returns the longest time since the last processed event across all vbuckets that have processed events.
+func (t *feedVbLagTracker) maxLag(now time.Time) time.Duration {
+	oldest := int64(0)
+	for vbNo := 0; vbNo < feedVbLagNumVbuckets; vbNo++ {
+		eventTime := atomic.LoadInt64(&t.lastTime[vbNo])
+		if eventTime != 0 && (oldest == 0 || eventTime < oldest) {
+			oldest = eventTime
+		}
+	}
+	if oldest == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, oldest))
+}
@@ -13,6 +13,7 @@ package db
 import (
 	"log"
 	"testing"
+	"time"
 
 	"github.com/couchbase/sync_gateway/base"
 	"github.com/couchbase/sync_gateway/channels"
@@ -132,3 +133,54 @@ func TestUserWaiterForRoleChange(t *testing.T) {
 	// Wait for user notification of updated role
 	require.True(t, WaitForUserWaiterChange(userWaiter))
 }
+
+// Simulates uneven traffic across vbuckets, and validates that the least recently updated vbuckets are reported as
+// laggards.
+func TestFeedVbLagTracker(t *testing.T) {
+
+	var tracker feedVbLagTracker
+	start := time.Now()
+
+	assert.Len(t, tracker.laggards(5, start), 0)
+	assert.Equal(t, time.Duration(0), tracker.maxLag(start))
+
+	// Every vbucket sees an initial event
+	for vbNo := uint16(0); vbNo < feedVbLagNumVbuckets; vbNo++ {
+		tracker.eventProcessed(vbNo, uint64(vbNo)+1, start)
+	}
+
+	// Hot vbuckets 10 and 20 continue to see traffic for the next minute, all other vbuckets keep seeing events
+	// except for 7, 500 and 1023, which stall at increasing points in time
+	stalled := map[uint16]time.Duration{7: 10 * time.Second, 500: 20 * time.Second, 1023: 30 * time.Second}
+	for elapsed := time.Second; elapsed <= time.Minute; elapsed += time.Second {
+		for vbNo := uint16(0); vbNo < feedVbLagNumVbuckets; vbNo++ {
+			if stallTime, ok := stalled[vbNo]; ok && elapsed > stallTime {
+				continue
+			}
+			if vbNo != 10 && vbNo != 20 && elapsed%(10*time.Second) != 0 {
+				continue
+			}
+			tracker.eventProcessed(vbNo, uint64(elapsed.Seconds())*10000+uint64(vbNo), start.Add(elapsed))
+		}
+	}
+
+	now := start.Add(time.Minute)
+	laggards := tracker.laggards(3, now)
+	require.Len(t, laggards, 3)
+	assert.Equal(t, uint16(7), laggards[0].VbNo)
+	assert.Equal(t, float64(50), laggards[0].LagSecs)
+	assert.Equal(t, uint64(10*10000+7), laggards[0].LastCas)
+	assert.Equal(t, uint16(500), laggards[1].VbNo)
+	assert.Equal(t, float64(40), laggards[1].LagSecs)
+	assert.Equal(t, uint16(1023), laggards[2].VbNo)
+	assert.Equal(t, float64(30), laggards[2].LagSecs)
+	assert.Equal(t, 50*time.Second, tracker.maxLag(now))
+
+	// Report includes all active vbuckets when count exceeds the number of vbuckets
+	assert.Len(t, tracker.laggards(2*feedVbLagNumVbuckets, now), feedVbLagNumVbuckets)
+	assert.Len(t, tracker.laggards(0, now), 0)
+
+	// Out of range vbuckets are ignored
+	tracker.eventProcessed(feedVbLagNumVbuckets, 1, start)
+	assert.Len(t, tracker.laggards(2*feedVbLagNumVbuckets, now), feedVbLagNumVbuckets)
+}
@@ -25,19 +25,23 @@ import (
 // Options for changes-feeds.  ChangesOptions must not contain any mutable pointer references, as
 // changes processing currently assumes a deep copy when doing chanOpts := changesOptions.
 type ChangesOptions struct {
-	Since       SequenceID      // sequence # to start _after_
-	Limit       int             // Max number of changes to return, if nonzero
-	Conflicts   bool            // Show all conflicting revision IDs, not just winning one?
-	IncludeDocs bool            // Include doc body of each change?
-	Wait        bool            // Wait for results, instead of immediately returning empty result?
-	Continuous  bool            // Run continuously until terminated?
-	Terminator  chan bool       // Caller can close this channel to terminate the feed
-	HeartbeatMs uint64          // How often to send a heartbeat to the client
-	TimeoutMs   uint64          // After this amount of time, close the longpoll connection
-	ActiveOnly  bool            // If true, only return information on non-deleted, non-removed revisions
-	Revocations bool            // Specifies whether revocation messages should be sent on the changes feed
-	clientType  clientType      // Can be used to determine if the replication is being started from a CBL 2.x or SGR2 client
-	Ctx         context.Context // Used for adding context to logs
+	Since             SequenceID      // sequence # to start _after_
+	Limit             int             // Max number of changes to return, if nonzero
+	Conflicts         bool            // Show all conflicting revision IDs, not just winning one?
+	IncludeDocs       bool            // Include doc body of each change?
+	Wait              bool            // Wait for results, instead of immediately returning empty result?
+	Continuous        bool            // Run continuously until terminated?
+	Terminator        chan bool       // Caller can close this channel to terminate the feed
+	HeartbeatMs       uint64          // How often to send a heartbeat to the client
+	TimeoutMs         uint64          // After this amount of time, close the longpoll connection
+	ActiveOnly        bool            // If true, only return information on non-deleted, non-removed revisions
+	Revocations       bool            // Specifies whether revocation messages should be sent on the changes feed
+	NoInitialBackfill bool            // If true, channels granted to the user are fed from the grant sequence, without their earlier history
+	Collection        string          // Collection the channels belong to, empty for the default collection
+	Descending        bool            // Channel cache reads return the newest entries first, with Limit applying from the highest sequence
+	clientType        clientType      // Can be used to determine if the replication is being started from a CBL 2.x or SGR2 client
+	omitsExpired      bool            // Every tombstone older than the client partition window is omitted for Since - see Database.omitsExpiredTombstones
+	Ctx               context.Context // Used for adding context to logs
 }
 
 // A changes entry; Database.GetChanges returns an array of these.
@@ -47,6 +51,7 @@ type ChangeEntry struct {
 	ID           string          `json:"id"`
 	Deleted      bool            `json:"deleted,omitempty"`
 	Removed      base.Set        `json:"removed,omitempty"`
+	RemovedRev   string          `json:"removed_rev,omitempty"` // Revision that removed the document from the channels in Removed
 	Doc          json.RawMessage `json:"doc,omitempty"`
 	Changes      []ChangeRev     `json:"changes"`
 	Err          error           `json:"err,omitempty"` // Used to notify feed consumer of errors
@@ -94,6 +99,9 @@ func (db *Database) addDocToChangeEntry(entry *ChangeEntry, options ChangesOptio
 		return
 	}
 
+	_, span := base.StartSpan(options.Ctx, "changes.doc", base.SpanAttribute{Key: "conflicts", Value: includeConflicts})
+	defer span.End()
+
 	// Three options for retrieving document content, depending on what's required:
 	//   includeConflicts only:
 	//      - Retrieve document metadata from bucket (required to identify current set of conflicts)
@@ -181,6 +189,10 @@ func (db *Database) buildRevokedFeed(singleChannelCache SingleChannelCache, opti
 	paginationOptions := options
 	paginationOptions.Since.Seq = 0
 	paginationOptions.Since.LowSeq = 0
+	if paginationOptions.Ctx == nil {
+		// Attributes any channel query to the request
+		paginationOptions.Ctx = db.Ctx
+	}
 
 	// If this replication has since been interrupted we'll be given a triggered by seq in the since request and can use
 	// this to resume.
@@ -347,6 +359,36 @@ func (db *Database) wasDocInChannelAtSeq(docID, chanName string, since uint64) (
 	return false, nil
 }
 
+// sequenceClockTolerance allows for sequences allocated before a deletion being written after it, and for the
+// deletion time being truncated to the second, when comparing a since sequence's time with the deletion time.
+const sequenceClockTolerance = time.Minute
+
+// isExpiredTombstoneForSince returns true when FilterExpiredTombstones is enabled and logEntry is a tombstone older
+// than the client partition window, which a client resuming from since doesn't need - the client has synced since
+// the deletion, so has either been sent the tombstone already or never had the document.  Clients whose since
+// predates the deletion, or can't be dated, are still sent the tombstone.
+func (db *Database) isExpiredTombstoneForSince(logEntry *LogEntry, since SequenceID) bool {
+	if !db.Options.FilterExpiredTombstones || logEntry.TimeDeleted.IsZero() || logEntry.Flags&channels.Deleted == 0 {
+		return false
+	}
+	if time.Since(logEntry.TimeDeleted) <= db.Options.ClientPartitionWindow {
+		return false
+	}
+	sinceTime, ok := db.changeCache.sequenceClock.timeOf(since.SafeSequence())
+	return ok && sinceTime.After(logEntry.TimeDeleted.Add(sequenceClockTolerance))
+}
+
+// omitsExpiredTombstones returns true when every tombstone older than the client partition window is omitted from a
+// changes feed resuming from since, as the client has synced since the start of the window.  Channel caches only need
+// to backfill the expired tombstones they've evicted for other clients.
+func (db *Database) omitsExpiredTombstones(since SequenceID) bool {
+	if !db.Options.FilterExpiredTombstones {
+		return false
+	}
+	sinceTime, ok := db.changeCache.sequenceClock.timeOf(since.SafeSequence())
+	return ok && sinceTime.After(time.Now().Add(sequenceClockTolerance-db.Options.ClientPartitionWindow))
+}
+
 // Creates a Go-channel of all the changes made on a channel.
 // Does NOT handle the Wait option. Does NOT check authorization.
 func (db *Database) changesFeed(singleChannelCache SingleChannelCache, options ChangesOptions, to string) <-chan *ChangeEntry {
@@ -362,6 +404,17 @@ func (db *Database) changesFeed(singleChannelCache SingleChannelCache, options C
 	paginationOptions := options
 	paginationOptions.Since.Seq = options.Since.SafeSequence()
 	paginationOptions.Since.LowSeq = 0
+	paginationOptions.omitsExpired = db.omitsExpiredTombstones(options.Since)
+	if paginationOptions.Ctx == nil {
+		// Attributes any channel query to the request
+		paginationOptions.Ctx = db.Ctx
+	}
+
+	// Entries in (LowSeq, Seq] the client has already received aren't re-sent, when enabled
+	var received *receivedWindow
+	if db.Options.UnsupportedOptions.NormalizeLowSeqSince {
+		received = newReceivedWindow(singleChannelCache, options.Since)
+	}
 
 	go func() {
 		defer base.FatalPanicHandler()
@@ -371,7 +424,7 @@ func (db *Database) changesFeed(singleChannelCache SingleChannelCache, options C
 		// Pagination based on ChannelQueryLimit.  This loop may terminated in three ways (see return statements):
 		//   1. Query returns fewer rows than ChannelQueryLimit
 		//   2. A limit is specified on the incoming ChangesOptions, and that limit is reached
-		//   3. An error is returned when calling singleChannelCache.GetChanges
+		//   3. An error is returned when calling singleChannelCache.GetChangesStream
 		for {
 			// Calculate limit for this iteration
 			if requestLimit == 0 {
@@ -381,22 +434,17 @@ func (db *Database) changesFeed(singleChannelCache SingleChannelCache, options C
 				paginationOptions.Limit = base.MinInt(remainingLimit, queryLimit)
 			}
 
-			// TODO: pass db.Ctx down to changeCache?
 			base.TracefCtx(db.Ctx, base.KeyChanges, "Querying channel %q with options: %+v", base.UD(singleChannelCache.ChannelName()), paginationOptions)
-			changes, err := singleChannelCache.GetChanges(paginationOptions)
-			if err != nil {
-				base.WarnfCtx(db.Ctx, "Error retrieving changes for channel %q: %v", base.UD(singleChannelCache.ChannelName()), err)
-				change := ChangeEntry{
-					Err: base.ErrChannelFeed,
-				}
-				feed <- &change
-				return
-			}
-			base.DebugfCtx(db.Ctx, base.KeyChanges, "[changesFeed] Found %d changes for channel %q", len(changes), base.UD(singleChannelCache.ChannelName()))
+			readOptions := paginationOptions
+			var span base.Span
+			readOptions.Ctx, span = base.StartSpan(paginationOptions.Ctx, "cache.get_changes", base.SpanAttribute{Key: "limit", Value: readOptions.Limit})
 
-			// Now write each log entry to the 'feed' channel in turn:
+			// Write each log entry to the 'feed' channel as it's streamed from the cache:
+			numChanges := 0
 			sentChanges := 0
-			for _, logEntry := range changes {
+			terminated := false
+			err := singleChannelCache.GetChangesStream(readOptions, func(logEntry *LogEntry) bool {
+				numChanges++
 				if logEntry.Sequence >= options.Since.TriggeredBy {
 					options.Since.TriggeredBy = 0
 				}
@@ -410,21 +458,45 @@ func (db *Database) changesFeed(singleChannelCache SingleChannelCache, options C
 
 				// Don't include deletes or removals during initial channel backfill
 				if options.Since.TriggeredBy > 0 && (change.Deleted || len(change.Removed) > 0) {
-					continue
+					return true
+				}
+
+				if db.isExpiredTombstoneForSince(logEntry, options.Since) {
+					return true
+				}
+
+				if received.received(logEntry) {
+					return true
 				}
 
 				base.DebugfCtx(db.Ctx, base.KeyChanges, "Channel feed processing seq:%v in channel %s %s", seqID, base.UD(singleChannelCache.ChannelName()), base.UD(to))
 				select {
 				case <-options.Terminator:
-					base.DebugfCtx(db.Ctx, base.KeyChanges, "Terminating channel feed %s", base.UD(to))
-					return
+					terminated = true
+					return false
 				case feed <- &change:
 					sentChanges++
+					return true
+				}
+			})
+			span.SetAttributes(base.SpanAttribute{Key: "entries", Value: numChanges})
+			span.End()
+			if terminated || err == ErrChangesStreamTerminated {
+				base.DebugfCtx(db.Ctx, base.KeyChanges, "Terminating channel feed %s", base.UD(to))
+				return
+			}
+			if err != nil {
+				base.WarnfCtx(db.Ctx, "Error retrieving changes for channel %q: %v", base.UD(singleChannelCache.ChannelName()), err)
+				change := ChangeEntry{
+					Err: base.ErrChannelFeed,
 				}
+				feed <- &change
+				return
 			}
+			base.DebugfCtx(db.Ctx, base.KeyChanges, "[changesFeed] Streamed %d changes for channel %q", numChanges, base.UD(singleChannelCache.ChannelName()))
 
 			// If the query returned fewer results than the pagination limit, we're done
-			if len(changes) < paginationOptions.Limit {
+			if numChanges < paginationOptions.Limit {
 				return
 			}
 
@@ -452,6 +524,7 @@ func makeChangeEntry(logEntry *LogEntry, seqID SequenceID, channelName string) C
 
 	if logEntry.Flags&channels.Removed != 0 {
 		change.Removed = base.SetOf(channelName)
+		change.RemovedRev = logEntry.RemovedAtRev
 	}
 
 	return change
@@ -638,6 +711,7 @@ func (db *Database) SimpleMultiChangesFeed(chans base.Set, options ChangesOption
 		} else {
 			channelsSince = channels.AtSequence(chans, 0)
 		}
+		channelsSince = db.normalizeChannelsSince(channelsSince, options.Collection)
 
 		// Mark channel set as active, schedule defer
 		db.activeChannels.IncrChannels(channelsSince)
@@ -698,7 +772,7 @@ func (db *Database) SimpleMultiChangesFeed(chans base.Set, options ChangesOption
 
 				// Obtain a SingleChannelCache instance to use for both normal and late feeds.  Required to ensure consistency
 				// if cache is evicted during processing
-				singleChannelCache := db.changeCache.getChannelCache().getSingleChannelCache(name)
+				singleChannelCache := db.changeCache.getChannelCache().getSingleChannelCache(NewChannelID(options.Collection, name))
 
 				// Set up late sequence handling first, as we need to roll back the regular feed on error
 				// Handles previously skipped sequences prior to options.Since that
@@ -711,7 +785,7 @@ func (db *Database) SimpleMultiChangesFeed(chans base.Set, options ChangesOption
 						if err != nil {
 							base.WarnfCtx(db.Ctx, "MultiChangesFeed got error reading late sequence feed %q, rolling back channel changes feed to last sent low sequence #%d.", base.UD(name), lastSentLowSeq)
 							chanOpts.Since.LowSeq = lastSentLowSeq
-							if lateFeed := db.newLateSequenceFeed(singleChannelCache); lateFeed != nil {
+							if lateFeed := db.newLateSequenceFeed(singleChannelCache, options.Collection); lateFeed != nil {
 								lateSequenceFeeds[name] = lateFeed
 							}
 						} else {
@@ -722,7 +796,7 @@ func (db *Database) SimpleMultiChangesFeed(chans base.Set, options ChangesOption
 						}
 					} else {
 						// Initialize lateSequenceFeeds[name] for next iteration
-						if lateFeed := db.newLateSequenceFeed(singleChannelCache); lateFeed != nil {
+						if lateFeed := db.newLateSequenceFeed(singleChannelCache, options.Collection); lateFeed != nil {
 							lateSequenceFeeds[name] = lateFeed
 						}
 					}
@@ -761,7 +835,15 @@ func (db *Database) SimpleMultiChangesFeed(chans base.Set, options ChangesOption
 
 				backfillInOtherChannel := options.Since.TriggeredBy != 0 && options.Since.TriggeredBy > seqAddedAt
 
-				if isNewChannel || (backfillRequired && backfillPending) {
+				if (isNewChannel || (backfillRequired && backfillPending)) && options.NoInitialBackfill {
+					// Newly added channel, but backfill isn't wanted - start from the grant, even when the feed has
+					// already passed it, as the channel's changes since the grant weren't visible to the user when the
+					// feed passed them.  The sequences sent for this channel don't have TriggeredBy set, so resuming
+					// from them doesn't initiate a backfill.
+					if seqAddedAt > 0 {
+						chanOpts.Since = SequenceID{Seq: seqAddedAt - 1}
+					}
+				} else if isNewChannel || (backfillRequired && backfillPending) {
 					// Newly added channel so initiate backfill:
 					chanOpts.Since = SequenceID{Seq: 0, TriggeredBy: seqAddedAt}
 				} else if backfillInOtherChannel {
@@ -798,7 +880,7 @@ func (db *Database) SimpleMultiChangesFeed(chans base.Set, options ChangesOption
 
 				channelsToRevoke := db.user.RevokedChannels(revocationSinceSeq)
 				for channel, revokedSeq := range channelsToRevoke {
-					feed := db.buildRevokedFeed(db.changeCache.getChannelCache().getSingleChannelCache(channel), options, revokedSeq, revocationSinceSeq, to)
+					feed := db.buildRevokedFeed(db.changeCache.getChannelCache().getSingleChannelCache(NewChannelID(options.Collection, channel)), options, revokedSeq, revocationSinceSeq, to)
 					feeds = append(feeds, feed)
 				}
 			}
@@ -863,6 +945,9 @@ func (db *Database) SimpleMultiChangesFeed(chans base.Set, options ChangesOption
 							} else {
 								minEntry.Removed = minEntry.Removed.Union(cur.Removed)
 							}
+							if minEntry.RemovedRev == "" {
+								minEntry.RemovedRev = cur.RemovedRev
+							}
 						}
 					}
 				}
@@ -990,6 +1075,7 @@ func (db *Database) SimpleMultiChangesFeed(chans base.Set, options ChangesOption
 			}
 			if userChanged && db.user != nil {
 				newChannelsSince, _ := db.user.FilterToAvailableChannels(chans)
+				newChannelsSince = db.normalizeChannelsSince(newChannelsSince, options.Collection)
 				changedChannels = newChannelsSince.CompareKeys(channelsSince)
 				if len(changedChannels) > 0 {
 					db.activeChannels.UpdateChanged(changedChannels)
@@ -1037,26 +1123,35 @@ func (db *Database) GetChanges(channels base.Set, options ChangesOptions) ([]*Ch
 // Returns the set of cached log entries for a given channel
 func (db *Database) GetChangeLog(channelName string, afterSeq uint64) (entries []*LogEntry) {
 
-	return db.changeCache.getChannelCache().GetCachedChanges(channelName)
+	return db.changeCache.getChannelCache().GetCachedChanges(NewDefaultChannelID(channelName))
 }
 
-// WaitForSequenceNotSkipped blocks until the given sequence has been received or skipped by the change cache.
+// WaitForSequence blocks until the given sequence has been received or skipped by the change cache, up to the
+// database's sequence wait timeout.
 func (dbc *DatabaseContext) WaitForSequence(ctx context.Context, sequence uint64) (err error) {
 	base.Debugf(base.KeyChanges, "Waiting for sequence: %d", sequence)
-	return dbc.changeCache.waitForSequence(ctx, sequence, base.DefaultWaitForSequence)
+	return dbc.changeCache.waitForSequence(ctx, sequence, dbc.sequenceWaitTimeout())
 }
 
 // WaitForSequenceNotSkipped blocks until the given sequence has been received by the change cache without being skipped.
 func (dbc *DatabaseContext) WaitForSequenceNotSkipped(ctx context.Context, sequence uint64) (err error) {
 	base.Debugf(base.KeyChanges, "Waiting for sequence: %d", sequence)
-	return dbc.changeCache.waitForSequenceNotSkipped(ctx, sequence, base.DefaultWaitForSequence)
+	return dbc.changeCache.waitForSequenceNotSkipped(ctx, sequence, dbc.sequenceWaitTimeout())
 }
 
 // WaitForPendingChanges blocks until the change-cache has caught up with the latest writes to the database.
 func (dbc *DatabaseContext) WaitForPendingChanges(ctx context.Context) (err error) {
 	lastSequence, err := dbc.LastSequence()
 	base.Debugf(base.KeyChanges, "Waiting for sequence: %d", lastSequence)
-	return dbc.changeCache.waitForSequence(ctx, lastSequence, base.DefaultWaitForSequence)
+	return dbc.changeCache.waitForSequence(ctx, lastSequence, dbc.sequenceWaitTimeout())
+}
+
+// sequenceWaitTimeout returns the maximum time to wait for a sequence to be cached.
+func (dbc *DatabaseContext) sequenceWaitTimeout() time.Duration {
+	if dbc.Options.CacheOptions != nil && dbc.Options.CacheOptions.SequenceWaitTimeout > 0 {
+		return dbc.Options.CacheOptions.SequenceWaitTimeout
+	}
+	return base.DefaultWaitForSequence
 }
 
 // Late Sequence Feed
@@ -1065,13 +1160,14 @@ type lateSequenceFeed struct {
 	active           bool      // Whether the changes feed is still serving the channel this feed is associated with
 	lastSequence     uint64    // Last late sequence processed on the feed
 	channelName      string    // Channel Name
+	collection       string    // Collection of the channel, empty for the default collection
 	lateSequenceUUID uuid.UUID // Ensures cache doesn't change underneath us
 }
 
 // Returns a lateSequenceFeed for the channel, used to find late-arriving (previously
 // skipped) sequences that have been sent to the channel cache.  The lateSequenceFeed stores the last (late)
 // sequence seen by this particular _changes feed to support continuous changes.
-func (db *Database) newLateSequenceFeed(singleChannelCache SingleChannelCache) *lateSequenceFeed {
+func (db *Database) newLateSequenceFeed(singleChannelCache SingleChannelCache, collection string) *lateSequenceFeed {
 
 	if !singleChannelCache.SupportsLateFeed() {
 		return nil
@@ -1081,6 +1177,7 @@ func (db *Database) newLateSequenceFeed(singleChannelCache SingleChannelCache) *
 		active:           true,
 		lateSequenceUUID: singleChannelCache.LateSequenceUUID(),
 		channelName:      singleChannelCache.ChannelName(),
+		collection:       collection,
 		lastSequence:     singleChannelCache.RegisterLateSequenceClient(),
 	}
 	return lsf
@@ -1138,7 +1235,7 @@ func (db *Database) getLateFeed(feedHandler *lateSequenceFeed, singleChannelCach
 
 // Closes a single late sequence feed.
 func (db *Database) closeLateFeed(feedHandler *lateSequenceFeed) {
-	singleChannelCache := db.changeCache.getChannelCache().getSingleChannelCache(feedHandler.channelName)
+	singleChannelCache := db.changeCache.getChannelCache().getSingleChannelCache(NewChannelID(feedHandler.collection, feedHandler.channelName))
 	if !singleChannelCache.SupportsLateFeed() {
 		return
 	}
@@ -1211,6 +1308,7 @@ func createChangesEntry(docid string, db *Database, options ChangesOptions) *Cha
 	row.SetBranched((populatedDoc.Flags & channels.Branched) != 0)
 
 	var removedChannels []string
+	var removedSeq uint64
 
 	userCanSeeDocChannel := false
 
@@ -1230,6 +1328,10 @@ func createChangesEntry(docid string, db *Database, options ChangesOptions) *Cha
 					if removal.Deleted {
 						row.Deleted = true
 					}
+					if removal.Seq > removedSeq {
+						removedSeq = removal.Seq
+						row.RemovedRev = removal.RevID
+					}
 				}
 			}
 		}
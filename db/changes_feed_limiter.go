@@ -0,0 +1,86 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"sync/atomic"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// ChangesFeedLimits caps the number of concurrently active continuous, longpoll and websocket changes feeds.  Zero
+// means no limit.
+type ChangesFeedLimits struct {
+	Max     int // Feeds over this limit are rejected
+	SoftMax int // Guest feeds over this limit are rejected, so that they're shed before authenticated users' feeds
+}
+
+// ChangesFeedLimiter tracks the number of active changes feeds, and rejects new feeds over its limits.  Limits can be
+// changed while feeds are active, and apply to new feeds only.
+type ChangesFeedLimiter struct {
+	max         int64            // Accessed atomically
+	softMax     int64            // Accessed atomically
+	active      int64            // Accessed atomically
+	activeStat  *base.SgwIntStat // Active feed count gauge, optional
+	rejectsStat *base.SgwIntStat // Rejected feed counter, optional
+}
+
+func NewChangesFeedLimiter(limits ChangesFeedLimits, activeStat, rejectsStat *base.SgwIntStat) *ChangesFeedLimiter {
+	l := &ChangesFeedLimiter{activeStat: activeStat, rejectsStat: rejectsStat}
+	l.SetLimits(limits)
+	return l
+}
+
+// SetLimits replaces the limiter's limits.
+func (l *ChangesFeedLimiter) SetLimits(limits ChangesFeedLimits) {
+	atomic.StoreInt64(&l.max, int64(limits.Max))
+	atomic.StoreInt64(&l.softMax, int64(limits.SoftMax))
+}
+
+// Limits returns the limiter's current limits.
+func (l *ChangesFeedLimiter) Limits() ChangesFeedLimits {
+	return ChangesFeedLimits{
+		Max:     int(atomic.LoadInt64(&l.max)),
+		SoftMax: int(atomic.LoadInt64(&l.softMax)),
+	}
+}
+
+// Active returns the number of active feeds.
+func (l *ChangesFeedLimiter) Active() int64 {
+	return atomic.LoadInt64(&l.active)
+}
+
+// Acquire counts a new feed, returning false if it's over the limits, in which case it must not be started.  Feeds
+// that are acquired must be released when they end.
+func (l *ChangesFeedLimiter) Acquire(guest bool) bool {
+	active := atomic.AddInt64(&l.active, 1)
+	max := atomic.LoadInt64(&l.max)
+	softMax := atomic.LoadInt64(&l.softMax)
+	if (max > 0 && active > max) || (guest && softMax > 0 && active > softMax) {
+		atomic.AddInt64(&l.active, -1)
+		if l.rejectsStat != nil {
+			l.rejectsStat.Add(1)
+		}
+		return false
+	}
+	if l.activeStat != nil {
+		l.activeStat.Add(1)
+	}
+	return true
+}
+
+// Release uncounts a feed that was acquired.
+func (l *ChangesFeedLimiter) Release() {
+	atomic.AddInt64(&l.active, -1)
+	if l.activeStat != nil {
+		l.activeStat.Add(-1)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+// A composite since value LowSeq::Seq tells the feed that the client has received every sequence up to LowSeq, and
+// every sequence in (LowSeq, Seq] except those that were skipped when it was sent.  Without normalization, the feed
+// reads each channel from LowSeq, re-sending everything in (LowSeq, Seq] so that any of the skipped sequences that
+// have since arrived are sent.  With normalization enabled, the entries in (LowSeq, Seq] that the client provably
+// received aren't re-sent.  For each form of since and state of the skipped sequences:
+//
+//   since            | skipped state on this node            | channel read
+//   -----------------+---------------------------------------+----------------------------------------------------
+//   Seq              | any                                   | from Seq
+//   LowSeq::Seq      | oldest skipped is LowSeq+1            | from Seq - LowSeq is ignored by the feed, so later
+//                    |                                       | arrivals in (LowSeq, Seq] wait for LowSeq+1
+//   LowSeq::Seq      | any other oldest skipped, or none     | from LowSeq.  When the channel's cache is valid from
+//                    | skipped (recovered or abandoned)      | LowSeq+1 or earlier, and (LowSeq, Seq] was cached
+//                    |                                       | from the feed rather than read by query, entries that
+//                    |                                       | were cached in sequence order are omitted, and out of
+//                    |                                       | order (late) entries sent.  Otherwise all are sent
+//   LowSeq:TB:Seq    | any                                   | from LowSeq, all sent - backfill handling is unchanged
+//   LowSeq::Seq with | any                                   | from Seq - LowSeq isn't meaningful, and is dropped
+//   LowSeq >= Seq    |                                       | when the since value is formatted
+//
+// An entry cached in sequence order was cached before Seq was, so was sent to the client with Seq - but only by this
+// node, as skipped state differs between nodes.  Normalization is therefore only correct when clients resume on the
+// node that issued their since value, and is opt-in.  Entries that arrive out of order after the read window is
+// established are still sent, as they were skipped when the current iteration's low sequence was computed.
+
+// receivedWindow identifies the entries in a channel that a client resuming from a composite since value has already
+// received.
+type receivedWindow struct {
+	lowSeq uint64              // Client has received every sequence up to lowSeq
+	seq    uint64              // Client has received every in-order sequence in (lowSeq, seq]
+	late   map[uint64]struct{} // Out of order sequences in (lowSeq, seq] cached when the window was established
+}
+
+// newReceivedWindow returns the entries in the channel that a client resuming from since has received, or nil when
+// they can't be established from the channel's cache.
+func newReceivedWindow(singleChannelCache SingleChannelCache, since SequenceID) *receivedWindow {
+	if since.LowSeq == 0 || since.LowSeq >= since.Seq || since.TriggeredBy != 0 {
+		return nil
+	}
+
+	// Entries from queries aren't flagged as out of order, so the window must be cached, from entries received over the
+	// feed - a cache rebuilt by query after eviction can't identify late entries.  Out of order entries are noted now,
+	// so that they're still recognized if the feed's read is served by a query.
+	validFrom, entries := singleChannelCache.GetCachedChanges(ChangesOptions{Since: SequenceID{Seq: since.LowSeq}})
+	if validFrom > since.LowSeq+1 {
+		return nil
+	}
+	w := &receivedWindow{lowSeq: since.LowSeq, seq: since.Seq}
+	for _, entry := range entries {
+		if entry.Sequence > since.Seq {
+			break
+		}
+		if entry.FromQuery {
+			return nil
+		}
+		if entry.OutOfOrder {
+			if w.late == nil {
+				w.late = make(map[uint64]struct{})
+			}
+			w.late[entry.Sequence] = struct{}{}
+		}
+	}
+	return w
+}
+
+// received returns true if the client has already received entry.
+func (w *receivedWindow) received(entry *LogEntry) bool {
+	if w == nil || entry.Sequence <= w.lowSeq || entry.Sequence > w.seq || entry.OutOfOrder {
+		return false
+	}
+	_, late := w.late[entry.Sequence]
+	return !late
+}
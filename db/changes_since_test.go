@@ -0,0 +1,218 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Validates the entries a client is considered to have received, for each form of since value.
+func TestReceivedWindow(t *testing.T) {
+
+	newCache := func(validFrom uint64) *singleChannelCacheImpl {
+		cache := newChannelCacheWithOptions(&testQueryHandler{}, "ABC", validFrom, ChannelCacheOptions{},
+			(base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache())
+		// 3 and 4 were skipped, and 3 arrived after 6
+		for _, seq := range []uint64{1, 2, 5, 6, 3, 7} {
+			entry := testLogEntry(seq, fmt.Sprintf("doc_%d", seq), "1-a")
+			entry.OutOfOrder = seq == 3
+			cache.addToCache(entry, false)
+		}
+		return cache
+	}
+	cache := newCache(0)
+
+	// Since forms that don't establish a window - all entries are sent
+	for _, since := range []SequenceID{
+		{Seq: 6},
+		{LowSeq: 2, TriggeredBy: 4, Seq: 6},
+		{LowSeq: 6, Seq: 6},
+		{LowSeq: 7, Seq: 6},
+	} {
+		assert.Nil(t, newReceivedWindow(cache, since), "Unexpected window for since %+v", since)
+	}
+
+	// The cache doesn't cover the window - all entries are sent
+	assert.Nil(t, newReceivedWindow(newCache(4), SequenceID{LowSeq: 2, Seq: 6}))
+
+	// Entries read by query in the window can't be identified as late - all entries are sent
+	queryCache := newCache(0)
+	queryEntry := testLogEntry(8, "doc_8", "1-a")
+	queryEntry.FromQuery = true
+	queryCache.addToCache(queryEntry, false)
+	assert.NotNil(t, newReceivedWindow(queryCache, SequenceID{LowSeq: 2, Seq: 6}))
+	assert.Nil(t, newReceivedWindow(queryCache, SequenceID{LowSeq: 2, Seq: 8}))
+
+	// In order entries in (LowSeq, Seq] have been received, out of order entries and those outside the window haven't
+	window := newReceivedWindow(cache, SequenceID{LowSeq: 2, Seq: 6})
+	require.NotNil(t, window)
+	_, cached := cache.GetCachedChanges(ChangesOptions{Since: SequenceID{Seq: 0}})
+	received := make(map[uint64]bool)
+	for _, entry := range cached {
+		received[entry.Sequence] = window.received(entry)
+	}
+	assert.Equal(t, map[uint64]bool{1: false, 2: false, 3: false, 5: true, 6: true, 7: false}, received)
+
+	// Out of order entries noted from the cache aren't considered received when read from a query
+	assert.False(t, window.received(testLogEntry(3, "doc_3", "1-a")))
+	assert.True(t, window.received(testLogEntry(5, "doc_5", "1-a")))
+
+	var nilWindow *receivedWindow
+	assert.False(t, nilWindow.received(testLogEntry(5, "doc_5", "1-a")))
+}
+
+// Validates that one-shot changes requests resuming from a LowSeq::Seq since value only re-send entries that
+// arrived out of order when normalization is enabled, and re-send everything in (LowSeq, Seq] when it isn't.
+func TestChangesSinceLowSeqNormalization(t *testing.T) {
+
+	if base.TestUseXattrs() {
+		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
+	}
+
+	defer base.SetUpTestLogging(base.LevelDebug, base.KeyCache, base.KeyChanges)()
+
+	for _, normalize := range []bool{true, false} {
+		t.Run(fmt.Sprintf("normalize=%t", normalize), func(t *testing.T) {
+			cacheOptions := shortWaitCache()
+			db := setupTestDBWithOptions(t, DatabaseContextOptions{
+				CacheOptions:       &cacheOptions,
+				UnsupportedOptions: UnsupportedOptions{NormalizeLowSeqSince: normalize},
+			})
+			defer db.Close()
+			db.ChannelMapper = channels.NewDefaultChannelMapper()
+
+			authenticator := db.Authenticator()
+			user, err := authenticator.NewUser("naomi", "letmein", channels.SetOf(t, "ABC"))
+			require.NoError(t, err)
+			require.NoError(t, authenticator.Save(user))
+			db.user, err = authenticator.GetUser("naomi")
+			require.NoError(t, err)
+
+			getChanges := func(since SequenceID) (sequences []uint64, lastSeq SequenceID) {
+				changes, err := db.GetChanges(base.SetOf("*"), ChangesOptions{Since: since})
+				require.NoError(t, err)
+				for _, change := range changes {
+					sequences = append(sequences, change.Seq.Seq)
+					lastSeq = change.Seq
+				}
+				return sequences, lastSeq
+			}
+
+			// Simulate seq 3 and 4 being delayed - write 1,2,5,6
+			WriteDirect(db, []string{"ABC"}, 1)
+			WriteDirect(db, []string{"ABC"}, 2)
+			WriteDirect(db, []string{"ABC"}, 5)
+			WriteDirect(db, []string{"ABC"}, 6)
+			require.NoError(t, db.changeCache.waitForSequence(context.TODO(), 6, base.DefaultWaitForSequence))
+
+			sequences, lastSeq := getChanges(SequenceID{})
+			assert.Equal(t, []uint64{1, 2, 5, 6}, sequences)
+			assert.Equal(t, SequenceID{LowSeq: 2, Seq: 6}, lastSeq)
+
+			// Oldest skipped is still LowSeq+1 - LowSeq is ignored, nothing is re-sent
+			sequences, _ = getChanges(lastSeq)
+			assert.Empty(t, sequences)
+
+			// 3 arrives - only it is sent when normalizing
+			WriteDirect(db, []string{"ABC"}, 3)
+			require.NoError(t, db.changeCache.waitForSequenceNotSkipped(context.TODO(), 3, base.DefaultWaitForSequence))
+			sequences, _ = getChanges(lastSeq)
+			if normalize {
+				assert.Equal(t, []uint64{3}, sequences)
+			} else {
+				assert.Equal(t, []uint64{3, 5, 6}, sequences)
+			}
+
+			// 4 arrives, so none are skipped - 3 is re-sent to a client that hasn't received it
+			WriteDirect(db, []string{"ABC"}, 4)
+			require.NoError(t, db.changeCache.waitForSequenceNotSkipped(context.TODO(), 4, base.DefaultWaitForSequence))
+			sequences, _ = getChanges(lastSeq)
+			if normalize {
+				assert.Equal(t, []uint64{3, 4}, sequences)
+			} else {
+				assert.Equal(t, []uint64{3, 4, 5, 6}, sequences)
+			}
+
+			// A client that received 3 with 3::6 is only sent 4
+			sequences, _ = getChanges(SequenceID{LowSeq: 3, Seq: 6})
+			if normalize {
+				assert.Equal(t, []uint64{4}, sequences)
+			} else {
+				assert.Equal(t, []uint64{4, 5, 6}, sequences)
+			}
+		})
+	}
+}
+
+// Validates that entries re-read by query after a channel's cache is evicted aren't considered received by a client
+// resuming from a LowSeq::Seq since value, as late entries can't be identified - including late entries cached
+// before the eviction, and those delivered after the cache is rebuilt.
+func TestChangesSinceLowSeqNormalizationAfterEviction(t *testing.T) {
+
+	if base.TestUseXattrs() {
+		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
+	}
+
+	defer base.SetUpTestLogging(base.LevelDebug, base.KeyCache, base.KeyChanges)()
+
+	cacheOptions := shortWaitCache()
+	db := setupTestDBWithOptions(t, DatabaseContextOptions{
+		CacheOptions:       &cacheOptions,
+		UnsupportedOptions: UnsupportedOptions{NormalizeLowSeqSince: true},
+	})
+	defer db.Close()
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+
+	authenticator := db.Authenticator()
+	user, err := authenticator.NewUser("naomi", "letmein", channels.SetOf(t, "ABC"))
+	require.NoError(t, err)
+	require.NoError(t, authenticator.Save(user))
+	db.user, err = authenticator.GetUser("naomi")
+	require.NoError(t, err)
+
+	getChanges := func(since SequenceID) (sequences []uint64) {
+		changes, err := db.GetChanges(base.SetOf("*"), ChangesOptions{Since: since})
+		require.NoError(t, err)
+		for _, change := range changes {
+			sequences = append(sequences, change.Seq.Seq)
+		}
+		return sequences
+	}
+
+	// Simulate seq 3 and 4 being delayed - write 1,2,5,6, giving the client a since value of 2::6
+	WriteDirect(db, []string{"ABC"}, 1)
+	WriteDirect(db, []string{"ABC"}, 2)
+	WriteDirect(db, []string{"ABC"}, 5)
+	WriteDirect(db, []string{"ABC"}, 6)
+	require.NoError(t, db.changeCache.waitForSequence(context.TODO(), 6, base.DefaultWaitForSequence))
+	lastSeq := SequenceID{LowSeq: 2, Seq: 6}
+
+	// 3 arrives late, then the channel's cache is evicted and rebuilt by query
+	WriteDirect(db, []string{"ABC"}, 3)
+	require.NoError(t, db.changeCache.waitForSequenceNotSkipped(context.TODO(), 3, base.DefaultWaitForSequence))
+	require.True(t, db.changeCache.RemoveChannelCache(NewDefaultChannelID("ABC")))
+	assert.Equal(t, []uint64{1, 2, 3, 5, 6}, getChanges(SequenceID{}))
+
+	// 3 isn't known to be late once read by query, so isn't omitted
+	assert.Equal(t, []uint64{3, 5, 6}, getChanges(lastSeq))
+
+	// 4 is delivered late to the rebuilt cache
+	WriteDirect(db, []string{"ABC"}, 4)
+	require.NoError(t, db.changeCache.waitForSequenceNotSkipped(context.TODO(), 4, base.DefaultWaitForSequence))
+	assert.Equal(t, []uint64{3, 4, 5, 6}, getChanges(lastSeq))
+}
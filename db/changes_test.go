@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"log"
 	"testing"
+	"time"
 
 	"github.com/couchbase/sync_gateway/base"
 	"github.com/couchbase/sync_gateway/channels"
@@ -267,12 +268,65 @@ func TestDocDeletionFromChannelCoalescedRemoved(t *testing.T) {
 		Seq:        SequenceID{Seq: 2},
 		ID:         "alpha",
 		Removed:    base.SetOf("A"),
+		RemovedRev: "2-e99405a23fa102238fa8c3fd499b15bc",
 		allRemoved: true,
 		Changes:    []ChangeRev{{"rev": "2-e99405a23fa102238fa8c3fd499b15bc"}}})
 
 	printChanges(changes)
 }
 
+// Validates that removal rows identify the revision that removed the document from the channel, for both cached
+// and backfilled removals.
+func TestChannelRemovalRemovedRev(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyChanges, base.KeyCache)()
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+	cacheWaiter := db.NewDCPCachingCountWaiter(t)
+
+	// Sequence 1: doc in channels A and B
+	rev1, _, err := db.Put("doc1", Body{"channels": []string{"A", "B"}})
+	require.NoError(t, err)
+	// Sequence 2: doc removed from channel A
+	rev2, _, err := db.Put("doc1", Body{BodyRev: rev1, "channels": []string{"B"}})
+	require.NoError(t, err)
+	// Sequence 3: subsequent update, doc remains in channel B only
+	rev3, _, err := db.Put("doc1", Body{BodyRev: rev2, "channels": []string{"B"}, "updated": true})
+	require.NoError(t, err)
+	cacheWaiter.AddAndWait(3)
+
+	assertRemovalRow := func(changes []*ChangeEntry) {
+		require.Len(t, changes, 1)
+		assert.Equal(t, uint64(2), changes[0].Seq.Seq)
+		assert.Equal(t, base.SetOf("A"), changes[0].Removed)
+		assert.Equal(t, rev2, changes[0].RemovedRev)
+		assert.Equal(t, []ChangeRev{{"rev": rev2}}, changes[0].Changes)
+	}
+
+	// Removal row from the channel cache
+	changes, err := db.GetChanges(base.SetOf("A"), getZeroSequence())
+	require.NoError(t, err)
+	assertRemovalRow(changes)
+
+	// Removal row from a backfill query
+	require.NoError(t, db.FlushChannelCache())
+	startQueryCount := db.GetChannelQueryCount()
+	changes, err = db.GetChanges(base.SetOf("A"), getZeroSequence())
+	require.NoError(t, err)
+	assert.Equal(t, startQueryCount+1, db.GetChannelQueryCount())
+	assertRemovalRow(changes)
+
+	// Non-removal rows don't include removed_rev
+	changes, err = db.GetChanges(base.SetOf("B"), getZeroSequence())
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, rev3, changes[0].Changes[0]["rev"])
+	assert.Empty(t, changes[0].RemovedRev)
+}
+
 func TestDocDeletionFromChannelCoalesced(t *testing.T) {
 
 	if testing.Short() {
@@ -497,3 +551,52 @@ func BenchmarkChangesFeedDocUnmarshalling(b *testing.B) {
 	}
 
 }
+
+// Validates that tombstones older than the client partition window are only sent to clients whose since predates the
+// deletion.
+func TestExpiredTombstoneFiltering(t *testing.T) {
+	db := setupTestDBWithOptions(t, DatabaseContextOptions{
+		FilterExpiredTombstones: true,
+		ClientPartitionWindow:   base.DefaultClientPartitionWindow,
+	})
+	defer db.Close()
+
+	now := time.Now()
+	daysAgo := func(days int) time.Time {
+		return now.Add(-time.Duration(days) * 24 * time.Hour)
+	}
+
+	// Sequence 10 was written 60 days ago, and sequence 100 20 days ago
+	clock := &db.changeCache.sequenceClock
+	clock.lock.Lock()
+	clock.samples = nil
+	clock.lock.Unlock()
+	clock.record(10, daysAgo(60))
+	clock.record(100, daysAgo(20))
+
+	// A tombstone for a deletion 40 days ago, resequenced by a later update
+	tombstone := &LogEntry{Sequence: 150, DocID: "doc1", RevID: "2-a", Flags: channels.Deleted, TimeDeleted: daysAgo(40)}
+	assert.False(t, db.isExpiredTombstoneForSince(tombstone, SequenceID{Seq: 50}), "Client that synced before the deletion should get the tombstone")
+	assert.False(t, db.isExpiredTombstoneForSince(tombstone, SequenceID{Seq: 5}), "Client whose since can't be dated should get the tombstone")
+	assert.True(t, db.isExpiredTombstoneForSince(tombstone, SequenceID{Seq: 120}), "Client that synced after the deletion shouldn't get the tombstone")
+	assert.True(t, db.isExpiredTombstoneForSince(tombstone, SequenceID{Seq: 140, LowSeq: 120}))
+
+	// Tombstones within the partition window are always sent
+	recentTombstone := &LogEntry{Sequence: 160, DocID: "doc2", RevID: "2-a", Flags: channels.Deleted, TimeDeleted: daysAgo(5)}
+	assert.False(t, db.isExpiredTombstoneForSince(recentTombstone, SequenceID{Seq: 120}))
+
+	// Tombstones backfilled by channel query are given their deletion time
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+	revID, _, err := db.Put("doc3", Body{"channels": []string{"ABC"}})
+	require.NoError(t, err)
+	_, err = db.DeleteDoc("doc3", revID)
+	require.NoError(t, err)
+	entries, err := db.getChangesInChannelFromQuery(context.Background(), "ABC", 0, 0, 0, false)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, entries[0].IsDeleted())
+	assert.WithinDuration(t, time.Now(), entries[0].TimeDeleted, time.Minute)
+
+	db.Options.FilterExpiredTombstones = false
+	assert.False(t, db.isExpiredTombstoneForSince(tombstone, SequenceID{Seq: 120}))
+}
@@ -12,12 +12,13 @@ package db
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"time"
 
 	"github.com/couchbase/go-couchbase"
 	sgbucket "github.com/couchbase/sg-bucket"
 	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
 )
 
 // Unmarshaled JSON structure for "changes" view results
@@ -52,6 +53,10 @@ func nextChannelViewEntry(results sgbucket.QueryResultIterator) (*LogEntry, bool
 		RevID:        viewRow.Value.Rev,
 		Flags:        viewRow.Value.Flags,
 		TimeReceived: time.Now(),
+		FromQuery:    true,
+	}
+	if entry.IsRemoved() {
+		entry.RemovedAtRev = entry.RevID
 	}
 	return entry, true
 
@@ -70,10 +75,12 @@ func nextChannelQueryEntry(results sgbucket.QueryResultIterator) (*LogEntry, boo
 		RevID:        queryRow.Rev,
 		Flags:        queryRow.Flags,
 		TimeReceived: time.Now(),
+		FromQuery:    true,
 	}
 
 	if queryRow.RemovalRev != "" {
 		entry.RevID = queryRow.RemovalRev
+		entry.RemovedAtRev = queryRow.RemovalRev
 		if queryRow.RemovalDel {
 			entry.SetDeleted()
 		}
@@ -83,14 +90,39 @@ func nextChannelQueryEntry(results sgbucket.QueryResultIterator) (*LogEntry, boo
 
 }
 
-// Queries the 'channels' view to get a range of sequences of a single channel as LogEntries.
-func (dbc *DatabaseContext) getChangesInChannelFromQuery(
+// setTombstoneDeletionTimes sets the deletion time of the tombstones returned by a channel query from their sync
+// metadata, as it isn't covered by the channels index.  Tombstones whose sync metadata can't be read are left without
+// a deletion time, so are never omitted as expired.
+func (dbc *DatabaseContext) setTombstoneDeletionTimes(entries LogEntries) {
+	for _, entry := range entries {
+		if entry.Flags&channels.Deleted == 0 || !entry.TimeDeleted.IsZero() {
+			continue
+		}
+		syncData, err := dbc.GetDocSyncData(entry.DocID)
+		if err != nil {
+			base.Debugf(base.KeyCache, "Unable to read deletion time of tombstone %q: %v", base.UD(entry.DocID), err)
+			continue
+		}
+		if syncData.TombstonedAt > 0 {
+			entry.TimeDeleted = time.Unix(syncData.TombstonedAt, 0)
+		}
+	}
+}
+
+// Queries the 'channels' view to get a range of sequences of a single channel as LogEntries.  Queries are attributed to
+// the request in ctx, in slow query logging and - for N1QL queries - by their client context ID.
+func (dbc *DatabaseContext) getChangesInChannelFromQuery(ctx context.Context,
 	channelName string, startSeq, endSeq uint64, limit int, activeOnly bool) (LogEntries, error) {
 	if dbc.Bucket == nil {
-		return nil, errors.New("No bucket available for channel query")
+		return nil, base.ErrDatabaseClosed
 	}
 	start := time.Now()
 	usingViews := dbc.Options.UseViews
+	ctx, span := base.StartSpan(ctx, "query.channel", base.SpanAttribute{Key: "start_seq", Value: startSeq},
+		base.SpanAttribute{Key: "end_seq", Value: endSeq}, base.SpanAttribute{Key: "limit", Value: limit},
+		base.SpanAttribute{Key: "views", Value: usingViews})
+	defer span.End()
+	clientContextID := dbc.channelQueryClientContextID(ctx, channelName)
 
 	entries := make(LogEntries, 0)
 	activeEntryCount := 0
@@ -103,7 +135,7 @@ func (dbc *DatabaseContext) getChangesInChannelFromQuery(
 	for {
 
 		// Query the view or index
-		queryResults, err := dbc.QueryChannels(channelName, startSeq, endSeq, limit, activeOnly)
+		queryResults, err := dbc.queryChannels(channelName, startSeq, endSeq, limit, activeOnly, clientContextID)
 		if err != nil {
 			return nil, err
 		}
@@ -172,23 +204,45 @@ func (dbc *DatabaseContext) getChangesInChannelFromQuery(
 		}
 	}
 
+	if dbc.Options.FilterExpiredTombstones {
+		dbc.setTombstoneDeletionTimes(entries)
+	}
+
 	if len(entries) > 0 {
 		base.Infof(base.KeyCache, "    Got %d rows from query for %q: #%d ... #%d",
 			len(entries), base.UD(channelName), entries[0].Sequence, entries[len(entries)-1].Sequence)
 	}
-	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
-		base.Infof(base.KeyAll, "Channel query took %v to return %d rows.  Channel: %s StartSeq: %d EndSeq: %d Limit: %d",
-			elapsed, len(entries), base.UD(channelName), startSeq, endSeq, limit)
+	if threshold := dbc.Options.SlowQueryWarningThreshold; threshold > 0 {
+		if elapsed := time.Since(start); elapsed > threshold {
+			dbc.DbStats.Cache().ChannelCacheSlowBackfillCount.Add(1)
+			base.InfofCtx(ctx, base.KeyAll, "Channel query took %v to return %d rows.  Channel: %s StartSeq: %d EndSeq: %d Limit: %d ClientContextID: %s",
+				elapsed, len(entries), base.UD(channelName), startSeq, endSeq, limit, clientContextID)
+		}
 	}
 	dbc.DbStats.Cache().ViewQueries.Add(1)
 	return entries, nil
 }
 
-// Queries the 'channels' view to get changes from a channel for the specified sequence.  Used for skipped sequence check
-// before abandoning.
+// channelQueryClientContextID returns the ID identifying a channel query to the query service: the database, the channel
+// - hashed when user data is redacted, as the query service's logs aren't - and the correlation ID of the request in ctx.
+func (dbc *DatabaseContext) channelQueryClientContextID(ctx context.Context, channelName string) string {
+	if base.RedactUserData {
+		channelName = base.Sha1HashString(channelName, "")
+	}
+	var correlationID string
+	if ctx != nil {
+		if logCtx, ok := ctx.Value(base.LogContextKey{}).(base.LogContext); ok {
+			correlationID = logCtx.CorrelationID
+		}
+	}
+	return fmt.Sprintf("sgw:%s:%s:%s", dbc.Name, channelName, correlationID)
+}
+
+// Queries for the changes at the specified sequences - using the 'channels' view, or the allDocs index when views are
+// disabled (see QuerySequences).  Used for skipped sequence check before abandoning.
 func (dbc *DatabaseContext) getChangesForSequences(ctx context.Context, sequences []uint64) (LogEntries, error) {
 	if dbc.Bucket == nil {
-		return nil, errors.New("No bucket available for sequence query")
+		return nil, base.ErrDatabaseClosed
 	}
 	start := time.Now()
 	usingViews := dbc.Options.UseViews
@@ -236,5 +290,5 @@ func (dbc *DatabaseContext) getChangesForSequences(ctx context.Context, sequence
 
 // Public channel view call - for unit test support
 func (dbc *DatabaseContext) ChannelViewTest(channelName string, startSeq, endSeq uint64) (LogEntries, error) {
-	return dbc.getChangesInChannelFromQuery(channelName, startSeq, endSeq, 0, false)
+	return dbc.getChangesInChannelFromQuery(context.Background(), channelName, startSeq, endSeq, 0, false)
 }
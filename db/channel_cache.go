@@ -13,6 +13,10 @@ package db
 import (
 	"context"
 	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,6 +28,37 @@ const (
 	MinimumChannelCacheMaxNumber = 100 // Minimum size for channel cache capacity
 )
 
+// Sort keys for ListChannelCaches
+const (
+	ChannelCacheSortName       = "name"        // Ascending channel name
+	ChannelCacheSortSize       = "size"        // Largest cache first
+	ChannelCacheSortLastAccess = "last_access" // Most recently read cache first
+)
+
+// ChannelCacheInfo summarizes a single channel cache, for diagnostics.
+type ChannelCacheInfo struct {
+	Collection string    `json:"collection,omitempty"` // Omitted for the default collection
+	Name       string    `json:"name"`
+	Size       int       `json:"size"`        // Number of cached entries
+	ValidFrom  uint64    `json:"valid_from"`  // Sequence the cache is complete from
+	LastAccess time.Time `json:"last_access"` // Time the cache was last read
+}
+
+// ChannelCacheEntry describes a single channel cache entry, for diagnostics.
+type ChannelCacheEntry struct {
+	Sequence     uint64    `json:"seq"`
+	DocID        string    `json:"id"` // Tagged as user data when user data redaction is enabled
+	RevID        string    `json:"rev"`
+	Removal      bool      `json:"removal,omitempty"` // Whether the doc was removed from the channel at this revision
+	TimeReceived time.Time `json:"time_received"`
+}
+
+// ChannelCacheContents is a single channel cache's summary and a range of its entries, for diagnostics.
+type ChannelCacheContents struct {
+	ChannelCacheInfo
+	Entries []ChannelCacheEntry `json:"entries"`
+}
+
 var (
 	DefaultChannelCacheMinLength       = 50               // Keep at least this many entries in cache
 	DefaultChannelCacheMaxLength       = 500              // Don't put more than this many entries in cache
@@ -38,7 +73,7 @@ type ChannelCache interface {
 	// Initializes the cache high sequence value
 	Init(initialSequence uint64)
 
-	// Adds an entry to the cache, returns set of channels it was added to
+	// Adds an entry to the cache, returns set of channels it was added to, as ChannelID strings
 	AddToCache(change *LogEntry) []string
 
 	// Notifies the cache of a principal update.  Updates the cache's high sequence
@@ -47,11 +82,31 @@ type ChannelCache interface {
 	// Remove purges the given doc IDs from all channel caches and returns the number of items removed.
 	Remove(docIDs []string, startTime time.Time) (count int)
 
+	// RemoveUpToSequence purges the entries for the given doc IDs with sequences up to and including purgeSeq from all
+	// channel caches, and returns the number of items removed.
+	RemoveUpToSequence(docIDs []string, purgeSeq uint64) (count int)
+
+	// Rollback removes the entries for which isRolledBack returns true from all channel caches, returning the number of
+	// entries removed and the channels they were removed from, as ChannelID strings.
+	Rollback(isRolledBack func(*LogEntry) bool) (count int, channelNames []string)
+
+	// Revalidate drops the entries of all channel caches and makes them valid from validFrom, when the entries preceding
+	// validFrom may not have been cached.  Caches created later are also valid from validFrom.
+	Revalidate(validFrom uint64)
+
+	// RevisionsPruned updates the entries for docID referencing any of prunedRevIDs in the caches of the channels in
+	// docChannels, for the update at sequence that pruned them.  Returns the number of entries dropped and replaced.
+	// Documents belong to the default collection.
+	RevisionsPruned(docID string, prunedRevIDs base.Set, currentRev string, sequence uint64, docChannels channels.ChannelMap) (dropped, replaced int)
+
 	// Returns set of changes for a given channel, within the bounds specified in options
-	GetChanges(channelName string, options ChangesOptions) ([]*LogEntry, error)
+	GetChanges(channelID ChannelID, options ChangesOptions) ([]*LogEntry, error)
+
+	// Reads the changes for a given channel for streaming, within the bounds specified in options
+	readChanges(channelID ChannelID, options ChangesOptions) (changeStream, error)
 
 	// Returns the set of all cached data for a given channel (intended for diagnostic usage)
-	GetCachedChanges(channelName string) []*LogEntry
+	GetCachedChanges(channelID ChannelID) []*LogEntry
 
 	// Clear reinitializes the cache to an empty state
 	Clear()
@@ -63,8 +118,42 @@ type ChannelCache interface {
 	// Returns the highest cached sequence, used for changes synchronization
 	GetHighCacheSequence() uint64
 
+	// Returns up to limit channel cache summaries following cursor, in sortKey order (intended for diagnostic usage)
+	ListChannelCaches(sortKey string, limit int, cursor string) (infos []ChannelCacheInfo, nextCursor string, err error)
+
+	// Returns up to limit entries after since from the channel's cache, without creating or touching the cache (intended
+	// for diagnostic usage).  Returns false if the channel isn't cached
+	GetChannelCacheContents(channelID ChannelID, since uint64, limit int) (contents *ChannelCacheContents, ok bool)
+
+	// Removes the channel's cache, returning false if the channel isn't cached
+	RemoveChannelCache(channelID ChannelID) bool
+
+	// Returns the ID of the channel whose cache serves channelID, under the channel name normalization policy
+	NormalizeChannelID(channelID ChannelID) ChannelID
+
+	// Changes the channel name normalization policy, merging or dropping the caches of channels whose names normalize
+	// to the same channel.  Returns the number of caches merged or dropped
+	setChannelNameNormalization(policy string) (count int)
+
+	// Returns all of the channel's cached entries and the sequence the cache is valid from, without creating or touching
+	// the cache.  Returns false if the channel isn't cached
+	getCachedEntries(channelID ChannelID) (validFrom uint64, entries []*LogEntry, ok bool)
+
+	// Calls callback with each cached channel's entries, in ChannelID string order, until callback returns false.
+	// Doesn't create or touch caches (intended for test and diagnostic usage)
+	forEachCachedChannel(callback func(channelID ChannelID, entries []*LogEntry) bool)
+
+	// Creates the channel's cache from entries handed off by another node, valid from validFrom, with the entries up to
+	// provisionalTo marked as provisional.  Returns false if the channel is already cached, is never cached, or the
+	// cache is at capacity
+	seedChannelCache(channelID ChannelID, validFrom uint64, entries LogEntries, provisionalTo uint64) bool
+
+	// Checks a replayed change preceding the initial sequence against the provisional entries of seeded caches, and
+	// drops them from any cache missing the change.  Returns the number of caches invalidated
+	verifySeededChannels(docID string, sequence uint64, docChannels channels.ChannelMap) (invalidated int)
+
 	// Access to individual channel cache
-	getSingleChannelCache(channelName string) SingleChannelCache
+	getSingleChannelCache(channelID ChannelID) SingleChannelCache
 
 	// Stop stops the channel cache and it's background tasks.
 	Stop()
@@ -72,14 +161,14 @@ type ChannelCache interface {
 
 // ChannelQueryHandler interface is implemented by databaseContext.
 type ChannelQueryHandler interface {
-	getChangesInChannelFromQuery(channelName string, startSeq, endSeq uint64, limit int, activeOnly bool) (LogEntries, error)
+	getChangesInChannelFromQuery(ctx context.Context, channelName string, startSeq, endSeq uint64, limit int, activeOnly bool) (LogEntries, error)
 }
 
 type StableSequenceCallbackFunc func() uint64
 
 type channelCacheImpl struct {
 	queryHandler         ChannelQueryHandler       // Passed to singleChannelCacheImpl for view queries.
-	channelCaches        *base.RangeSafeCollection // A collection of singleChannelCaches
+	channelCaches        *base.RangeSafeCollection // A collection of singleChannelCaches, keyed by ChannelID string
 	backgroundTasks      []BackgroundTask          // List of background tasks specific to channel cache.
 	dbName               string                    // Name of the database associated with the channel cache.
 	terminator           chan bool                 // Signal terminator of background goroutines
@@ -94,15 +183,20 @@ type channelCacheImpl struct {
 	activeChannels       *channels.ActiveChannels  // Active channel handler
 	cacheStats           *base.CacheStats          // Map used for cache stats
 	validFromLock        sync.RWMutex              // Mutex used to avoid race between AddToCache and addChannelCache.  See CBG-520 for more details
-}
-
-func NewChannelCacheForContext(options ChannelCacheOptions, context *DatabaseContext) (*channelCacheImpl, error) {
-	return newChannelCache(context.Name, options, context, context.activeChannels, context.DbStats.Cache())
+	emptyChannels        map[string]bool           // Channels known to have no entries (true) or pending confirmation by query (false), by ChannelID string.  Guarded by validFromLock
+	seeded               base.AtomicBool           // Set once any channel cache has been seeded from a cache handoff snapshot
+	lowercaseNames       base.AtomicBool           // Whether channel names are lowercased, under the lowercase channel name normalization policy
+	nameVariants         map[string]base.Set       // Channel names seen that normalize to a different name, by normalized ChannelID string.  Guarded by validFromLock
+	uncachedChannels     *channelNamePatterns      // Channels that are never cached, from UncachedChannelPatterns.  Nil when all channels are cached
 }
 
 func newChannelCache(dbName string, options ChannelCacheOptions, queryHandler ChannelQueryHandler,
 	activeChannels *channels.ActiveChannels, cacheStats *base.CacheStats) (*channelCacheImpl, error) {
 
+	uncachedChannels, err := newChannelNamePatterns(options.UncachedChannelPatterns)
+	if err != nil {
+		return nil, err
+	}
 	channelCache := &channelCacheImpl{
 		queryHandler:         queryHandler,
 		channelCaches:        base.NewRangeSafeCollection(),
@@ -114,7 +208,12 @@ func newChannelCache(dbName string, options ChannelCacheOptions, queryHandler Ch
 		compactLowWatermark:  int(math.Round(float64(options.CompactLowWatermarkPercent) / 100 * float64(options.MaxNumChannels))),
 		activeChannels:       activeChannels,
 		cacheStats:           cacheStats,
+		emptyChannels:        make(map[string]bool),
+		nameVariants:         make(map[string]base.Set),
+		uncachedChannels:     uncachedChannels,
 	}
+	channelCache.lowercaseNames.Set(options.ChannelNameNormalization == ChannelNamesLowercase)
+	channelCache.queryHandler = &normalizedQueryHandler{queryHandler: queryHandler, channelCache: channelCache}
 	bgt, err := NewBackgroundTask("CleanAgedItems", dbName, channelCache.cleanAgedItems, options.ChannelCacheAge, channelCache.terminator)
 	if err != nil {
 		return nil, err
@@ -129,6 +228,10 @@ func (c *channelCacheImpl) Clear() {
 	c.seqLock.Lock()
 	c.channelCaches.Init()
 	c.seqLock.Unlock()
+
+	c.validFromLock.Lock()
+	c.emptyChannels = make(map[string]bool)
+	c.validFromLock.Unlock()
 }
 
 // Stop stops the channel cache and it's background tasks.
@@ -171,9 +274,9 @@ func (c *channelCacheImpl) updateHighCacheSequence(sequence uint64) {
 
 // GetSingleChannelCache will create the cache for the channel if it doesn't exist.  If the cache is at
 // capacity, will return a bypass channel cache.
-func (c *channelCacheImpl) getSingleChannelCache(channelName string) SingleChannelCache {
+func (c *channelCacheImpl) getSingleChannelCache(channelID ChannelID) SingleChannelCache {
 
-	return c.getChannelCache(channelName)
+	return c.getChannelCache(channelID)
 }
 
 func (c *channelCacheImpl) AddPrincipal(change *LogEntry) {
@@ -187,6 +290,11 @@ func (c *channelCacheImpl) AddToCache(change *LogEntry) (updatedChannels []strin
 	ch := change.Channels
 	change.Channels = nil // not needed anymore, so free some memory
 
+	// The entry is shared by each channel's cache, so must be checksummed before it's added to any of them
+	if c.options.EntryChecksums {
+		change.Checksum = logEntryChecksum(change)
+	}
+
 	// updatedChannels tracks the set of channels that should be notified of the change.  This includes
 	// the change's active channels, as well as any channel removals for the active revision.
 	updatedChannels = make([]string, 0, len(ch))
@@ -204,14 +312,19 @@ func (c *channelCacheImpl) AddToCache(change *LogEntry) (updatedChannels []strin
 	// any new caches that are added between the check for c.GetActiveChannelCache and the update of
 	// c.highCacheSequence are initialized with the correct validFrom.
 	var explicitStarChannel bool
+	starChannelID := logEntryChannelID(change, channels.UserStarChannel)
 	c.validFromLock.Lock()
+	ch = c._normalizeChannelMap(change.Collection, ch)
 	for channelName, removal := range ch {
 		if removal == nil || removal.Seq == change.Sequence {
+			channelID := logEntryChannelID(change, channelName)
+			delete(c.emptyChannels, channelID.String())
 			// If the document has been explicitly added to the star channel by the sync function, don't need to recheck below
 			if channelName == channels.UserStarChannel {
 				explicitStarChannel = true
 			}
-			channelCache, ok := c.getActiveChannelCache(channelName)
+			// Uncached channels never have an active cache - they're only notified
+			channelCache, ok := c.getActiveChannelCache(channelID)
 			if ok {
 				channelCache.addToCache(change, removal != nil)
 				if change.Skipped {
@@ -219,21 +332,26 @@ func (c *channelCacheImpl) AddToCache(change *LogEntry) (updatedChannels []strin
 				}
 			}
 			// Need to notify even if channel isn't active, for case where number of connected changes channels exceeds cache capacity
-			updatedChannels = append(updatedChannels, channelName)
+			updatedChannels = append(updatedChannels, channelID.String())
 		}
 	}
 
-	if EnableStarChannelLog && !explicitStarChannel {
-		channelCache, ok := c.getActiveChannelCache(channels.UserStarChannel)
-		if ok {
-			channelCache.addToCache(change, false)
-			if change.Skipped {
-				channelCache.AddLateSequence(change)
+	if !explicitStarChannel {
+		if c.options.EnableStarChannel {
+			channelCache, ok := c.getActiveChannelCache(starChannelID)
+			if ok {
+				channelCache.addToCache(change, false)
+				if change.Skipped {
+					channelCache.AddLateSequence(change)
+				}
 			}
 		}
-		updatedChannels = append(updatedChannels, channels.UserStarChannel)
+		// Changes feeds for "*" are notified even when it isn't cached, as they query for it instead
+		updatedChannels = append(updatedChannels, starChannelID.String())
 	}
 
+	delete(c.emptyChannels, starChannelID.String())
+
 	c.updateHighCacheSequence(change.Sequence)
 	c.validFromLock.Unlock()
 	return updatedChannels
@@ -262,14 +380,102 @@ func (c *channelCacheImpl) Remove(docIDs []string, startTime time.Time) (count i
 	return count
 }
 
-func (c *channelCacheImpl) GetChanges(channelName string, options ChangesOptions) ([]*LogEntry, error) {
+func (c *channelCacheImpl) RemoveUpToSequence(docIDs []string, purgeSeq uint64) (count int) {
+	if len(docIDs) == 0 {
+		return 0
+	}
 
-	return c.getChannelCache(channelName).GetChanges(options)
+	c.channelCaches.Range(func(v interface{}) bool {
+		channelCache := AsSingleChannelCache(v)
+		if channelCache == nil {
+			return false
+		}
+		count += channelCache.RemoveUpToSequence(docIDs, purgeSeq)
+		return true
+	})
+	return count
+}
+
+// Rollback removes the entries for which isRolledBack returns true from all channel caches.  Holds the late sequence
+// lock, so that changes feeds see the late logs of all channels either before or after the rollback.
+func (c *channelCacheImpl) Rollback(isRolledBack func(*LogEntry) bool) (count int, channelNames []string) {
+	c.lateSeqLock.Lock()
+	defer c.lateSeqLock.Unlock()
+
+	rollbackCallback := func(v interface{}) bool {
+		channelCache := AsSingleChannelCache(v)
+		if channelCache == nil {
+			return false
+		}
+
+		if removed := channelCache.rollback(isRolledBack); removed > 0 {
+			count += removed
+			channelNames = append(channelNames, channelCache.channelID().String())
+		}
+		return true
+	}
+
+	c.channelCaches.Range(rollbackCallback)
+
+	return count, channelNames
 }
 
-func (c *channelCacheImpl) GetCachedChanges(channelName string) []*LogEntry {
+// Revalidate drops the entries of all channel caches and makes them valid from validFrom.  Holds validFromLock so that
+// caches created concurrently are valid from validFrom, and any record of channels being empty is discarded, as entries
+// may have been added to them without being cached.
+func (c *channelCacheImpl) Revalidate(validFrom uint64) {
+	c.validFromLock.Lock()
+	defer c.validFromLock.Unlock()
+	c.emptyChannels = make(map[string]bool)
+	c.updateHighCacheSequence(validFrom - 1)
+	c.channelCaches.Range(func(v interface{}) bool {
+		if channelCache := AsSingleChannelCache(v); channelCache != nil {
+			channelCache.revalidate(validFrom)
+		}
+		return true
+	})
+	base.Infof(base.KeyCache, "Revalidated channel caches for %s from #%d", base.MD(c.dbName), validFrom)
+}
+
+// RevisionsPruned updates the entries for docID referencing any of prunedRevIDs in the caches of the channels in
+// docChannels, and the star channel, for the update at sequence that pruned them.  Entries are superseded in the
+// channels the update is cached in - those the doc is still in, or was removed from by the update.
+func (c *channelCacheImpl) RevisionsPruned(docID string, prunedRevIDs base.Set, currentRev string, sequence uint64, docChannels channels.ChannelMap) (dropped, replaced int) {
+	update := func(channelName string, superseded bool) {
+		channelCache, ok := c.getActiveChannelCache(NewDefaultChannelID(channelName))
+		if !ok {
+			return
+		}
+		entryDropped, entryReplaced := channelCache.revisionsPruned(docID, prunedRevIDs, currentRev, superseded)
+		if entryDropped {
+			dropped++
+		}
+		if entryReplaced {
+			replaced++
+		}
+	}
+
+	for channelName, removal := range docChannels {
+		update(channelName, removal == nil || removal.Seq == sequence)
+	}
+	if _, explicitStarChannel := docChannels[channels.UserStarChannel]; !explicitStarChannel {
+		update(channels.UserStarChannel, true)
+	}
+	return dropped, replaced
+}
+
+func (c *channelCacheImpl) GetChanges(channelID ChannelID, options ChangesOptions) ([]*LogEntry, error) {
+
+	return c.getChannelCache(channelID).GetChanges(options)
+}
+
+func (c *channelCacheImpl) readChanges(channelID ChannelID, options ChangesOptions) (changeStream, error) {
+	return c.getChannelCache(channelID).readChanges(options)
+}
+
+func (c *channelCacheImpl) GetCachedChanges(channelID ChannelID) []*LogEntry {
 	options := ChangesOptions{Since: SequenceID{Seq: 0}}
-	_, changes := c.getChannelCache(channelName).GetCachedChanges(options)
+	_, changes := c.getChannelCache(channelID).GetCachedChanges(options)
 	return changes
 }
 
@@ -289,26 +495,46 @@ func (c *channelCacheImpl) cleanAgedItems(ctx context.Context) error {
 	return nil
 }
 
-func (c *channelCacheImpl) getChannelCache(channelName string) SingleChannelCache {
+func (c *channelCacheImpl) getChannelCache(channelID ChannelID) SingleChannelCache {
 
-	cacheValue, found := c.channelCaches.Get(channelName)
+	channelID = c.NormalizeChannelID(channelID)
+	cacheValue, found := c.channelCaches.Get(channelID.String())
 	if found {
 		return AsSingleChannelCache(cacheValue)
 	}
 
+	// The star channel is always queried when it isn't cached, so isn't counted as a bypass due to capacity
+	if channelID.Name == channels.UserStarChannel && !c.options.EnableStarChannel {
+		return c.newBypassChannelCache(channelID)
+	}
+
+	// Uncached channels are always queried, so also aren't counted as a bypass due to capacity
+	if c.isUncachedChannel(channelID) {
+		return c.newBypassChannelCache(channelID)
+	}
+
 	// Attempt to add a singleChannelCache for the channel name.  If unsuccessful, return a bypass channel cache
-	singleChannelCache, ok := c.addChannelCache(channelName)
+	singleChannelCache, ok := c.addChannelCache(channelID)
 	if ok {
 		return singleChannelCache
 	}
 
+	bypassChannelCache := c.newBypassChannelCache(channelID)
+	c.cacheStats.ChannelCacheBypassCount.Add(1)
+	return bypassChannelCache
+
+}
+
+func (c *channelCacheImpl) newBypassChannelCache(channelID ChannelID) *bypassChannelCache {
 	bypassChannelCache := &bypassChannelCache{
-		channelName:  channelName,
+		channelName:  channelID.Name,
 		queryHandler: c.queryHandler,
+		channelCache: c,
+	}
+	if !channelID.IsDefaultCollection() {
+		bypassChannelCache.collection = channelID.Collection
 	}
-	c.cacheStats.ChannelCacheBypassCount.Add(1)
 	return bypassChannelCache
-
 }
 
 // Converts an RangeSafeCollection value to a singleChannelCacheImpl.  On type
@@ -329,20 +555,29 @@ func AsSingleChannelCache(cacheValue interface{}) *singleChannelCacheImpl {
 //	//     4. addChannelCache initializes cache with validFrom=10 and adds to c.channelCaches
 //	//  This scenario would result in sequence 11 missing from the cache.  Locking seqLock ensures that
 //	//  step 3 blocks until step 4 is complete (and so sees the channel as active)
-func (c *channelCacheImpl) addChannelCache(channelName string) (*singleChannelCacheImpl, bool) {
+func (c *channelCacheImpl) addChannelCache(channelID ChannelID) (*singleChannelCacheImpl, bool) {
 
-	// Return nil if the cache at capacity.
-	if c.channelCaches.Length() >= c.maxChannels {
+	// Return nil if the cache at capacity, or the channel isn't cached.
+	if c.channelCaches.Length() >= c.maxChannels || c.isUncachedChannel(channelID) {
 		return nil, false
 	}
 
+	channelID = c.NormalizeChannelID(channelID)
 	c.validFromLock.Lock()
 
 	// Everything after the current high sequence will be added to the cache via the feed
 	validFrom := c.GetHighCacheSequence() + 1
 
-	singleChannelCache := newChannelCacheWithOptions(c.queryHandler, channelName, validFrom, c.options, c.cacheStats)
-	cacheValue, created, cacheSize := c.channelCaches.GetOrInsert(channelName, singleChannelCache)
+	// A channel known to have no entries is complete from the start, and doesn't need to be backfilled by query
+	key := channelID.String()
+	if c.emptyChannels[key] {
+		validFrom = 1
+		delete(c.emptyChannels, key)
+		c.cacheStats.ChannelCacheNegativeHits.Add(1)
+	}
+
+	singleChannelCache := newCollectionChannelCache(c.queryHandler, channelID, validFrom, c.options, c.cacheStats)
+	cacheValue, created, cacheSize := c.channelCaches.GetOrInsert(key, singleChannelCache)
 	c.validFromLock.Unlock()
 
 	singleChannelCache = AsSingleChannelCache(cacheValue)
@@ -359,9 +594,17 @@ func (c *channelCacheImpl) addChannelCache(channelName string) (*singleChannelCa
 	return singleChannelCache, true
 }
 
-func (c *channelCacheImpl) getActiveChannelCache(channelName string) (*singleChannelCacheImpl, bool) {
+// isUncachedChannel returns true if the channel's normalized name matches any of the UncachedChannelPatterns.
+func (c *channelCacheImpl) isUncachedChannel(channelID ChannelID) bool {
+	if c.uncachedChannels == nil || channelID.Name == channels.UserStarChannel {
+		return false
+	}
+	return c.uncachedChannels.matches(c.NormalizeChannelID(channelID).Name)
+}
 
-	cacheValue, found := c.channelCaches.Get(channelName)
+func (c *channelCacheImpl) getActiveChannelCache(channelID ChannelID) (*singleChannelCacheImpl, bool) {
+
+	cacheValue, found := c.channelCaches.Get(c.NormalizeChannelID(channelID).String())
 	if !found {
 		return nil, false
 	}
@@ -388,6 +631,184 @@ func (c *channelCacheImpl) MaxCacheSize() int {
 	return maxCacheSize
 }
 
+// ListChannelCaches returns up to limit (unbounded when limit <= 0) channel cache summaries in sortKey order, starting
+// after the given cursor.  nextCursor is non-empty when further caches may follow.  The channel names are snapshotted
+// up front and each cache is inspected individually, so the listing doesn't block cache updates.  Ties in size or
+// access time are ordered by name, so a listing paged through with cursors covers every channel present throughout
+// exactly once.
+func (c *channelCacheImpl) ListChannelCaches(sortKey string, limit int, cursor string) (infos []ChannelCacheInfo, nextCursor string, err error) {
+	if sortKey == "" {
+		sortKey = ChannelCacheSortName
+	}
+	if sortKey != ChannelCacheSortName && sortKey != ChannelCacheSortSize && sortKey != ChannelCacheSortLastAccess {
+		return nil, "", base.HTTPErrorf(http.StatusBadRequest, "Invalid channel cache sort key %q", sortKey)
+	}
+	var after *channelCacheCursor
+	if cursor != "" {
+		if after, err = parseChannelCacheCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	names := c.channelCaches.Keys()
+	more := false
+	if sortKey == ChannelCacheSortName {
+		// Only the caches being returned need to be inspected
+		sort.Strings(names)
+		if after != nil {
+			names = names[sort.SearchStrings(names, after.name):]
+			if len(names) > 0 && names[0] == after.name {
+				names = names[1:]
+			}
+		}
+		for _, name := range names {
+			if limit > 0 && len(infos) == limit {
+				more = true
+				break
+			}
+			if cache, ok := c.getActiveChannelCache(ParseChannelID(name)); ok {
+				infos = append(infos, cache.info())
+			}
+		}
+	} else {
+		for _, name := range names {
+			cache, ok := c.getActiveChannelCache(ParseChannelID(name))
+			if !ok {
+				continue
+			}
+			info := cache.info()
+			if after != nil && !after.less(newChannelCacheCursor(sortKey, &info)) {
+				continue
+			}
+			infos = append(infos, info)
+		}
+		sort.Slice(infos, func(i, j int) bool {
+			return newChannelCacheCursor(sortKey, &infos[i]).less(newChannelCacheCursor(sortKey, &infos[j]))
+		})
+		if limit > 0 && len(infos) > limit {
+			infos = infos[:limit]
+			more = true
+		}
+	}
+
+	if more && len(infos) > 0 {
+		nextCursor = newChannelCacheCursor(sortKey, &infos[len(infos)-1]).String()
+	}
+	return infos, nextCursor, nil
+}
+
+// GetChannelCacheContents returns the channel cache's summary and up to limit (unbounded when limit <= 0) of its
+// entries with sequences after since.  Reads the cache's published snapshot, so doesn't block cache updates, and
+// doesn't mark the cache as recently used.
+func (c *channelCacheImpl) GetChannelCacheContents(channelID ChannelID, since uint64, limit int) (*ChannelCacheContents, bool) {
+	cache, ok := c.getActiveChannelCache(channelID)
+	if !ok {
+		return nil, false
+	}
+	contents := &ChannelCacheContents{ChannelCacheInfo: cache.info()}
+	_, logs := cache.snapshot.Load().(*channelCacheSnapshot).getCachedChanges(since, limit)
+	contents.Entries = make([]ChannelCacheEntry, 0, len(logs))
+	for _, entry := range logs {
+		contents.Entries = append(contents.Entries, ChannelCacheEntry{
+			Sequence:     entry.Sequence,
+			DocID:        base.UD(entry.DocID).Redact(),
+			RevID:        entry.RevID,
+			Removal:      entry.IsRemoved(),
+			TimeReceived: entry.TimeReceived,
+		})
+	}
+	return contents, true
+}
+
+func (c *channelCacheImpl) getCachedEntries(channelID ChannelID) (validFrom uint64, entries []*LogEntry, ok bool) {
+	cache, ok := c.getActiveChannelCache(channelID)
+	if !ok {
+		return 0, nil, false
+	}
+	validFrom, entries = cache.snapshot.Load().(*channelCacheSnapshot).getCachedChanges(0, 0)
+	return validFrom, entries, true
+}
+
+// forEachCachedChannel calls callback with the entries of each cached channel, in ChannelID string order, until
+// callback returns false.  Each channel's entries are read from its published snapshot, so caches may be updated
+// between calls.
+func (c *channelCacheImpl) forEachCachedChannel(callback func(channelID ChannelID, entries []*LogEntry) bool) {
+	names := c.channelCaches.Keys()
+	sort.Strings(names)
+	for _, name := range names {
+		channelID := ParseChannelID(name)
+		_, entries, ok := c.getCachedEntries(channelID)
+		if ok && !callback(channelID, entries) {
+			return
+		}
+	}
+}
+
+// RemoveChannelCache drops a single channel's cache.  The cache is recreated on next use, valid from the sequence
+// following the high cache sequence, so reads before that are backfilled by query.  Holds validFromLock so that the
+// removal can't interleave with AddToCache or addChannelCache.  Any record of the channel being empty is also
+// discarded, so the recreated cache doesn't rely on it.
+func (c *channelCacheImpl) RemoveChannelCache(channelID ChannelID) bool {
+	c.validFromLock.Lock()
+	defer c.validFromLock.Unlock()
+	return c._removeChannelCache(c.NormalizeChannelID(channelID).String())
+}
+
+// _removeChannelCache drops the cache for the channel with ChannelID string key.  Requires validFromLock.
+func (c *channelCacheImpl) _removeChannelCache(key string) bool {
+	delete(c.emptyChannels, key)
+	if _, found := c.channelCaches.Get(key); !found {
+		return false
+	}
+	c.channelCaches.Remove(key)
+	c.cacheStats.ChannelCacheNumChannels.Add(-1)
+	base.Infof(base.KeyCache, "Removed channel cache for %q", base.UD(key))
+	return true
+}
+
+// channelCacheCursor is a position in a ListChannelCaches ordering.  value is the sort key's value, negated for
+// descending orderings (zero when sorting by name), and ties are broken by name - the ChannelID string, which is the
+// channel name for the default collection.
+type channelCacheCursor struct {
+	value int64
+	name  string
+}
+
+func newChannelCacheCursor(sortKey string, info *ChannelCacheInfo) *channelCacheCursor {
+	cursor := &channelCacheCursor{name: NewChannelID(info.Collection, info.Name).String()}
+	switch sortKey {
+	case ChannelCacheSortSize:
+		cursor.value = -int64(info.Size)
+	case ChannelCacheSortLastAccess:
+		cursor.value = -info.LastAccess.UnixNano()
+	}
+	return cursor
+}
+
+// parseChannelCacheCursor parses a cursor in the "value:name" form returned by String.
+func parseChannelCacheCursor(cursor string) (*channelCacheCursor, error) {
+	parts := strings.SplitN(cursor, ":", 2)
+	if len(parts) != 2 {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "Invalid channel cache cursor %q", cursor)
+	}
+	value, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "Invalid channel cache cursor %q", cursor)
+	}
+	return &channelCacheCursor{value: value, name: parts[1]}, nil
+}
+
+func (cc *channelCacheCursor) less(other *channelCacheCursor) bool {
+	if cc.value != other.value {
+		return cc.value < other.value
+	}
+	return cc.name < other.name
+}
+
+func (cc *channelCacheCursor) String() string {
+	return strconv.FormatInt(cc.value, 10) + ":" + cc.name
+}
+
 func (c *channelCacheImpl) isCompactActive() bool {
 	return c.compactRunning.IsTrue()
 }
@@ -496,6 +917,7 @@ func (c *channelCacheImpl) compactChannelCache() {
 			}
 		}
 
+		c.rememberEmptyChannels(evictionElements)
 		cacheSize = c.channelCaches.RemoveElements(evictionElements)
 
 		// Update eviction stats
@@ -505,6 +927,63 @@ func (c *channelCacheImpl) compactChannelCache() {
 	}
 }
 
+// rememberEmptyChannels records the channels of evicted caches that are known to have no entries, so that recreating
+// their caches doesn't require a backfill query.  The number of channels remembered is bounded by maxChannels.
+func (c *channelCacheImpl) rememberEmptyChannels(evictionElements []*base.AppendOnlyListElement) {
+	c.validFromLock.Lock()
+	defer c.validFromLock.Unlock()
+	for _, elem := range evictionElements {
+		singleChannelCache, ok := elem.Value.(*singleChannelCacheImpl)
+		if !ok || !singleChannelCache.isEmpty() {
+			continue
+		}
+		if len(c.emptyChannels) >= c.maxChannels {
+			return
+		}
+		c.emptyChannels[singleChannelCache.channelID().String()] = true
+	}
+}
+
+// isChannelEmpty returns true when the channel is known to have no entries.
+func (c *channelCacheImpl) isChannelEmpty(channelID ChannelID) bool {
+	c.validFromLock.RLock()
+	defer c.validFromLock.RUnlock()
+	return c.emptyChannels[channelID.String()]
+}
+
+// beginEmptyCheck is called before a query for all of a channel's entries, and returns false if the result can't be
+// remembered.  An entry added to the channel before endEmptyCheck means that an empty query result is out of date.
+func (c *channelCacheImpl) beginEmptyCheck(channelID ChannelID) bool {
+	c.validFromLock.Lock()
+	defer c.validFromLock.Unlock()
+	key := channelID.String()
+	if _, ok := c.emptyChannels[key]; ok {
+		return true
+	}
+	if len(c.emptyChannels) >= c.maxChannels {
+		return false
+	}
+	c.emptyChannels[key] = false
+	return true
+}
+
+// endEmptyCheck records whether a query for all of a channel's entries found none, unless an entry has been added to
+// the channel since beginEmptyCheck.
+func (c *channelCacheImpl) endEmptyCheck(channelID ChannelID, empty bool) {
+	c.validFromLock.Lock()
+	defer c.validFromLock.Unlock()
+	key := channelID.String()
+	confirmed, ok := c.emptyChannels[key]
+	if !ok || confirmed {
+		return
+	}
+	if empty {
+		c.emptyChannels[key] = true
+	} else {
+		delete(c.emptyChannels, key)
+	}
+}
+
 // Updates cache stats
 func (c *channelCacheImpl) updateEvictionStats(inactiveEvicted int, totalEvicted int, startTime time.Time) {
 	// Eviction stats
@@ -0,0 +1,151 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// ChannelAuditReport is the result of comparing a range of a channel's cached entries with the channel query.
+type ChannelAuditReport struct {
+	Channel       string                    `json:"channel"`
+	FromSeq       uint64                    `json:"from_seq"`                 // First sequence compared - no earlier than the sequence the cache is valid from
+	ToSeq         uint64                    `json:"to_seq"`                   // Last sequence compared - no later than the last cached sequence
+	Truncated     bool                      `json:"truncated,omitempty"`      // The row cap was reached, so ToSeq is the last sequence returned by the query
+	CachedCount   int                       `json:"cached_count"`             // Cached entries in the range
+	QueryCount    int                       `json:"query_count"`              // Query entries in the range
+	OnlyInCache   []ChannelAuditEntry       `json:"only_in_cache,omitempty"`  // Cached entries the query didn't return
+	OnlyInQuery   []ChannelAuditEntry       `json:"only_in_query,omitempty"`  // Query entries missing from the cache
+	RevMismatches []ChannelAuditRevMismatch `json:"rev_mismatches,omitempty"` // Sequences cached and queried with different revisions
+	Consistent    bool                      `json:"consistent"`               // No differences were found, other than entries flagged as inactive
+}
+
+// ChannelAuditEntry identifies an entry found by only one side of a channel audit.
+type ChannelAuditEntry struct {
+	Sequence uint64 `json:"seq"`
+	DocID    string `json:"id"` // Tagged as user data when user data redaction is enabled
+	RevID    string `json:"rev"`
+	Inactive bool   `json:"inactive,omitempty"` // The cached revision is no longer the document's current revision, so is legitimately missing from the query
+}
+
+// ChannelAuditRevMismatch identifies a sequence the cache and query disagree on the revision of.
+type ChannelAuditRevMismatch struct {
+	Sequence    uint64 `json:"seq"`
+	DocID       string `json:"id"` // Tagged as user data when user data redaction is enabled
+	CachedRevID string `json:"cached_rev"`
+	QueryRevID  string `json:"query_rev"`
+}
+
+// AuditChannel compares the channel's cached entries between fromSeq and toSeq (inclusive, zero for the last cached
+// sequence) with the channel query, up to limit query rows (zero for no limit).  Only the range the cache is valid for
+// is compared.  Cached entries missing from the query for revisions that are no longer current are flagged as inactive
+// rather than reported as inconsistent, as the query only indexes documents' current revisions.  Doesn't create the
+// channel's cache - returns a not found error if the channel isn't cached.
+func (c *changeCache) AuditChannel(channelID ChannelID, fromSeq, toSeq uint64, limit int) (*ChannelAuditReport, error) {
+
+	if c.IsStopped() {
+		return nil, base.ErrDatabaseClosed
+	}
+
+	// The last sequence is read before the snapshot, so the snapshot includes every cached entry up to it
+	lastSequence := c.LastSequence()
+	if toSeq == 0 || toSeq > lastSequence {
+		toSeq = lastSequence
+	}
+	validFrom, cached, ok := c.channelCache.getCachedEntries(channelID)
+	if !ok {
+		return nil, base.HTTPErrorf(http.StatusNotFound, "Channel is not cached")
+	}
+	if fromSeq < validFrom {
+		fromSeq = validFrom
+	}
+
+	report := &ChannelAuditReport{
+		Channel: base.UD(channelID.String()).Redact(),
+		FromSeq: fromSeq,
+		ToSeq:   toSeq,
+	}
+	if fromSeq > toSeq {
+		report.Consistent = true
+		return report, nil
+	}
+
+	queried, err := queryChannel(c.backingStore, context.Background(), channelID, fromSeq, toSeq, limit, false)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(queried) >= limit {
+		report.ToSeq = queried[len(queried)-1].Sequence
+		report.Truncated = true
+	}
+	report.QueryCount = len(queried)
+
+	unmatched := make(map[uint64]*LogEntry, len(queried))
+	for _, entry := range queried {
+		unmatched[entry.Sequence] = entry
+	}
+	report.Consistent = true
+	for _, entry := range cached {
+		if entry.Sequence < report.FromSeq || entry.Sequence > report.ToSeq {
+			continue
+		}
+		report.CachedCount++
+		queryEntry, found := unmatched[entry.Sequence]
+		if !found || queryEntry.DocID != entry.DocID {
+			auditEntry := newChannelAuditEntry(entry)
+			auditEntry.Inactive = c.isInactiveRevision(entry)
+			report.OnlyInCache = append(report.OnlyInCache, auditEntry)
+			report.Consistent = report.Consistent && auditEntry.Inactive
+			continue
+		}
+		delete(unmatched, entry.Sequence)
+		if queryEntry.RevID != entry.RevID {
+			report.RevMismatches = append(report.RevMismatches, ChannelAuditRevMismatch{
+				Sequence:    entry.Sequence,
+				DocID:       base.UD(entry.DocID).Redact(),
+				CachedRevID: entry.RevID,
+				QueryRevID:  queryEntry.RevID,
+			})
+			report.Consistent = false
+		}
+	}
+	for _, entry := range queried {
+		if _, found := unmatched[entry.Sequence]; found {
+			report.OnlyInQuery = append(report.OnlyInQuery, newChannelAuditEntry(entry))
+			report.Consistent = false
+		}
+	}
+
+	base.Infof(base.KeyCache, "Audited channel cache for %q (#%d ... #%d): %d cached, %d queried, consistent: %t",
+		base.UD(channelID.String()), report.FromSeq, report.ToSeq, report.CachedCount, report.QueryCount, report.Consistent)
+	return report, nil
+}
+
+// isInactiveRevision returns true if the cached entry's revision has since been replaced by a later update to the
+// document.
+func (c *changeCache) isInactiveRevision(entry *LogEntry) bool {
+	doc, err := c.backingStore.GetDocument(entry.DocID, DocUnmarshalNoHistory)
+	if err != nil {
+		return false
+	}
+	return doc.Sequence > entry.Sequence
+}
+
+func newChannelAuditEntry(entry *LogEntry) ChannelAuditEntry {
+	return ChannelAuditEntry{
+		Sequence: entry.Sequence,
+		DocID:    base.UD(entry.DocID).Redact(),
+		RevID:    entry.RevID,
+	}
+}
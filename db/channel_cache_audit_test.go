@@ -0,0 +1,90 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Validates that a channel audit reports divergence injected between the cache and the backing store's channel query,
+// flagging entries for revisions that are no longer current rather than reporting them as inconsistent.
+func TestAuditChannel(t *testing.T) {
+
+	store := newTestCacheBackingStore()
+	cache := newTestChangeCache(t, store, nil)
+	defer cache.Stop()
+
+	cache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("ABC"))
+	for seq := uint64(1); seq <= 5; seq++ {
+		store.addDoc(seq, []string{"ABC"})
+		cache.processEntry(store.docLogEntry(store.docs[fmt.Sprintf("doc-%d", seq)]))
+	}
+
+	report, err := cache.AuditChannel(NewDefaultChannelID("ABC"), 0, 0, 0)
+	require.NoError(t, err)
+	assert.True(t, report.Consistent)
+	assert.Equal(t, uint64(1), report.FromSeq)
+	assert.Equal(t, uint64(5), report.ToSeq)
+	assert.Equal(t, 5, report.CachedCount)
+	assert.Equal(t, 5, report.QueryCount)
+
+	// Inject divergence into the store: doc-2's revision differs, doc-3 is missing, and doc-4 has been updated past the
+	// audited range.  Sequence 6 is in ABC in the store, but was cached in another channel.
+	store.lock.Lock()
+	store.docs["doc-2"].CurrentRev = "2-b"
+	delete(store.docs, "doc-3")
+	store.docs["doc-4"].Sequence = 7
+	store.lock.Unlock()
+	store.addDoc(6, []string{"ABC"})
+	divergent := store.docLogEntry(store.docs["doc-6"])
+	divergent.Channels = channels.ChannelMap{"NBC": nil}
+	cache.processEntry(divergent)
+
+	report, err = cache.AuditChannel(NewDefaultChannelID("ABC"), 0, 0, 0)
+	require.NoError(t, err)
+	assert.False(t, report.Consistent)
+	assert.Equal(t, uint64(6), report.ToSeq)
+	assert.Equal(t, 5, report.CachedCount)
+	assert.Equal(t, 4, report.QueryCount)
+	assert.Equal(t, []ChannelAuditRevMismatch{{Sequence: 2, DocID: base.UD("doc-2").Redact(), CachedRevID: "1-a", QueryRevID: "2-b"}}, report.RevMismatches)
+	assert.Equal(t, []ChannelAuditEntry{
+		{Sequence: 3, DocID: base.UD("doc-3").Redact(), RevID: "1-a"},
+		{Sequence: 4, DocID: base.UD("doc-4").Redact(), RevID: "1-a", Inactive: true},
+	}, report.OnlyInCache)
+	assert.Equal(t, []ChannelAuditEntry{{Sequence: 6, DocID: base.UD("doc-6").Redact(), RevID: "1-a"}}, report.OnlyInQuery)
+
+	// An inactive revision alone doesn't make the range inconsistent
+	report, err = cache.AuditChannel(NewDefaultChannelID("ABC"), 4, 5, 0)
+	require.NoError(t, err)
+	assert.True(t, report.Consistent)
+	require.Len(t, report.OnlyInCache, 1)
+	assert.True(t, report.OnlyInCache[0].Inactive)
+
+	// The row cap ends the comparison at the last sequence queried
+	report, err = cache.AuditChannel(NewDefaultChannelID("ABC"), 0, 0, 1)
+	require.NoError(t, err)
+	assert.True(t, report.Truncated)
+	assert.Equal(t, uint64(1), report.ToSeq)
+	assert.Equal(t, 1, report.CachedCount)
+	assert.True(t, report.Consistent)
+
+	// Channels that aren't cached aren't audited
+	_, err = cache.AuditChannel(NewDefaultChannelID("PBS"), 0, 0, 0)
+	assertHTTPError(t, err, 404)
+	_, ok := cache.getChannelCache().(*channelCacheImpl).getActiveChannelCache(NewDefaultChannelID("PBS"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,368 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// A node starting up has cold channel caches, and backfills each channel by query on first use - during a rolling
+// upgrade, that's a query stampede just as the warm caches of the node being replaced are discarded.  When cache
+// handoff is enabled, a node shutting down gracefully writes a snapshot of its largest channel caches to the
+// base.CacheHandoffKey metadata document, and a node starting up seeds its channel caches from it.
+//
+// The snapshot is only used when it's fresh: written within MaxAge, in the current sequence epoch, and no later than
+// the current _sync:seq.  The channel caches were complete up to the writing node's stable sequence - changes between
+// that and the starting node's initial sequence aren't received over the feed, so are queried for each seeded channel.
+// Seeded entries are provisional: changes replayed by the feed from before the initial sequence are checked against
+// them, and a seeded cache missing one drops its provisional entries, so that reads of that range are backfilled by
+// query as they would have been without the snapshot.
+
+var (
+	DefaultCacheHandoffMaxChannels = 100             // Maximum number of channel caches in a handoff snapshot
+	DefaultCacheHandoffMaxBytes    = 1024 * 1024     // Maximum encoded size of a handoff snapshot
+	DefaultCacheHandoffMaxAge      = 5 * time.Minute // Maximum age of a handoff snapshot that channel caches are seeded from
+)
+
+// Version of the handoff snapshot format.  Snapshots of other versions are ignored.
+const cacheHandoffVersion = 1
+
+// CacheHandoffOptions configure the handoff of channel caches to the next node to start.
+type CacheHandoffOptions struct {
+	Enabled     bool          // Write a snapshot on graceful shutdown, and seed channel caches from one on startup
+	MaxChannels int           // Maximum number of channel caches in a snapshot, largest first
+	MaxBytes    int           // Maximum encoded size of a snapshot - channels that don't fit are left out
+	MaxAge      time.Duration // Maximum age of a snapshot that channel caches are seeded from
+}
+
+// cacheHandoff is the snapshot of channel caches written on graceful shutdown.  Field names are abbreviated to keep
+// the document compact.
+type cacheHandoff struct {
+	Version  int                   `json:"version"`
+	Epoch    string                `json:"epoch,omitempty"` // Sequence epoch of the writing node, when sequence epochs are enabled
+	HighSeq  uint64                `json:"high_seq"`        // Stable sequence the channel caches were complete to
+	Written  time.Time             `json:"written"`
+	Channels []cacheHandoffChannel `json:"channels"` // Largest first
+}
+
+// cacheHandoffChannel is a channel cache's entries up to the snapshot's high sequence.
+type cacheHandoffChannel struct {
+	Collection string              `json:"c,omitempty"` // Omitted for the default collection
+	Name       string              `json:"n"`
+	ValidFrom  uint64              `json:"v"`
+	Entries    []cacheHandoffEntry `json:"e"`
+}
+
+type cacheHandoffEntry struct {
+	Sequence     uint64 `json:"s"`
+	DocID        string `json:"d"`
+	RevID        string `json:"r"`
+	Flags        uint8  `json:"f,omitempty"`
+	RemovedAtRev string `json:"x,omitempty"`
+}
+
+// validate returns an error describing why channel caches shouldn't be seeded from the snapshot, if it's stale or
+// ahead of the initial sequence.
+func (h *cacheHandoff) validate(epoch string, initialSequence uint64, maxAge time.Duration, now time.Time) error {
+	if h.Version != cacheHandoffVersion {
+		return fmt.Errorf("unsupported version %d", h.Version)
+	}
+	if age := now.Sub(h.Written); age > maxAge {
+		return fmt.Errorf("written %v ago, older than max age %v", age.Round(time.Second), maxAge)
+	}
+	if h.Epoch != epoch {
+		return fmt.Errorf("written in sequence epoch %q, current epoch is %q", h.Epoch, epoch)
+	}
+	if h.HighSeq > initialSequence {
+		return fmt.Errorf("high sequence #%d is ahead of %s #%d", h.HighSeq, base.SyncSeqKey, initialSequence)
+	}
+	return nil
+}
+
+// cacheHandoffSnapshot returns a snapshot of the largest channel caches, up to the handoff options' max channels and
+// max bytes.  Only entries up to the stable sequence are included, as later entries may be preceded by skipped
+// sequences that the caches don't hold.  Feed events must have been stopped, so that the caches don't change.
+func (c *changeCache) cacheHandoffSnapshot(epoch string) *cacheHandoff {
+	c.lock.RLock()
+	highSeq := c._getMaxStableCached()
+	// Entries received while the cache is paused aren't cached
+	if c.paused && c.pauseStatus.PausedAt < highSeq {
+		highSeq = c.pauseStatus.PausedAt
+	}
+	c.lock.RUnlock()
+
+	handoff := &cacheHandoff{
+		Version: cacheHandoffVersion,
+		Epoch:   epoch,
+		HighSeq: highSeq,
+		Written: time.Now(),
+	}
+	infos, _, err := c.channelCache.ListChannelCaches(ChannelCacheSortSize, c.options.Handoff.MaxChannels, "")
+	if err != nil {
+		base.Warnf("Unable to list channel caches for cache handoff for database %s: %v", base.MD(c.dbName), err)
+		return handoff
+	}
+
+	size := 0
+	for _, info := range infos {
+		validFrom, entries, ok := c.channelCache.getCachedEntries(NewChannelID(info.Collection, info.Name))
+		if !ok || validFrom > highSeq+1 {
+			continue
+		}
+		channel := cacheHandoffChannel{
+			Collection: info.Collection,
+			Name:       info.Name,
+			ValidFrom:  validFrom,
+			Entries:    make([]cacheHandoffEntry, 0, len(entries)),
+		}
+		for _, entry := range entries {
+			if entry.Sequence > highSeq {
+				break
+			}
+			channel.Entries = append(channel.Entries, cacheHandoffEntry{
+				Sequence:     entry.Sequence,
+				DocID:        entry.DocID,
+				RevID:        entry.RevID,
+				Flags:        entry.Flags,
+				RemovedAtRev: entry.RemovedAtRev,
+			})
+		}
+		encoded, err := base.JSONMarshal(channel)
+		if err != nil {
+			continue
+		}
+		// Channels are in descending size, so no later channel would be worth more than the one that doesn't fit
+		if size+len(encoded) > c.options.Handoff.MaxBytes {
+			break
+		}
+		size += len(encoded)
+		handoff.Channels = append(handoff.Channels, channel)
+	}
+	return handoff
+}
+
+// writeCacheHandoff writes a snapshot of the database's largest channel caches, for the next node to start to seed its
+// channel caches from.  The snapshot expires once it's too old to be used.
+func (context *DatabaseContext) writeCacheHandoff() error {
+	handoffOptions := context.changeCache.options.Handoff
+	handoff := context.changeCache.cacheHandoffSnapshot(context.SequenceEpoch)
+	value, err := base.JSONMarshal(handoff)
+	if err != nil {
+		return err
+	}
+	if err := context.Bucket.SetRaw(base.CacheHandoffKey, base.DurationToCbsExpiry(handoffOptions.MaxAge), value); err != nil {
+		return err
+	}
+	base.Infof(base.KeyCache, "Wrote cache handoff snapshot of %d channel caches (%d bytes) for database %s, complete to #%d",
+		len(handoff.Channels), len(value), base.MD(context.Name), handoff.HighSeq)
+	return nil
+}
+
+// loadCacheHandoff reads the cache handoff snapshot written by a node on shutdown, returning nil if there isn't one or
+// it's stale.
+func (context *DatabaseContext) loadCacheHandoff(initialSequence uint64) *cacheHandoff {
+	value, _, err := context.Bucket.GetRaw(base.CacheHandoffKey)
+	if err != nil {
+		if !base.IsDocNotFoundError(err) {
+			base.Warnf("Unable to read cache handoff snapshot for database %s: %v", base.MD(context.Name), err)
+		}
+		return nil
+	}
+	var handoff cacheHandoff
+	if err := base.JSONUnmarshal(value, &handoff); err != nil {
+		base.Warnf("Unable to parse cache handoff snapshot for database %s: %v", base.MD(context.Name), err)
+		return nil
+	}
+
+	if err := handoff.validate(context.SequenceEpoch, initialSequence, context.changeCache.options.Handoff.MaxAge, time.Now()); err != nil {
+		// A snapshot ahead of the sequence counter means the counter has gone backwards, and the cached entries may
+		// refer to sequences that will be reallocated
+		if handoff.HighSeq > initialSequence {
+			base.Warnf("Not seeding channel caches for database %s from cache handoff snapshot: %v - the sequence counter may have been reset",
+				base.MD(context.Name), err)
+		} else {
+			base.Infof(base.KeyCache, "Not seeding channel caches for database %s from cache handoff snapshot: %v", base.MD(context.Name), err)
+		}
+		return nil
+	}
+	return &handoff
+}
+
+// _seedFromHandoff seeds channel caches from the handoff snapshot, completing each to initialSequence by query.  Caches
+// that can't be completed aren't seeded.  Called by Start, before any feed events are processed.
+func (c *changeCache) _seedFromHandoff(handoff *cacheHandoff, initialSequence uint64) {
+	maxLength := c.options.ChannelCacheMaxLength
+	if maxLength <= 0 {
+		maxLength = DefaultChannelCacheMaxLength
+	}
+
+	seeded := 0
+	now := time.Now()
+	for _, channel := range handoff.Channels {
+		channelID := NewChannelID(channel.Collection, channel.Name)
+		entries := make(LogEntries, 0, len(channel.Entries))
+		for _, entry := range channel.Entries {
+			entries = append(entries, &LogEntry{
+				Sequence:     entry.Sequence,
+				DocID:        entry.DocID,
+				RevID:        entry.RevID,
+				Flags:        entry.Flags,
+				RemovedAtRev: entry.RemovedAtRev,
+				TimeReceived: now,
+				Collection:   channel.Collection,
+			})
+		}
+
+		// Changes between the snapshot and the initial sequence won't be received over the feed
+		if handoff.HighSeq < initialSequence {
+			changes, err := queryChannel(c.backingStore, context.Background(), channelID, handoff.HighSeq+1, initialSequence, maxLength, false)
+			if err != nil {
+				base.Infof(base.KeyCache, "Not seeding channel cache %q from cache handoff snapshot - unable to query changes since #%d: %v",
+					base.UD(channelID.String()), handoff.HighSeq, err)
+				continue
+			}
+			if len(changes) >= maxLength {
+				// The channel has changed too much for any of the snapshot's entries to be retained
+				continue
+			}
+			for _, change := range changes {
+				change.Channels = nil
+				change.TimeReceived = now
+			}
+			entries = append(entries, changes...)
+		}
+
+		if c.channelCache.seedChannelCache(channelID, channel.ValidFrom, entries, initialSequence) {
+			seeded++
+		}
+	}
+
+	c.dbStats.Cache().ChannelCacheHandoffSeeded.Add(int64(seeded))
+	base.Infof(base.KeyCache, "Seeded %d of %d channel caches for database %s from cache handoff snapshot written at %s, complete to #%d",
+		seeded, len(handoff.Channels), base.MD(c.dbName), handoff.Written.Format(time.RFC3339), handoff.HighSeq)
+}
+
+// seedChannelCache creates the channel's cache from entries handed off by another node, in sequence order, valid from
+// validFrom.  The entries up to provisionalTo are provisional - see verifySeededChannels.  Returns false if the
+// channel is already cached, isn't cached due to UncachedChannelPatterns, or the cache is at capacity.
+func (c *channelCacheImpl) seedChannelCache(channelID ChannelID, validFrom uint64, entries LogEntries, provisionalTo uint64) bool {
+	if c.channelCaches.Length() >= c.maxChannels || c.isUncachedChannel(channelID) {
+		return false
+	}
+	channelID = c.NormalizeChannelID(channelID)
+
+	singleChannelCache := newCollectionChannelCache(c.queryHandler, channelID, validFrom, c.options, c.cacheStats)
+	singleChannelCache.lock.Lock()
+	for _, entry := range entries {
+		if entry.Sequence < validFrom {
+			continue
+		}
+		if c.options.EntryChecksums {
+			entry.Checksum = logEntryChecksum(entry)
+		}
+		singleChannelCache._appendChange(entry)
+	}
+	singleChannelCache._pruneCacheLength()
+	singleChannelCache.provisionalTo = provisionalTo
+	singleChannelCache._publishSnapshot()
+	singleChannelCache.lock.Unlock()
+
+	key := channelID.String()
+	c.validFromLock.Lock()
+	_, created, cacheSize := c.channelCaches.GetOrInsert(key, singleChannelCache)
+	if created {
+		delete(c.emptyChannels, key)
+	}
+	c.validFromLock.Unlock()
+	if !created {
+		// Release the utilization counted for the discarded cache's entries
+		singleChannelCache.lock.Lock()
+		singleChannelCache._pruneToLength(0)
+		singleChannelCache.lock.Unlock()
+		return false
+	}
+
+	c.seeded.Set(true)
+	c.cacheStats.ChannelCacheNumChannels.Add(1)
+	c.cacheStats.ChannelCacheChannelsAdded.Add(1)
+	if cacheSize > c.compactHighWatermark {
+		c.startCacheCompaction()
+	}
+	return true
+}
+
+// verifySeededChannels checks a change replayed by the feed from before the initial sequence against the caches of the
+// channels the doc is in, and those it was removed from by the change.  A seeded cache that should hold the change,
+// as it's within the cache's provisional entries, but doesn't, drops its provisional entries.  Returns the number of
+// caches invalidated.
+func (c *channelCacheImpl) verifySeededChannels(docID string, sequence uint64, docChannels channels.ChannelMap) (invalidated int) {
+	if !c.seeded.IsTrue() {
+		return 0
+	}
+
+	verify := func(channelName string) {
+		if channelCache, ok := c.getActiveChannelCache(NewDefaultChannelID(channelName)); ok && channelCache.verifySeeded(docID, sequence) {
+			invalidated++
+		}
+	}
+	for channelName, removal := range docChannels {
+		if removal == nil || removal.Seq == sequence {
+			verify(channelName)
+		}
+	}
+	if _, explicitStarChannel := docChannels[channels.UserStarChannel]; !explicitStarChannel {
+		verify(channels.UserStarChannel)
+	}
+
+	if invalidated > 0 {
+		c.cacheStats.ChannelCacheHandoffInvalidated.Add(int64(invalidated))
+	}
+	return invalidated
+}
+
+// verifySeeded checks that the cache holds the change to docID at sequence, or a later one, when the sequence is in
+// the range of its provisional entries.  If not, the provisional entries are dropped and the cache made valid from
+// the following sequence, so that reads of that range are backfilled by query.  Returns true if they were dropped.
+func (c *singleChannelCacheImpl) verifySeeded(docID string, sequence uint64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if sequence < c.validFrom || sequence > c.provisionalTo {
+		return false
+	}
+	if cached, found := c.cachedDocs[docID]; found && cached.Sequence >= sequence {
+		return false
+	}
+
+	provisionalTo := c.provisionalTo
+	numProvisional := sort.Search(len(c.logs), func(i int) bool { return c.logs[i].Sequence > provisionalTo })
+	for _, entry := range c.logs[:numProvisional] {
+		c.UpdateCacheUtilization(entry, -1)
+		delete(c.cachedDocs, entry.DocID)
+	}
+	c._dropRetainedRemovals(func(retained *LogEntry) bool {
+		return retained.Sequence <= provisionalTo
+	})
+	c.logs = c.logs[numProvisional:]
+	c.validFrom = provisionalTo + 1
+	c.provisionalTo = 0
+	c._publishSnapshot()
+
+	base.Infof(base.KeyCache, "Channel cache %q seeded from cache handoff snapshot is missing doc %q #%d - dropped %d seeded entries, now valid from #%d",
+		base.UD(c.channelID().String()), base.UD(docID), sequence, numProvisional, c.validFrom)
+	return true
+}
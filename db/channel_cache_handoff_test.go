@@ -0,0 +1,210 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Shuts down one database context and starts another on the same bucket, and validates that the second starts with
+// the first's largest channel caches warm, served without backfill queries.
+func TestCacheHandoffWarmStart(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache)()
+
+	tBucket := base.GetTestBucket(t)
+	defer tBucket.Close()
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.Handoff.Enabled = true
+	cacheOptions.Handoff.MaxChannels = 2
+	dbcOptions := DatabaseContextOptions{CacheOptions: &cacheOptions}
+
+	db1 := setupTestDBForBucketWithOptions(t, tBucket.NoCloseClone(), dbcOptions)
+	db1.ChannelMapper = channels.NewDefaultChannelMapper()
+
+	// Channel A has the most changes, then B, then C
+	var lastSeq uint64
+	for i, channelNames := range [][]string{{"A", "B", "C"}, {"A", "B"}, {"A", "B"}, {"A"}, {"A"}} {
+		_, doc, err := db1.Put(fmt.Sprintf("doc%d", i), Body{"channels": channelNames})
+		require.NoError(t, err)
+		lastSeq = doc.Sequence
+	}
+	require.NoError(t, db1.changeCache.waitForSequence(context.TODO(), lastSeq, base.DefaultWaitForSequence))
+	for _, channelName := range []string{"A", "B", "C"} {
+		_, err := db1.changeCache.GetChanges(channelName, ChangesOptions{Since: SequenceID{Seq: 0}})
+		require.NoError(t, err)
+	}
+	db1.Close()
+
+	// The snapshot is written on close
+	_, _, err := tBucket.GetRaw(base.CacheHandoffKey)
+	require.NoError(t, err)
+
+	context2, err := NewDatabaseContext("db", tBucket.NoCloseClone(), false, dbcOptions)
+	require.NoError(t, err)
+	defer context2.Close()
+
+	cacheStats := context2.DbStats.Cache()
+	assert.Equal(t, int64(2), cacheStats.ChannelCacheHandoffSeeded.Value())
+
+	// The two largest channels are cached, complete from the start of the channel
+	channelCache := context2.changeCache.getChannelCache()
+	for channelName, expectedLen := range map[string]int{"A": 5, "B": 3} {
+		validFrom, entries, ok := channelCache.getCachedEntries(NewDefaultChannelID(channelName))
+		require.True(t, ok, "Channel %s not seeded", channelName)
+		assert.Equal(t, uint64(1), validFrom)
+		assert.Len(t, entries, expectedLen)
+	}
+	_, _, ok := channelCache.getCachedEntries(NewDefaultChannelID("C"))
+	assert.False(t, ok)
+
+	entries, err := context2.changeCache.GetChanges("A", ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+	assert.Equal(t, int64(1), cacheStats.ChannelCacheHits.Value())
+	assert.Equal(t, int64(0), cacheStats.ChannelCacheMisses.Value())
+	assert.Equal(t, int64(0), cacheStats.ChannelCachePartialHits.Value())
+	assert.Equal(t, int64(0), cacheStats.ChannelCacheHandoffInvalidated.Value())
+}
+
+// Validates that channel caches aren't seeded from a snapshot that's stale, or ahead of the sequence counter.
+func TestCacheHandoffRejectsStaleSnapshot(t *testing.T) {
+
+	testCases := []struct {
+		name    string
+		handoff cacheHandoff
+		seeded  bool
+	}{
+		{
+			name:    "fresh",
+			handoff: cacheHandoff{Version: cacheHandoffVersion, Written: time.Now()},
+			seeded:  true,
+		},
+		{
+			name:    "too old",
+			handoff: cacheHandoff{Version: cacheHandoffVersion, Written: time.Now().Add(-time.Hour)},
+		},
+		{
+			name:    "other epoch",
+			handoff: cacheHandoff{Version: cacheHandoffVersion, Epoch: "other", Written: time.Now()},
+		},
+		{
+			name:    "ahead of sequence",
+			handoff: cacheHandoff{Version: cacheHandoffVersion, HighSeq: 100, Written: time.Now()},
+		},
+		{
+			name:    "unknown version",
+			handoff: cacheHandoff{Version: cacheHandoffVersion + 1, Written: time.Now()},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tBucket := base.GetTestBucket(t)
+			defer tBucket.Close()
+
+			tc.handoff.Channels = []cacheHandoffChannel{{Name: "A", ValidFrom: 1, Entries: []cacheHandoffEntry{}}}
+			value, err := base.JSONMarshal(tc.handoff)
+			require.NoError(t, err)
+			require.NoError(t, tBucket.SetRaw(base.CacheHandoffKey, 0, value))
+
+			cacheOptions := DefaultCacheOptions()
+			cacheOptions.Handoff.Enabled = true
+			dbContext, err := NewDatabaseContext("db", tBucket.NoCloseClone(), false, DatabaseContextOptions{CacheOptions: &cacheOptions})
+			require.NoError(t, err)
+			defer dbContext.Close()
+
+			_, _, ok := dbContext.changeCache.getChannelCache().getCachedEntries(NewDefaultChannelID("A"))
+			assert.Equal(t, tc.seeded, ok)
+			assert.Equal(t, tc.seeded, dbContext.DbStats.Cache().ChannelCacheHandoffSeeded.Value() == 1)
+		})
+	}
+}
+
+// Validates that seeded caches are completed by query to the initial sequence, and that a change replayed by the feed
+// that a seeded cache should hold, but doesn't, drops its seeded entries so that reads are backfilled by query.
+func TestCacheHandoffSeedingIsProvisional(t *testing.T) {
+
+	store := newTestCacheBackingStore()
+	store.addDoc(5, []string{"A"})
+	store.addDoc(7, []string{"A"})
+	store.addDoc(12, []string{"A"})
+
+	dbStats := base.NewSyncGatewayStats().NewDBStats("", false, false, false)
+	cache := &changeCache{}
+	require.NoError(t, cache.init("db", dbStats, &DatabaseContextOptions{}, store,
+		channels.NewActiveChannels(dbStats.Cache().NumActiveChannels), nil, nil))
+
+	// The snapshot is missing doc-7, and predates doc-12
+	cache.handoff = &cacheHandoff{
+		Version: cacheHandoffVersion,
+		HighSeq: 10,
+		Written: time.Now(),
+		Channels: []cacheHandoffChannel{
+			{Name: "A", ValidFrom: 1, Entries: []cacheHandoffEntry{{Sequence: 5, DocID: "doc-5", RevID: "1-a"}}},
+		},
+	}
+	require.NoError(t, cache.Start(15))
+	defer cache.Stop()
+
+	validFrom, entries, ok := cache.getChannelCache().getCachedEntries(NewDefaultChannelID("A"))
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), validFrom)
+	require.Len(t, entries, 2)
+	assert.Equal(t, uint64(5), entries[0].Sequence)
+	assert.Equal(t, uint64(12), entries[1].Sequence)
+	assert.Equal(t, int64(1), dbStats.Cache().ChannelCacheHandoffSeeded.Value())
+
+	docEvent := func(seq uint64, channelName string) sgbucket.FeedEvent {
+		return sgbucket.FeedEvent{
+			Opcode:      sgbucket.FeedOpMutation,
+			Synchronous: true,
+			Key:         []byte(fmt.Sprintf("doc-%d", seq)),
+			Value:       []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d],"channels":{%q:null}}}`, seq, seq, channelName)),
+			DataType:    base.MemcachedDataTypeJSON,
+		}
+	}
+
+	// Replayed changes the seeded cache holds, or for other channels, leave it intact
+	cache.DocChanged(docEvent(5, "A"))
+	cache.DocChanged(docEvent(12, "A"))
+	cache.DocChanged(docEvent(8, "B"))
+	_, entries, _ = cache.getChannelCache().getCachedEntries(NewDefaultChannelID("A"))
+	assert.Len(t, entries, 2)
+	assert.Equal(t, int64(0), dbStats.Cache().ChannelCacheHandoffInvalidated.Value())
+
+	// A change received after startup is cached as usual
+	cache.DocChanged(docEvent(16, "A"))
+	require.NoError(t, cache.waitForSequence(context.TODO(), 16, base.DefaultWaitForSequence))
+
+	// A replayed change missing from the seeded cache drops the seeded entries, and only those
+	cache.DocChanged(docEvent(7, "A"))
+	assert.Equal(t, int64(1), dbStats.Cache().ChannelCacheHandoffInvalidated.Value())
+	validFrom, entries, _ = cache.getChannelCache().getCachedEntries(NewDefaultChannelID("A"))
+	assert.Equal(t, uint64(16), validFrom)
+	require.Len(t, entries, 1)
+	assert.Equal(t, uint64(16), entries[0].Sequence)
+
+	// Reads of the dropped range are backfilled by query
+	changes, err := cache.GetChanges("A", ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	assert.Len(t, changes, 4)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"sort"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Estimated memory used by a cached LogEntry and its reference from a channel cache, other than its doc and rev IDs.
+const logEntryOverheadBytes = 200
+
+// cacheMemoryAccountant is implemented by channel caches that estimate the memory used by their entries, so that it
+// can be limited by a CacheMemoryGovernor.
+type cacheMemoryAccountant interface {
+	// Returns the estimated memory used by the cached entries of every channel
+	memoryUsage() (bytes int64)
+
+	// Reduces the estimated memory used to at most targetBytes, returning the estimated memory used afterwards
+	reduceMemory(targetBytes int64) (bytes int64)
+}
+
+// estimatedLogEntryBytes returns the estimated memory used by a cached entry.  Entries cached in several channels are
+// counted for each, so that the estimate is proportional to what evicting a channel's cache would release.
+func estimatedLogEntryBytes(entry *LogEntry) int64 {
+	return int64(logEntryOverheadBytes + len(entry.DocID) + len(entry.RevID))
+}
+
+// memoryUsage returns the estimated memory used by the cache's entries.  Reads the published snapshot, so doesn't
+// block cache updates.
+func (c *singleChannelCacheImpl) memoryUsage() (bytes int64) {
+	for _, entry := range c.snapshot.Load().(*channelCacheSnapshot).logs {
+		bytes += estimatedLogEntryBytes(entry)
+	}
+	return bytes
+}
+
+// pruneToLength prunes the oldest entries from the cache, leaving at most length entries.
+func (c *singleChannelCacheImpl) pruneToLength(length int) (pruned int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pruned = c._pruneToLength(length)
+	if pruned > 0 {
+		c._publishSnapshot()
+	}
+	return pruned
+}
+
+func (c *channelCacheImpl) memoryUsage() (bytes int64) {
+	c.channelCaches.Range(func(v interface{}) bool {
+		if singleChannelCache := AsSingleChannelCache(v); singleChannelCache != nil {
+			bytes += singleChannelCache.memoryUsage()
+		}
+		return true
+	})
+	return bytes
+}
+
+// reduceMemory evicts idle channel caches - those not used since the last compaction or reduction - until the
+// estimated memory used is at most targetBytes, inactive channels first, and larger caches before smaller.  If that
+// isn't enough, the remaining caches are pruned to their minimum length, largest first.  Evicted caches are recreated
+// on next use, backfilled by query.
+func (c *channelCacheImpl) reduceMemory(targetBytes int64) (bytes int64) {
+	type candidate struct {
+		cache  *singleChannelCacheImpl
+		bytes  int64
+		idle   bool
+		active bool
+	}
+	var candidates []candidate
+	c.channelCaches.Range(func(v interface{}) bool {
+		singleChannelCache := AsSingleChannelCache(v)
+		if singleChannelCache == nil {
+			return true
+		}
+		usage := singleChannelCache.memoryUsage()
+		bytes += usage
+		candidates = append(candidates, candidate{
+			cache:  singleChannelCache,
+			bytes:  usage,
+			idle:   !singleChannelCache.recentlyUsed.IsTrue(),
+			active: c.activeChannels.IsActive(singleChannelCache.channelName),
+		})
+		return true
+	})
+	if bytes <= targetBytes {
+		return bytes
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].idle != candidates[j].idle {
+			return candidates[i].idle
+		}
+		if candidates[i].active != candidates[j].active {
+			return !candidates[i].active
+		}
+		return candidates[i].bytes > candidates[j].bytes
+	})
+
+	// Evict idle caches.  Holds validFromLock so that eviction can't interleave with AddToCache or addChannelCache
+	var evicted, inactiveEvicted int
+	remaining := make([]candidate, 0, len(candidates))
+	c.validFromLock.Lock()
+	for _, candidate := range candidates {
+		if bytes <= targetBytes || !candidate.idle || candidate.bytes == 0 {
+			remaining = append(remaining, candidate)
+			continue
+		}
+		key := candidate.cache.channelID().String()
+		if value, found := c.channelCaches.Get(key); !found || value != candidate.cache {
+			continue
+		}
+		c.channelCaches.Remove(key)
+		bytes -= candidate.bytes
+		evicted++
+		if !candidate.active {
+			inactiveEvicted++
+		}
+	}
+	c.validFromLock.Unlock()
+	if evicted > 0 {
+		c.cacheStats.ChannelCacheNumChannels.Add(-int64(evicted))
+		c.cacheStats.ChannelCacheChannelsEvictedInactive.Add(int64(inactiveEvicted))
+		c.cacheStats.ChannelCacheChannelsEvictedNRU.Add(int64(evicted - inactiveEvicted))
+	}
+
+	// Prune the remaining caches, largest first
+	sort.Slice(remaining, func(i, j int) bool { return remaining[i].bytes > remaining[j].bytes })
+	var pruned int
+	for _, candidate := range remaining {
+		if bytes <= targetBytes {
+			break
+		}
+		if candidate.cache.pruneToLength(candidate.cache.options.ChannelCacheMinLength) > 0 {
+			pruned++
+			bytes += candidate.cache.memoryUsage() - candidate.bytes
+		}
+	}
+
+	base.Infof(base.KeyCache, "Reduced channel cache memory for database %s to an estimated %d bytes (target %d bytes) - evicted %d channel caches, pruned %d",
+		base.MD(c.dbName), bytes, targetBytes, evicted, pruned)
+	return bytes
+}
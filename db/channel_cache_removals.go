@@ -0,0 +1,155 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"sort"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// The channel query only finds a removal while it's the document's current revision, so a backfill of a channel whose
+// removals have been pruned from its cache can miss them.  When ChannelCacheOptions.RemovalRetention is set, the most
+// recent removal entries pruned from a channel's cache are retained alongside it, and merged into the results of
+// backfill queries.  Retained removals are outside the range the cache is valid for, so are never served from the
+// cache alone.
+
+// _retainRemovals retains the removal entries among those pruned from the cache, keeping only the most recent
+// RemovalRetention, and the latest for each doc.  Caller MUST be holding the lock.
+func (c *singleChannelCacheImpl) _retainRemovals(pruned []*LogEntry) {
+	var retained, dropped int
+	for _, entry := range pruned {
+		if !entry.IsRemoved() {
+			continue
+		}
+		if c.options.RemovalRetention <= 0 {
+			dropped++
+			continue
+		}
+		if existing := c._retainedRemoval(entry.DocID); existing != nil {
+			if existing.Sequence >= entry.Sequence {
+				// Already retained, or superseded by a later removal of the doc
+				if existing.Sequence > entry.Sequence {
+					dropped++
+				}
+				continue
+			}
+			dropped += c._dropRetainedRemovals(func(retained *LogEntry) bool { return retained == existing })
+		}
+		c.retainedRemovals = append(c.retainedRemovals, entry)
+		retained++
+	}
+	if retained == 0 && dropped == 0 {
+		return
+	}
+
+	// Entries prepended by a backfill can be pruned after later entries, so the retained removals are re-sorted
+	sort.Slice(c.retainedRemovals, func(i, j int) bool {
+		return c.retainedRemovals[i].Sequence < c.retainedRemovals[j].Sequence
+	})
+	if excess := len(c.retainedRemovals) - c.options.RemovalRetention; excess > 0 {
+		c.retainedRemovals = append([]*LogEntry(nil), c.retainedRemovals[excess:]...)
+		dropped += excess
+	}
+	c.cacheStats.ChannelCacheRemovalsRetained.Add(int64(retained))
+	c.cacheStats.ChannelCacheRemovalsPruned.Add(int64(dropped))
+}
+
+// _retainedRemoval returns the retained removal of docID, if any.  Caller MUST be holding the lock.
+func (c *singleChannelCacheImpl) _retainedRemoval(docID string) *LogEntry {
+	for _, retained := range c.retainedRemovals {
+		if retained.DocID == docID {
+			return retained
+		}
+	}
+	return nil
+}
+
+// _dropRetainedRemovals drops the retained removals for which drop returns true, returning the number dropped.  Caller
+// MUST be holding the lock.
+func (c *singleChannelCacheImpl) _dropRetainedRemovals(drop func(*LogEntry) bool) (dropped int) {
+	if len(c.retainedRemovals) == 0 {
+		return 0
+	}
+	remaining := make([]*LogEntry, 0, len(c.retainedRemovals))
+	for _, retained := range c.retainedRemovals {
+		if drop(retained) {
+			dropped++
+			continue
+		}
+		remaining = append(remaining, retained)
+	}
+	if dropped > 0 {
+		c.retainedRemovals = remaining
+	}
+	return dropped
+}
+
+// mergeRetainedRemovals merges the retained removals between startSeq and endSeq into the results of a backfill query,
+// in sequence order.  A retained removal is superseded by a later entry for its doc in the results or the cache, and
+// supersedes an earlier one in the results.  When limit is reached, only removals up to the last query result are
+// merged, and the merged results are truncated to limit.
+func (c *singleChannelCacheImpl) mergeRetainedRemovals(queried LogEntries, startSeq, endSeq uint64, limit int) LogEntries {
+	if limit > 0 && len(queried) >= limit {
+		endSeq = queried[len(queried)-1].Sequence
+	}
+
+	c.lock.RLock()
+	var removals []*LogEntry
+	for _, retained := range c.retainedRemovals {
+		if retained.Sequence < startSeq || retained.Sequence > endSeq {
+			continue
+		}
+		if cached, found := c.cachedDocs[retained.DocID]; found && cached.Sequence > retained.Sequence {
+			continue
+		}
+		removals = append(removals, retained)
+	}
+	c.lock.RUnlock()
+	if len(removals) == 0 {
+		return queried
+	}
+
+	queriedSeqs := make(map[string]uint64, len(queried))
+	for _, entry := range queried {
+		queriedSeqs[entry.DocID] = entry.Sequence
+	}
+	merged := make(LogEntries, 0, len(queried)+len(removals))
+	removalSeqs := make(map[string]uint64, len(removals))
+	for _, removal := range removals {
+		if seq, found := queriedSeqs[removal.DocID]; found && seq >= removal.Sequence {
+			continue
+		}
+		removalSeqs[removal.DocID] = removal.Sequence
+	}
+	for _, entry := range queried {
+		if seq, found := removalSeqs[entry.DocID]; found && seq > entry.Sequence {
+			continue
+		}
+		merged = append(merged, entry)
+	}
+	var served int
+	for _, removal := range removals {
+		if seq, found := removalSeqs[removal.DocID]; found && seq == removal.Sequence {
+			merged = append(merged, removal)
+			served++
+		}
+	}
+	if served == 0 {
+		return queried
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Sequence < merged[j].Sequence })
+	if limit > 0 && len(merged) > limit {
+		merged = merged[:limit]
+	}
+	base.Debugf(base.KeyCache, "Merged %d retained removals into backfill of channel %q", served, base.UD(c.channelName))
+	return merged
+}
@@ -15,7 +15,9 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbase/sync_gateway/base"
@@ -91,6 +93,7 @@ import (
 // the guarantees described above would possibly be discarded.
 type SingleChannelCache interface {
 	GetChanges(options ChangesOptions) ([]*LogEntry, error)
+	GetChangesStream(options ChangesOptions, callback func(*LogEntry) bool) error
 	GetCachedChanges(options ChangesOptions) (validFrom uint64, result []*LogEntry)
 	ChannelName() string
 	SupportsLateFeed() bool
@@ -98,30 +101,49 @@ type SingleChannelCache interface {
 	GetLateSequencesSince(sinceSequence uint64) (entries []*LogEntry, lastSequence uint64, err error)
 	RegisterLateSequenceClient() (latestLateSeq uint64)
 	ReleaseLateSequenceClient(sequence uint64) (success bool)
+	readChanges(options ChangesOptions) (changeStream, error)
 }
 
 type singleChannelCacheImpl struct {
-	channelName      string               // The channel name, duh
-	queryHandler     ChannelQueryHandler  // Database connection (used for view queries)
-	logs             LogEntries           // Log entries in sequence order
-	validFrom        uint64               // First sequence that logs is valid for, not necessarily the seq number of a change entry.
-	lock             sync.RWMutex         // Controls access to logs, validFrom
-	queryLock        sync.Mutex           // Ensures only one view query is made at a time
-	lateLogs         []*lateLogEntry      // Late arriving LogEntries, stored in the order they were received
-	lastLateSequence uint64               // Used for fast check of whether listener has the latest
-	lateLogLock      sync.RWMutex         // Controls access to lateLogs
-	lateSequenceUUID uuid.UUID            // UUID for late sequence consistency across cache compaction
-	options          *ChannelCacheOptions // Cache size/expiry settings
-	cachedDocIDs     map[string]struct{}  // Set of keys present in the cache.  Used for efficient check for previous revisions on append
-	recentlyUsed     base.AtomicBool      // Atomic recently used flag, used by cache compaction.
-	cacheStats       *base.CacheStats     // Map used for cache stats
+	channelName      string                         // The channel name, duh
+	collection       string                         // The collection the channel belongs to, empty for the default collection
+	queryHandler     ChannelQueryHandler            // Database connection (used for view queries)
+	logs             LogEntries                     // Log entries in sequence order
+	validFrom        uint64                         // First sequence that logs is valid for, not necessarily the seq number of a change entry.
+	lock             sync.RWMutex                   // Controls access to logs, validFrom
+	snapshot         atomic.Value                   // The most recently published *channelCacheSnapshot of logs and validFrom, read without locking
+	queryLock        sync.Mutex                     // Ensures only one view query is made at a time
+	lateLogs         []*lateLogEntry                // Late arriving LogEntries, stored in the order they were received
+	lastLateSequence uint64                         // Used for fast check of whether listener has the latest
+	lateLogLock      sync.RWMutex                   // Controls access to lateLogs
+	lateSequenceUUID uuid.UUID                      // UUID for late sequence consistency across cache compaction
+	options          *ChannelCacheOptions           // Cache size/expiry settings
+	cachedDocs       map[string]*LogEntry           // Entry for each doc present in the cache, by doc ID.  Used for efficient check for previous revisions on append
+	recentlyUsed     base.AtomicBool                // Atomic recently used flag, used by cache compaction.
+	lastAccess       int64                          // Unix nano time the cache was last read, for diagnostics.  Accessed atomically
+	cacheStats       *base.CacheStats               // Map used for cache stats
+	accessCounts     *base.ChannelCacheAccessCounts // The channel's cache access counts, nil if the channel isn't tracked
+	retainedRemovals []*LogEntry                    // Most recent removal entries pruned from logs, in sequence order.  Guarded by lock - see _retainRemovals
+	provisionalTo    uint64                         // Entries up to this sequence were seeded from a cache handoff snapshot, rather than received from the feed.  Guarded by lock
+	evictedTombstone uint64                         // Last expired tombstone evicted ahead of older entries - see _evictExpiredTombstones.  Guarded by lock
+	logsShared       bool                           // Set while logs shares its backing array with a published snapshot - see _copyLogs.  Guarded by lock
 }
 
+// channelCacheAccess classifies how a changes request was served by a channel's cache.
+type channelCacheAccess int
+
+const (
+	channelCacheHit        channelCacheAccess = iota // Served entirely from the cache
+	channelCachePartialHit                           // Served from the cache and a backfill query
+	channelCacheMiss                                 // Served by query alone
+)
+
 func newSingleChannelCache(queryHandler ChannelQueryHandler, channelName string, validFrom uint64, cacheStats *base.CacheStats) *singleChannelCacheImpl {
 	cache := &singleChannelCacheImpl{queryHandler: queryHandler, channelName: channelName, validFrom: validFrom}
 	cache.initializeLateLogs()
-	cache.cachedDocIDs = make(map[string]struct{})
+	cache.cachedDocs = make(map[string]*LogEntry)
 	cache.cacheStats = cacheStats
+	cache.accessCounts = cacheStats.ChannelAccess.Channel(channelName)
 	cache.options = &ChannelCacheOptions{
 		ChannelCacheMinLength: DefaultChannelCacheMinLength,
 		ChannelCacheMaxLength: DefaultChannelCacheMaxLength,
@@ -129,13 +151,22 @@ func newSingleChannelCache(queryHandler ChannelQueryHandler, channelName string,
 		MaxNumChannels:        DefaultChannelCacheMaxNumber,
 	}
 	cache.logs = make(LogEntries, 0)
-	cache.recentlyUsed.Set(true)
+	cache._publishSnapshot()
+	cache.setRecentlyUsed()
 
 	return cache
 }
 
 func newChannelCacheWithOptions(queryHandler ChannelQueryHandler, channelName string, validFrom uint64, options ChannelCacheOptions, cacheStats *base.CacheStats) *singleChannelCacheImpl {
-	cache := newSingleChannelCache(queryHandler, channelName, validFrom, cacheStats)
+	return newCollectionChannelCache(queryHandler, NewDefaultChannelID(channelName), validFrom, options, cacheStats)
+}
+
+// newCollectionChannelCache creates the cache for a channel that may belong to a collection other than the default.
+func newCollectionChannelCache(queryHandler ChannelQueryHandler, channelID ChannelID, validFrom uint64, options ChannelCacheOptions, cacheStats *base.CacheStats) *singleChannelCacheImpl {
+	cache := newSingleChannelCache(queryHandler, channelID.Name, validFrom, cacheStats)
+	if !channelID.IsDefaultCollection() {
+		cache.collection = channelID.Collection
+	}
 
 	// Update cache options when present
 	if options.ChannelCacheMinLength > 0 {
@@ -154,6 +185,10 @@ func newChannelCacheWithOptions(queryHandler ChannelQueryHandler, channelName st
 		cache.options.MaxNumChannels = options.MaxNumChannels
 	}
 
+	cache.options.EntryChecksums = options.EntryChecksums
+	cache.options.RemovalRetention = options.RemovalRetention
+	cache.options.ExpiredTombstoneAge = options.ExpiredTombstoneAge
+
 	base.Debugf(base.KeyCache, "Initialized cache for channel %q with min:%v max:%v age:%v, validFrom: %d",
 		base.UD(cache.channelName), cache.options.ChannelCacheMinLength, cache.options.ChannelCacheMaxLength, cache.options.ChannelCacheAge, validFrom)
 
@@ -168,6 +203,50 @@ type ChannelCacheOptions struct {
 	CompactHighWatermarkPercent int           // Compact HWM (as percent of MaxNumChannels)
 	CompactLowWatermarkPercent  int           // Compact LWM (as percent of MaxNumChannels)
 	ChannelQueryLimit           int           // Query limit
+	EntryChecksums              bool          // Checksum entries when cached, and verify them when read
+	MaxMemoryBytes              int64         // Estimated memory the database's channel caches are reduced below (0 for no limit) - see CacheMemoryGovernor
+	RemovalRetention            int           // Removal entries retained per channel once pruned, to be merged into backfills - see _retainRemovals
+	ChannelNameNormalization    string        // Channel name normalization policy - ChannelNamesPreserve (default) or ChannelNamesLowercase
+	ExpiredTombstoneAge         time.Duration // Tombstones deleted longer ago than this are pruned ahead of older entries (0 to prune in sequence order only)
+
+	// UncachedChannelPatterns identifies channels that aren't cached, by name or by a name prefix or suffix glob (e.g.
+	// "user.*").  Changes to those channels still notify changes feeds, but reads always query for the channel's
+	// changes.  Intended for channels read by a single client, such as per-user channels, where a cache would only
+	// take memory from the caches of shared channels.  The star channel is unaffected - see EnableStarChannel.
+	UncachedChannelPatterns []string
+
+	// EnableStarChannel keeps a cache for the "*" channel (channels.UserStarChannel), holding every change.  It's only
+	// read by changes feeds of users with access to "*" (e.g. admin-party), which query for the "*" channel instead
+	// when it's disabled.
+	EnableStarChannel bool
+}
+
+// channelCacheSnapshot is an immutable view of a channel cache's entries, published by writers after each change so
+// that reads don't contend with writes.  Writers append to logs in place, beyond the length of any published snapshot,
+// but must copy logs before modifying existing entries once they've been published (see _copyLogs).
+type channelCacheSnapshot struct {
+	logs             LogEntries // Log entries in sequence order.  Entries within len(logs) are never modified
+	validFrom        uint64     // First sequence that logs is valid for
+	evictedTombstone uint64     // Last expired tombstone evicted from logs ahead of older entries
+}
+
+// _publishSnapshot publishes the current logs and validFrom for readers.  Caller MUST be holding the lock.
+func (c *singleChannelCacheImpl) _publishSnapshot() {
+	c.snapshot.Store(&channelCacheSnapshot{logs: c.logs, validFrom: c.validFrom, evictedTombstone: c.evictedTombstone})
+	c.logsShared = true
+}
+
+// _copyLogs replaces logs with a copy, so that existing entries can be modified without affecting published snapshots.
+// Logs that haven't been published since they were last copied are modified in place, so a burst of out-of-order or
+// duplicate changes between publishes only copies once.  Caller MUST be holding the lock.
+func (c *singleChannelCacheImpl) _copyLogs() {
+	if !c.logsShared {
+		return
+	}
+	logs := make(LogEntries, len(c.logs), len(c.logs)+1)
+	copy(logs, c.logs)
+	c.logs = logs
+	c.logsShared = false
 }
 
 func (c *singleChannelCacheImpl) ChannelName() string {
@@ -198,9 +277,11 @@ func (c *singleChannelCacheImpl) addToCache(change *LogEntry, isRemoval bool) {
 	} else {
 		removalChange := *change
 		removalChange.Flags |= channels.Removed
+		removalChange.RemovedAtRev = change.RevID
 		c._appendChange(&removalChange)
 	}
 	c._pruneCacheLength()
+	c._publishSnapshot()
 }
 
 // If certain conditions are met, it's possible that this change will be added and then
@@ -217,8 +298,25 @@ func (c *singleChannelCacheImpl) wouldBeImmediatelyPruned(change *LogEntry) bool
 
 }
 
-// Remove purges the given doc IDs from the channel cache and returns the number of items removed.
+// Remove purges the given doc IDs from the channel cache and returns the number of items removed.  Entries received
+// after startTime, for resurrected documents, are kept.
 func (c *singleChannelCacheImpl) Remove(docIDs []string, startTime time.Time) (count int) {
+	return c.removeDocs(docIDs, func(entry *LogEntry) bool {
+		return !entry.TimeReceived.After(startTime)
+	})
+}
+
+// RemoveUpToSequence purges the entries for the given doc IDs with sequences up to and including purgeSeq from the
+// channel cache, and returns the number of items removed.
+func (c *singleChannelCacheImpl) RemoveUpToSequence(docIDs []string, purgeSeq uint64) (count int) {
+	return c.removeDocs(docIDs, func(entry *LogEntry) bool {
+		return entry.Sequence <= purgeSeq
+	})
+}
+
+// removeDocs purges the entries for the given doc IDs for which isPurged returns true, and returns the number of items
+// removed.
+func (c *singleChannelCacheImpl) removeDocs(docIDs []string, isPurged func(entry *LogEntry) bool) (count int) {
 	// Exit early if there's no work to do
 	if len(docIDs) == 0 {
 		return 0
@@ -229,11 +327,23 @@ func (c *singleChannelCacheImpl) Remove(docIDs []string, startTime time.Time) (c
 
 	// Build subset of docIDs that we know are present in the cache
 	foundDocs := make(map[string]struct{}, 0)
+	purgedDocs := make(map[string]struct{}, len(docIDs))
 	for _, docID := range docIDs {
-		if _, found := c.cachedDocIDs[docID]; found {
+		if _, found := c.cachedDocs[docID]; found {
 			foundDocs[docID] = struct{}{}
 		}
+		purgedDocs[docID] = struct{}{}
+	}
+	c._dropRetainedRemovals(func(retained *LogEntry) bool {
+		_, purged := purgedDocs[retained.DocID]
+		return purged && isPurged(retained)
+	})
+
+	if len(foundDocs) == 0 {
+		return 0
 	}
+	c._copyLogs()
+	defer c._publishSnapshot()
 
 	// Do the removals in one sweep of the channel cache
 	end := len(c.logs) - 1
@@ -241,9 +351,9 @@ func (c *singleChannelCacheImpl) Remove(docIDs []string, startTime time.Time) (c
 		if _, ok := foundDocs[c.logs[i].DocID]; ok {
 			docID := c.logs[i].DocID
 
-			// Make sure the document we're about to remove is older than the start time of the purge
+			// Make sure the document we're about to remove preceded the purge
 			// This is to ensure that resurrected documents do not accidentally get removed.
-			if c.logs[i].TimeReceived.After(startTime) {
+			if !isPurged(c.logs[i]) {
 				base.Debugf(base.KeyCache, "Skipping removal of doc %q from cache %q - received after purge",
 					base.UD(docID), base.UD(c.channelName))
 				continue
@@ -256,7 +366,7 @@ func (c *singleChannelCacheImpl) Remove(docIDs []string, startTime time.Time) (c
 			copy(c.logs[i:], c.logs[i+1:])
 			c.logs[len(c.logs)-1] = nil
 			c.logs = c.logs[:len(c.logs)-1]
-			delete(c.cachedDocIDs, docID)
+			delete(c.cachedDocs, docID)
 			count++
 
 			base.Tracef(base.KeyCache, "Removed doc %q from cache %q", base.UD(docID), base.UD(c.channelName))
@@ -266,15 +376,159 @@ func (c *singleChannelCacheImpl) Remove(docIDs []string, startTime time.Time) (c
 	return count
 }
 
+// revisionsPruned updates the cached entry for docID when it references one of prunedRevIDs, which can no longer be
+// fetched.  A superseded entry is dropped, as a later entry for the doc will be cached, otherwise the entry is replaced
+// by a copy referencing currentRev.  Returns whether the entry was dropped or replaced.
+func (c *singleChannelCacheImpl) revisionsPruned(docID string, prunedRevIDs base.Set, currentRev string, superseded bool) (dropped, replaced bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c._dropRetainedRemovals(func(retained *LogEntry) bool {
+		return retained.DocID == docID && prunedRevIDs.Contains(retained.RevID)
+	})
+	entry, found := c.cachedDocs[docID]
+	if !found || !prunedRevIDs.Contains(entry.RevID) {
+		return false, false
+	}
+	i := sort.Search(len(c.logs), func(i int) bool { return c.logs[i].Sequence >= entry.Sequence })
+	if i == len(c.logs) || c.logs[i] != entry {
+		base.Warnf("Cached entry #%d for doc %q not found in channel %q", entry.Sequence, base.UD(docID), base.UD(c.channelName))
+		return false, false
+	}
+
+	c._copyLogs()
+	defer c._publishSnapshot()
+	if superseded {
+		c.UpdateCacheUtilization(entry, -1)
+		copy(c.logs[i:], c.logs[i+1:])
+		c.logs[len(c.logs)-1] = nil
+		c.logs = c.logs[:len(c.logs)-1]
+		delete(c.cachedDocs, docID)
+		base.Debugf(base.KeyCache, "Dropped doc %q / %q from cache %q - revision pruned", base.UD(docID), entry.RevID, base.UD(c.channelName))
+		return true, false
+	}
+
+	// Entries are shared between channels and published snapshots, so are replaced rather than modified
+	replacement := *entry
+	replacement.RevID = currentRev
+	if c.options.EntryChecksums {
+		replacement.Checksum = logEntryChecksum(&replacement)
+	}
+	c.logs[i] = &replacement
+	c.cachedDocs[docID] = &replacement
+	base.Debugf(base.KeyCache, "Replaced doc %q / %q with current revision %q in cache %q - revision pruned",
+		base.UD(docID), entry.RevID, currentRev, base.UD(c.channelName))
+	return false, true
+}
+
+// rollback removes the entries for which isRolledBack returns true, and moves validFrom past the last of them so that
+// reads of the rolled back range are served by query.  Entries preceding the last rolled back entry are also removed,
+// as the cache must be complete from validFrom.  Rolled back entries are removed from the late logs, so late feeds
+// positioned on one reset.  Returns the number of rolled back entries removed.
+func (c *singleChannelCacheImpl) rollback(isRolledBack func(*LogEntry) bool) (removed int) {
+	c.lock.Lock()
+	c._dropRetainedRemovals(isRolledBack)
+	last := -1
+	for i, entry := range c.logs {
+		if isRolledBack(entry) {
+			last = i
+			removed++
+		}
+	}
+	if last >= 0 {
+		for _, entry := range c.logs[:last+1] {
+			c.UpdateCacheUtilization(entry, -1)
+			delete(c.cachedDocs, entry.DocID)
+		}
+		if rolledBackTo := c.logs[last].Sequence + 1; rolledBackTo > c.validFrom {
+			c.validFrom = rolledBackTo
+		}
+		c.logs = c.logs[last+1:]
+		c._publishSnapshot()
+		base.Debugf(base.KeyCache, "Rolled back %d entries from channel %q, now valid from #%d", removed, base.UD(c.channelName), c.validFrom)
+	}
+	c.lock.Unlock()
+
+	// The first late log entry is retained regardless, to track listeners
+	c.lateLogLock.Lock()
+	lateLogs := c.lateLogs[:1]
+	for _, lateLog := range c.lateLogs[1:] {
+		if !isRolledBack(lateLog.logEntry) {
+			lateLogs = append(lateLogs, lateLog)
+		}
+	}
+	c.lateLogs = lateLogs
+	c.lastLateSequence = c._mostRecentLateLog().logEntry.Sequence
+	c.lateLogLock.Unlock()
+
+	return removed
+}
+
+// revalidate drops all of the cache's entries and makes it valid from validFrom, when the entries preceding validFrom
+// may not have been cached.  Reads before validFrom are backfilled by query.
+func (c *singleChannelCacheImpl) revalidate(validFrom uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c._pruneToLength(0)
+	if validFrom > c.validFrom {
+		c.validFrom = validFrom
+	}
+	c._publishSnapshot()
+	base.Debugf(base.KeyCache, "Revalidated channel %q, now valid from #%d", base.UD(c.channelName), c.validFrom)
+}
+
 // Internal helper that prunes a single channel's cache. Caller MUST be holding the lock.
 func (c *singleChannelCacheImpl) _pruneCacheLength() (pruned int) {
-	// If we are over max length, prune it down to max length
-	if len(c.logs) > c.options.ChannelCacheMaxLength {
-		pruned = len(c.logs) - c.options.ChannelCacheMaxLength
+	// If we are over max length, evict expired tombstones first, then prune it down to max length
+	if excess := len(c.logs) - c.options.ChannelCacheMaxLength; excess > 0 && c.options.ExpiredTombstoneAge > 0 {
+		pruned = c._evictExpiredTombstones(excess)
+	}
+	return pruned + c._pruneToLength(c.options.ChannelCacheMaxLength)
+}
+
+// _evictExpiredTombstones removes up to count of the oldest tombstones deleted more than ExpiredTombstoneAge ago, ahead
+// of the older entries that would otherwise be pruned.  Clients that have synced since the deletion don't need them,
+// but evicting them leaves gaps in the cache, so reads by other clients are backfilled by query through the last
+// evicted tombstone (see excludingEvictedTombstones).  Caller MUST be holding the lock.
+func (c *singleChannelCacheImpl) _evictExpiredTombstones(count int) (evicted int) {
+	expiredBefore := time.Now().Add(-c.options.ExpiredTombstoneAge)
+	var logs LogEntries
+	for i, entry := range c.logs {
+		expired := evicted < count && entry.Flags&channels.Deleted != 0 && entry.Flags&channels.Removed == 0 &&
+			!entry.TimeDeleted.IsZero() && entry.TimeDeleted.Before(expiredBefore)
+		if !expired {
+			if logs != nil {
+				logs = append(logs, entry)
+			}
+			continue
+		}
+		// Published snapshots share logs, so the remaining entries are copied
+		if logs == nil {
+			logs = make(LogEntries, i, len(c.logs))
+			copy(logs, c.logs[:i])
+		}
+		c.UpdateCacheUtilization(entry, -1)
+		delete(c.cachedDocs, entry.DocID)
+		c.evictedTombstone = entry.Sequence
+		evicted++
+	}
+	if evicted > 0 {
+		c.logs = logs
+		c.logsShared = false
+		base.Debugf(base.KeyCache, "Evicted %d expired tombstones from channel %q", evicted, base.UD(c.channelName))
+	}
+	return evicted
+}
+
+// _pruneToLength prunes the oldest entries, leaving at most length entries.  Caller MUST be holding the lock.
+func (c *singleChannelCacheImpl) _pruneToLength(length int) (pruned int) {
+	if len(c.logs) > length {
+		pruned = len(c.logs) - length
 		for i := 0; i < pruned; i++ {
 			c.UpdateCacheUtilization(c.logs[i], -1)
-			delete(c.cachedDocIDs, c.logs[i].DocID)
+			delete(c.cachedDocs, c.logs[i].DocID)
 		}
+		c._retainRemovals(c.logs[:pruned])
 		c.validFrom = c.logs[pruned-1].Sequence + 1
 		c.logs = c.logs[pruned:]
 	}
@@ -296,25 +550,41 @@ func (c *singleChannelCacheImpl) pruneCacheAge(ctx context.Context) {
 	}
 
 	pruned := 0
+	logs := c.logs
 	// Remove all entries who've been in the cache longer than channelCacheAge, except
 	// those that fit within channelCacheMinLength and therefore not subject to cache age restrictions
 	for len(c.logs) > c.options.ChannelCacheMinLength && time.Since(c.logs[0].TimeReceived) > c.options.ChannelCacheAge {
 		c.validFrom = c.logs[0].Sequence + 1
 		c.UpdateCacheUtilization(c.logs[0], -1)
-		delete(c.cachedDocIDs, c.logs[0].DocID)
+		delete(c.cachedDocs, c.logs[0].DocID)
 		c.logs = c.logs[1:]
 		pruned++
 	}
+	if pruned > 0 {
+		c._retainRemovals(logs[:pruned])
+		c._publishSnapshot()
+	}
 	base.DebugfCtx(ctx, base.KeyCache, "Pruned %d old entries from channel %q", pruned, base.UD(c.channelName))
 
 }
 
 // Returns all of the cached entries for sequences greater than 'since' in the given channel.
 // Entries are returned in increasing-sequence order.
+// Reads the most recently published snapshot, without locking.
 func (c *singleChannelCacheImpl) GetCachedChanges(options ChangesOptions) (validFrom uint64, result []*LogEntry) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	c.recentlyUsed.Set(true)
+	validFrom, entries := c.cachedChanges(options)
+	if entries == nil {
+		return validFrom, nil
+	}
+	result = make([]*LogEntry, len(entries))
+	copy(result, entries)
+	return validFrom, result
+}
+
+// cachedChanges returns the cached changes as GetCachedChanges does, as a slice of the cache's current snapshot rather
+// than a copy, so must not be modified.
+func (c *singleChannelCacheImpl) cachedChanges(options ChangesOptions) (validFrom uint64, entries []*LogEntry) {
+	c.setRecentlyUsed()
 	sinceSeq := options.Since.SafeSequence()
 	limit := options.Limit
 
@@ -325,14 +595,83 @@ func (c *singleChannelCacheImpl) GetCachedChanges(options ChangesOptions) (valid
 		limit = 0
 	}
 
-	return c._getCachedChanges(sinceSeq, limit)
+	snapshot := c.snapshot.Load().(*channelCacheSnapshot)
+	validFrom, entries = snapshot.entriesSince(sinceSeq, limit)
+	if c.options.EntryChecksums && c.dropCorruptEntries(entries) {
+		// Corrupt entries have been dropped, and validFrom moved past them so they're backfilled by query
+		snapshot = c.snapshot.Load().(*channelCacheSnapshot)
+		validFrom, entries = snapshot.entriesSince(sinceSeq, limit)
+	}
+	if !options.omitsExpired {
+		validFrom, entries = snapshot.excludingEvictedTombstones(validFrom, entries)
+	}
+	return validFrom, entries
+}
+
+// dropCorruptEntries verifies the checksums of entries read from the cache.  When any don't match, the cache is
+// rolled back through the last of them so that they aren't served, and true is returned.
+func (c *singleChannelCacheImpl) dropCorruptEntries(entries []*LogEntry) bool {
+	var corrupt map[*LogEntry]struct{}
+	for _, entry := range entries {
+		if entry.Checksum != logEntryChecksum(entry) {
+			if corrupt == nil {
+				corrupt = make(map[*LogEntry]struct{})
+			}
+			base.Warnf("Channel cache entry #%d doc %q / %q in channel %q failed checksum verification - dropping it from the cache",
+				entry.Sequence, base.UD(entry.DocID), entry.RevID, base.UD(c.channelName))
+			corrupt[entry] = struct{}{}
+		}
+	}
+	if len(corrupt) == 0 {
+		return false
+	}
+	c.cacheStats.ChannelCacheCorruptEntries.Add(int64(len(corrupt)))
+	c.rollback(func(entry *LogEntry) bool {
+		_, ok := corrupt[entry]
+		return ok
+	})
+	return true
+}
+
+// logEntryChecksum returns a 32-bit FNV-1a hash of the entry's sequence, doc ID and rev ID.  Computed inline rather
+// than with hash/fnv to avoid allocating on every cache read.
+func logEntryChecksum(entry *LogEntry) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	hash := uint32(offset32)
+	for seq, i := entry.Sequence, 0; i < 8; seq, i = seq>>8, i+1 {
+		hash = (hash ^ uint32(seq&0xff)) * prime32
+	}
+	for i := 0; i < len(entry.DocID); i++ {
+		hash = (hash ^ uint32(entry.DocID[i])) * prime32
+	}
+	// Separates doc and rev IDs, so that moving a character between them changes the checksum
+	hash = (hash ^ 0xff) * prime32
+	for i := 0; i < len(entry.RevID); i++ {
+		hash = (hash ^ uint32(entry.RevID[i])) * prime32
+	}
+	return hash
 }
 
-func (c *singleChannelCacheImpl) _getCachedChanges(sinceSeq uint64, limit int) (validFrom uint64, result []*LogEntry) {
+func (s *channelCacheSnapshot) getCachedChanges(sinceSeq uint64, limit int) (validFrom uint64, result []*LogEntry) {
+	validFrom, entries := s.entriesSince(sinceSeq, limit)
+	if entries == nil {
+		return validFrom, nil
+	}
+	result = make([]*LogEntry, len(entries))
+	copy(result, entries)
+	return validFrom, result
+}
+
+// entriesSince returns up to limit of the snapshot's entries after sinceSeq, and the sequence they're valid from.  The
+// entries are a slice of the snapshot's logs rather than a copy, so must not be modified.
+func (s *channelCacheSnapshot) entriesSince(sinceSeq uint64, limit int) (validFrom uint64, entries []*LogEntry) {
 	// Find the first entry in the log to return:
-	log := c.logs
+	log := s.logs
 	if len(log) == 0 {
-		validFrom = c.validFrom
+		validFrom = s.validFrom
 		return // Return nil if nothing is cached
 	}
 	var start int
@@ -343,7 +682,7 @@ func (c *singleChannelCacheImpl) _getCachedChanges(sinceSeq uint64, limit int) (
 	if start > 0 {
 		validFrom = log[start-1].Sequence + 1
 	} else {
-		validFrom = c.validFrom
+		validFrom = s.validFrom
 	}
 
 	n := len(log) - start
@@ -352,9 +691,19 @@ func (c *singleChannelCacheImpl) _getCachedChanges(sinceSeq uint64, limit int) (
 		n = limit
 	}
 
-	result = make([]*LogEntry, n)
-	copy(result[0:], log[start:])
-	return
+	// Capped, so that appending to the entries can't write to the snapshot's logs
+	return validFrom, log[start : start+n : start+n]
+}
+
+// excludingEvictedTombstones adjusts the validFrom and entries returned by entriesSince for a reader that may need the
+// expired tombstones evicted from the snapshot's logs.  The logs are only complete after the last evicted tombstone,
+// so the entries preceding it are dropped, to be read by query instead.
+func (s *channelCacheSnapshot) excludingEvictedTombstones(validFrom uint64, entries []*LogEntry) (uint64, []*LogEntry) {
+	if s.evictedTombstone < validFrom {
+		return validFrom, entries
+	}
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Sequence > s.evictedTombstone })
+	return s.evictedTombstone + 1, entries[i:]
 }
 
 // Top-level method to get all the changes in a channel since the sequence 'since'.
@@ -365,9 +714,33 @@ func (c *singleChannelCacheImpl) _getCachedChanges(sinceSeq uint64, limit int) (
 // nextSequence.
 
 func (c *singleChannelCacheImpl) GetChanges(options ChangesOptions) ([]*LogEntry, error) {
+	stream, err := c.readChanges(options)
+	if err != nil {
+		return nil, err
+	}
+	return stream.entries(), nil
+}
+
+// GetChangesStream invokes callback with each of the changes GetChanges would return, in sequence order, until
+// callback returns false.  Cached changes are streamed from a snapshot of the cache, so no lock is held while callback
+// runs.  Returns an error if options.Terminator is closed before streaming completes.
+func (c *singleChannelCacheImpl) GetChangesStream(options ChangesOptions, callback func(*LogEntry) bool) error {
+	stream, err := c.readChanges(options)
+	if err != nil {
+		return err
+	}
+	return stream.forEach(options.Terminator, callback)
+}
+
+// readChanges reads the changes for GetChanges and GetChangesStream, backfilling from a channel query when the cache
+// doesn't go back far enough.
+func (c *singleChannelCacheImpl) readChanges(options ChangesOptions) (changeStream, error) {
+	if options.Descending {
+		return c.readChangesDescending(options)
+	}
 
 	// Use the cache, and return if it fulfilled the entire request:
-	cacheValidFrom, resultFromCache := c.GetCachedChanges(options)
+	cacheValidFrom, resultFromCache := c.cachedChanges(options)
 	numFromCache := len(resultFromCache)
 	if numFromCache > 0 {
 		base.InfofCtx(options.Ctx, base.KeyCache, "GetCachedChanges(%q, %s) --> %d changes valid from #%d",
@@ -378,8 +751,8 @@ func (c *singleChannelCacheImpl) GetChanges(options ChangesOptions) ([]*LogEntry
 	}
 	startSeq := options.Since.SafeSequence() + 1
 	if cacheValidFrom <= startSeq {
-		c.cacheStats.ChannelCacheHits.Add(1)
-		return resultFromCache, nil
+		c.recordAccess(channelCacheHit)
+		return changeStream{fromCache: resultFromCache}, nil
 	}
 
 	// Nope, we're going to have to backfill from the view.
@@ -392,66 +765,128 @@ func (c *singleChannelCacheImpl) GetChanges(options ChangesOptions) ([]*LogEntry
 
 	// Another goroutine might have gotten the lock first and already queried the view and updated
 	// the cache, so repeat the above:
-	cacheValidFrom, resultFromCache = c.GetCachedChanges(options)
+	cacheValidFrom, resultFromCache = c.cachedChanges(options)
 	if len(resultFromCache) > numFromCache {
 		base.InfofCtx(options.Ctx, base.KeyCache, "2nd GetCachedChanges(%q, %s) got %d more, valid from #%d!",
 			base.UD(c.channelName), options.Since.String(), len(resultFromCache)-numFromCache, cacheValidFrom)
 	}
 	if cacheValidFrom <= startSeq {
-		c.cacheStats.ChannelCacheHits.Add(1)
-		return resultFromCache, nil
+		c.recordAccess(channelCacheHit)
+		return changeStream{fromCache: resultFromCache}, nil
 	}
 
 	// Check whether the changes process has been terminated while we waited for the view lock, to avoid the view
 	// overhead in that case (and prevent feedback loop on query backlog)
 	select {
 	case <-options.Terminator:
-		return nil, fmt.Errorf("Changes feed terminated while waiting for view lock")
+		return changeStream{}, fmt.Errorf("Changes feed terminated while waiting for view lock")
 	default:
 		// continue
 	}
 
 	// Now query the view. We set the max sequence equal to cacheValidFrom, so we'll get one
 	// overlap, which helps confirm that we've got everything.
-	c.cacheStats.ChannelCacheMisses.Add(1)
+	if len(resultFromCache) > 0 {
+		c.recordAccess(channelCachePartialHit)
+	} else {
+		c.recordAccess(channelCacheMiss)
+	}
 	endSeq := cacheValidFrom
-	resultFromQuery, err := c.queryHandler.getChangesInChannelFromQuery(c.channelName, startSeq, endSeq, options.Limit, options.ActiveOnly)
+	resultFromQuery, err := queryChannel(c.queryHandler, options.Ctx, c.channelID(), startSeq, endSeq, options.Limit, options.ActiveOnly)
 	if err != nil {
-		return nil, err
+		return changeStream{}, err
+	}
+	if !options.ActiveOnly {
+		resultFromQuery = c.mergeRetainedRemovals(resultFromQuery, startSeq, endSeq, options.Limit)
 	}
 
-	// Cache some of the query results, if there's room in the cache.  If query hit the limit,
+	// Merge the query results into the cache, bounded by its max length.  If query hit the limit,
 	// the query results are only valid for the range of sequences in the result set.
-	// Don't cache when active_only=true since query results aren't complete.
-	if options.ActiveOnly != true {
+	// Don't cache active-only query results since they aren't complete.
+	if !options.ActiveOnly {
 		resultValidTo := endSeq
 		numResults := len(resultFromQuery)
 		if options.Limit != 0 && numResults >= options.Limit {
 			resultValidTo = resultFromQuery[numResults-1].Sequence
 		}
-		if len(resultFromCache) < c.options.ChannelCacheMaxLength {
-			c.prependChanges(resultFromQuery, startSeq, resultValidTo)
+		if c.options.EntryChecksums {
+			for _, entry := range resultFromQuery {
+				entry.Checksum = logEntryChecksum(entry)
+			}
 		}
+		merged := c.prependChanges(resultFromQuery, startSeq, resultValidTo)
+		c.cacheStats.ChannelCacheBackfillMerged.Add(int64(merged))
 	}
 
-	result := resultFromQuery
-	room := options.Limit - len(result)
+	result := changeStream{fromQuery: resultFromQuery}
+	if len(resultFromQuery) > 0 && len(resultFromCache) > 0 && resultFromCache[0].Sequence == resultFromQuery[len(resultFromQuery)-1].Sequence {
+		// The query overlaps the cache by one sequence - prefer the cached entry, whose flags reflect the cache's
+		// processing of the feed
+		result.fromQuery = resultFromQuery[:len(resultFromQuery)-1]
+	}
+	room := options.Limit - len(result.fromQuery)
 	if (options.Limit == 0 || room > 0) && len(resultFromCache) > 0 {
-		// Concatenate the view & cache results:
-		if len(result) > 0 && resultFromCache[0].Sequence == result[len(result)-1].Sequence {
-			resultFromCache = resultFromCache[1:]
-		}
+		// Follow the view results with the cache results:
 		n := len(resultFromCache)
 		if options.Limit > 0 && room > 0 && room < n {
 			n = room
 		}
-		result = append(result, resultFromCache[0:n]...)
+		result.fromCache = resultFromCache[0:n]
 	}
-	base.InfofCtx(options.Ctx, base.KeyCache, "GetChangesInChannel(%q) --> %d rows", base.UD(c.channelName), len(result))
+	base.InfofCtx(options.Ctx, base.KeyCache, "GetChangesInChannel(%q) --> %d rows", base.UD(c.channelName), result.len())
 
 	return result, nil
 }
 
+// readChangesDescending reads the changes for a descending request.  When the cache holds at least limit changes since
+// options.Since, the newest of them are all cached, as the cache is complete from its validFrom.  Otherwise all of the
+// changes since options.Since are read, backfilling the cache as an ascending read would, as channel queries return
+// the lowest sequences first.
+func (c *singleChannelCacheImpl) readChangesDescending(options ChangesOptions) (changeStream, error) {
+
+	// As for ascending reads, the limit doesn't apply when the caller is filtering out non-active entries
+	limit := options.Limit
+	if options.ActiveOnly {
+		limit = 0
+	}
+	options.Descending = false
+	options.Limit = 0
+
+	if limit > 0 {
+		if _, cached := c.cachedChanges(options); len(cached) >= limit {
+			c.recordAccess(channelCacheHit)
+			return changeStream{fromCache: cached}.newest(limit), nil
+		}
+	}
+
+	stream, err := c.readChanges(options)
+	if err != nil {
+		return changeStream{}, err
+	}
+	return stream.newest(limit), nil
+}
+
+// recordAccess counts a changes request in the database's cache stats and the channel's access counts.
+func (c *singleChannelCacheImpl) recordAccess(access channelCacheAccess) {
+	switch access {
+	case channelCacheHit:
+		c.cacheStats.ChannelCacheHits.Add(1)
+		if c.accessCounts != nil {
+			atomic.AddInt64(&c.accessCounts.Hits, 1)
+		}
+	case channelCachePartialHit:
+		c.cacheStats.ChannelCachePartialHits.Add(1)
+		if c.accessCounts != nil {
+			atomic.AddInt64(&c.accessCounts.PartialHits, 1)
+		}
+	case channelCacheMiss:
+		c.cacheStats.ChannelCacheMisses.Add(1)
+		if c.accessCounts != nil {
+			atomic.AddInt64(&c.accessCounts.Misses, 1)
+		}
+	}
+}
+
 //////// LOGENTRIES:
 
 func (c *singleChannelCacheImpl) _adjustFirstSeq(change *LogEntry) {
@@ -471,17 +906,21 @@ func (c *singleChannelCacheImpl) _appendChange(change *LogEntry) {
 			base.Debugf(base.KeyCache, "LogEntries.appendChange: out-of-order sequence #%d (last is #%d) - handling as insert",
 				change.Sequence, log[end].Sequence)
 			// insert the change in the array, ensuring the docID isn't already present
+			c._copyLogs()
 			c.insertChange(&c.logs, change)
 			return
 		}
 		// If entry with DocID already exists, remove it.
-		if _, found := c.cachedDocIDs[change.DocID]; found {
+		if _, found := c.cachedDocs[change.DocID]; found {
+			c._copyLogs()
+			log = c.logs
 			for i := end; i >= 0; i-- {
 				if log[i].DocID == change.DocID {
 					c.UpdateCacheUtilization(log[i], -1)
 					copy(log[i:], log[i+1:])
 					c.UpdateCacheUtilization(change, 1)
 					log[end] = change
+					c.cachedDocs[change.DocID] = change
 					return
 				}
 			}
@@ -493,7 +932,7 @@ func (c *singleChannelCacheImpl) _appendChange(change *LogEntry) {
 	c.logs = append(log, change)
 
 	c.UpdateCacheUtilization(change, 1)
-	c.cachedDocIDs[change.DocID] = struct{}{}
+	c.cachedDocs[change.DocID] = change
 }
 
 // Updates cache utilization.  Note that cache entries that are both removals and tombstones are counted as removals
@@ -513,7 +952,10 @@ func (c *singleChannelCacheImpl) UpdateCacheUtilization(entry *LogEntry, delta i
 func (c *singleChannelCacheImpl) insertChange(log *LogEntries, change *LogEntry) {
 
 	defer func() {
-		c.cachedDocIDs[change.DocID] = struct{}{}
+		// A later revision of the document may already be cached, in which case the change isn't inserted
+		if cached, found := c.cachedDocs[change.DocID]; !found || cached.Sequence < change.Sequence {
+			c.cachedDocs[change.DocID] = change
+		}
 		c.UpdateCacheUtilization(change, 1)
 	}()
 
@@ -521,7 +963,7 @@ func (c *singleChannelCacheImpl) insertChange(log *LogEntries, change *LogEntry)
 
 	insertAtIndex := 0
 
-	_, docIDExists := c.cachedDocIDs[change.DocID]
+	_, docIDExists := c.cachedDocs[change.DocID]
 
 	// Walk log backwards until we find the point where we should insert this change.
 	// (recall that logentries is sorted in ascending sequence order)
@@ -578,6 +1020,15 @@ func (c *singleChannelCacheImpl) insertChange(log *LogEntries, change *LogEntry)
 func (c *singleChannelCacheImpl) prependChanges(changes LogEntries, changesValidFrom uint64, changesValidTo uint64) int {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	defer c._publishSnapshot()
+
+	// When extending the cache back from validFrom, later sequences have been, or will be, received by the cache over
+	// the feed - the cache's entries are preferred over query results for those sequences
+	if changesValidFrom < c.validFrom {
+		for len(changes) > 0 && changes[len(changes)-1].Sequence >= c.validFrom {
+			changes = changes[:len(changes)-1]
+		}
+	}
 
 	// If set of changes to prepend is empty, check whether validFrom should be updated
 	if len(changes) == 0 {
@@ -602,11 +1053,12 @@ func (c *singleChannelCacheImpl) prependChanges(changes LogEntries, changesValid
 		}
 		c.logs = make(LogEntries, len(changes))
 		copy(c.logs, changes)
+		c.logsShared = false
 		base.Infof(base.KeyCache, "  Initialized cache of %q with %d entries from query (#%d--#%d)",
 			base.UD(c.channelName), len(changes), changes[0].Sequence, changes[len(changes)-1].Sequence)
 
 		for _, change := range changes {
-			c.cachedDocIDs[change.DocID] = struct{}{}
+			c.cachedDocs[change.DocID] = change
 			c.UpdateCacheUtilization(change, 1)
 		}
 
@@ -636,13 +1088,13 @@ func (c *singleChannelCacheImpl) prependChanges(changes LogEntries, changesValid
 		change := changes[i]
 		if change != nil && change.Sequence < c.validFrom {
 			// If docid is already in cache, existing revision must be for a later sequence; can ignore this revision.
-			if _, docIdExists := c.cachedDocIDs[change.DocID]; docIdExists {
+			if _, docIdExists := c.cachedDocs[change.DocID]; docIdExists {
 				continue
 			}
 			entriesToPrepend = append(entriesToPrepend, nil)
 			copy(entriesToPrepend[1:], entriesToPrepend)
 			entriesToPrepend[0] = change
-			c.cachedDocIDs[change.DocID] = struct{}{}
+			c.cachedDocs[change.DocID] = change
 			c.UpdateCacheUtilization(change, 1)
 
 			if len(entriesToPrepend) >= cacheCapacity {
@@ -667,12 +1119,42 @@ func (c *singleChannelCacheImpl) prependChanges(changes LogEntries, changesValid
 
 }
 
+// isEmpty returns true when the cache has no entries, and is complete from the start of the channel.
+func (c *singleChannelCacheImpl) isEmpty() bool {
+	snapshot := c.snapshot.Load().(*channelCacheSnapshot)
+	return len(snapshot.logs) == 0 && snapshot.validFrom <= 1
+}
+
 func (c *singleChannelCacheImpl) GetSize() int {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	return len(c.logs)
 }
 
+// setRecentlyUsed flags the cache as recently used for compaction, and records the access time.
+func (c *singleChannelCacheImpl) setRecentlyUsed() {
+	c.recentlyUsed.Set(true)
+	atomic.StoreInt64(&c.lastAccess, time.Now().UnixNano())
+}
+
+// channelID returns the ID of the cached channel.
+func (c *singleChannelCacheImpl) channelID() ChannelID {
+	return NewChannelID(c.collection, c.channelName)
+}
+
+// info returns a summary of the cache's current state, for diagnostics.
+func (c *singleChannelCacheImpl) info() ChannelCacheInfo {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return ChannelCacheInfo{
+		Collection: c.collection,
+		Name:       c.channelName,
+		Size:       len(c.logs),
+		ValidFrom:  c.validFrom,
+		LastAccess: time.Unix(0, atomic.LoadInt64(&c.lastAccess)),
+	}
+}
+
 type lateLogEntry struct {
 	logEntry      *LogEntry
 	arrived       time.Time    // Time arrived in late log - for diagnostics tracking
@@ -829,15 +1311,67 @@ func (c *singleChannelCacheImpl) _mostRecentLateLog() *lateLogEntry {
 // A bypassChannelCache serves GetChanges requests directly via query
 type bypassChannelCache struct {
 	channelName  string
+	collection   string // Empty for the default collection
 	queryHandler ChannelQueryHandler
+	channelCache *channelCacheImpl // Optional - remembers channels with no entries, so they aren't repeatedly queried
 }
 
 // Get Changes uses high sequence value (math.MaxUint64) as the upper bound.  Relies on changes processing
 // to apply HighCachedSequence filtering - same approach used by singleChannelCacheImpl.
 func (b *bypassChannelCache) GetChanges(options ChangesOptions) ([]*LogEntry, error) {
+	stream, err := b.readChanges(options)
+	if err != nil {
+		return nil, err
+	}
+	return stream.entries(), nil
+}
+
+func (b *bypassChannelCache) queryChanges(options ChangesOptions) ([]*LogEntry, error) {
 	startSeq := options.Since.SafeSequence() + 1
 	endSeq := uint64(math.MaxUint64)
-	return b.queryHandler.getChangesInChannelFromQuery(b.channelName, startSeq, endSeq, options.Limit, options.ActiveOnly)
+	channelID := NewChannelID(b.collection, b.channelName)
+	if b.channelCache == nil {
+		return queryChannel(b.queryHandler, options.Ctx, channelID, startSeq, endSeq, options.Limit, options.ActiveOnly)
+	}
+
+	if b.channelCache.isChannelEmpty(channelID) {
+		b.channelCache.cacheStats.ChannelCacheNegativeHits.Add(1)
+		return nil, nil
+	}
+
+	// Only a query for all of the channel's entries establishes that it's empty
+	checkEmpty := startSeq == 1 && !options.ActiveOnly && b.channelCache.beginEmptyCheck(channelID)
+	entries, err := queryChannel(b.queryHandler, options.Ctx, channelID, startSeq, endSeq, options.Limit, options.ActiveOnly)
+	if checkEmpty {
+		b.channelCache.endEmptyCheck(channelID, err == nil && len(entries) == 0)
+	}
+	return entries, err
+}
+
+// GetChangesStream invokes callback with each of the changes GetChanges would return, until callback returns false.
+func (b *bypassChannelCache) GetChangesStream(options ChangesOptions, callback func(*LogEntry) bool) error {
+	stream, err := b.readChanges(options)
+	if err != nil {
+		return err
+	}
+	return stream.forEach(options.Terminator, callback)
+}
+
+func (b *bypassChannelCache) readChanges(options ChangesOptions) (changeStream, error) {
+	if !options.Descending {
+		entries, err := b.queryChanges(options)
+		return changeStream{fromQuery: entries}, err
+	}
+
+	// Channel queries return the lowest sequences first, so all changes since options.Since are queried for the limit
+	// to apply from the highest sequence
+	limit := options.Limit
+	options.Limit = 0
+	entries, err := b.queryChanges(options)
+	if err != nil {
+		return changeStream{}, err
+	}
+	return changeStream{fromQuery: entries}.newest(limit), nil
 }
 
 // No cached changes for bypassChannelCache
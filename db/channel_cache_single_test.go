@@ -15,10 +15,13 @@ import (
 	"log"
 	"math"
 	"math/rand"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -70,6 +73,39 @@ func TestDuplicateDocID(t *testing.T) {
 
 }
 
+// Validates that logs shared with a published snapshot are copied before an entry is replaced or inserted, and only
+// once until they're published again.
+func TestAppendChangeCopiesSharedLogs(t *testing.T) {
+
+	context, err := NewDatabaseContext("db", base.GetTestBucket(t), false, DatabaseContextOptions{})
+	require.NoError(t, err)
+	defer context.Close()
+
+	cache := newSingleChannelCache(context, "Test1", 0, (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache())
+	cache.addToCache(testLogEntry(1, "doc1", "1-a"), false)
+	cache.addToCache(testLogEntry(2, "doc2", "1-a"), false)
+	cache.addToCache(testLogEntry(4, "doc3", "1-a"), false)
+	published := cache.snapshot.Load().(*channelCacheSnapshot).logs
+
+	cache.lock.Lock()
+	cache._appendChange(testLogEntry(5, "doc1", "2-a"))
+	require.False(t, cache.logsShared)
+	copied := &cache.logs[0]
+	cache._appendChange(testLogEntry(6, "doc2", "2-a"))
+	assert.Same(t, copied, &cache.logs[0])
+	cache._appendChange(testLogEntry(3, "doc4", "1-a"))
+	assert.False(t, cache.logsShared)
+	cache._publishSnapshot()
+	cache.lock.Unlock()
+
+	// The previously published snapshot is unaffected
+	assert.True(t, verifyChannelSequences(published, []uint64{1, 2, 4}))
+	entries, err := cache.GetChanges(ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	assert.True(t, verifyChannelSequences(entries, []uint64{3, 4, 5, 6}))
+	assert.True(t, cache.logsShared)
+}
+
 func TestLateArrivingSequence(t *testing.T) {
 
 	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache)()
@@ -433,6 +469,140 @@ func TestChannelCacheRemove(t *testing.T) {
 	assert.True(t, err == nil)
 }
 
+// Validates that removal by sequence, as used for purge markers written by other nodes, only removes the purged doc's
+// entries up to the purge sequence, regardless of when they were received.
+func TestChannelCacheRemoveUpToSequence(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache)()
+
+	context, err := NewDatabaseContext("db", base.GetTestBucket(t), false, DatabaseContextOptions{})
+	require.NoError(t, err)
+	defer context.Close()
+	cache := newSingleChannelCache(context, "Test1", 0, (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache())
+
+	cache.addToCache(testLogEntry(1, "doc1", "1-a"), false)
+	cache.addToCache(testLogEntry(2, "doc3", "3-a"), false)
+	cache.addToCache(testLogEntry(3, "doc5", "5-a"), false)
+
+	// The purge sequence precedes doc5's entry, as for a doc resurrected after the purge
+	assert.Equal(t, 0, cache.RemoveUpToSequence([]string{"doc5"}, 2))
+	assert.Equal(t, 1, cache.RemoveUpToSequence([]string{"doc1", "doc5"}, 2))
+	entries, err := cache.GetChanges(ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	assert.True(t, verifyChannelSequences(entries, []uint64{2, 3}))
+	assert.True(t, verifyChannelDocIDs(entries, []string{"doc3", "doc5"}))
+}
+
+// Validates that entries referencing pruned revisions are dropped when superseded, and otherwise replaced by entries
+// for the current revision without modifying previously read entries.
+func TestChannelCacheRevisionsPruned(t *testing.T) {
+
+	cache := newChannelCacheWithOptions(&testQueryHandler{}, "Test1", 0, ChannelCacheOptions{EntryChecksums: true},
+		(base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache())
+	addToCache := func(entry *LogEntry) {
+		entry.Checksum = logEntryChecksum(entry)
+		cache.addToCache(entry, false)
+	}
+	addToCache(testLogEntry(1, "doc1", "1-a"))
+	addToCache(testLogEntry(2, "doc2", "3-a"))
+	addToCache(testLogEntry(3, "doc3", "5-a"))
+	addToCache(testLogEntry(10, "doc4", "2-a"))
+	// Out of order entry for an earlier revision of doc4 isn't cached
+	addToCache(testLogEntry(5, "doc4", "1-a"))
+	_, entriesBefore := cache.GetCachedChanges(ChangesOptions{})
+	require.Len(t, entriesBefore, 4)
+
+	// Entries not referencing a pruned revision aren't modified
+	dropped, replaced := cache.revisionsPruned("doc3", base.SetOf("4-a", "3-a"), "6-a", true)
+	assert.False(t, dropped || replaced)
+	dropped, replaced = cache.revisionsPruned("doc5", base.SetOf("1-a"), "2-a", true)
+	assert.False(t, dropped || replaced)
+
+	// Superseded entries are dropped
+	dropped, replaced = cache.revisionsPruned("doc1", base.SetOf("1-a"), "3-a", true)
+	assert.True(t, dropped)
+	assert.False(t, replaced)
+
+	// Others are replaced by the current revision
+	dropped, replaced = cache.revisionsPruned("doc2", base.SetOf("2-a", "3-a"), "5-b", false)
+	assert.False(t, dropped)
+	assert.True(t, replaced)
+	dropped, replaced = cache.revisionsPruned("doc4", base.SetOf("2-a"), "3-a", false)
+	assert.False(t, dropped)
+	assert.True(t, replaced)
+
+	// Replaced entries are checksummed, so aren't dropped as corrupt when read
+	_, entries := cache.GetCachedChanges(ChangesOptions{})
+	require.Len(t, entries, 3)
+	assert.True(t, verifyChannelSequences(entries, []uint64{2, 3, 10}))
+	revIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		revIDs = append(revIDs, entry.RevID)
+	}
+	assert.Equal(t, []string{"5-b", "5-a", "3-a"}, revIDs)
+
+	// Previously read entries are unchanged
+	assert.Equal(t, "3-a", entriesBefore[1].RevID)
+	assert.Equal(t, "2-a", entriesBefore[3].RevID)
+
+	// The replacement is updated when its revision is pruned in turn
+	dropped, _ = cache.revisionsPruned("doc2", base.SetOf("5-b"), "6-b", true)
+	assert.True(t, dropped)
+	assert.Equal(t, 2, cache.GetSize())
+}
+
+// Validates that reads of the channel cache's published snapshot see a consistent cache while it's concurrently
+// appended to, inserted into, deduplicated, pruned and removed from.  Intended to be run with the race detector.
+func TestChannelCacheConcurrentReadWrite(t *testing.T) {
+	cache := newChannelCacheWithOptions(&testQueryHandler{}, "Test1", 0, ChannelCacheOptions{ChannelCacheMaxLength: 100},
+		(base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache())
+
+	numWrites := uint64(5000)
+	writesDone := make(chan struct{})
+	go func() {
+		defer close(writesDone)
+		for seq := uint64(1); seq <= numWrites; seq += 2 {
+			// Add pairs out of order, to exercise insert, with repeated doc IDs to exercise deduplication
+			cache.addToCache(testLogEntry(seq+1, fmt.Sprintf("doc_%d", rand.Intn(50)), "1-a"), false)
+			cache.addToCache(testLogEntry(seq, fmt.Sprintf("doc_%d", rand.Intn(50)), "1-a"), false)
+			if seq%101 == 0 {
+				cache.Remove([]string{fmt.Sprintf("doc_%d", rand.Intn(50))}, time.Now())
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for reader := 0; reader < 4; reader++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-writesDone:
+					return
+				default:
+				}
+				validFrom, entries := cache.GetCachedChanges(ChangesOptions{})
+				docIDs := make(map[string]struct{}, len(entries))
+				for i, entry := range entries {
+					if i == 0 {
+						assert.GreaterOrEqual(t, entry.Sequence, validFrom)
+					} else {
+						assert.Greater(t, entry.Sequence, entries[i-1].Sequence)
+					}
+					_, duplicate := docIDs[entry.DocID]
+					assert.False(t, duplicate, "Duplicate entry for doc %s", entry.DocID)
+					docIDs[entry.DocID] = struct{}{}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, entries := cache.GetCachedChanges(ChangesOptions{})
+	assert.Equal(t, cache.GetSize(), len(entries))
+}
+
 func TestChannelCacheStats(t *testing.T) {
 
 	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache)()
@@ -594,6 +764,160 @@ func TestChannelCacheStatsOnPrepend(t *testing.T) {
 	assert.Equal(t, 3, removals)
 }
 
+// Validates that changes requests are counted as hits, partial hits and misses, in aggregate and per channel, and that
+// the per-channel counts are published with the database's stats until they're removed.
+func TestChannelCacheAccessStats(t *testing.T) {
+
+	// The query handler has the entries before each cache's validFrom
+	queryHandler := &testQueryHandler{}
+	for seq := 1; seq <= 5; seq++ {
+		queryHandler.seedEntries(LogEntries{testLogEntryForChannels(seq, []string{"ABC", "DEF"})})
+	}
+	dbName := "access_stats_db"
+	cacheStats := base.SyncGatewayStats.NewDBStats(dbName, false, false, false).Cache()
+	defer base.RemovePerDbStats(dbName)
+
+	// ABC has nothing cached, so a request below its validFrom is a miss.  The query results are merged into the
+	// cache, so the same request is then a hit.
+	abcCache := newChannelCacheWithOptions(queryHandler, "ABC", 6, ChannelCacheOptions{}, cacheStats)
+	entries, err := abcCache.GetChanges(ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	assert.Equal(t, int64(1), cacheStats.ChannelCacheMisses.Value())
+	assert.Equal(t, int64(0), cacheStats.ChannelCacheHits.Value())
+	entries, err = abcCache.GetChanges(ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	assert.Equal(t, int64(1), cacheStats.ChannelCacheMisses.Value())
+	assert.Equal(t, int64(1), cacheStats.ChannelCacheHits.Value())
+
+	// DEF has entries from its validFrom, so a request below it is a partial hit
+	defCache := newChannelCacheWithOptions(queryHandler, "DEF", 6, ChannelCacheOptions{}, cacheStats)
+	defCache.addToCache(testLogEntry(6, "doc_6", "1-abc"), false)
+	defCache.addToCache(testLogEntry(7, "doc_7", "1-abc"), false)
+	entries, err = defCache.GetChanges(ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	require.Len(t, entries, 7)
+	assert.Equal(t, int64(1), cacheStats.ChannelCachePartialHits.Value())
+	assert.Equal(t, int64(1), cacheStats.ChannelCacheMisses.Value())
+
+	assert.Equal(t, base.ChannelCacheAccessCounts{Hits: 1, Misses: 1}, *cacheStats.ChannelAccess.Channel("ABC"))
+	assert.Equal(t, base.ChannelCacheAccessCounts{PartialHits: 1}, *cacheStats.ChannelAccess.Channel("DEF"))
+	marshalled, err := base.JSONMarshal(cacheStats.ChannelAccess)
+	require.NoError(t, err)
+	var published map[string]base.ChannelCacheAccessCounts
+	require.NoError(t, base.JSONUnmarshal(marshalled, &published))
+	assert.Equal(t, map[string]base.ChannelCacheAccessCounts{
+		base.UD("ABC").Redact(): {Hits: 1, Misses: 1},
+		base.UD("DEF").Redact(): {PartialHits: 1},
+	}, published)
+
+	// The counts are removed with the database's stats
+	assert.Contains(t, base.SyncGatewayStats.String(), `"chan_cache_channel_access":{`)
+	base.RemovePerDbStats(dbName)
+	assert.NotContains(t, base.SyncGatewayStats.String(), dbName)
+}
+
+// Validates that the most recent removals pruned from a channel's cache are retained, and merged into backfills that
+// the channel query doesn't return them in, while the other pruned entries are only served by query.
+func TestChannelCacheRemovalRetention(t *testing.T) {
+
+	// The query doesn't find the removals, as happens once the removed docs have been updated
+	queryHandler := &testQueryHandler{}
+	queryHandler.seedEntries(LogEntries{
+		testLogEntryForChannels(1, []string{"ABC"}),
+		testLogEntryForChannels(4, []string{"ABC"}),
+	})
+
+	for _, retention := range []int{0, 1} {
+		t.Run(fmt.Sprintf("retention=%d", retention), func(t *testing.T) {
+			cacheStats := (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache()
+			options := ChannelCacheOptions{ChannelCacheMinLength: 1, ChannelCacheMaxLength: 2, RemovalRetention: retention}
+			cache := newChannelCacheWithOptions(queryHandler, "ABC", 1, options, cacheStats)
+
+			// Entries 1 to 4 are pruned as 3 to 6 are added
+			cache.addToCache(testLogEntry(1, "doc_1", "1-abc"), false)
+			cache.addToCache(testLogEntry(2, "removedA", "2-abc"), true)
+			cache.addToCache(testLogEntry(3, "removedB", "2-abc"), true)
+			for seq := uint64(4); seq <= 6; seq++ {
+				cache.addToCache(testLogEntry(seq, fmt.Sprintf("doc_%d", seq), "1-abc"), false)
+			}
+			validFrom, cached := cache.GetCachedChanges(ChangesOptions{Since: SequenceID{Seq: 0}})
+			assert.Equal(t, uint64(5), validFrom)
+			require.Len(t, cached, 2)
+
+			entries, err := cache.GetChanges(ChangesOptions{Since: SequenceID{Seq: 0}})
+			require.NoError(t, err)
+			var sequences []uint64
+			for _, entry := range entries {
+				sequences = append(sequences, entry.Sequence)
+			}
+			if retention == 0 {
+				assert.Equal(t, []uint64{1, 4, 5, 6}, sequences)
+				assert.Equal(t, int64(0), cacheStats.ChannelCacheRemovalsRetained.Value())
+				assert.Equal(t, int64(2), cacheStats.ChannelCacheRemovalsPruned.Value())
+				return
+			}
+
+			// Only the later removal is retained
+			assert.Equal(t, []uint64{1, 3, 4, 5, 6}, sequences)
+			assert.True(t, entries[1].IsRemoved())
+			assert.Equal(t, "removedB", entries[1].DocID)
+			assert.Equal(t, int64(2), cacheStats.ChannelCacheRemovalsRetained.Value())
+			assert.Equal(t, int64(1), cacheStats.ChannelCacheRemovalsPruned.Value())
+
+			// A purge of the doc drops its retained removal
+			cache.Remove([]string{"removedB"}, time.Now())
+			cache.lock.RLock()
+			assert.Len(t, cache.retainedRemovals, 0)
+			cache.lock.RUnlock()
+		})
+	}
+}
+
+// Validates that expired tombstones are pruned ahead of older entries, and that reads by clients that may need them
+// are backfilled by query through the last evicted tombstone.
+func TestChannelCacheExpiredTombstoneEviction(t *testing.T) {
+
+	expiredTombstone := et(2, "doc_2", "2-abc")
+	expiredTombstone.Channels = channels.ChannelMap{"ABC": nil}
+	expiredTombstone.TimeDeleted = time.Now().Add(-2 * time.Hour)
+	recentTombstone := et(3, "doc_3", "2-abc")
+	recentTombstone.Channels = channels.ChannelMap{"ABC": nil}
+	recentTombstone.TimeDeleted = time.Now()
+
+	queryHandler := &testQueryHandler{}
+	queryHandler.seedEntries(LogEntries{testLogEntryForChannels(1, []string{"ABC"}), expiredTombstone, recentTombstone})
+
+	cacheStats := (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache()
+	options := ChannelCacheOptions{ChannelCacheMinLength: 1, ChannelCacheMaxLength: 4, ExpiredTombstoneAge: time.Hour}
+	cache := newChannelCacheWithOptions(queryHandler, "ABC", 1, options, cacheStats)
+	cache.addToCache(testLogEntry(1, "doc_1", "1-abc"), false)
+	cache.addToCache(expiredTombstone, false)
+	cache.addToCache(recentTombstone, false)
+	cache.addToCache(testLogEntry(4, "doc_4", "1-abc"), false)
+
+	// Adding a fifth entry evicts the expired tombstone, rather than the oldest entry
+	cache.addToCache(testLogEntry(5, "doc_5", "1-abc"), false)
+	validFrom, cached := cache.GetCachedChanges(ChangesOptions{Since: SequenceID{Seq: 0}, omitsExpired: true})
+	assert.Equal(t, uint64(1), validFrom)
+	assert.True(t, verifyChannelSequences(cached, []uint64{1, 3, 4, 5}))
+
+	// Clients that may need the evicted tombstone are only served from the cache after it, and backfilled before it
+	validFrom, cached = cache.GetCachedChanges(ChangesOptions{Since: SequenceID{Seq: 0}})
+	assert.Equal(t, uint64(3), validFrom)
+	assert.True(t, verifyChannelSequences(cached, []uint64{3, 4, 5}))
+	entries, err := cache.GetChanges(ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	assert.True(t, verifyChannelSequences(entries, []uint64{1, 2, 3, 4, 5}))
+
+	// With no expired tombstones left, the oldest entry is pruned
+	cache.addToCache(testLogEntry(6, "doc_6", "1-abc"), false)
+	validFrom, cached = cache.GetCachedChanges(ChangesOptions{Since: SequenceID{Seq: 0}, omitsExpired: true})
+	assert.Equal(t, uint64(2), validFrom)
+	assert.True(t, verifyChannelSequences(cached, []uint64{3, 4, 5, 6}))
+}
+
 func TestBypassSingleChannelCache(t *testing.T) {
 	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache)()
 
@@ -812,3 +1136,223 @@ func writeEntries(entries []*LogEntry) {
 		log.Printf("%d:seq=%d, docID=%s, revID=%s", index, entry.Sequence, entry.DocID, entry.RevID)
 	}
 }
+
+// Measures channel cache read latency while the cache is being written, with reads of the published snapshot
+// compared against reads holding the cache lock.  Reports the 99th percentile read latency.
+func BenchmarkChannelCacheConcurrentReads(b *testing.B) {
+	defer base.DisableTestLogging()()
+
+	benchmarks := []struct {
+		name   string
+		locked bool
+	}{
+		{"Snapshot", false},
+		{"Locked", true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			cache := newSingleChannelCache(&testQueryHandler{}, "Benchmark", 0, (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache())
+			docIDs, revStrings := generateDocs(20.0, 100000)
+
+			terminator := make(chan struct{})
+			writerDone := make(chan struct{})
+			go func() {
+				defer close(writerDone)
+				for i := 0; ; i++ {
+					select {
+					case <-terminator:
+						return
+					default:
+					}
+					cache.addToCache(testLogEntry(uint64(i+1), docIDs[i%len(docIDs)], revStrings[i%len(revStrings)]), false)
+				}
+			}()
+
+			var latenciesLock sync.Mutex
+			var latencies []time.Duration
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				readerLatencies := make([]time.Duration, 0, 1024)
+				for pb.Next() {
+					start := time.Now()
+					if bm.locked {
+						cache.lock.RLock()
+						_, _ = cache.GetCachedChanges(ChangesOptions{})
+						cache.lock.RUnlock()
+					} else {
+						_, _ = cache.GetCachedChanges(ChangesOptions{})
+					}
+					readerLatencies = append(readerLatencies, time.Since(start))
+				}
+				latenciesLock.Lock()
+				latencies = append(latencies, readerLatencies...)
+				latenciesLock.Unlock()
+			})
+			b.StopTimer()
+			close(terminator)
+			<-writerDone
+
+			if len(latencies) > 0 {
+				sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+				b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns/read")
+			}
+		})
+	}
+}
+
+// Validates that GetChangesStream streams the same changes as GetChanges, from the cache and backfill queries, and
+// stops when the callback returns false or the terminator is closed.
+func TestGetChangesStream(t *testing.T) {
+
+	queryHandler := &testQueryHandler{}
+	for seq := 1; seq <= 6; seq++ {
+		queryHandler.seedEntries(LogEntries{testLogEntryForChannels(seq, []string{"Test1"})})
+	}
+	cache := newSingleChannelCache(queryHandler, "Test1", 6, (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache())
+	for seq := 6; seq <= 10; seq++ {
+		cache.addToCache(testLogEntryForChannels(seq, []string{"Test1"}), false)
+	}
+
+	streamSequences := func(options ChangesOptions, maxEntries int) ([]uint64, error) {
+		var sequences []uint64
+		err := cache.GetChangesStream(options, func(entry *LogEntry) bool {
+			sequences = append(sequences, entry.Sequence)
+			return len(sequences) < maxEntries
+		})
+		return sequences, err
+	}
+
+	// The callback stops the stream partway through the backfilled entries
+	sequences, err := streamSequences(ChangesOptions{Since: SequenceID{Seq: 0}}, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3}, sequences)
+	assert.Equal(t, 1, queryHandler.queryCount)
+
+	// The backfill was merged into the cache, so the full stream is served from the cache without querying
+	sequences, err = streamSequences(ChangesOptions{Since: SequenceID{Seq: 0}}, math.MaxInt32)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, sequences)
+	assert.Equal(t, 1, queryHandler.queryCount)
+
+	entries, err := cache.GetChanges(ChangesOptions{Since: SequenceID{Seq: 4}, Limit: 3})
+	require.NoError(t, err)
+	sequences, err = streamSequences(ChangesOptions{Since: SequenceID{Seq: 4}, Limit: 3}, math.MaxInt32)
+	require.NoError(t, err)
+	assert.True(t, verifyChannelSequences(entries, sequences))
+
+	// A closed terminator ends the stream with an error
+	terminator := make(chan bool)
+	close(terminator)
+	sequences, err = streamSequences(ChangesOptions{Since: SequenceID{Seq: 0}, Terminator: terminator}, math.MaxInt32)
+	assert.Equal(t, ErrChangesStreamTerminated, err)
+	assert.Empty(t, sequences)
+}
+
+// Validates that descending reads return the same window of changes as ascending reads, newest first, with the limit
+// applied from the highest sequence - whether served from the cache or backfilled by query.
+func TestGetChangesDescending(t *testing.T) {
+
+	queryHandler := &testQueryHandler{}
+	for seq := 1; seq <= 6; seq++ {
+		queryHandler.seedEntries(LogEntries{testLogEntryForChannels(seq, []string{"Test1"})})
+	}
+	newCache := func() *singleChannelCacheImpl {
+		cache := newSingleChannelCache(queryHandler, "Test1", 6, (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache())
+		// Sequence 8 arrives late, after 9 and 10
+		for _, seq := range []int{6, 7, 9, 10, 8} {
+			cache.addToCache(testLogEntryForChannels(seq, []string{"Test1"}), false)
+		}
+		return cache
+	}
+	sequences := func(entries []*LogEntry) []uint64 {
+		result := make([]uint64, 0, len(entries))
+		for _, entry := range entries {
+			result = append(result, entry.Sequence)
+		}
+		return result
+	}
+
+	// Limited reads served by the cache don't query, those extending below validFrom are backfilled
+	cache := newCache()
+	entries, err := cache.GetChanges(ChangesOptions{Since: SequenceID{Seq: 0}, Limit: 3, Descending: true})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{10, 9, 8}, sequences(entries))
+	assert.Equal(t, 0, queryHandler.queryCount)
+	entries, err = cache.GetChanges(ChangesOptions{Since: SequenceID{Seq: 0}, Limit: 7, Descending: true})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{10, 9, 8, 7, 6, 5, 4}, sequences(entries))
+	assert.Equal(t, 1, queryHandler.queryCount)
+
+	testCases := []struct {
+		name  string
+		since uint64
+		limit int
+	}{
+		{name: "all", since: 0},
+		{name: "since", since: 7},
+		{name: "since with limit", since: 6, limit: 2},
+		{name: "limit over cached", since: 0, limit: 8},
+		{name: "limit over available", since: 8, limit: 5},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Both orderings are read from a new cache, so each is backfilled when the window extends below validFrom
+			ascending, err := newCache().GetChanges(ChangesOptions{Since: SequenceID{Seq: tc.since}})
+			require.NoError(t, err)
+			descending, err := newCache().GetChanges(ChangesOptions{Since: SequenceID{Seq: tc.since}, Limit: tc.limit, Descending: true})
+			require.NoError(t, err)
+
+			expected := sequences(ascending)
+			for i, j := 0, len(expected)-1; i < j; i, j = i+1, j-1 {
+				expected[i], expected[j] = expected[j], expected[i]
+			}
+			if tc.limit > 0 && len(expected) > tc.limit {
+				expected = expected[:tc.limit]
+			}
+			assert.Equal(t, expected, sequences(descending))
+
+			var streamed []*LogEntry
+			require.NoError(t, newCache().GetChangesStream(ChangesOptions{Since: SequenceID{Seq: tc.since}, Limit: tc.limit, Descending: true}, func(entry *LogEntry) bool {
+				streamed = append(streamed, entry)
+				return true
+			}))
+			assert.Equal(t, expected, sequences(streamed))
+		})
+	}
+
+	bypassCache := &bypassChannelCache{channelName: "Test1", queryHandler: queryHandler}
+	entries, err = bypassCache.GetChanges(ChangesOptions{Since: SequenceID{Seq: 0}, Limit: 2, Descending: true})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{6, 5}, sequences(entries))
+}
+
+// Compares the memory allocated reading a 50k entry channel cache as a slice, and as a stream.
+func BenchmarkChannelCacheReadLargeChannel(b *testing.B) {
+
+	defer base.DisableTestLogging()()
+
+	const numEntries = 50000
+	options := DefaultCacheOptions().ChannelCacheOptions
+	options.ChannelCacheMaxLength = numEntries
+	cache := newChannelCacheWithOptions(&testQueryHandler{}, "Benchmark", 0, options, (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache())
+	for i := 1; i <= numEntries; i++ {
+		cache.addToCache(testLogEntry(uint64(i), fmt.Sprintf("doc%d", i), "1-a"), false)
+	}
+
+	b.Run("GetChanges", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			entries, _ := cache.GetChanges(ChangesOptions{})
+			for range entries {
+			}
+		}
+	})
+	b.Run("GetChangesStream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = cache.GetChangesStream(ChangesOptions{}, func(*LogEntry) bool {
+				return true
+			})
+		}
+	})
+}
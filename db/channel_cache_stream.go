@@ -0,0 +1,87 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"errors"
+)
+
+// ErrChangesStreamTerminated is returned when a changes stream's terminator is closed before the stream completes.
+var ErrChangesStreamTerminated = errors.New("changes stream terminated")
+
+// changeStream holds the changes read from a channel's cache for GetChanges and GetChangesStream: entries backfilled
+// by channel query, followed by entries from the cache.  The cached entries are a slice of the cache's immutable
+// snapshot rather than a copy, so streaming them doesn't buffer the channel's changes a second time, or hold the
+// cache's lock while the caller processes each entry.  Neither slice may be modified.
+type changeStream struct {
+	fromQuery  []*LogEntry
+	fromCache  []*LogEntry
+	descending bool // Entries are streamed newest-first, from the end of fromCache to the start of fromQuery
+}
+
+func (s changeStream) len() int {
+	return len(s.fromQuery) + len(s.fromCache)
+}
+
+// newest returns a descending stream of the limit highest sequence entries in the stream, or of all of them when
+// limit is zero.
+func (s changeStream) newest(limit int) changeStream {
+	if drop := s.len() - limit; limit > 0 && drop > 0 {
+		if drop < len(s.fromQuery) {
+			s.fromQuery = s.fromQuery[drop:]
+		} else {
+			s.fromCache = s.fromCache[drop-len(s.fromQuery):]
+			s.fromQuery = nil
+		}
+	}
+	s.descending = true
+	return s
+}
+
+// entries returns the stream's entries as a slice, in stream order.  Cached entries are always copied, query results
+// only when they need to be combined or reordered.
+func (s changeStream) entries() []*LogEntry {
+	if len(s.fromCache) == 0 && !s.descending {
+		return s.fromQuery
+	}
+	result := make([]*LogEntry, 0, s.len())
+	_ = s.forEach(nil, func(entry *LogEntry) bool {
+		result = append(result, entry)
+		return true
+	})
+	return result
+}
+
+// forEach invokes callback with each entry in turn, until callback returns false.  Returns
+// ErrChangesStreamTerminated if terminator is closed before all entries have been streamed.
+func (s changeStream) forEach(terminator chan bool, callback func(*LogEntry) bool) error {
+	entrySets := [][]*LogEntry{s.fromQuery, s.fromCache}
+	if s.descending {
+		entrySets[0], entrySets[1] = s.fromCache, s.fromQuery
+	}
+	for _, entries := range entrySets {
+		for i := range entries {
+			entry := entries[i]
+			if s.descending {
+				entry = entries[len(entries)-1-i]
+			}
+			select {
+			case <-terminator:
+				return ErrChangesStreamTerminated
+			default:
+			}
+			if !callback(entry) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
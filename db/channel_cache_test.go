@@ -9,9 +9,11 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
+	"net/http"
 	"sync"
 	"testing"
 	"time"
@@ -34,13 +36,13 @@ func TestChannelCacheMaxSize(t *testing.T) {
 	cache := context.changeCache.getChannelCache()
 
 	// Make channels active
-	_, err = cache.GetChanges("TestA", ChangesOptions{})
+	_, err = cache.GetChanges(NewDefaultChannelID("TestA"), ChangesOptions{})
 	require.NoError(t, err)
-	_, err = cache.GetChanges("TestB", ChangesOptions{})
+	_, err = cache.GetChanges(NewDefaultChannelID("TestB"), ChangesOptions{})
 	require.NoError(t, err)
-	_, err = cache.GetChanges("TestC", ChangesOptions{})
+	_, err = cache.GetChanges(NewDefaultChannelID("TestC"), ChangesOptions{})
 	require.NoError(t, err)
-	_, err = cache.GetChanges("TestD", ChangesOptions{})
+	_, err = cache.GetChanges(NewDefaultChannelID("TestD"), ChangesOptions{})
 	require.NoError(t, err)
 
 	// Add some entries to caches, leaving some empty caches
@@ -90,13 +92,13 @@ func TestChannelCacheSimpleCompact(t *testing.T) {
 	// Add 16 channels to the cache.  Shouldn't trigger compaction (hwm is not exceeded)
 	for i := 1; i <= 16; i++ {
 		channelName := fmt.Sprintf("chan_%d", i)
-		cache.addChannelCache(channelName)
+		cache.addChannelCache(NewDefaultChannelID(channelName))
 	}
 	// Validate cache size
 	assert.Equal(t, 16, cache.channelCaches.Length())
 
 	// Add another channel to cache
-	cache.addChannelCache("chan_17")
+	cache.addChannelCache(NewDefaultChannelID("chan_17"))
 
 	assert.True(t, waitForCompaction(cache), "Compaction didn't complete in expected time")
 
@@ -127,7 +129,7 @@ func TestChannelCacheCompactInactiveChannels(t *testing.T) {
 	// Shouldn't trigger compaction (hwm is not exceeded)
 	for i := 1; i <= 18; i++ {
 		channelName := fmt.Sprintf("chan_%d", i)
-		cache.addChannelCache(channelName)
+		cache.addChannelCache(NewDefaultChannelID(channelName))
 		if i%2 == 1 {
 			log.Printf("Marking channel %s as active", channelName)
 			activeChannels.IncrChannel(channelName)
@@ -138,7 +140,7 @@ func TestChannelCacheCompactInactiveChannels(t *testing.T) {
 
 	log.Printf("adding 19th element to cache...")
 	// Add another channel to cache, should trigger compaction
-	cache.addChannelCache("chan_19")
+	cache.addChannelCache(NewDefaultChannelID("chan_19"))
 
 	assert.True(t, waitForCompaction(cache), "Compaction didn't complete in expected time")
 
@@ -182,7 +184,7 @@ func TestChannelCacheCompactNRU(t *testing.T) {
 	// Shouldn't trigger compaction (hwm is not exceeded)
 	for i := 1; i <= 18; i++ {
 		channelName := fmt.Sprintf("chan_%d", i)
-		cache.addChannelCache(channelName)
+		cache.addChannelCache(NewDefaultChannelID(channelName))
 		if i <= 10 {
 			log.Printf("Marking channel %s as active", channelName)
 			activeChannels.IncrChannel(channelName)
@@ -192,7 +194,7 @@ func TestChannelCacheCompactNRU(t *testing.T) {
 	assert.Equal(t, 18, cache.channelCaches.Length())
 
 	// Add another channel to cache, should trigger compaction
-	cache.addChannelCache("chan_19")
+	cache.addChannelCache(NewDefaultChannelID("chan_19"))
 	assert.True(t, waitForCompaction(cache), "Compaction didn't complete in expected time")
 
 	// Expect channels 1-10, 11-15 to be evicted, and all to be marked as NRU during compaction
@@ -227,7 +229,7 @@ func TestChannelCacheCompactNRU(t *testing.T) {
 			log.Printf("Marking channel %s as inactive", channelName)
 			activeChannels.DecrChannel(channelName)
 		} else {
-			cache.addChannelCache(channelName)
+			cache.addChannelCache(NewDefaultChannelID(channelName))
 		}
 	}
 
@@ -300,7 +302,7 @@ func TestChannelCacheHighLoadCacheHit(t *testing.T) {
 				channelNumber := rand.Intn(channelCount) + 1
 				channelName := fmt.Sprintf("chan_%d", channelNumber)
 				options := ChangesOptions{}
-				changes, err := cache.GetChanges(channelName, options)
+				changes, err := cache.GetChanges(NewDefaultChannelID(channelName), options)
 				if len(changes) == 1 {
 					changesSuccessCount++
 				}
@@ -370,7 +372,7 @@ func TestChannelCacheHighLoadCacheMiss(t *testing.T) {
 				channelNumber := rand.Intn(channelCount) + 1
 				channelName := fmt.Sprintf("chan_%d", channelNumber)
 				options := ChangesOptions{}
-				changes, err := cache.GetChanges(channelName, options)
+				changes, err := cache.GetChanges(NewDefaultChannelID(channelName), options)
 				if len(changes) == 1 {
 					changesSuccessCount++
 				}
@@ -426,7 +428,7 @@ func TestChannelCacheBypass(t *testing.T) {
 	for c := 1; c <= channelCount; c++ {
 		channelName := fmt.Sprintf("chan_%d", c)
 		options := ChangesOptions{}
-		changes, err := cache.GetChanges(channelName, options)
+		changes, err := cache.GetChanges(NewDefaultChannelID(channelName), options)
 		assert.NoError(t, err, fmt.Sprintf("Error getting changes for channel %s", channelName))
 		assert.True(t, len(changes) == 1, "Expected one change per channel")
 	}
@@ -437,6 +439,149 @@ func TestChannelCacheBypass(t *testing.T) {
 	assert.Equal(t, 80, int(bypassCountStat.Value()))
 }
 
+// Validates that channels matching UncachedChannelPatterns are notified of changes but never cached, and are read by
+// query.
+func TestChannelCacheUncachedChannels(t *testing.T) {
+	defer base.SetUpTestLogging(base.LevelWarn, base.KeyCache)()
+
+	options := DefaultCacheOptions().ChannelCacheOptions
+	options.UncachedChannelPatterns = []string{"user.*", "*.private", "audit"}
+
+	testStats := (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache()
+	queryHandler := &testQueryHandler{}
+	activeChannels := channels.NewActiveChannels(&base.SgwIntStat{})
+	cache, err := newChannelCache("testDb", options, queryHandler, activeChannels, testStats)
+	require.NoError(t, err, "Background task error whilst creating channel cache")
+	defer cache.Stop()
+
+	uncachedNames := []string{"user.alice", "team.private", "audit"}
+	channelNames := append([]string{"shared", "auditors"}, uncachedNames...)
+	for _, channelName := range channelNames {
+		cache.addChannelCache(NewDefaultChannelID(channelName))
+	}
+
+	// Seed the query handler with the doc.  Don't reuse queryEntry for the cache, as AddToCache strips out the
+	// channels property
+	queryHandler.seedEntries(LogEntries{testLogEntryForChannels(1, channelNames)})
+	updatedChannels := cache.AddToCache(testLogEntryForChannels(1, channelNames))
+	for _, channelName := range channelNames {
+		assert.Contains(t, updatedChannels, channelName)
+	}
+
+	infos, _, err := cache.ListChannelCaches(ChannelCacheSortName, 0, "")
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "auditors", infos[0].Name)
+	assert.Equal(t, "shared", infos[1].Name)
+
+	for _, channelName := range uncachedNames {
+		changes, err := cache.GetChanges(NewDefaultChannelID(channelName), ChangesOptions{})
+		require.NoError(t, err)
+		assert.Len(t, changes, 1)
+		_, ok := cache.getActiveChannelCache(NewDefaultChannelID(channelName))
+		assert.False(t, ok, "Channel %s shouldn't be cached", channelName)
+		assert.False(t, cache.seedChannelCache(NewDefaultChannelID(channelName), 1, nil, 0))
+	}
+	assert.Equal(t, len(uncachedNames), queryHandler.queryCount)
+	assert.Equal(t, int64(2), testStats.ChannelCacheNumChannels.Value())
+	assert.Equal(t, int64(0), testStats.ChannelCacheBypassCount.Value())
+
+	// Invalid patterns are rejected
+	options.UncachedChannelPatterns = []string{"user.*.private"}
+	_, err = newChannelCache("testDb", options, queryHandler, activeChannels, testStats)
+	assert.Error(t, err)
+}
+
+func TestChannelNamePatterns(t *testing.T) {
+	patterns, err := newChannelNamePatterns([]string{"user.*", "*.private", "audit"})
+	require.NoError(t, err)
+	for name, expected := range map[string]bool{
+		"user.alice":   true,
+		"user.":        true,
+		"team.private": true,
+		"audit":        true,
+		"auditors":     false,
+		"users":        false,
+		"private":      false,
+		"":             false,
+	} {
+		assert.Equal(t, expected, patterns.matches(name), "Match of %q", name)
+	}
+
+	var noPatterns *channelNamePatterns
+	assert.False(t, noPatterns.matches("user.alice"))
+
+	patterns, err = newChannelNamePatterns([]string{"*"})
+	require.NoError(t, err)
+	assert.True(t, patterns.matches("anything"))
+
+	for _, invalid := range []string{"", "**", "*user*", "user.*.private"} {
+		assert.Error(t, ValidateChannelNamePatterns([]string{invalid}), "Pattern %q", invalid)
+	}
+}
+
+// Validates that repeated reads of a channel with no entries only query once, until an entry is added to the channel.
+func TestChannelCacheNegativeLookup(t *testing.T) {
+	defer base.SetUpTestLogging(base.LevelWarn, base.KeyCache)()
+
+	options := DefaultCacheOptions().ChannelCacheOptions
+	options.MaxNumChannels = 20
+	options.CompactHighWatermarkPercent = 90
+	options.CompactLowWatermarkPercent = 50
+
+	testStats := (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache()
+	queryHandler := &testQueryHandler{}
+	activeChannels := channels.NewActiveChannels(&base.SgwIntStat{})
+	cache, err := newChannelCache("testDb", options, queryHandler, activeChannels, testStats)
+	require.NoError(t, err, "Background task error whilst creating channel cache")
+	defer cache.Stop()
+	cache.Init(10)
+
+	queryCount := func() int {
+		queryHandler.lock.RLock()
+		defer queryHandler.lock.RUnlock()
+		return queryHandler.queryCount
+	}
+
+	// An empty channel's cache is queried once, and is remembered as empty when it's evicted
+	changes, err := cache.GetChanges(NewDefaultChannelID("empty"), ChangesOptions{})
+	require.NoError(t, err)
+	assert.Len(t, changes, 0)
+	assert.Equal(t, 1, queryCount())
+	for i := 1; i <= 18; i++ {
+		cache.addChannelCache(NewDefaultChannelID(fmt.Sprintf("chan_%d", i)))
+	}
+	assert.True(t, waitForCompaction(cache), "Compaction didn't complete in expected time")
+	_, isCached := cache.channelCaches.Get("empty")
+	require.False(t, isCached, "Expected cache for channel to be evicted")
+
+	changes, err = cache.GetChanges(NewDefaultChannelID("empty"), ChangesOptions{})
+	require.NoError(t, err)
+	assert.Len(t, changes, 0)
+	assert.Equal(t, 1, queryCount())
+	assert.Equal(t, int64(1), testStats.ChannelCacheNegativeHits.Value())
+
+	// Fill the cache, so that reads of other channels bypass it
+	for i := 19; cache.channelCaches.Length() < options.MaxNumChannels; i++ {
+		cache.addChannelCache(NewDefaultChannelID(fmt.Sprintf("chan_%d", i)))
+	}
+	for i := 0; i < 5; i++ {
+		changes, err = cache.GetChanges(NewDefaultChannelID("typo"), ChangesOptions{})
+		require.NoError(t, err)
+		assert.Len(t, changes, 0)
+	}
+	assert.Equal(t, 2, queryCount())
+	assert.Equal(t, int64(5), testStats.ChannelCacheNegativeHits.Value())
+
+	// Once an entry is added to the channel, reads query again
+	queryHandler.seedEntries(LogEntries{testLogEntryForChannels(11, []string{"typo"})})
+	cache.AddToCache(testLogEntryForChannels(11, []string{"typo"}))
+	changes, err = cache.GetChanges(NewDefaultChannelID("typo"), ChangesOptions{})
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, 3, queryCount())
+}
+
 func waitForCompaction(cache *channelCacheImpl) (compactionComplete bool) {
 	for i := 0; i <= 10; i++ {
 		if cache.compactRunning.IsTrue() {
@@ -457,7 +602,7 @@ type testQueryHandler struct {
 	lock       sync.RWMutex
 }
 
-func (qh *testQueryHandler) getChangesInChannelFromQuery(channelName string, startSeq, endSeq uint64, limit int, activeOnly bool) (LogEntries, error) {
+func (qh *testQueryHandler) getChangesInChannelFromQuery(ctx context.Context, channelName string, startSeq, endSeq uint64, limit int, activeOnly bool) (LogEntries, error) {
 	queryEntries := make(LogEntries, 0)
 	qh.lock.RLock()
 	for _, entry := range qh.entries {
@@ -507,3 +652,297 @@ func TestChannelCacheBackgroundTaskWithIllegalTimeInterval(t *testing.T) {
 	assert.Equal(t, "CleanAgedItems", backgroundTaskError.TaskName)
 	assert.Equal(t, options.ChannelCacheAge, backgroundTaskError.Interval)
 }
+
+// Pages through the channel cache listing with each sort key, and validates that the pages cover every channel
+// exactly once, in order.
+func TestChannelCacheListPagination(t *testing.T) {
+
+	options := DefaultCacheOptions().ChannelCacheOptions
+	testStats := (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache()
+	queryHandler := &testQueryHandler{}
+	activeChannelStat := &base.SgwIntStat{}
+	activeChannels := channels.NewActiveChannels(activeChannelStat)
+	cache, err := newChannelCache("testDb", options, queryHandler, activeChannels, testStats)
+	require.NoError(t, err, "Background task error whilst creating channel cache")
+	defer cache.Stop()
+
+	// Add channels with a mix of sizes, so that the size ordering has ties
+	numChannels := 250
+	seq := uint64(0)
+	for i := 0; i < numChannels; i++ {
+		channelName := fmt.Sprintf("chan_%d", i)
+		cache.addChannelCache(NewDefaultChannelID(channelName))
+		for j := 0; j < i%5; j++ {
+			seq++
+			cache.AddToCache(logEntry(seq, fmt.Sprintf("doc_%d", seq), "1-a", []string{channelName}))
+		}
+	}
+	// Read a few channels, to vary their access times
+	for i := 0; i < numChannels; i += 50 {
+		_ = cache.GetCachedChanges(NewDefaultChannelID(fmt.Sprintf("chan_%d", i)))
+	}
+
+	for _, sortKey := range []string{ChannelCacheSortName, ChannelCacheSortSize, ChannelCacheSortLastAccess} {
+		var listed []ChannelCacheInfo
+		cursor := ""
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, numChannels, "Listing didn't terminate for sort key %s", sortKey)
+			infos, nextCursor, err := cache.ListChannelCaches(sortKey, 30, cursor)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(infos), 30)
+			listed = append(listed, infos...)
+			if nextCursor == "" {
+				break
+			}
+			cursor = nextCursor
+		}
+
+		require.Len(t, listed, numChannels, "Unexpected channel count for sort key %s", sortKey)
+		seen := make(map[string]struct{}, numChannels)
+		for i, info := range listed {
+			_, duplicate := seen[info.Name]
+			assert.False(t, duplicate, "Channel %s listed twice for sort key %s", info.Name, sortKey)
+			seen[info.Name] = struct{}{}
+			if i == 0 {
+				continue
+			}
+			previous := listed[i-1]
+			switch sortKey {
+			case ChannelCacheSortName:
+				assert.Less(t, previous.Name, info.Name)
+			case ChannelCacheSortSize:
+				assert.True(t, previous.Size > info.Size || (previous.Size == info.Size && previous.Name < info.Name))
+			case ChannelCacheSortLastAccess:
+				assert.False(t, previous.LastAccess.Before(info.LastAccess))
+			}
+		}
+	}
+
+	// The most recently read channel is listed first by last_access
+	infos, _, err := cache.ListChannelCaches(ChannelCacheSortLastAccess, 1, "")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "chan_200", infos[0].Name)
+
+	_, _, err = cache.ListChannelCaches("bogus", 10, "")
+	assert.Error(t, err)
+	_, _, err = cache.ListChannelCaches(ChannelCacheSortSize, 10, "not-a-cursor")
+	assert.Error(t, err)
+}
+
+// corruptCachedEntry modifies the rev ID of the cached entry for seq in place, simulating memory corruption.
+func corruptCachedEntry(t *testing.T, cache *channelCacheImpl, channelName string, seq uint64) {
+	singleCache, ok := cache.getChannelCache(NewDefaultChannelID(channelName)).(*singleChannelCacheImpl)
+	require.True(t, ok)
+	singleCache.lock.Lock()
+	defer singleCache.lock.Unlock()
+	for _, entry := range singleCache.logs {
+		if entry.Sequence == seq {
+			entry.RevID = "1-corrupt"
+			return
+		}
+	}
+	t.Fatalf("Sequence %d not cached in channel %s", seq, channelName)
+}
+
+// Validates that with entry checksums enabled, a corrupt cache entry is detected, isn't served, and is backfilled by
+// query.
+func TestChannelCacheEntryChecksums(t *testing.T) {
+
+	options := DefaultCacheOptions().ChannelCacheOptions
+	options.EntryChecksums = true
+	testStats := (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache()
+	queryHandler := &testQueryHandler{}
+	activeChannelStat := &base.SgwIntStat{}
+	activeChannels := channels.NewActiveChannels(activeChannelStat)
+	cache, err := newChannelCache("testDb", options, queryHandler, activeChannels, testStats)
+	require.NoError(t, err, "Background task error whilst creating channel cache")
+	defer cache.Stop()
+
+	// testQueryHandler doesn't filter by sequence range, so is only seeded with the range that will be backfilled
+	cache.addChannelCache(NewDefaultChannelID("ABC"))
+	for seq := uint64(1); seq <= 5; seq++ {
+		docID := fmt.Sprintf("doc_%d", seq)
+		cache.AddToCache(logEntry(seq, docID, "1-a", []string{"ABC"}))
+		if seq <= 3 {
+			queryHandler.seedEntries(LogEntries{logEntry(seq, docID, "1-a", []string{"ABC"})})
+		}
+	}
+
+	validateChanges := func() {
+		entries, err := cache.GetChanges(NewDefaultChannelID("ABC"), ChangesOptions{Since: SequenceID{Seq: 0}})
+		require.NoError(t, err)
+		require.Len(t, entries, 5)
+		for i, entry := range entries {
+			assert.Equal(t, uint64(i+1), entry.Sequence)
+			assert.Equal(t, "1-a", entry.RevID)
+		}
+	}
+
+	validateChanges()
+	assert.Equal(t, 0, queryHandler.queryCount)
+	assert.Equal(t, int64(0), testStats.ChannelCacheCorruptEntries.Value())
+
+	corruptCachedEntry(t, cache, "ABC", 3)
+	validateChanges()
+	assert.Equal(t, 1, queryHandler.queryCount)
+	assert.Equal(t, int64(1), testStats.ChannelCacheCorruptEntries.Value())
+
+	// Backfilled entries are cached, and pass verification
+	validateChanges()
+	assert.Equal(t, 1, queryHandler.queryCount)
+	assert.Equal(t, int64(1), testStats.ChannelCacheCorruptEntries.Value())
+	assert.Len(t, cache.GetCachedChanges(NewDefaultChannelID("ABC")), 5)
+}
+
+// Validates that the "*" channel is only cached when the star channel is enabled for the database, and that changes for
+// "*" are queried, without counting as a bypass, when it isn't.
+func TestChannelCacheEnableStarChannel(t *testing.T) {
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%t", enabled), func(t *testing.T) {
+			store := newTestCacheBackingStore()
+			options := DefaultCacheOptions()
+			options.EnableStarChannel = enabled
+			cache := newTestChangeCache(t, store, &options)
+			defer cache.Stop()
+
+			starCache := cache.getChannelCache().getSingleChannelCache(NewDefaultChannelID(channels.UserStarChannel))
+			for seq := uint64(1); seq <= 3; seq++ {
+				store.addDoc(seq, []string{"ABC"})
+				changedChannels := cache.processEntry(store.docLogEntry(store.docs[fmt.Sprintf("doc-%d", seq)]))
+				assert.True(t, changedChannels.Contains(channels.UserStarChannel))
+			}
+
+			_, cached := cache.getChannelCache().(*channelCacheImpl).getActiveChannelCache(NewDefaultChannelID(channels.UserStarChannel))
+			assert.Equal(t, enabled, cached)
+			_, bypassed := starCache.(*bypassChannelCache)
+			assert.Equal(t, !enabled, bypassed)
+			assert.Equal(t, int64(0), cache.dbStats.Cache().ChannelCacheBypassCount.Value())
+
+			changes, err := cache.GetChanges(channels.UserStarChannel, ChangesOptions{})
+			require.NoError(t, err)
+			assert.Len(t, changes, 3)
+		})
+	}
+}
+
+// Validates that channels evicted by compaction while changes are being cached and read behave like channels that were
+// never cached: on their next access they're backfilled from the channel query, returning every change in the channel.
+func TestChannelCacheEvictionConcurrentAccess(t *testing.T) {
+
+	const numChannels, numSequences = 40, 1000
+	store := newTestCacheBackingStore()
+	options := DefaultCacheOptions()
+	options.MaxNumChannels = 20
+	cache := newTestChangeCache(t, store, &options)
+	defer cache.Stop()
+	channelCache := cache.getChannelCache().(*channelCacheImpl)
+
+	channelName := func(seq uint64) string {
+		return fmt.Sprintf("chan_%d", seq%numChannels)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				channel := channelName(uint64(rand.Intn(numChannels)))
+				changes, err := cache.GetChanges(channel, ChangesOptions{})
+				if !assert.NoError(t, err) {
+					return
+				}
+				for j, change := range changes {
+					assert.Equal(t, fmt.Sprintf("doc-%d", change.Sequence), change.DocID)
+					assert.Equal(t, channel, channelName(change.Sequence))
+					if j > 0 {
+						assert.Greater(t, change.Sequence, changes[j-1].Sequence)
+					}
+				}
+			}
+		}()
+	}
+
+	for seq := uint64(1); seq <= numSequences; seq++ {
+		store.addDoc(seq, []string{channelName(seq)})
+		store.lock.RLock()
+		entry := store.docLogEntry(store.docs[fmt.Sprintf("doc-%d", seq)])
+		store.lock.RUnlock()
+		cache.processEntry(entry)
+	}
+	close(done)
+	wg.Wait()
+	require.True(t, waitForCompaction(channelCache), "Compaction didn't complete in expected time")
+
+	cacheStats := cache.dbStats.Cache()
+	assert.Greater(t, cacheStats.ChannelCacheChannelsEvictedInactive.Value()+cacheStats.ChannelCacheChannelsEvictedNRU.Value(), int64(0))
+	for i := 0; i < numChannels; i++ {
+		channel := channelName(uint64(i))
+		changes, err := cache.GetChanges(channel, ChangesOptions{})
+		require.NoError(t, err)
+		assert.Len(t, changes, numSequences/numChannels, "Unexpected changes for %s", channel)
+	}
+}
+
+// Validates that channels with the same name in different collections have separate caches, and that unqualified
+// channel names are treated as channels in the default collection.
+func TestChannelCacheCollectionsAreSeparate(t *testing.T) {
+
+	options := DefaultCacheOptions().ChannelCacheOptions
+	testStats := (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache()
+	queryHandler := &testQueryHandler{}
+	activeChannels := channels.NewActiveChannels(&base.SgwIntStat{})
+	cache, err := newChannelCache("testDb", options, queryHandler, activeChannels, testStats)
+	require.NoError(t, err, "Background task error whilst creating channel cache")
+	defer cache.Stop()
+
+	defaultABC := NewDefaultChannelID("ABC")
+	collectionABC := NewChannelID("products", "ABC")
+	assert.Equal(t, defaultABC, NewChannelID("", "ABC"))
+	assert.Equal(t, "ABC", defaultABC.String())
+	assert.Equal(t, collectionABC, ParseChannelID(collectionABC.String()))
+
+	cache.addChannelCache(defaultABC)
+	cache.addChannelCache(collectionABC)
+	updatedChannels := cache.AddToCache(logEntry(1, "doc1", "1-a", []string{"ABC"}))
+	assert.Contains(t, updatedChannels, "ABC")
+	collectionEntry := logEntry(2, "doc2", "1-a", []string{"ABC"})
+	collectionEntry.Collection = "products"
+	updatedChannels = cache.AddToCache(collectionEntry)
+	assert.Contains(t, updatedChannels, collectionABC.String())
+	assert.NotContains(t, updatedChannels, "ABC")
+
+	defaultEntries, err := cache.GetChanges(defaultABC, ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	require.Len(t, defaultEntries, 1)
+	assert.Equal(t, "doc1", defaultEntries[0].DocID)
+	collectionEntries, err := cache.GetChanges(collectionABC, ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	require.Len(t, collectionEntries, 1)
+	assert.Equal(t, "doc2", collectionEntries[0].DocID)
+	assert.Equal(t, 0, queryHandler.queryCount)
+
+	infos, _, err := cache.ListChannelCaches(ChannelCacheSortName, 0, "")
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, ChannelCacheInfo{Name: "ABC", Size: 1, ValidFrom: 1, LastAccess: infos[0].LastAccess}, infos[0])
+	assert.Equal(t, ChannelCacheInfo{Collection: "products", Name: "ABC", Size: 1, ValidFrom: 1, LastAccess: infos[1].LastAccess}, infos[1])
+
+	// Removing one collection's cache leaves the other's
+	assert.True(t, cache.RemoveChannelCache(collectionABC))
+	assert.False(t, cache.RemoveChannelCache(collectionABC))
+	assert.Len(t, cache.GetCachedChanges(defaultABC), 1)
+
+	// The recreated cache needs a backfill, which the query handler only supports for the default collection
+	_, err = cache.GetChanges(collectionABC, ChangesOptions{Since: SequenceID{Seq: 0}})
+	status, _ := base.ErrorAsHTTPStatus(err)
+	assert.Equal(t, http.StatusNotImplemented, status)
+}
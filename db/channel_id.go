@@ -0,0 +1,93 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// DefaultCollection is the collection that channels named without a collection belong to.
+const DefaultCollection = "_default"
+
+// channelIDSeparator separates the collection from the channel name in a qualified ChannelID.  Channel names can't
+// contain a comma, so a qualified ID can't be mistaken for an unqualified channel name.
+const channelIDSeparator = ","
+
+// ChannelID identifies a channel within a collection.  Channels with the same name in different collections are
+// distinct, and have separate channel caches.  Until databases have multiple collections, every channel belongs to the
+// default collection, and unqualified channel names are treated as naming a channel in the default collection.
+type ChannelID struct {
+	Collection string
+	Name       string
+}
+
+// NewChannelID returns the ID of the named channel in collection, or in the default collection when collection is empty.
+func NewChannelID(collection, name string) ChannelID {
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	return ChannelID{Collection: collection, Name: name}
+}
+
+// NewDefaultChannelID returns the ID of the named channel in the default collection.
+func NewDefaultChannelID(name string) ChannelID {
+	return ChannelID{Collection: DefaultCollection, Name: name}
+}
+
+// logEntryChannelID returns the ID of the named channel in the collection of the given entry.
+func logEntryChannelID(entry *LogEntry, name string) ChannelID {
+	return NewChannelID(entry.Collection, name)
+}
+
+// IsDefaultCollection returns true when the channel belongs to the default collection.
+func (id ChannelID) IsDefaultCollection() bool {
+	return id.Collection == "" || id.Collection == DefaultCollection
+}
+
+// String returns the channel name for a channel in the default collection, so that existing channel caches, logs and
+// change notifications are unaffected, and "collection,name" otherwise.  Used to key channel caches.
+func (id ChannelID) String() string {
+	if id.IsDefaultCollection() {
+		return id.Name
+	}
+	return id.Collection + channelIDSeparator + id.Name
+}
+
+// ParseChannelID parses a ChannelID in the form returned by String.
+func ParseChannelID(s string) ChannelID {
+	if i := strings.Index(s, channelIDSeparator); i >= 0 {
+		return NewChannelID(s[:i], s[i+1:])
+	}
+	return NewDefaultChannelID(s)
+}
+
+// collectionChannelQueryHandler is implemented by query handlers able to backfill the channels of collections other
+// than the default collection.
+type collectionChannelQueryHandler interface {
+	getChangesInCollectionChannelFromQuery(ctx context.Context, channelID ChannelID, startSeq, endSeq uint64, limit int, activeOnly bool) (LogEntries, error)
+}
+
+// queryChannel runs the channel query for channelID.  Channels in the default collection are queried by name, and
+// other collections' channels are only queryable by a collectionChannelQueryHandler.
+func queryChannel(queryHandler ChannelQueryHandler, ctx context.Context, channelID ChannelID, startSeq, endSeq uint64, limit int, activeOnly bool) (LogEntries, error) {
+	if channelID.IsDefaultCollection() {
+		return queryHandler.getChangesInChannelFromQuery(ctx, channelID.Name, startSeq, endSeq, limit, activeOnly)
+	}
+	collectionQueryHandler, ok := queryHandler.(collectionChannelQueryHandler)
+	if !ok {
+		return nil, base.HTTPErrorf(http.StatusNotImplemented, "Channel queries aren't supported for collection %q", channelID.Collection)
+	}
+	return collectionQueryHandler.getChangesInCollectionChannelFromQuery(ctx, channelID, startSeq, endSeq, limit, activeOnly)
+}
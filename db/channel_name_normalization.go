@@ -0,0 +1,279 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// Channel name normalization policies, selectable with a database's channel_cache.channel_name_normalization.  Channel
+// names are case sensitive, so a sync function that assigns a channel with inconsistent casing ("Sales" and "sales")
+// splits the channel's changes between two channel caches, and between the changes feeds of each name.  Under the
+// lowercase policy, channel names are lowercased when changes are cached, when caches are read, and when changes
+// requests are resolved to the channels they're fed from.
+const (
+	ChannelNamesPreserve  = "preserve"  // Channel names are used as assigned by the sync function - the default
+	ChannelNamesLowercase = "lowercase" // Channel names differing only by case share a channel cache and changes feed
+)
+
+// ValidateChannelNameNormalization returns an error for an unknown channel name normalization policy.  An empty
+// policy preserves channel names.
+func ValidateChannelNameNormalization(policy string) error {
+	switch policy {
+	case "", ChannelNamesPreserve, ChannelNamesLowercase:
+		return nil
+	}
+	return fmt.Errorf("unknown channel name normalization %q - must be one of %s, %s", policy, ChannelNamesPreserve, ChannelNamesLowercase)
+}
+
+// NormalizeChannelID returns the ID of the channel cache that serves channelID, under the channel cache's channel name
+// normalization policy.
+func (c *channelCacheImpl) NormalizeChannelID(channelID ChannelID) ChannelID {
+	if c.lowercaseNames.IsTrue() && channelID.Name != channels.UserStarChannel {
+		channelID.Name = strings.ToLower(channelID.Name)
+	}
+	return channelID
+}
+
+// _normalizeChannelMap returns a document's channels with their names normalized.  Where names normalize to the same
+// channel, the document is in the channel when it's in any of them, and was otherwise removed at the latest of their
+// removals.  Names that normalize to a different name are recorded as variants of it, for backfill queries.  Requires
+// validFromLock.
+func (c *channelCacheImpl) _normalizeChannelMap(collection string, docChannels channels.ChannelMap) channels.ChannelMap {
+	if !c.lowercaseNames.IsTrue() {
+		return docChannels
+	}
+	normalized := make(channels.ChannelMap, len(docChannels))
+	for name, removal := range docChannels {
+		channelID := c.NormalizeChannelID(NewChannelID(collection, name))
+		if channelID.Name != name {
+			c._addChannelNameVariant(channelID, name)
+		}
+		existing, found := normalized[channelID.Name]
+		switch {
+		case !found:
+			normalized[channelID.Name] = removal
+		case existing == nil || removal == nil:
+			normalized[channelID.Name] = nil
+		case removal.Seq > existing.Seq:
+			normalized[channelID.Name] = removal
+		}
+	}
+	return normalized
+}
+
+// _addChannelNameVariant records name as a channel name that normalizes to channelID.  Requires validFromLock.
+func (c *channelCacheImpl) _addChannelNameVariant(channelID ChannelID, name string) {
+	key := channelID.String()
+	variants, ok := c.nameVariants[key]
+	if !ok {
+		variants = base.Set{}
+		c.nameVariants[key] = variants
+	}
+	variants.Add(name)
+}
+
+// channelNameVariants returns the names channelID's channel is queried by - its own, followed by any names seen on the
+// feed that normalize to it.
+func (c *channelCacheImpl) channelNameVariants(channelID ChannelID) []string {
+	c.validFromLock.RLock()
+	defer c.validFromLock.RUnlock()
+	variants := c.nameVariants[channelID.String()]
+	names := make([]string, 0, len(variants)+1)
+	names = append(names, channelID.Name)
+	names = append(names, variants.ToArray()...)
+	return names
+}
+
+// setChannelNameNormalization changes the channel name normalization policy at runtime.  Enabling the lowercase policy
+// merges the caches of channels whose names differ only by case into the cache of the lowercase name.  Reverting to
+// preserving names drops the caches that merged other names, as they hold changes from channels other than their own.
+// Returns the number of channel caches merged or dropped.
+func (c *channelCacheImpl) setChannelNameNormalization(policy string) (count int) {
+	c.validFromLock.Lock()
+	defer c.validFromLock.Unlock()
+
+	lowercase := policy == ChannelNamesLowercase
+	if c.lowercaseNames.IsTrue() == lowercase {
+		return 0
+	}
+	c.lowercaseNames.Set(lowercase)
+
+	if !lowercase {
+		for key := range c.nameVariants {
+			if c._removeChannelCache(key) {
+				count++
+			}
+		}
+		c.nameVariants = make(map[string]base.Set)
+		base.Infof(base.KeyCache, "Channel names are now preserved - dropped %d channel caches holding merged channels", count)
+		return count
+	}
+
+	keys := c.channelCaches.Keys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		channelID := ParseChannelID(key)
+		normalizedID := c.NormalizeChannelID(channelID)
+		if normalizedID == channelID {
+			continue
+		}
+		c._addChannelNameVariant(normalizedID, channelID.Name)
+		c._mergeChannelCache(normalizedID, channelID)
+		count++
+	}
+	base.Infof(base.KeyCache, "Channel names are now lowercased - merged %d channel caches into the caches of their lowercase names", count)
+	return count
+}
+
+// _mergeChannelCache merges the cache of channelID into the cache of normalizedID, replacing both with a cache of
+// their combined entries.  Each cache is only complete from its validFrom, so the merged cache is valid from the later
+// of them, and earlier entries are dropped.  Requires validFromLock.
+func (c *channelCacheImpl) _mergeChannelCache(normalizedID, channelID ChannelID) {
+	var mergedCaches []*singleChannelCacheImpl
+	var validFrom uint64
+	var entries []*LogEntry
+	for _, key := range []string{normalizedID.String(), channelID.String()} {
+		cacheValue, found := c.channelCaches.Get(key)
+		if !found {
+			continue
+		}
+		cache := AsSingleChannelCache(cacheValue)
+		if cache == nil {
+			continue
+		}
+		snapshot := cache.snapshot.Load().(*channelCacheSnapshot)
+		if snapshot.validFrom > validFrom {
+			validFrom = snapshot.validFrom
+		}
+		entries = append(entries, snapshot.logs...)
+		mergedCaches = append(mergedCaches, cache)
+		c.channelCaches.Remove(key)
+		c.cacheStats.ChannelCacheNumChannels.Add(-1)
+	}
+	if len(mergedCaches) == 0 {
+		return
+	}
+
+	// A change to a doc in both channels is cached by each - only the entry for the channel the doc is in is kept
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Sequence != entries[j].Sequence {
+			return entries[i].Sequence < entries[j].Sequence
+		}
+		return !entries[i].IsRemoved() && entries[j].IsRemoved()
+	})
+	merged := newCollectionChannelCache(c.queryHandler, normalizedID, validFrom, c.options, c.cacheStats)
+	merged.lock.Lock()
+	var lastSequence uint64
+	for _, entry := range entries {
+		if entry.Sequence < validFrom || entry.Sequence == lastSequence {
+			continue
+		}
+		merged._appendChange(entry)
+		lastSequence = entry.Sequence
+	}
+	merged._pruneCacheLength()
+	merged._publishSnapshot()
+	merged.lock.Unlock()
+
+	// Release the utilization counted for the replaced caches' entries
+	for _, cache := range mergedCaches {
+		cache.lock.Lock()
+		cache._pruneToLength(0)
+		cache.lock.Unlock()
+	}
+
+	c.channelCaches.GetOrInsert(normalizedID.String(), merged)
+	c.cacheStats.ChannelCacheNumChannels.Add(1)
+	base.Infof(base.KeyCache, "Merged channel cache for %q into %q, valid from #%d", base.UD(channelID.String()), base.UD(normalizedID.String()), validFrom)
+}
+
+// normalizedQueryHandler backfills the caches of channels under the lowercase channel name normalization policy.
+// Channel queries match channel names exactly, so each of the names seen on the feed that normalize to the channel's
+// name is queried as well, and the results merged.  Names that were only assigned to documents before the cache was
+// started aren't known, so aren't queried - resync with a sync function that assigns consistently cased channel names
+// to make the channels of those documents consistent.
+type normalizedQueryHandler struct {
+	queryHandler ChannelQueryHandler
+	channelCache *channelCacheImpl
+}
+
+func (h *normalizedQueryHandler) getChangesInChannelFromQuery(ctx context.Context, channelName string, startSeq, endSeq uint64, limit int, activeOnly bool) (LogEntries, error) {
+	return h.queryChannelNameVariants(ctx, NewDefaultChannelID(channelName), startSeq, endSeq, limit, activeOnly)
+}
+
+func (h *normalizedQueryHandler) getChangesInCollectionChannelFromQuery(ctx context.Context, channelID ChannelID, startSeq, endSeq uint64, limit int, activeOnly bool) (LogEntries, error) {
+	return h.queryChannelNameVariants(ctx, channelID, startSeq, endSeq, limit, activeOnly)
+}
+
+func (h *normalizedQueryHandler) queryChannelNameVariants(ctx context.Context, channelID ChannelID, startSeq, endSeq uint64, limit int, activeOnly bool) (LogEntries, error) {
+	names := h.channelCache.channelNameVariants(channelID)
+	if len(names) == 1 {
+		return queryChannel(h.queryHandler, ctx, channelID, startSeq, endSeq, limit, activeOnly)
+	}
+
+	// Each doc is returned once, at its latest sequence in any of the channels, preferring the channel it's in
+	latest := make(map[string]*LogEntry)
+	for _, name := range names {
+		entries, err := queryChannel(h.queryHandler, ctx, NewChannelID(channelID.Collection, name), startSeq, endSeq, limit, activeOnly)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			existing, found := latest[entry.DocID]
+			if !found || entry.Sequence > existing.Sequence || (entry.Sequence == existing.Sequence && existing.IsRemoved() && !entry.IsRemoved()) {
+				latest[entry.DocID] = entry
+			}
+		}
+	}
+	result := make(LogEntries, 0, len(latest))
+	for _, entry := range latest {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Sequence < result[j].Sequence
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// normalizeChannelsSince returns the channels a changes request is fed from, with their names normalized under the
+// channel name normalization policy.  Where names normalize to the same channel, the channel is fed from the earliest
+// of their grants.  Access is checked against the requested names before they're normalized, so under the lowercase
+// policy access to any of the names is access to the unified channel.
+func (db *Database) normalizeChannelsSince(channelsSince channels.TimedSet, collection string) channels.TimedSet {
+	channelCache := db.changeCache.getChannelCache()
+	var normalized channels.TimedSet
+	for name := range channelsSince {
+		if channelCache.NormalizeChannelID(NewChannelID(collection, name)).Name != name {
+			normalized = make(channels.TimedSet, len(channelsSince))
+			break
+		}
+	}
+	if normalized == nil {
+		return channelsSince
+	}
+	for name, vbSeq := range channelsSince {
+		normalizedName := channelCache.NormalizeChannelID(NewChannelID(collection, name)).Name
+		if existing, found := normalized[normalizedName]; !found || vbSeq.Sequence < existing.Sequence {
+			normalized[normalizedName] = vbSeq
+		}
+	}
+	return normalized
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateChannelNameNormalization(t *testing.T) {
+	for _, policy := range []string{"", ChannelNamesPreserve, ChannelNamesLowercase} {
+		assert.NoError(t, ValidateChannelNameNormalization(policy))
+	}
+	assert.Error(t, ValidateChannelNameNormalization("uppercase"))
+	assert.Error(t, ValidateChannelNameNormalization("Lowercase"))
+}
+
+// salesEntries returns changes to docs in channels named "Sales" with different casing.  doc4 is in two of them.
+func salesEntries() LogEntries {
+	return LogEntries{
+		logEntry(1, "doc1", "1-a", []string{"Sales"}),
+		logEntry(2, "doc2", "1-a", []string{"sales"}),
+		logEntry(3, "doc3", "1-a", []string{"SALES"}),
+		logEntry(4, "doc4", "1-a", []string{"Sales", "sales"}),
+	}
+}
+
+func assertChangesDocIDs(t *testing.T, cache ChannelCache, channelName string, expectedDocIDs ...string) {
+	entries, err := cache.GetChanges(NewDefaultChannelID(channelName), ChangesOptions{Since: SequenceID{Seq: 0}})
+	require.NoError(t, err)
+	docIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		docIDs = append(docIDs, entry.DocID)
+	}
+	assert.Equal(t, expectedDocIDs, docIDs, "Changes for channel %s", channelName)
+}
+
+// Validates that under the lowercase policy, changes to channels whose names differ only by case are cached in a
+// single cache, read by any of the names, and backfilled from each of them.
+func TestChannelNameNormalizationLowercase(t *testing.T) {
+
+	options := DefaultCacheOptions().ChannelCacheOptions
+	options.ChannelNameNormalization = ChannelNamesLowercase
+	testStats := (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache()
+	queryHandler := &testQueryHandler{}
+	activeChannels := channels.NewActiveChannels(&base.SgwIntStat{})
+	cache, err := newChannelCache("testDb", options, queryHandler, activeChannels, testStats)
+	require.NoError(t, err, "Background task error whilst creating channel cache")
+	defer cache.Stop()
+
+	_, ok := cache.addChannelCache(NewDefaultChannelID("Sales"))
+	require.True(t, ok)
+	for _, entry := range salesEntries() {
+		updatedChannels := cache.AddToCache(entry)
+		assert.Contains(t, updatedChannels, "sales")
+		assert.NotContains(t, updatedChannels, "Sales")
+		assert.NotContains(t, updatedChannels, "SALES")
+	}
+
+	infos, _, err := cache.ListChannelCaches(ChannelCacheSortName, 0, "")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "sales", infos[0].Name)
+	assert.Equal(t, 4, infos[0].Size)
+	assert.Equal(t, int64(1), testStats.ChannelCacheNumChannels.Value())
+	for _, channelName := range []string{"Sales", "sales", "SALES"} {
+		assertChangesDocIDs(t, cache, channelName, "doc1", "doc2", "doc3", "doc4")
+	}
+	assert.Equal(t, 0, queryHandler.queryCount)
+
+	// The recreated cache is backfilled from each of the names seen, with doc4 returned once
+	queryHandler.seedEntries(salesEntries())
+	assert.True(t, cache.RemoveChannelCache(NewDefaultChannelID("SALES")))
+	assert.False(t, cache.RemoveChannelCache(NewDefaultChannelID("sales")))
+	assertChangesDocIDs(t, cache, "Sales", "doc1", "doc2", "doc3", "doc4")
+	assert.Equal(t, 3, queryHandler.queryCount)
+
+	// The star channel isn't affected
+	assert.Equal(t, channels.UserStarChannel, cache.NormalizeChannelID(NewDefaultChannelID(channels.UserStarChannel)).Name)
+}
+
+// Validates that channel names are case sensitive by default.
+func TestChannelNameNormalizationPreserve(t *testing.T) {
+
+	options := DefaultCacheOptions().ChannelCacheOptions
+	testStats := (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache()
+	activeChannels := channels.NewActiveChannels(&base.SgwIntStat{})
+	cache, err := newChannelCache("testDb", options, &testQueryHandler{}, activeChannels, testStats)
+	require.NoError(t, err, "Background task error whilst creating channel cache")
+	defer cache.Stop()
+
+	for _, channelName := range []string{"Sales", "sales", "SALES"} {
+		_, ok := cache.addChannelCache(NewDefaultChannelID(channelName))
+		require.True(t, ok)
+	}
+	for _, entry := range salesEntries() {
+		cache.AddToCache(entry)
+	}
+
+	infos, _, err := cache.ListChannelCaches(ChannelCacheSortName, 0, "")
+	require.NoError(t, err)
+	assert.Len(t, infos, 3)
+	assertChangesDocIDs(t, cache, "Sales", "doc1", "doc4")
+	assertChangesDocIDs(t, cache, "sales", "doc2", "doc4")
+	assertChangesDocIDs(t, cache, "SALES", "doc3")
+}
+
+// Validates that enabling the lowercase policy at runtime merges the existing caches of channels whose names differ
+// only by case, and that reverting to preserving names drops the merged caches.
+func TestChannelNameNormalizationMigration(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache)()
+
+	options := DefaultCacheOptions().ChannelCacheOptions
+	testStats := (base.NewSyncGatewayStats()).NewDBStats("", false, false, false).Cache()
+	activeChannels := channels.NewActiveChannels(&base.SgwIntStat{})
+	cache, err := newChannelCache("testDb", options, &testQueryHandler{}, activeChannels, testStats)
+	require.NoError(t, err, "Background task error whilst creating channel cache")
+	defer cache.Stop()
+
+	for _, channelName := range []string{"Sales", "sales", "SALES", "Other"} {
+		_, ok := cache.addChannelCache(NewDefaultChannelID(channelName))
+		require.True(t, ok)
+	}
+	for _, entry := range salesEntries() {
+		cache.AddToCache(entry)
+	}
+	cache.AddToCache(logEntry(5, "doc5", "1-a", []string{"Other"}))
+	assert.Equal(t, int64(4), testStats.ChannelCacheNumChannels.Value())
+
+	// Repeating the current policy is a no-op
+	assert.Equal(t, 0, cache.setChannelNameNormalization(ChannelNamesPreserve))
+
+	assert.Equal(t, 3, cache.setChannelNameNormalization(ChannelNamesLowercase))
+	infos, _, err := cache.ListChannelCaches(ChannelCacheSortName, 0, "")
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "other", infos[0].Name)
+	assert.Equal(t, 1, infos[0].Size)
+	assert.Equal(t, "sales", infos[1].Name)
+	assert.Equal(t, 4, infos[1].Size)
+	assert.Equal(t, int64(2), testStats.ChannelCacheNumChannels.Value())
+	assertChangesDocIDs(t, cache, "SALES", "doc1", "doc2", "doc3", "doc4")
+	assertChangesDocIDs(t, cache, "Other", "doc5")
+
+	// Subsequent changes are cached in the merged cache
+	cache.AddToCache(logEntry(6, "doc6", "1-a", []string{"SaLeS"}))
+	assertChangesDocIDs(t, cache, "sales", "doc1", "doc2", "doc3", "doc4", "doc6")
+
+	// Reverting drops the caches that merged other names
+	assert.Equal(t, 2, cache.setChannelNameNormalization(ChannelNamesPreserve))
+	infos, _, err = cache.ListChannelCaches(ChannelCacheSortName, 0, "")
+	require.NoError(t, err)
+	assert.Len(t, infos, 0)
+	assert.Equal(t, int64(0), testStats.ChannelCacheNumChannels.Value())
+}
+
+// Validates that under the lowercase policy, a changes request for any casing of a channel name is fed from the
+// unified channel, and that requesting several casings returns each change once.
+func TestChannelNameNormalizationChangesFeed(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyChanges, base.KeyCache)()
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.ChannelCacheOptions.ChannelNameNormalization = ChannelNamesLowercase
+	db := setupTestDBWithCacheOptions(t, cacheOptions)
+	defer db.Close()
+
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+	cacheWaiter := db.NewDCPCachingCountWaiter(t)
+
+	for docID, channelNames := range map[string][]string{
+		"doc1": {"Sales"},
+		"doc2": {"sales"},
+		"doc3": {"Sales", "sales"},
+	} {
+		_, _, err := db.Put(docID, Body{"channels": channelNames})
+		require.NoError(t, err)
+	}
+	cacheWaiter.AddAndWait(3)
+
+	for _, requested := range []base.Set{base.SetOf("Sales"), base.SetOf("sales"), base.SetOf("Sales", "sales")} {
+		changes, err := db.GetChanges(requested, getZeroSequence())
+		require.NoError(t, err)
+		docIDs := make([]string, 0, len(changes))
+		for _, change := range changes {
+			docIDs = append(docIDs, change.ID)
+		}
+		assert.ElementsMatch(t, []string{"doc1", "doc2", "doc3"}, docIDs, "Changes for %s", requested)
+	}
+
+	infos, _, err := db.changeCache.getChannelCache().ListChannelCaches(ChannelCacheSortName, 0, "")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "sales", infos[0].Name)
+}
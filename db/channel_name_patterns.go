@@ -0,0 +1,80 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+const channelNameWildcard = "*"
+
+// channelNamePatterns matches channel names against a set of simple glob patterns: an exact name, a prefix ending in
+// "*" ("user.*"), a suffix starting with "*" ("*.private"), or "*" alone to match every name.
+type channelNamePatterns struct {
+	names    base.Set
+	prefixes []string
+	suffixes []string
+	all      bool
+}
+
+// newChannelNamePatterns parses patterns, returning nil if there are none.
+func newChannelNamePatterns(patterns []string) (*channelNamePatterns, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	p := &channelNamePatterns{names: base.Set{}}
+	for _, pattern := range patterns {
+		wildcards := strings.Count(pattern, channelNameWildcard)
+		switch {
+		case pattern == channelNameWildcard:
+			p.all = true
+		case wildcards == 0 && pattern != "":
+			p.names.Add(pattern)
+		case wildcards == 1 && strings.HasSuffix(pattern, channelNameWildcard):
+			p.prefixes = append(p.prefixes, strings.TrimSuffix(pattern, channelNameWildcard))
+		case wildcards == 1 && strings.HasPrefix(pattern, channelNameWildcard):
+			p.suffixes = append(p.suffixes, strings.TrimPrefix(pattern, channelNameWildcard))
+		default:
+			return nil, fmt.Errorf("invalid channel name pattern %q - must be a channel name, optionally with a leading or trailing %s", pattern, channelNameWildcard)
+		}
+	}
+	return p, nil
+}
+
+// ValidateChannelNamePatterns returns an error for the first pattern that isn't a valid channel name pattern.
+func ValidateChannelNamePatterns(patterns []string) error {
+	_, err := newChannelNamePatterns(patterns)
+	return err
+}
+
+// matches returns true if name matches any of the patterns.  A nil channelNamePatterns matches no names.
+func (p *channelNamePatterns) matches(name string) bool {
+	if p == nil {
+		return false
+	}
+	if p.all || p.names.Contains(name) {
+		return true
+	}
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for _, suffix := range p.suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
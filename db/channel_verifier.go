@@ -0,0 +1,118 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"math/rand"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// channelVerifierQueueSize bounds the number of sampled revisions awaiting verification.  Samples arriving while the
+// queue is full are dropped, so verification never holds up the caching feed.
+const channelVerifierQueueSize = 100
+
+// channelVerifier re-runs the sync function for a sample of the doc revisions received by the change cache, and
+// compares the channels it assigns with those in the revision's sync metadata.  Divergence indicates the sync function
+// has changed since the documents were written, and that a resync is required.  Verification is performed by a single
+// goroutine, to bound its cost.
+type channelVerifier struct {
+	database         *Database              // Runs the sync function, as admin
+	sampleRate       float64                // Fraction of revisions sampled
+	queue            chan verifyChannelsRev // Sampled revisions awaiting verification
+	stats            *base.CacheStats       // Verification and divergence counts
+	mismatchReporter *base.LogCoalescer     // Summarizes diverged docs
+}
+
+type verifyChannelsRev struct {
+	docID string
+	revID string
+}
+
+// newChannelVerifier starts a channelVerifier for the given database, running until terminator is closed.
+func newChannelVerifier(context *DatabaseContext, sampleRate float64, terminator chan bool) *channelVerifier {
+	v := &channelVerifier{
+		database:   &Database{DatabaseContext: context},
+		sampleRate: sampleRate,
+		queue:      make(chan verifyChannelsRev, channelVerifierQueueSize),
+		stats:      context.DbStats.Cache(),
+	}
+	v.mismatchReporter = base.NewLogCoalescer(base.LevelWarn, base.KeyAll,
+		"Cached channels differ from the current sync function's channels - a resync may be required",
+		base.DefaultLogCoalesceInterval, v.stats.ChannelVerificationDivergedCount)
+	go v.run(terminator)
+	return v
+}
+
+// sample queues the revision for verification with probability sampleRate.
+func (v *channelVerifier) sample(docID, revID string) {
+	if rand.Float64() >= v.sampleRate {
+		return
+	}
+	select {
+	case v.queue <- verifyChannelsRev{docID: docID, revID: revID}:
+	default:
+		base.Debugf(base.KeyCache, "Channel verification queue full - not verifying doc %q / %q", base.UD(docID), revID)
+	}
+}
+
+func (v *channelVerifier) run(terminator chan bool) {
+	for {
+		select {
+		case rev := <-v.queue:
+			v.verify(rev.docID, rev.revID)
+		case <-terminator:
+			v.mismatchReporter.Flush()
+			return
+		}
+	}
+}
+
+// verify compares the channels in the sync metadata of the doc's current revision with the channels assigned by the
+// current sync function.  Returns false when the channels diverge.  Revisions that have since been superseded, or
+// that the sync function rejects, aren't verified.
+func (v *channelVerifier) verify(docID, revID string) bool {
+	doc, err := v.database.GetDocument(docID, DocUnmarshalAll)
+	if err != nil || doc.CurrentRev != revID {
+		return true
+	}
+	bodyBytes, _, err := v.database.get1xRevFromDoc(doc, revID, false)
+	if err != nil {
+		return true
+	}
+	var body Body
+	if err := body.Unmarshal(bodyBytes); err != nil {
+		return true
+	}
+	metaMap, err := doc.GetMetaMap(v.database.Options.UserXattrKey)
+	if err != nil {
+		return true
+	}
+	syncChannels, _, _, _, _, err := v.database.getChannelsAndAccess(doc, body, metaMap, revID)
+	if err != nil {
+		return true
+	}
+
+	v.stats.ChannelVerificationCount.Add(1)
+	cachedChannels := make(base.Set, len(doc.Channels))
+	for channel, removal := range doc.Channels {
+		if removal == nil {
+			cachedChannels[channel] = struct{}{}
+		}
+	}
+	if !cachedChannels.Equals(syncChannels) {
+		base.Debugf(base.KeyCache, "Channels for doc %q / %q diverge - cached: %v sync function: %v", base.UD(docID), revID, base.UD(cachedChannels), base.UD(syncChannels))
+		v.mismatchReporter.Add(docID)
+		v.database.resyncAdvisor.record(resyncSignalChannelDivergence, 1)
+		return false
+	}
+	return true
+}
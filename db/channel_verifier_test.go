@@ -0,0 +1,61 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Writes docs with every cached revision sampled for verification, then changes the sync function and validates that
+// verification of the existing revisions detects the divergence.
+func TestChannelVerifierDetectsSyncFunctionChange(t *testing.T) {
+
+	db := setupTestDBWithOptions(t, DatabaseContextOptions{ChannelVerificationRate: 1})
+	defer db.Close()
+
+	_, err := db.UpdateSyncFun(`function(doc) { channel(doc.channels) }`)
+	require.NoError(t, err)
+
+	numDocs := 5
+	revIDs := make(map[string]string, numDocs)
+	for i := 0; i < numDocs; i++ {
+		docID := fmt.Sprintf("doc%d", i)
+		revIDs[docID], _, err = db.Put(docID, Body{"channels": []string{"A"}})
+		require.NoError(t, err)
+	}
+
+	// Channels computed by an unchanged sync function match the cached channels
+	cacheStats := db.DbStats.Cache()
+	_, ok := base.WaitForStat(cacheStats.ChannelVerificationCount.Value, int64(numDocs))
+	require.True(t, ok)
+	assert.Equal(t, int64(0), cacheStats.ChannelVerificationDivergedCount.Value())
+
+	// After a sync function change without resync, the existing revisions diverge
+	_, err = db.UpdateSyncFun(`function(doc) { channel("B") }`)
+	require.NoError(t, err)
+	verifier := db.changeCache.channelVerifier
+	require.NotNil(t, verifier)
+	for docID, revID := range revIDs {
+		assert.False(t, verifier.verify(docID, revID))
+	}
+	assert.Equal(t, int64(2*numDocs), cacheStats.ChannelVerificationCount.Value())
+	assert.Equal(t, int64(numDocs), cacheStats.ChannelVerificationDivergedCount.Value())
+
+	// Superseded revisions aren't verified
+	_, _, err = db.Put("doc0", Body{BodyRev: revIDs["doc0"], "channels": []string{"B"}})
+	require.NoError(t, err)
+	assert.True(t, verifier.verify("doc0", revIDs["doc0"]))
+}
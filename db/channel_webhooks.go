@@ -0,0 +1,317 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+const (
+	// DefaultChannelWebhookTimeout is the timeout of each POST to a channel webhook, when not specified.
+	DefaultChannelWebhookTimeout = 10 * time.Second
+
+	channelWebhookQueueSize       = 100 // Notifications queued for delivery to an endpoint, above which they're dropped
+	channelWebhookMaxAttempts     = 3   // Attempts at each delivery before it's abandoned
+	channelWebhookCircuitFailures = 5   // Consecutive abandoned deliveries after which an endpoint's circuit opens
+)
+
+var (
+	// ChannelWebhookRetryBackoff is the wait before retrying a failed delivery, doubled for each subsequent retry.
+	ChannelWebhookRetryBackoff = 250 * time.Millisecond
+	// ChannelWebhookCircuitOpenTime is how long deliveries to an endpoint are skipped once its circuit opens.
+	ChannelWebhookCircuitOpenTime = 30 * time.Second
+)
+
+// ChannelWebhookOptions configures a webhook notified when any of a set of channels changes.
+type ChannelWebhookOptions struct {
+	URL      string
+	Channels []string      // Channel names, or channel name prefixes followed by *
+	Timeout  time.Duration // Timeout of each POST.  Defaults to DefaultChannelWebhookTimeout
+}
+
+// ChannelWebhookPayload summarises a channel's entries cached since its previous webhook notification.  Each
+// notification POSTs a JSON array with a payload for every changed channel the webhook matches.
+type ChannelWebhookPayload struct {
+	Channel    string `json:"channel"`
+	MaxSeq     uint64 `json:"maxSeq"`     // Sequence of the latest entry
+	EntryCount int    `json:"entryCount"` // Entries cached since the previous notification
+}
+
+// channelWebhookNotifier posts the change cache's changed channel notifications to the webhooks configured for them.
+// Entries cached for a matching channel are summarised until the channel's change is notified - after any coalescing
+// by the notify pacer - then queued for delivery.  Each endpoint has its own bounded queue and delivery worker, so a
+// slow or dead endpoint can't delay notifications to others, nor block the cache: notifications are dropped when an
+// endpoint's queue is full.  Failed deliveries are retried with backoff, and an endpoint's circuit opens after repeated
+// failures, skipping deliveries until ChannelWebhookCircuitOpenTime has passed.
+type channelWebhookNotifier struct {
+	dbName     string
+	client     *http.Client
+	endpoints  []*channelWebhookEndpoint
+	terminator chan struct{}  // Closed by stop
+	workersWg  sync.WaitGroup // Delivery workers, one per endpoint
+	lock       sync.Mutex     // Coordinates access to pending
+	pending    map[string]*ChannelWebhookPayload
+}
+
+// channelWebhookEndpoint is a webhook URL and the channels it's notified of.  Its circuit breaker state is only used
+// by its delivery worker.
+type channelWebhookEndpoint struct {
+	url                 string
+	sanitizedURL        string // URL with any password redacted, for logging and stats
+	channels            base.Set
+	prefixes            []string
+	timeout             time.Duration
+	queue               chan []byte // Payloads awaiting delivery
+	stats               *base.ChannelWebhookStats
+	consecutiveFailures int       // Deliveries abandoned since the last success
+	circuitOpenUntil    time.Time // Deliveries are skipped until this time
+}
+
+// newChannelWebhookNotifier returns a notifier posting to the webhooks configured by options with client, or nil when
+// there are none.
+func newChannelWebhookNotifier(dbName string, options []ChannelWebhookOptions, client *http.Client, dbStats *base.DbStats) *channelWebhookNotifier {
+	if len(options) == 0 {
+		return nil
+	}
+	if client == nil {
+		client = &http.Client{Transport: base.DefaultHTTPTransport()}
+	}
+	n := &channelWebhookNotifier{
+		dbName:     dbName,
+		client:     client,
+		terminator: make(chan struct{}),
+		pending:    make(map[string]*ChannelWebhookPayload),
+	}
+	for _, webhook := range options {
+		endpoint := &channelWebhookEndpoint{
+			url:          webhook.URL,
+			sanitizedURL: base.RedactBasicAuthURLPassword(webhook.URL),
+			channels:     base.Set{},
+			timeout:      webhook.Timeout,
+			queue:        make(chan []byte, channelWebhookQueueSize),
+		}
+		if endpoint.timeout <= 0 {
+			endpoint.timeout = DefaultChannelWebhookTimeout
+		}
+		for _, channelName := range webhook.Channels {
+			if strings.HasSuffix(channelName, "*") {
+				endpoint.prefixes = append(endpoint.prefixes, strings.TrimSuffix(channelName, "*"))
+			} else {
+				endpoint.channels.Add(channelName)
+			}
+		}
+		endpoint.stats = dbStats.ChannelWebhook(endpoint.sanitizedURL)
+		n.endpoints = append(n.endpoints, endpoint)
+
+		n.workersWg.Add(1)
+		go n.deliveryWorker(endpoint)
+	}
+	return n
+}
+
+// matches returns true if the endpoint is notified of changes to the channel.
+func (e *channelWebhookEndpoint) matches(channelName string) bool {
+	if e.channels.Contains(channelName) {
+		return true
+	}
+	for _, prefix := range e.prefixes {
+		if strings.HasPrefix(channelName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns true if any endpoint is notified of changes to the channel.
+func (n *channelWebhookNotifier) matches(channelName string) bool {
+	for _, endpoint := range n.endpoints {
+		if endpoint.matches(channelName) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordEntry adds the entry cached at sequence to the pending summaries of the channels it changed that have
+// webhooks.
+func (n *channelWebhookNotifier) recordEntry(changedChannels []string, sequence uint64) {
+	if n == nil {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for _, channelName := range changedChannels {
+		payload, ok := n.pending[channelName]
+		if !ok {
+			if !n.matches(channelName) {
+				continue
+			}
+			payload = &ChannelWebhookPayload{Channel: channelName}
+			n.pending[channelName] = payload
+		}
+		if sequence > payload.MaxSeq {
+			payload.MaxSeq = sequence
+		}
+		payload.EntryCount++
+	}
+}
+
+// Notify queues delivery of the pending summaries of changedChannels to the webhooks they match.  Never blocks on
+// delivery.
+func (n *channelWebhookNotifier) Notify(changedChannels base.Set) {
+	if n == nil {
+		return
+	}
+
+	var payloads []*ChannelWebhookPayload
+	n.lock.Lock()
+	for channelName := range changedChannels {
+		if payload, ok := n.pending[channelName]; ok {
+			payloads = append(payloads, payload)
+			delete(n.pending, channelName)
+		}
+	}
+	n.lock.Unlock()
+	if len(payloads) == 0 {
+		return
+	}
+	sort.Slice(payloads, func(i, j int) bool { return payloads[i].Channel < payloads[j].Channel })
+
+	for _, endpoint := range n.endpoints {
+		var matched []*ChannelWebhookPayload
+		for _, payload := range payloads {
+			if endpoint.matches(payload.Channel) {
+				matched = append(matched, payload)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		body, err := base.JSONMarshal(matched)
+		if err != nil {
+			base.Warnf("Unable to marshal channel webhook payload for database %s: %v", base.MD(n.dbName), err)
+			continue
+		}
+		select {
+		case endpoint.queue <- body:
+		default:
+			endpoint.stats.Dropped.Add(1)
+			base.Debugf(base.KeyEvents, "Dropped notification of %d channels to channel webhook %s - delivery queue is full", len(matched), base.UD(endpoint.sanitizedURL))
+		}
+	}
+}
+
+// stop stops the delivery workers, abandoning any queued or in-progress deliveries.
+func (n *channelWebhookNotifier) stop() {
+	if n == nil {
+		return
+	}
+	close(n.terminator)
+	n.workersWg.Wait()
+}
+
+// deliveryWorker delivers the endpoint's queued payloads in order until the notifier is stopped.
+func (n *channelWebhookNotifier) deliveryWorker(endpoint *channelWebhookEndpoint) {
+	defer n.workersWg.Done()
+	for {
+		select {
+		case <-n.terminator:
+			return
+		case body := <-endpoint.queue:
+			n.deliver(endpoint, body)
+		}
+	}
+}
+
+// deliver posts the payload to the endpoint, retrying with backoff, unless the endpoint's circuit is open.
+func (n *channelWebhookNotifier) deliver(endpoint *channelWebhookEndpoint, body []byte) {
+	if time.Now().Before(endpoint.circuitOpenUntil) {
+		endpoint.stats.Skipped.Add(1)
+		return
+	}
+
+	backoff := ChannelWebhookRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := n.post(endpoint, body)
+		if err == nil {
+			endpoint.stats.Delivered.Add(1)
+			if endpoint.consecutiveFailures > 0 {
+				base.Infof(base.KeyEvents, "Channel webhook %s for database %s is accepting deliveries again", base.UD(endpoint.sanitizedURL), base.MD(n.dbName))
+			}
+			endpoint.consecutiveFailures = 0
+			endpoint.stats.CircuitOpen.Set(0)
+			return
+		}
+		if attempt >= channelWebhookMaxAttempts {
+			base.Debugf(base.KeyEvents, "Abandoning delivery to channel webhook %s after %d attempts: %v", base.UD(endpoint.sanitizedURL), attempt, err)
+			break
+		}
+		endpoint.stats.Retries.Add(1)
+		select {
+		case <-n.terminator:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	endpoint.stats.Failed.Add(1)
+	endpoint.consecutiveFailures++
+	if endpoint.consecutiveFailures >= channelWebhookCircuitFailures {
+		// Each failure once the circuit has reopened keeps it open for another period
+		endpoint.circuitOpenUntil = time.Now().Add(ChannelWebhookCircuitOpenTime)
+		endpoint.stats.CircuitOpen.Set(1)
+		base.Warnf("Channel webhook %s for database %s has failed %d consecutive deliveries - skipping deliveries for %v",
+			base.UD(endpoint.sanitizedURL), base.MD(n.dbName), endpoint.consecutiveFailures, ChannelWebhookCircuitOpenTime)
+	}
+}
+
+// post makes a single POST of the payload to the endpoint, returning an error unless it responds with a 2xx status.
+// The request is cancelled when the notifier is stopped.
+func (n *channelWebhookNotifier) post(endpoint *channelWebhookEndpoint, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), endpoint.timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-n.terminator:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	// Ensure we're closing the response, so it can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -10,6 +10,8 @@ package db
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"math"
 	"net/http"
@@ -1287,7 +1289,7 @@ func (db *Database) tombstoneActiveRevision(doc *Document, revID string) (tombst
 	// Backup previous revision body, then remove the current body from the doc
 	bodyBytes, err := doc.BodyBytes()
 	if err == nil {
-		_ = db.setOldRevisionJSON(doc.ID, revID, bodyBytes, db.Options.OldRevExpirySeconds)
+		_ = db.setOldRevisionJSON(doc.ID, revID, bodyBytes, db.oldRevExpiry())
 	}
 	doc.RemoveBody()
 
@@ -1495,7 +1497,7 @@ func (db *Database) assignSequence(docSequence uint64, doc *Document, unusedSequ
 		// on the feed is small - sub-second, so we usually shouldn't care about more than
 		// a few recent sequences.  However, the pruning has some overhead (read lock on nextSequence),
 		// so we're allowing more 'recent sequences' on the doc (20) before attempting pruning
-		stableSequence := db.changeCache.GetStableSequence(doc.ID).Seq
+		stableSequence := db.stableSequence.GetStableSequence(doc.ID).Seq
 		count := 0
 		for _, seq := range doc.RecentSequences {
 			// Only remove sequences if they are higher than a sequence that's been seen on the
@@ -1612,7 +1614,10 @@ func (db *Database) documentUpdateFunc(docExists bool, doc *Document, allowImpor
 		return
 	}
 
-	syncFnBody := newDoc.GetDeepMutableBody()
+	syncFnBody := newDoc.declaredBody
+	if syncFnBody == nil {
+		syncFnBody = newDoc.GetDeepMutableBody()
+	}
 
 	// TODO: seems a bit late to do this. Could we move it earlier?
 	err = validateNewBody(syncFnBody)
@@ -1749,8 +1754,12 @@ func (db *Database) updateAndReturnDoc(docid string, allowImport bool, expiry ui
 
 	if db.UseXattrs() || upgradeInProgress {
 		var casOut uint64
-		// Update the document, storing metadata in extended attribute
-		casOut, err = db.Bucket.WriteUpdateWithXattr(key, base.SyncXattrName, db.Options.UserXattrKey, expiry, existingDoc, func(currentValue []byte, currentXattr []byte, currentUserXattr []byte, cas uint64) (raw []byte, rawXattr []byte, deleteDoc bool, syncFuncExpiry *uint32, err error) {
+		// Update the document, storing metadata in extended attribute.  Retries stop once the request's context is done.
+		ctx := db.RequestCtx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		casOut, err = base.WriteUpdateWithXattrCtx(ctx, db.Bucket, key, base.SyncXattrName, db.Options.UserXattrKey, expiry, existingDoc, func(currentValue []byte, currentXattr []byte, currentUserXattr []byte, cas uint64) (raw []byte, rawXattr []byte, deleteDoc bool, syncFuncExpiry *uint32, err error) {
 			// Be careful: this block can be invoked multiple times if there are races!
 			if doc, err = unmarshalDocumentWithXattr(docid, currentValue, currentXattr, currentUserXattr, cas, DocUnmarshalAll); err != nil {
 				return
@@ -1898,6 +1907,9 @@ func (db *Database) updateAndReturnDoc(docid string, allowImport bool, expiry ui
 	// Remove any obsolete non-winning revision bodies
 	doc.deleteRemovedRevisionBodies(db.Bucket)
 
+	// Update any cached changes referencing revisions that have been pruned
+	db.changeCache.RevisionsPruned(doc, doc.prunedRevIDs)
+
 	// Mark affected users/roles as needing to recompute their channel access:
 	db.MarkPrincipalsChanged(docid, newRevID, changedAccessPrincipals, changedRoleAccessUsers, doc.Sequence)
 	return doc, newRevID, nil
@@ -2014,6 +2026,20 @@ func (db *Database) Purge(key string) error {
 	}
 }
 
+// WritePurgeMarker writes a purge marker document for docID, used to notify the change caches of other nodes that the
+// document was purged.  Should be called once the document is purged.  The sequence counter's value is stored as the
+// document body - every sequence the purged document was cached with is at or below it, while a resurrected document
+// is assigned a later sequence.
+func (context *DatabaseContext) WritePurgeMarker(docID string) error {
+	purgeSeq, err := context.sequences.getSequence()
+	if err != nil {
+		return err
+	}
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint64(body, purgeSeq)
+	return context.Bucket.SetRaw(base.PurgeMarkerPrefix+docID, PurgeMarkerTTL, body)
+}
+
 //////// CHANNELS:
 
 // Calls the JS sync function to assign the doc to channels, grant users
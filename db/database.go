@@ -12,8 +12,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -69,6 +71,7 @@ var (
 var DefaultCompactInterval = uint32(60 * 60 * 24) // Default compact interval in seconds = 1 Day
 var (
 	DefaultQueryPaginationLimit = 5000
+	DefaultMaxChangesLimit      = 10000
 )
 
 const (
@@ -80,6 +83,15 @@ const (
 // completion of all background tasks before the server is stopped.
 const BGTCompletionMaxWait = 30 * time.Second
 
+// DCPCheckpointGCInterval is the interval at which import nodes collect the checkpoints of stale checkpoint groups, as a
+// backstop for nodes that aren't shut down cleanly.
+// Var to support testing
+var DCPCheckpointGCInterval = time.Hour
+
+// DatabaseClosePhaseMaxWait is the maximum amount of time to wait for each phase of closing a database, before moving
+// on to the next.  Var to support testing
+var DatabaseClosePhaseMaxWait = 60 * time.Second
+
 // Basic description of a database. Shared between all Database objects on the same database.
 // This object is thread-safe so it can be shared between HTTP handlers.
 type DatabaseContext struct {
@@ -89,6 +101,8 @@ type DatabaseContext struct {
 	BucketSpec         base.BucketSpec         // The BucketSpec
 	BucketLock         sync.RWMutex            // Control Access to the underlying bucket object
 	mutationListener   changeListener          // Caching feed listener
+	notifyPacer        *notifyPacer            // Coalesces the change cache's notifications to the mutationListener, when enabled
+	channelWebhooks    *channelWebhookNotifier // Posts the change cache's notifications to channel webhooks, when configured
 	ImportListener     *importListener         // Import feed listener
 	sequences          *sequenceAllocator      // Source of new sequence numbers
 	ChannelMapper      *channels.ChannelMapper // Runs JS 'sync' function
@@ -97,6 +111,7 @@ type DatabaseContext struct {
 	autoImport         bool                    // Add sync data to new untracked couchbase server docs?  (Xattr mode specific)
 	revisionCache      RevisionCache           // Cache of recently-accessed doc revisions
 	changeCache        *changeCache            // Cache of recently-access channels
+	stableSequence     StableSequenceProvider  // Stable sequence used when pruning a document's recent sequences
 	EventMgr           *EventManager           // Manages notification events
 	AllowEmptyPassword bool                    // Allow empty passwords?  Defaults to false
 	Options            DatabaseContextOptions  // Database Context Options
@@ -117,6 +132,16 @@ type DatabaseContext struct {
 	SGReplicateMgr               *sgReplicateManager // Manages interactions with sg-replicate replications
 	Heartbeater                  base.Heartbeater    // Node heartbeater for SG cluster awareness
 	ServeInsecureAttachmentTypes bool                // Attachment content type will bypass the content-disposition handling, default false
+	SequenceEpoch                string              // Current sequence epoch, set when sequence epochs are enabled
+	cacheHealthLock              sync.Mutex          // Guards cacheHealth and cacheHealthTime
+	cacheHealth                  CacheHealth         // Most recently computed change cache health
+	cacheHealthTime              time.Time           // Time cacheHealth was computed
+	importSuppressions           importSuppressions  // Runtime suppressions of feed import, by key prefix
+	resyncAdvisor                *resyncAdvisor      // Flags the database as needing a resync, when enabled
+	metadataUsageScans           metadataUsageScans  // Most recent metadata usage scan
+	compactionRuns               compactionRuns      // Most recent tombstone compaction
+	ChangesFeedLimiter           *ChangesFeedLimiter // Limits the number of active continuous, longpoll and websocket changes feeds
+	sequenceIDCache              *base.LRUCache      // Recently parsed sequence IDs, by the string parsed
 }
 
 type DatabaseContextOptions struct {
@@ -141,8 +166,21 @@ type DatabaseContextOptions struct {
 	SGReplicateOptions        SGReplicateOptions
 	SlowQueryWarningThreshold time.Duration
 	QueryPaginationLimit      int    // Limit used for pagination of queries. If not set defaults to DefaultQueryPaginationLimit
+	MaxChangesLimit           int    // Max results returned by a non-continuous changes request - 0 means no limit
 	UserXattrKey              string // Key of user xattr that will be accessible from the Sync Function. If empty the feature will be disabled.
 	ClientPartitionWindow     time.Duration
+	SequenceEpochEnabled      bool                    // Include the database's sequence epoch in changes feed last_seq values
+	ChannelVerificationRate   float64                 // Fraction of cached doc revisions whose channels are verified against the current sync function
+	FilterExpiredTombstones   bool                    // Omit tombstones older than ClientPartitionWindow from changes feeds of clients that have synced since the deletion
+	ResyncAdvisorOptions      *ResyncAdvisorOptions   // Enables the resync advisor, when non-nil
+	ChangesFeedLimits         ChangesFeedLimits       // Limits on the number of active continuous, longpoll and websocket changes feeds
+	ChannelWebhooks           []ChannelWebhookOptions // Webhooks notified of changes to channels
+	ChannelWebhookClient      *http.Client            // Client used to post to channel webhooks.  Defaults to a client using base.DefaultHTTPTransport
+
+	// Tombstone compaction.  A zero TombstoneRetention uses the server's metadata purge interval, and a zero
+	// CompactOpsPerSec uses DefaultCompactOpsPerSec
+	TombstoneRetention time.Duration // Age after which tombstones are purged by compaction
+	CompactOpsPerSec   int           // Max tombstones purged per second by compaction
 }
 
 type SGReplicateOptions struct {
@@ -171,14 +209,20 @@ type APIEndpoints struct {
 }
 
 type UnsupportedOptions struct {
-	UserViews                 UserViewsOptions        `json:"user_views,omitempty"`                  // Config settings for user views
-	OidcTestProvider          OidcTestProviderOptions `json:"oidc_test_provider,omitempty"`          // Config settings for OIDC Provider
-	APIEndpoints              APIEndpoints            `json:"api_endpoints,omitempty"`               // Config settings for API endpoints
-	WarningThresholds         WarningThresholds       `json:"warning_thresholds,omitempty"`          // Warning thresholds related to _sync size
-	DisableCleanSkippedQuery  bool                    `json:"disable_clean_skipped_query,omitempty"` // Clean skipped sequence processing bypasses final check
-	OidcTlsSkipVerify         bool                    `json:"oidc_tls_skip_verify"`                  // Config option to enable self-signed certs for OIDC testing.
-	SgrTlsSkipVerify          bool                    `json:"sgr_tls_skip_verify"`                   // Config option to enable self-signed certs for SG-Replicate testing.
-	RemoteConfigTlsSkipVerify bool                    `json:"remote_config_tls_skip_verify"`         // Config option to enable self signed certificates for external JavaScript load.
+	UserViews                       UserViewsOptions        `json:"user_views,omitempty"`                          // Config settings for user views
+	OidcTestProvider                OidcTestProviderOptions `json:"oidc_test_provider,omitempty"`                  // Config settings for OIDC Provider
+	APIEndpoints                    APIEndpoints            `json:"api_endpoints,omitempty"`                       // Config settings for API endpoints
+	WarningThresholds               WarningThresholds       `json:"warning_thresholds,omitempty"`                  // Warning thresholds related to _sync size
+	DisableCleanSkippedQuery        bool                    `json:"disable_clean_skipped_query,omitempty"`         // Clean skipped sequence processing bypasses final check
+	OidcTlsSkipVerify               bool                    `json:"oidc_tls_skip_verify"`                          // Config option to enable self-signed certs for OIDC testing.
+	SgrTlsSkipVerify                bool                    `json:"sgr_tls_skip_verify"`                           // Config option to enable self-signed certs for SG-Replicate testing.
+	RemoteConfigTlsSkipVerify       bool                    `json:"remote_config_tls_skip_verify"`                 // Config option to enable self signed certificates for external JavaScript load.
+	StrictFeedParsing               bool                    `json:"strict_feed_parsing,omitempty"`                 // Surface feed documents with unparseable sync metadata via warnings, stats and the _cache endpoint
+	CacheEntryChecksums             bool                    `json:"cache_entry_checksums,omitempty"`               // Verify channel cache entries against a checksum computed when cached, dropping corrupt entries
+	NormalizeLowSeqSince            bool                    `json:"normalize_low_seq_since,omitempty"`             // Don't re-send entries a client resuming from a LowSeq::Seq since value has received - requires clients to resume on the same node
+	NotifyPacing                    NotifyPacingOptions     `json:"notify_pacing,omitempty"`                       // Coalesce change notifications to changes feeds when they arrive faster than a threshold
+	ReleaseUnusedSequencesOnStartup bool                    `json:"release_unused_sequences_on_startup,omitempty"` // Release sequences from unused sequence notifications present at startup, written while the node was down
+	SafeStableSequence              bool                    `json:"safe_stable_sequence,omitempty"`                // Don't advance the stable sequence past the oldest outstanding skipped sequence
 }
 
 type WarningThresholds struct {
@@ -190,17 +234,26 @@ type WarningThresholds struct {
 
 // Options associated with the import of documents not written by Sync Gateway
 type ImportOptions struct {
-	ImportFilter     *ImportFilterFunction // Opt-in filter for document import
-	BackupOldRev     bool                  // Create temporary backup of old revision body when available
-	ImportPartitions uint16                // Number of partitions for import
+	ImportFilter          *ImportFilterFunction // Opt-in filter for document import
+	BackupOldRev          bool                  // Create temporary backup of old revision body when available
+	ImportPartitions      uint16                // Number of partitions for import
+	LargeDocSize          int                   // Body size in bytes above which imported documents are logged and counted as large.  Zero disables
+	MaxDocSize            int                   // Body size in bytes above which documents are rejected from import.  Zero disables
+	DeclaredFields        []string              // Top-level properties read by the import filter and sync function.  When set, only these are decoded for documents over LargeDocSize
+	CheckpointGroup       string                // Checkpoint group the import feed persists its checkpoints under
+	CheckpointGCMaxAge    time.Duration         // Age after which checkpoints for inactive checkpoint groups are collected on import feed shutdown, and every DCPCheckpointGCInterval.  Zero disables
+	CheckpointGCOnStartup bool                  // Also collect stale checkpoints when the import feed is started
+	MaxInFlightBytes      int64                 // Feed event bytes being imported at once above which further imports wait, or are deferred.  Zero disables
+	DeferOverBudget       bool                  // Defer imports over MaxInFlightBytes to the document's next mutation, rather than waiting
 }
 
 // Represents a simulated CouchDB database. A new instance is created for each HTTP request,
 // so this struct does not have to be thread-safe.
 type Database struct {
 	*DatabaseContext
-	user auth.User
-	Ctx  context.Context
+	user       auth.User
+	Ctx        context.Context
+	RequestCtx context.Context // Done once the request the database is used for is done.  Only for operations that can't outlive the request
 }
 
 func ValidateDatabaseName(dbName string) error {
@@ -255,6 +308,9 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 	if err := ValidateDatabaseName(dbName); err != nil {
 		return nil, err
 	}
+	if err := validateSequenceBufferingBypass(options); err != nil {
+		return nil, err
+	}
 
 	dbContext := &DatabaseContext{
 		Name:       dbName,
@@ -283,24 +339,48 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 	dbContext.EventMgr = NewEventManager()
 
 	var err error
-	dbContext.sequences, err = newSequenceAllocator(bucket, dbContext.DbStats.Database())
+	dbContext.sequenceIDCache, err = base.NewLRUCache(SequenceIDCacheCapacity)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get current value of _sync:seq
+	dbContext.sequences, err = newSequenceAllocator(bucket, dbContext.DbStats.Database())
+	if err != nil && !isSequenceCorruptionError(err) {
+		return nil, err
+	}
+	dbContext.sequences.onCorruption = dbContext.handleSequenceCorruption
+	unusedSeqCacheOptions := DefaultCacheOptions()
+	if options.CacheOptions != nil {
+		unusedSeqCacheOptions = *options.CacheOptions
+	}
+	dbContext.sequences.unusedSeqTTL = unusedSequenceTTL(unusedSeqCacheOptions)
+
+	// Get current value of _sync:seq.  When the sequence counter is corrupt, the database is initialized without its
+	// mutation feed, to be left offline until the counter is repaired.
 	initialSequence, seqErr := dbContext.sequences.lastSequence()
-	if seqErr != nil {
+	sequenceCorrupt := isSequenceCorruptionError(seqErr)
+	if seqErr != nil && !sequenceCorrupt {
 		return nil, seqErr
 	}
 	initialSequenceTime := time.Now()
 
+	if options.SequenceEpochEnabled && !sequenceCorrupt {
+		dbContext.SequenceEpoch, err = initSequenceEpoch(bucket, initialSequence)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// In-memory channel cache
 	dbContext.changeCache = &changeCache{}
+	dbContext.stableSequence = &intSequenceProvider{changeCache: dbContext.changeCache, safe: options.UnsupportedOptions.SafeStableSequence}
 
 	// Callback that is invoked whenever a set of channels is changed in the ChangeCache
-	notifyChange := func(changedChannels base.Set) {
-		dbContext.mutationListener.Notify(changedChannels)
+	dbContext.channelWebhooks = newChannelWebhookNotifier(dbName, options.ChannelWebhooks, options.ChannelWebhookClient, dbContext.DbStats)
+	notifyChange := dbContext.notifyChanged
+	dbContext.notifyPacer = newNotifyPacer(options.UnsupportedOptions.NotifyPacing, notifyChange, dbContext.DbStats.Database().NotifyPacingWindow)
+	if dbContext.notifyPacer != nil {
+		notifyChange = dbContext.notifyPacer.Notify
 	}
 
 	// Initialize the active channel counter
@@ -316,8 +396,33 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 		base.Debugf(base.KeyDCP, "Error initializing the change cache", err)
 	}
 
+	// Verify the channels of a sample of cached revisions against the current sync function, when enabled
+	if options.ChannelVerificationRate > 0 {
+		dbContext.changeCache.channelVerifier = newChannelVerifier(dbContext, options.ChannelVerificationRate, dbContext.terminator)
+	}
+
+	dbContext.changeCache.channelWebhooks = dbContext.channelWebhooks
+
+	// Sequence buffering is only bypassed while this node is found to be the only one allocating sequences
+	if options.CacheOptions != nil && options.CacheOptions.BypassSequenceBuffering {
+		dbContext.changeCache.isAllocated = dbContext.sequences.isAllocated
+		dbContext.sequences.onOtherWriter = func() {
+			dbContext.changeCache.resumeSequenceBuffering("the sequence counter was incremented by another node")
+		}
+	}
+
+	// Advise when signals indicate a resync is needed, when enabled
+	if options.ResyncAdvisorOptions != nil {
+		dbContext.resyncAdvisor = newResyncAdvisor(dbName, *options.ResyncAdvisorOptions, dbContext.DbStats.Database().NeedsResync)
+		dbContext.changeCache.resyncAdvisor = dbContext.resyncAdvisor
+	}
+
+	dbContext.ChangesFeedLimiter = NewChangesFeedLimiter(options.ChangesFeedLimits, dbContext.DbStats.Database().NumChangesFeedsActive,
+		dbContext.DbStats.Database().NumChangesFeedsRejected)
+
 	// Set the DB Context notifyChange callback to call back the changecache DocChanged callback
 	dbContext.SetOnChangeCallback(dbContext.changeCache.DocChanged)
+	dbContext.mutationListener.OnRollback = dbContext.changeCache.processVbucketRollback
 
 	// Initialize the tap Listener for notify handling
 	dbContext.mutationListener.Init(bucket.GetName())
@@ -368,14 +473,18 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 	}
 
 	// Start DCP feed
-	base.Infof(base.KeyDCP, "Starting mutation feed on bucket %v due to either channel cache mode or doc tracking (auto-import)", base.MD(bucket.GetName()))
-	cacheFeedStatsMap := dbContext.DbStats.Database().CacheFeedMapStats
-	err = dbContext.mutationListener.Start(bucket, cacheFeedStatsMap.Map)
+	if sequenceCorrupt {
+		base.Errorf("Not starting mutation feed for database %s: %v", base.MD(dbContext.Name), seqErr)
+	} else {
+		base.Infof(base.KeyDCP, "Starting mutation feed on bucket %v due to either channel cache mode or doc tracking (auto-import)", base.MD(bucket.GetName()))
+		cacheFeedStatsMap := dbContext.DbStats.Database().CacheFeedMapStats
+		err = dbContext.mutationListener.Start(bucket, cacheFeedStatsMap.Map)
 
-	// Check if there is an error starting the DCP feed
-	if err != nil {
-		dbContext.changeCache = nil
-		return nil, err
+		// Check if there is an error starting the DCP feed
+		if err != nil {
+			dbContext.changeCache = nil
+			return nil, err
+		}
 	}
 
 	// Unlock change cache.  Validate that any allocated sequences on other nodes have either been assigned or released
@@ -384,18 +493,42 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 		_ = dbContext.sequences.waitForReleasedSequences(initialSequenceTime)
 	}
 
+	// Seed channel caches from the snapshot written by the last node to shut down, when enabled
+	if dbContext.changeCache.options.Handoff.Enabled && !sequenceCorrupt {
+		dbContext.changeCache.handoff = dbContext.loadCacheHandoff(initialSequence)
+	}
+
 	err = dbContext.changeCache.Start(initialSequence)
 	if err != nil {
 		return nil, err
 	}
 
+	// Release sequences from unused sequence notifications written while this node wasn't running
+	if options.UnsupportedOptions.ReleaseUnusedSequencesOnStartup && !sequenceCorrupt {
+		if _, err := dbContext.ReleaseUnusedSequenceDocs(); err != nil {
+			base.Warnf("Unable to release sequences from unused sequence notifications for database %s - sequences will be skipped: %v", base.MD(dbContext.Name), err)
+		}
+	}
+
 	// If this is an xattr import node, start import feed.  Must be started after the caching DCP feed, as import cfg
 	// subscription relies on the caching feed.
-	if importEnabled {
+	if importEnabled && !sequenceCorrupt {
 		dbContext.ImportListener = NewImportListener()
 		if importFeedErr := dbContext.ImportListener.StartImportFeed(bucket, dbContext.DbStats, dbContext); importFeedErr != nil {
 			return nil, importFeedErr
 		}
+		if gcMaxAge := dbContext.Options.ImportOptions.CheckpointGCMaxAge; gcMaxAge > 0 {
+			bgt, err := NewBackgroundTask("CollectStaleDCPCheckpoints", dbContext.Name, func(ctx context.Context) error {
+				if _, err := dbContext.CollectStaleDCPCheckpoints(gcMaxAge, false); err != nil {
+					base.WarnfCtx(ctx, "Unable to collect stale DCP checkpoints for database %s: %v", base.MD(dbContext.Name), err)
+				}
+				return nil
+			}, DCPCheckpointGCInterval, dbContext.terminator)
+			if err != nil {
+				return nil, err
+			}
+			dbContext.backgroundTasks = append(dbContext.backgroundTasks, bgt)
+		}
 	}
 
 	// Load providers into provider map.  Does basic validation on the provider definition, and identifies the default provider.
@@ -456,6 +589,9 @@ func NewDatabaseContext(dbName string, bucket base.Bucket, autoImport bool, opti
 				dbContext.PurgeInterval = serverPurgeInterval
 			}
 		}
+		if dbContext.Options.TombstoneRetention > 0 {
+			dbContext.PurgeInterval = dbContext.Options.TombstoneRetention
+		}
 		base.Infof(base.KeyAll, "Using metadata purge interval of %.2f days for tombstone compaction.", dbContext.PurgeInterval.Hours()/24)
 
 		if dbContext.Options.CompactInterval != 0 {
@@ -524,8 +660,7 @@ func (context *DatabaseContext) GetOIDCProvider(providerName string) (*auth.OIDC
 	}
 }
 
-// Create a zero'd out since value (eg, initial since value) based on the sequence type
-// of the database (int or vector clock)
+// Create a zero'd out since value (eg, initial since value)
 func (context *DatabaseContext) CreateZeroSinceValue() SequenceID {
 	return SequenceID{}
 }
@@ -565,6 +700,27 @@ func (context *DatabaseContext) GetChangeCache() *changeCache {
 	return context.changeCache
 }
 
+// CacheFeedVbLag returns up to count vbuckets with the longest time since their last event was processed by the
+// caching feed.
+func (context *DatabaseContext) CacheFeedVbLag(count int) []VbLag {
+	return context.mutationListener.vbLag.laggards(count, time.Now())
+}
+
+// ImportFeedVbLag returns up to count vbuckets with the longest time since their last event was processed by the
+// import feed.  Returns nil when this node isn't running an import feed.
+func (context *DatabaseContext) ImportFeedVbLag(count int) []VbLag {
+	if context.ImportListener == nil {
+		return nil
+	}
+	return context.ImportListener.vbLag.laggards(count, time.Now())
+}
+
+// Close shuts down the database.  Once its background tasks are stopped, the remaining shutdown runs in phases, in
+// dependency order: the feeds are stopped and in-flight feed events drained before the change cache they populate is
+// snapshotted for cache handoff (when enabled) and stopped, the cache is stopped before the per-db stats it reports to
+// are removed, and the bucket is closed last.  Each phase is given up to DatabaseClosePhaseMaxWait before shutdown
+// moves on to the next.  Phases that time out are given another DatabaseClosePhaseMaxWait to finish before the bucket
+// is closed, and the bucket is left open if they still haven't, rather than closed under a feed or cache still using it.
 func (context *DatabaseContext) Close() {
 	context.BucketLock.Lock()
 	defer context.BucketLock.Unlock()
@@ -574,20 +730,90 @@ func (context *DatabaseContext) Close() {
 	// Wait for database background tasks to finish.
 	waitForBGTCompletion(BGTCompletionMaxWait, context.backgroundTasks, context.Name)
 	context.sequences.Stop()
-	context.mutationListener.Stop()
-	context.changeCache.Stop()
-	context.ImportListener.Stop()
+	if context.notifyPacer != nil {
+		context.notifyPacer.stop()
+	}
+	context.channelWebhooks.stop()
+
+	var unfinished []<-chan struct{}
+	runPhase := func(phase string, f func()) {
+		if done := runClosePhase(context.Name, phase, f); done != nil {
+			unfinished = append(unfinished, done)
+		}
+	}
+
+	runPhase("stop feeds", func() {
+		context.mutationListener.Stop()
+		context.ImportListener.Stop()
+		if context.ImportListener != nil && context.Options.ImportOptions.CheckpointGCMaxAge > 0 {
+			if _, err := context.CollectStaleDCPCheckpoints(context.Options.ImportOptions.CheckpointGCMaxAge, false); err != nil {
+				base.Warnf("Unable to collect stale DCP checkpoints for database %s: %v", base.MD(context.Name), err)
+			}
+		}
+		// DCP callbacks may still be running after the feed is stopped
+		if !context.changeCache.stopFeedEvents(FeedEventsStopMaxWait) {
+			base.Warnf("Closing database %s: timeout after %v waiting for feed events in progress", base.MD(context.Name), FeedEventsStopMaxWait)
+		}
+	})
+	if context.changeCache.options.Handoff.Enabled && context.SequenceCorruption() == nil {
+		runPhase("write cache handoff", func() {
+			if err := context.writeCacheHandoff(); err != nil {
+				base.Warnf("Unable to write cache handoff snapshot for database %s: %v", base.MD(context.Name), err)
+			}
+		})
+	}
+	runPhase("stop change cache", context.changeCache.Stop)
+
 	if context.Heartbeater != nil {
 		context.Heartbeater.Stop()
 	}
 	if context.SGReplicateMgr != nil {
 		context.SGReplicateMgr.Stop()
 	}
-	context.Bucket.Close()
+
+	runPhase("remove stats", func() {
+		base.RemovePerDbStats(context.Name)
+	})
+	if waitForClosePhases(unfinished, DatabaseClosePhaseMaxWait) {
+		runClosePhase(context.Name, "close bucket", context.Bucket.Close)
+	} else {
+		base.Warnf("Closing database %s: leaving bucket open, as %d timed out phases are still running", base.MD(context.Name), len(unfinished))
+	}
 	context.Bucket = nil
+}
 
-	base.RemovePerDbStats(context.Name)
+// runClosePhase runs a phase of Close, waiting up to DatabaseClosePhaseMaxWait for it to complete.  When the phase times
+// out, returns a channel that's closed once it completes, or nil otherwise.
+func runClosePhase(dbName string, phase string, f func()) (unfinished <-chan struct{}) {
+	base.Debugf(base.KeyAll, "Closing database %s: %s", base.MD(dbName), phase)
+	start := time.Now()
+	doneChan := make(chan struct{})
+	go func() {
+		defer close(doneChan)
+		f()
+	}()
+	select {
+	case <-doneChan:
+		base.Debugf(base.KeyAll, "Closing database %s: %s completed in %v", base.MD(dbName), phase, time.Since(start))
+		return nil
+	case <-time.After(DatabaseClosePhaseMaxWait):
+		base.Warnf("Closing database %s: timeout after %v waiting to %s, continuing shutdown", base.MD(dbName), DatabaseClosePhaseMaxWait, phase)
+		return doneChan
+	}
+}
 
+// waitForClosePhases waits up to maxWait for the timed out phases of Close to complete, returning whether they have.
+func waitForClosePhases(unfinished []<-chan struct{}, maxWait time.Duration) bool {
+	timeout := time.NewTimer(maxWait)
+	defer timeout.Stop()
+	for _, done := range unfinished {
+		select {
+		case <-done:
+		case <-timeout.C:
+			return false
+		}
+	}
+	return true
 }
 
 // waitForBGTCompletion waits for all the background tasks to finish.
@@ -607,6 +833,30 @@ func waitForBGTCompletion(waitTimeMax time.Duration, tasks []BackgroundTask, dbN
 	}
 }
 
+// validateSequenceBufferingBypass returns an error if the options bypass the change cache's sequence buffering, but
+// sequences may be allocated by nodes other than this one.  Buffering is only safe to bypass when this node is the
+// database's only writer, as changes from another writer can arrive long after later sequences.  Other writers that
+// can't be ruled out by config are detected at runtime, by the sequence allocator and change cache, and resume
+// buffering.
+func validateSequenceBufferingBypass(options DatabaseContextOptions) error {
+	if options.CacheOptions == nil || !options.CacheOptions.BypassSequenceBuffering {
+		return nil
+	}
+	if options.EnableXattr {
+		return errors.New("Sequence buffering can't be bypassed when shared bucket access is enabled, as documents may be imported by other nodes")
+	}
+	if options.SGReplicateOptions.Enabled {
+		return errors.New("Sequence buffering can't be bypassed when sg-replicate is enabled, as replications may write from other nodes")
+	}
+	return nil
+}
+
+// notifyChanged notifies changes feeds and channel webhooks of changed channels.
+func (context *DatabaseContext) notifyChanged(changedChannels base.Set) {
+	context.mutationListener.Notify(changedChannels)
+	context.channelWebhooks.Notify(changedChannels)
+}
+
 func (context *DatabaseContext) IsClosed() bool {
 	context.BucketLock.RLock()
 	defer context.BucketLock.RUnlock()
@@ -615,10 +865,17 @@ func (context *DatabaseContext) IsClosed() bool {
 
 // For testing only!
 func (context *DatabaseContext) RestartListener() error {
+	// Buffer the cache's change notifications while the listener is recreated
+	context.changeCache.SetNotifyChange(nil)
 	context.mutationListener.Stop()
 	// Delay needed to properly stop
 	time.Sleep(2 * time.Second)
 	context.mutationListener.Init(context.Bucket.GetName())
+	if context.notifyPacer != nil {
+		context.changeCache.SetNotifyChange(context.notifyPacer.Notify)
+	} else {
+		context.changeCache.SetNotifyChange(context.notifyChanged)
+	}
 	cacheFeedStatsMap := context.DbStats.Database().CacheFeedMapStats
 	if err := context.mutationListener.Start(context.Bucket, cacheFeedStatsMap.Map); err != nil {
 		return err
@@ -927,104 +1184,272 @@ outerLoop:
 
 //////// HOUSEKEEPING:
 
-// Deletes all session documents for a user
-func (db *DatabaseContext) DeleteUserSessions(userName string) error {
+// ForEachMetadataKey is a base.MetadataKeyIterator over the database's metadata documents, paging through
+// QueryMetadataKeys results.  When using views, installs the metadata design doc on first use.
+func (db *DatabaseContext) ForEachMetadataKey(prefix string, callback func(key string) error) error {
 
-	results, err := db.QuerySessions(userName)
-	if err != nil {
-		return err
+	if db.UseViews() {
+		if err := installMetadataViews(db.Bucket); err != nil {
+			return err
+		}
 	}
 
-	var sessionsRow QueryIdRow
-	for results.Next(&sessionsRow) {
-		base.Infof(base.KeyCRUD, "\tDeleting %q", sessionsRow.Id)
-		if err := db.Bucket.Delete(sessionsRow.Id); err != nil {
-			base.Warnf("Error deleting %q: %v", sessionsRow.Id, err)
+	startKey := ""
+	limit := db.Options.QueryPaginationLimit
+
+	for {
+		results, err := db.QueryMetadataKeys(prefix, startKey, limit)
+		if err != nil {
+			return err
+		}
+
+		resultCount := 0
+		var callbackErr error
+		var row QueryIdRow
+		for results.Next(&row) {
+			resultCount++
+			// Skip the first result when paging, as startKey is inclusive
+			if row.Id == startKey {
+				continue
+			}
+			startKey = row.Id
+			if callbackErr = callback(row.Id); callbackErr != nil {
+				break
+			}
+		}
+
+		closeErr := results.Close()
+		if callbackErr != nil {
+			return callbackErr
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+
+		if limit == 0 || resultCount < limit {
+			return nil
 		}
 	}
-	return results.Close()
 }
 
-// Trigger tombstone compaction from view and/or GSI indexes.  Several Sync Gateway indexes server tombstones (deleted documents with an xattr).
-// There currently isn't a mechanism for server to remove these docs from the index when the tombstone is purged by the server during
-// metadata purge, because metadata purge doesn't trigger a DCP event.
-// When compact is run, Sync Gateway initiates a normal delete operation for the document and xattr (a Sync Gateway purge).  This triggers
-// removal of the document from the index.  In the event that the document has already been purged by server, we need to recreate and delete
-// the document to accomplish the same result.
-func (db *Database) Compact() (int, error) {
-	if !atomic.CompareAndSwapUint32(&db.CompactState, DBCompactNotRunning, DBCompactRunning) {
-		return 0, base.HTTPErrorf(http.StatusServiceUnavailable, "Compaction already running")
+// CollectStaleDCPCheckpoints deletes DCP checkpoint documents for checkpoint groups that have been inactive for at
+// least maxAge.  In dry-run mode, lists the checkpoints that would be deleted.
+func (db *DatabaseContext) CollectStaleDCPCheckpoints(maxAge time.Duration, dryRun bool) (*base.DCPCheckpointGCResult, error) {
+	result, err := base.CollectDCPCheckpoints(db.Bucket, db.ForEachMetadataKey, maxAge, dryRun)
+	if err != nil {
+		return nil, err
 	}
+	if !dryRun && db.DbStats.SharedBucketImport() != nil {
+		db.DbStats.SharedBucketImport().ImportCheckpointsCollected.Add(int64(result.Deleted))
+	}
+	return result, nil
+}
+
+// DefaultOldRevExpiryScanSize is the number of old revision backups sampled by ScanOldRevExpiry when no sample size is
+// specified.
+const DefaultOldRevExpiryScanSize = 1000
 
-	defer atomic.CompareAndSwapUint32(&db.CompactState, DBCompactRunning, DBCompactNotRunning)
+// errOldRevExpiryScanComplete stops iteration over old revision backups once the sample is complete.
+var errOldRevExpiryScanComplete = errors.New("old revision expiry scan complete")
 
-	// Compact should be a no-op if not running w/ xattrs
-	if !db.UseXattrs() {
-		return 0, nil
+// OldRevExpiryScanResult reports the expiry of a sample of the database's old revision backups.
+type OldRevExpiryScanResult struct {
+	Scanned  int `json:"scanned"`   // Number of old revision backups sampled
+	NoExpiry int `json:"no_expiry"` // Number of sampled backups without an expiry
+}
+
+// ScanOldRevExpiry reads the expiry of up to sampleSize old revision backups.  Backups without an expiry were
+// typically written by earlier versions of Sync Gateway, and won't be removed from the bucket.  Requires a Couchbase
+// Server bucket, to read the expiry.
+func (db *DatabaseContext) ScanOldRevExpiry(sampleSize int) (*OldRevExpiryScanResult, error) {
+	gocbBucket, ok := base.AsGoCBBucket(db.Bucket)
+	if !ok {
+		return nil, base.HTTPErrorf(http.StatusNotImplemented, "Old revision expiry scan requires a Couchbase Server bucket")
+	}
+	if sampleSize <= 0 {
+		sampleSize = DefaultOldRevExpiryScanSize
 	}
 
-	// Trigger view compaction for all tombstoned documents older than the purge interval
-	startTime := time.Now()
-	purgeOlderThan := startTime.Add(-db.PurgeInterval)
+	result := &OldRevExpiryScanResult{}
+	err := db.ForEachMetadataKey(base.RevPrefix, func(key string) error {
+		if result.Scanned >= sampleSize {
+			return errOldRevExpiryScanComplete
+		}
+		expiry, err := gocbBucket.GetExpiry(key)
+		if base.IsKeyNotFoundError(db.Bucket, err) {
+			// Expired since the key was listed
+			return nil
+		} else if err != nil {
+			return err
+		}
+		result.Scanned++
+		if expiry == 0 {
+			result.NoExpiry++
+		}
+		return nil
+	})
+	if err != nil && err != errOldRevExpiryScanComplete {
+		return nil, err
+	}
 
-	purgedDocCount := 0
+	base.Infof(base.KeyAll, "Old revision expiry scan for database %s found %d of %d sampled old revision backups without an expiry", base.MD(db.Name), result.NoExpiry, result.Scanned)
+	return result, nil
+}
 
-	ctx := db.Ctx
+// SequenceRepairMaxQueries bounds the number of queries issued when searching for the highest assigned sequence while
+// repairing the sequence counter.  A search over the full sequence range needs at most 64.  Var to support testing.
+var SequenceRepairMaxQueries = 128
 
-	base.InfofCtx(ctx, base.KeyAll, "Starting compaction of purged tombstones for %s ...", base.MD(db.Name))
-	purgeBody := Body{"_purged": true}
-	for {
-		purgedDocs := make([]string, 0)
-		results, err := db.QueryTombstones(purgeOlderThan, QueryTombstoneBatch)
-		if err != nil {
-			return 0, err
-		}
-		var tombstonesRow QueryIdRow
-		var resultCount int
-		for results.Next(&tombstonesRow) {
-			resultCount++
-			base.DebugfCtx(ctx, base.KeyCRUD, "\tDeleting %q", tombstonesRow.Id)
-			// First, attempt to purge.
-			purgeErr := db.Purge(tombstonesRow.Id)
-			if purgeErr == nil {
-				purgedDocs = append(purgedDocs, tombstonesRow.Id)
-			} else if base.IsKeyNotFoundError(db.Bucket, purgeErr) {
-				// If key no longer exists, need to add and remove to trigger removal from view
-				_, addErr := db.Bucket.Add(tombstonesRow.Id, 0, purgeBody)
-				if addErr != nil {
-					base.WarnfCtx(ctx, "Error compacting key %s (add) - tombstone will not be compacted.  %v", base.UD(tombstonesRow.Id), addErr)
-					continue
-				}
+// SequenceRepairResult reports the sequence counter value written (or, for a dry run, that would be written) by
+// RepairSequence.
+type SequenceRepairResult struct {
+	DryRun           bool   `json:"dry_run"`
+	PreviousValue    string `json:"previous_value"`    // Raw value of the sequence counter before repair
+	ObservedSequence uint64 `json:"observed_sequence"` // Highest sequence found by the document and principal search
+	Queries          int    `json:"queries"`           // Number of queries issued by the document search
+	ScanComplete     bool   `json:"scan_complete"`     // False when the search stopped at SequenceRepairMaxQueries
+	Sequence         uint64 `json:"sequence"`          // Repaired sequence counter value
+}
 
-				// At this point, the doc is not in a usable state for mobile
-				// so mark it to be removed from cache, even if the subsequent delete fails
-				purgedDocs = append(purgedDocs, tombstonesRow.Id)
+// RepairSequence rewrites the sequence counter as the greater of the highest document or principal sequence and the
+// provided override.  Refuses to lower a numeric counter, and refuses to write the result of an incomplete search unless the
+// override is at least the highest sequence observed.  In dry-run mode, reports the value that would be written
+// without modifying the counter.
+func (db *DatabaseContext) RepairSequence(override uint64, dryRun bool) (*SequenceRepairResult, error) {
 
-				if delErr := db.Bucket.Delete(tombstonesRow.Id); delErr != nil {
-					base.ErrorfCtx(ctx, "Error compacting key %s (delete) - tombstone will not be compacted.  %v", base.UD(tombstonesRow.Id), delErr)
-				}
-			} else {
-				base.WarnfCtx(ctx, "Error compacting key %s (purge) - tombstone will not be compacted.  %v", base.UD(tombstonesRow.Id), purgeErr)
-			}
+	rawValue, cas, err := db.Bucket.GetRaw(base.SyncSeqKey)
+	if err != nil && !base.IsKeyNotFoundError(db.Bucket, err) {
+		return nil, err
+	}
+
+	result := &SequenceRepairResult{
+		DryRun:        dryRun,
+		PreviousValue: string(rawValue),
+	}
+	result.ObservedSequence, result.Queries, result.ScanComplete, err = db.searchMaxSequence(SequenceRepairMaxQueries)
+	if err != nil {
+		return nil, err
+	}
+	maxPrincipalSequence, err := db.maxPrincipalSequence()
+	if err != nil {
+		return nil, err
+	}
+	result.ObservedSequence = base.MaxUint64(result.ObservedSequence, maxPrincipalSequence)
+
+	result.Sequence = base.MaxUint64(result.ObservedSequence, override)
+	if result.Sequence == 0 && result.ScanComplete {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "No documents with sequences found - a sequence must be provided to repair the sequence counter")
+	}
+	if len(rawValue) > 0 {
+		if current, parseErr := parseSequenceCounter(rawValue); parseErr == nil && result.Sequence < current {
+			return nil, base.HTTPErrorf(http.StatusConflict, "Refusing to lower sequence counter from %d to %d", current, result.Sequence)
 		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
 
-		// Now purge them from all channel caches
-		count := len(purgedDocs)
-		purgedDocCount += count
-		if count > 0 {
-			db.changeCache.Remove(purgedDocs, startTime)
-			db.DbStats.Database().NumTombstonesCompacted.Add(int64(count))
+	// Higher sequences than those observed may exist - only an explicit override can be trusted
+	if !result.ScanComplete && (override == 0 || override < result.ObservedSequence) {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "Search for the highest document sequence was incomplete - a sequence of at least %d must be provided to repair the sequence counter", result.ObservedSequence)
+	}
+
+	_, err = db.Bucket.WriteCas(base.SyncSeqKey, 0, 0, cas, []byte(strconv.FormatUint(result.Sequence, 10)), sgbucket.Raw)
+	if base.IsCasMismatch(err) {
+		return nil, base.HTTPErrorf(http.StatusConflict, "Sequence counter was modified during repair - retry")
+	} else if err != nil {
+		return nil, err
+	}
+	db.sequences.clearCorruption()
+
+	base.Infof(base.KeyAll, "Repaired sequence counter for database %s: %q -> %d (highest document sequence: %d, search complete: %v)",
+		base.MD(db.Name), base.UD(result.PreviousValue), result.Sequence, result.ObservedSequence, result.ScanComplete)
+	return result, nil
+}
+
+// searchMaxSequence returns the highest sequence of documents in the star channel.  Rather than scanning documents,
+// binary searches the sequence range with single row queries for the first document at or above a sequence, issuing
+// at most maxQueries queries.  When the search doesn't complete, returns the highest sequence observed.
+func (db *DatabaseContext) searchMaxSequence(maxQueries int) (maxSequence uint64, queries int, complete bool, err error) {
+	// The highest sequence is in [maxSequence, upper) - upper is never assigned
+	upper := uint64(math.MaxUint64)
+	for upper-maxSequence > 1 {
+		if queries >= maxQueries {
+			return maxSequence, queries, false, nil
+		}
+		probe := maxSequence + (upper-maxSequence)/2
+		entries, err := db.getChangesInChannelFromQuery(context.Background(), channels.UserStarChannel, probe, 0, 1, false)
+		if err != nil {
+			return 0, queries, false, err
+		}
+		queries++
+		if len(entries) == 0 {
+			upper = probe
+		} else {
+			maxSequence = entries[0].Sequence
 		}
-		base.DebugfCtx(ctx, base.KeyAll, "Compacted %v tombstones", count)
+	}
+	return maxSequence, queries, true, nil
+}
 
-		if resultCount < QueryTombstoneBatch {
-			break
+// maxPrincipalSequence returns the highest sequence of the users (including the guest user) and roles.  Principal docs
+// aren't in the star channel, so aren't found by searchMaxSequence.
+func (db *DatabaseContext) maxPrincipalSequence() (maxSequence uint64, err error) {
+	users, roles, err := db.AllPrincipalIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	docIDs := make([]string, 0, len(users)+len(roles)+1)
+	docIDs = append(docIDs, base.UserPrefix) // Guest user
+	for _, user := range users {
+		docIDs = append(docIDs, base.UserPrefix+user)
+	}
+	for _, role := range roles {
+		docIDs = append(docIDs, base.RolePrefix+role)
+	}
+
+	for _, docID := range docIDs {
+		rawPrincipal, _, err := db.Bucket.GetRaw(docID)
+		if base.IsKeyNotFoundError(db.Bucket, err) {
+			continue
+		} else if err != nil {
+			return 0, err
 		}
+		if sequence, ok := extractPrincipalSequence(rawPrincipal); ok && sequence > maxSequence {
+			maxSequence = sequence
+		}
+	}
+	return maxSequence, nil
+}
+
+// Deletes all session documents for a user
+func (db *DatabaseContext) DeleteUserSessions(userName string) error {
+
+	results, err := db.QuerySessions(userName)
+	if err != nil {
+		return err
 	}
 
-	base.InfofCtx(ctx, base.KeyAll, "Finished compaction of purged tombstones for %s... Total Tombstones Compacted: %d", base.MD(db.Name), purgedDocCount)
+	var sessionsRow QueryIdRow
+	for results.Next(&sessionsRow) {
+		base.Infof(base.KeyCRUD, "\tDeleting %q", sessionsRow.Id)
+		if err := db.Bucket.Delete(sessionsRow.Id); err != nil {
+			base.Warnf("Error deleting %q: %v", sessionsRow.Id, err)
+		}
+	}
+	return results.Close()
+}
 
-	return purgedDocCount, nil
+// Trigger tombstone compaction from view and/or GSI indexes, purging tombstones older than the purge interval at the
+// configured rate, and returns the number purged.  See StartCompact.
+func (db *Database) Compact() (int, error) {
+	status, err := db.StartCompact(db.Options.CompactOpsPerSec, false)
+	if err != nil {
+		return 0, err
+	}
+	return status.Purged, nil
 }
 
 // Deletes all orphaned CouchDB attachments not used by any revisions.
@@ -1324,6 +1749,7 @@ func (db *Database) UpdateAllDocChannels(regenerateSequences bool) (int, error)
 	}
 
 	base.Infof(base.KeyAll, "Finished re-running sync function; %d/%d docs changed", docsChanged, docsProcessed)
+	db.resyncAdvisor.clear("resync completed")
 
 	if docsChanged > 0 {
 		// Now invalidate channel cache of all users/roles:
@@ -1433,6 +1859,8 @@ func initDatabaseStats(dbName string, autoImport bool, options DatabaseContextOp
 			fmt.Sprintf(base.StatViewFormat, DesignDocSyncHousekeeping(), ViewImport),
 			fmt.Sprintf(base.StatViewFormat, DesignDocSyncHousekeeping(), ViewSessions),
 			fmt.Sprintf(base.StatViewFormat, DesignDocSyncHousekeeping(), ViewTombstones),
+			fmt.Sprintf(base.StatViewFormat, DesignDocSyncMetadata(), ViewMetadataKeys),
+			fmt.Sprintf(base.StatViewFormat, DesignDocSyncMetadata(), ViewRevBodyKeys),
 		}
 	} else {
 		queryNames = []string{
@@ -1446,6 +1874,8 @@ func initDatabaseStats(dbName string, autoImport bool, options DatabaseContextOp
 			QueryTypeTombstones,
 			QueryTypeResync,
 			QueryTypeAllDocs,
+			QueryTypeMetadataKeys,
+			QueryTypeRevBodyKeys,
 		}
 	}
 
@@ -1473,3 +1903,28 @@ func (context *DatabaseContext) AllowFlushNonCouchbaseBuckets() bool {
 func (context *DatabaseContext) LastSequence() (uint64, error) {
 	return context.sequences.lastSequence()
 }
+
+// SequenceCorruption returns the sequence counter corruption detected for the database, or nil if none has been
+// detected.  A database with a corrupt sequence counter can't be brought online until the counter is repaired.
+func (context *DatabaseContext) SequenceCorruption() error {
+	if corruption := context.sequences.getCorruption(); corruption != nil {
+		return corruption
+	}
+	return nil
+}
+
+// handleSequenceCorruption takes the database offline when sequence counter corruption is detected while online.
+func (context *DatabaseContext) handleSequenceCorruption(corruptionErr *SequenceCorruptionError) {
+	// Taking the database offline blocks until in-flight requests complete, including the one that detected the
+	// corruption, so needs to be done asynchronously.
+	go func() {
+		if err := context.TakeDbOffline(corruptionErr.Error()); err != nil {
+			base.Warnf("Unable to take database %s offline after detecting sequence counter corruption: %v", base.MD(context.Name), err)
+		}
+	}()
+}
+
+func isSequenceCorruptionError(err error) bool {
+	_, ok := err.(*SequenceCorruptionError)
+	return ok
+}
@@ -10,6 +10,8 @@ licenses/APL2.txt.
 
 package db
 
+import "time"
+
 // Wrapper around *expvars.Map for database stats that provide:
 //
 //    - A lazy loading mechanism
@@ -29,4 +31,10 @@ func (db *DatabaseContext) UpdateCalculatedStats() {
 		db.DbStats.Cache().HighSeqCached.Set(int64(channelCache.GetHighCacheSequence()))
 	}
 
+	now := time.Now()
+	db.DbStats.Cache().MaxVbLag.Set(int64(db.mutationListener.vbLag.maxLag(now).Seconds()))
+	if db.ImportListener != nil && db.DbStats.SharedBucketImport() != nil {
+		db.DbStats.SharedBucketImport().ImportMaxVbLag.Set(int64(db.ImportListener.vbLag.maxLag(now).Seconds()))
+	}
+
 }
@@ -57,6 +57,12 @@ func setupTestDBWithOptions(t testing.TB, dbcOptions DatabaseContextOptions) *Da
 	return setupTestDBForBucketWithOptions(t, tBucket, dbcOptions)
 }
 
+// setupExclusiveTestDB sets up a test db on a bucket that isn't shared with other tests when the test bucket pool is in
+// namespace mode, for tests depending on design doc or index management.
+func setupExclusiveTestDB(t testing.TB) *Database {
+	return setupTestDBForBucket(t, base.GetExclusiveTestBucket(t))
+}
+
 func setupTestDBForBucketWithOptions(t testing.TB, tBucket base.Bucket, dbcOptions DatabaseContextOptions) *Database {
 	AddOptionsFromEnvironmentVariables(&dbcOptions)
 	context, err := NewDatabaseContext("db", tBucket, false, dbcOptions)
@@ -68,7 +74,8 @@ func setupTestDBForBucketWithOptions(t testing.TB, tBucket base.Bucket, dbcOptio
 
 func setupTestDBWithOptionsAndImport(t testing.TB, dbcOptions DatabaseContextOptions) *Database {
 	AddOptionsFromEnvironmentVariables(&dbcOptions)
-	context, err := NewDatabaseContext("db", base.GetTestBucket(t), true, dbcOptions)
+	// Import checkpoints aren't namespaced, so import tests need an exclusive bucket
+	context, err := NewDatabaseContext("db", base.GetExclusiveTestBucket(t), true, dbcOptions)
 	require.NoError(t, err, "Couldn't create context for database 'db'")
 	db, err := CreateDatabase(context)
 	require.NoError(t, err, "Couldn't create database 'db'")
@@ -116,11 +123,14 @@ func setupTestDBWithCustomSyncSeq(t testing.TB, customSeq uint64) *Database {
 }
 
 func setupTestLeakyDBWithCacheOptions(t *testing.T, options CacheOptions, leakyOptions base.LeakyBucketConfig) *Database {
+	return setupTestLeakyDBForBucketWithCacheOptions(t, base.GetTestBucket(t), options, leakyOptions)
+}
+
+func setupTestLeakyDBForBucketWithCacheOptions(t *testing.T, testBucket base.Bucket, options CacheOptions, leakyOptions base.LeakyBucketConfig) *Database {
 	dbcOptions := DatabaseContextOptions{
 		CacheOptions: &options,
 	}
 	AddOptionsFromEnvironmentVariables(&dbcOptions)
-	testBucket := base.GetTestBucket(t)
 	leakyBucket := base.NewLeakyBucket(testBucket, leakyOptions)
 	context, err := NewDatabaseContext("db", leakyBucket, false, dbcOptions)
 	assert.NoError(t, err, "Couldn't create context for database 'db'")
@@ -771,7 +781,7 @@ func TestAllDocsOnly(t *testing.T) {
 	db.ChannelMapper = channels.NewDefaultChannelMapper()
 
 	// Trigger creation of the channel cache for channel "all"
-	db.changeCache.getChannelCache().getSingleChannelCache("all")
+	db.changeCache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("all"))
 
 	ids := make([]AllDocsEntry, 100)
 	for i := 0; i < 100; i++ {
@@ -948,7 +958,7 @@ func TestConflicts(t *testing.T) {
 	db.ChannelMapper = channels.NewDefaultChannelMapper()
 
 	// Instantiate channel cache for channel 'all'
-	db.changeCache.getChannelCache().getSingleChannelCache("all")
+	db.changeCache.getChannelCache().getSingleChannelCache(NewDefaultChannelID("all"))
 
 	cacheWaiter := db.NewDCPCachingCountWaiter(t)
 
@@ -1450,7 +1460,7 @@ func TestDocIDs(t *testing.T) {
 
 func TestUpdateDesignDoc(t *testing.T) {
 
-	db := setupTestDB(t)
+	db := setupExclusiveTestDB(t)
 	defer db.Close()
 
 	mapFunction := `function (doc, meta) { emit(); }`
@@ -1670,7 +1680,7 @@ func TestChannelView(t *testing.T) {
 	// Query view (retry loop to wait for indexing)
 	for i := 0; i < 10; i++ {
 		var err error
-		entries, err = db.getChangesInChannelFromQuery("*", 0, 100, 0, false)
+		entries, err = db.getChangesInChannelFromQuery(context.Background(), "*", 0, 100, 0, false)
 
 		assert.NoError(t, err, "Couldn't create document")
 		if len(entries) >= 1 {
@@ -2423,3 +2433,193 @@ func waitAndAssertCondition(t *testing.T, fn func() bool, failureMsgAndArgs ...i
 		time.Sleep(time.Millisecond * 100)
 	}
 }
+
+// Validates detection of a corrupt sequence counter while online and on startup, and repair of the counter.
+func TestSequenceCorruptionRepair(t *testing.T) {
+
+	tBucket := base.GetTestBucket(t)
+	defer tBucket.Close()
+
+	db := setupTestDBForBucket(t, tBucket.NoCloseClone())
+	defer db.Close()
+	atomic.StoreUint32(&db.State, DBOnline)
+
+	var lastDocSeq uint64
+	for i := 0; i < 3; i++ {
+		_, doc, err := db.Put(fmt.Sprintf("doc%d", i), Body{"foo": "bar"})
+		require.NoError(t, err)
+		lastDocSeq = doc.Sequence
+	}
+
+	// Writes fail with a corruption error once any reserved sequences are used, and the database is taken offline
+	require.NoError(t, tBucket.SetRaw(base.SyncSeqKey, 0, []byte("not-a-sequence")))
+	var err error
+	for i := 0; i < 20 && err == nil; i++ {
+		_, _, err = db.Put(fmt.Sprintf("corrupt%d", i), Body{"foo": "bar"})
+	}
+	require.True(t, isSequenceCorruptionError(err), "Expected sequence corruption error, got %v", err)
+	assert.Error(t, db.SequenceCorruption())
+
+	err, _ = base.RetryLoop("wait for offline", func() (bool, error, interface{}) {
+		return atomic.LoadUint32(&db.State) != DBOffline, nil, nil
+	}, base.CreateSleeperFunc(100, 50))
+	require.NoError(t, err)
+
+	// Dry run reports the highest document sequence without modifying the counter
+	result, err := db.RepairSequence(0, true)
+	require.NoError(t, err)
+	assert.Equal(t, "not-a-sequence", result.PreviousValue)
+	assert.Equal(t, lastDocSeq, result.ObservedSequence)
+	assert.Equal(t, lastDocSeq, result.Sequence)
+	assert.True(t, result.ScanComplete)
+	assert.LessOrEqual(t, result.Queries, 64)
+	rawValue, _, err := tBucket.GetRaw(base.SyncSeqKey)
+	require.NoError(t, err)
+	assert.Equal(t, "not-a-sequence", string(rawValue))
+
+	// The result of an incomplete search isn't written without an override of at least the observed sequence
+	maxQueries := SequenceRepairMaxQueries
+	SequenceRepairMaxQueries = 1
+	result, err = db.RepairSequence(0, true)
+	require.NoError(t, err)
+	assert.False(t, result.ScanComplete)
+	_, err = db.RepairSequence(0, false)
+	assertHTTPError(t, err, 400)
+	SequenceRepairMaxQueries = maxQueries
+	rawValue, _, err = tBucket.GetRaw(base.SyncSeqKey)
+	require.NoError(t, err)
+	assert.Equal(t, "not-a-sequence", string(rawValue))
+
+	// Override is used when higher than the observed sequence
+	result, err = db.RepairSequence(100, false)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), result.Sequence)
+	rawValue, _, err = tBucket.GetRaw(base.SyncSeqKey)
+	require.NoError(t, err)
+	assert.Equal(t, "100", string(rawValue))
+	assert.NoError(t, db.SequenceCorruption())
+
+	// Repair refuses to lower the counter
+	_, err = db.RepairSequence(50, false)
+	assertHTTPError(t, err, 409)
+
+	// Corruption on startup leaves the database without a mutation feed, reporting the corruption
+	require.NoError(t, tBucket.SetRaw(base.SyncSeqKey, 0, []byte(`{"seq": 5}`)))
+	db2, err := NewDatabaseContext("db2", tBucket.NoCloseClone(), false, DatabaseContextOptions{})
+	require.NoError(t, err)
+	defer db2.Close()
+	assert.Error(t, db2.SequenceCorruption())
+	assert.Nil(t, db2.mutationListener.tapFeed)
+}
+
+// Validates that the sequence repair search includes principals, when a principal holds the highest sequence.
+func TestRepairSequencePrincipal(t *testing.T) {
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, doc, err := db.Put("doc1", Body{"foo": "bar"})
+	require.NoError(t, err)
+
+	authenticator := auth.NewAuthenticator(db.Bucket, db, auth.DefaultAuthenticatorOptions())
+	role, err := authenticator.NewRole("role1", base.SetOf("ABC"))
+	require.NoError(t, err)
+	role.SetSequence(doc.Sequence + 5)
+	require.NoError(t, authenticator.Save(role))
+	user, err := authenticator.NewUser("alice", "pass", base.SetOf("ABC"))
+	require.NoError(t, err)
+	user.SetSequence(doc.Sequence + 10)
+	require.NoError(t, authenticator.Save(user))
+
+	result, err := db.RepairSequence(0, true)
+	require.NoError(t, err)
+	assert.True(t, result.ScanComplete)
+	assert.Equal(t, doc.Sequence+10, result.ObservedSequence)
+	assert.Equal(t, doc.Sequence+10, result.Sequence)
+}
+
+// Closes the database while documents are written, changes are read and feed events arrive, and validates that nothing
+// panics and that feed events aren't processed by the change cache once it's closed.  Run with -race.
+func TestCloseDuringActivity(t *testing.T) {
+
+	db := setupTestDB(t)
+
+	// whileOpen runs f if the database hasn't been closed, holding off Close until it returns
+	whileOpen := func(f func()) bool {
+		db.BucketLock.RLock()
+		defer db.BucketLock.RUnlock()
+		if db.Bucket == nil {
+			return false
+		}
+		f()
+		return true
+	}
+
+	docEvent := func(seq uint64) sgbucket.FeedEvent {
+		return sgbucket.FeedEvent{
+			Opcode:       sgbucket.FeedOpMutation,
+			Synchronous:  true,
+			Key:          []byte(fmt.Sprintf("feed-doc-%d", seq)),
+			Value:        []byte(fmt.Sprintf(`{"_sync":{"rev":"1-a","sequence":%d,"recent_sequences":[%d]}}`, seq, seq)),
+			DataType:     base.MemcachedDataTypeJSON,
+			TimeReceived: time.Now(),
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(writer int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				if !whileOpen(func() {
+					_, _, err := db.Put(fmt.Sprintf("doc-%d-%d", writer, j), Body{"channels": []string{"ABC"}})
+					assert.NoError(t, err)
+				}) {
+					return
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for whileOpen(func() {
+				_, err := db.GetChanges(base.SetOf("ABC"), ChangesOptions{Since: SequenceID{Seq: 0}})
+				assert.NoError(t, err)
+			}) {
+			}
+		}()
+	}
+
+	// Feed events aren't gated on the database being open, as DCP callbacks may still be running after the feed is stopped
+	closed := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Sequences well ahead of the writers' are buffered as pending - fewer than the pending limit are sent, so none are skipped
+		for seq := uint64(1000000); seq < 1005000; seq++ {
+			select {
+			case <-closed:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			db.changeCache.DocChanged(docEvent(seq))
+		}
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	db.Close()
+	close(closed)
+	wg.Wait()
+
+	// A feed event for the next sequence would otherwise advance it
+	nextSequence := db.changeCache.getNextSequence()
+	db.changeCache.DocChanged(docEvent(nextSequence))
+	assert.Equal(t, nextSequence, db.changeCache.getNextSequence())
+	db.changeCache.lock.RLock()
+	pendingLen := len(db.changeCache.pendingLogs)
+	db.changeCache.lock.RUnlock()
+	db.changeCache.DocChanged(docEvent(nextSequence + 10))
+	db.changeCache.lock.RLock()
+	assert.Equal(t, pendingLen, len(db.changeCache.pendingLogs))
+	db.changeCache.lock.RUnlock()
+}
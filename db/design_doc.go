@@ -41,6 +41,7 @@ var DesignDocPreviousVersions = []string{"", "2.0"}
 const (
 	DesignDocSyncGatewayPrefix      = "sync_gateway"
 	DesignDocSyncHousekeepingPrefix = "sync_housekeeping"
+	DesignDocSyncMetadataPrefix     = "sync_metadata"
 	ViewPrincipals                  = "principals"
 	ViewChannels                    = "channels"
 	ViewAccess                      = "access"
@@ -51,6 +52,8 @@ const (
 	ViewImport                      = "import"
 	ViewSessions                    = "sessions"
 	ViewTombstones                  = "tombstones"
+	ViewMetadataKeys                = "metadata_keys"
+	ViewRevBodyKeys                 = "rev_body_keys"
 )
 
 func isInternalDDoc(ddocName string) bool {
@@ -65,6 +68,12 @@ func DesignDocSyncHousekeeping() string {
 	return fmt.Sprintf(DesignDocFormat, DesignDocSyncHousekeepingPrefix, DesignDocVersion)
 }
 
+// DesignDocSyncMetadata is only installed on demand, by metadata scans on databases using views.  It isn't part of
+// installViews, to avoid indexing all metadata documents for databases that never scan them.
+func DesignDocSyncMetadata() string {
+	return fmt.Sprintf(DesignDocFormat, DesignDocSyncMetadataPrefix, DesignDocVersion)
+}
+
 // Enforces access by admins only, and not to the built-in Sync Gateway design docs:
 func (db *Database) checkDDocAccess(ddocName string) error {
 	if db.user != nil || isInternalDDoc(ddocName) {
@@ -349,17 +358,21 @@ func checkExistingDDocs(bucket base.Bucket) bool {
 	return false
 }
 
-func installViews(bucket base.Bucket) error {
-
-	// syncData specifies the path to Sync Gateway sync metadata used in the map function -
-	// in the document body when xattrs available, in the mobile xattr when xattrs enabled.
-	syncData := fmt.Sprintf(`var sync
+// viewSyncData returns the map function snippet that sets sync to the Sync Gateway sync metadata -
+// in the document body when xattrs available, in the mobile xattr when xattrs enabled.
+func viewSyncData() string {
+	return fmt.Sprintf(`var sync
 							if (meta.xattrs === undefined || meta.xattrs.%[1]s === undefined) {
 		                        sync = doc.%[2]s
 		                  	} else {
 		                       	sync = meta.xattrs.%[1]s
 		                    }
 		                     `, base.SyncXattrName, base.SyncPropertyName)
+}
+
+func installViews(bucket base.Bucket) error {
+
+	syncData := viewSyncData()
 
 	// View for _all_docs
 	// Key is docid; value is [revid, sequence]
@@ -415,7 +428,8 @@ func installViews(bucket base.Bucket) error {
 
 	// By-channels view.
 	// Key is [channelname, sequence]; value is [docid, revid, flag?]
-	// where flag is true for doc deletion, false for removed from channel, missing otherwise
+	// where flag is true for doc deletion, false for removed from channel, missing otherwise.  Every doc is emitted for
+	// the "*" channel, as it's used for resync and skipped sequence queries, and by databases that don't cache "*".
 	channels_map := `function (doc, meta) {
 	                    %s
 	                    if (sync === undefined || meta.id.substring(0,6) == "%s")
@@ -429,8 +443,7 @@ func installViews(bucket base.Bucket) error {
 	                    } else if (sync.deleted) {
 	                    	value.flags = %d // channels.Deleted
 	                    }
-	                    if (%v) // EnableStarChannelLog
-							emit(["*", sequence], value);
+						emit(["*", sequence], value);
 						var channels = sync.channels;
 						if (channels) {
 							for (var name in channels) {
@@ -445,8 +458,7 @@ func installViews(bucket base.Bucket) error {
 						}
 					}`
 
-	channels_map = fmt.Sprintf(channels_map, syncData, base.SyncPrefix, ch.Deleted, EnableStarChannelLog,
-		ch.Removed|ch.Deleted, ch.Removed)
+	channels_map = fmt.Sprintf(channels_map, syncData, base.SyncPrefix, ch.Deleted, ch.Removed|ch.Deleted, ch.Removed)
 
 	// Channel access view, used by ComputeChannelsForPrincipal()
 	// Key is username; value is dictionary channelName->firstSequence (compatible with TimedSet)
@@ -585,6 +597,54 @@ func installViews(bucket base.Bucket) error {
 	return nil
 }
 
+// installMetadataViews installs the metadata design doc, if it isn't already present.
+func installMetadataViews(bucket base.Bucket) error {
+
+	_, getDDocErr := bucket.GetDDoc(DesignDocSyncMetadata())
+	if getDDocErr == nil {
+		return nil
+	} else if !IsMissingDDocError(getDDocErr) {
+		return getDDocErr
+	}
+
+	// Metadata keys view - used for metadata scans
+	// Key is docid
+	metadataKeys_map := `function (doc, meta) {
+                     	if (meta.id.substring(0,%d) == %q)
+                     		emit(meta.id, null);}`
+	metadataKeys_map = fmt.Sprintf(metadataKeys_map, len(base.SyncPrefix), base.SyncPrefix)
+
+	// Revision body keys view - used for metadata purge of external revision bodies
+	// Key is sequence; value is the keys of the revision bodies stored in external documents
+	revBodyKeys_map := `function (doc, meta) {
+                     	%s
+                     	if (sync === undefined || meta.id.substring(0,6) == "%s")
+                     		return;
+                     	if (sync.history === undefined || sync.history.bodyKeyMap === undefined)
+                     		return;
+                     	var keys = [];
+                     	for (i in sync.history.bodyKeyMap)
+                     		keys.push(sync.history.bodyKeyMap[i]);
+                     	emit(sync.sequence, keys);}`
+	revBodyKeys_map = fmt.Sprintf(revBodyKeys_map, viewSyncData(), base.SyncPrefix)
+
+	designDoc := &sgbucket.DesignDoc{
+		Views: sgbucket.ViewMap{
+			ViewMetadataKeys: sgbucket.ViewDef{Map: metadataKeys_map},
+			ViewRevBodyKeys:  sgbucket.ViewDef{Map: revBodyKeys_map},
+		},
+		Options: &sgbucket.DesignDocOptions{
+			IndexXattrOnTombstones: true, // For ViewRevBodyKeys
+		},
+	}
+	if err := bucket.PutDDoc(DesignDocSyncMetadata(), designDoc); err != nil {
+		return pkgerrors.WithStack(base.RedactErrorf("Error installing Couchbase Design doc: %v.  Error: %v", base.UD(DesignDocSyncMetadata()), err))
+	}
+
+	base.Infof(base.KeyAll, "Metadata design doc successfully created for view version %s.", DesignDocVersion)
+	return nil
+}
+
 // Issue a stale=false queries against critical views to guarantee indexing is complete and views are ready
 func WaitForViews(bucket base.Bucket) error {
 	var viewsWg sync.WaitGroup
@@ -651,7 +711,7 @@ func waitForViewIndexing(bucket base.Bucket, ddocName string, viewName string) e
 func removeObsoleteDesignDocs(bucket base.Bucket, previewOnly bool, useViews bool) (removedDesignDocs []string, err error) {
 
 	removedDesignDocs = make([]string, 0)
-	designDocPrefixes := []string{DesignDocSyncGatewayPrefix, DesignDocSyncHousekeepingPrefix}
+	designDocPrefixes := []string{DesignDocSyncGatewayPrefix, DesignDocSyncHousekeepingPrefix, DesignDocSyncMetadataPrefix}
 
 	versionsToRemove := DesignDocPreviousVersions
 
@@ -21,7 +21,7 @@ import (
 
 func TestRemoveObsoleteDesignDocs(t *testing.T) {
 
-	base.ForAllDataStores(t, func(t *testing.T, bucket sgbucket.DataStore) {
+	base.ForAllExclusiveDataStores(t, func(t *testing.T, bucket sgbucket.DataStore) {
 		mapFunction := `function (doc, meta) { emit(); }`
 
 		// Add some design docs in the old format
@@ -81,7 +81,7 @@ func TestRemoveObsoleteDesignDocs(t *testing.T) {
 func TestRemoveDesignDocsUseViewsTrueAndFalse(t *testing.T) {
 	DesignDocPreviousVersions = []string{"2.0"}
 
-	base.ForAllDataStores(t, func(t *testing.T, bucket sgbucket.DataStore) {
+	base.ForAllExclusiveDataStores(t, func(t *testing.T, bucket sgbucket.DataStore) {
 
 		mapFunction := `function (doc, meta){ emit(); }`
 
@@ -138,7 +138,7 @@ func TestRemoveObsoleteDesignDocsErrors(t *testing.T) {
 		DDocDeleteErrorCount: 1,
 	}
 
-	bucket := base.NewLeakyBucket(base.GetTestBucket(t), leakyBucketConfig)
+	bucket := base.NewLeakyBucket(base.GetExclusiveTestBucket(t), leakyBucketConfig)
 	defer bucket.Close()
 
 	mapFunction := `function (doc, meta){ emit(); }`
@@ -162,6 +162,24 @@ func TestRemoveObsoleteDesignDocsErrors(t *testing.T) {
 
 }
 
+// Test that the metadata design doc is installed on demand, and removed along with the other design docs when views
+// aren't used
+func TestInstallMetadataViews(t *testing.T) {
+
+	base.ForAllExclusiveDataStores(t, func(t *testing.T, bucket sgbucket.DataStore) {
+		assert.False(t, designDocExists(bucket, DesignDocSyncMetadata()))
+
+		assert.NoError(t, installMetadataViews(bucket))
+		assert.True(t, designDocExists(bucket, DesignDocSyncMetadata()))
+		assert.NoError(t, installMetadataViews(bucket))
+
+		removedDDocs, err := removeObsoleteDesignDocs(bucket, false, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{DesignDocSyncMetadata()}, removedDDocs)
+		assert.False(t, designDocExists(bucket, DesignDocSyncMetadata()))
+	})
+}
+
 func designDocExists(bucket base.Bucket, ddocName string) bool {
 	_, err := bucket.GetDDoc(ddocName)
 	return err == nil
@@ -84,6 +84,7 @@ type SyncData struct {
 
 	addedRevisionBodies     []string          // revIDs of non-winning revision bodies that have been added (and so require persistence)
 	removedRevisionBodyKeys map[string]string // keys of non-winning revisions that have been removed (and so may require deletion), indexed by revID
+	prunedRevIDs            []string          // revIDs pruned from the rev tree by the current update (and so may be referenced by cached changes)
 }
 
 func (sd *SyncData) HashRedact(salt string) SyncData {
@@ -170,6 +171,7 @@ type Document struct {
 	RevID          string
 	DocAttachments AttachmentsMeta
 	inlineSyncData bool
+	declaredBody   Body // Set by import of a large document, when only ImportOptions.DeclaredFields were decoded - used as the sync function's body
 }
 
 type revOnlySyncData struct {
@@ -711,14 +713,15 @@ func (doc *Document) promoteNonWinningRevisionBody(revid string, loader RevLoade
 }
 
 func (doc *Document) pruneRevisions(maxDepth uint32, keepRev string) int {
-	numPruned, prunedTombstoneBodyKeys := doc.History.pruneRevisions(maxDepth, keepRev)
+	prunedRevIDs, prunedTombstoneBodyKeys := doc.History.pruneRevisionIDs(maxDepth, keepRev)
+	doc.prunedRevIDs = append(doc.prunedRevIDs, prunedRevIDs...)
 	for revID, bodyKey := range prunedTombstoneBodyKeys {
 		if doc.removedRevisionBodyKeys == nil {
 			doc.removedRevisionBodyKeys = make(map[string]string)
 		}
 		doc.removedRevisionBodyKeys[revID] = bodyKey
 	}
-	return numPruned
+	return len(prunedRevIDs)
 }
 
 // Adds a revision body (as Body) to a document.  Removes special properties first.
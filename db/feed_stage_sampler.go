@@ -0,0 +1,53 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// DefaultFeedStageSampleRate is the default rate at which feed events are sampled for per-stage timing - one in every
+// DefaultFeedStageSampleRate events.
+const DefaultFeedStageSampleRate = 1000
+
+// feedStageSampler selects one in every rate feed events to have the time spent in each stage of its processing
+// recorded.  Unsampled events pay for a single atomic increment, and don't read the clock.  A zero rate disables
+// sampling.
+type feedStageSampler struct {
+	rate  uint64 // Sample one in every rate events, or none when zero
+	count uint64 // Events seen.  Accessed atomically
+}
+
+func newFeedStageSampler(rate int) *feedStageSampler {
+	if rate < 0 {
+		rate = 0
+	}
+	return &feedStageSampler{rate: uint64(rate)}
+}
+
+// sample returns true if the next event should be timed.  A nil sampler samples no events.
+func (s *feedStageSampler) sample() bool {
+	if s == nil || s.rate == 0 {
+		return false
+	}
+	return atomic.AddUint64(&s.count, 1)%s.rate == 0
+}
+
+// observeStage records the time since start in stat, and returns the time it was recorded at, to start the next stage
+// from.
+func observeStage(stat *base.SgwHistogramStat, start time.Time) time.Time {
+	now := time.Now()
+	stat.Observe(now.Sub(start).Nanoseconds())
+	return now
+}
@@ -11,9 +11,12 @@ licenses/APL2.txt.
 package db
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	sgbucket "github.com/couchbase/sg-bucket"
@@ -32,11 +35,25 @@ const (
 // Imports a document that was written by someone other than sync gateway, given the existing state of the doc in raw bytes
 func (db *Database) ImportDocRaw(docid string, value []byte, xattrValue []byte, userXattrValue []byte, isDelete bool, cas uint64, expiry *uint32, mode ImportMode) (docOut *Document, err error) {
 
+	ctx, span := base.StartSpan(db.Ctx, "import", base.SpanAttribute{Key: "on_demand", Value: mode == ImportOnDemand},
+		base.SpanAttribute{Key: "delete", Value: isDelete}, base.SpanAttribute{Key: "size", Value: len(value)})
+	defer span.End()
+
 	var body Body
+	var declaredOnly bool
 	if isDelete {
 		body = Body{}
 	} else {
-		err := body.Unmarshal(value)
+		large, err := db.checkImportDocSize(docid, value)
+		if err != nil {
+			return nil, err
+		}
+		declaredOnly = large && len(db.Options.ImportOptions.DeclaredFields) > 0
+		if declaredOnly {
+			body, err = decodeDeclaredFields(value, db.Options.ImportOptions.DeclaredFields)
+		} else {
+			err = body.Unmarshal(value)
+		}
 		if err != nil {
 			base.Infof(base.KeyImport, "Unmarshal error during importDoc %v", err)
 			return nil, err
@@ -67,7 +84,98 @@ func (db *Database) ImportDocRaw(docid string, value []byte, xattrValue []byte,
 		Cas:       cas,
 		Expiry:    *expiry,
 	}
-	return db.importDoc(docid, body, isDelete, existingBucketDoc, mode)
+
+	// The import's bucket operations are started from its span
+	tracedDb := *db
+	tracedDb.Ctx = ctx
+	return tracedDb.importDoc(docid, body, declaredOnly, isDelete, existingBucketDoc, mode)
+}
+
+// checkImportDocSize rejects documents larger than ImportOptions.MaxDocSize from import, and flags documents larger
+// than ImportOptions.LargeDocSize, as unmarshalling these bodies results in large allocations.  Returns whether the
+// document is large.
+func (db *Database) checkImportDocSize(docid string, value []byte) (large bool, err error) {
+	size := len(value)
+	if db.Options.ImportOptions.MaxDocSize > 0 && size > db.Options.ImportOptions.MaxDocSize {
+		base.Warnf("Document %q will not be imported - body size %d bytes exceeds import_max_doc_size %d", base.UD(docid), size, db.Options.ImportOptions.MaxDocSize)
+		db.DbStats.SharedBucketImport().ImportErrorCount.Add(1)
+		return false, base.ErrImportDocTooLarge
+	}
+
+	if db.Options.ImportOptions.LargeDocSize > 0 && size > db.Options.ImportOptions.LargeDocSize {
+		base.Warnf("Importing large document %q - body size %d bytes exceeds import_large_doc_size %d", base.UD(docid), size, db.Options.ImportOptions.LargeDocSize)
+		db.DbStats.SharedBucketImport().ImportLargeDocCount.Add(1)
+		return true, nil
+	}
+	return false, nil
+}
+
+// decodeDeclaredFields decodes the top-level properties of a JSON object body named in declaredFields, along with any
+// special (underscore-prefixed) properties.  The remaining properties are walked with a streaming decoder rather than
+// unmarshalled, so a large body doesn't result in a large allocation.  Returns nil for a null body.
+func decodeDeclaredFields(value []byte, declaredFields []string) (Body, error) {
+	declared := make(map[string]struct{}, len(declaredFields))
+	for _, field := range declaredFields {
+		declared[field] = struct{}{}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, errors.New("Document body is not a JSON object")
+	}
+
+	body := Body{}
+	for decoder.More() {
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := token.(string)
+		if _, ok := declared[key]; ok || strings.HasPrefix(key, "_") {
+			var property interface{}
+			if err := decoder.Decode(&property); err != nil {
+				return nil, err
+			}
+			body[key] = property
+		} else if err := skipJSONValue(decoder); err != nil {
+			return nil, err
+		}
+	}
+	// Consume the closing brace, to detect a truncated body
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// skipJSONValue reads past the next value from decoder, token by token.
+func skipJSONValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
 }
 
 // Import a document, given the existing state of the doc in *document format.
@@ -112,16 +220,17 @@ func (db *Database) ImportDoc(docid string, existingDoc *Document, isDelete bool
 		return nil, err
 	}
 
-	return db.importDoc(docid, existingDoc.Body(), isDelete, existingBucketDoc, mode)
+	return db.importDoc(docid, existingDoc.Body(), false, isDelete, existingBucketDoc, mode)
 }
 
 // Import document
 //   docid  - document key
 //   body - marshalled body of document to be imported
+//   declaredOnly - whether body only holds the declared fields of existingDoc.Body - see ImportOptions.DeclaredFields
 //   isDelete - whether the document to be imported is a delete
 //   existingDoc - bytes/cas/expiry of the  document to be imported (including xattr when available)
 //   mode - ImportMode - ImportFromFeed or ImportOnDemand
-func (db *Database) importDoc(docid string, body Body, isDelete bool, existingDoc *sgbucket.BucketDocument, mode ImportMode) (docOut *Document, err error) {
+func (db *Database) importDoc(docid string, body Body, declaredOnly bool, isDelete bool, existingDoc *sgbucket.BucketDocument, mode ImportMode) (docOut *Document, err error) {
 
 	base.Debugf(base.KeyImport, "Attempting to import doc %q...", base.UD(docid))
 	importStartTime := time.Now()
@@ -156,6 +265,7 @@ func (db *Database) importDoc(docid string, body Body, isDelete bool, existingDo
 			// If this is an on-demand import, we want to continue to import the current version of the doc.  Re-initialize existing doc based on the latest doc
 			if mode == ImportOnDemand {
 				body = doc.Body()
+				declaredOnly = false
 				if body == nil {
 					return nil, nil, false, nil, base.ErrEmptyDocument
 				}
@@ -202,6 +312,7 @@ func (db *Database) importDoc(docid string, body Body, isDelete bool, existingDo
 			// If document still requires import post-migration attempt, continue with import processing based on the body returned by migrate
 			doc = migratedDoc
 			body = migratedDoc.Body()
+			declaredOnly = false
 			base.Infof(base.KeyMigrate, "Falling back to import with cas: %v", doc.Cas)
 		}
 
@@ -309,9 +420,15 @@ func (db *Database) importDoc(docid string, body Body, isDelete bool, existingDo
 		// During import, oldDoc (doc.Body) is nil (since it's not guaranteed to be available)
 		doc.RemoveBody()
 
-		newDoc.UpdateBody(body)
-		if !wasStripped && !isDelete {
-			newDoc._rawBody = rawBodyForRevID
+		if declaredOnly {
+			// The full body is written from the raw body, and only the declared fields are passed to the sync function
+			newDoc.UpdateBodyBytes(rawBodyForRevID)
+			newDoc.declaredBody = body
+		} else {
+			newDoc.UpdateBody(body)
+			if !wasStripped && !isDelete {
+				newDoc._rawBody = rawBodyForRevID
+			}
 		}
 
 		// Note - no attachments processing is done during ImportDoc.  We don't (currently) support writing attachments through anything but SG.
@@ -391,6 +508,7 @@ func (db *Database) migrateMetadata(docid string, body Body, existingDoc *sgbuck
 
 	// If it was a cas mismatch, propagate an error as far up the stack as possible to force a full refresh + retry
 	if base.IsCasMismatch(writeErr) {
+		db.resyncAdvisor.record(resyncSignalMigrateCasMismatch, 1)
 		return nil, false, base.ErrCasFailureShouldRetry
 	}
 
@@ -431,7 +549,7 @@ func (db *Database) backupPreImportRevision(docid, revid string) error {
 		return err
 	}
 
-	setOldRevErr := db.setOldRevisionJSON(docid, revid, oldRevJSON, db.Options.OldRevExpirySeconds)
+	setOldRevErr := db.setOldRevisionJSON(docid, revid, oldRevJSON, db.oldRevExpiry())
 	if setOldRevErr != nil {
 		return fmt.Errorf("Persistence error: %v", setOldRevErr)
 	}
@@ -0,0 +1,126 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"sync"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// importBudget limits the feed event bytes being imported at once.  Each import retains its event's body and xattrs
+// until it completes, so with large documents and many concurrent imports, the retained bytes can dominate the heap.
+// A nil importBudget admits every import.
+type importBudget struct {
+	maxBytes      int64            // Bytes in flight above which imports aren't admitted
+	inFlightBytes int64            // Bytes of the imports currently admitted.  Guarded by lock
+	released      chan struct{}    // Closed and replaced whenever bytes are released, to wake waiting imports.  Guarded by lock
+	lock          sync.Mutex       // Guards inFlightBytes and released
+	inFlightStat  *base.SgwIntStat // In flight bytes gauge, optional
+}
+
+func newImportBudget(maxBytes int64, inFlightStat *base.SgwIntStat) *importBudget {
+	return &importBudget{
+		maxBytes:     maxBytes,
+		released:     make(chan struct{}),
+		inFlightStat: inFlightStat,
+	}
+}
+
+// tryAcquire admits an import of the given number of bytes if it fits within the budget, returning false otherwise, along
+// with a channel closed when bytes are next released.  An import larger than the whole budget is admitted once nothing
+// else is in flight, so that it can't wait forever.
+func (b *importBudget) tryAcquire(bytes int64) (ok bool, released <-chan struct{}) {
+	if b == nil {
+		return true, nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.inFlightBytes > 0 && b.inFlightBytes+bytes > b.maxBytes {
+		return false, b.released
+	}
+	b.inFlightBytes += bytes
+	b._updateStat()
+	return true, nil
+}
+
+// acquire admits an import of the given number of bytes, waiting until it fits within the budget.  Returns false without
+// admitting the import if terminator is closed first.
+func (b *importBudget) acquire(bytes int64, terminator <-chan bool) bool {
+	for {
+		ok, released := b.tryAcquire(bytes)
+		if ok {
+			return true
+		}
+		select {
+		case <-released:
+		case <-terminator:
+			return false
+		}
+	}
+}
+
+// release returns the bytes of a completed import to the budget, waking any waiting imports.
+func (b *importBudget) release(bytes int64) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.inFlightBytes -= bytes
+	b._updateStat()
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// inFlight returns the bytes of the imports currently admitted.
+func (b *importBudget) inFlight() int64 {
+	if b == nil {
+		return 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.inFlightBytes
+}
+
+func (b *importBudget) _updateStat() {
+	if b.inFlightStat != nil {
+		b.inFlightStat.Set(b.inFlightBytes)
+	}
+}
+
+// admitImport admits the import of a feed event of the given number of bytes within the listener's import budget.  When
+// the budget is exhausted, the import either waits or, when ImportOptions.DeferOverBudget is set, is deferred to the
+// document's next mutation.  Returns false when the import isn't admitted - callers must otherwise release the bytes
+// once the import completes.
+func (il *importListener) admitImport(docID string, bytes int64) bool {
+	ok, _ := il.budget.tryAcquire(bytes)
+	if ok {
+		return true
+	}
+	importStats := il.importStats
+	if il.deferOverBudget {
+		base.Debugf(base.KeyImport, "Deferring import of doc %q to its next mutation - %d bytes already being imported, over the import budget of %d bytes",
+			base.UD(docID), il.budget.inFlight(), il.budget.maxBytes)
+		if importStats != nil {
+			importStats.ImportBudgetDeferredCount.Add(1)
+		}
+		return false
+	}
+	if importStats != nil {
+		importStats.ImportBudgetWaitCount.Add(1)
+	}
+	if !il.budget.acquire(bytes, il.terminator) {
+		base.Infof(base.KeyImport, "Aborting import for doc %q - importListener.terminator was closed while waiting for import budget", base.UD(docID))
+		return false
+	}
+	return true
+}
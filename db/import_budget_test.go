@@ -0,0 +1,124 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBudgetTestImportListener returns an import listener with an import budget of maxBytes, without an import feed.
+func newBudgetTestImportListener(maxBytes int64, deferOverBudget bool) *importListener {
+	dbStats := base.NewSyncGatewayStats().NewDBStats("", false, false, false)
+	dbStats.InitSharedBucketImportStats()
+	il := NewImportListener()
+	il.importStats = dbStats.SharedBucketImport()
+	il.budget = newImportBudget(maxBytes, il.importStats.ImportInFlightBytes)
+	il.deferOverBudget = deferOverBudget
+	return il
+}
+
+// Validates that imports over the budget wait for in flight imports to complete, and that an import larger than the
+// whole budget is admitted once nothing else is in flight.
+func TestImportBudgetWaits(t *testing.T) {
+
+	il := newBudgetTestImportListener(1000, false)
+	importStats := il.importStats
+
+	require.True(t, il.admitImport("doc1", 600))
+	require.True(t, il.admitImport("doc2", 400))
+	assert.Equal(t, int64(1000), importStats.ImportInFlightBytes.Value())
+
+	admitted := make(chan bool)
+	go func() {
+		admitted <- il.admitImport("oversized", 5000)
+	}()
+	_, ok := base.WaitForStat(importStats.ImportBudgetWaitCount.Value, 1)
+	require.True(t, ok)
+	select {
+	case <-admitted:
+		t.Fatal("Import admitted over budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Still in flight after the first release, so the oversized import keeps waiting
+	il.budget.release(600)
+	select {
+	case <-admitted:
+		t.Fatal("Oversized import admitted while another import is in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	il.budget.release(400)
+	select {
+	case ok := <-admitted:
+		assert.True(t, ok)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for import to be admitted")
+	}
+	assert.Equal(t, int64(5000), importStats.ImportInFlightBytes.Value())
+	il.budget.release(5000)
+	assert.Equal(t, int64(0), importStats.ImportInFlightBytes.Value())
+	assert.Equal(t, int64(0), importStats.ImportBudgetDeferredCount.Value())
+}
+
+// Validates that with DeferOverBudget, imports over the budget are deferred and counted rather than waiting.
+func TestImportBudgetDeferred(t *testing.T) {
+
+	il := newBudgetTestImportListener(1000, true)
+	importStats := il.importStats
+
+	require.True(t, il.admitImport("doc1", 800))
+	assert.False(t, il.admitImport("doc2", 500))
+	assert.False(t, il.admitImport("oversized", 5000))
+	assert.Equal(t, int64(2), importStats.ImportBudgetDeferredCount.Value())
+	assert.Equal(t, int64(800), importStats.ImportInFlightBytes.Value())
+
+	il.budget.release(800)
+	assert.True(t, il.admitImport("oversized", 5000))
+	assert.Equal(t, int64(5000), importStats.ImportInFlightBytes.Value())
+	assert.Equal(t, int64(0), importStats.ImportBudgetWaitCount.Value())
+}
+
+// Validates that an import waiting for budget is abandoned when the import listener is stopped.
+func TestImportBudgetWaitStopped(t *testing.T) {
+
+	il := newBudgetTestImportListener(1000, false)
+
+	require.True(t, il.admitImport("doc1", 1000))
+	admitted := make(chan bool)
+	go func() {
+		admitted <- il.admitImport("doc2", 1)
+	}()
+	_, ok := base.WaitForStat(il.importStats.ImportBudgetWaitCount.Value, 1)
+	require.True(t, ok)
+
+	il.Stop()
+	select {
+	case ok := <-admitted:
+		assert.False(t, ok)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for import to be abandoned")
+	}
+	assert.Equal(t, int64(1000), il.budget.inFlight())
+}
+
+// Validates that a nil budget admits every import.
+func TestImportBudgetDisabled(t *testing.T) {
+	il := NewImportListener()
+	assert.True(t, il.admitImport("doc1", 1<<30))
+	il.budget.release(1 << 30)
+	assert.Equal(t, int64(0), il.budget.inFlight())
+}
@@ -12,6 +12,7 @@ package db
 
 import (
 	"strings"
+	"time"
 
 	sgbucket "github.com/couchbase/sg-bucket"
 	"github.com/couchbase/sync_gateway/base"
@@ -20,11 +21,19 @@ import (
 // ImportListener manages the import DCP feed.  ProcessFeedEvent is triggered for each feed events,
 // and invokes ImportFeedEvent for any event that's eligible for import handling.
 type importListener struct {
-	bucketName  string              // Used for logging
-	terminator  chan bool           // Signal to cause cbdatasource bucketdatasource.Close() to be called, which removes dcp receiver
-	database    Database            // Admin database instance to be used for import
-	stats       *base.DatabaseStats // Database stats group
-	cbgtContext *base.CbgtContext   // Handle to cbgt manager,cfg
+	bucketName        string              // Used for logging
+	terminator        chan bool           // Signal to cause cbdatasource bucketdatasource.Close() to be called, which removes dcp receiver
+	database          Database            // Admin database instance to be used for import
+	stats             *base.DatabaseStats // Database stats group
+	cbgtContext       *base.CbgtContext   // Handle to cbgt manager,cfg
+	vbLag             feedVbLagTracker    // Last event processed per vbucket
+	emptyMetaReporter *base.LogCoalescer  // Summarizes feed documents with unexpected empty metadata
+
+	// Limits the feed event bytes being imported at once, nil when ImportOptions.MaxInFlightBytes isn't set
+	budget          *importBudget
+	deferOverBudget bool                          // Defer imports over budget, rather than waiting
+	importStats     *base.SharedBucketImportStats // Import stats group, optional
+	stageSampler    *feedStageSampler             // Selects the imports that are timed, at the cache's feed stage sample rate
 }
 
 func NewImportListener() *importListener {
@@ -43,6 +52,20 @@ func (il *importListener) StartImportFeed(bucket base.Bucket, dbStats *base.DbSt
 	il.bucketName = bucket.GetName()
 	il.database = Database{DatabaseContext: dbContext, user: nil}
 	il.stats = dbStats.Database()
+	il.importStats = dbStats.SharedBucketImport()
+	if dbContext.changeCache != nil {
+		il.stageSampler = newFeedStageSampler(dbContext.changeCache.GetOptions().FeedStageSampleRate)
+	}
+	if maxBytes := dbContext.Options.ImportOptions.MaxInFlightBytes; maxBytes > 0 {
+		var inFlightStat *base.SgwIntStat
+		if il.importStats != nil {
+			inFlightStat = il.importStats.ImportInFlightBytes
+		}
+		il.budget = newImportBudget(maxBytes, inFlightStat)
+		il.deferOverBudget = dbContext.Options.ImportOptions.DeferOverBudget
+	}
+	il.emptyMetaReporter = base.NewLogCoalescer(base.LevelWarn, base.KeyAll, "Import: Unexpected empty metadata when processing feed events",
+		base.DefaultLogCoalesceInterval, nil)
 	feedArgs := sgbucket.FeedArguments{
 		ID:         base.DCPImportFeedID,
 		Backfill:   sgbucket.FeedResume,
@@ -52,6 +75,18 @@ func (il *importListener) StartImportFeed(bucket base.Bucket, dbStats *base.DbSt
 
 	importFeedStatsMap := dbContext.DbStats.Database().ImportFeedMapStats
 
+	// Record the checkpoint group used by the import feed, which the feed persists its checkpoints under, so that
+	// checkpoints for previous groups can be collected
+	if err := base.SetActiveDCPCheckpointGroup(bucket, dbContext.Options.ImportOptions.CheckpointGroup); err != nil {
+		base.Warnf("Unable to record active DCP checkpoint group for import feed: %v", err)
+	} else if importOptions := dbContext.Options.ImportOptions; importOptions.CheckpointGCOnStartup && importOptions.CheckpointGCMaxAge > 0 {
+		go func() {
+			if _, err := dbContext.CollectStaleDCPCheckpoints(importOptions.CheckpointGCMaxAge, false); err != nil {
+				base.Warnf("Unable to collect stale DCP checkpoints on import feed startup: %v", err)
+			}
+		}()
+	}
+
 	// Register cbgt PIndex to support sharded import.
 	il.RegisterImportPindexImpl()
 
@@ -79,6 +114,9 @@ func (il *importListener) ProcessFeedEvent(event sgbucket.FeedEvent) (shouldPers
 	if event.Opcode != sgbucket.FeedOpMutation && event.Opcode != sgbucket.FeedOpDeletion {
 		return true
 	}
+	defer func() {
+		il.vbLag.eventProcessed(event.VbNo, event.Cas, time.Now())
+	}()
 	key := string(event.Key)
 
 	// Ignore internal documents
@@ -111,7 +149,8 @@ func (il *importListener) ImportFeedEvent(event sgbucket.FeedEvent) {
 	if err != nil {
 		base.Debugf(base.KeyImport, "Found sync metadata, but unable to unmarshal for feed document %q.  Will not be imported.  Error: %v", base.UD(event.Key), err)
 		if err == base.ErrEmptyMetadata {
-			base.Warnf("Unexpected empty metadata when processing feed event.  docid: %s opcode: %v datatype:%v", base.UD(event.Key), event.Opcode, event.DataType)
+			base.Debugf(base.KeyImport, "Unexpected empty metadata when processing feed event.  docid: %s opcode: %v datatype:%v", base.UD(event.Key), event.Opcode, event.DataType)
+			il.emptyMetaReporter.Add(string(event.Key))
 		}
 		return
 	}
@@ -141,7 +180,27 @@ func (il *importListener) ImportFeedEvent(event sgbucket.FeedEvent) {
 		default:
 		}
 
+		if il.database.isImportSuppressed(docID) {
+			base.Debugf(base.KeyImport, "Not importing mutation for doc %q - import is suppressed for its key prefix", base.UD(docID))
+			return
+		}
+
+		// The event's body and xattrs are retained until the import completes, so count against the import budget
+		eventBytes := int64(len(event.Value))
+		if !il.admitImport(docID, eventBytes) {
+			return
+		}
+		defer il.budget.release(eventBytes)
+
+		var importStart time.Time
+		sampled := il.importStats != nil && il.stageSampler.sample()
+		if sampled {
+			importStart = time.Now()
+		}
 		_, err := il.database.ImportDocRaw(docID, rawBody, rawXattr, rawUserXattr, isDelete, event.Cas, &event.Expiry, ImportFromFeed)
+		if sampled {
+			observeStage(il.importStats.ImportTime, importStart)
+		}
 		if err != nil {
 			if err == base.ErrImportCasFailure {
 				base.Debugf(base.KeyImport, "Not importing mutation - document %s has been subsequently updated and will be imported based on that mutation.", base.UD(docID))
@@ -173,5 +232,8 @@ func (il *importListener) Stop() {
 			// TODO: Shut down the cfg (when cfg supports)
 		}
 		close(il.terminator)
+		if il.emptyMetaReporter != nil {
+			il.emptyMetaReporter.Flush()
+		}
 	}
 }
@@ -0,0 +1,138 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// DefaultImportSuppressionTTL is the lifetime of an import suppression added without a TTL.
+const DefaultImportSuppressionTTL = time.Hour
+
+// MaxImportSuppressionTTL bounds the lifetime of an import suppression, so that a forgotten suppression can't stop
+// import of a class of documents indefinitely.
+const MaxImportSuppressionTTL = 7 * 24 * time.Hour
+
+// ImportSuppression describes a runtime suppression of feed import for documents whose IDs start with Prefix.
+type ImportSuppression struct {
+	Prefix          string    `json:"prefix"`
+	Expiry          time.Time `json:"expiry"`           // Time after which documents matching the prefix are imported again
+	SuppressedCount int64     `json:"suppressed_count"` // Number of feed imports suppressed by this entry
+}
+
+// importSuppressions is the set of import suppressions for a database, keyed by prefix.  Expired entries are removed
+// lazily, when next encountered.
+type importSuppressions struct {
+	entries map[string]*ImportSuppression
+	lock    sync.RWMutex
+}
+
+// SuppressImport suppresses feed import of documents with IDs starting with prefix, for the given TTL.  Replaces any
+// existing suppression for the prefix.  Documents written through Sync Gateway are unaffected.
+func (context *DatabaseContext) SuppressImport(prefix string, ttl time.Duration) ImportSuppression {
+	if ttl <= 0 {
+		ttl = DefaultImportSuppressionTTL
+	} else if ttl > MaxImportSuppressionTTL {
+		ttl = MaxImportSuppressionTTL
+	}
+
+	s := &context.importSuppressions
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.entries == nil {
+		s.entries = make(map[string]*ImportSuppression)
+	}
+	suppression := &ImportSuppression{Prefix: prefix, Expiry: time.Now().Add(ttl)}
+	s.entries[prefix] = suppression
+	base.Infof(base.KeyImport, "Suppressing import of documents with prefix %q until %s", base.UD(prefix), suppression.Expiry.Format(time.RFC3339))
+	return *suppression
+}
+
+// RemoveImportSuppression removes the import suppression for prefix, returning false if there wasn't one.
+func (context *DatabaseContext) RemoveImportSuppression(prefix string) bool {
+	s := &context.importSuppressions
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.entries[prefix]; !ok {
+		return false
+	}
+	delete(s.entries, prefix)
+	base.Infof(base.KeyImport, "Removed import suppression for documents with prefix %q", base.UD(prefix))
+	return true
+}
+
+// ImportSuppressions returns the unexpired import suppressions, ordered by prefix.
+func (context *DatabaseContext) ImportSuppressions() []ImportSuppression {
+	s := &context.importSuppressions
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s._removeExpired(time.Now())
+	suppressions := make([]ImportSuppression, 0, len(s.entries))
+	for _, suppression := range s.entries {
+		suppressions = append(suppressions, ImportSuppression{
+			Prefix:          suppression.Prefix,
+			Expiry:          suppression.Expiry,
+			SuppressedCount: atomic.LoadInt64(&suppression.SuppressedCount),
+		})
+	}
+	sort.Slice(suppressions, func(i, j int) bool {
+		return suppressions[i].Prefix < suppressions[j].Prefix
+	})
+	return suppressions
+}
+
+// isImportSuppressed returns true when feed import of docID is suppressed, counting the suppressed import.
+func (context *DatabaseContext) isImportSuppressed(docID string) bool {
+	s := &context.importSuppressions
+	now := time.Now()
+	foundExpired := false
+
+	s.lock.RLock()
+	for prefix, suppression := range s.entries {
+		if !strings.HasPrefix(docID, prefix) {
+			continue
+		}
+		if now.After(suppression.Expiry) {
+			foundExpired = true
+			continue
+		}
+		atomic.AddInt64(&suppression.SuppressedCount, 1)
+		s.lock.RUnlock()
+		if importStats := context.DbStats.SharedBucketImport(); importStats != nil {
+			importStats.ImportSuppressedCount.Add(1)
+		}
+		return true
+	}
+	s.lock.RUnlock()
+
+	if foundExpired {
+		s.lock.Lock()
+		s._removeExpired(now)
+		s.lock.Unlock()
+	}
+	return false
+}
+
+// _removeExpired removes suppressions that have expired as of now.  Requires s.lock.
+func (s *importSuppressions) _removeExpired(now time.Time) {
+	for prefix, suppression := range s.entries {
+		if now.After(suppression.Expiry) {
+			base.Infof(base.KeyImport, "Import suppression for documents with prefix %q expired - resuming import", base.UD(prefix))
+			delete(s.entries, prefix)
+		}
+	}
+}
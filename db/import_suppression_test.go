@@ -0,0 +1,59 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportSuppression(t *testing.T) {
+	context := &DatabaseContext{
+		DbStats: base.NewSyncGatewayStats().NewDBStats("", false, true, false),
+	}
+
+	assert.False(t, context.isImportSuppressed("bulk::1"))
+	assert.Len(t, context.ImportSuppressions(), 0)
+
+	suppression := context.SuppressImport("bulk::", 0)
+	assert.WithinDuration(t, time.Now().Add(DefaultImportSuppressionTTL), suppression.Expiry, time.Minute)
+	suppression = context.SuppressImport("tmp::", 30*24*time.Hour)
+	assert.WithinDuration(t, time.Now().Add(MaxImportSuppressionTTL), suppression.Expiry, time.Minute)
+
+	assert.True(t, context.isImportSuppressed("bulk::1"))
+	assert.True(t, context.isImportSuppressed("bulk::2"))
+	assert.True(t, context.isImportSuppressed("tmp::1"))
+	assert.False(t, context.isImportSuppressed("user::1"))
+	assert.Equal(t, int64(3), context.DbStats.SharedBucketImport().ImportSuppressedCount.Value())
+
+	suppressions := context.ImportSuppressions()
+	require.Len(t, suppressions, 2)
+	assert.Equal(t, "bulk::", suppressions[0].Prefix)
+	assert.Equal(t, int64(2), suppressions[0].SuppressedCount)
+	assert.Equal(t, "tmp::", suppressions[1].Prefix)
+	assert.Equal(t, int64(1), suppressions[1].SuppressedCount)
+
+	// Removal resumes import
+	assert.True(t, context.RemoveImportSuppression("tmp::"))
+	assert.False(t, context.RemoveImportSuppression("tmp::"))
+	assert.False(t, context.isImportSuppressed("tmp::2"))
+
+	// Expired suppressions no longer apply, and are removed
+	context.SuppressImport("bulk::", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	assert.False(t, context.isImportSuppressed("bulk::3"))
+	assert.Len(t, context.ImportSuppressions(), 0)
+	assert.Equal(t, int64(3), context.DbStats.SharedBucketImport().ImportSuppressedCount.Value())
+}
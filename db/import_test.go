@@ -11,8 +11,10 @@ licenses/APL2.txt.
 package db
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"testing"
 	"time"
 
@@ -20,6 +22,7 @@ import (
 
 	sgbucket "github.com/couchbase/sg-bucket"
 	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
 	goassert "github.com/couchbaselabs/go.assert"
 	"github.com/stretchr/testify/assert"
 )
@@ -175,7 +178,7 @@ func TestImportWithStaleBucketDocCorrectExpiry(t *testing.T) {
 			require.NoError(t, err)
 
 			// Import the doc (will migrate as part of the import since the doc contains sync meta)
-			_, errImportDoc := db.importDoc(key, body, false, existingBucketDoc, ImportOnDemand)
+			_, errImportDoc := db.importDoc(key, body, false, false, existingBucketDoc, ImportOnDemand)
 			assert.NoError(t, errImportDoc, "Unexpected error")
 
 			// Make sure the doc in the bucket has expected XATTR
@@ -322,7 +325,7 @@ func TestImportWithCasFailureUpdate(t *testing.T) {
 			runOnce = true
 
 			// Trigger import
-			_, err = db.importDoc(testcase.docname, bodyD, false, existingBucketDoc, ImportOnDemand)
+			_, err = db.importDoc(testcase.docname, bodyD, false, false, existingBucketDoc, ImportOnDemand)
 			assert.NoError(t, err)
 
 			// Check document has the rev and new body
@@ -390,7 +393,7 @@ func TestImportNullDoc(t *testing.T) {
 	existingDoc := &sgbucket.BucketDocument{Body: rawNull, Cas: 1}
 
 	// Import a null document
-	importedDoc, err := db.importDoc(key+"1", body, false, existingDoc, ImportOnDemand)
+	importedDoc, err := db.importDoc(key+"1", body, false, false, existingDoc, ImportOnDemand)
 	goassert.Equals(t, err, base.ErrEmptyDocument)
 	assert.True(t, importedDoc == nil, "Expected no imported doc")
 }
@@ -408,6 +411,163 @@ func TestImportNullDocRaw(t *testing.T) {
 	assert.True(t, importedDoc == nil, "Expected no imported doc")
 }
 
+func TestImportDocSizeLimits(t *testing.T) {
+
+	if !base.TestUseXattrs() {
+		t.Skip("This test only works with XATTRS enabled")
+	}
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyImport)()
+
+	db := setupTestDBWithOptions(t, DatabaseContextOptions{
+		ImportOptions: ImportOptions{
+			LargeDocSize: 100,
+			MaxDocSize:   1000,
+		},
+	})
+	defer db.Close()
+
+	importStats := db.DbStats.SharedBucketImport()
+	exp := uint32(0)
+
+	testCases := []struct {
+		name          string
+		valueSize     int
+		expectedErr   error
+		expectedLarge int64
+	}{
+		{name: "belowThreshold", valueSize: 10, expectedLarge: 0},
+		{name: "aboveThreshold", valueSize: 500, expectedLarge: 1},
+		{name: "aboveMaxSize", valueSize: 2000, expectedErr: base.ErrImportDocTooLarge, expectedLarge: 1},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			key := "TestImportDocSizeLimits_" + testCase.name
+			value := []byte(fmt.Sprintf(`{"value":"%s"}`, strings.Repeat("a", testCase.valueSize)))
+			cas, err := db.Bucket.WriteCas(key, 0, 0, 0, value, sgbucket.Raw)
+			require.NoError(t, err)
+
+			importedDoc, err := db.ImportDocRaw(key, value, nil, nil, false, cas, &exp, ImportFromFeed)
+			if testCase.expectedErr != nil {
+				assert.Equal(t, testCase.expectedErr, err)
+				assert.Nil(t, importedDoc)
+			} else {
+				require.NoError(t, err)
+				require.NotNil(t, importedDoc)
+			}
+			assert.Equal(t, testCase.expectedLarge, importStats.ImportLargeDocCount.Value())
+		})
+	}
+}
+
+// Validates that only the declared fields, and special properties, are decoded from a body.
+func TestDecodeDeclaredFields(t *testing.T) {
+	value := []byte(`{"type":"order","value":"` + strings.Repeat("a", 1000) + `","nested":{"channels":["XYZ"],"list":[1,[2,{"a":3}]]},"channels":["ABC"],"_deleted":false,"count":12345678901234567890}`)
+
+	body, err := decodeDeclaredFields(value, []string{"type", "channels", "count"})
+	require.NoError(t, err)
+	assert.Equal(t, Body{
+		"type":     "order",
+		"channels": []interface{}{"ABC"},
+		"_deleted": false,
+		"count":    json.Number("12345678901234567890"),
+	}, body)
+
+	body, err = decodeDeclaredFields([]byte("null"), []string{"type"})
+	require.NoError(t, err)
+	assert.Nil(t, body)
+
+	_, err = decodeDeclaredFields([]byte(`["type"]`), []string{"type"})
+	assert.Error(t, err)
+	_, err = decodeDeclaredFields([]byte(`{"type":"order","value":[1,2`), []string{"type"})
+	assert.Error(t, err)
+}
+
+// Validates that an import of a document over the large doc size passes only the declared fields to the sync function,
+// while the document body is imported in full.
+func TestImportDeclaredFields(t *testing.T) {
+
+	if !base.TestUseXattrs() {
+		t.Skip("This test only works with XATTRS enabled")
+	}
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyImport)()
+
+	db := setupTestDBWithOptions(t, DatabaseContextOptions{
+		ImportOptions: ImportOptions{
+			LargeDocSize:   100,
+			DeclaredFields: []string{"channels"},
+		},
+	})
+	defer db.Close()
+	db.ChannelMapper = channels.NewChannelMapper(`function(doc, oldDoc) {
+		if (doc.value !== undefined) {
+			throw({forbidden: "undeclared field"});
+		}
+		channel(doc.channels);
+	}`)
+
+	key := "TestImportDeclaredFields"
+	largeValue := strings.Repeat("a", 500)
+	value := []byte(fmt.Sprintf(`{"channels":["ABC"],"value":"%s"}`, largeValue))
+	cas, err := db.Bucket.WriteCas(key, 0, 0, 0, value, sgbucket.Raw)
+	require.NoError(t, err)
+
+	exp := uint32(0)
+	importedDoc, err := db.ImportDocRaw(key, value, nil, nil, false, cas, &exp, ImportFromFeed)
+	require.NoError(t, err)
+	require.NotNil(t, importedDoc)
+	assert.Equal(t, int64(1), db.DbStats.SharedBucketImport().ImportLargeDocCount.Value())
+	assert.Contains(t, importedDoc.Channels, "ABC")
+
+	doc, err := db.GetDocument(key, DocUnmarshalAll)
+	require.NoError(t, err)
+	assert.Equal(t, largeValue, doc.Body()["value"])
+}
+
+// Validates that imports made by the import feed are timed when sampled.
+func TestImportFeedStageTiming(t *testing.T) {
+
+	if !base.TestUseXattrs() {
+		t.Skip("This test only works with XATTRS enabled")
+	}
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyImport)()
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	il := NewImportListener()
+	il.database = Database{DatabaseContext: db.DatabaseContext}
+	il.stats = db.DbStats.Database()
+	il.importStats = db.DbStats.SharedBucketImport()
+	require.NotNil(t, il.importStats)
+	il.stageSampler = newFeedStageSampler(1)
+	initialCount := il.importStats.ImportTime.Count()
+	initialSum := il.importStats.ImportTime.Snapshot().Sum
+
+	const numDocs = 3
+	for i := 0; i < numDocs; i++ {
+		key := fmt.Sprintf("TestImportFeedStageTiming_%d", i)
+		value := []byte(`{"value":"a"}`)
+		cas, err := db.Bucket.WriteCas(key, 0, 0, 0, value, sgbucket.Raw)
+		require.NoError(t, err)
+		il.ImportFeedEvent(sgbucket.FeedEvent{
+			Opcode:   sgbucket.FeedOpMutation,
+			Key:      []byte(key),
+			Value:    value,
+			Cas:      cas,
+			DataType: base.MemcachedDataTypeJSON,
+		})
+	}
+
+	snapshot := il.importStats.ImportTime.Snapshot()
+	assert.Equal(t, initialCount+numDocs, snapshot.Count)
+	assert.Greater(t, snapshot.Sum, initialSum)
+	assert.Less(t, snapshot.Sum-initialSum, int64(numDocs*time.Minute))
+}
+
 func assertXattrSyncMetaRevGeneration(t *testing.T, bucket base.Bucket, key string, expectedRevGeneration int) {
 	xattr := map[string]interface{}{}
 	_, err := bucket.GetWithXattr(key, base.SyncXattrName, "", nil, &xattr, nil)
@@ -29,7 +29,7 @@ func TestInitializeIndexes(t *testing.T) {
 		t.Skip("This test only works with Couchbase Server and UseViews=false")
 	}
 
-	db := setupTestDB(t)
+	db := setupExclusiveTestDB(t)
 	defer db.Close()
 
 	goCbBucket, isGoCBBucket := base.AsGoCBBucket(db.Bucket)
@@ -88,7 +88,7 @@ func TestPostUpgradeIndexesSimple(t *testing.T) {
 		t.Skip("This test only works with Couchbase Server and UseViews=false")
 	}
 
-	db := setupTestDB(t)
+	db := setupExclusiveTestDB(t)
 	defer db.Close()
 
 	gocbBucket, ok := base.AsGoCBBucket(db.Bucket)
@@ -135,7 +135,7 @@ func TestPostUpgradeIndexesVersionChange(t *testing.T) {
 		t.Skip("This test only works with Couchbase Server and UseViews=false")
 	}
 
-	db := setupTestDB(t)
+	db := setupExclusiveTestDB(t)
 	defer db.Close()
 
 	gocbBucket, ok := base.AsGoCBBucket(db.Bucket)
@@ -181,7 +181,7 @@ func TestRemoveIndexesUseViewsTrueAndFalse(t *testing.T) {
 		t.Skip("This test only works with Couchbase Server and UseViews=false")
 	}
 
-	db := setupTestDB(t)
+	db := setupExclusiveTestDB(t)
 	defer db.Close()
 
 	copiedIndexes := copySGIndexes(sgIndexes)
@@ -237,7 +237,7 @@ func TestRemoveObsoleteIndexOnError(t *testing.T) {
 		t.Skip("This test only works with Couchbase Server and UseViews=false")
 	}
 
-	db := setupTestDB(t)
+	db := setupExclusiveTestDB(t)
 	defer db.Close()
 
 	leakyBucket := base.NewLeakyBucket(db.Bucket, base.LeakyBucketConfig{DropIndexErrorNames: []string{"sg_access_1", "sg_access_x1"}})
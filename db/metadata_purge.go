@@ -0,0 +1,137 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// PurgeMetadata purges the metadata documents with the given key prefixes that are no longer in use.  Each prefix has
+// its own predicate:
+//
+//   - DCP checkpoints are purged once their checkpoint group has been inactive for at least maxAge.  Checkpoints of the
+//     active group are never purged.
+//   - External revision bodies are purged when no document's revision tree references them.
+//   - Unused sequence notifications are purged once the change cache has moved past all of their sequences.
+//
+// In dry-run mode, matching documents are only counted.
+func (db *DatabaseContext) PurgeMetadata(prefixes []string, maxAge time.Duration, dryRun bool) (*base.MetadataPurgeResult, error) {
+
+	predicates := make(map[string]func(key string) bool, len(prefixes))
+	for _, prefix := range prefixes {
+		var predicate func(key string) bool
+		var err error
+		switch prefix {
+		case base.DCPCheckpointPrefix:
+			predicate, err = base.StaleDCPCheckpointPredicate(db.Bucket, maxAge)
+		case base.RevBodyPrefix:
+			predicate, err = db.orphanedRevBodyPredicate()
+		case base.UnusedSeqPrefix, base.UnusedSeqRangePrefix:
+			predicate = db.releasedUnusedSequencePredicate()
+		default:
+			return nil, fmt.Errorf("Metadata with prefix %q can't be purged", prefix)
+		}
+		if err != nil {
+			return nil, err
+		}
+		predicates[prefix] = predicate
+	}
+
+	shouldPurge := func(key string) bool {
+		for prefix, predicate := range predicates {
+			if strings.HasPrefix(key, prefix) {
+				return predicate(key)
+			}
+		}
+		return false
+	}
+	return base.MetadataPurge(db.Bucket, db.ForEachMetadataKey, prefixes, shouldPurge, dryRun)
+}
+
+// orphanedRevBodyPredicate returns a predicate matching the external revision bodies that aren't referenced by the
+// revision tree of any document.  Bodies are listed before referenced body keys are queried, so a body written during
+// the scan is never matched, even when the document referencing it hasn't been written yet.
+func (db *DatabaseContext) orphanedRevBodyPredicate() (func(key string) bool, error) {
+
+	orphaned := make(map[string]struct{})
+	err := db.ForEachMetadataKey(base.RevBodyPrefix, func(key string) error {
+		orphaned[key] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	limit := db.Options.QueryPaginationLimit
+	startSeq := uint64(0)
+	for len(orphaned) > 0 {
+		results, err := db.QueryRevBodyKeys(startSeq, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		resultCount := 0
+		for {
+			var seq uint64
+			var bodyKeys []string
+			if db.Options.UseViews {
+				var viewRow RevBodyKeysViewQueryRow
+				if !results.Next(&viewRow) {
+					break
+				}
+				seq, bodyKeys = viewRow.Key, viewRow.Value
+			} else {
+				var queryRow RevBodyKeysIndexQueryRow
+				if !results.Next(&queryRow) {
+					break
+				}
+				seq, bodyKeys = queryRow.Sequence, queryRow.BodyKeys
+			}
+			resultCount++
+			startSeq = seq + 1
+			for _, bodyKey := range bodyKeys {
+				delete(orphaned, bodyKey)
+			}
+		}
+		if err := results.Close(); err != nil {
+			return nil, err
+		}
+
+		if limit == 0 || resultCount < limit {
+			break
+		}
+	}
+
+	return func(key string) bool {
+		_, ok := orphaned[key]
+		return ok
+	}, nil
+}
+
+// releasedUnusedSequencePredicate returns a predicate matching the unused sequence notifications whose sequences are
+// all older than the change cache's next sequence and oldest skipped sequence, and so are no longer waited for.
+func (db *DatabaseContext) releasedUnusedSequencePredicate() func(key string) bool {
+	return func(key string) bool {
+		doc, err := parseUnusedSequenceDocKey(key)
+		if err != nil {
+			return false
+		}
+		if doc.toSequence >= db.changeCache.getNextSequence() {
+			return false
+		}
+		oldestSkipped := db.changeCache.getOldestSkippedSequence()
+		return oldestSkipped == 0 || doc.toSequence < oldestSkipped
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// DefaultMetadataUsageOpsPerSec is the rate at which a metadata usage scan reads metadata documents, when not
+// specified.
+const DefaultMetadataUsageOpsPerSec = 500
+
+// States of a metadata usage scan
+const (
+	MetadataUsageStateRunning   = "running"
+	MetadataUsageStateCompleted = "completed"
+	MetadataUsageStateError     = "error"
+)
+
+// metadataUsagePrefixes are the key prefixes metadata usage is reported by.  Metadata documents that don't match a
+// more specific prefix are reported under base.SyncPrefix.
+var metadataUsagePrefixes = []string{
+	base.AttPrefix,
+	base.BackfillCompletePrefix,
+	base.BackfillPendingPrefix,
+	base.DCPCheckpointPrefix,
+	base.PurgeMarkerPrefix,
+	base.RepairBackup,
+	base.RepairDryRun,
+	base.RevBodyPrefix,
+	base.RevPrefix,
+	base.RolePrefix,
+	base.SessionPrefix,
+	base.SGCfgPrefix,
+	base.SGRStatusPrefix,
+	base.SyncSeqPrefix,
+	base.UserEmailPrefix,
+	base.UserPrefix,
+	base.UnusedSeqPrefix,
+	base.UnusedSeqRangePrefix,
+	base.SyncPrefix + DocTypeLocal + ":",
+}
+
+// errMetadataUsageScanStopped stops a metadata usage scan when the database is closed.
+var errMetadataUsageScanStopped = errors.New("metadata usage scan stopped - database closed")
+
+// MetadataUsage reports the number and approximate size of a database's metadata documents, by key prefix.  Sizes are
+// the total of key and body lengths, and exclude server metadata and any xattrs.
+type MetadataUsage struct {
+	Status     string                          `json:"status"`
+	StartTime  time.Time                       `json:"start_time"`
+	EndTime    *time.Time                      `json:"end_time,omitempty"`
+	Error      string                          `json:"error,omitempty"`
+	TotalCount int64                           `json:"total_count"`
+	TotalBytes int64                           `json:"total_bytes"`
+	Prefixes   map[string]*MetadataPrefixUsage `json:"prefixes"`
+}
+
+// MetadataPrefixUsage is the number and approximate size of the metadata documents with a given key prefix.
+type MetadataPrefixUsage struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// metadataUsageScans tracks the database's most recent metadata usage scan, in progress or complete.
+type metadataUsageScans struct {
+	last *MetadataUsage // Guarded by lock
+	lock sync.Mutex
+}
+
+// StartMetadataUsageScan scans the database's metadata documents, reading at most opsPerSec documents per second so
+// as not to impact other bucket operations.  Keys are listed by the metadata keys query - a N1QL query on the
+// sync docs index, or a view query when views are in use.  In background mode, returns the initial state of the scan
+// without waiting for it to complete.  Only one scan can run at a time.
+func (context *DatabaseContext) StartMetadataUsageScan(opsPerSec int, background bool) (*MetadataUsage, error) {
+	if opsPerSec <= 0 {
+		opsPerSec = DefaultMetadataUsageOpsPerSec
+	}
+
+	scans := &context.metadataUsageScans
+	scans.lock.Lock()
+	if scans.last != nil && scans.last.Status == MetadataUsageStateRunning {
+		scans.lock.Unlock()
+		return nil, base.HTTPErrorf(http.StatusConflict, "Metadata usage scan already running")
+	}
+	usage := &MetadataUsage{
+		Status:    MetadataUsageStateRunning,
+		StartTime: time.Now(),
+		Prefixes:  make(map[string]*MetadataPrefixUsage),
+	}
+	scans.last = usage
+	initial := usage.copy()
+	scans.lock.Unlock()
+
+	if background {
+		go context.scanMetadataUsage(usage, opsPerSec)
+		return initial, nil
+	}
+	context.scanMetadataUsage(usage, opsPerSec)
+	return context.MetadataUsage(), nil
+}
+
+// MetadataUsage returns the result of the most recent metadata usage scan, or its progress while running.  Returns
+// nil if no scan has been run.
+func (context *DatabaseContext) MetadataUsage() *MetadataUsage {
+	scans := &context.metadataUsageScans
+	scans.lock.Lock()
+	defer scans.lock.Unlock()
+	if scans.last == nil {
+		return nil
+	}
+	return scans.last.copy()
+}
+
+func (context *DatabaseContext) scanMetadataUsage(usage *MetadataUsage, opsPerSec int) {
+	scans := &context.metadataUsageScans
+	interval := time.Second / time.Duration(opsPerSec)
+	next := time.Now()
+
+	err := context.ForEachMetadataKey(base.SyncPrefix, func(key string) error {
+		if wait := time.Until(next); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-context.terminator:
+				return errMetadataUsageScanStopped
+			}
+		}
+		next = next.Add(interval)
+		if now := time.Now(); next.Before(now) {
+			// Don't make up for time spent on queries or slow reads with a burst of reads
+			next = now
+		}
+
+		value, _, err := context.Bucket.GetRaw(key)
+		if base.IsKeyNotFoundError(context.Bucket, err) {
+			// Removed since the key was listed
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		prefix := metadataUsagePrefix(key)
+		scans.lock.Lock()
+		prefixUsage, ok := usage.Prefixes[prefix]
+		if !ok {
+			prefixUsage = &MetadataPrefixUsage{}
+			usage.Prefixes[prefix] = prefixUsage
+		}
+		prefixUsage.Count++
+		prefixUsage.Bytes += int64(len(key) + len(value))
+		usage.TotalCount++
+		usage.TotalBytes += int64(len(key) + len(value))
+		scans.lock.Unlock()
+		return nil
+	})
+
+	scans.lock.Lock()
+	defer scans.lock.Unlock()
+	endTime := time.Now()
+	usage.EndTime = &endTime
+	if err != nil {
+		base.Warnf("Metadata usage scan for database %s failed: %v", base.MD(context.Name), err)
+		usage.Status = MetadataUsageStateError
+		usage.Error = err.Error()
+		return
+	}
+	usage.Status = MetadataUsageStateCompleted
+	context.DbStats.Database().MetadataDocCount.Set(usage.TotalCount)
+	base.Infof(base.KeyAll, "Metadata usage scan for database %s found %d metadata docs (%d bytes)", base.MD(context.Name), usage.TotalCount, usage.TotalBytes)
+}
+
+// metadataUsagePrefix returns the longest of metadataUsagePrefixes that key starts with, or base.SyncPrefix.
+func metadataUsagePrefix(key string) string {
+	match := base.SyncPrefix
+	for _, prefix := range metadataUsagePrefixes {
+		if len(prefix) > len(match) && strings.HasPrefix(key, prefix) {
+			match = prefix
+		}
+	}
+	return match
+}
+
+// copy returns a copy of usage that isn't modified by a running scan.  Requires metadataUsageScans.lock.
+func (usage *MetadataUsage) copy() *MetadataUsage {
+	usageCopy := *usage
+	usageCopy.Prefixes = make(map[string]*MetadataPrefixUsage, len(usage.Prefixes))
+	for prefix, prefixUsage := range usage.Prefixes {
+		prefixUsageCopy := *prefixUsage
+		usageCopy.Prefixes[prefix] = &prefixUsageCopy
+	}
+	return &usageCopy
+}
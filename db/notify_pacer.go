@@ -0,0 +1,210 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+const (
+	// DefaultNotifyPacingMaxWindow is the maximum time a change notification is delayed for coalescing, when not
+	// specified.
+	DefaultNotifyPacingMaxWindow = 500 * time.Millisecond
+
+	notifyPacingMinWindow    = 5 * time.Millisecond   // Coalescing window used when the rate first exceeds the threshold
+	notifyPacingRateInterval = 100 * time.Millisecond // Interval over which the notification rate is measured
+)
+
+// NotifyPacingOptions configures the coalescing of change notifications to changes feeds.  While change notifications
+// arrive faster than the threshold, for example during catch-up after ingestion is re-enabled, each notification
+// causes every continuous and longpoll feed for an affected channel to re-scan its channels.  Notifications are instead
+// coalesced over a window that widens while the rate stays over the threshold, and narrows again as it drops.
+type NotifyPacingOptions struct {
+	RateThreshold int    `json:"rate_threshold,omitempty"` // Notifications per second above which notifications are coalesced.  Zero disables pacing
+	MaxWindowMs   uint32 `json:"max_window_ms,omitempty"`  // Maximum time a notification is delayed.  Defaults to DefaultNotifyPacingMaxWindow
+}
+
+// notifyPacer coalesces change notifications while their rate exceeds a threshold.  The coalescing window doubles for
+// each rate interval over the threshold, up to the max window, and halves for each interval under it.  A notification
+// is never delayed by more than the max window, as windows aren't extended once notifications are pending.  Only
+// notifications are delayed - feeds waiting for changes still time out and send heartbeats on schedule.
+type notifyPacer struct {
+	notify     func(base.Set)   // Callback notified of changed channels
+	threshold  int              // Notifications per second above which notifications are coalesced
+	maxWindow  time.Duration    // Maximum coalescing window
+	windowStat *base.SgwIntStat // Effective coalescing window gauge, in ms
+	now        func() time.Time // Current time, replaceable by tests
+	lock       sync.Mutex       // Coordinates access to the fields below
+	fixed      bool             // Set when the window isn't adapted to the notification rate
+	window     time.Duration    // Effective coalescing window.  Zero while notifications aren't coalesced
+	rateStart  time.Time        // Start of the current rate interval
+	rateCount  int              // Notifications received in the current rate interval
+	pending    base.Set         // Changed channels awaiting notification
+	flushTimer *time.Timer      // Notifies pending channels at the end of the window they arrived in
+	stopped    bool             // Set by stop - pending and subsequent notifications are dropped
+}
+
+// newNotifyPacer returns a pacer notifying notify, or nil when pacing isn't enabled by options.
+func newNotifyPacer(options NotifyPacingOptions, notify func(base.Set), windowStat *base.SgwIntStat) *notifyPacer {
+	if options.RateThreshold <= 0 {
+		return nil
+	}
+	maxWindow := DefaultNotifyPacingMaxWindow
+	if options.MaxWindowMs > 0 {
+		maxWindow = time.Duration(options.MaxWindowMs) * time.Millisecond
+	}
+	return &notifyPacer{
+		notify:     notify,
+		threshold:  options.RateThreshold,
+		maxWindow:  maxWindow,
+		windowStat: windowStat,
+		now:        time.Now,
+	}
+}
+
+// newFixedNotifyPacer returns a pacer notifying notify at most once per window, regardless of the notification rate,
+// or nil when window isn't positive.
+func newFixedNotifyPacer(window time.Duration, notify func(base.Set)) *notifyPacer {
+	if window <= 0 {
+		return nil
+	}
+	return &notifyPacer{
+		notify:    notify,
+		maxWindow: window,
+		now:       time.Now,
+		fixed:     true,
+		window:    window,
+	}
+}
+
+// Notify notifies changedChannels immediately while notifications aren't being coalesced, otherwise when the current
+// window ends.
+func (p *notifyPacer) Notify(changedChannels base.Set) {
+	if len(changedChannels) == 0 {
+		return
+	}
+
+	p.lock.Lock()
+	if p.stopped {
+		p.lock.Unlock()
+		return
+	}
+	p._updateWindow()
+	p.rateCount++
+	if p.window == 0 && p.flushTimer == nil {
+		p.lock.Unlock()
+		p.notify(changedChannels)
+		return
+	}
+	if p.pending == nil {
+		p.pending = make(base.Set, len(changedChannels))
+	}
+	for channelName := range changedChannels {
+		p.pending.Add(channelName)
+	}
+	if p.flushTimer == nil {
+		p.flushTimer = time.AfterFunc(p.window, p.flush)
+	}
+	p.lock.Unlock()
+}
+
+// flush notifies the channels that changed during the window that just ended.
+func (p *notifyPacer) flush() {
+	p.lock.Lock()
+	changedChannels := p.pending
+	p.pending = nil
+	if p.flushTimer != nil {
+		p.flushTimer.Stop()
+		p.flushTimer = nil
+	}
+	stopped := p.stopped
+	p.lock.Unlock()
+
+	if !stopped && len(changedChannels) > 0 {
+		p.notify(changedChannels)
+	}
+}
+
+// _updateWindow widens or narrows the window at the end of each rate interval.  Requires p.lock.
+func (p *notifyPacer) _updateWindow() {
+	if p.fixed {
+		return
+	}
+	now := p.now()
+	if p.rateStart.IsZero() {
+		p.rateStart = now
+		return
+	}
+	elapsed := now.Sub(p.rateStart)
+	if elapsed < notifyPacingRateInterval {
+		return
+	}
+
+	window := p.window
+	if float64(p.rateCount)/elapsed.Seconds() > float64(p.threshold) {
+		window *= 2
+		if window < notifyPacingMinWindow {
+			window = notifyPacingMinWindow
+		}
+		if window > p.maxWindow {
+			window = p.maxWindow
+		}
+	} else {
+		// Halve for every interval elapsed, so a quiet period doesn't leave the window wide
+		for intervals := elapsed / notifyPacingRateInterval; intervals > 0 && window > 0; intervals-- {
+			window /= 2
+			if window < notifyPacingMinWindow {
+				window = 0
+			}
+		}
+	}
+	p.rateStart = now
+	p.rateCount = 0
+
+	if window != p.window {
+		base.Debugf(base.KeyChanges, "Change notification coalescing window changed from %v to %v", p.window, window)
+		p.window = window
+		if p.windowStat != nil {
+			p.windowStat.Set(int64(window / time.Millisecond))
+		}
+	}
+}
+
+// Window returns the effective coalescing window.
+func (p *notifyPacer) Window() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.window
+}
+
+// drain notifies pending channels, and stops coalescing, so that subsequent notifications are notified immediately.
+func (p *notifyPacer) drain() {
+	p.lock.Lock()
+	p.fixed = true
+	p.window = 0
+	p.lock.Unlock()
+	p.flush()
+}
+
+// stop drops pending notifications, and any received subsequently.
+func (p *notifyPacer) stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stopped = true
+	if p.flushTimer != nil {
+		p.flushTimer.Stop()
+		p.flushTimer = nil
+	}
+	p.pending = nil
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Simulates a notification storm, and validates that the coalescing window widens up to the max window while it lasts,
+// and narrows back to zero once it ends.
+func TestNotifyPacerStorm(t *testing.T) {
+
+	var notifiedLock sync.Mutex
+	var notifyCount int
+	notified := make(base.Set)
+	notify := func(changedChannels base.Set) {
+		notifiedLock.Lock()
+		defer notifiedLock.Unlock()
+		notifyCount++
+		for channelName := range changedChannels {
+			notified.Add(channelName)
+		}
+	}
+	// Returns the number of notifications, and of distinct channels notified
+	getNotified := func() (int, int) {
+		notifiedLock.Lock()
+		defer notifiedLock.Unlock()
+		return notifyCount, len(notified)
+	}
+
+	windowStat := base.NewSyncGatewayStats().NewDBStats("", false, false, false).Database().NotifyPacingWindow
+	assert.Nil(t, newNotifyPacer(NotifyPacingOptions{}, notify, windowStat))
+	pacer := newNotifyPacer(NotifyPacingOptions{RateThreshold: 100, MaxWindowMs: 40}, notify, windowStat)
+	require.NotNil(t, pacer)
+	defer pacer.stop()
+
+	now := time.Now()
+	pacer.now = func() time.Time { return now }
+	sendNotifications := func(count int, interval time.Duration) {
+		for i := 0; i < count; i++ {
+			pacer.Notify(base.SetOf(fmt.Sprintf("ch%d", i%10)))
+			now = now.Add(interval)
+		}
+	}
+
+	// Under the threshold, notifications are sent immediately
+	sendNotifications(20, 10*time.Millisecond)
+	count, _ := getNotified()
+	assert.Equal(t, 20, count)
+	assert.Equal(t, time.Duration(0), pacer.Window())
+
+	// 1000/s - the window doubles every rate interval up to the max window.  The rate is evaluated by the first
+	// notification of the following interval, so the first batch isn't coalesced.
+	var windows []time.Duration
+	for i := 0; i < 6; i++ {
+		sendNotifications(100, time.Millisecond)
+		windows = append(windows, pacer.Window())
+	}
+	assert.Equal(t, []time.Duration{0, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond}, windows)
+	assert.Equal(t, int64(40), windowStat.Value())
+
+	// Coalesced notifications are sent when their window ends
+	pendingSent := func() bool {
+		pacer.lock.Lock()
+		defer pacer.lock.Unlock()
+		return pacer.flushTimer == nil && len(pacer.pending) == 0
+	}
+	require.Eventually(t, pendingSent, time.Second, 10*time.Millisecond)
+	count, channelCount := getNotified()
+	assert.Equal(t, 10, channelCount)
+	assert.Less(t, count, 20+600)
+
+	// 20/s - the window halves every rate interval, back to zero
+	windows = nil
+	for i := 0; i < 5; i++ {
+		sendNotifications(2, 50*time.Millisecond)
+		windows = append(windows, pacer.Window())
+	}
+	assert.Equal(t, []time.Duration{40 * time.Millisecond, 20 * time.Millisecond, 10 * time.Millisecond, 5 * time.Millisecond, 0}, windows)
+	assert.Equal(t, int64(0), windowStat.Value())
+
+	// Once any pending notifications are sent, notifications are immediate again
+	require.Eventually(t, pendingSent, time.Second, 10*time.Millisecond)
+	countBefore, _ := getNotified()
+	pacer.Notify(base.SetOf("ch0"))
+	count, _ = getNotified()
+	assert.Equal(t, countBefore+1, count)
+
+	// A quiet period narrows a wide window in one step
+	sendNotifications(500, time.Millisecond)
+	require.NotZero(t, pacer.Window())
+	now = now.Add(time.Minute)
+	pacer.Notify(base.SetOf("ch0"))
+	assert.Equal(t, time.Duration(0), pacer.Window())
+}
+
+// Validates that a fixed window pacer batches notifications regardless of their rate, and notifies immediately once
+// drained.
+func TestNotifyPacerFixedWindow(t *testing.T) {
+
+	var notifiedLock sync.Mutex
+	var notifications []base.Set
+	notify := func(changedChannels base.Set) {
+		notifiedLock.Lock()
+		defer notifiedLock.Unlock()
+		notifications = append(notifications, changedChannels)
+	}
+	getNotifications := func() []base.Set {
+		notifiedLock.Lock()
+		defer notifiedLock.Unlock()
+		return append([]base.Set(nil), notifications...)
+	}
+
+	assert.Nil(t, newFixedNotifyPacer(0, notify))
+	pacer := newFixedNotifyPacer(time.Hour, notify)
+	require.NotNil(t, pacer)
+	defer pacer.stop()
+
+	now := time.Now()
+	pacer.now = func() time.Time { return now }
+	pacer.Notify(base.SetOf("ABC"))
+	now = now.Add(time.Minute)
+	pacer.Notify(base.SetOf("DEF"))
+	assert.Len(t, getNotifications(), 0)
+	assert.Equal(t, time.Hour, pacer.Window())
+
+	pacer.flush()
+	require.Len(t, getNotifications(), 1)
+	assert.True(t, getNotifications()[0].Equals(base.SetOf("ABC", "DEF")))
+
+	pacer.Notify(base.SetOf("GHI"))
+	assert.Len(t, getNotifications(), 1)
+	pacer.drain()
+	require.Len(t, getNotifications(), 2)
+	assert.True(t, getNotifications()[1].Equals(base.SetOf("GHI")))
+
+	pacer.Notify(base.SetOf("JKL"))
+	require.Len(t, getNotifications(), 3)
+	assert.True(t, getNotifications()[2].Equals(base.SetOf("JKL")))
+}
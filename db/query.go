@@ -38,6 +38,8 @@ const (
 	QueryTypeTombstones   = "tombstones"
 	QueryTypeResync       = "resync"
 	QueryTypeAllDocs      = "allDocs"
+	QueryTypeMetadataKeys = "metadataKeys"
+	QueryTypeRevBodyKeys  = "revBodyKeys"
 )
 
 type SGQuery struct {
@@ -167,6 +169,38 @@ var QuerySessions = SGQuery{
 		base.KeyspaceQueryToken, base.KeyspaceQueryToken, base.KeyspaceQueryToken, SyncDocWildcard, base.KeyspaceQueryToken, `\\_sync:session:%`),
 	adhoc: false,
 }
+var QueryMetadataKeys = SGQuery{
+	name: QueryTypeMetadataKeys,
+	statement: fmt.Sprintf(
+		"SELECT META(`%s`).id "+
+			"FROM `%s` "+
+			"USE INDEX($idx) "+
+			"WHERE META(`%s`).id LIKE '%s' "+
+			"AND META(`%s`).id LIKE $prefix "+
+			"AND META(`%s`).id >= $startkey "+
+			"ORDER BY META(`%s`).id",
+		base.KeyspaceQueryToken, base.KeyspaceQueryToken, base.KeyspaceQueryToken, SyncDocWildcard,
+		base.KeyspaceQueryToken, base.KeyspaceQueryToken, base.KeyspaceQueryToken),
+	adhoc: false,
+}
+
+// QueryRevBodyKeys is using the star channel's index, which is indexed by sequence.  The revision tree isn't covered by
+// the index, so the documents are fetched by the query service rather than one at a time by Sync Gateway.
+var QueryRevBodyKeys = SGQuery{
+	name: QueryTypeRevBodyKeys,
+	statement: fmt.Sprintf(
+		"SELECT $sync.sequence AS seq, "+
+			"OBJECT_VALUES($sync.history.bodyKeyMap) AS bodyKeys "+
+			"FROM `%s` "+
+			"USE INDEX ($idx) "+
+			"WHERE $sync.sequence >= $startSeq "+
+			"AND META().id NOT LIKE '%s' "+
+			"AND $sync.history.bodyKeyMap IS VALUED "+
+			"ORDER BY $sync.sequence",
+		base.KeyspaceQueryToken, SyncDocWildcard),
+	adhoc: false,
+}
+
 var QueryTombstones = SGQuery{
 	name: QueryTypeTombstones,
 	statement: fmt.Sprintf(
@@ -229,27 +263,34 @@ const (
 	QueryParamStartKey    = "startkey"
 	QueryParamEndKey      = "endkey"
 	QueryParamLimit       = "limit"
+	QueryParamPrefix      = "prefix"
 
 	// Variables in the select clause can't be parameterized, require additional handling
 	QuerySelectUserName = "$$selectUserName"
 )
 
-// N1QlQueryWithStats is a wrapper for gocbBucket.Query that performs additional diagnostic processing (expvars, slow query logging)
+// N1QlQueryWithStats is a wrapper for N1QLStore.Query that performs additional diagnostic processing (expvars, slow query logging)
 func (context *DatabaseContext) N1QLQueryWithStats(queryName string, statement string, params map[string]interface{}, consistency base.ConsistencyMode, adhoc bool) (results sgbucket.QueryResultIterator, err error) {
+	return context.n1qlQueryWithStats(queryName, statement, params, consistency, adhoc, "")
+}
+
+// n1qlQueryWithStats performs N1QLQueryWithStats, identifying the query to the query service by clientContextID when
+// set.
+func (context *DatabaseContext) n1qlQueryWithStats(queryName string, statement string, params map[string]interface{}, consistency base.ConsistencyMode, adhoc bool, clientContextID string) (results sgbucket.QueryResultIterator, err error) {
 
 	startTime := time.Now()
 	if threshold := context.Options.SlowQueryWarningThreshold; threshold > 0 {
 		defer base.SlowQueryLog(startTime, threshold, "N1QL Query(%q)", queryName)
 	}
 
-	gocbBucket, ok := base.AsGoCBBucket(context.Bucket)
+	n1qlStore, ok := base.AsN1QLStore(context.Bucket)
 	if !ok {
 		return nil, errors.New("Cannot perform N1QL query on non-Couchbase bucket.")
 	}
 
 	queryStat := context.DbStats.Query(queryName)
 
-	results, err = gocbBucket.Query(statement, params, consistency, adhoc)
+	results, err = n1qlStore.QueryWithClientContextID(statement, params, consistency, adhoc, clientContextID)
 	if err != nil {
 		queryStat.QueryErrorCount.Add(1)
 	}
@@ -346,6 +387,11 @@ func (context *DatabaseContext) buildRoleAccessQuery(username string) string {
 
 // Query to compute the set of documents assigned to the specified channel within the sequence range
 func (context *DatabaseContext) QueryChannels(channelName string, startSeq uint64, endSeq uint64, limit int, activeOnly bool) (sgbucket.QueryResultIterator, error) {
+	return context.queryChannels(channelName, startSeq, endSeq, limit, activeOnly, "")
+}
+
+// queryChannels performs QueryChannels, identifying a N1QL query to the query service by clientContextID when set.
+func (context *DatabaseContext) queryChannels(channelName string, startSeq uint64, endSeq uint64, limit int, activeOnly bool, clientContextID string) (sgbucket.QueryResultIterator, error) {
 
 	if context.Options.UseViews {
 		opts := changesViewOptions(channelName, startSeq, endSeq, limit)
@@ -358,7 +404,7 @@ func (context *DatabaseContext) QueryChannels(channelName string, startSeq uint6
 	// QueryChannels result schema (removal handling isn't needed for the star channel).
 	channelQueryStatement, params := context.buildChannelsQuery(channelName, startSeq, endSeq, limit, activeOnly)
 
-	return context.N1QLQueryWithStats(QueryChannels.name, channelQueryStatement, params, base.RequestPlus, QueryChannels.adhoc)
+	return context.n1qlQueryWithStats(QueryChannels.name, channelQueryStatement, params, base.RequestPlus, QueryChannels.adhoc, clientContextID)
 }
 
 // Query to retrieve keys for the specified sequences.  View query uses star channel, N1QL query uses IndexAllDocs
@@ -475,6 +521,76 @@ func (context *DatabaseContext) QuerySessions(userName string) (sgbucket.QueryRe
 	return context.N1QLQueryWithStats(QueryTypeSessions, queryStatement, params, base.RequestPlus, QuerySessions.adhoc)
 }
 
+// QueryMetadataKeys returns the ids of Sync Gateway metadata documents with the given key prefix, ordered by id and
+// starting at startKey (inclusive).  When using views, requires the metadata design doc (see installMetadataViews).
+func (context *DatabaseContext) QueryMetadataKeys(prefix string, startKey string, limit int) (sgbucket.QueryResultIterator, error) {
+
+	if startKey == "" {
+		startKey = prefix
+	}
+
+	// View Query
+	if context.Options.UseViews {
+		opts := map[string]interface{}{"stale": false}
+		opts[QueryParamStartKey] = startKey
+		opts[QueryParamEndKey] = prefix + "\uefff"
+		if limit > 0 {
+			opts[QueryParamLimit] = limit
+		}
+		return context.ViewQueryWithStats(DesignDocSyncMetadata(), ViewMetadataKeys, opts)
+	}
+
+	queryStatement := replaceIndexTokensQuery(QueryMetadataKeys.statement, sgIndexes[IndexSyncDocs], context.UseXattrs())
+	if limit > 0 {
+		queryStatement = fmt.Sprintf("%s LIMIT %d", queryStatement, limit)
+	}
+
+	params := make(map[string]interface{}, 2)
+	params[QueryParamPrefix] = prefix + "%"
+	params[QueryParamStartKey] = startKey
+
+	// N1QL Query
+	return context.N1QLQueryWithStats(QueryTypeMetadataKeys, queryStatement, params, base.RequestPlus, QueryMetadataKeys.adhoc)
+}
+
+type RevBodyKeysViewQueryRow struct {
+	Key   uint64
+	Value []string
+}
+
+type RevBodyKeysIndexQueryRow struct {
+	Sequence uint64   `json:"seq"`
+	BodyKeys []string `json:"bodyKeys"`
+}
+
+// QueryRevBodyKeys returns the keys of the external revision bodies referenced by the revision trees of documents,
+// ordered by sequence and starting at startSeq (inclusive).  Documents that don't reference any external revision
+// bodies aren't returned.  When using views, requires the metadata design doc (see installMetadataViews).
+func (context *DatabaseContext) QueryRevBodyKeys(startSeq uint64, limit int) (sgbucket.QueryResultIterator, error) {
+
+	// View Query
+	if context.Options.UseViews {
+		opts := map[string]interface{}{"stale": false}
+		opts[QueryParamStartKey] = startSeq
+		if limit > 0 {
+			opts[QueryParamLimit] = limit
+		}
+		return context.ViewQueryWithStats(DesignDocSyncMetadata(), ViewRevBodyKeys, opts)
+	}
+
+	queryStatement := replaceSyncTokensQuery(QueryRevBodyKeys.statement, context.UseXattrs())
+	queryStatement = replaceIndexTokensQuery(queryStatement, sgIndexes[IndexAllDocs], context.UseXattrs())
+	if limit > 0 {
+		queryStatement = fmt.Sprintf("%s LIMIT %d", queryStatement, limit)
+	}
+
+	params := make(map[string]interface{}, 1)
+	params[QueryParamStartSeq] = startSeq
+
+	// N1QL Query
+	return context.N1QLQueryWithStats(QueryTypeRevBodyKeys, queryStatement, params, base.RequestPlus, QueryRevBodyKeys.adhoc)
+}
+
 type AllDocsViewQueryRow struct {
 	Key   string
 	Value struct {
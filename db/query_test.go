@@ -11,9 +11,11 @@ licenses/APL2.txt.
 package db
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"testing"
+	"time"
 
 	sgbucket "github.com/couchbase/sg-bucket"
 	"github.com/couchbase/sync_gateway/base"
@@ -696,50 +698,84 @@ func TestQueryChannelsActiveOnlyWithLimit(t *testing.T) {
 	// 20 Deleted documents (10 deleted + 10 branched|deleted)
 
 	// Get changes from channel "ABC" with limit and activeOnly true
-	entries, err := db.getChangesInChannelFromQuery("ABC", startSeq, endSeq, 25, true)
+	entries, err := db.getChangesInChannelFromQuery(context.Background(), "ABC", startSeq, endSeq, 25, true)
 	require.NoError(t, err, "Couldn't query active docs from channel ABC with limit")
 	require.Len(t, entries, 25)
 	checkFlags(entries)
 
 	// Get changes from channel "*" with limit and activeOnly true
-	entries, err = db.getChangesInChannelFromQuery("*", startSeq, endSeq, 25, true)
+	entries, err = db.getChangesInChannelFromQuery(context.Background(), "*", startSeq, endSeq, 25, true)
 	require.NoError(t, err, "Couldn't query active docs from channel * with limit")
 	require.Len(t, entries, 25)
 	checkFlags(entries)
 
 	// Get changes from channel "ABC" without limit and activeOnly true
-	entries, err = db.getChangesInChannelFromQuery("ABC", startSeq, endSeq, 0, true)
+	entries, err = db.getChangesInChannelFromQuery(context.Background(), "ABC", startSeq, endSeq, 0, true)
 	require.NoError(t, err, "Couldn't query active docs from channel ABC with limit")
 	require.Len(t, entries, 30)
 	checkFlags(entries)
 
 	// Get changes from channel "*" without limit and activeOnly true
-	entries, err = db.getChangesInChannelFromQuery("*", startSeq, endSeq, 0, true)
+	entries, err = db.getChangesInChannelFromQuery(context.Background(), "*", startSeq, endSeq, 0, true)
 	require.NoError(t, err, "Couldn't query active docs from channel * with limit")
 	require.Len(t, entries, 30)
 	checkFlags(entries)
 
 	// Get changes from channel "ABC" with limit and activeOnly false
-	entries, err = db.getChangesInChannelFromQuery("ABC", startSeq, endSeq, 45, false)
+	entries, err = db.getChangesInChannelFromQuery(context.Background(), "ABC", startSeq, endSeq, 45, false)
 	require.NoError(t, err, "Couldn't query active docs from channel ABC with limit")
 	require.Len(t, entries, 45)
 	checkFlags(entries)
 
 	// Get changes from channel "*" with limit and activeOnly false
-	entries, err = db.getChangesInChannelFromQuery("*", startSeq, endSeq, 45, false)
+	entries, err = db.getChangesInChannelFromQuery(context.Background(), "*", startSeq, endSeq, 45, false)
 	require.NoError(t, err, "Couldn't query active docs from channel * with limit")
 	require.Len(t, entries, 45)
 	checkFlags(entries)
 
 	// Get changes from channel "ABC" without limit and activeOnly false
-	entries, err = db.getChangesInChannelFromQuery("ABC", startSeq, endSeq, 0, false)
+	entries, err = db.getChangesInChannelFromQuery(context.Background(), "ABC", startSeq, endSeq, 0, false)
 	require.NoError(t, err, "Couldn't query active docs from channel ABC with limit")
 	require.Len(t, entries, 50)
 	checkFlags(entries)
 
 	// Get changes from channel "*" without limit and activeOnly true
-	entries, err = db.getChangesInChannelFromQuery("*", startSeq, endSeq, 0, false)
+	entries, err = db.getChangesInChannelFromQuery(context.Background(), "*", startSeq, endSeq, 0, false)
 	require.NoError(t, err, "Couldn't query active docs from channel * with limit")
 	require.Len(t, entries, 50)
 	checkFlags(entries)
 }
+
+// Validates that slow channel backfill queries are logged with their client context ID, attributing them to the
+// database, channel and request, and counted.
+func TestSlowChannelQueryAttribution(t *testing.T) {
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, _, err := db.Put("doc1", Body{"channels": []string{"ABC"}})
+	require.NoError(t, err)
+
+	defer func(redactUserData bool) { base.RedactUserData = redactUserData }(base.RedactUserData)
+	base.RedactUserData = true
+	ctx := context.WithValue(context.Background(), base.LogContextKey{}, base.LogContext{CorrelationID: "#123"})
+	clientContextID := db.channelQueryClientContextID(ctx, "ABC")
+	assert.Equal(t, "sgw:db:"+base.Sha1HashString("ABC", "")+":#123", clientContextID)
+
+	// Without a threshold, queries aren't logged or counted
+	_, err = db.getChangesInChannelFromQuery(ctx, "ABC", 0, 0, 0, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), db.DbStats.Cache().ChannelCacheSlowBackfillCount.Value())
+
+	db.Options.SlowQueryWarningThreshold = time.Nanosecond
+	base.AssertLogContains(t, "ClientContextID: "+clientContextID, func() {
+		entries, err := db.getChangesInChannelFromQuery(ctx, "ABC", 0, 0, 0, false)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+	})
+	assert.Equal(t, int64(1), db.DbStats.Cache().ChannelCacheSlowBackfillCount.Value())
+
+	// The channel is only identified in the clear when user data isn't redacted
+	base.RedactUserData = false
+	assert.Equal(t, "sgw:db:ABC:", db.channelQueryClientContextID(context.Background(), "ABC"))
+}
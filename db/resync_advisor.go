@@ -0,0 +1,211 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Defaults for the resync advisor, when enabled.  A threshold of zero disables the corresponding signal.
+const (
+	DefaultResyncAdvisorWindow                      = time.Hour
+	DefaultResyncAdvisorAbandonedSeqsThreshold      = 100
+	DefaultResyncAdvisorChannelDivergenceThreshold  = 10
+	DefaultResyncAdvisorMigrateCasMismatchThreshold = 100
+)
+
+// resyncAdvisorWindowBuckets is the number of buckets signal counts are aggregated into over the window.  Counts
+// expire a bucket at a time, so the window is accurate to within window/resyncAdvisorWindowBuckets.
+const resyncAdvisorWindowBuckets = 10
+
+// ResyncAdvisorOptions configures a database's resync advisor.
+type ResyncAdvisorOptions struct {
+	Window                      time.Duration // Sliding window over which signals are counted
+	AbandonedSeqsThreshold      int64         // Skipped sequences abandoned by the change cache
+	ChannelDivergenceThreshold  int64         // Sampled revisions whose channels differ from the current sync function's
+	MigrateCasMismatchThreshold int64         // CAS mismatches when migrating legacy metadata to xattrs
+}
+
+// resyncSignal identifies a signal that may indicate the need for a resync.
+type resyncSignal int
+
+const (
+	resyncSignalAbandonedSeqs resyncSignal = iota
+	resyncSignalChannelDivergence
+	resyncSignalMigrateCasMismatch
+	numResyncSignals
+)
+
+var resyncSignalNames = [numResyncSignals]string{"abandoned_seqs", "channel_divergence", "migrate_cas_mismatch"}
+
+// ResyncAdvice is the current state of a database's resync advisor.
+type ResyncAdvice struct {
+	NeedsResync bool             `json:"needs_resync"`
+	FlaggedAt   *time.Time       `json:"flagged_at,omitempty"` // Time needs_resync was set
+	Counts      map[string]int64 `json:"counts"`               // Signal counts within the window
+	Thresholds  map[string]int64 `json:"thresholds"`           // Signal thresholds - zero when disabled
+	WindowSecs  int64            `json:"window_secs"`
+}
+
+// resyncAdvisor counts signals that the database's documents need resyncing over a sliding window, and flags the
+// database as needing a resync when any of them exceeds its threshold.  It's advisory only - a resync is never
+// started automatically.  The flag is cleared by a completed resync or by acknowledgement, and a warning is logged
+// only when it's set, so signals arriving while flagged don't repeat it.
+type resyncAdvisor struct {
+	dbName      string
+	window      time.Duration
+	thresholds  [numResyncSignals]int64
+	counts      [numResyncSignals]windowCount
+	needsResync bool
+	flaggedAt   time.Time
+	stat        *base.SgwIntStat // Set to 1 while flagged
+	lock        sync.Mutex
+}
+
+func newResyncAdvisor(dbName string, options ResyncAdvisorOptions, stat *base.SgwIntStat) *resyncAdvisor {
+	a := &resyncAdvisor{
+		dbName: dbName,
+		window: options.Window,
+		stat:   stat,
+	}
+	if a.window <= 0 {
+		a.window = DefaultResyncAdvisorWindow
+	}
+	a.thresholds[resyncSignalAbandonedSeqs] = options.AbandonedSeqsThreshold
+	a.thresholds[resyncSignalChannelDivergence] = options.ChannelDivergenceThreshold
+	a.thresholds[resyncSignalMigrateCasMismatch] = options.MigrateCasMismatchThreshold
+	return a
+}
+
+// record adds count occurrences of signal.  No-op when the advisor isn't enabled.
+func (a *resyncAdvisor) record(signal resyncSignal, count int64) {
+	if a == nil || count <= 0 {
+		return
+	}
+	a.recordAt(signal, count, time.Now())
+}
+
+func (a *resyncAdvisor) recordAt(signal resyncSignal, count int64, now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.counts[signal].add(now, count, a.window)
+	if a.needsResync || a.thresholds[signal] <= 0 || a.counts[signal].total(now, a.window) < a.thresholds[signal] {
+		return
+	}
+
+	a.needsResync = true
+	a.flaggedAt = now
+	a.stat.Set(1)
+	contributing := make([]string, 0, numResyncSignals)
+	for s := resyncSignal(0); s < numResyncSignals; s++ {
+		contributing = append(contributing, fmt.Sprintf("%s: %d (threshold %d)", resyncSignalNames[s], a.counts[s].total(now, a.window), a.thresholds[s]))
+	}
+	base.Warnf("Database %s may need a resync - %s exceeded its threshold within the last %v [%s].  Run _resync with the "+
+		"database offline, or acknowledge with DELETE /%s/_resync_advice", base.MD(a.dbName), resyncSignalNames[signal],
+		a.window, strings.Join(contributing, ", "), base.MD(a.dbName))
+}
+
+// advice returns the advisor's current state.
+func (a *resyncAdvisor) advice() ResyncAdvice {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := time.Now()
+	advice := ResyncAdvice{
+		NeedsResync: a.needsResync,
+		Counts:      make(map[string]int64, numResyncSignals),
+		Thresholds:  make(map[string]int64, numResyncSignals),
+		WindowSecs:  int64(a.window / time.Second),
+	}
+	if a.needsResync {
+		flaggedAt := a.flaggedAt
+		advice.FlaggedAt = &flaggedAt
+	}
+	for s := resyncSignal(0); s < numResyncSignals; s++ {
+		advice.Counts[resyncSignalNames[s]] = a.counts[s].total(now, a.window)
+		advice.Thresholds[resyncSignalNames[s]] = a.thresholds[s]
+	}
+	return advice
+}
+
+// clear resets the flag and the signal counts, so that only signals arriving afterwards can set it again.
+func (a *resyncAdvisor) clear(reason string) {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.needsResync {
+		base.Infof(base.KeyAll, "Cleared resync advice for database %s - %s", base.MD(a.dbName), reason)
+	}
+	a.needsResync = false
+	a.flaggedAt = time.Time{}
+	a.counts = [numResyncSignals]windowCount{}
+	a.stat.Set(0)
+}
+
+// windowCount is a count of events over a sliding window, aggregated into time buckets.
+type windowCount struct {
+	buckets []windowBucket // In ascending start order
+}
+
+type windowBucket struct {
+	start time.Time
+	count int64
+}
+
+func (w *windowCount) add(now time.Time, count int64, window time.Duration) {
+	w.expire(now, window)
+	if n := len(w.buckets); n > 0 && now.Sub(w.buckets[n-1].start) < window/resyncAdvisorWindowBuckets {
+		w.buckets[n-1].count += count
+		return
+	}
+	w.buckets = append(w.buckets, windowBucket{start: now, count: count})
+}
+
+func (w *windowCount) total(now time.Time, window time.Duration) (total int64) {
+	w.expire(now, window)
+	for _, bucket := range w.buckets {
+		total += bucket.count
+	}
+	return total
+}
+
+// expire removes the buckets that started before the window.
+func (w *windowCount) expire(now time.Time, window time.Duration) {
+	i := 0
+	for i < len(w.buckets) && now.Sub(w.buckets[i].start) > window {
+		i++
+	}
+	w.buckets = w.buckets[i:]
+}
+
+// ResyncAdvice returns the state of the database's resync advisor, or false if it isn't enabled.
+func (context *DatabaseContext) ResyncAdvice() (ResyncAdvice, bool) {
+	if context.resyncAdvisor == nil {
+		return ResyncAdvice{}, false
+	}
+	return context.resyncAdvisor.advice(), true
+}
+
+// AcknowledgeResyncAdvice clears the resync advisor's flag and signal counts.  Returns false if the advisor isn't
+// enabled.
+func (context *DatabaseContext) AcknowledgeResyncAdvice() bool {
+	if context.resyncAdvisor == nil {
+		return false
+	}
+	context.resyncAdvisor.clear("acknowledged")
+	return true
+}
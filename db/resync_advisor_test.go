@@ -0,0 +1,126 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Pushes each signal over its threshold in turn, and validates the flag is set, not repeated, and cleared.
+func TestResyncAdvisorThresholds(t *testing.T) {
+	options := ResyncAdvisorOptions{
+		AbandonedSeqsThreshold:      3,
+		ChannelDivergenceThreshold:  3,
+		MigrateCasMismatchThreshold: 3,
+	}
+	for signal := resyncSignal(0); signal < numResyncSignals; signal++ {
+		t.Run(resyncSignalNames[signal], func(t *testing.T) {
+			stat := &base.SgwIntStat{}
+			advisor := newResyncAdvisor("db", options, stat)
+			now := time.Now()
+
+			advisor.recordAt(signal, 2, now)
+			assert.False(t, advisor.advice().NeedsResync)
+			assert.Equal(t, int64(0), stat.Value())
+
+			advisor.recordAt(signal, 1, now.Add(time.Second))
+			advice := advisor.advice()
+			assert.True(t, advice.NeedsResync)
+			require.NotNil(t, advice.FlaggedAt)
+			assert.Equal(t, int64(3), advice.Counts[resyncSignalNames[signal]])
+			assert.Equal(t, int64(3), advice.Thresholds[resyncSignalNames[signal]])
+			assert.Equal(t, int64(1), stat.Value())
+
+			// Further signals don't re-flag
+			flaggedAt := *advice.FlaggedAt
+			advisor.recordAt(signal, 5, now.Add(2*time.Second))
+			assert.Equal(t, flaggedAt, *advisor.advice().FlaggedAt)
+
+			advisor.clear("test")
+			advice = advisor.advice()
+			assert.False(t, advice.NeedsResync)
+			assert.Nil(t, advice.FlaggedAt)
+			assert.Equal(t, int64(0), advice.Counts[resyncSignalNames[signal]])
+			assert.Equal(t, int64(0), stat.Value())
+		})
+	}
+}
+
+// Validates that signals expire from the window, and that a zero threshold ignores a signal.
+func TestResyncAdvisorWindow(t *testing.T) {
+	window := 10 * time.Minute
+	advisor := newResyncAdvisor("db", ResyncAdvisorOptions{Window: window, AbandonedSeqsThreshold: 2}, &base.SgwIntStat{})
+
+	now := time.Now()
+	advisor.recordAt(resyncSignalAbandonedSeqs, 1, now)
+	now = now.Add(window + window/resyncAdvisorWindowBuckets + time.Second)
+	advisor.recordAt(resyncSignalAbandonedSeqs, 1, now)
+	assert.False(t, advisor.advice().NeedsResync)
+
+	advisor.recordAt(resyncSignalChannelDivergence, 100, now)
+	advisor.recordAt(resyncSignalMigrateCasMismatch, 100, now)
+	assert.False(t, advisor.advice().NeedsResync)
+
+	advisor.recordAt(resyncSignalAbandonedSeqs, 1, now.Add(time.Second))
+	assert.True(t, advisor.advice().NeedsResync)
+}
+
+// Validates that channel divergence detected by the channel verifier flags the database, and that a resync clears it.
+func TestResyncAdvisorChannelDivergence(t *testing.T) {
+
+	db := setupTestDBWithOptions(t, DatabaseContextOptions{
+		ChannelVerificationRate: 1,
+		ResyncAdvisorOptions:    &ResyncAdvisorOptions{ChannelDivergenceThreshold: 3},
+	})
+	defer db.Close()
+
+	_, err := db.UpdateSyncFun(`function(doc) { channel(doc.channels) }`)
+	require.NoError(t, err)
+
+	numDocs := 3
+	revIDs := make(map[string]string, numDocs)
+	for i := 0; i < numDocs; i++ {
+		docID := fmt.Sprintf("doc%d", i)
+		revIDs[docID], _, err = db.Put(docID, Body{"channels": []string{"A"}})
+		require.NoError(t, err)
+	}
+	_, ok := base.WaitForStat(db.DbStats.Cache().ChannelVerificationCount.Value, int64(numDocs))
+	require.True(t, ok)
+	advice, ok := db.ResyncAdvice()
+	require.True(t, ok)
+	assert.False(t, advice.NeedsResync)
+
+	_, err = db.UpdateSyncFun(`function(doc) { channel("B") }`)
+	require.NoError(t, err)
+	for docID, revID := range revIDs {
+		assert.False(t, db.changeCache.channelVerifier.verify(docID, revID))
+	}
+	advice, _ = db.ResyncAdvice()
+	assert.True(t, advice.NeedsResync)
+	assert.Equal(t, int64(1), db.DbStats.Database().NeedsResync.Value())
+
+	require.NoError(t, db.TakeDbOffline(""))
+	waitAndAssertCondition(t, func() bool {
+		return atomic.LoadUint32(&db.State) == DBOffline
+	})
+	_, err = db.UpdateAllDocChannels(false)
+	require.NoError(t, err)
+	advice, _ = db.ResyncAdvice()
+	assert.False(t, advice.NeedsResync)
+	assert.Equal(t, int64(0), db.DbStats.Database().NeedsResync.Value())
+}
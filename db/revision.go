@@ -254,7 +254,7 @@ func (db *Database) backupRevisionJSON(docId, newRevId, oldRevId string, newBody
 	// Without delta sync, store the old rev for in-flight replication purposes
 	if !db.DeltaSyncEnabled() || db.Options.DeltaSyncOptions.RevMaxAgeSeconds == 0 {
 		if len(oldBody) > 0 {
-			_ = db.setOldRevisionJSON(docId, oldRevId, oldBody, db.Options.OldRevExpirySeconds)
+			_ = db.setOldRevisionJSON(docId, oldRevId, oldBody, db.oldRevExpiry())
 		}
 		return
 	}
@@ -276,20 +276,27 @@ func (db *Database) backupRevisionJSON(docId, newRevId, oldRevId string, newBody
 				return
 			}
 		}
-		_ = db.setOldRevisionJSON(docId, newRevId, newBodyWithAtts, db.Options.DeltaSyncOptions.RevMaxAgeSeconds)
+		_ = db.setOldRevisionJSON(docId, newRevId, newBodyWithAtts, base.ExpiryValue(db.Options.DeltaSyncOptions.RevMaxAgeSeconds))
 
 		// Refresh the expiry on the previous revision backup
-		_ = db.refreshPreviousRevisionBackup(docId, oldRevId, oldBody, db.Options.DeltaSyncOptions.RevMaxAgeSeconds)
+		_ = db.refreshPreviousRevisionBackup(docId, oldRevId, oldBody, base.ExpiryValue(db.Options.DeltaSyncOptions.RevMaxAgeSeconds))
 		return
 	}
 
 	// Non-xattr only need to store the previous revision, as all writes come through SG
 	if len(oldBody) > 0 {
-		_ = db.setOldRevisionJSON(docId, oldRevId, oldBody, db.Options.DeltaSyncOptions.RevMaxAgeSeconds)
+		_ = db.setOldRevisionJSON(docId, oldRevId, oldBody, base.ExpiryValue(db.Options.DeltaSyncOptions.RevMaxAgeSeconds))
 	}
 }
 
-func (db *Database) setOldRevisionJSON(docid string, revid string, body []byte, expiry uint32) error {
+// oldRevExpiry returns the expiry of old revision backups that aren't retained for delta sync.
+func (db *Database) oldRevExpiry() base.ExpiryValue {
+	return base.ExpiryValue(db.Options.OldRevExpirySeconds)
+}
+
+// setOldRevisionJSON backs up a revision body with the given expiry.  All old revision backups must be written here, so
+// that the expiry is applied consistently and the backups are counted.
+func (db *Database) setOldRevisionJSON(docid string, revid string, body []byte, expiry base.ExpiryValue) error {
 
 	// Setting the binary flag isn't sufficient to make N1QL ignore the doc - the binary flag is only used by the SDKs.
 	// To ensure it's not available via N1QL, need to prefix the raw bytes with non-JSON data.
@@ -297,9 +304,13 @@ func (db *Database) setOldRevisionJSON(docid string, revid string, body []byte,
 	nonJSONBytes := make([]byte, 1, len(body)+1)
 	nonJSONBytes[0] = nonJSONPrefix
 	nonJSONBytes = append(nonJSONBytes, body...)
-	err := db.Bucket.SetRaw(oldRevisionKey(docid, revid), expiry, base.BinaryDocument(nonJSONBytes))
+	err := db.Bucket.SetRaw(oldRevisionKey(docid, revid), expiry.CbsExpiry(), base.BinaryDocument(nonJSONBytes))
 	if err == nil {
 		base.Debugf(base.KeyCRUD, "Backed up revision body %q/%q (%d bytes, ttl:%d)", base.UD(docid), revid, len(body), expiry)
+		db.DbStats.Database().OldRevBackupCount.Add(1)
+		if expiry == 0 {
+			db.DbStats.Database().OldRevNoExpiryCount.Add(1)
+		}
 	} else {
 		base.Warnf("setOldRevisionJSON failed: doc=%q rev=%q err=%v", base.UD(docid), revid, err)
 	}
@@ -308,9 +319,9 @@ func (db *Database) setOldRevisionJSON(docid string, revid string, body []byte,
 
 // Extends the expiry on a revision backup.  If this fails w/ key not found, will attempt to
 // recreate the revision backup when body is non-empty.
-func (db *Database) refreshPreviousRevisionBackup(docid string, revid string, body []byte, expiry uint32) error {
+func (db *Database) refreshPreviousRevisionBackup(docid string, revid string, body []byte, expiry base.ExpiryValue) error {
 
-	_, err := db.Bucket.Touch(oldRevisionKey(docid, revid), expiry)
+	_, err := db.Bucket.Touch(oldRevisionKey(docid, revid), expiry.CbsExpiry())
 	if base.IsKeyNotFoundError(db.Bucket, err) && len(body) > 0 {
 		return db.setOldRevisionJSON(docid, revid, body, expiry)
 	}
@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"log"
 	"testing"
+	"time"
 
 	"github.com/couchbase/sync_gateway/base"
 	goassert "github.com/couchbaselabs/go.assert"
@@ -146,6 +147,48 @@ func TestBackupOldRevision(t *testing.T) {
 	}
 }
 
+// TestOldRevisionBackupExpiry ensures old revision backups are written with the configured expiry, including expiries
+// of more than 30 days, and that backups with and without an expiry are counted.
+func TestOldRevisionBackupExpiry(t *testing.T) {
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCRUD)()
+
+	oldRevExpiry := uint32(60 * 24 * 60 * 60) // 60 days
+	db := setupTestDBWithOptions(t, DatabaseContextOptions{OldRevExpirySeconds: oldRevExpiry})
+	defer db.Close()
+	dbStats := db.DbStats.Database()
+
+	docID := t.Name()
+	rev1ID, _, err := db.Put(docID, Body{"test": true})
+	require.NoError(t, err)
+	backupCount := dbStats.OldRevBackupCount.Value()
+
+	db.backupRevisionJSON(docID, "2-abc", rev1ID, []byte(`{"test":true,"updated":true}`), []byte(`{"test":true}`), nil)
+	assert.Equal(t, backupCount+1, dbStats.OldRevBackupCount.Value())
+	assert.Equal(t, int64(0), dbStats.OldRevNoExpiryCount.Value())
+
+	require.NoError(t, db.setOldRevisionJSON(docID, "0-legacy", []byte(`{"test":true}`), 0))
+	assert.Equal(t, backupCount+2, dbStats.OldRevBackupCount.Value())
+	assert.Equal(t, int64(1), dbStats.OldRevNoExpiryCount.Value())
+
+	gocbBucket, ok := base.AsGoCBBucket(db.Bucket)
+	if !ok {
+		_, err = db.ScanOldRevExpiry(0)
+		assert.Error(t, err)
+		t.Skip("Reading expiry requires a Couchbase Server bucket")
+	}
+
+	// Expiries of more than 30 days are stored as an absolute time
+	expiry, err := gocbBucket.GetExpiry(oldRevisionKey(docID, rev1ID))
+	require.NoError(t, err)
+	expectedExpiry := time.Now().Add(time.Duration(oldRevExpiry) * time.Second)
+	assert.WithinDuration(t, expectedExpiry, time.Unix(int64(expiry), 0), time.Minute)
+
+	result, err := db.ScanOldRevExpiry(0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.NoExpiry)
+	assert.GreaterOrEqual(t, result.Scanned, 2)
+}
+
 func BenchmarkSpecialProperties(b *testing.B) {
 	noSpecialBody := Body{
 		"asdf": "qwerty", "a": true, "b": true, "c": true,
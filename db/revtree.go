@@ -437,6 +437,12 @@ func (tree RevTree) copy() RevTree {
 //  pruned: number of revisions pruned
 //  prunedTombstoneBodyKeys: set of tombstones with external body storage that were pruned, as map[revid]bodyKey
 func (tree RevTree) pruneRevisions(maxDepth uint32, keepRev string) (pruned int, prunedTombstoneBodyKeys map[string]string) {
+	prunedRevIDs, prunedTombstoneBodyKeys := tree.pruneRevisionIDs(maxDepth, keepRev)
+	return len(prunedRevIDs), prunedTombstoneBodyKeys
+}
+
+// pruneRevisionIDs prunes the tree as described for pruneRevisions, returning the IDs of the pruned revisions.
+func (tree RevTree) pruneRevisionIDs(maxDepth uint32, keepRev string) (prunedRevIDs []string, prunedTombstoneBodyKeys map[string]string) {
 
 	if len(tree) <= int(maxDepth) {
 		return
@@ -448,7 +454,7 @@ func (tree RevTree) pruneRevisions(maxDepth uint32, keepRev string) (pruned int,
 		for revid, node := range tree {
 			if node.depth > maxDepth {
 				delete(tree, revid)
-				pruned++
+				prunedRevIDs = append(prunedRevIDs, revid)
 			}
 		}
 	}
@@ -470,7 +476,7 @@ func (tree RevTree) pruneRevisions(maxDepth uint32, keepRev string) (pruned int,
 			}
 			leafGeneration, _ := ParseRevID(leaf.ID)
 			if leafGeneration < tombstoneGenerationThreshold {
-				pruned += tree.DeleteBranch(leaf)
+				prunedRevIDs = append(prunedRevIDs, tree.deleteBranch(leaf)...)
 				if leaf.BodyKey != "" {
 					if prunedTombstoneBodyKeys == nil {
 						prunedTombstoneBodyKeys = make(map[string]string)
@@ -482,7 +488,7 @@ func (tree RevTree) pruneRevisions(maxDepth uint32, keepRev string) (pruned int,
 	}
 
 	// Snip dangling Parent links:
-	if pruned > 0 {
+	if len(prunedRevIDs) > 0 {
 		for _, node := range tree {
 			if node.Parent != "" {
 				if _, found := tree[node.Parent]; !found {
@@ -492,20 +498,25 @@ func (tree RevTree) pruneRevisions(maxDepth uint32, keepRev string) (pruned int,
 		}
 	}
 
-	return pruned, prunedTombstoneBodyKeys
+	return prunedRevIDs, prunedTombstoneBodyKeys
 
 }
 
 func (tree RevTree) DeleteBranch(node *RevInfo) (pruned int) {
+	return len(tree.deleteBranch(node))
+}
+
+// deleteBranch deletes node and its ancestors, returning the IDs of the deleted revisions.
+func (tree RevTree) deleteBranch(node *RevInfo) (deletedRevIDs []string) {
 
 	revId := node.ID
 
 	for node := tree[revId]; node != nil; node = tree[node.Parent] {
 		delete(tree, node.ID)
-		pruned++
+		deletedRevIDs = append(deletedRevIDs, node.ID)
 	}
 
-	return pruned
+	return deletedRevIDs
 
 }
 
@@ -9,18 +9,20 @@
 package db
 
 import (
-	"encoding/binary"
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbase/sync_gateway/base"
 )
 
 const (
-	// 10 minute expiry for unused sequence docs.
-	UnusedSequenceTTL = 10 * 60
+	// 10 minute expiry for purge marker docs.
+	PurgeMarkerTTL = 10 * 60
 
 	// Maximum time to wait after a reserve before releasing sequences
 	defaultReleaseSequenceWait = 1500 * time.Millisecond
@@ -41,16 +43,43 @@ const (
 var MaxSequenceIncrFrequency = 1000 * time.Millisecond
 
 type sequenceAllocator struct {
-	bucket                  base.Bucket         // Bucket whose counter to use
-	dbStats                 *base.DatabaseStats // For updating per-db sequence allocation stats
-	mutex                   sync.Mutex          // Makes this object thread-safe
-	last                    uint64              // The last sequence allocated by this allocator.
-	max                     uint64              // The range from (last+1) to max represents previously reserved sequences available for use.
-	terminator              chan struct{}       // Terminator for releaseUnusedSequences goroutine
-	reserveNotify           chan struct{}       // Channel for reserve notifications
-	sequenceBatchSize       uint64              // Current sequence allocation batch size
-	lastSequenceReserveTime time.Time           // Time of most recent sequence reserve
-	releaseSequenceWait     time.Duration       // Supports test customization
+	bucket                  base.Bucket                    // Bucket whose counter to use
+	dbStats                 *base.DatabaseStats            // For updating per-db sequence allocation stats
+	mutex                   sync.Mutex                     // Makes this object thread-safe
+	last                    uint64                         // The last sequence allocated by this allocator.
+	max                     uint64                         // The range from (last+1) to max represents previously reserved sequences available for use.  Written atomically, so isAllocated can read it without the mutex
+	terminator              chan struct{}                  // Terminator for releaseUnusedSequences goroutine
+	reserveNotify           chan struct{}                  // Channel for reserve notifications
+	sequenceBatchSize       uint64                         // Current sequence allocation batch size
+	lastSequenceReserveTime time.Time                      // Time of most recent sequence reserve
+	releaseSequenceWait     time.Duration                  // Supports test customization
+	corruption              *SequenceCorruptionError       // Set when the sequence counter is found to be non-numeric
+	corruptionLock          sync.Mutex                     // Coordinates access to corruption
+	onCorruption            func(*SequenceCorruptionError) // Optional callback when sequence counter corruption is detected
+	unusedSeqTTL            time.Duration                  // Expiry of unused sequence notifications - see unusedSequenceTTL.  Zero uses the default cache options
+	onOtherWriter           func()                         // Optional callback when another node is found to have allocated sequences.  Called holding mutex
+}
+
+// maxCorruptValueLength bounds the length of a corrupt sequence counter value included in errors
+const maxCorruptValueLength = 64
+
+// SequenceCorruptionError is returned when the sequence counter document exists but doesn't hold a numeric value,
+// e.g. after being overwritten by a backup restore.
+type SequenceCorruptionError struct {
+	Value []byte // Raw value of the sequence counter document
+}
+
+func (e *SequenceCorruptionError) Error() string {
+	value := string(e.Value)
+	if len(value) > maxCorruptValueLength {
+		value = value[:maxCorruptValueLength] + "..."
+	}
+	return fmt.Sprintf("Sequence counter %s is corrupt - expected a numeric value but found %q.  Use the _repair_sequence admin endpoint to repair it.", base.SyncSeqKey, value)
+}
+
+// parseSequenceCounter parses the raw value of the sequence counter document.
+func parseSequenceCounter(rawValue []byte) (uint64, error) {
+	return strconv.ParseUint(strings.TrimSpace(string(rawValue)), 10, 64)
 }
 
 func newSequenceAllocator(bucket base.Bucket, dbStatsMap *base.DatabaseStats) (*sequenceAllocator, error) {
@@ -202,9 +231,15 @@ func (s *sequenceAllocator) _reserveSequenceRange() error {
 		return err
 	}
 
+	// The counter only moves past the previous reservation without an incr by this node when another node has
+	// allocated sequences
+	if s.max > 0 && max-s.sequenceBatchSize != s.max && s.onOtherWriter != nil {
+		s.onOtherWriter()
+	}
+
 	// Update max and last used sequences.  Last is updated here to account for sequences allocated/used by other
 	// Sync Gateway nodes
-	s.max = max
+	atomic.StoreUint64(&s.max, max)
 	s.last = max - s.sequenceBatchSize
 	s.lastSequenceReserveTime = time.Now()
 
@@ -212,27 +247,73 @@ func (s *sequenceAllocator) _reserveSequenceRange() error {
 	return nil
 }
 
+// isAllocated returns true if the sequence is no higher than this allocator's most recent reservation, so a change for
+// it could have been written by this node.  Doesn't acquire the mutex, so may be called holding the change cache's lock.
+func (s *sequenceAllocator) isAllocated(sequence uint64) bool {
+	return sequence <= atomic.LoadUint64(&s.max)
+}
+
 // Gets the _sync:seq document value.  Retry handling provided by bucket.Get.
 func (s *sequenceAllocator) getSequence() (max uint64, err error) {
-	return base.GetCounter(s.bucket, base.SyncSeqKey)
+	max, err = base.GetCounter(s.bucket, base.SyncSeqKey)
+	if err != nil {
+		return 0, s.checkForCorruption(err)
+	}
+	return max, nil
 }
 
 // Increments the _sync:seq document.  Retry handling provided by bucket.Incr.
 func (s *sequenceAllocator) incrementSequence(numToReserve uint64) (max uint64, err error) {
 	value, err := s.bucket.Incr(base.SyncSeqKey, numToReserve, numToReserve, 0)
-	if err == nil {
-		s.dbStats.SequenceIncrCount.Add(1)
+	if err != nil {
+		return 0, s.checkForCorruption(err)
+	}
+	s.dbStats.SequenceIncrCount.Add(1)
+	return value, nil
+}
+
+// checkForCorruption checks whether an error reading or incrementing the _sync:seq document is due to a non-numeric
+// counter value.  If so, the corruption is recorded and returned as a SequenceCorruptionError - other errors are
+// returned unchanged.
+func (s *sequenceAllocator) checkForCorruption(err error) error {
+	rawValue, _, getErr := s.bucket.GetRaw(base.SyncSeqKey)
+	if getErr != nil {
+		return err
+	}
+	if _, parseErr := parseSequenceCounter(rawValue); parseErr == nil {
+		return err
+	}
+
+	corruptionErr := &SequenceCorruptionError{Value: rawValue}
+	base.Errorf("%v (original error: %v)", corruptionErr, err)
+
+	s.corruptionLock.Lock()
+	s.corruption = corruptionErr
+	s.corruptionLock.Unlock()
+
+	if s.onCorruption != nil {
+		s.onCorruption(corruptionErr)
 	}
-	return value, err
+	return corruptionErr
+}
+
+// getCorruption returns the sequence counter corruption detected by this allocator, if any.
+func (s *sequenceAllocator) getCorruption() *SequenceCorruptionError {
+	s.corruptionLock.Lock()
+	defer s.corruptionLock.Unlock()
+	return s.corruption
+}
+
+// clearCorruption clears recorded corruption, once the sequence counter has been repaired.
+func (s *sequenceAllocator) clearCorruption() {
+	s.corruptionLock.Lock()
+	s.corruption = nil
+	s.corruptionLock.Unlock()
 }
 
 // ReleaseSequence writes an unused sequence document, used to notify sequence buffering that a sequence has been allocated and not used.
-// Sequence is stored as the document body to avoid null doc issues.
 func (s *sequenceAllocator) releaseSequence(sequence uint64) error {
-	key := fmt.Sprintf("%s%d", base.UnusedSeqPrefix, sequence)
-	body := make([]byte, 8)
-	binary.LittleEndian.PutUint64(body, sequence)
-	_, err := s.bucket.AddRaw(key, UnusedSequenceTTL, body)
+	_, err := writeUnusedSequenceDoc(s.bucket, unusedSequenceDoc{fromSequence: sequence, toSequence: sequence}, s.unusedSeqTTL)
 	if err != nil {
 		return err
 	}
@@ -243,17 +324,13 @@ func (s *sequenceAllocator) releaseSequence(sequence uint64) error {
 
 // releaseSequenceRange writes a binary document with the key _sync:unusedSeqs:fromSeq:toSeq.
 // fromSeq and toSeq are inclusive (i.e. both fromSeq and toSeq are unused).
-// From and to seq are stored as the document contents to avoid null doc issues.
 func (s *sequenceAllocator) releaseSequenceRange(fromSequence, toSequence uint64) error {
-	key := fmt.Sprintf("%s%d:%d", base.UnusedSeqRangePrefix, fromSequence, toSequence)
-	body := make([]byte, 16)
-	binary.LittleEndian.PutUint64(body[:8], fromSequence)
-	binary.LittleEndian.PutUint64(body[8:16], toSequence)
-	_, err := s.bucket.AddRaw(key, UnusedSequenceTTL, body)
+	doc := unusedSequenceDoc{fromSequence: fromSequence, toSequence: toSequence, isRange: true}
+	_, err := writeUnusedSequenceDoc(s.bucket, doc, s.unusedSeqTTL)
 	if err != nil {
 		return err
 	}
-	s.dbStats.SequenceReleasedCount.Add(int64(toSequence - fromSequence + 1))
+	s.dbStats.SequenceReleasedCount.Add(int64(doc.count()))
 	base.Debugf(base.KeyCRUD, "Released unused sequences #%d-#%d", fromSequence, toSequence)
 	return nil
 }
@@ -270,3 +347,53 @@ func (s *sequenceAllocator) waitForReleasedSequences(startTime time.Time) (waite
 	time.Sleep(requiredWait)
 	return requiredWait
 }
+
+// sequenceEpochDoc is the body of the _sync:epoch document, recording the database's current sequence epoch and the
+// value of _sync:seq when the epoch was last validated.
+type sequenceEpochDoc struct {
+	Epoch    string `json:"epoch"`
+	Sequence uint64 `json:"seq"`
+}
+
+// newSequenceEpoch returns a random epoch identifier.  Epochs always start with a non-numeric character, so that they
+// can be distinguished from the numeric components of a sequence ID.
+func newSequenceEpoch() string {
+	return sequenceEpochPrefix + base.GenerateRandomID()[:16]
+}
+
+// initSequenceEpoch returns the database's sequence epoch, starting a new epoch when the epoch document is missing
+// (new or flushed bucket) or records a higher sequence than the current value of _sync:seq (bucket restored from
+// backup).  Sequences issued under a previous epoch aren't meaningful to the current bucket.
+func initSequenceEpoch(bucket base.Bucket, currentSequence uint64) (epoch string, err error) {
+	_, err = bucket.Update(base.SyncEpochKey, 0, func(currentValue []byte) ([]byte, *uint32, bool, error) {
+		var epochDoc sequenceEpochDoc
+		if currentValue != nil {
+			if unmarshalErr := base.JSONUnmarshal(currentValue, &epochDoc); unmarshalErr != nil {
+				base.Warnf("Unable to parse sequence epoch document, starting new epoch: %v", unmarshalErr)
+				epochDoc = sequenceEpochDoc{}
+			}
+		}
+
+		if epochDoc.Epoch == "" {
+			epochDoc.Epoch = newSequenceEpoch()
+			base.Infof(base.KeyAll, "Starting new sequence epoch %s", epochDoc.Epoch)
+		} else if epochDoc.Sequence > currentSequence {
+			previousEpoch := epochDoc.Epoch
+			epochDoc.Epoch = newSequenceEpoch()
+			base.Warnf("Sequence regression detected (_sync:seq %d is lower than previously seen sequence %d) - starting new sequence epoch %s, replacing %s",
+				currentSequence, epochDoc.Sequence, epochDoc.Epoch, previousEpoch)
+		} else if epochDoc.Sequence == currentSequence {
+			epoch = epochDoc.Epoch
+			return nil, nil, false, base.ErrUpdateCancel // value unchanged, no need to save
+		}
+
+		epoch = epochDoc.Epoch
+		epochDoc.Sequence = currentSequence
+		bytes, err := base.JSONMarshal(epochDoc)
+		return bytes, nil, false, err
+	})
+	if err == base.ErrUpdateCancel {
+		err = nil
+	}
+	return epoch, err
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	sequenceClockInterval   = 10 * time.Minute // Minimum interval between samples
+	sequenceClockMaxSamples = 10000            // Bounds the number of samples retained - around 70 days at the minimum interval
+)
+
+// sequenceClock records the time at which a sample of sequences were written, to estimate when a client's since
+// sequence was current.  Only covers the sequences cached since the change cache started.
+type sequenceClock struct {
+	samples []sequenceTime // Samples in ascending sequence (and time) order
+	lock    sync.RWMutex   // Coordinates access to samples
+}
+
+type sequenceTime struct {
+	sequence uint64
+	time     time.Time
+}
+
+// record adds a sample for sequence, unless the latest sample is more recent than sequenceClockInterval.  Sequences
+// must be recorded in ascending order.
+func (c *sequenceClock) record(sequence uint64, timeSaved time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if n := len(c.samples); n > 0 && timeSaved.Sub(c.samples[n-1].time) < sequenceClockInterval {
+		return
+	}
+	if len(c.samples) >= sequenceClockMaxSamples {
+		c.samples = c.samples[1:]
+	}
+	c.samples = append(c.samples, sequenceTime{sequence: sequence, time: timeSaved})
+}
+
+// timeOf returns the time of the latest sample at or before sequence, which the sequence was current no earlier than.
+// Returns false when sequence precedes all samples.
+func (c *sequenceClock) timeOf(sequence uint64) (time.Time, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	i := sort.Search(len(c.samples), func(i int) bool {
+		return c.samples[i].sequence > sequence
+	})
+	if i == 0 {
+		return time.Time{}, false
+	}
+	return c.samples[i-1].time, true
+}
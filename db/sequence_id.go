@@ -19,21 +19,26 @@ import (
 	"github.com/couchbase/sync_gateway/base"
 )
 
-// A change sequence as reported externally in a _changes feed.
-// Can support either integer- or vector clock-based sequences
+// A change sequence as reported externally in a _changes feed.  Only integer sequences are supported - vector
+// clock sequences were only used by the channel index (distributed index) mode, which has been removed.
 
 // Most of the time the TriggerSeq is 0, but if a revision is being sent retroactively because
 // the user got access to a channel, the TriggerSeq will be equal to the sequence of the change
 // that gave the user access.
-
-// SequenceID doesn't do any clock hash management - it's expected that hashing has already been done (if required)
-// when the clock is set.
 type SequenceID struct {
 	TriggeredBy uint64 // Int sequence: The sequence # that triggered this (0 if none)
 	LowSeq      uint64 // Int sequence: Lowest contiguous sequence seen on the feed
 	Seq         uint64 // Int sequence: The actual internal sequence
+	Epoch       string // Database sequence epoch the sequence was issued under (empty if none)
 }
 
+// Prefix identifying the epoch component of a sequence ID
+const sequenceEpochPrefix = "e"
+
+// SequenceIDCacheCapacity is the number of parsed sequence IDs cached by a database.  Polling clients repeat the same
+// since value, so only a few recent values need caching.
+const SequenceIDCacheCapacity = 256
+
 var MaxSequenceID = SequenceID{
 	Seq: math.MaxUint64,
 }
@@ -44,7 +49,11 @@ var MaxSequenceID = SequenceID{
 //   LowSeq:TriggeredBy:Seq - when LowSeq is non-zero.
 // When LowSeq is non-zero but TriggeredBy is zero, will appear as LowSeq::Seq.
 // When LowSeq is non-zero but is greater than s.Seq (occurs when sending previously skipped sequences), ignore LowSeq.
+// When Epoch is set, any of the above is prefixed with Epoch: (e.g. Epoch:Seq).
 func (s SequenceID) String() string {
+	if s.Epoch != "" {
+		return s.Epoch + ":" + s.intSeqToString()
+	}
 	return s.intSeqToString()
 }
 
@@ -65,9 +74,19 @@ func (s SequenceID) intSeqToString() string {
 }
 
 // Currently accepts a plain string, but in the future might accept generic JSON objects.
-// Calling this with a JSON string will result in an error.
+// Calling this with a JSON string, or a vector clock sequence, will result in an error.
+// Parsing only depends on the string, so results are cached by it, and never invalidated.  Errors aren't cached.
 func (dbc *DatabaseContext) ParseSequenceID(str string) (s SequenceID, err error) {
-	return parseIntegerSequenceID(str)
+	if dbc.sequenceIDCache == nil || str == "" {
+		return parseIntegerSequenceID(str)
+	}
+	if cached, found := dbc.sequenceIDCache.Get(str); found {
+		return cached.(SequenceID), nil
+	}
+	if s, err = parseIntegerSequenceID(str); err == nil {
+		dbc.sequenceIDCache.Put(str, s)
+	}
+	return s, err
 }
 
 func parseIntegerSequenceID(str string) (s SequenceID, err error) {
@@ -75,6 +94,11 @@ func parseIntegerSequenceID(str string) (s SequenceID, err error) {
 		return SequenceID{}, nil
 	}
 	components := strings.Split(str, ":")
+	if len(components) > 1 && isSequenceEpoch(components[0]) {
+		// Epoch prefix, followed by any of the non-epoch forms
+		s.Epoch = components[0]
+		components = components[1:]
+	}
 	if len(components) == 1 {
 		// Just the internal sequence
 		s.Seq, err = ParseIntSequenceComponent(components[0], false)
@@ -106,6 +130,19 @@ func parseIntegerSequenceID(str string) (s SequenceID, err error) {
 	return
 }
 
+// isSequenceEpoch returns true if the given sequence ID component is a sequence epoch, as generated by newSequenceEpoch.
+func isSequenceEpoch(component string) bool {
+	if len(component) <= len(sequenceEpochPrefix) || !strings.HasPrefix(component, sequenceEpochPrefix) {
+		return false
+	}
+	for _, c := range component[len(sequenceEpochPrefix):] {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
 func ParseIntSequenceComponent(component string, allowEmpty bool) (uint64, error) {
 	value := uint64(0)
 	if allowEmpty && component == "" {
@@ -118,7 +155,7 @@ func ParseIntSequenceComponent(component string, allowEmpty bool) (uint64, error
 
 func (s SequenceID) MarshalJSON() ([]byte, error) {
 
-	if s.TriggeredBy > 0 || s.LowSeq > 0 {
+	if s.TriggeredBy > 0 || s.LowSeq > 0 || s.Epoch != "" {
 		return []byte(fmt.Sprintf("\"%s\"", s.String())), nil
 	} else {
 		return []byte(strconv.FormatUint(s.Seq, 10)), nil
@@ -11,11 +11,13 @@ licenses/APL2.txt.
 package db
 
 import (
+	"strconv"
 	"testing"
 
 	"github.com/couchbase/sync_gateway/base"
 	goassert "github.com/couchbaselabs/go.assert"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseSequenceID(t *testing.T) {
@@ -51,6 +53,61 @@ func TestParseSequenceID(t *testing.T) {
 	goassert.True(t, err != nil)
 	s, err = parseIntegerSequenceID("123:ggg")
 	goassert.True(t, err != nil)
+
+	// Vector clock sequences from the removed channel index mode aren't supported
+	s, err = parseIntegerSequenceID("1-0:5.6")
+	goassert.True(t, err != nil)
+	s, err = parseIntegerSequenceID("0.1.2")
+	goassert.True(t, err != nil)
+}
+
+// Validates that parse results are identical with and without the database's sequence ID cache, on first parse and
+// when served from the cache, and that errors aren't cached.
+func TestParseSequenceIDCache(t *testing.T) {
+	cache, err := base.NewLRUCache(SequenceIDCacheCapacity)
+	require.NoError(t, err)
+	dbc := &DatabaseContext{sequenceIDCache: cache}
+	uncached := &DatabaseContext{}
+
+	inputs := []string{"", "1234", "5678:1234", "123:456:789", "123::789", "e1a2b3c:1234", "e1a2b3c:123::789",
+		"foo", ":1", "10:11:12:13", "e1a2b3c", "1-0:5.6"}
+	for i := 0; i < 2; i++ {
+		for _, input := range inputs {
+			expected, expectedErr := uncached.ParseSequenceID(input)
+			actual, actualErr := dbc.ParseSequenceID(input)
+			assert.Equal(t, expected, actual, "Unexpected result parsing %q", input)
+			assert.Equal(t, expectedErr, actualErr, "Unexpected error parsing %q", input)
+		}
+	}
+	assert.Equal(t, 6, cache.Count())
+
+	// Only the most recent values are retained
+	for i := 0; i < SequenceIDCacheCapacity*2; i++ {
+		_, err := dbc.ParseSequenceID(strconv.Itoa(i))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, SequenceIDCacheCapacity, cache.Count())
+}
+
+func BenchmarkParseSequenceID(b *testing.B) {
+	cache, err := base.NewLRUCache(SequenceIDCacheCapacity)
+	require.NoError(b, err)
+	benchmarks := []struct {
+		name string
+		dbc  *DatabaseContext
+	}{
+		{"Uncached", &DatabaseContext{}},
+		{"Cached", &DatabaseContext{sequenceIDCache: cache}},
+	}
+	for _, bm := range benchmarks {
+		for _, since := range []string{"1234567", "1234500:1234560:1234567", "e1a2b3c:1234500::1234567"} {
+			b.Run(bm.name+"-"+since, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					_, _ = bm.dbc.ParseSequenceID(since)
+				}
+			})
+		}
+	}
 }
 
 func TestMarshalSequenceID(t *testing.T) {
@@ -118,6 +175,87 @@ func TestMarshalTriggeredSequenceID(t *testing.T) {
 	goassert.Equals(t, s2, s)
 }
 
+func TestParseEpochSequenceID(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected SequenceID
+	}{
+		{"e1a2b3c:1234", SequenceID{Epoch: "e1a2b3c", Seq: 1234}},
+		{"e1a2b3c:5678:1234", SequenceID{Epoch: "e1a2b3c", TriggeredBy: 5678, Seq: 1234}},
+		{"e1a2b3c:123:456:789", SequenceID{Epoch: "e1a2b3c", LowSeq: 123, TriggeredBy: 456, Seq: 789}},
+		{"e1a2b3c:123::789", SequenceID{Epoch: "e1a2b3c", LowSeq: 123, Seq: 789}},
+		{"ef:0", SequenceID{Epoch: "ef", Seq: 0}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			s, err := parseIntegerSequenceID(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, s)
+			assert.Equal(t, tc.input, s.String())
+
+			// Round trip through JSON, which always uses the string form for epoch sequences
+			asJson, err := base.JSONMarshal(s)
+			require.NoError(t, err)
+			assert.Equal(t, "\""+tc.input+"\"", string(asJson))
+			var s2 SequenceID
+			require.NoError(t, base.JSONUnmarshal(asJson, &s2))
+			assert.Equal(t, s, s2)
+		})
+	}
+
+	invalid := []string{
+		"e1a2b3c",         // epoch without sequence
+		"e1a2b3c:",        // empty sequence
+		"e:1234",          // empty epoch
+		"eggg:1234",       // non-hex epoch
+		"1a2b3c:1234",     // epoch without prefix
+		"e1a2b3c:e1a2b3c", // epoch as sequence
+		"e1a2b3c:e1a2b3c:1234",
+		"e1a2b3c:10:11:12:13",
+	}
+	for _, input := range invalid {
+		_, err := parseIntegerSequenceID(input)
+		assert.Error(t, err, "Expected error parsing %q", input)
+	}
+
+	// Epoch is ignored when comparing sequences
+	s1, err := parseIntegerSequenceID("e1a2b3c:1234")
+	require.NoError(t, err)
+	s2, err := parseIntegerSequenceID("1234")
+	require.NoError(t, err)
+	assert.True(t, s1.Equals(s2))
+	assert.False(t, s1.Before(s2))
+}
+
+func TestInitSequenceEpoch(t *testing.T) {
+	bucket := base.GetTestBucket(t)
+	defer bucket.Close()
+
+	// New epoch on first init
+	epoch, err := initSequenceEpoch(bucket, 10)
+	require.NoError(t, err)
+	assert.True(t, isSequenceEpoch(epoch), "Unexpected epoch format %q", epoch)
+
+	// Same epoch while sequences don't regress
+	epoch2, err := initSequenceEpoch(bucket, 10)
+	require.NoError(t, err)
+	assert.Equal(t, epoch, epoch2)
+	epoch2, err = initSequenceEpoch(bucket, 20)
+	require.NoError(t, err)
+	assert.Equal(t, epoch, epoch2)
+
+	// Regression to a lower sequence starts a new epoch
+	epoch3, err := initSequenceEpoch(bucket, 15)
+	require.NoError(t, err)
+	assert.NotEqual(t, epoch, epoch3)
+
+	// Removal of the epoch doc (flush) starts a new epoch
+	require.NoError(t, bucket.Delete(base.SyncEpochKey))
+	epoch4, err := initSequenceEpoch(bucket, 15)
+	require.NoError(t, err)
+	assert.NotEqual(t, epoch3, epoch4)
+}
+
 func TestCompareSequenceIDs(t *testing.T) {
 	orderedSeqs := []SequenceID{
 		{Seq: 1234},
@@ -90,23 +90,24 @@ func NewSGNode(uuid string, host string) *SGNode {
 
 // ReplicationConfig is a replication definition as stored in the Sync Gateway config
 type ReplicationConfig struct {
-	ID                     string                    `json:"replication_id"`
-	Remote                 string                    `json:"remote"`
-	Username               string                    `json:"username,omitempty"`
-	Password               string                    `json:"password,omitempty"`
-	Direction              ActiveReplicatorDirection `json:"direction"`
-	ConflictResolutionType ConflictResolverType      `json:"conflict_resolution_type,omitempty"`
-	ConflictResolutionFn   string                    `json:"custom_conflict_resolver,omitempty"`
-	PurgeOnRemoval         bool                      `json:"purge_on_removal,omitempty"`
-	DeltaSyncEnabled       bool                      `json:"enable_delta_sync,omitempty"`
-	MaxBackoff             int                       `json:"max_backoff_time,omitempty"`
-	InitialState           string                    `json:"initial_state,omitempty"`
-	Continuous             bool                      `json:"continuous"`
-	Filter                 string                    `json:"filter,omitempty"`
-	QueryParams            interface{}               `json:"query_params,omitempty"`
-	Cancel                 bool                      `json:"cancel,omitempty"`
-	Adhoc                  bool                      `json:"adhoc,omitempty"`
-	BatchSize              int                       `json:"batch_size,omitempty"`
+	ID                         string                    `json:"replication_id"`
+	Remote                     string                    `json:"remote"`
+	Username                   string                    `json:"username,omitempty"`
+	Password                   string                    `json:"password,omitempty"`
+	Direction                  ActiveReplicatorDirection `json:"direction"`
+	ConflictResolutionType     ConflictResolverType      `json:"conflict_resolution_type,omitempty"`
+	ConflictResolutionFn       string                    `json:"custom_conflict_resolver,omitempty"`
+	PurgeOnRemoval             bool                      `json:"purge_on_removal,omitempty"`
+	DeltaSyncEnabled           bool                      `json:"enable_delta_sync,omitempty"`
+	MaxBackoff                 int                       `json:"max_backoff_time,omitempty"`
+	InitialState               string                    `json:"initial_state,omitempty"`
+	Continuous                 bool                      `json:"continuous"`
+	Filter                     string                    `json:"filter,omitempty"`
+	QueryParams                interface{}               `json:"query_params,omitempty"`
+	Cancel                     bool                      `json:"cancel,omitempty"`
+	Adhoc                      bool                      `json:"adhoc,omitempty"`
+	BatchSize                  int                       `json:"batch_size,omitempty"`
+	RejectInaccessibleChannels bool                      `json:"reject_inaccessible_channels,omitempty"`
 }
 
 func DefaultReplicationConfig() ReplicationConfig {
@@ -132,24 +133,25 @@ type ReplicationCfg struct {
 
 // ReplicationUpsertConfig is used for operations that support upsert of a subset of replication properties.
 type ReplicationUpsertConfig struct {
-	ID                     string      `json:"replication_id"`
-	Remote                 *string     `json:"remote"`
-	Username               *string     `json:"username,omitempty"`
-	Password               *string     `json:"password,omitempty"`
-	Direction              *string     `json:"direction"`
-	ConflictResolutionType *string     `json:"conflict_resolution_type,omitempty"`
-	ConflictResolutionFn   *string     `json:"custom_conflict_resolver,omitempty"`
-	PurgeOnRemoval         *bool       `json:"purge_on_removal,omitempty"`
-	DeltaSyncEnabled       *bool       `json:"enable_delta_sync,omitempty"`
-	MaxBackoff             *int        `json:"max_backoff_time,omitempty"`
-	InitialState           *string     `json:"initial_state,omitempty"`
-	Continuous             *bool       `json:"continuous"`
-	Filter                 *string     `json:"filter,omitempty"`
-	QueryParams            interface{} `json:"query_params,omitempty"`
-	Cancel                 *bool       `json:"cancel,omitempty"`
-	Adhoc                  *bool       `json:"adhoc,omitempty"`
-	BatchSize              *int        `json:"batch_size,omitempty"`
-	SGR1CheckpointID       *string     `json:"sgr1_checkpoint_id,omitempty"`
+	ID                         string      `json:"replication_id"`
+	Remote                     *string     `json:"remote"`
+	Username                   *string     `json:"username,omitempty"`
+	Password                   *string     `json:"password,omitempty"`
+	Direction                  *string     `json:"direction"`
+	ConflictResolutionType     *string     `json:"conflict_resolution_type,omitempty"`
+	ConflictResolutionFn       *string     `json:"custom_conflict_resolver,omitempty"`
+	PurgeOnRemoval             *bool       `json:"purge_on_removal,omitempty"`
+	DeltaSyncEnabled           *bool       `json:"enable_delta_sync,omitempty"`
+	MaxBackoff                 *int        `json:"max_backoff_time,omitempty"`
+	InitialState               *string     `json:"initial_state,omitempty"`
+	Continuous                 *bool       `json:"continuous"`
+	Filter                     *string     `json:"filter,omitempty"`
+	QueryParams                interface{} `json:"query_params,omitempty"`
+	Cancel                     *bool       `json:"cancel,omitempty"`
+	Adhoc                      *bool       `json:"adhoc,omitempty"`
+	BatchSize                  *int        `json:"batch_size,omitempty"`
+	RejectInaccessibleChannels *bool       `json:"reject_inaccessible_channels,omitempty"`
+	SGR1CheckpointID           *string     `json:"sgr1_checkpoint_id,omitempty"`
 }
 
 func (rc *ReplicationConfig) ValidateReplication(fromConfig bool) (err error) {
@@ -297,6 +299,10 @@ func (rc *ReplicationConfig) Upsert(c *ReplicationUpsertConfig) {
 		rc.BatchSize = *c.BatchSize
 	}
 
+	if c.RejectInaccessibleChannels != nil {
+		rc.RejectInaccessibleChannels = *c.RejectInaccessibleChannels
+	}
+
 	if c.QueryParams != nil {
 		// QueryParams can be either []interface{} or map[string]interface{}, so requires type-specific copying
 		// avoid later mutating c.QueryParams
@@ -513,6 +519,7 @@ func (m *sgReplicateManager) NewActiveReplicatorConfig(config *ReplicationCfg) (
 		if err != nil {
 			return nil, err
 		}
+		rc.RejectInaccessibleChannels = config.RejectInaccessibleChannels
 	}
 	rc.Direction = config.Direction
 
@@ -12,11 +12,19 @@ package db
 
 import (
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
 )
 
+// ErrorInaccessibleChannelsFmt is used when a replication's remote user can't access some of its filter channels
+const ErrorInaccessibleChannelsFmt = "remote user doesn't have access to channels %v"
+
 // QueryParams retrieves the channels associated with the byChannels a replication filter
 // from the generic queryParams interface{}.
 // The Channels may be passed as a JSON array of strings directly,
@@ -45,3 +53,53 @@ func ChannelsFromQueryParams(queryParams interface{}) (channels []string, err er
 	}
 	return channels, nil
 }
+
+// RemoteInaccessibleChannels returns the subset of the given channels that the user identified by the credentials in
+// remoteDBURL can't access, based on the channels reported by the remote database's _session endpoint.  When the
+// session has no user, as for admin or unauthenticated requests, access is unknown, and nil is returned.
+func RemoteInaccessibleChannels(remoteDBURL url.URL, insecureSkipVerify bool, filterChannels []string) (inaccessible []string, err error) {
+	remoteDBURL.Path = strings.TrimSuffix(remoteDBURL.Path, "/") + "/_session"
+	req, err := http.NewRequest(http.MethodGet, remoteDBURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := base.GetHttpClient(insecureSkipVerify).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from target database _session", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var session struct {
+		UserCtx struct {
+			Name     *string                `json:"name"`
+			Channels map[string]interface{} `json:"channels"`
+		} `json:"userCtx"`
+	}
+	if err := base.JSONUnmarshal(body, &session); err != nil {
+		return nil, err
+	}
+
+	if session.UserCtx.Name == nil || session.UserCtx.Channels == nil {
+		return nil, nil
+	}
+	if _, ok := session.UserCtx.Channels[channels.UserStarChannel]; ok {
+		return nil, nil
+	}
+
+	for _, channel := range filterChannels {
+		if _, ok := session.UserCtx.Channels[channel]; !ok {
+			inaccessible = append(inaccessible, channel)
+		}
+	}
+	return inaccessible, nil
+}
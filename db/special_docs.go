@@ -40,8 +40,8 @@ func (db *DatabaseContext) GetSpecialBytes(doctype string, docid string) ([]byte
 
 	var rawDocBytes []byte
 	var err error
-	if doctype == "local" && db.Options.LocalDocExpirySecs > 0 {
-		rawDocBytes, _, err = db.Bucket.GetAndTouchRaw(key, base.SecondsToCbsExpiry(int(db.Options.LocalDocExpirySecs)))
+	if doctype == DocTypeLocal && db.Options.LocalDocExpirySecs > 0 {
+		rawDocBytes, _, err = db.Bucket.GetAndTouchRaw(key, db.localDocExpiry().CbsExpiry())
 	} else {
 		rawDocBytes, _, err = db.Bucket.GetRaw(key)
 	}
@@ -59,11 +59,11 @@ func (db *Database) putSpecial(doctype string, docid string, matchRev string, bo
 	}
 	var revid string
 
-	var expiry uint32
+	var expiry base.ExpiryValue
 	if doctype == DocTypeLocal {
-		expiry = base.SecondsToCbsExpiry(int(db.DatabaseContext.Options.LocalDocExpirySecs))
+		expiry = db.localDocExpiry()
 	}
-	_, err := db.Bucket.Update(key, expiry, func(value []byte) ([]byte, *uint32, bool, error) {
+	_, err := db.Bucket.Update(key, expiry.CbsExpiry(), func(value []byte) ([]byte, *uint32, bool, error) {
 		if len(value) == 0 {
 			if matchRev != "" || body == nil {
 				return nil, nil, false, base.HTTPErrorf(http.StatusNotFound, "No previous revision to replace")
@@ -108,6 +108,11 @@ func (db *Database) DeleteSpecial(doctype string, docid string, revid string) er
 	return err
 }
 
+// localDocExpiry returns the expiry applied to _local docs when they're written or read.
+func (db *DatabaseContext) localDocExpiry() base.ExpiryValue {
+	return base.ExpiryValue(db.Options.LocalDocExpirySecs)
+}
+
 func RealSpecialDocID(doctype string, docid string) string {
 	return base.SyncPrefix + doctype + ":" + docid
 }
@@ -0,0 +1,69 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Validates _local doc create, update, read and delete against each data store.  _local docs only require the
+// bucket, so the database context isn't fully initialized - collections don't yet support the caching feed.
+func TestLocalDocsForAllDataStores(t *testing.T) {
+	for _, expirySecs := range []uint32{0, 60} {
+		t.Run(fmt.Sprintf("expiry=%d", expirySecs), func(t *testing.T) {
+			base.ForAllDataStores(t, func(t *testing.T, bucket sgbucket.DataStore) {
+				db := &Database{DatabaseContext: &DatabaseContext{
+					Bucket:  bucket,
+					Options: DatabaseContextOptions{LocalDocExpirySecs: expirySecs},
+				}}
+
+				// Missing docs are not found
+				_, err := db.GetSpecial(DocTypeLocal, "checkpoint")
+				assertErrorStatus(t, err, http.StatusNotFound)
+
+				revID, err := db.PutSpecial(DocTypeLocal, "checkpoint", Body{"seq": "10"})
+				require.NoError(t, err)
+				assert.Equal(t, "0-1", revID)
+
+				// Updates must match the current revision
+				_, err = db.PutSpecial(DocTypeLocal, "checkpoint", Body{"seq": "20"})
+				assertErrorStatus(t, err, http.StatusConflict)
+				revID, err = db.PutSpecial(DocTypeLocal, "checkpoint", Body{"seq": "20", BodyRev: revID})
+				require.NoError(t, err)
+				assert.Equal(t, "0-2", revID)
+
+				body, err := db.GetSpecial(DocTypeLocal, "checkpoint")
+				require.NoError(t, err)
+				assert.Equal(t, "20", body["seq"])
+				assert.Equal(t, "0-2", body[BodyRev])
+
+				require.NoError(t, db.DeleteSpecial(DocTypeLocal, "checkpoint", revID))
+				_, err = db.GetSpecial(DocTypeLocal, "checkpoint")
+				assertErrorStatus(t, err, http.StatusNotFound)
+			})
+		})
+	}
+}
+
+// assertErrorStatus asserts that err maps to the given HTTP status, as bucket errors are returned as-is by _local doc
+// operations.
+func assertErrorStatus(t *testing.T, err error, status int) {
+	require.Error(t, err)
+	errStatus, _ := base.ErrorAsHTTPStatus(err)
+	assert.Equal(t, status, errStatus, "Unexpected status for error %v", err)
+}
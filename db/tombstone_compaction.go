@@ -0,0 +1,208 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// DefaultCompactOpsPerSec is the rate at which tombstone compaction purges tombstones, when not specified.
+const DefaultCompactOpsPerSec = 1000
+
+// States of a tombstone compaction
+const (
+	CompactionStateRunning   = "running"
+	CompactionStateCompleted = "completed"
+	CompactionStateError     = "error"
+)
+
+// errCompactionStopped stops a tombstone compaction when the database is closed.
+var errCompactionStopped = errors.New("tombstone compaction stopped - database closed")
+
+// CompactionStatus reports the progress of a tombstone compaction, or its result once complete.
+type CompactionStatus struct {
+	Status         string     `json:"status"`
+	StartTime      time.Time  `json:"start_time"`
+	EndTime        *time.Time `json:"end_time,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	PurgeOlderThan time.Time  `json:"purge_older_than"` // Tombstones deleted before this time are purged
+	Batches        int        `json:"batches"`
+	Purged         int        `json:"purged"`
+	Failed         int        `json:"failed"` // Tombstones that couldn't be purged, retried by the next compaction
+}
+
+// compactionRuns tracks the database's most recent tombstone compaction, in progress or complete.
+type compactionRuns struct {
+	last *CompactionStatus // Guarded by lock
+	lock sync.Mutex
+}
+
+// StartCompact purges the database's tombstones older than the purge interval - the server's metadata purge interval,
+// unless overridden by DatabaseContextOptions.TombstoneRetention.  Several Sync Gateway indexes serve tombstones
+// (deleted documents with an xattr).  There currently isn't a mechanism for server to remove these docs from the index
+// when the tombstone is purged by the server during metadata purge, because metadata purge doesn't trigger a DCP event.
+// Compaction initiates a normal delete operation for the document and xattr (a Sync Gateway purge), which triggers
+// removal of the document from the index.  In the event that the document has already been purged by server, it's
+// recreated and deleted to accomplish the same result.
+//
+// Tombstones are queried in batches, and purged at most opsPerSec per second so as not to impact other bucket
+// operations.  In background mode, returns the initial state of the compaction without waiting for it to complete.
+// Only one compaction can run at a time.  A no-op when not using xattrs.
+func (db *Database) StartCompact(opsPerSec int, background bool) (*CompactionStatus, error) {
+	if !atomic.CompareAndSwapUint32(&db.CompactState, DBCompactNotRunning, DBCompactRunning) {
+		return nil, base.HTTPErrorf(http.StatusServiceUnavailable, "Compaction already running")
+	}
+	if opsPerSec <= 0 {
+		opsPerSec = DefaultCompactOpsPerSec
+	}
+
+	startTime := time.Now()
+	status := &CompactionStatus{
+		Status:         CompactionStateRunning,
+		StartTime:      startTime,
+		PurgeOlderThan: startTime.Add(-db.PurgeInterval),
+	}
+	runs := &db.compactionRuns
+	runs.lock.Lock()
+	runs.last = status
+	initial := *status
+	runs.lock.Unlock()
+	db.DbStats.Database().TombstoneCompactionRunning.Set(1)
+
+	if background {
+		go func() {
+			_ = db.compact(status, opsPerSec)
+		}()
+		return &initial, nil
+	}
+	err := db.compact(status, opsPerSec)
+	return db.CompactionStatus(), err
+}
+
+// CompactionStatus returns the result of the most recent tombstone compaction, or its progress while running.
+// Returns nil if no compaction has been run.
+func (context *DatabaseContext) CompactionStatus() *CompactionStatus {
+	runs := &context.compactionRuns
+	runs.lock.Lock()
+	defer runs.lock.Unlock()
+	if runs.last == nil {
+		return nil
+	}
+	statusCopy := *runs.last
+	return &statusCopy
+}
+
+func (db *Database) compact(status *CompactionStatus, opsPerSec int) (err error) {
+	runs := &db.compactionRuns
+	defer func() {
+		runs.lock.Lock()
+		endTime := time.Now()
+		status.EndTime = &endTime
+		if err != nil {
+			status.Status = CompactionStateError
+			status.Error = err.Error()
+		} else {
+			status.Status = CompactionStateCompleted
+		}
+		runs.lock.Unlock()
+		db.DbStats.Database().TombstoneCompactionRunning.Set(0)
+		atomic.CompareAndSwapUint32(&db.CompactState, DBCompactRunning, DBCompactNotRunning)
+	}()
+
+	// Compact should be a no-op if not running w/ xattrs
+	if !db.UseXattrs() {
+		return nil
+	}
+
+	ctx := db.Ctx
+	interval := time.Second / time.Duration(opsPerSec)
+
+	base.InfofCtx(ctx, base.KeyAll, "Starting compaction of purged tombstones for %s ...", base.MD(db.Name))
+	purgeBody := Body{"_purged": true}
+	for {
+		batchStart := time.Now()
+		results, err := db.QueryTombstones(status.PurgeOlderThan, QueryTombstoneBatch)
+		if err != nil {
+			base.WarnfCtx(ctx, "Error querying tombstones for compaction of %s: %v", base.MD(db.Name), err)
+			return err
+		}
+		purgedDocs := make([]string, 0)
+		var tombstonesRow QueryIdRow
+		var resultCount, failedCount int
+		for results.Next(&tombstonesRow) {
+			resultCount++
+			base.DebugfCtx(ctx, base.KeyCRUD, "\tDeleting %q", tombstonesRow.Id)
+			// First, attempt to purge.
+			purgeErr := db.Purge(tombstonesRow.Id)
+			if purgeErr == nil {
+				purgedDocs = append(purgedDocs, tombstonesRow.Id)
+			} else if base.IsKeyNotFoundError(db.Bucket, purgeErr) {
+				// If key no longer exists, need to add and remove to trigger removal from view
+				_, addErr := db.Bucket.Add(tombstonesRow.Id, 0, purgeBody)
+				if addErr != nil {
+					base.WarnfCtx(ctx, "Error compacting key %s (add) - tombstone will not be compacted.  %v", base.UD(tombstonesRow.Id), addErr)
+					failedCount++
+					continue
+				}
+
+				// At this point, the doc is not in a usable state for mobile
+				// so mark it to be removed from cache, even if the subsequent delete fails
+				purgedDocs = append(purgedDocs, tombstonesRow.Id)
+
+				if delErr := db.Bucket.Delete(tombstonesRow.Id); delErr != nil {
+					base.ErrorfCtx(ctx, "Error compacting key %s (delete) - tombstone will not be compacted.  %v", base.UD(tombstonesRow.Id), delErr)
+				}
+			} else {
+				base.WarnfCtx(ctx, "Error compacting key %s (purge) - tombstone will not be compacted.  %v", base.UD(tombstonesRow.Id), purgeErr)
+				failedCount++
+			}
+		}
+
+		// Now purge them from all channel caches.  The purges' own feed events carry no sync metadata, so are ignored
+		// by the change cache.
+		count := len(purgedDocs)
+		if count > 0 {
+			db.changeCache.Remove(purgedDocs, status.StartTime)
+			db.DbStats.Database().NumTombstonesCompacted.Add(int64(count))
+		}
+		if failedCount > 0 {
+			db.DbStats.Database().TombstoneCompactionFailedCount.Add(int64(failedCount))
+		}
+		runs.lock.Lock()
+		status.Batches++
+		status.Purged += count
+		status.Failed += failedCount
+		runs.lock.Unlock()
+		base.DebugfCtx(ctx, base.KeyAll, "Compacted %v tombstones", count)
+
+		if resultCount < QueryTombstoneBatch {
+			break
+		}
+
+		// Pace the next batch, so that the batch just purged took at least its share of a second at opsPerSec
+		if wait := time.Duration(resultCount)*interval - time.Since(batchStart); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-db.terminator:
+				return errCompactionStopped
+			}
+		}
+	}
+
+	base.InfofCtx(ctx, base.KeyAll, "Finished compaction of purged tombstones for %s... Total Tombstones Compacted: %d", base.MD(db.Name), status.Purged)
+	return nil
+}
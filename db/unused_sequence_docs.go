@@ -0,0 +1,141 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// Unused sequence notification documents tell every node's change cache that allocated sequences won't be used by a
+// document, so that sequence buffering doesn't wait for them.  Their lifecycle:
+//
+//   - Written by the allocating node with a TTL covering the longest a cache may still be waiting for the sequence - the
+//     pending wait, then the skipped wait - plus unusedSequenceTTLMargin for a cache that's slow to process its feed.
+//     Once expired, any cache still waiting abandons the sequence when it leaves the skipped queue.
+//   - Received over the caching feed by every running node.  Duplicate notifications are ignored by processEntry.
+//   - Optionally scanned on startup, releasing the sequences of any notifications still present, as the caching feed
+//     doesn't backfill notifications written while the node was down.
+
+// unusedSequenceTTLMargin is added to the cache's pending and skipped waits to give unused sequence notifications' TTL.
+const unusedSequenceTTLMargin = 10 * time.Minute
+
+// unusedSequenceDoc identifies an unused sequence notification document for a single sequence (_sync:unusedSeq:seq) or
+// an inclusive range of sequences (_sync:unusedSeqs:fromSeq:toSeq).  The sequences are also stored as the document
+// body, to avoid null doc issues.
+type unusedSequenceDoc struct {
+	fromSequence uint64
+	toSequence   uint64
+	isRange      bool // Range notifications may release a single sequence
+}
+
+// key returns the notification's document ID.
+func (d unusedSequenceDoc) key() string {
+	if !d.isRange {
+		return fmt.Sprintf("%s%d", base.UnusedSeqPrefix, d.fromSequence)
+	}
+	return fmt.Sprintf("%s%d:%d", base.UnusedSeqRangePrefix, d.fromSequence, d.toSequence)
+}
+
+// body returns the notification's binary document body.
+func (d unusedSequenceDoc) body() []byte {
+	if !d.isRange {
+		body := make([]byte, 8)
+		binary.LittleEndian.PutUint64(body, d.fromSequence)
+		return body
+	}
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint64(body[:8], d.fromSequence)
+	binary.LittleEndian.PutUint64(body[8:16], d.toSequence)
+	return body
+}
+
+// count returns the number of sequences released by the notification.
+func (d unusedSequenceDoc) count() uint64 {
+	return d.toSequence - d.fromSequence + 1
+}
+
+// parseUnusedSequenceDocKey returns the sequences released by the unused sequence notification with document ID key.
+func parseUnusedSequenceDocKey(key string) (unusedSequenceDoc, error) {
+	if strings.HasPrefix(key, base.UnusedSeqPrefix) {
+		sequence, err := strconv.ParseUint(strings.TrimPrefix(key, base.UnusedSeqPrefix), 10, 64)
+		if err != nil {
+			return unusedSequenceDoc{}, fmt.Errorf("unable to identify sequence number: %w", err)
+		}
+		return unusedSequenceDoc{fromSequence: sequence, toSequence: sequence}, nil
+	}
+	if !strings.HasPrefix(key, base.UnusedSeqRangePrefix) {
+		return unusedSequenceDoc{}, fmt.Errorf("not an unused sequence notification")
+	}
+	sequences := strings.Split(strings.TrimPrefix(key, base.UnusedSeqRangePrefix), ":")
+	if len(sequences) != 2 {
+		return unusedSequenceDoc{}, fmt.Errorf("expected from and to sequence numbers")
+	}
+	fromSequence, err := strconv.ParseUint(sequences[0], 10, 64)
+	if err != nil {
+		return unusedSequenceDoc{}, fmt.Errorf("unable to identify from sequence number: %w", err)
+	}
+	toSequence, err := strconv.ParseUint(sequences[1], 10, 64)
+	if err != nil {
+		return unusedSequenceDoc{}, fmt.Errorf("unable to identify to sequence number: %w", err)
+	}
+	if fromSequence > toSequence {
+		return unusedSequenceDoc{}, fmt.Errorf("from sequence %d is after to sequence %d", fromSequence, toSequence)
+	}
+	return unusedSequenceDoc{fromSequence: fromSequence, toSequence: toSequence, isRange: true}, nil
+}
+
+// unusedSequenceTTL returns the expiry for unused sequence notifications written while the cache uses options.
+func unusedSequenceTTL(options CacheOptions) time.Duration {
+	return options.CachePendingSeqMaxWait + options.CacheSkippedSeqMaxWait + unusedSequenceTTLMargin
+}
+
+// writeUnusedSequenceDoc writes the unused sequence notification doc, expiring after ttl - or the TTL for the default
+// cache options, when zero.  Returns false if the notification already exists.
+func writeUnusedSequenceDoc(bucket base.Bucket, doc unusedSequenceDoc, ttl time.Duration) (added bool, err error) {
+	if ttl <= 0 {
+		ttl = unusedSequenceTTL(DefaultCacheOptions())
+	}
+	return bucket.AddRaw(doc.key(), base.DurationToCbsExpiry(ttl), doc.body())
+}
+
+// ReleaseUnusedSequenceDocs releases the sequences of the unused sequence notifications present in the bucket to the
+// change cache, for notifications written while this node wasn't receiving the caching feed.  Sequences the cache has
+// already received aren't released again.  Returns the number of sequences released.
+func (context *DatabaseContext) ReleaseUnusedSequenceDocs() (released int, err error) {
+	for _, prefix := range []string{base.UnusedSeqPrefix, base.UnusedSeqRangePrefix} {
+		err = context.ForEachMetadataKey(prefix, func(key string) error {
+			doc, parseErr := parseUnusedSequenceDocKey(key)
+			if parseErr != nil {
+				base.Warnf("Ignoring unused sequence notification with key %s: %v", base.UD(key), parseErr)
+				return nil
+			}
+			for sequence := doc.fromSequence; sequence <= doc.toSequence; sequence++ {
+				if context.changeCache.isReceived(sequence) {
+					continue
+				}
+				context.changeCache.releaseUnusedSequence(sequence, time.Now())
+				released++
+			}
+			return nil
+		})
+		if err != nil {
+			return released, err
+		}
+	}
+	base.Infof(base.KeyCache, "Released %d sequences from unused sequence notifications for database %s", released, base.MD(context.Name))
+	return released, nil
+}
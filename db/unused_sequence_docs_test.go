@@ -0,0 +1,133 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expiryRecordingBucket records the expiry of documents written with AddRaw.
+type expiryRecordingBucket struct {
+	base.Bucket
+	expiries map[string]uint32
+}
+
+func (b *expiryRecordingBucket) AddRaw(k string, exp uint32, v []byte) (added bool, err error) {
+	b.expiries[k] = exp
+	return b.Bucket.AddRaw(k, exp, v)
+}
+
+func TestParseUnusedSequenceDocKey(t *testing.T) {
+
+	for _, doc := range []unusedSequenceDoc{
+		{fromSequence: 5, toSequence: 5},
+		{fromSequence: 5, toSequence: 10, isRange: true},
+		{fromSequence: 5, toSequence: 5, isRange: true},
+	} {
+		parsed, err := parseUnusedSequenceDocKey(doc.key())
+		require.NoError(t, err)
+		assert.Equal(t, doc, parsed)
+	}
+
+	for _, key := range []string{
+		"_sync:user:bob",
+		base.UnusedSeqPrefix + "abc",
+		base.UnusedSeqRangePrefix + "5",
+		base.UnusedSeqRangePrefix + "5:abc",
+		base.UnusedSeqRangePrefix + "10:5",
+	} {
+		_, err := parseUnusedSequenceDocKey(key)
+		assert.Error(t, err, "Expected error parsing key %s", key)
+	}
+}
+
+// Validates that unused sequence notifications expire after the cache's pending and skipped waits, plus the margin.
+func TestUnusedSequenceDocTTL(t *testing.T) {
+
+	testBucket := base.GetTestBucket(t)
+	defer testBucket.Close()
+	bucket := &expiryRecordingBucket{Bucket: testBucket, expiries: make(map[string]uint32)}
+
+	cacheOptions := DefaultCacheOptions()
+	cacheOptions.CachePendingSeqMaxWait = time.Second
+	cacheOptions.CacheSkippedSeqMaxWait = 2 * time.Minute
+	a := &sequenceAllocator{
+		bucket:       bucket,
+		dbStats:      base.NewSyncGatewayStats().NewDBStats("", false, false, false).Database(),
+		unusedSeqTTL: unusedSequenceTTL(cacheOptions),
+	}
+
+	require.NoError(t, a.releaseSequence(5))
+	require.NoError(t, a.releaseSequenceRange(10, 12))
+	assert.Equal(t, map[string]uint32{
+		base.UnusedSeqPrefix + "5":          721,
+		base.UnusedSeqRangePrefix + "10:12": 721,
+	}, bucket.expiries)
+	assert.Equal(t, int64(4), a.dbStats.SequenceReleasedCount.Value())
+
+	// Without a TTL, the default cache options' waits are used
+	a.unusedSeqTTL = 0
+	require.NoError(t, a.releaseSequence(6))
+	assert.Equal(t, uint32(3605+600), bucket.expiries[base.UnusedSeqPrefix+"6"])
+}
+
+// Validates that sequences of unused sequence notifications missed by the caching feed are released by the startup
+// scan, and that sequences already received aren't released again.
+func TestReleaseUnusedSequenceDocs(t *testing.T) {
+
+	if base.TestUseXattrs() {
+		t.Skip("This test does not work with XATTRs due to calling WriteDirect().  Skipping.")
+	}
+
+	defer base.SetUpTestLogging(base.LevelDebug, base.KeyCache)()
+
+	// The feed misses the notifications for 2 and 4-5, as when they're written while the node is down.  Released
+	// notifications are found by a metadata key query, which isn't namespaced, so the bucket must be exclusive.
+	db := setupTestLeakyDBForBucketWithCacheOptions(t, base.GetExclusiveTestBucket(t), shortWaitCache(), base.LeakyBucketConfig{
+		TapFeedMissingDocs: []string{base.UnusedSeqPrefix + "2", base.UnusedSeqRangePrefix + "4:5"},
+	})
+	defer db.Close()
+
+	WriteDirect(db, []string{"ABC"}, 1)
+	WriteDirect(db, []string{"ABC"}, 3)
+	WriteDirect(db, []string{"ABC"}, 6)
+	require.NoError(t, db.sequences.releaseSequence(2))
+	require.NoError(t, db.sequences.releaseSequenceRange(4, 5))
+	require.NoError(t, db.sequences.releaseSequence(7))
+	require.NoError(t, db.changeCache.waitForSequence(context.TODO(), 7, base.DefaultWaitForSequence))
+	for _, seq := range []uint64{2, 4, 5} {
+		assert.True(t, db.changeCache.WasSkipped(seq), "Expected sequence %d to be skipped", seq)
+	}
+
+	released, err := db.ReleaseUnusedSequenceDocs()
+	require.NoError(t, err)
+	assert.Equal(t, 3, released)
+	for _, seq := range []uint64{2, 4, 5} {
+		assert.False(t, db.changeCache.WasSkipped(seq), "Expected sequence %d to be released", seq)
+	}
+
+	// 7 was received over the feed, and 2, 4 and 5 have now been released
+	released, err = db.ReleaseUnusedSequenceDocs()
+	require.NoError(t, err)
+	assert.Equal(t, 0, released)
+
+	// A duplicate notification is ignored
+	nextSequence := db.changeCache.getNextSequence()
+	db.changeCache.releaseUnusedSequence(2, time.Now())
+	assert.Equal(t, nextSequence, db.changeCache.getNextSequence())
+	assert.False(t, db.changeCache.WasSkipped(2))
+}
@@ -96,13 +96,18 @@ func (db *DatabaseContext) WaitForCaughtUp(targetCount int64) error {
 }
 
 type StatWaiter struct {
-	initCount   int64            // Document cached count when NewStatWaiter is called
-	targetCount int64            // Target count used when Wait is called
-	stat        *base.SgwIntStat // Stat to wait on
-	tb          testing.TB       // Raises tb.Fatalf on wait timeout
+	initCount   int64      // Document cached count when NewStatWaiter is called
+	targetCount int64      // Target count used when Wait is called
+	stat        intStat    // Stat to wait on
+	tb          testing.TB // Raises tb.Fatalf on wait timeout
 }
 
-func (db *DatabaseContext) NewStatWaiter(stat *base.SgwIntStat, tb testing.TB) *StatWaiter {
+// intStat is implemented by both base.SgwIntStat and base.ShardedIntStat.
+type intStat interface {
+	Value() int64
+}
+
+func (db *DatabaseContext) NewStatWaiter(stat intStat, tb testing.TB) *StatWaiter {
 	return &StatWaiter{
 		initCount:   stat.Value(),
 		targetCount: stat.Value(),
@@ -346,6 +351,23 @@ func (db *DatabaseContext) GetChannelQueryCount() int64 {
 	return db.DbStats.Query(QueryTypeChannels).QueryCount.Value()
 }
 
+// AddSkippedSequenceForTest adds seq to the change cache's skipped sequences as if it had been skipped for the
+// given duration, and resets any cached cache health.
+func (db *DatabaseContext) AddSkippedSequenceForTest(seq uint64, skippedFor time.Duration) error {
+	err := db.changeCache.skippedSeqs.Push(&SkippedSequence{seq: seq, timeAdded: time.Now().Add(-skippedFor)})
+	db.cacheHealthLock.Lock()
+	db.cacheHealthTime = time.Time{}
+	db.cacheHealthLock.Unlock()
+	return err
+}
+
+// AssertCacheInvariants fails the test if the change cache violates any of the invariants checked by
+// CollectCacheInvariantReport, logging the report of violations.
+func AssertCacheInvariants(t testing.TB, cache *changeCache) bool {
+	report := CollectCacheInvariantReport(cache)
+	return assert.Truef(t, report.Empty(), "Change cache invariants violated: %s", report)
+}
+
 // GetLocalActiveReplicatorForTest is a test util for retrieving an Active Replicator for deeper introspection/assertions.
 func (m *sgReplicateManager) GetLocalActiveReplicatorForTest(t testing.TB, replicationID string) (ar *ActiveReplicator, ok bool) {
 	// Check if replication is assigned locally
@@ -13,6 +13,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -98,15 +99,441 @@ func (h *handler) handleDbOffline() error {
 
 // Get admin database info
 func (h *handler) handleGetDbConfig() error {
+	dbConfig := h.server.GetDatabaseConfig(h.db.Name)
+	if dbConfig == nil {
+		return base.HTTPErrorf(http.StatusNotFound, "Database config not found")
+	}
+
+	var cfg *DbConfig
 	redact, _ := h.getOptBoolQuery("redact", true)
 	if redact {
-		cfg, err := h.server.GetDatabaseConfig(h.db.Name).Redacted()
+		var err error
+		cfg, err = dbConfig.Redacted()
 		if err != nil {
 			return err
 		}
-		h.writeJSON(cfg)
 	} else {
-		h.writeJSON(h.server.GetDatabaseConfig(h.db.Name))
+		// Shallow copy, to avoid modifying the server's config below
+		configCopy := *dbConfig
+		cfg = &configCopy
+	}
+
+	// Report the effective query page size when the default is being used
+	if cfg.QueryPaginationLimit == nil {
+		cfg.QueryPaginationLimit = base.IntPtr(h.db.Options.QueryPaginationLimit)
+	}
+
+	// Report the effective channel cache settings when a cache profile is in use
+	if cfg.CacheProfile != "" && h.db.Options.CacheOptions != nil {
+		cfg.CacheConfig = withEffectiveChannelCacheConfig(cfg.CacheConfig, h.db.Options.CacheOptions)
+	}
+
+	if effective, _ := h.getOptBoolQuery("effective", false); effective {
+		effectiveCfg, err := h.effectiveDbConfig(cfg)
+		if err != nil {
+			return err
+		}
+		h.writeJSON(effectiveCfg)
+		return nil
+	}
+
+	h.writeJSON(cfg)
+	return nil
+}
+
+// EffectiveDbConfig is the response body for GET /{db}/_config?effective=true
+type EffectiveDbConfig struct {
+	Config       *DbConfig           `json:"config"`        // The persisted database config
+	ChannelCache *ChannelCacheConfig `json:"channel_cache"` // The change cache's in-memory options
+	Diff         []ConfigDrift       `json:"diff"`          // Settings whose in-memory value differs from the persisted config
+}
+
+// ConfigDrift is a setting whose in-memory value differs from the persisted config
+type ConfigDrift struct {
+	Field        string      `json:"field"`                   // Config path of the setting
+	Persisted    interface{} `json:"persisted"`               // Persisted value, or the default it resolves to when unset
+	Effective    interface{} `json:"effective"`               // In-memory value
+	LastModified *time.Time  `json:"last_modified,omitempty"` // When the in-memory value was last updated at runtime, if it has been
+}
+
+// channelCacheRuntimeOptions maps the channel cache settings that can be updated at runtime to their CacheOptions
+// field names.
+var channelCacheRuntimeOptions = map[string]string{
+	"max_wait_pending":        db.CacheOptionPendingSeqMaxWait,
+	"max_num_pending":         db.CacheOptionPendingSeqMaxNum,
+	"max_wait_skipped":        db.CacheOptionSkippedSeqMaxWait,
+	"feed_lag_warn_threshold": db.CacheOptionFeedLagWarnThreshold,
+}
+
+// effectiveDbConfig returns the persisted config alongside the change cache's in-memory options, and the settings
+// whose values differ between the two - either updated at runtime, or changed in the persisted config and not yet
+// applied by a reload.
+func (h *handler) effectiveDbConfig(cfg *DbConfig) (*EffectiveDbConfig, error) {
+	loadedOptions := db.DefaultCacheOptions()
+	if h.db.Options.CacheOptions != nil {
+		loadedOptions = *h.db.Options.CacheOptions
+	}
+	runtimeOptions := h.db.GetChangeCache().GetOptions()
+	persisted := withEffectiveChannelCacheConfig(cfg.CacheConfig, &loadedOptions).ChannelCacheConfig
+	effective := withEffectiveChannelCacheConfig(nil, &runtimeOptions).ChannelCacheConfig
+
+	// Compare by config key.  Settings that aren't cache options (e.g. query_limit) are unset in effective,
+	// so aren't compared.
+	configValues := func(config *ChannelCacheConfig) (map[string]interface{}, error) {
+		var values map[string]interface{}
+		data, err := base.JSONMarshal(config)
+		if err != nil {
+			return nil, err
+		}
+		return values, base.JSONUnmarshal(data, &values)
+	}
+	persistedValues, err := configValues(persisted)
+	if err != nil {
+		return nil, err
+	}
+	effectiveValues, err := configValues(effective)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(effectiveValues))
+	for key := range effectiveValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	modified := h.db.GetChangeCache().OptionsModified()
+	diff := []ConfigDrift{}
+	for _, key := range keys {
+		if persistedValues[key] == effectiveValues[key] {
+			continue
+		}
+		drift := ConfigDrift{
+			Field:     "cache.channel_cache." + key,
+			Persisted: persistedValues[key],
+			Effective: effectiveValues[key],
+		}
+		if lastModified, ok := modified[channelCacheRuntimeOptions[key]]; ok {
+			drift.LastModified = &lastModified
+		}
+		diff = append(diff, drift)
+	}
+
+	return &EffectiveDbConfig{
+		Config:       cfg,
+		ChannelCache: effective,
+		Diff:         diff,
+	}, nil
+}
+
+// withEffectiveChannelCacheConfig returns a copy of cacheConfig, with any unset channel cache settings populated from
+// the database's resolved cache options.
+func withEffectiveChannelCacheConfig(cacheConfig *CacheConfig, options *db.CacheOptions) *CacheConfig {
+	var configCopy CacheConfig
+	if cacheConfig != nil {
+		configCopy = *cacheConfig
+	}
+	var channelCacheConfig ChannelCacheConfig
+	if configCopy.ChannelCacheConfig != nil {
+		channelCacheConfig = *configCopy.ChannelCacheConfig
+	}
+
+	if channelCacheConfig.MaxNumber == nil {
+		channelCacheConfig.MaxNumber = base.IntPtr(options.MaxNumChannels)
+	}
+	if channelCacheConfig.HighWatermarkPercent == nil {
+		channelCacheConfig.HighWatermarkPercent = base.IntPtr(options.CompactHighWatermarkPercent)
+	}
+	if channelCacheConfig.LowWatermarkPercent == nil {
+		channelCacheConfig.LowWatermarkPercent = base.IntPtr(options.CompactLowWatermarkPercent)
+	}
+	if channelCacheConfig.MaxWaitPending == nil {
+		channelCacheConfig.MaxWaitPending = base.Uint32Ptr(uint32(options.CachePendingSeqMaxWait / time.Millisecond))
+	}
+	if channelCacheConfig.MinWaitPending == nil {
+		channelCacheConfig.MinWaitPending = base.Uint32Ptr(uint32(options.CachePendingSeqMinWait / time.Millisecond))
+	}
+	if channelCacheConfig.AdaptiveWaitPending == nil {
+		channelCacheConfig.AdaptiveWaitPending = base.BoolPtr(options.AdaptivePendingSeqMaxWait)
+	}
+	if channelCacheConfig.MaxNumPending == nil {
+		channelCacheConfig.MaxNumPending = base.IntPtr(options.CachePendingSeqMaxNum)
+	}
+	if channelCacheConfig.MaxPendingMemoryMB == nil {
+		channelCacheConfig.MaxPendingMemoryMB = base.IntPtr(int(options.CachePendingSeqMaxBytes / (1024 * 1024)))
+	}
+	if channelCacheConfig.MaxWaitSkipped == nil {
+		channelCacheConfig.MaxWaitSkipped = base.Uint32Ptr(uint32(options.CacheSkippedSeqMaxWait / time.Millisecond))
+	}
+	if channelCacheConfig.MaxNumSkipped == nil {
+		channelCacheConfig.MaxNumSkipped = base.IntPtr(options.CacheSkippedSeqMaxNum)
+	}
+	if channelCacheConfig.MaxWaitSequence == nil {
+		channelCacheConfig.MaxWaitSequence = base.Uint32Ptr(uint32(options.SequenceWaitTimeout / time.Millisecond))
+	}
+	if channelCacheConfig.FeedLagWarnThreshold == nil {
+		channelCacheConfig.FeedLagWarnThreshold = base.Uint32Ptr(uint32(options.FeedLagWarnThreshold / time.Millisecond))
+	}
+	if channelCacheConfig.FeedStageSampleRate == nil {
+		channelCacheConfig.FeedStageSampleRate = base.IntPtr(options.FeedStageSampleRate)
+	}
+	if channelCacheConfig.NotifyDebounce == nil {
+		channelCacheConfig.NotifyDebounce = base.Uint32Ptr(uint32(options.NotificationDebounceInterval / time.Millisecond))
+	}
+	if channelCacheConfig.BypassBuffering == nil {
+		channelCacheConfig.BypassBuffering = base.BoolPtr(options.BypassSequenceBuffering)
+	}
+	if channelCacheConfig.EnableStarChannel == nil {
+		channelCacheConfig.EnableStarChannel = base.BoolPtr(options.EnableStarChannel)
+	}
+	if channelCacheConfig.MaxLength == nil {
+		channelCacheConfig.MaxLength = base.IntPtr(options.ChannelCacheMaxLength)
+	}
+	if channelCacheConfig.MinLength == nil {
+		channelCacheConfig.MinLength = base.IntPtr(options.ChannelCacheMinLength)
+	}
+	if channelCacheConfig.MaxMemoryMB == nil {
+		channelCacheConfig.MaxMemoryMB = base.IntPtr(int(options.MaxMemoryBytes / (1024 * 1024)))
+	}
+	if channelCacheConfig.RemovalRetention == nil {
+		channelCacheConfig.RemovalRetention = base.IntPtr(options.RemovalRetention)
+	}
+	if channelCacheConfig.NameNormalization == nil {
+		nameNormalization := options.ChannelNameNormalization
+		if nameNormalization == "" {
+			nameNormalization = db.ChannelNamesPreserve
+		}
+		channelCacheConfig.NameNormalization = &nameNormalization
+	}
+	if channelCacheConfig.UncachedChannels == nil {
+		channelCacheConfig.UncachedChannels = options.UncachedChannelPatterns
+	}
+	if channelCacheConfig.ExpirySeconds == nil {
+		channelCacheConfig.ExpirySeconds = base.IntPtr(int(options.ChannelCacheAge / time.Second))
+	}
+	if channelCacheConfig.Handoff == nil {
+		channelCacheConfig.Handoff = base.BoolPtr(options.Handoff.Enabled)
+	}
+	if channelCacheConfig.HandoffMaxChannels == nil {
+		channelCacheConfig.HandoffMaxChannels = base.IntPtr(options.Handoff.MaxChannels)
+	}
+	if channelCacheConfig.HandoffMaxSizeKB == nil {
+		channelCacheConfig.HandoffMaxSizeKB = base.IntPtr(options.Handoff.MaxBytes / 1024)
+	}
+	if channelCacheConfig.HandoffMaxAgeSeconds == nil {
+		channelCacheConfig.HandoffMaxAgeSeconds = base.IntPtr(int(options.Handoff.MaxAge / time.Second))
+	}
+	configCopy.ChannelCacheConfig = &channelCacheConfig
+	return &configCopy
+}
+
+// CacheDiagnostics is the response body for GET /{db}/_cache
+type CacheDiagnostics struct {
+	LastSequence     uint64                   `json:"last_sequence"`               // The sequence the change cache is up-to-date with
+	ParseFailures    []db.CacheParseFailure   `json:"parse_failures,omitempty"`    // Feed documents with unparseable sync metadata (strict_feed_parsing only), with IDs tagged as user data
+	CacheVbLag       []db.VbLag               `json:"cache_vb_lag,omitempty"`      // Vbuckets with the longest time since the caching feed processed an event
+	ImportVbLag      []db.VbLag               `json:"import_vb_lag,omitempty"`     // Vbuckets with the longest time since the import feed processed an event
+	SkippedSequences []db.SkippedSequenceInfo `json:"skipped_sequences,omitempty"` // Oldest skipped sequences, and the pending queue state when they were skipped
+	Channels         []db.ChannelCacheInfo    `json:"channels,omitempty"`          // A page of the channel caches, ordered by channel_sort, with names tagged as user data
+	ChannelsCursor   string                   `json:"channels_cursor,omitempty"`   // Pass as channel_cursor to get the next page of channels
+	PauseStatus      db.CachePauseStatus      `json:"pause_status"`                // Whether caching is paused, and the sequences it was last paused and resumed at
+}
+
+// Get diagnostic information about the database's change cache
+func (h *handler) handleGetCache() error {
+	changeCache := h.db.GetChangeCache()
+	vbLagCount := int(h.getIntQuery("vb_lag_count", db.DefaultVbLagReportCount))
+	skippedCount := int(h.getIntQuery("skipped_count", db.DefaultSkippedSeqReportCount))
+	channelCount := int(h.getIntQuery("channel_count", db.DefaultChannelCacheReportCount))
+	channels, channelsCursor, err := changeCache.ListChannelCaches(h.getQuery("channel_sort"), channelCount, h.getQuery("channel_cursor"))
+	if err != nil {
+		return err
+	}
+	for i := range channels {
+		channels[i].Name = base.UD(channels[i].Name).Redact()
+	}
+	parseFailures := changeCache.GetParseFailures()
+	for i := range parseFailures {
+		parseFailures[i].DocID = base.UD(parseFailures[i].DocID).Redact()
+	}
+	diagnostics := CacheDiagnostics{
+		LastSequence:     changeCache.LastSequence(),
+		ParseFailures:    parseFailures,
+		CacheVbLag:       h.db.CacheFeedVbLag(vbLagCount),
+		ImportVbLag:      h.db.ImportFeedVbLag(vbLagCount),
+		SkippedSequences: changeCache.GetSkippedSequences(skippedCount),
+		Channels:         channels,
+		ChannelsCursor:   channelsCursor,
+		PauseStatus:      changeCache.PauseStatus(),
+	}
+	h.writeJSON(diagnostics)
+	return nil
+}
+
+// Get the change cache's skipped sequences (up to limit, oldest first) and sequence buffering state
+func (h *handler) handleGetSkippedSequences() error {
+	report, err := h.db.GetChangeCache().GetSkippedSequenceReport(int(h.getIntQuery("limit", db.DefaultSkippedSeqReportCount)))
+	if err != nil {
+		return err
+	}
+	h.writeJSON(report)
+	return nil
+}
+
+// Check the skipped sequences that have been waiting longer than max_wait_skipped now, rather than on the next run of
+// the background task - any found by query are cached, and the remainder abandoned.  Responds with the skipped
+// sequences remaining.
+func (h *handler) handlePostSkippedSequences() error {
+	changeCache := h.db.GetChangeCache()
+	if changeCache.IsStopped() {
+		return base.ErrDatabaseClosed
+	}
+	if err := changeCache.CleanSkippedSequenceQueue(h.db.Ctx); err != nil {
+		return err
+	}
+	return h.handleGetSkippedSequences()
+}
+
+// channelCacheID returns the ID of the channel named in the path, in the collection query param's collection (the
+// default collection when not given)
+func (h *handler) channelCacheID() db.ChannelID {
+	return db.NewChannelID(h.getQuery("collection"), h.PathVar("channel"))
+}
+
+// Get the entries in a single channel's cache, after the since query param (up to limit, when given)
+func (h *handler) handleGetChannelCache() error {
+	contents, ok := h.db.GetChangeCache().GetChannelCacheContents(h.channelCacheID(), h.getIntQuery("since", 0), int(h.getIntQuery("limit", 0)))
+	if !ok {
+		return base.HTTPErrorf(http.StatusNotFound, "Channel is not cached")
+	}
+	contents.Name = base.UD(contents.Name).Redact()
+	h.writeJSON(contents)
+	return nil
+}
+
+// Drop a single channel's cache, which is rebuilt on next use
+func (h *handler) handleDeleteChannelCache() error {
+	if !h.db.GetChangeCache().RemoveChannelCache(h.channelCacheID()) {
+		return base.HTTPErrorf(http.StatusNotFound, "Channel is not cached")
+	}
+	return nil
+}
+
+// Compare a range of a single channel's cache with the channel query (from and to sequences, and a limit on query
+// rows, when given), reporting any differences
+func (h *handler) handleAuditChannelCache() error {
+	report, err := h.db.GetChangeCache().AuditChannel(h.channelCacheID(), h.getIntQuery("from", 0), h.getIntQuery("to", 0), int(h.getIntQuery("limit", 0)))
+	if err != nil {
+		return err
+	}
+	h.writeJSON(report)
+	return nil
+}
+
+// Pause caching, to shed channel cache load - changes feeds are served up to the sequence paused at until caching is
+// resumed.  Not persisted, so caching resumes when the database is reloaded.
+func (h *handler) handlePostCachePause() error {
+	changeCache := h.db.GetChangeCache()
+	if changeCache.IsStopped() {
+		return base.ErrDatabaseClosed
+	}
+	h.writeJSON(changeCache.Pause())
+	return nil
+}
+
+// Resume caching after a pause.  Channel caches are revalidated from the sequence resumed at, so changes received while
+// paused are backfilled by query.
+func (h *handler) handlePostCacheResume() error {
+	changeCache := h.db.GetChangeCache()
+	if changeCache.IsStopped() {
+		return base.ErrDatabaseClosed
+	}
+	h.writeJSON(changeCache.Resume())
+	return nil
+}
+
+// Update the change cache's in-memory options.  Updates aren't persisted, and are reported as drift from the
+// persisted config by GET /{db}/_config?effective=true until the database is reloaded.
+func (h *handler) handlePutCacheOptions() error {
+	var input struct {
+		MaxWaitPending       *uint32 `json:"max_wait_pending"` // ms
+		MaxNumPending        *int    `json:"max_num_pending"`
+		MaxWaitSkipped       *uint32 `json:"max_wait_skipped"`        // ms
+		FeedLagWarnThreshold *uint32 `json:"feed_lag_warn_threshold"` // ms
+		NameNormalization    *string `json:"channel_name_normalization"`
+	}
+	if err := h.readJSONInto(&input); err != nil {
+		return err
+	}
+	var update db.CacheOptionsUpdate
+	if input.MaxWaitPending != nil {
+		maxWait := time.Duration(*input.MaxWaitPending) * time.Millisecond
+		update.CachePendingSeqMaxWait = &maxWait
+	}
+	update.CachePendingSeqMaxNum = input.MaxNumPending
+	if input.MaxWaitSkipped != nil {
+		maxWait := time.Duration(*input.MaxWaitSkipped) * time.Millisecond
+		update.CacheSkippedSeqMaxWait = &maxWait
+	}
+	if input.FeedLagWarnThreshold != nil {
+		threshold := time.Duration(*input.FeedLagWarnThreshold) * time.Millisecond
+		update.FeedLagWarnThreshold = &threshold
+	}
+	update.ChannelNameNormalization = input.NameNormalization
+	if err := h.db.GetChangeCache().UpdateOptions(update); err != nil {
+		return err
+	}
+	options := h.db.GetChangeCache().GetOptions()
+	h.writeJSON(withEffectiveChannelCacheConfig(nil, &options).ChannelCacheConfig)
+	return nil
+}
+
+// Get the state of the database's resync advisor
+func (h *handler) handleGetResyncAdvice() error {
+	advice, ok := h.db.ResyncAdvice()
+	if !ok {
+		return base.HTTPErrorf(http.StatusNotFound, "Resync advisor is not enabled")
+	}
+	h.writeJSON(advice)
+	return nil
+}
+
+// Acknowledge the resync advisor's advice, clearing the needs_resync flag
+func (h *handler) handleDeleteResyncAdvice() error {
+	if !h.db.AcknowledgeResyncAdvice() {
+		return base.HTTPErrorf(http.StatusNotFound, "Resync advisor is not enabled")
+	}
+	return nil
+}
+
+// List the database's active import suppressions
+func (h *handler) handleGetImportSuppression() error {
+	h.writeJSON(h.db.ImportSuppressions())
+	return nil
+}
+
+// Suppress feed import of documents with the given key prefix, for ttl_secs (default one hour)
+func (h *handler) handlePutImportSuppression() error {
+	var input struct {
+		Prefix  string `json:"prefix"`
+		TTLSecs int    `json:"ttl_secs"`
+	}
+	if err := h.readJSONInto(&input); err != nil {
+		return err
+	}
+	if input.Prefix == "" {
+		return base.HTTPErrorf(http.StatusBadRequest, "prefix must be specified")
+	}
+	if input.TTLSecs < 0 {
+		return base.HTTPErrorf(http.StatusBadRequest, "ttl_secs must not be negative")
+	}
+	h.writeJSON(h.db.SuppressImport(input.Prefix, time.Duration(input.TTLSecs)*time.Second))
+	return nil
+}
+
+// Remove the import suppression for the key prefix given by the prefix query param
+func (h *handler) handleDeleteImportSuppression() error {
+	if !h.db.RemoveImportSuppression(h.getQuery("prefix")) {
+		return base.HTTPErrorf(http.StatusNotFound, "No import suppression for prefix")
 	}
 	return nil
 }
@@ -137,10 +564,17 @@ func (h *handler) handlePutDbConfig() error {
 	if err := config.setup(dbName); err != nil {
 		return err
 	}
+	changesFeedLimits, err := changesFeedLimitsFromConfig(config)
+	if err != nil {
+		return base.HTTPErrorf(http.StatusBadRequest, "%v", err)
+	}
 	h.server.lock.Lock()
 	defer h.server.lock.Unlock()
 	h.server.config.Databases[dbName] = config
 
+	// Limits on active changes feeds take effect immediately - other settings when the database is reloaded
+	h.db.ChangesFeedLimiter.SetLimits(changesFeedLimits)
+
 	return base.HTTPErrorf(http.StatusCreated, "created")
 }
 
@@ -835,6 +1269,13 @@ func (h *handler) handlePurge() error {
 	if len(docIDs) > 0 {
 		count := h.db.GetChangeCache().Remove(docIDs, startTime)
 		base.Debugf(base.KeyCache, "Purged %d items from caches", count)
+
+		// Notify other nodes, which will remove the purged docs from their caches when the markers arrive over the feed
+		for _, docID := range docIDs {
+			if err := h.db.WritePurgeMarker(docID); err != nil {
+				base.Warnf("Unable to write purge marker for doc %q - doc may remain in other nodes' caches: %v", base.UD(docID), err)
+			}
+		}
 	}
 
 	_, _ = h.response.Write([]byte("}\n}\n"))
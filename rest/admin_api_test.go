@@ -17,6 +17,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	sgbucket "github.com/couchbase/sg-bucket"
 	"github.com/couchbase/sync_gateway/auth"
 	"github.com/couchbase/sync_gateway/base"
 	"github.com/couchbase/sync_gateway/channels"
@@ -1197,6 +1199,27 @@ func TestDBGetConfigNames(t *testing.T) {
 
 }
 
+// Test that a database without a config reports the config as not found, rather than panicking
+func TestDBGetConfigMissing(t *testing.T) {
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	sc := rt.ServerContext()
+	sc.lock.Lock()
+	dbConfig := sc.config.Databases["db"]
+	delete(sc.config.Databases, "db")
+	sc.lock.Unlock()
+	defer func() {
+		sc.lock.Lock()
+		sc.config.Databases["db"] = dbConfig
+		sc.lock.Unlock()
+	}()
+
+	assertStatus(t, rt.SendAdminRequest(http.MethodGet, "/db/_config", ""), http.StatusNotFound)
+	assertStatus(t, rt.SendAdminRequest(http.MethodGet, "/db/_config?redact=false", ""), http.StatusNotFound)
+}
+
 //Take DB offline and ensure can post _resync
 func TestDBOfflinePostResync(t *testing.T) {
 
@@ -2696,6 +2719,143 @@ func TestUserAndRoleResponseContentType(t *testing.T) {
 	require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &responseBody))
 }
 
+// Validates that a cache profile's settings are applied, that individual cache settings override them, and that the
+// effective settings are reported by the db config.
+func TestCacheProfile(t *testing.T) {
+	rt := NewRestTester(t, &RestTesterConfig{DatabaseConfig: &DbConfig{
+		CacheProfile: db.CacheProfileLowMemory,
+		CacheConfig: &CacheConfig{
+			ChannelCacheConfig: &ChannelCacheConfig{MaxLength: base.IntPtr(150)},
+		},
+	}})
+	defer rt.Close()
+
+	cacheOptions := rt.GetDatabase().Options.CacheOptions
+	require.NotNil(t, cacheOptions)
+	assert.Equal(t, db.CacheProfileLowMemory, cacheOptions.Profile)
+	assert.Equal(t, 150, cacheOptions.ChannelCacheMaxLength)
+	assert.Equal(t, 20, cacheOptions.ChannelCacheMinLength)
+	assert.Equal(t, 30*time.Second, cacheOptions.ChannelCacheAge)
+
+	var dbConfig DbConfig
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_config?redact=false", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &dbConfig))
+	require.NotNil(t, dbConfig.CacheConfig)
+	require.NotNil(t, dbConfig.CacheConfig.ChannelCacheConfig)
+	assert.Equal(t, 150, *dbConfig.CacheConfig.ChannelCacheConfig.MaxLength)
+	assert.Equal(t, 20, *dbConfig.CacheConfig.ChannelCacheConfig.MinLength)
+	assert.Equal(t, 30, *dbConfig.CacheConfig.ChannelCacheConfig.ExpirySeconds)
+	assert.Equal(t, uint32(db.DefaultCachePendingSeqMaxWait/time.Millisecond), *dbConfig.CacheConfig.ChannelCacheConfig.MaxWaitPending)
+
+	// Reporting the effective settings doesn't modify the database's config
+	assert.Nil(t, rt.ServerContext().GetDatabaseConfig("db").CacheConfig.ChannelCacheConfig.MinLength)
+}
+
+// Validates that cache options updated at runtime are reported as drift from the persisted config by
+// GET /{db}/_config?effective=true.
+func TestDbConfigEffectiveCacheOptions(t *testing.T) {
+	rt := NewRestTester(t, &RestTesterConfig{DatabaseConfig: &DbConfig{
+		CacheConfig: &CacheConfig{
+			ChannelCacheConfig: &ChannelCacheConfig{MaxNumPending: base.IntPtr(5000)},
+		},
+	}})
+	defer rt.Close()
+
+	getEffectiveConfig := func() EffectiveDbConfig {
+		var effectiveConfig EffectiveDbConfig
+		response := rt.SendAdminRequest(http.MethodGet, "/db/_config?effective=true", "")
+		assertStatus(t, response, http.StatusOK)
+		require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &effectiveConfig))
+		require.NotNil(t, effectiveConfig.Config)
+		require.NotNil(t, effectiveConfig.ChannelCache)
+		return effectiveConfig
+	}
+
+	// No drift before any update
+	effectiveConfig := getEffectiveConfig()
+	assert.Empty(t, effectiveConfig.Diff)
+	assert.Equal(t, 5000, *effectiveConfig.ChannelCache.MaxNumPending)
+
+	// Invalid updates are rejected
+	response := rt.SendAdminRequest(http.MethodPut, "/db/_cache/options", `{"max_num_pending": 0}`)
+	assertStatus(t, response, http.StatusBadRequest)
+
+	beforeUpdate := time.Now()
+	response = rt.SendAdminRequest(http.MethodPut, "/db/_cache/options", `{"max_num_pending": 100, "max_wait_skipped": 1000}`)
+	assertStatus(t, response, http.StatusOK)
+	assert.Equal(t, 100, rt.GetDatabase().GetChangeCache().GetOptions().CachePendingSeqMaxNum)
+	assert.Equal(t, time.Second, rt.GetDatabase().GetChangeCache().GetOptions().CacheSkippedSeqMaxWait)
+
+	effectiveConfig = getEffectiveConfig()
+	assert.Equal(t, 100, *effectiveConfig.ChannelCache.MaxNumPending)
+	assert.Equal(t, uint32(1000), *effectiveConfig.ChannelCache.MaxWaitSkipped)
+	require.Len(t, effectiveConfig.Diff, 2)
+
+	assert.Equal(t, "cache.channel_cache.max_num_pending", effectiveConfig.Diff[0].Field)
+	assert.Equal(t, float64(5000), effectiveConfig.Diff[0].Persisted)
+	assert.Equal(t, float64(100), effectiveConfig.Diff[0].Effective)
+	require.NotNil(t, effectiveConfig.Diff[0].LastModified)
+	assert.False(t, effectiveConfig.Diff[0].LastModified.Before(beforeUpdate))
+
+	// Unset in the persisted config, so compared against the default
+	assert.Equal(t, "cache.channel_cache.max_wait_skipped", effectiveConfig.Diff[1].Field)
+	assert.Equal(t, float64(db.DefaultSkippedSeqMaxWait/time.Millisecond), effectiveConfig.Diff[1].Persisted)
+	assert.Equal(t, float64(1000), effectiveConfig.Diff[1].Effective)
+	require.NotNil(t, effectiveConfig.Diff[1].LastModified)
+
+	// Updating back to the persisted value removes the drift, and the persisted config is unchanged
+	response = rt.SendAdminRequest(http.MethodPut, "/db/_cache/options", `{"max_num_pending": 5000}`)
+	assertStatus(t, response, http.StatusOK)
+	effectiveConfig = getEffectiveConfig()
+	require.Len(t, effectiveConfig.Diff, 1)
+	assert.Equal(t, "cache.channel_cache.max_wait_skipped", effectiveConfig.Diff[0].Field)
+	assert.Equal(t, 5000, *rt.ServerContext().GetDatabaseConfig("db").CacheConfig.ChannelCacheConfig.MaxNumPending)
+}
+
+// Validates that changes made while the cache is paused are served once it's resumed.
+func TestCachePauseResume(t *testing.T) {
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	getChanges := func() changesResults {
+		var changes changesResults
+		response := rt.SendAdminRequest(http.MethodGet, "/db/_changes?filter=sync_gateway/bychannel&channels=ABC", "")
+		assertStatus(t, response, http.StatusOK)
+		require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &changes))
+		return changes
+	}
+
+	rt.putDoc("doc1", `{"channels":["ABC"]}`)
+	require.NoError(t, rt.WaitForPendingChanges())
+	getChanges().requireDocIDs(t, []string{"doc1"})
+
+	var status db.CachePauseStatus
+	response := rt.SendAdminRequest(http.MethodPost, "/db/_cache/pause", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &status))
+	assert.True(t, status.Paused)
+	assert.NotZero(t, status.PausedAt)
+
+	// Changes made while paused aren't served until the cache is resumed
+	rt.putDoc("doc2", `{"channels":["ABC"]}`)
+	require.NoError(t, rt.WaitForPendingChanges())
+	getChanges().requireDocIDs(t, []string{"doc1"})
+
+	var diagnostics CacheDiagnostics
+	response = rt.SendAdminRequest(http.MethodGet, "/db/_cache", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &diagnostics))
+	assert.Equal(t, status, diagnostics.PauseStatus)
+
+	response = rt.SendAdminRequest(http.MethodPost, "/db/_cache/resume", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &status))
+	assert.False(t, status.Paused)
+	assert.Greater(t, status.ResumedAt, status.PausedAt)
+	getChanges().requireDocIDs(t, []string{"doc1", "doc2"})
+}
+
 func TestConfigRedaction(t *testing.T) {
 	rt := NewRestTester(t, &RestTesterConfig{DatabaseConfig: &DbConfig{Users: map[string]*db.PrincipalConfig{"alice": {Password: base.StringPtr("password")}}}})
 	defer rt.Close()
@@ -2966,3 +3126,542 @@ func TestObtainUserChannelsForDeletedRoleCasFail(t *testing.T) {
 
 	}
 }
+
+// Verifies that feed parse failures are returned by the _cache endpoint when strict feed parsing is enabled, with doc
+// IDs tagged as user data when redaction is enabled
+func TestCacheParseFailures(t *testing.T) {
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DbConfig{Unsupported: db.UnsupportedOptions{StrictFeedParsing: true}},
+	})
+	defer rt.Close()
+
+	rt.GetDatabase().GetChangeCache().DocChanged(sgbucket.FeedEvent{
+		Synchronous: true,
+		Key:         []byte("corruptDoc"),
+		Value:       []byte(`{"_sync":{"rev":"1-abc","sequence":"not-a-number"}}`),
+		DataType:    base.MemcachedDataTypeJSON,
+	})
+
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_cache", "")
+	assertStatus(t, response, http.StatusOK)
+
+	var diagnostics CacheDiagnostics
+	require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &diagnostics))
+	require.Len(t, diagnostics.ParseFailures, 1)
+	assert.Equal(t, "corruptDoc", diagnostics.ParseFailures[0].DocID)
+	assert.Equal(t, int64(1), rt.GetDatabase().DbStats.Cache().FeedParseErrorCount.Value())
+
+	defer func(redactUserData bool) { base.RedactUserData = redactUserData }(base.RedactUserData)
+	base.RedactUserData = true
+	response = rt.SendAdminRequest(http.MethodGet, "/db/_cache", "")
+	assertStatus(t, response, http.StatusOK)
+	diagnostics = CacheDiagnostics{}
+	require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &diagnostics))
+	require.Len(t, diagnostics.ParseFailures, 1)
+	assert.Equal(t, "<ud>corruptDoc</ud>", diagnostics.ParseFailures[0].DocID)
+}
+
+// Validates that skipped sequences are listed by the _cache endpoint, along with the pending queue state that caused
+// them to be skipped.
+func TestCacheSkippedSequences(t *testing.T) {
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DbConfig{
+			CacheConfig: &CacheConfig{
+				ChannelCacheConfig: &ChannelCacheConfig{MaxNumPending: base.IntPtr(1)},
+			},
+		},
+	})
+	defer rt.Close()
+
+	changeCache := rt.GetDatabase().GetChangeCache()
+	lastSeq := changeCache.LastSequence()
+
+	// Two pending sequences exceed max_num_pending, so the gap before them is skipped
+	for _, seq := range []uint64{lastSeq + 5, lastSeq + 6} {
+		changeCache.DocChanged(sgbucket.FeedEvent{
+			Opcode:       sgbucket.FeedOpMutation,
+			Synchronous:  true,
+			Key:          []byte(fmt.Sprintf("%s%d", base.UnusedSeqPrefix, seq)),
+			TimeReceived: time.Now(),
+		})
+	}
+
+	getDiagnostics := func(queryString string) CacheDiagnostics {
+		response := rt.SendAdminRequest(http.MethodGet, "/db/_cache"+queryString, "")
+		assertStatus(t, response, http.StatusOK)
+		var diagnostics CacheDiagnostics
+		require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &diagnostics))
+		return diagnostics
+	}
+
+	diagnostics := getDiagnostics("")
+	require.Len(t, diagnostics.SkippedSequences, 4)
+	for i, skipped := range diagnostics.SkippedSequences {
+		assert.Equal(t, lastSeq+uint64(i)+1, skipped.Sequence)
+		assert.Equal(t, lastSeq+5, skipped.PendingSeq)
+		assert.Equal(t, 2, skipped.PendingLen)
+		assert.False(t, skipped.TimeAdded.IsZero())
+	}
+
+	diagnostics = getDiagnostics("?skipped_count=2")
+	require.Len(t, diagnostics.SkippedSequences, 2)
+	assert.Equal(t, lastSeq+1, diagnostics.SkippedSequences[0].Sequence)
+}
+
+// Validates that the _cache/skipped endpoint reports skipped sequences, and that a POST abandons skipped sequences that
+// have been waiting longer than max_wait_skipped without waiting for the background task.
+func TestCacheSkippedSequenceReport(t *testing.T) {
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DbConfig{
+			CacheConfig: &CacheConfig{
+				ChannelCacheConfig: &ChannelCacheConfig{MaxNumPending: base.IntPtr(1)},
+			},
+		},
+	})
+	defer rt.Close()
+
+	changeCache := rt.GetDatabase().GetChangeCache()
+	lastSeq := changeCache.LastSequence()
+
+	// Two pending sequences exceed max_num_pending, so the gap before them is skipped
+	for _, seq := range []uint64{lastSeq + 5, lastSeq + 6} {
+		changeCache.DocChanged(sgbucket.FeedEvent{
+			Opcode:       sgbucket.FeedOpMutation,
+			Synchronous:  true,
+			Key:          []byte(fmt.Sprintf("%s%d", base.UnusedSeqPrefix, seq)),
+			TimeReceived: time.Now(),
+		})
+	}
+
+	getReport := func(method, queryString string) db.SkippedSequenceReport {
+		response := rt.SendAdminRequest(method, "/db/_cache/skipped"+queryString, "")
+		assertStatus(t, response, http.StatusOK)
+		var report db.SkippedSequenceReport
+		require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &report))
+		return report
+	}
+
+	report := getReport(http.MethodGet, "")
+	assert.Equal(t, lastSeq+7, report.NextSequence)
+	assert.Equal(t, int64(4), report.NumSkipped)
+	require.Len(t, report.Skipped, 4)
+	assert.Equal(t, lastSeq+1, report.Skipped[0].Sequence)
+	assert.Equal(t, 0, report.PendingLen)
+
+	report = getReport(http.MethodGet, "?limit=2")
+	assert.Equal(t, int64(4), report.NumSkipped)
+	assert.Len(t, report.Skipped, 2)
+
+	// Sequences that haven't been skipped for longer than max_wait_skipped are left by a clean
+	report = getReport(http.MethodPost, "")
+	assert.Equal(t, int64(4), report.NumSkipped)
+
+	// The unused sequences aren't found by query, so are abandoned
+	response := rt.SendAdminRequest(http.MethodPut, "/db/_cache/options", `{"max_wait_skipped": 1}`)
+	assertStatus(t, response, http.StatusOK)
+	time.Sleep(10 * time.Millisecond)
+	report = getReport(http.MethodPost, "")
+	assert.Equal(t, int64(0), report.NumSkipped)
+	assert.Len(t, report.Skipped, 0)
+	assert.Equal(t, int64(4), rt.GetDatabase().DbStats.Cache().AbandonedSeqs.Value())
+}
+
+// Validates paging through the channel caches listed by the _cache endpoint.
+func TestCacheChannelListing(t *testing.T) {
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	// Changes requests create the channel caches
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_changes?filter=sync_gateway/bychannel&channels=A,B,C", "")
+	assertStatus(t, response, http.StatusOK)
+
+	getDiagnostics := func(queryString string) CacheDiagnostics {
+		response := rt.SendAdminRequest(http.MethodGet, "/db/_cache"+queryString, "")
+		assertStatus(t, response, http.StatusOK)
+		var diagnostics CacheDiagnostics
+		require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &diagnostics))
+		return diagnostics
+	}
+
+	diagnostics := getDiagnostics("?channel_count=2")
+	require.Len(t, diagnostics.Channels, 2)
+	assert.Equal(t, base.UD("A").Redact(), diagnostics.Channels[0].Name)
+	assert.Equal(t, base.UD("B").Redact(), diagnostics.Channels[1].Name)
+	require.NotEmpty(t, diagnostics.ChannelsCursor)
+
+	diagnostics = getDiagnostics("?channel_count=2&channel_cursor=" + url.QueryEscape(diagnostics.ChannelsCursor))
+	require.Len(t, diagnostics.Channels, 1)
+	assert.Equal(t, base.UD("C").Redact(), diagnostics.Channels[0].Name)
+	assert.Empty(t, diagnostics.ChannelsCursor)
+
+	response = rt.SendAdminRequest(http.MethodGet, "/db/_cache?channel_sort=bogus", "")
+	assertStatus(t, response, http.StatusBadRequest)
+}
+
+// Validates the resync advice endpoints, which are only available when the resync advisor is configured.
+func TestResyncAdviceEndpoints(t *testing.T) {
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DbConfig{
+			ResyncAdvisor: &ResyncAdvisorConfig{WindowSecs: base.Uint32Ptr(60), AbandonedSeqsThreshold: base.Int64Ptr(0)},
+		},
+	})
+	defer rt.Close()
+
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_resync_advice", "")
+	assertStatus(t, response, http.StatusOK)
+	var advice db.ResyncAdvice
+	require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &advice))
+	assert.False(t, advice.NeedsResync)
+	assert.Equal(t, int64(60), advice.WindowSecs)
+	assert.Equal(t, int64(0), advice.Thresholds["abandoned_seqs"])
+	assert.Equal(t, int64(db.DefaultResyncAdvisorChannelDivergenceThreshold), advice.Thresholds["channel_divergence"])
+
+	response = rt.SendAdminRequest(http.MethodDelete, "/db/_resync_advice", "")
+	assertStatus(t, response, http.StatusOK)
+
+	rtDisabled := NewRestTester(t, nil)
+	defer rtDisabled.Close()
+	response = rtDisabled.SendAdminRequest(http.MethodGet, "/db/_resync_advice", "")
+	assertStatus(t, response, http.StatusNotFound)
+	response = rtDisabled.SendAdminRequest(http.MethodDelete, "/db/_resync_advice", "")
+	assertStatus(t, response, http.StatusNotFound)
+}
+
+// Validates auditing a channel's cache against the channel query.
+func TestCacheChannelAudit(t *testing.T) {
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_changes?filter=sync_gateway/bychannel&channels=sales", "")
+	assertStatus(t, response, http.StatusOK)
+
+	cacheWaiter := rt.GetDatabase().NewDCPCachingCountWaiter(t)
+	for _, docID := range []string{"doc1", "doc2", "doc3"} {
+		response = rt.SendAdminRequest(http.MethodPut, "/db/"+docID, `{"channels":["sales"]}`)
+		assertStatus(t, response, http.StatusCreated)
+	}
+	cacheWaiter.AddAndWait(3)
+
+	audit := func(queryString string) db.ChannelAuditReport {
+		response := rt.SendAdminRequest(http.MethodPost, "/db/_cache/channel/sales/audit"+queryString, "")
+		assertStatus(t, response, http.StatusOK)
+		var report db.ChannelAuditReport
+		require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &report))
+		return report
+	}
+
+	report := audit("")
+	assert.True(t, report.Consistent)
+	assert.Equal(t, 3, report.CachedCount)
+	assert.Equal(t, 3, report.QueryCount)
+
+	report = audit("?limit=2")
+	assert.True(t, report.Consistent)
+	assert.True(t, report.Truncated)
+	assert.Equal(t, 2, report.CachedCount)
+
+	response = rt.SendAdminRequest(http.MethodPost, "/db/_cache/channel/other/audit", "")
+	assertStatus(t, response, http.StatusNotFound)
+}
+
+// Validates listing the entries in a single channel cache, and that deleting the cache forces a backfill on next read.
+func TestCacheChannelContents(t *testing.T) {
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	// A changes request creates the channel cache, which then caches the docs from the feed
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_changes?filter=sync_gateway/bychannel&channels=sales", "")
+	assertStatus(t, response, http.StatusOK)
+
+	testDb := rt.GetDatabase()
+	cacheWaiter := testDb.NewDCPCachingCountWaiter(t)
+	docIDs := []string{"doc1", "doc2", "doc3"}
+	for _, docID := range docIDs {
+		response = rt.SendAdminRequest(http.MethodPut, "/db/"+docID, `{"channels":["sales"]}`)
+		assertStatus(t, response, http.StatusCreated)
+	}
+	cacheWaiter.AddAndWait(len(docIDs))
+
+	getContents := func(queryString string) db.ChannelCacheContents {
+		response := rt.SendAdminRequest(http.MethodGet, "/db/_cache/channel/sales"+queryString, "")
+		assertStatus(t, response, http.StatusOK)
+		var contents db.ChannelCacheContents
+		require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &contents))
+		return contents
+	}
+
+	contents := getContents("")
+	assert.Equal(t, base.UD("sales").Redact(), contents.Name)
+	assert.Equal(t, len(docIDs), contents.Size)
+	require.Len(t, contents.Entries, len(docIDs))
+	for i, entry := range contents.Entries {
+		assert.Equal(t, base.UD(docIDs[i]).Redact(), entry.DocID)
+		assert.True(t, strings.HasPrefix(entry.RevID, "1-"))
+		assert.False(t, entry.Removal)
+		assert.False(t, entry.TimeReceived.IsZero())
+		if i > 0 {
+			assert.Greater(t, entry.Sequence, contents.Entries[i-1].Sequence)
+		}
+	}
+
+	page := getContents(fmt.Sprintf("?since=%d&limit=1", contents.Entries[0].Sequence))
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, contents.Entries[1], page.Entries[0])
+
+	response = rt.SendAdminRequest(http.MethodGet, "/db/_cache/channel/other", "")
+	assertStatus(t, response, http.StatusNotFound)
+
+	// The channel is only cached in the default collection
+	response = rt.SendAdminRequest(http.MethodGet, "/db/_cache/channel/sales?collection=_default", "")
+	assertStatus(t, response, http.StatusOK)
+	response = rt.SendAdminRequest(http.MethodGet, "/db/_cache/channel/sales?collection=products", "")
+	assertStatus(t, response, http.StatusNotFound)
+	response = rt.SendAdminRequest(http.MethodDelete, "/db/_cache/channel/sales?collection=products", "")
+	assertStatus(t, response, http.StatusNotFound)
+
+	// Delete the cache, then check the next changes request backfills it by query
+	response = rt.SendAdminRequest(http.MethodDelete, "/db/_cache/channel/sales", "")
+	assertStatus(t, response, http.StatusOK)
+	response = rt.SendAdminRequest(http.MethodGet, "/db/_cache/channel/sales", "")
+	assertStatus(t, response, http.StatusNotFound)
+	response = rt.SendAdminRequest(http.MethodDelete, "/db/_cache/channel/sales", "")
+	assertStatus(t, response, http.StatusNotFound)
+
+	queryCount := testDb.DbStats.Cache().ViewQueries.Value()
+	changes, err := rt.WaitForChanges(len(docIDs), "/db/_changes?filter=sync_gateway/bychannel&channels=sales", "", true)
+	require.NoError(t, err)
+	changes.requireDocIDs(t, docIDs)
+	assert.Equal(t, queryCount+1, testDb.DbStats.Cache().ViewQueries.Value())
+	assert.Len(t, getContents("").Entries, len(docIDs))
+}
+
+func TestMetadataPurge(t *testing.T) {
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DbConfig{
+			Users: map[string]*db.PrincipalConfig{"alice": {Password: base.StringPtr("letmein")}},
+		},
+	})
+	defer rt.Close()
+
+	revBodyKeys := []string{base.RevBodyPrefix + "a", base.RevBodyPrefix + "b", base.RevBodyPrefix + "c"}
+	checkpointKey := base.DCPCheckpointPrefix + "0"
+	for _, key := range append(revBodyKeys, checkpointKey) {
+		require.NoError(t, rt.Bucket().Set(key, 0, map[string]interface{}{"key": key}))
+	}
+
+	// A conflicting document, with the large body of its non-winning revision stored externally
+	largeValue := strings.Repeat("x", db.MaximumInlineBodySize)
+	for _, revID := range []string{"a", "b"} {
+		body := fmt.Sprintf(`{"value": %q, "_revisions": {"start": 2, "ids": [%q, "x"]}}`, largeValue, revID)
+		assertStatus(t, rt.SendAdminRequest(http.MethodPut, "/db/live?new_edits=false", body), http.StatusCreated)
+	}
+
+	assertStatus(t, rt.SendAdminRequest(http.MethodPost, "/db/_metadata/purge", ""), http.StatusBadRequest)
+	assertStatus(t, rt.SendAdminRequest(http.MethodPost, "/db/_metadata/purge?type=user", ""), http.StatusBadRequest)
+
+	// Defaults to dry run.  The live checkpoint and the body of the live document's revision aren't matched
+	var result base.MetadataPurgeResult
+	response := rt.SendAdminRequest(http.MethodPost, "/db/_metadata/purge?type=rev_body,dcp_checkpoint", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &result))
+	assert.True(t, result.DryRun)
+	assert.Equal(t, 3, result.Matched[base.RevBodyPrefix])
+	assert.Equal(t, 0, result.Matched[base.DCPCheckpointPrefix])
+	assert.Equal(t, 0, result.Purged[base.RevBodyPrefix])
+
+	// Apply for rev bodies only
+	result = base.MetadataPurgeResult{}
+	response = rt.SendAdminRequest(http.MethodPost, "/db/_metadata/purge?type=rev_body&dry_run=false", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &result))
+	assert.False(t, result.DryRun)
+	assert.Equal(t, 3, result.Purged[base.RevBodyPrefix])
+
+	for _, key := range revBodyKeys {
+		_, _, err := rt.Bucket().GetRaw(key)
+		assert.True(t, base.IsDocNotFoundError(err), "Expected %q to be purged", key)
+	}
+	rt.GetDatabase().FlushRevisionCacheForTest()
+	assertStatus(t, rt.SendAdminRequest(http.MethodGet, "/db/live?rev=2-a", ""), http.StatusOK)
+	_, _, err := rt.Bucket().GetRaw(checkpointKey)
+	assert.NoError(t, err)
+	_, _, err = rt.Bucket().GetRaw(base.SyncSeqKey)
+	assert.NoError(t, err)
+	_, _, err = rt.Bucket().GetRaw(base.UserPrefix + "alice")
+	assert.NoError(t, err)
+
+	// Checkpoints are purged once their group has been inactive for max_age_secs, and those of the active group never
+	require.NoError(t, base.SetActiveDCPCheckpointGroup(rt.Bucket(), "old"))
+	require.NoError(t, base.SetActiveDCPCheckpointGroup(rt.Bucket(), "new"))
+	oldCheckpointKey := base.DCPCheckpointKey("old", 0)
+	newCheckpointKey := base.DCPCheckpointKey("new", 0)
+	for _, key := range []string{oldCheckpointKey, newCheckpointKey} {
+		require.NoError(t, rt.Bucket().SetRaw(key, 0, []byte(`{}`)))
+	}
+
+	result = base.MetadataPurgeResult{}
+	response = rt.SendAdminRequest(http.MethodPost, "/db/_metadata/purge?type=dcp_checkpoint&dry_run=false", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &result))
+	assert.Equal(t, 0, result.Purged[base.DCPCheckpointPrefix])
+
+	result = base.MetadataPurgeResult{}
+	response = rt.SendAdminRequest(http.MethodPost, "/db/_metadata/purge?type=dcp_checkpoint&dry_run=false&max_age_secs=0", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &result))
+	assert.Equal(t, 2, result.Purged[base.DCPCheckpointPrefix])
+	for _, key := range []string{checkpointKey, oldCheckpointKey} {
+		_, _, err := rt.Bucket().GetRaw(key)
+		assert.True(t, base.IsDocNotFoundError(err), "Expected %q to be purged", key)
+	}
+	_, _, err = rt.Bucket().GetRaw(newCheckpointKey)
+	assert.NoError(t, err)
+}
+
+func TestGetStaleDCPCheckpoints(t *testing.T) {
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	require.NoError(t, base.SetActiveDCPCheckpointGroup(rt.Bucket(), "old"))
+	require.NoError(t, rt.Bucket().SetRaw(base.DCPCheckpointKey("old", 0), 0, []byte(`{}`)))
+	require.NoError(t, base.SetActiveDCPCheckpointGroup(rt.Bucket(), "new"))
+	require.NoError(t, rt.Bucket().SetRaw(base.DCPCheckpointKey("new", 0), 0, []byte(`{}`)))
+
+	// Default max age - nothing stale yet
+	var result base.DCPCheckpointGCResult
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_metadata/dcp_checkpoints", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &result))
+	assert.True(t, result.DryRun)
+	assert.Equal(t, "new", result.ActiveGroup)
+	assert.Empty(t, result.Keys)
+
+	result = base.DCPCheckpointGCResult{}
+	response = rt.SendAdminRequest(http.MethodGet, "/db/_metadata/dcp_checkpoints?max_age_secs=0", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &result))
+	assert.Equal(t, []string{base.DCPCheckpointKey("old", 0)}, result.Keys)
+
+	// Dry run doesn't delete
+	_, _, err := rt.Bucket().GetRaw(base.DCPCheckpointKey("old", 0))
+	assert.NoError(t, err)
+}
+
+// Validates that metadata usage scans count the metadata documents under each prefix, in the foreground and background.
+func TestMetadataUsage(t *testing.T) {
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_metadata/usage", "")
+	assertStatus(t, response, http.StatusNotFound)
+
+	expectedBytes := make(map[string]int64)
+	for prefix, count := range map[string]int{base.AttPrefix: 2, base.UnusedSeqPrefix: 3} {
+		for i := 0; i < count; i++ {
+			key := fmt.Sprintf("%s%d", prefix, i)
+			value := []byte(`{"usage":"test"}`)
+			require.NoError(t, rt.Bucket().SetRaw(key, 0, value))
+			expectedBytes[prefix] += int64(len(key) + len(value))
+		}
+	}
+	response = rt.SendAdminRequest(http.MethodPut, "/db/_local/checkpoint", `{"seq":1}`)
+	assertStatus(t, response, http.StatusCreated)
+	localPrefix := base.SyncPrefix + db.DocTypeLocal + ":"
+
+	var usage db.MetadataUsage
+	response = rt.SendAdminRequest(http.MethodPost, "/db/_metadata/usage?ops_per_sec=1000", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &usage))
+	assert.Equal(t, db.MetadataUsageStateCompleted, usage.Status)
+	require.NotNil(t, usage.Prefixes[base.AttPrefix])
+	assert.Equal(t, int64(2), usage.Prefixes[base.AttPrefix].Count)
+	assert.Equal(t, expectedBytes[base.AttPrefix], usage.Prefixes[base.AttPrefix].Bytes)
+	require.NotNil(t, usage.Prefixes[base.UnusedSeqPrefix])
+	assert.Equal(t, int64(3), usage.Prefixes[base.UnusedSeqPrefix].Count)
+	assert.Equal(t, expectedBytes[base.UnusedSeqPrefix], usage.Prefixes[base.UnusedSeqPrefix].Bytes)
+	require.NotNil(t, usage.Prefixes[localPrefix])
+	assert.Equal(t, int64(1), usage.Prefixes[localPrefix].Count)
+
+	var totalCount int64
+	for _, prefixUsage := range usage.Prefixes {
+		totalCount += prefixUsage.Count
+	}
+	assert.Equal(t, totalCount, usage.TotalCount)
+	assert.Equal(t, totalCount, rt.GetDatabase().DbStats.Database().MetadataDocCount.Value())
+
+	// A background scan returns immediately, and its result is retrieved once complete
+	response = rt.SendAdminRequest(http.MethodPost, "/db/_metadata/usage?background=true", "")
+	assertStatus(t, response, http.StatusAccepted)
+	var backgroundUsage db.MetadataUsage
+	require.NoError(t, rt.WaitForCondition(func() bool {
+		response := rt.SendAdminRequest(http.MethodGet, "/db/_metadata/usage", "")
+		assertStatus(t, response, http.StatusOK)
+		require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &backgroundUsage))
+		return backgroundUsage.Status == db.MetadataUsageStateCompleted
+	}))
+	assert.Equal(t, usage.TotalCount, backgroundUsage.TotalCount)
+	assert.Equal(t, usage.Prefixes, backgroundUsage.Prefixes)
+}
+
+// Validates that a database with a corrupt sequence counter loads offline, and can be brought online after repair.
+func TestRepairSequence(t *testing.T) {
+
+	testBucket := base.GetTestBucket(t)
+	require.NoError(t, testBucket.SetRaw(base.SyncSeqKey, 0, []byte("not-a-sequence")))
+
+	rt := NewRestTester(t, &RestTesterConfig{TestBucket: testBucket})
+	defer rt.Close()
+
+	getDatabaseRoot := func() DatabaseRoot {
+		resp := rt.SendAdminRequest(http.MethodGet, "/db/", "")
+		assertStatus(t, resp, http.StatusOK)
+		var dbRoot DatabaseRoot
+		require.NoError(t, base.JSONUnmarshal(resp.BodyBytes(), &dbRoot))
+		return dbRoot
+	}
+
+	// Database loads offline, with the corruption reported to admins
+	dbRoot := getDatabaseRoot()
+	assert.Equal(t, db.RunStateString[db.DBOffline], dbRoot.State)
+	assert.Contains(t, dbRoot.Error, "corrupt")
+
+	// Without confirmation, reports the value that would be written
+	resp := rt.SendAdminRequest(http.MethodPost, "/db/_repair_sequence?sequence=10", "")
+	assertStatus(t, resp, http.StatusOK)
+	var result db.SequenceRepairResult
+	require.NoError(t, base.JSONUnmarshal(resp.BodyBytes(), &result))
+	assert.True(t, result.DryRun)
+	assert.Equal(t, "not-a-sequence", result.PreviousValue)
+	assert.Equal(t, uint64(10), result.Sequence)
+	rawValue, _, err := rt.Bucket().GetRaw(base.SyncSeqKey)
+	require.NoError(t, err)
+	assert.Equal(t, "not-a-sequence", string(rawValue))
+
+	resp = rt.SendAdminRequest(http.MethodPost, "/db/_repair_sequence?sequence=10&confirm=true", "")
+	assertStatus(t, resp, http.StatusOK)
+	rawValue, _, err = rt.Bucket().GetRaw(base.SyncSeqKey)
+	require.NoError(t, err)
+	assert.Equal(t, "10", string(rawValue))
+	assert.Empty(t, getDatabaseRoot().Error)
+
+	// Repair refuses to lower the counter
+	resp = rt.SendAdminRequest(http.MethodPost, "/db/_repair_sequence?sequence=5&confirm=true", "")
+	assertStatus(t, resp, http.StatusConflict)
+
+	// Database can be brought online once repaired, and allocates sequences from the repaired counter
+	resp = rt.SendAdminRequest(http.MethodPost, "/db/_online", `{"delay": 0}`)
+	assertStatus(t, resp, http.StatusOK)
+	err, _ = base.RetryLoop("wait for online", func() (bool, error, interface{}) {
+		return getDatabaseRoot().State != db.RunStateString[db.DBOnline], nil, nil
+	}, base.CreateSleeperFunc(100, 50))
+	require.NoError(t, err)
+
+	resp = rt.SendAdminRequest(http.MethodPut, "/db/doc1", `{"foo": "bar"}`)
+	assertStatus(t, resp, http.StatusCreated)
+	dbRoot = getDatabaseRoot()
+	assert.Greater(t, dbRoot.SequenceNumber, uint64(10))
+
+	// Repair requires the database to be offline
+	resp = rt.SendAdminRequest(http.MethodPost, "/db/_repair_sequence?sequence=100&confirm=true", "")
+	assertStatus(t, resp, http.StatusServiceUnavailable)
+}
@@ -16,6 +16,7 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -35,10 +36,12 @@ const (
 )
 
 type rootResponse struct {
-	Admin   bool   `json:"ADMIN,omitempty"`
-	CouchDB string `json:"couchdb,omitempty"` // TODO: Lithium - remove couchdb welcome
-	Vendor  vendor `json:"vendor,omitempty"`
-	Version string `json:"version,omitempty"`
+	Admin        bool   `json:"ADMIN,omitempty"`
+	CouchDB      string `json:"couchdb,omitempty"` // TODO: Lithium - remove couchdb welcome
+	Vendor       vendor `json:"vendor,omitempty"`
+	Version      string `json:"version,omitempty"`
+	Health       string `json:"health,omitempty"`         // Worst change cache health across databases, when health hints are enabled
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"` // Suggested client backoff when health is degraded
 }
 
 type vendor struct {
@@ -61,6 +64,12 @@ func (h *handler) handleRoot() error {
 		resp.Vendor.Version = base.ProductVersionNumber
 	}
 
+	if h.server.config.HealthHints {
+		health := h.server.cacheHealth()
+		resp.Health = health.Status
+		resp.RetryAfterMs = health.RetryAfterMs
+	}
+
 	h.writeJSON(resp)
 	return nil
 }
@@ -70,13 +79,29 @@ func (h *handler) handleAllDbs() error {
 	return nil
 }
 
+// Purges tombstones older than the purge interval.  With background=true, returns the initial compaction status
+// immediately, and progress is retrieved with a GET.  Purges are paced to ops_per_sec, defaulting to the configured rate.
 func (h *handler) handleCompact() error {
-	revsDeleted, err := h.db.Compact()
+	background, _ := h.getOptBoolQuery("background", false)
+	status, err := h.db.StartCompact(int(h.getIntQuery("ops_per_sec", uint64(h.db.Options.CompactOpsPerSec))), background)
 	if err != nil {
 		return err
 	}
+	if background {
+		h.writeJSONStatus(http.StatusAccepted, status)
+	} else {
+		h.writeRawJSON([]byte(`{"revs":` + strconv.Itoa(status.Purged) + `}`))
+	}
+	return nil
+}
 
-	h.writeRawJSON([]byte(`{"revs":` + strconv.Itoa(revsDeleted) + `}`))
+// Returns the status of the most recent tombstone compaction, or its progress while running
+func (h *handler) handleGetCompact() error {
+	status := h.db.CompactionStatus()
+	if status == nil {
+		return base.HTTPErrorf(http.StatusNotFound, "No compaction has been run")
+	}
+	h.writeJSON(status)
 	return nil
 }
 
@@ -90,6 +115,113 @@ func (h *handler) handleVacuum() error {
 	return nil
 }
 
+// metadataPurgePrefixes maps the metadata types accepted by _metadata/purge to their document key prefixes
+var metadataPurgePrefixes = map[string][]string{
+	"rev_body":       {base.RevBodyPrefix},
+	"dcp_checkpoint": {base.DCPCheckpointPrefix},
+	"unused_seq":     {base.UnusedSeqPrefix, base.UnusedSeqRangePrefix},
+}
+
+// Purges orphaned metadata documents of the types specified by the 'type' query parameter.  DCP checkpoints are only
+// purged once their checkpoint group has been inactive for the configured checkpoint GC max age, unless overridden by
+// the 'max_age_secs' query parameter.  Only reports the number of matching documents unless dry_run=false.
+func (h *handler) handleMetadataPurge() error {
+	types := h.getQuery("type")
+	if types == "" {
+		return base.HTTPErrorf(http.StatusBadRequest, "Metadata type must be specified - valid types are rev_body, dcp_checkpoint, unused_seq")
+	}
+
+	var prefixes []string
+	for _, metadataType := range strings.Split(types, ",") {
+		typePrefixes, ok := metadataPurgePrefixes[metadataType]
+		if !ok {
+			return base.HTTPErrorf(http.StatusBadRequest, "Unknown metadata type %q - valid types are rev_body, dcp_checkpoint, unused_seq", metadataType)
+		}
+		prefixes = append(prefixes, typePrefixes...)
+	}
+
+	dryRun, _ := h.getOptBoolQuery("dry_run", true)
+	result, err := h.db.PurgeMetadata(prefixes, h.getCheckpointMaxAge(), dryRun)
+	if err != nil {
+		return err
+	}
+	h.writeJSON(result)
+	return nil
+}
+
+// Lists the DCP checkpoint documents for inactive checkpoint groups that would be deleted by checkpoint collection,
+// using the configured max age unless overridden by the 'max_age_secs' query parameter.
+func (h *handler) handleGetStaleDCPCheckpoints() error {
+	result, err := h.db.CollectStaleDCPCheckpoints(h.getCheckpointMaxAge(), true)
+	if err != nil {
+		return err
+	}
+	h.writeJSON(result)
+	return nil
+}
+
+// getCheckpointMaxAge returns the age after which inactive DCP checkpoint groups are stale - the 'max_age_secs' query
+// parameter, defaulting to the configured checkpoint GC max age.
+func (h *handler) getCheckpointMaxAge() time.Duration {
+	maxAge := h.db.Options.ImportOptions.CheckpointGCMaxAge
+	if maxAge <= 0 {
+		maxAge = base.DefaultDCPCheckpointGCMaxAge
+	}
+	return time.Duration(h.getIntQuery("max_age_secs", uint64(maxAge.Seconds()))) * time.Second
+}
+
+// Reports how many of a sample of old revision backups have no expiry.  The 'sample' query parameter sets the number
+// of backups sampled.
+func (h *handler) handleGetOldRevExpiry() error {
+	result, err := h.db.ScanOldRevExpiry(int(h.getIntQuery("sample", db.DefaultOldRevExpiryScanSize)))
+	if err != nil {
+		return err
+	}
+	h.writeJSON(result)
+	return nil
+}
+
+// Scans the database's metadata documents, reporting their number and size by key prefix.  With background=true,
+// returns immediately and the result is retrieved with a GET.  Reads are paced to ops_per_sec.
+func (h *handler) handlePostMetadataUsage() error {
+	background, _ := h.getOptBoolQuery("background", false)
+	usage, err := h.db.StartMetadataUsageScan(int(h.getIntQuery("ops_per_sec", db.DefaultMetadataUsageOpsPerSec)), background)
+	if err != nil {
+		return err
+	}
+	if background {
+		h.writeJSONStatus(http.StatusAccepted, usage)
+	} else {
+		h.writeJSON(usage)
+	}
+	return nil
+}
+
+// Returns the result of the most recent metadata usage scan, or its progress while running
+func (h *handler) handleGetMetadataUsage() error {
+	usage := h.db.MetadataUsage()
+	if usage == nil {
+		return base.HTTPErrorf(http.StatusNotFound, "No metadata usage scan has been run")
+	}
+	h.writeJSON(usage)
+	return nil
+}
+
+// Rewrites a corrupt or missing sequence counter, based on a search of the database's documents and an optional
+// 'sequence' query parameter.  Reports the value that would be written unless 'confirm=true' is specified.
+func (h *handler) handleRepairSequence() error {
+	if atomic.LoadUint32(&h.db.State) != db.DBOffline {
+		return base.HTTPErrorf(http.StatusServiceUnavailable, "Database must be _offline before calling _repair_sequence")
+	}
+	confirm, _ := h.getOptBoolQuery("confirm", false)
+	result, err := h.db.RepairSequence(h.getIntQuery("sequence", 0), !confirm)
+	if err != nil {
+		return err
+	}
+	h.writeJSON(result)
+	return nil
+}
+
 func (h *handler) handleFlush() error {
 
 	baseBucket := base.GetBaseBucket(h.db.Bucket)
@@ -256,6 +388,10 @@ type DatabaseRoot struct {
 	DiskFormatVersion             uint64 `json:"disk_format_version"`
 	State                         string `json:"state"`
 	ServerUUID                    string `json:"server_uuid,omitempty"`
+	Error                         string `json:"error,omitempty"`          // Reason the database can't be brought online, shown to admins
+	Health                        string `json:"health,omitempty"`         // Change cache health, when health hints are enabled
+	RetryAfterMs                  int64  `json:"retry_after_ms,omitempty"` // Suggested client backoff when health is degraded
+	NeedsResync                   bool   `json:"needs_resync,omitempty"`   // Whether the resync advisor has flagged the database, shown to admins
 }
 
 func (h *handler) handleGetDB() error {
@@ -283,6 +419,21 @@ func (h *handler) handleGetDB() error {
 		ServerUUID:                    h.db.DatabaseContext.GetServerUUID(),
 	}
 
+	if h.privs == adminPrivs {
+		if corruptionErr := h.db.SequenceCorruption(); corruptionErr != nil {
+			response.Error = corruptionErr.Error()
+		}
+		if advice, ok := h.db.ResyncAdvice(); ok {
+			response.NeedsResync = advice.NeedsResync
+		}
+	}
+
+	if h.server.config.HealthHints && runState == db.RunStateString[db.DBOnline] {
+		health := h.db.CacheHealth()
+		response.Health = health.Status
+		response.RetryAfterMs = health.RetryAfterMs
+	}
+
 	h.writeJSON(response)
 	return nil
 }
@@ -493,6 +644,16 @@ func (h *handler) handleStats() error {
 	return nil
 }
 
+// ADMIN API to describe the stats published under the expvars, for generating dashboards
+func (h *handler) handleStatsSchema() error {
+	schema, err := base.SgwStatsSchema()
+	if err != nil {
+		return base.HTTPErrorf(http.StatusInternalServerError, "Error describing stats: %v", err)
+	}
+	h.writeJSON(schema)
+	return nil
+}
+
 func (h *handler) handleMetrics() error {
 	promhttp.Handler().ServeHTTP(h.response, h.rq)
 
@@ -11,6 +11,7 @@ package rest
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -3911,7 +3912,7 @@ func TestWriteTombstonedDocUsingXattrs(t *testing.T) {
 	gocbBucket, _ := base.AsGoCBBucket(baseBucket)
 	var retrievedVal map[string]interface{}
 	var retrievedXattr map[string]interface{}
-	_, err = gocbBucket.SubdocGetBodyAndXattr("-21SK00U-ujxUO9fU2HezxL", base.SyncXattrName, "", &retrievedVal, &retrievedXattr, nil)
+	_, err = gocbBucket.SubdocGetBodyAndXattr(context.TODO(), "-21SK00U-ujxUO9fU2HezxL", base.SyncXattrName, "", &retrievedVal, &retrievedXattr, nil)
 	assert.NoError(t, err, "Unexpected Error")
 	assert.Equal(t, "2-466a1fab90a810dc0a63565b70680e4e", retrievedXattr["rev"])
 
@@ -5181,6 +5182,30 @@ func TestHandleStats(t *testing.T) {
 	assertStatus(t, response, http.StatusOK)
 }
 
+func TestHandleStatsSchema(t *testing.T) {
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	response := rt.SendAdminRequest(http.MethodGet, "/_stats/schema", "")
+	assertStatus(t, response, http.StatusOK)
+	assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+
+	var schema []base.StatSchema
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &schema))
+	require.NotEmpty(t, schema)
+
+	var found bool
+	for _, stat := range schema {
+		if stat.Path == "per_db.*.cache.high_seq_stable" {
+			found = true
+			assert.Equal(t, "counter", stat.Kind)
+			assert.Equal(t, "sequence", stat.Unit)
+			assert.NotEmpty(t, stat.Description)
+		}
+	}
+	assert.True(t, found, "Missing per_db.*.cache.high_seq_stable in %s", response.BodyBytes())
+}
+
 // Try to create session with invalid cert but valid credentials
 func TestSessionFail(t *testing.T) {
 	rt := NewRestTester(t, nil)
@@ -5674,7 +5699,7 @@ func TestUserXattrAutoImport(t *testing.T) {
 
 	// Get Xattr and ensure channel value set correctly
 	var syncData db.SyncData
-	_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData)
+	_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData)
 	assert.NoError(t, err)
 
 	assert.Equal(t, []string{channelName}, syncData.Channels.KeySet())
@@ -5689,7 +5714,7 @@ func TestUserXattrAutoImport(t *testing.T) {
 	assert.NoError(t, err)
 
 	var syncData2 db.SyncData
-	_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData2)
+	_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData2)
 	assert.NoError(t, err)
 
 	assert.Equal(t, syncData.Crc32c, syncData2.Crc32c)
@@ -5707,7 +5732,7 @@ func TestUserXattrAutoImport(t *testing.T) {
 	assert.NoError(t, err)
 
 	var syncData3 db.SyncData
-	_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData3)
+	_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData3)
 	assert.NoError(t, err)
 
 	assert.Equal(t, syncData2.Crc32c, syncData3.Crc32c)
@@ -5728,7 +5753,7 @@ func TestUserXattrAutoImport(t *testing.T) {
 	assert.Equal(t, int64(3), rt.GetDatabase().DbStats.CBLReplicationPush().SyncFunctionCount.Value())
 
 	var syncData4 db.SyncData
-	_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData4)
+	_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData4)
 	assert.NoError(t, err)
 
 	assert.Equal(t, base.Crc32cHashString(updateVal), syncData4.Crc32c)
@@ -5807,7 +5832,7 @@ func TestUserXattrOnDemandImportGET(t *testing.T) {
 
 	// Get sync data for doc and ensure user xattr has been used correctly to set channel
 	var syncData db.SyncData
-	_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData)
+	_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData)
 	assert.NoError(t, err)
 
 	assert.Equal(t, []string{channelName}, syncData.Channels.KeySet())
@@ -5821,7 +5846,7 @@ func TestUserXattrOnDemandImportGET(t *testing.T) {
 	assertStatus(t, resp, http.StatusOK)
 
 	var syncData2 db.SyncData
-	_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData2)
+	_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData2)
 	assert.NoError(t, err)
 
 	assert.Equal(t, syncData.Crc32c, syncData2.Crc32c)
@@ -5904,7 +5929,7 @@ func TestUserXattrOnDemandImportWrite(t *testing.T) {
 	assert.Equal(t, int64(3), rt.GetDatabase().DbStats.CBLReplicationPush().SyncFunctionCount.Value())
 
 	var syncData db.SyncData
-	_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData)
+	_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData)
 	assert.NoError(t, err)
 
 	assert.Equal(t, []string{channelName}, syncData.Channels.KeySet())
@@ -5995,7 +6020,7 @@ func TestRemovingUserXattr(t *testing.T) {
 
 			// Get sync data for doc and ensure user xattr has been used correctly to set channel
 			var syncData db.SyncData
-			_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData)
+			_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData)
 			assert.NoError(t, err)
 
 			assert.Equal(t, []string{channelName}, syncData.Channels.KeySet())
@@ -6013,7 +6038,7 @@ func TestRemovingUserXattr(t *testing.T) {
 
 			// Ensure old channel set with user xattr has been removed
 			var syncData2 db.SyncData
-			_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData2)
+			_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData2)
 			assert.NoError(t, err)
 
 			assert.Equal(t, uint64(3), syncData2.Channels[channelName].Seq)
@@ -6067,7 +6092,7 @@ func TestUserXattrAvoidRevisionIDGeneration(t *testing.T) {
 
 	// Get current sync data
 	var syncData db.SyncData
-	_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData)
+	_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData)
 	assert.NoError(t, err)
 
 	docRev, err := rt.GetDatabase().GetRevisionCacheForTest().Get(docKey, syncData.CurrentRev, true, false)
@@ -6087,7 +6112,7 @@ func TestUserXattrAvoidRevisionIDGeneration(t *testing.T) {
 
 	// Ensure import worked and sequence incremented but that sequence did not
 	var syncData2 db.SyncData
-	_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData2)
+	_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData2)
 	assert.NoError(t, err)
 
 	docRev2, err := rt.GetDatabase().GetRevisionCacheForTest().Get(docKey, syncData.CurrentRev, true, false)
@@ -6108,7 +6133,7 @@ func TestUserXattrAvoidRevisionIDGeneration(t *testing.T) {
 	assert.NoError(t, err)
 
 	var syncData3 db.SyncData
-	_, err = gocbBucket.SubdocGetXattr(docKey, base.SyncXattrName, &syncData2)
+	_, err = gocbBucket.SubdocGetXattr(context.TODO(), docKey, base.SyncXattrName, &syncData2)
 	assert.NoError(t, err)
 
 	assert.NotEqual(t, syncData2.CurrentRev, syncData3.CurrentRev)
@@ -7298,3 +7323,55 @@ func TestRevocationResumeSameRoleAndLowSeqCheck(t *testing.T) {
 	assert.True(t, changes.Results[1].Revoked)
 
 }
+
+// TestHealthHints ensures cache health and a retry hint are included in root and database root responses when
+// health hints are enabled, and reflect a degraded change cache.
+func TestHealthHints(t *testing.T) {
+	type healthResponse struct {
+		Health       *string `json:"health"`
+		RetryAfterMs *int64  `json:"retry_after_ms"`
+	}
+	getHealth := func(t *testing.T, rt *RestTester, path string) healthResponse {
+		resp := rt.SendRequest(http.MethodGet, path, "")
+		assertStatus(t, resp, http.StatusOK)
+		var health healthResponse
+		require.NoError(t, base.JSONUnmarshal(resp.BodyBytes(), &health))
+		return health
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		rt := NewRestTester(t, nil)
+		defer rt.Close()
+		require.NoError(t, rt.GetDatabase().AddSkippedSequenceForTest(100, time.Hour))
+
+		for _, path := range []string{"/", "/db/"} {
+			health := getHealth(t, rt, path)
+			assert.Nil(t, health.Health, "Unexpected health for %s", path)
+			assert.Nil(t, health.RetryAfterMs, "Unexpected retry hint for %s", path)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		rt := NewRestTester(t, &RestTesterConfig{healthHints: true})
+		defer rt.Close()
+
+		for _, path := range []string{"/", "/db/"} {
+			health := getHealth(t, rt, path)
+			require.NotNil(t, health.Health, "Missing health for %s", path)
+			assert.Equal(t, db.CacheHealthOK, *health.Health)
+			assert.Nil(t, health.RetryAfterMs, "Unexpected retry hint for %s", path)
+		}
+
+		// A sequence skipped for longer than the threshold degrades the cache
+		require.NoError(t, rt.GetDatabase().AddSkippedSequenceForTest(100, 5*db.CacheHealthSkippedAgeThreshold))
+
+		for _, path := range []string{"/", "/db/"} {
+			health := getHealth(t, rt, path)
+			require.NotNil(t, health.Health, "Missing health for %s", path)
+			assert.Equal(t, db.CacheHealthDegraded, *health.Health)
+			require.NotNil(t, health.RetryAfterMs, "Missing retry hint for %s", path)
+			assert.GreaterOrEqual(t, *health.RetryAfterMs, int64(db.CacheHealthBaseRetryAfter/time.Millisecond))
+			assert.LessOrEqual(t, *health.RetryAfterMs, int64(db.CacheHealthMaxRetryAfter/time.Millisecond))
+		}
+	})
+}
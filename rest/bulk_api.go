@@ -281,6 +281,9 @@ func (h *handler) handleRepair() error {
 	}
 
 	repairBucket := db.NewRepairBucket(h.db.Bucket)
+	if h.db.Options.QueryPaginationLimit > 0 {
+		repairBucket.ViewQueryPageSize = h.db.Options.QueryPaginationLimit
+	}
 
 	repairBucket.InitFrom(repairBucketParams)
 
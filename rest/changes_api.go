@@ -36,6 +36,12 @@ const kDefaultTimeoutMS = 5 * 60 * 1000
 // Maximum value of _changes?timeout property
 const kMaxTimeoutMS = 15 * 60 * 1000
 
+// Response header set when a _changes?limit property exceeding the database's max_changes_limit has been clamped
+const changesLimitClampedHeader = "X-Changes-Limit-Clamped"
+
+// Retry-After value (seconds) for changes feeds rejected by max_changes_feeds or soft_max_changes_feeds
+const changesFeedRetryAfterSecs = 30
+
 func (h *handler) handleRevsDiff() error {
 	var input map[string][]string
 	err := h.readJSONInto(&input)
@@ -104,6 +110,10 @@ func (h *handler) updateChangesOptionsFromQuery(feed *string, options *db.Change
 		options.IncludeDocs = (h.getBoolQuery("include_docs"))
 	}
 
+	if _, ok := values["no_initial_backfill"]; ok {
+		options.NoInitialBackfill = h.getBoolQuery("no_initial_backfill")
+	}
+
 	if _, ok := values["filter"]; ok {
 		*filter = h.getQuery("filter")
 	}
@@ -179,6 +189,7 @@ func (h *handler) handleChanges() error {
 		options.ActiveOnly = h.getBoolQuery("active_only")
 		options.IncludeDocs = h.getBoolQuery("include_docs")
 		options.Revocations = h.getBoolQuery("revocations")
+		options.NoInitialBackfill = h.getBoolQuery("no_initial_backfill")
 		filter = h.getQuery("filter")
 		channelsParam := h.getQuery("channels")
 		if channelsParam != "" {
@@ -248,6 +259,13 @@ func (h *handler) handleChanges() error {
 		feed = "normal"
 	}
 
+	// Bound the size of non-continuous responses.  Applied ahead of generating the feed, so that cache and query reads
+	// are bounded too.
+	var maxLimit int
+	if feed == "normal" || feed == "longpoll" {
+		maxLimit = h.applyMaxChangesLimit(&options)
+	}
+
 	// Get the channels as parameters to an imaginary "bychannel" filter.
 	// The default is all channels the user can access.
 	userChannels := base.SetOf(ch.AllChannelWildcard)
@@ -279,6 +297,15 @@ func (h *handler) handleChanges() error {
 		}
 	}
 
+	// Limit the number of active feeds that are held open
+	if feed == "longpoll" || feed == "continuous" || feed == "websocket" {
+		release, err := h.acquireChangesFeed()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
 	// Pull replication stats by type
 	if feed == "normal" {
 		h.db.DatabaseContext.DbStats.CBLReplicationPull().NumPullReplActiveOneShot.Add(1)
@@ -295,8 +322,20 @@ func (h *handler) handleChanges() error {
 	h.db.DatabaseContext.DbStats.Database().NumReplicationsTotal.Add(1)
 	defer h.db.DatabaseContext.DbStats.Database().NumReplicationsActive.Add(-1)
 
+	sinceReset := h.resetStaleEpochSince(&options)
+
+	if feed != "websocket" {
+		h.negotiateChangesEncoding()
+	}
+
 	options.Terminator = make(chan bool)
 
+	// The spans for each stage of the request are started from the request's span
+	var span base.Span
+	options.Ctx, span = base.StartSpan(h.db.Ctx, "changes", base.SpanAttribute{Key: "feed", Value: feed},
+		base.SpanAttribute{Key: "include_docs", Value: options.IncludeDocs})
+	defer span.End()
+
 	forceClose := false
 
 	var err error
@@ -304,13 +343,13 @@ func (h *handler) handleChanges() error {
 	switch feed {
 	case "normal":
 		if filter == "_doc_ids" {
-			err, forceClose = h.sendSimpleChanges(userChannels, options, docIdsArray)
+			err, forceClose = h.sendSimpleChanges(userChannels, options, docIdsArray, sinceReset, maxLimit)
 		} else {
-			err, forceClose = h.sendSimpleChanges(userChannels, options, nil)
+			err, forceClose = h.sendSimpleChanges(userChannels, options, nil, sinceReset, maxLimit)
 		}
 	case "longpoll":
 		options.Wait = true
-		err, forceClose = h.sendSimpleChanges(userChannels, options, nil)
+		err, forceClose = h.sendSimpleChanges(userChannels, options, nil, sinceReset, maxLimit)
 	case "continuous":
 		err, forceClose = h.sendContinuousChangesByHTTP(userChannels, options)
 	case "websocket":
@@ -334,9 +373,109 @@ func (h *handler) handleChanges() error {
 	return err
 }
 
-func (h *handler) sendSimpleChanges(channels base.Set, options db.ChangesOptions, docids []string) (error, bool) {
+// changesEncodings are the encodings changes responses may be compressed with, in order of preference.
+var changesEncodings = []string{encodingGzip, encodingDeflate}
+
+// negotiateChangesEncoding compresses the changes response with the encoding the client prefers, for every feed type.
+// Continuous feeds aren't otherwise compressed due to their content type - they're flushed after each batch of entries
+// and every heartbeat, which also flushes the compressor, so compression doesn't delay delivery.  The size of compressed
+// responses is recorded in the database's pull replication stats.
+func (h *handler) negotiateChangesEncoding() {
+	encoded, ok := h.response.(*EncodedResponseWriter)
+	if !ok {
+		return // Response compression is disabled, or the client doesn't accept a supported encoding
+	}
+	pullStats := h.db.DbStats.CBLReplicationPull()
+	encoded.compressAs(negotiateEncoding(h.rq.Header.Get("Accept-Encoding"), changesEncodings...), func(uncompressed, compressed int64) {
+		pullStats.ChangesUncompressedBytes.Add(uncompressed)
+		pullStats.ChangesCompressedBytes.Add(compressed)
+		totalUncompressed, totalCompressed := pullStats.ChangesUncompressedBytes.Value(), pullStats.ChangesCompressedBytes.Value()
+		pullStats.ChangesCompressionSaved.Set(totalUncompressed - totalCompressed)
+		if totalCompressed > 0 {
+			pullStats.ChangesCompressionRatio.Set(float64(totalUncompressed) / float64(totalCompressed))
+		}
+	})
+}
+
+// acquireChangesFeed counts a feed that's held open against the server's and database's limits on active feeds,
+// returning a 503 error when over either of them.  Guest feeds are also rejected over the database's soft limit.
+// Admin feeds aren't limited.  Returns a function that must be called when the feed ends.
+func (h *handler) acquireChangesFeed() (release func(), err error) {
+	if h.user == nil {
+		return func() {}, nil
+	}
+	guest := h.user.Name() == ""
+	if !h.server.changesFeeds.Acquire(guest) {
+		return nil, h.rejectChangesFeed("server", h.server.changesFeeds.Limits())
+	}
+	if !h.db.ChangesFeedLimiter.Acquire(guest) {
+		h.server.changesFeeds.Release()
+		return nil, h.rejectChangesFeed("database", h.db.ChangesFeedLimiter.Limits())
+	}
+	return func() {
+		h.db.ChangesFeedLimiter.Release()
+		h.server.changesFeeds.Release()
+	}, nil
+}
+
+func (h *handler) rejectChangesFeed(scope string, limits db.ChangesFeedLimits) error {
+	base.InfofCtx(h.db.Ctx, base.KeyChanges, "Rejecting changes feed for %s - too many active feeds for %s (max: %d, soft max: %d)",
+		base.UD(h.user.Name()), scope, limits.Max, limits.SoftMax)
+	h.setHeader("Retry-After", strconv.Itoa(changesFeedRetryAfterSecs))
+	return base.HTTPErrorf(http.StatusServiceUnavailable, "Too many active changes feeds - try again later")
+}
+
+// applyMaxChangesLimit bounds options.Limit by the database's max_changes_limit.  Requests without a limit have the
+// maximum applied implicitly, and larger limits are clamped to it (noted in a response header).  Returns the maximum
+// when it's been applied, otherwise zero.
+func (h *handler) applyMaxChangesLimit(options *db.ChangesOptions) int {
+	maxLimit := h.db.Options.MaxChangesLimit
+	if maxLimit <= 0 {
+		return 0
+	}
+	if options.Limit <= 0 {
+		options.Limit = maxLimit
+		return maxLimit
+	}
+	if options.Limit > maxLimit {
+		base.InfofCtx(h.db.Ctx, base.KeyChanges, "Changes limit %d exceeds max_changes_limit - clamping to %d", options.Limit, maxLimit)
+		h.setHeader(changesLimitClampedHeader, strconv.Itoa(maxLimit))
+		options.Limit = maxLimit
+		return maxLimit
+	}
+	return 0
+}
+
+// resetStaleEpochSince restarts the feed from zero when its since value was issued under a previous sequence epoch, as
+// it doesn't correspond to the current bucket contents (bucket has been flushed or restored).  Returns true if reset.
+func (h *handler) resetStaleEpochSince(options *db.ChangesOptions) bool {
+	if h.db.SequenceEpoch == "" || options.Since.Epoch == "" || options.Since.Epoch == h.db.SequenceEpoch {
+		return false
+	}
+	base.InfofCtx(h.db.Ctx, base.KeyChanges, "Changes since value %s was issued under sequence epoch %s, current epoch is %s - restarting feed from zero",
+		options.Since, options.Since.Epoch, h.db.SequenceEpoch)
+	options.Since = db.SequenceID{}
+	return true
+}
+
+// withSequenceEpoch returns the entry with its sequence tagged with the database's sequence epoch, when sequence epochs
+// are enabled.  The entry is copied rather than modified, as the feed continues from the entry's sequence.
+func (h *handler) withSequenceEpoch(entry *db.ChangeEntry) *db.ChangeEntry {
+	if h.db.SequenceEpoch == "" {
+		return entry
+	}
+	tagged := *entry
+	tagged.Seq.Epoch = h.db.SequenceEpoch
+	return &tagged
+}
+
+// sendSimpleChanges writes a one-shot or longpoll changes response.  When sinceReset is true, the response flags that
+// the requested since value was discarded because it was issued under a previous sequence epoch.  When maxLimit is
+// non-zero and the response reaches it, the response is flagged as truncated - clients continue from last_seq.
+func (h *handler) sendSimpleChanges(channels base.Set, options db.ChangesOptions, docids []string, sinceReset bool, maxLimit int) (error, bool) {
 	lastSeq := options.Since
 	var first bool = true
+	numEntries := 0
 	var feed <-chan *db.ChangeEntry
 	var err error
 	if len(docids) > 0 {
@@ -401,8 +540,11 @@ func (h *handler) sendSimpleChanges(channels base.Set, options db.ChangesOptions
 					} else {
 						_, _ = h.response.Write([]byte(","))
 					}
-					_ = encoder.Encode(entry)
+					_, writeSpan := base.StartSpan(options.Ctx, "changes.write")
+					_ = encoder.Encode(h.withSequenceEpoch(entry))
+					writeSpan.End()
 					lastSeq = entry.Seq
+					numEntries++
 				}
 
 			case <-heartbeat:
@@ -429,7 +571,15 @@ func (h *handler) sendSimpleChanges(channels base.Set, options db.ChangesOptions
 		}
 	}
 
-	s := fmt.Sprintf("],\n\"last_seq\":%q}\n", lastSeq.String())
+	lastSeq.Epoch = h.db.SequenceEpoch
+	s := fmt.Sprintf("],\n\"last_seq\":%q", lastSeq.String())
+	if sinceReset {
+		s += ",\n\"since_reset\":\"sequence_epoch\""
+	}
+	if maxLimit > 0 && numEntries >= maxLimit {
+		s += ",\n\"truncated\":true"
+	}
+	s += "}\n"
 	_, _ = h.response.Write([]byte(s))
 	logStatus(http.StatusOK, message)
 	return nil, forceClose
@@ -463,7 +613,7 @@ func (h *handler) sendContinuousChangesByHTTP(inChannels base.Set, options db.Ch
 		var err error
 		if changes != nil {
 			for _, change := range changes {
-				data, _ := base.JSONMarshal(change)
+				data, _ := base.JSONMarshal(h.withSequenceEpoch(change))
 				if _, err = h.response.Write(data); err != nil {
 					break
 				}
@@ -511,6 +661,7 @@ func (h *handler) sendContinuousChangesByWebSocket(inChannels base.Set, options
 		//options.Terminator will be closed automatically when
 		//changes feed completes
 		wsoptions.Terminator = options.Terminator
+		h.resetStaleEpochSince(&wsoptions)
 
 		// Set up GZip compression
 		var writer *bytes.Buffer
@@ -524,7 +675,11 @@ func (h *handler) sendContinuousChangesByWebSocket(inChannels base.Set, options
 		_, forceClose = h.generateContinuousChanges(inChannels, wsoptions, func(changes []*db.ChangeEntry) error {
 			var data []byte
 			if changes != nil {
-				data, _ = base.JSONMarshal(changes)
+				tagged := make([]*db.ChangeEntry, len(changes))
+				for i, change := range changes {
+					tagged[i] = h.withSequenceEpoch(change)
+				}
+				data, _ = base.JSONMarshal(tagged)
 			} else if !caughtUp {
 				caughtUp = true
 				data, _ = base.JSONMarshal([]*db.ChangeEntry{})
@@ -559,21 +714,22 @@ func (h *handler) sendContinuousChangesByWebSocket(inChannels base.Set, options
 
 func (h *handler) readChangesOptionsFromJSON(jsonData []byte) (feed string, options db.ChangesOptions, filter string, channelsArray []string, docIdsArray []string, compress bool, err error) {
 	var input struct {
-		Feed           string        `json:"feed"`
-		Since          db.SequenceID `json:"since"`
-		Limit          int           `json:"limit"`
-		Style          string        `json:"style"`
-		IncludeDocs    bool          `json:"include_docs"`
-		Filter         string        `json:"filter"`
-		Channels       string        `json:"channels"` // a filter query param, so it has to be a string
-		DocIds         []string      `json:"doc_ids"`
-		HeartbeatMs    *uint64       `json:"heartbeat"`
-		TimeoutMs      *uint64       `json:"timeout"`
-		AcceptEncoding string        `json:"accept_encoding"`
-		ActiveOnly     bool          `json:"active_only"` // Return active revisions only
-	}
-
-	// Initialize since clock and hasher ahead of unmarshalling sequence
+		Feed              string        `json:"feed"`
+		Since             db.SequenceID `json:"since"`
+		Limit             int           `json:"limit"`
+		Style             string        `json:"style"`
+		IncludeDocs       bool          `json:"include_docs"`
+		Filter            string        `json:"filter"`
+		Channels          string        `json:"channels"` // a filter query param, so it has to be a string
+		DocIds            []string      `json:"doc_ids"`
+		HeartbeatMs       *uint64       `json:"heartbeat"`
+		TimeoutMs         *uint64       `json:"timeout"`
+		AcceptEncoding    string        `json:"accept_encoding"`
+		ActiveOnly        bool          `json:"active_only"`         // Return active revisions only
+		NoInitialBackfill bool          `json:"no_initial_backfill"` // Don't backfill channels granted during the feed
+	}
+
+	// Initialize since ahead of unmarshalling sequence
 	if h.db != nil {
 		input.Since = h.db.CreateZeroSinceValue()
 	}
@@ -587,6 +743,7 @@ func (h *handler) readChangesOptionsFromJSON(jsonData []byte) (feed string, opti
 
 	options.Conflicts = input.Style == "all_docs"
 	options.ActiveOnly = input.ActiveOnly
+	options.NoInitialBackfill = input.NoInitialBackfill
 
 	options.IncludeDocs = input.IncludeDocs
 	filter = input.Filter
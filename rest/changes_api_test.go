@@ -9,13 +9,19 @@
 package rest
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -876,6 +882,58 @@ func TestChangesFromCompoundSinceViaDocGrant(t *testing.T) {
 	})
 }
 
+// Validates that no_initial_backfill feeds a channel granted during the feed from the grant, rather than from the start
+// of the channel's history, and that resuming from that feed doesn't backfill the channel.
+func TestChangesNoInitialBackfill(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyChanges, base.KeyHTTP)()
+
+	rt := NewRestTester(t, &RestTesterConfig{SyncFn: `function(doc) {
+	channel(doc.channel);
+	if (doc.grants) {
+		access(doc.grants.users, doc.grants.channels);
+	}
+}`})
+	defer rt.Close()
+
+	a := rt.ServerContext().Database("db").Authenticator()
+	bernard, err := a.NewUser("bernard", "letmein", channels.SetOf(t, "ABC"))
+	require.NoError(t, err)
+	require.NoError(t, a.Save(bernard))
+
+	cacheWaiter := rt.GetDatabase().NewDCPCachingCountWaiter(t)
+	_ = rt.putDoc("pbs-1", `{"channel":["PBS"]}`)
+	_ = rt.putDoc("abc-1", `{"channel":["ABC"]}`)
+	cacheWaiter.AddAndWait(2)
+
+	changes, err := rt.WaitForChanges(1, "/db/_changes", "bernard", false)
+	require.NoError(t, err)
+	require.Len(t, changes.Results, 1)
+	assert.Equal(t, "abc-1", changes.Results[0].ID)
+	sinceGrant := changes.Last_Seq
+
+	// Grant bernard access to PBS
+	_ = rt.putDoc("grant-1", `{"channel":["PBS"], "grants": {"users": ["bernard"], "channels": ["PBS"]}}`)
+	cacheWaiter.AddAndWait(1)
+
+	// Without the option, PBS is backfilled
+	changes, err = rt.WaitForChanges(2, fmt.Sprintf("/db/_changes?since=%v", sinceGrant), "bernard", false)
+	require.NoError(t, err)
+	changes.requireDocIDs(t, []string{"pbs-1", "grant-1"})
+
+	// With the option, PBS is fed from the grant
+	changes, err = rt.WaitForChanges(1, fmt.Sprintf("/db/_changes?since=%v&no_initial_backfill=true", sinceGrant), "bernard", false)
+	require.NoError(t, err)
+	changes.requireDocIDs(t, []string{"grant-1"})
+
+	// Resuming from the feed without the option doesn't backfill PBS
+	_ = rt.putDoc("pbs-2", `{"channel":["PBS"]}`)
+	cacheWaiter.AddAndWait(1)
+	changes, err = rt.WaitForChanges(1, fmt.Sprintf("/db/_changes?since=%v", changes.Last_Seq), "bernard", false)
+	require.NoError(t, err)
+	changes.requireDocIDs(t, []string{"pbs-2"})
+}
+
 // Ensures that changes feed goroutines blocked on a ChangeWaiter are closed when the changes feed is terminated.
 // Reproduces CBG-1113 and #1329 (even with the fix in PR #1360)
 // Tests all combinations of HTTP feed types, admin/non-admin, and with and without a manual notify to wake up.
@@ -2112,9 +2170,9 @@ func TestChangesIncludeDocs(t *testing.T) {
 	expectedStyleAllDocs[0] = `{"seq":1,"id":"_user/user1","changes":[]}`
 	expectedStyleAllDocs[1] = `{"seq":2,"id":"doc_active","changes":[{"rev":"1-d59fda97ac4849f6a754fbcf4b522980"}]}`
 	expectedStyleAllDocs[2] = `{"seq":4,"id":"doc_multi_rev","changes":[{"rev":"2-db2cf770921c3764b2d213ee0cbb5f45"}]}`
-	expectedStyleAllDocs[3] = `{"seq":6,"id":"doc_tombstone","deleted":true,"removed":["alpha"],"changes":[{"rev":"2-5bd8eb422f30e8d455940672e9e76549"}]}`
-	expectedStyleAllDocs[4] = `{"seq":8,"id":"doc_removed","removed":["alpha"],"changes":[{"rev":"2-d15cb77d1dbe1cc06d27310de5b75914"}]}`
-	expectedStyleAllDocs[5] = `{"seq":12,"id":"doc_pruned","removed":["alpha"],"changes":[{"rev":"2-5afcb73bd3eb50615470e3ba54b80f00"}]}`
+	expectedStyleAllDocs[3] = `{"seq":6,"id":"doc_tombstone","deleted":true,"removed":["alpha"],"removed_rev":"2-5bd8eb422f30e8d455940672e9e76549","changes":[{"rev":"2-5bd8eb422f30e8d455940672e9e76549"}]}`
+	expectedStyleAllDocs[4] = `{"seq":8,"id":"doc_removed","removed":["alpha"],"removed_rev":"2-d15cb77d1dbe1cc06d27310de5b75914","changes":[{"rev":"2-d15cb77d1dbe1cc06d27310de5b75914"}]}`
+	expectedStyleAllDocs[5] = `{"seq":12,"id":"doc_pruned","removed":["alpha"],"removed_rev":"2-5afcb73bd3eb50615470e3ba54b80f00","changes":[{"rev":"2-5afcb73bd3eb50615470e3ba54b80f00"}]}`
 	expectedStyleAllDocs[6] = `{"seq":18,"id":"doc_attachment","changes":[{"rev":"2-0b0457923508d99ec1929d2316d14cf2"}]}`
 	expectedStyleAllDocs[7] = `{"seq":19,"id":"doc_large_numbers","changes":[{"rev":"1-2721633d9000e606e9c642e98f2f5ae7"}]}`
 	expectedStyleAllDocs[8] = `{"seq":22,"id":"doc_conflict","changes":[{"rev":"2-conflicting_rev"},{"rev":"2-869a7167ccbad634753105568055bd61"}]}`
@@ -2816,6 +2874,62 @@ func TestChangesQueryBackfillWithLimit(t *testing.T) {
 
 }
 
+// Tests query backfill paging when the page size is set via the database's query_pagination_limit
+func TestChangesQueryBackfillConfiguredPageSize(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyHTTP, base.KeyChanges, base.KeyCache)()
+
+	rtConfig := RestTesterConfig{
+		SyncFn:         `function(doc, oldDoc){channel(doc.channels);}`,
+		DatabaseConfig: &DbConfig{QueryPaginationLimit: base.IntPtr(2)},
+	}
+	rt := NewRestTester(t, &rtConfig)
+	defer rt.Close()
+	testDb := rt.GetDatabase()
+	require.Equal(t, 2, testDb.Options.CacheOptions.ChannelQueryLimit)
+
+	// Effective page size is reported in the db config
+	var dbConfig DbConfig
+	response := rt.SendAdminRequest("GET", "/db/_config", "")
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &dbConfig))
+	require.NotNil(t, dbConfig.QueryPaginationLimit)
+	assert.Equal(t, 2, *dbConfig.QueryPaginationLimit)
+
+	username := "user_" + t.Name()
+	a := testDb.Authenticator()
+	testUser, err := a.NewUser(username, "letmein", channels.SetOf(t, "ABC"))
+	assert.NoError(t, err)
+	assert.NoError(t, a.Save(testUser))
+
+	cacheWaiter := testDb.NewDCPCachingCountWaiter(t)
+	for i := 0; i < 7; i++ {
+		response := rt.SendAdminRequest("PUT", fmt.Sprintf("/db/%s_%d", t.Name(), i), `{"channels":["ABC"]}`)
+		assertStatus(t, response, 201)
+		response = rt.SendAdminRequest("PUT", fmt.Sprintf("/db/%s_%d_other", t.Name(), i), `{"channels":["other"]}`)
+		assertStatus(t, response, 201)
+	}
+	cacheWaiter.AddAndWait(14)
+
+	// Flush the channel cache to force a backfill
+	assert.NoError(t, testDb.FlushChannelCache())
+	startQueryCount := testDb.GetChannelQueryCount()
+
+	var changes struct {
+		Results  []db.ChangeEntry
+		Last_Seq interface{}
+	}
+	changesResponse := rt.Send(requestByUser("GET", "/db/_changes", "", username))
+	err = base.JSONUnmarshal(changesResponse.Body.Bytes(), &changes)
+	assert.NoError(t, err, "Error unmarshalling changes response")
+	require.Equal(t, 7, len(changes.Results))
+	for i, entry := range changes.Results {
+		assert.Equal(t, fmt.Sprintf("%s_%d", t.Name(), i), entry.ID)
+	}
+
+	// Expect pages of 2, 2, 2, 1 for the channel, plus one query for the public channel
+	assert.Equal(t, int64(4), testDb.GetChannelQueryCount()-startQueryCount-1)
+}
+
 // Tests query backfill with limit
 func TestMultichannelChangesQueryBackfillWithLimit(t *testing.T) {
 
@@ -3916,6 +4030,76 @@ func TestTombstoneCompaction(t *testing.T) {
 	TestCompact(db.QueryTombstoneBatch + 20)
 }
 
+// Validates that compaction only purges tombstones older than the retention, and that a background compaction reports
+// its progress.
+func TestTombstoneCompactionRetention(t *testing.T) {
+
+	if base.UnitTestUrlIsWalrus() {
+		t.Skip("Walrus does not support Xattrs")
+	}
+
+	if !base.TestUseXattrs() {
+		t.Skip("If running with no xattrs compact acts as a no-op")
+	}
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_compact", "")
+	assertStatus(t, response, http.StatusNotFound)
+
+	// Returns the rev ID of each tombstone
+	createTombstones := func(prefix string, numDocs int) map[string]string {
+		tombstones := make(map[string]string, numDocs)
+		for i := 0; i < numDocs; i++ {
+			docID := fmt.Sprintf("%s_%d", prefix, i)
+			response := rt.SendAdminRequest(http.MethodPut, "/db/"+docID, `{"foo":"bar"}`)
+			assertStatus(t, response, http.StatusCreated)
+			var body db.Body
+			require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &body))
+			response = rt.SendAdminRequest(http.MethodDelete, fmt.Sprintf("/db/%s?rev=%s", docID, body["rev"]), "")
+			assertStatus(t, response, http.StatusOK)
+			require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &body))
+			tombstones[docID] = body["rev"].(string)
+		}
+		return tombstones
+	}
+	aged := createTombstones("aged", 10)
+	time.Sleep(3 * time.Second)
+	recent := createTombstones("recent", 10)
+
+	rt.GetDatabase().PurgeInterval = 2 * time.Second
+	response = rt.SendAdminRequest(http.MethodPost, "/db/_compact?background=true", "")
+	assertStatus(t, response, http.StatusAccepted)
+	var status db.CompactionStatus
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &status))
+	assert.Equal(t, db.CompactionStateRunning, status.Status)
+
+	require.NoError(t, rt.WaitForCondition(func() bool {
+		response := rt.SendAdminRequest(http.MethodGet, "/db/_compact", "")
+		assertStatus(t, response, http.StatusOK)
+		require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &status))
+		return status.Status != db.CompactionStateRunning
+	}))
+	assert.Equal(t, db.CompactionStateCompleted, status.Status)
+	assert.Equal(t, 10, status.Purged)
+	assert.Equal(t, 0, status.Failed)
+	assert.Equal(t, 1, status.Batches)
+	require.NotNil(t, status.EndTime)
+	assert.Equal(t, int64(10), rt.GetDatabase().DbStats.Database().NumTombstonesCompacted.Value())
+	assert.Equal(t, int64(0), rt.GetDatabase().DbStats.Database().TombstoneCompactionRunning.Value())
+
+	// Only the aged tombstones were purged
+	for docID, revID := range aged {
+		response := rt.SendAdminRequest(http.MethodGet, fmt.Sprintf("/db/%s?rev=%s", docID, revID), "")
+		assertStatus(t, response, http.StatusNotFound)
+	}
+	for docID, revID := range recent {
+		response := rt.SendAdminRequest(http.MethodGet, fmt.Sprintf("/db/%s?rev=%s", docID, revID), "")
+		assertStatus(t, response, http.StatusOK)
+	}
+}
+
 func waitForCompactStopped(dbc *db.DatabaseContext) error {
 	for i := 0; i < 100; i++ {
 		compactRunning := dbc.CacheCompactActive()
@@ -3963,3 +4147,460 @@ func WriteDirectWithKey(testDb *db.DatabaseContext, key string, channelArray []s
 		base.Panicf("Error while add ket to bucket: %v", err)
 	}
 }
+
+func TestChangesSequenceEpoch(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyHTTP, base.KeyChanges)()
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DbConfig{SequenceEpochEnabled: base.BoolPtr(true)},
+	})
+	defer rt.Close()
+
+	epoch := rt.GetDatabase().SequenceEpoch
+	require.NotEmpty(t, epoch)
+
+	for i := 0; i < 3; i++ {
+		response := rt.SendAdminRequest("PUT", fmt.Sprintf("/db/%s_%d", t.Name(), i), `{"channels":["ABC"]}`)
+		assertStatus(t, response, 201)
+	}
+	require.NoError(t, rt.WaitForPendingChanges())
+
+	type changesResponse struct {
+		Results    []db.ChangeEntry
+		LastSeq    string `json:"last_seq"`
+		SinceReset string `json:"since_reset"`
+	}
+	getChanges := func(since string) changesResponse {
+		var changes changesResponse
+		response := rt.SendAdminRequest("GET", "/db/_changes?since="+since, "")
+		assertStatus(t, response, 200)
+		require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &changes))
+		return changes
+	}
+
+	// last_seq and each row's seq include the current epoch
+	changes := getChanges("")
+	assert.Len(t, changes.Results, 3)
+	for _, entry := range changes.Results {
+		assert.Equal(t, epoch, entry.Seq.Epoch)
+	}
+	assert.Equal(t, epoch+":3", changes.LastSeq)
+	assert.Empty(t, changes.SinceReset)
+
+	// since with the current epoch
+	changes = getChanges(changes.LastSeq)
+	assert.Len(t, changes.Results, 0)
+	assert.Equal(t, epoch+":3", changes.LastSeq)
+	assert.Empty(t, changes.SinceReset)
+
+	// Plain numeric since remains supported
+	changes = getChanges("1")
+	assert.Len(t, changes.Results, 2)
+	assert.Equal(t, epoch+":3", changes.LastSeq)
+	assert.Empty(t, changes.SinceReset)
+
+	// since with a stale epoch restarts the feed from zero
+	changes = getChanges("e0123456789abcdef:3")
+	assert.Len(t, changes.Results, 3)
+	assert.Equal(t, epoch+":3", changes.LastSeq)
+	assert.Equal(t, "sequence_epoch", changes.SinceReset)
+
+	// Continuous feed rows include the current epoch, and a stale epoch restarts the feed from zero
+	for _, since := range []string{"1", "e0123456789abcdef:3"} {
+		response := rt.SendAdminRequest("GET", "/db/_changes?feed=continuous&timeout=100&since="+since, "")
+		assertStatus(t, response, 200)
+		entries, err := readContinuousChanges(response)
+		require.NoError(t, err)
+		var seqs []string
+		for _, entry := range entries {
+			seqs = append(seqs, entry.Seq.String())
+		}
+		if since == "1" {
+			assert.Equal(t, []string{epoch + ":2", epoch + ":3"}, seqs)
+		} else {
+			assert.Equal(t, []string{epoch + ":1", epoch + ":2", epoch + ":3"}, seqs)
+		}
+	}
+}
+
+func TestChangesMaxLimit(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyHTTP, base.KeyChanges)()
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DbConfig{MaxChangesLimit: base.IntPtr(3)},
+	})
+	defer rt.Close()
+
+	for i := 0; i < 5; i++ {
+		response := rt.SendAdminRequest("PUT", fmt.Sprintf("/db/%s_%d", t.Name(), i), `{"channels":["ABC"]}`)
+		assertStatus(t, response, 201)
+	}
+	require.NoError(t, rt.WaitForPendingChanges())
+
+	type changesResponse struct {
+		Results   []db.ChangeEntry
+		LastSeq   string `json:"last_seq"`
+		Truncated bool   `json:"truncated"`
+	}
+	getChanges := func(queryString string) (changesResponse, *TestResponse) {
+		var changes changesResponse
+		response := rt.SendAdminRequest("GET", "/db/_changes"+queryString, "")
+		assertStatus(t, response, 200)
+		require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &changes))
+		return changes, response
+	}
+
+	// No limit - max is applied implicitly, and the response flagged as truncated
+	changes, response := getChanges("")
+	assert.Len(t, changes.Results, 3)
+	assert.Equal(t, "3", changes.LastSeq)
+	assert.True(t, changes.Truncated)
+	assert.Empty(t, response.Header().Get(changesLimitClampedHeader))
+
+	// Continuing from last_seq returns the remainder
+	changes, _ = getChanges("?since=" + changes.LastSeq)
+	assert.Len(t, changes.Results, 2)
+	assert.Equal(t, "5", changes.LastSeq)
+	assert.False(t, changes.Truncated)
+
+	// Limit above the max is clamped
+	changes, response = getChanges("?limit=1000")
+	assert.Len(t, changes.Results, 3)
+	assert.True(t, changes.Truncated)
+	assert.Equal(t, "3", response.Header().Get(changesLimitClampedHeader))
+
+	// Limit below the max is honored as-is
+	changes, response = getChanges("?limit=2")
+	assert.Len(t, changes.Results, 2)
+	assert.Equal(t, "2", changes.LastSeq)
+	assert.False(t, changes.Truncated)
+	assert.Empty(t, response.Header().Get(changesLimitClampedHeader))
+
+	// POST requests are bounded too
+	response = rt.SendAdminRequest("POST", "/db/_changes", `{"limit":1000}`)
+	assertStatus(t, response, 200)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &changes))
+	assert.Len(t, changes.Results, 3)
+	assert.Equal(t, "3", response.Header().Get(changesLimitClampedHeader))
+}
+
+// Opens longpoll feeds up to the database's limits on active feeds, and validates that guest feeds are rejected over
+// the soft limit, all feeds over the hard limit, and that the limits can be raised at runtime.
+func TestChangesFeedLimits(t *testing.T) {
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyHTTP, base.KeyChanges)()
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		guestEnabled:   true,
+		DatabaseConfig: &DbConfig{MaxChangesFeeds: base.IntPtr(2), SoftMaxChangesFeeds: base.IntPtr(1)},
+	})
+	defer rt.Close()
+
+	for _, username := range []string{"alice", "bob", "carol"} {
+		response := rt.SendAdminRequest("PUT", "/db/_user/"+username, `{"password":"letmein", "admin_channels":["*"]}`)
+		assertStatus(t, response, 201)
+	}
+	require.NoError(t, rt.WaitForPendingChanges())
+	lastSeq, err := rt.GetDatabase().LastSequence()
+	require.NoError(t, err)
+	longpollURL := fmt.Sprintf("/db/_changes?feed=longpoll&since=%d", lastSeq)
+
+	dbStats := rt.GetDatabase().DbStats.Database()
+	var longpollWg sync.WaitGroup
+	startLongpoll := func(username string, expectedActive int64) {
+		longpollWg.Add(1)
+		go func() {
+			defer longpollWg.Done()
+			response := rt.Send(requestByUser("GET", longpollURL, "", username))
+			assertStatus(t, response, 200)
+		}()
+		_, ok := base.WaitForStat(dbStats.NumChangesFeedsActive.Value, expectedActive)
+		require.True(t, ok)
+	}
+	assertRejected := func(response *TestResponse, expectedRejected int64) {
+		assertStatus(t, response, http.StatusServiceUnavailable)
+		assert.Equal(t, strconv.Itoa(changesFeedRetryAfterSecs), response.Header().Get("Retry-After"))
+		assert.Equal(t, expectedRejected, dbStats.NumChangesFeedsRejected.Value())
+	}
+
+	// Guest feeds are rejected over the soft limit, authenticated users' up to the hard limit
+	startLongpoll("alice", 1)
+	assertRejected(rt.SendRequest("GET", longpollURL, ""), 1)
+	startLongpoll("bob", 2)
+	assertRejected(rt.Send(requestByUser("GET", longpollURL, "", "carol")), 2)
+	assert.Equal(t, int64(2), dbStats.NumChangesFeedsActive.Value())
+
+	// One-shot feeds aren't limited
+	assertStatus(t, rt.Send(requestByUser("GET", "/db/_changes", "", "carol")), 200)
+
+	// Raising the limit applies without a reload
+	response := rt.SendAdminRequest("PUT", "/db/_config", `{"max_changes_feeds": 3, "soft_max_changes_feeds": 1}`)
+	assertStatus(t, response, 201)
+	startLongpoll("carol", 3)
+	assertRejected(rt.SendRequest("GET", longpollURL, ""), 3)
+
+	response = rt.SendAdminRequest("PUT", "/db/_config", `{"max_changes_feeds": 3, "soft_max_changes_feeds": 4}`)
+	assertStatus(t, response, http.StatusBadRequest)
+
+	// Wake the longpolls, and validate their feeds are released
+	response = rt.SendAdminRequest("PUT", "/db/doc1", `{"channels":["ABC"]}`)
+	assertStatus(t, response, 201)
+	longpollWg.Wait()
+	_, ok := base.WaitForStat(dbStats.NumChangesFeedsActive.Value, 0)
+	assert.True(t, ok)
+}
+
+// Validates that one-shot changes responses are compressed with the negotiated encoding, and that compression is
+// recorded in the pull replication stats.
+func TestChangesResponseEncoding(t *testing.T) {
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	for i := 0; i < 20; i++ {
+		response := rt.SendAdminRequest(http.MethodPut, fmt.Sprintf("/db/doc%d", i), `{"channels":["ABC"], "value":"abcdefghijklmnopqrstuvwxyz"}`)
+		assertStatus(t, response, http.StatusCreated)
+	}
+	require.NoError(t, rt.WaitForPendingChanges())
+
+	testCases := []struct {
+		acceptEncoding string
+		feed           string
+		expected       string
+	}{
+		{"gzip", "normal", "gzip"},
+		{"deflate", "normal", "deflate"},
+		{"gzip;q=0, deflate", "longpoll", "deflate"},
+		{"deflate;q=0.5, gzip", "longpoll", "gzip"},
+		{"identity", "normal", ""},
+	}
+	for _, testCase := range testCases {
+		t.Run(fmt.Sprintf("%s %s", testCase.feed, testCase.acceptEncoding), func(t *testing.T) {
+			response := rt.SendAdminRequestWithHeaders(http.MethodGet, "/db/_changes?include_docs=true&feed="+testCase.feed, "",
+				map[string]string{"Accept-Encoding": testCase.acceptEncoding})
+			assertStatus(t, response, http.StatusOK)
+			require.Equal(t, testCase.expected, response.Header().Get("Content-Encoding"))
+
+			var body io.Reader = response.Body
+			switch testCase.expected {
+			case "gzip":
+				gzipReader, err := gzip.NewReader(body)
+				require.NoError(t, err)
+				body = gzipReader
+			case "deflate":
+				zlibReader, err := zlib.NewReader(body)
+				require.NoError(t, err)
+				body = zlibReader
+			}
+			var changes struct {
+				Results []db.ChangeEntry `json:"results"`
+			}
+			require.NoError(t, base.JSONDecoder(body).Decode(&changes))
+			assert.Len(t, changes.Results, 20)
+		})
+	}
+
+	pullStats := rt.GetDatabase().DbStats.CBLReplicationPull()
+	assert.Greater(t, pullStats.ChangesCompressedBytes.Value(), int64(0))
+	assert.Greater(t, pullStats.ChangesUncompressedBytes.Value(), pullStats.ChangesCompressedBytes.Value())
+	assert.Equal(t, pullStats.ChangesUncompressedBytes.Value()-pullStats.ChangesCompressedBytes.Value(), pullStats.ChangesCompressionSaved.Value())
+	assert.Greater(t, pullStats.ChangesCompressionRatio.Value(), 1.0)
+}
+
+// Validates that a continuous feed over a gzip-negotiated connection delivers each entry as soon as it's cached,
+// rather than once the compressor's buffer fills.
+func TestContinuousChangesGzipStreaming(t *testing.T) {
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	srv := httptest.NewServer(rt.TestAdminHandler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/db/_changes?feed=continuous&since=0&heartbeat=60000", nil)
+	require.NoError(t, err)
+	// Set explicitly, so the client doesn't decompress transparently
+	request.Header.Set("Accept-Encoding", "gzip")
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer func() { _ = response.Body.Close() }()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "gzip", response.Header.Get("Content-Encoding"))
+
+	// The feed is flushed once caught up, so the gzip header can be read before any entries are written
+	gzipReader, err := gzip.NewReader(response.Body)
+	require.NoError(t, err)
+	entries := make(chan db.ChangeEntry)
+	go func() {
+		defer close(entries)
+		scanner := bufio.NewScanner(gzipReader)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue // heartbeat
+			}
+			var entry db.ChangeEntry
+			if base.JSONUnmarshal(scanner.Bytes(), &entry) == nil {
+				entries <- entry
+			}
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		docID := fmt.Sprintf("doc%d", i)
+		response := rt.SendAdminRequest(http.MethodPut, "/db/"+docID, `{"channels":["ABC"]}`)
+		assertStatus(t, response, http.StatusCreated)
+		require.NoError(t, rt.WaitForPendingChanges())
+		cached := time.Now()
+
+		select {
+		case entry, ok := <-entries:
+			require.True(t, ok, "Continuous feed ended unexpectedly")
+			assert.Equal(t, docID, entry.ID)
+			assert.Less(t, int64(time.Since(cached)), int64(100*time.Millisecond), "Entry %s delayed by compression", docID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Entry for %s wasn't delivered", docID)
+		}
+	}
+}
+
+// Validates that changes rows never reference revisions that have been pruned from the rev tree, with revs_limit low
+// enough that every update prunes the previous revision.
+func TestChangesPrunedRevisions(t *testing.T) {
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyCache, base.KeyChanges, base.KeyCRUD)()
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		SyncFn: `function(doc) {channel(doc.channels);}`,
+		DatabaseConfig: &DbConfig{
+			AllowConflicts: base.BoolPtr(false),
+			RevsLimit:      base.Uint32Ptr(1),
+		},
+	})
+	defer rt.Close()
+
+	a := rt.ServerContext().Database("db").Authenticator()
+	alice, err := a.NewUser("alice", "letmein", channels.SetOf(t, "A"))
+	require.NoError(t, err)
+	require.NoError(t, a.Save(alice))
+
+	revs := make(map[string]string)
+	putDoc := func(docID, channel string) {
+		resource := "/db/" + docID
+		if rev, ok := revs[docID]; ok {
+			resource += "?rev=" + rev
+		}
+		response := rt.SendAdminRequest(http.MethodPut, resource, fmt.Sprintf(`{"channels":[%q]}`, channel))
+		assertStatus(t, response, http.StatusCreated)
+		var body db.Body
+		require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &body))
+		revs[docID] = body["rev"].(string)
+	}
+
+	// Fetches each revision returned by a changes request immediately, without waiting for the cache to receive the
+	// update that pruned it.  Revisions are read from the bucket, rather than the revision cache.
+	changesAndGet := func(username string) []db.ChangeEntry {
+		send := func(resource string) *TestResponse {
+			if username == "" {
+				return rt.SendAdminRequest(http.MethodGet, resource, "")
+			}
+			return rt.Send(requestByUser(http.MethodGet, resource, "", username))
+		}
+		response := send("/db/_changes")
+		assertStatus(t, response, http.StatusOK)
+		var changes struct {
+			Results []db.ChangeEntry
+		}
+		require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &changes))
+
+		rt.GetDatabase().FlushRevisionCacheForTest()
+		for _, change := range changes.Results {
+			for _, changeRev := range change.Changes {
+				resource := fmt.Sprintf("/db/%s?rev=%s", change.ID, changeRev["rev"])
+				assert.Equal(t, http.StatusOK, send(resource).Code, "Unexpected status fetching %s returned by changes", resource)
+			}
+		}
+		return changes.Results
+	}
+
+	// Entries for revisions pruned by an update are dropped from the cache until the update is cached
+	for i := 0; i < 50; i++ {
+		putDoc(fmt.Sprintf("doc%d", i%5), "B")
+		changesAndGet("")
+	}
+
+	// The doc is removed from channel A, then updated until the removal revision is pruned.  The removal entry in A's cache isn't
+	// superseded, so references the current revision instead.
+	putDoc("removed", "A")
+	require.NoError(t, rt.WaitForPendingChanges())
+	changesAndGet("alice")
+	putDoc("removed", "B")
+	removalRev := revs["removed"]
+	require.NoError(t, rt.WaitForPendingChanges())
+	putDoc("removed", "B")
+	putDoc("removed", "B")
+	require.NoError(t, rt.WaitForPendingChanges())
+
+	var removal *db.ChangeEntry
+	for _, change := range changesAndGet("alice") {
+		if change.ID == "removed" {
+			change := change
+			removal = &change
+		}
+	}
+	require.NotNil(t, removal)
+	assert.True(t, removal.Removed.Contains("A"))
+	assert.NotEqual(t, removalRev, removal.Changes[0]["rev"])
+	assert.Equal(t, revs["removed"], removal.Changes[0]["rev"])
+}
+
+// Validates the spans started for a changes request for a channel that isn't cached.  The channel's changes are read
+// from the cache, which backfills them with a channel query, then each entry's doc is fetched and written to the
+// response.
+func TestChangesTracingSpans(t *testing.T) {
+
+	rt := NewRestTester(t, &RestTesterConfig{SyncFn: `function(doc) {channel(doc.channels);}`})
+	defer rt.Close()
+
+	for i := 0; i < 3; i++ {
+		response := rt.SendAdminRequest(http.MethodPut, fmt.Sprintf("/db/doc%d", i), `{"channels":["ABC"]}`)
+		assertStatus(t, response, http.StatusCreated)
+	}
+	require.NoError(t, rt.WaitForPendingChanges())
+	require.NoError(t, rt.GetDatabase().FlushChannelCache())
+
+	tracer, teardown := base.SetUpTestTracing()
+	defer teardown()
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_changes?include_docs=true&filter=sync_gateway/bychannel&channels=ABC", "")
+	assertStatus(t, response, http.StatusOK)
+
+	changesSpans := tracer.SpansNamed("changes")
+	require.Len(t, changesSpans, 1)
+	root := changesSpans[0]
+	assert.Equal(t, 0, root.ParentID)
+	assert.Equal(t, "normal", root.Attributes["feed"])
+	assert.Equal(t, true, root.Attributes["include_docs"])
+	assert.True(t, root.Ended)
+
+	readSpans := tracer.SpansNamed("cache.get_changes")
+	require.Len(t, readSpans, 1)
+	assert.Equal(t, root.ID, readSpans[0].ParentID)
+	assert.Equal(t, 3, readSpans[0].Attributes["entries"])
+	assert.True(t, readSpans[0].Ended)
+
+	querySpans := tracer.SpansNamed("query.channel")
+	require.Len(t, querySpans, 1)
+	assert.Equal(t, readSpans[0].ID, querySpans[0].ParentID)
+	assert.True(t, querySpans[0].Ended)
+
+	for _, name := range []string{"changes.doc", "changes.write"} {
+		spans := tracer.SpansNamed(name)
+		assert.Len(t, spans, 3, "Unexpected number of %s spans", name)
+		for _, span := range spans {
+			assert.Equal(t, root.ID, span.ParentID, "Unexpected parent of %s span", name)
+			assert.True(t, span.Ended)
+		}
+	}
+}
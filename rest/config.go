@@ -90,7 +90,7 @@ type ServerConfig struct {
 	DeploymentID               *string                  `json:",omitempty"`                       // Optional customer/deployment ID for stats reporting
 	StatsReportInterval        *float64                 `json:",omitempty"`                       // Optional stats report interval (0 to disable)
 	CouchbaseKeepaliveInterval *int                     `json:",omitempty"`                       // TCP keep-alive interval between SG and Couchbase server
-	SlowQueryWarningThreshold  *int                     `json:",omitempty"`                       // Log warnings if N1QL queries take this many ms
+	SlowQueryWarningThreshold  *int                     `json:",omitempty"`                       // Log warnings if N1QL queries, or channel backfill queries, take this many ms
 	MaxIncomingConnections     *int                     `json:",omitempty"`                       // Max # of incoming HTTP connections to accept
 	MaxFileDescriptors         *uint64                  `json:",omitempty"`                       // Max # of open file descriptors (RLIMIT_NOFILE)
 	CompressResponses          *bool                    `json:",omitempty"`                       // If false, disables compression of HTTP responses
@@ -103,6 +103,13 @@ type ServerConfig struct {
 	BcryptCost                 int                      `json:"bcrypt_cost,omitempty"`            // bcrypt cost to use for password hashes - Default: bcrypt.DefaultCost
 	MetricsInterface           *string                  `json:"metricsInterface,omitempty"`       // Interface to bind metrics to. If not set then metrics isn't accessible
 	HideProductVersion         bool                     `json:"hide_product_version,omitempty"`   // Determines whether product versions removed from Server headers and REST API responses. This setting does not apply to the Admin REST API.
+	HealthHints                bool                     `json:"health_hints,omitempty"`           // Determines whether change cache health and a retry hint are included in root and database root responses
+	MaxChangesFeeds            *int                     `json:"max_changes_feeds,omitempty"`      // Max active continuous, longpoll and websocket changes feeds from non-admin clients, across all databases
+
+	// ChannelCacheMaxMemoryMB is the estimated memory (MB) the channel caches of all databases are reduced below, by
+	// evicting idle channels and pruning in proportion to each database's usage.  Checked at the stats logging interval.
+	// 0 means no limit.
+	ChannelCacheMaxMemoryMB *int `json:"channel_cache_max_memory_mb,omitempty"`
 }
 
 // Bucket configuration elements - used by db, index
@@ -174,10 +181,19 @@ type DbConfig struct {
 	ImportPartitions                 *uint16                          `json:"import_partitions,omitempty"`                    // Number of partitions for import sharding.  Impacts the total DCP concurrency for import
 	ImportFilter                     *string                          `json:"import_filter,omitempty"`                        // Filter function (import)
 	ImportBackupOldRev               bool                             `json:"import_backup_old_rev"`                          // Whether import should attempt to create a temporary backup of the previous revision body, when available.
+	ImportLargeDocSize               *int                             `json:"import_large_doc_size,omitempty"`                // Body size in bytes above which imported documents are logged and counted in import_large_doc_count
+	ImportMaxDocSize                 *int                             `json:"import_max_doc_size,omitempty"`                  // Body size in bytes above which documents are rejected from import
+	ImportDeclaredFields             []string                         `json:"import_declared_fields,omitempty"`               // Top-level properties read by the import filter and sync function, decoded alone for documents over import_large_doc_size
+	ImportCheckpointGroup            *string                          `json:"import_checkpoint_group,omitempty"`              // Group the import feed persists its checkpoints under.  Changing it restarts import from the start of the feed
+	ImportCheckpointGCMaxAgeSecs     *uint32                          `json:"import_checkpoint_gc_max_age_secs,omitempty"`    // Age after which import checkpoints for inactive checkpoint groups are deleted on feed shutdown, and periodically.  Zero disables
+	ImportCheckpointGCOnStartup      bool                             `json:"import_checkpoint_gc_on_startup"`                // Whether stale import checkpoints are also deleted when the import feed starts
+	ImportMaxInFlightBytes           *int64                           `json:"import_max_in_flight_bytes,omitempty"`           // Feed event bytes being imported at once above which further imports wait (or are deferred).  Zero disables
+	ImportDeferOverBudget            bool                             `json:"import_defer_over_budget"`                       // Whether imports over import_max_in_flight_bytes are deferred to the document's next mutation, rather than waiting
 	EventHandlers                    *EventHandlerConfig              `json:"event_handlers,omitempty"`                       // Event handlers (webhook)
 	FeedType                         string                           `json:"feed_type,omitempty"`                            // Feed type - "DCP" or "TAP"; defaults based on Couchbase server version
 	AllowEmptyPassword               bool                             `json:"allow_empty_password,omitempty"`                 // Allow empty passwords?  Defaults to false
 	CacheConfig                      *CacheConfig                     `json:"cache,omitempty"`                                // Cache settings
+	CacheProfile                     string                           `json:"cache_profile,omitempty"`                        // Named preset of cache settings (low_memory, balanced, high_throughput), which individual cache settings override
 	DeprecatedRevCacheSize           *uint32                          `json:"rev_cache_size,omitempty"`                       // Maximum number of revisions to store in the revision cache (deprecated, CBG-356)
 	StartOffline                     bool                             `json:"offline,omitempty"`                              // start the DB in the offline state, defaults to false
 	Unsupported                      db.UnsupportedOptions            `json:"unsupported,omitempty"`                          // Config for unsupported features
@@ -197,13 +213,23 @@ type DbConfig struct {
 	BucketOpTimeoutMs                *uint32                          `json:"bucket_op_timeout_ms,omitempty"`                 // How long bucket ops should block returning "operation timed out". If nil, uses GoCB default.  GoCB buckets only.
 	DeltaSync                        *DeltaSyncConfig                 `json:"delta_sync,omitempty"`                           // Config for delta sync
 	CompactIntervalDays              *float32                         `json:"compact_interval_days,omitempty"`                // Interval between scheduled compaction runs (in days) - 0 means don't run
+	CompactOpsPerSec                 *int                             `json:"compact_ops_per_sec,omitempty"`                  // Max tombstones purged per second by compaction.  Defaults to 1000
+	TombstoneRetentionSecs           *uint32                          `json:"tombstone_retention_secs,omitempty"`             // Age after which tombstones are purged by compaction.  Defaults to the server's metadata purge interval, and must exceed client_partition_window_secs
 	SGReplicateEnabled               *bool                            `json:"sgreplicate_enabled,omitempty"`                  // When false, node will not be assigned replications
 	SGReplicateWebsocketPingInterval *int                             `json:"sgreplicate_websocket_heartbeat_secs,omitempty"` // If set, uses this duration as a custom heartbeat interval for websocket ping frames
 	Replications                     map[string]*db.ReplicationConfig `json:"replications,omitempty"`                         // sg-replicate replication definitions
 	ServeInsecureAttachmentTypes     bool                             `json:"serve_insecure_attachment_types,omitempty"`      // Attachment content type will bypass the content-disposition handling, default false
 	QueryPaginationLimit             *int                             `json:"query_pagination_limit,omitempty"`               // Query limit to be used during pagination of large queries
+	MaxChangesLimit                  *int                             `json:"max_changes_limit,omitempty"`                    // Max results returned by a non-continuous changes request. Defaults to 10000, 0 means no limit
+	ChannelVerificationSampleRate    *float64                         `json:"channel_verification_sample_rate,omitempty"`     // Fraction (0-1) of cached doc revisions whose channels are verified against the current sync function. Defaults to 0 (disabled)
 	UserXattrKey                     string                           `json:"user_xattr_key,omitempty"`                       // Key of user xattr that will be accessible from the Sync Function. If empty the feature will be disabled.
 	ClientPartitionWindowSecs        *int                             `json:"client_partition_window_secs,omitempty"`         // How long clients can remain offline for without losing replication metadata. Default 30 days (in seconds)
+	SequenceEpochEnabled             *bool                            `json:"sequence_epoch_enabled,omitempty"`               // Whether changes feed last_seq values include the database's sequence epoch, to detect bucket flush/restore
+	FilterExpiredTombstones          *bool                            `json:"filter_expired_tombstones,omitempty"`            // Whether changes feeds omit tombstones older than the client partition window from clients that have synced since the deletion
+	ResyncAdvisor                    *ResyncAdvisorConfig             `json:"resync_advisor,omitempty"`                       // Config for the resync advisor, which flags the database as needing a resync - disabled when not set
+	MaxChangesFeeds                  *int                             `json:"max_changes_feeds,omitempty"`                    // Max active continuous, longpoll and websocket changes feeds from non-admin clients. 0 means no limit
+	SoftMaxChangesFeeds              *int                             `json:"soft_max_changes_feeds,omitempty"`               // Max active changes feeds above which guest feeds are rejected. 0 means no limit
+	ChannelWebhooks                  []ChannelWebhookConfig           `json:"channel_webhooks,omitempty"`                     // Webhooks notified of changes to channels by the change cache
 }
 
 type DeltaSyncConfig struct {
@@ -211,6 +237,20 @@ type DeltaSyncConfig struct {
 	RevMaxAgeSeconds *uint32 `json:"rev_max_age_seconds,omitempty"` // The number of seconds deltas for old revs are available for
 }
 
+type ResyncAdvisorConfig struct {
+	WindowSecs                  *uint32 `json:"window_secs,omitempty"`                    // Sliding window over which signals are counted.  Defaults to an hour
+	AbandonedSeqsThreshold      *int64  `json:"abandoned_seqs_threshold,omitempty"`       // Abandoned skipped sequences within the window that warrant a resync - 0 ignores them
+	ChannelDivergenceThreshold  *int64  `json:"channel_divergence_threshold,omitempty"`   // Sampled channel verification divergences within the window that warrant a resync - 0 ignores them
+	MigrateCasMismatchThreshold *int64  `json:"migrate_cas_mismatch_threshold,omitempty"` // CAS mismatches migrating legacy metadata within the window that warrant a resync - 0 ignores them
+}
+
+// ChannelWebhookConfig configures a webhook the change cache notifies of changes to a set of channels.
+type ChannelWebhookConfig struct {
+	URL       string   `json:"url"`                  // URL to POST notifications to
+	Channels  []string `json:"channels"`             // Channel names, or channel name prefixes followed by *
+	TimeoutMs *uint32  `json:"timeout_ms,omitempty"` // Timeout of each POST.  Defaults to 10 seconds
+}
+
 type DeprecatedOptions struct {
 }
 
@@ -256,7 +296,9 @@ type CacheConfig struct {
 }
 
 // ***************************************************************
+//
 //	Kept around for CBG-356 backwards compatability
+//
 // ***************************************************************
 type DeprecatedCacheConfig struct {
 	DeprecatedCachePendingSeqMaxWait *uint32 `json:"max_wait_pending,omitempty"`         // Max wait for pending sequence before skipping
@@ -274,17 +316,34 @@ type RevCacheConfig struct {
 }
 
 type ChannelCacheConfig struct {
-	MaxNumber            *int    `json:"max_number,omitempty"`                 // Maximum number of channel caches which will exist at any one point
-	HighWatermarkPercent *int    `json:"compact_high_watermark_pct,omitempty"` // High watermark for channel cache eviction (percent)
-	LowWatermarkPercent  *int    `json:"compact_low_watermark_pct,omitempty"`  // Low watermark for channel cache eviction (percent)
-	MaxWaitPending       *uint32 `json:"max_wait_pending,omitempty"`           // Max wait for pending sequence before skipping
-	MaxNumPending        *int    `json:"max_num_pending,omitempty"`            // Max number of pending sequences before skipping
-	MaxWaitSkipped       *uint32 `json:"max_wait_skipped,omitempty"`           // Max wait for skipped sequence before abandoning
-	EnableStarChannel    *bool   `json:"enable_star_channel,omitempty"`        // Enable star channel
-	MaxLength            *int    `json:"max_length,omitempty"`                 // Maximum number of entries maintained in cache per channel
-	MinLength            *int    `json:"min_length,omitempty"`                 // Minimum number of entries maintained in cache per channel
-	ExpirySeconds        *int    `json:"expiry_seconds,omitempty"`             // Time (seconds) to keep entries in cache beyond the minimum retained
-	DeprecatedQueryLimit *int    `json:"query_limit,omitempty"`                // Limit used for channel queries, if not specified by client DEPRECATED in favour of db.QueryPaginationLimit
+	MaxNumber            *int     `json:"max_number,omitempty"`                 // Maximum number of channel caches which will exist at any one point
+	HighWatermarkPercent *int     `json:"compact_high_watermark_pct,omitempty"` // High watermark for channel cache eviction (percent)
+	LowWatermarkPercent  *int     `json:"compact_low_watermark_pct,omitempty"`  // Low watermark for channel cache eviction (percent)
+	MaxWaitPending       *uint32  `json:"max_wait_pending,omitempty"`           // Max wait for pending sequence before skipping
+	MinWaitPending       *uint32  `json:"min_wait_pending,omitempty"`           // Min wait for pending sequence before skipping, when adaptive_wait_pending is set
+	AdaptiveWaitPending  *bool    `json:"adaptive_wait_pending,omitempty"`      // Adapt the wait for pending sequences to observed out of order arrival, between min_wait_pending and max_wait_pending
+	MaxNumPending        *int     `json:"max_num_pending,omitempty"`            // Max number of pending sequences before skipping
+	MaxPendingMemoryMB   *int     `json:"max_pending_memory_mb,omitempty"`      // Estimated memory (MB) of pending sequences before skipping.  0 means no limit
+	MaxWaitSkipped       *uint32  `json:"max_wait_skipped,omitempty"`           // Max wait for skipped sequence before abandoning
+	MaxNumSkipped        *int     `json:"max_num_skipped,omitempty"`            // Max number of skipped sequences before the oldest are abandoned early.  0 means no limit
+	MaxWaitSequence      *uint32  `json:"max_wait_sequence,omitempty"`          // Max wait for a sequence to be cached, when a request waits for it
+	FeedLagWarnThreshold *uint32  `json:"feed_lag_warn_threshold,omitempty"`    // Feed latency (ms) above which a change is counted and warned about
+	FeedStageSampleRate  *int     `json:"feed_stage_sample_rate,omitempty"`     // Time the processing stages of one in every feed_stage_sample_rate feed events.  0 disables sampling
+	NotifyDebounce       *uint32  `json:"notify_debounce,omitempty"`            // Interval (ms) over which changes are batched before changes feeds are notified.  Zero notifies each change immediately
+	BypassBuffering      *bool    `json:"bypass_sequence_buffering,omitempty"`  // Cache changes as they arrive rather than in sequence order.  Only for databases with a single writer node
+	EnableStarChannel    *bool    `json:"enable_star_channel,omitempty"`        // Enable star channel
+	MaxLength            *int     `json:"max_length,omitempty"`                 // Maximum number of entries maintained in cache per channel
+	MinLength            *int     `json:"min_length,omitempty"`                 // Minimum number of entries maintained in cache per channel
+	MaxMemoryMB          *int     `json:"max_memory_mb,omitempty"`              // Estimated memory (MB) the database's channel caches are reduced below, by evicting idle channels and pruning.  0 means no limit
+	RemovalRetention     *int     `json:"removal_retention,omitempty"`          // Number of the most recent removals kept per channel once pruned, and served to backfills the channel query may not find them in
+	NameNormalization    *string  `json:"channel_name_normalization,omitempty"` // Channel name normalization policy - "preserve" (default) or "lowercase", under which channel names differing only by case share a cache and changes feed
+	UncachedChannels     []string `json:"uncached_channels,omitempty"`          // Channels that are never cached, by name or name prefix/suffix glob (e.g. "user.*").  Reads of these channels always query
+	ExpirySeconds        *int     `json:"expiry_seconds,omitempty"`             // Time (seconds) to keep entries in cache beyond the minimum retained
+	Handoff              *bool    `json:"handoff,omitempty"`                    // Write a snapshot of the largest channel caches on graceful shutdown, and seed channel caches from one on startup
+	HandoffMaxChannels   *int     `json:"handoff_max_channels,omitempty"`       // Maximum number of channel caches in a handoff snapshot
+	HandoffMaxSizeKB     *int     `json:"handoff_max_size_kb,omitempty"`        // Maximum size (KB) of a handoff snapshot
+	HandoffMaxAgeSeconds *int     `json:"handoff_max_age_seconds,omitempty"`    // Maximum age (seconds) of a handoff snapshot that channel caches are seeded from
+	DeprecatedQueryLimit *int     `json:"query_limit,omitempty"`                // Limit used for channel queries, if not specified by client DEPRECATED in favour of db.QueryPaginationLimit
 }
 
 type UnsupportedServerConfig struct {
@@ -513,6 +572,28 @@ func (dbConfig *DbConfig) validateVersion(isEnterpriseEdition bool) (errorMessag
 			fmt.Sprintf("%g-%g", db.CompactIntervalMinDays, db.CompactIntervalMaxDays)))
 	}
 
+	if dbConfig.CompactOpsPerSec != nil && *dbConfig.CompactOpsPerSec < 1 {
+		errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "compact_ops_per_sec", 1))
+	}
+
+	// Tombstones must outlive the client partition window, so that clients syncing within it are sent deletions
+	if dbConfig.TombstoneRetentionSecs != nil {
+		clientPartitionWindowSecs := int64(base.DefaultClientPartitionWindow.Seconds())
+		if dbConfig.ClientPartitionWindowSecs != nil {
+			clientPartitionWindowSecs = int64(*dbConfig.ClientPartitionWindowSecs)
+		}
+		if int64(*dbConfig.TombstoneRetentionSecs) <= clientPartitionWindowSecs {
+			errorMessages = multierror.Append(errorMessages, fmt.Errorf("Invalid configuration - tombstone_retention_secs (%d) must be greater than client_partition_window_secs (%d)",
+				*dbConfig.TombstoneRetentionSecs, clientPartitionWindowSecs))
+		}
+	}
+
+	if dbConfig.CacheProfile != "" {
+		if _, err := db.CacheProfileOptions(dbConfig.CacheProfile); err != nil {
+			errorMessages = multierror.Append(errorMessages, fmt.Errorf("Invalid configuration - cache_profile: %w", err))
+		}
+	}
+
 	if dbConfig.CacheConfig != nil {
 
 		if dbConfig.CacheConfig.ChannelCacheConfig != nil {
@@ -536,21 +617,59 @@ func (dbConfig *DbConfig) validateVersion(isEnterpriseEdition bool) (errorMessag
 			if dbConfig.CacheConfig.ChannelCacheConfig.MaxNumPending != nil && *dbConfig.CacheConfig.ChannelCacheConfig.MaxNumPending < 1 {
 				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.max_num_pending", 1))
 			}
+			if dbConfig.CacheConfig.ChannelCacheConfig.MaxPendingMemoryMB != nil && *dbConfig.CacheConfig.ChannelCacheConfig.MaxPendingMemoryMB < 0 {
+				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.max_pending_memory_mb", 0))
+			}
 			if dbConfig.CacheConfig.ChannelCacheConfig.MaxWaitPending != nil && *dbConfig.CacheConfig.ChannelCacheConfig.MaxWaitPending < 1 {
 				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.max_wait_pending", 1))
 			}
 			if dbConfig.CacheConfig.ChannelCacheConfig.MaxWaitSkipped != nil && *dbConfig.CacheConfig.ChannelCacheConfig.MaxWaitSkipped < 1 {
 				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.max_wait_skipped", 1))
 			}
+			if dbConfig.CacheConfig.ChannelCacheConfig.MaxNumSkipped != nil && *dbConfig.CacheConfig.ChannelCacheConfig.MaxNumSkipped < 0 {
+				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.max_num_skipped", 0))
+			}
+			if dbConfig.CacheConfig.ChannelCacheConfig.MaxWaitSequence != nil && *dbConfig.CacheConfig.ChannelCacheConfig.MaxWaitSequence < 1 {
+				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.max_wait_sequence", 1))
+			}
+			if dbConfig.CacheConfig.ChannelCacheConfig.FeedLagWarnThreshold != nil && *dbConfig.CacheConfig.ChannelCacheConfig.FeedLagWarnThreshold < 1 {
+				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.feed_lag_warn_threshold", 1))
+			}
+			if dbConfig.CacheConfig.ChannelCacheConfig.FeedStageSampleRate != nil && *dbConfig.CacheConfig.ChannelCacheConfig.FeedStageSampleRate < 0 {
+				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.feed_stage_sample_rate", 0))
+			}
 			if dbConfig.CacheConfig.ChannelCacheConfig.MaxLength != nil && *dbConfig.CacheConfig.ChannelCacheConfig.MaxLength < 1 {
 				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.max_length", 1))
 			}
 			if dbConfig.CacheConfig.ChannelCacheConfig.MinLength != nil && *dbConfig.CacheConfig.ChannelCacheConfig.MinLength < 1 {
 				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.min_length", 1))
 			}
+			if dbConfig.CacheConfig.ChannelCacheConfig.MaxMemoryMB != nil && *dbConfig.CacheConfig.ChannelCacheConfig.MaxMemoryMB < 0 {
+				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.max_memory_mb", 0))
+			}
+			if dbConfig.CacheConfig.ChannelCacheConfig.RemovalRetention != nil && *dbConfig.CacheConfig.ChannelCacheConfig.RemovalRetention < 0 {
+				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.removal_retention", 0))
+			}
+			if dbConfig.CacheConfig.ChannelCacheConfig.NameNormalization != nil {
+				if err := db.ValidateChannelNameNormalization(*dbConfig.CacheConfig.ChannelCacheConfig.NameNormalization); err != nil {
+					errorMessages = multierror.Append(errorMessages, fmt.Errorf("Invalid configuration - cache.channel_cache.channel_name_normalization: %w", err))
+				}
+			}
+			if err := db.ValidateChannelNamePatterns(dbConfig.CacheConfig.ChannelCacheConfig.UncachedChannels); err != nil {
+				errorMessages = multierror.Append(errorMessages, fmt.Errorf("Invalid configuration - cache.channel_cache.uncached_channels: %w", err))
+			}
 			if dbConfig.CacheConfig.ChannelCacheConfig.ExpirySeconds != nil && *dbConfig.CacheConfig.ChannelCacheConfig.ExpirySeconds < 1 {
 				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.expiry_seconds", 1))
 			}
+			if dbConfig.CacheConfig.ChannelCacheConfig.HandoffMaxChannels != nil && *dbConfig.CacheConfig.ChannelCacheConfig.HandoffMaxChannels < 1 {
+				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.handoff_max_channels", 1))
+			}
+			if dbConfig.CacheConfig.ChannelCacheConfig.HandoffMaxSizeKB != nil && *dbConfig.CacheConfig.ChannelCacheConfig.HandoffMaxSizeKB < 1 {
+				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.handoff_max_size_kb", 1))
+			}
+			if dbConfig.CacheConfig.ChannelCacheConfig.HandoffMaxAgeSeconds != nil && *dbConfig.CacheConfig.ChannelCacheConfig.HandoffMaxAgeSeconds < 1 {
+				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.handoff_max_age_seconds", 1))
+			}
 			if dbConfig.CacheConfig.ChannelCacheConfig.MaxNumber != nil && *dbConfig.CacheConfig.ChannelCacheConfig.MaxNumber < db.MinimumChannelCacheMaxNumber {
 				errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "cache.channel_cache.max_number", db.MinimumChannelCacheMaxNumber))
 			}
@@ -624,6 +743,24 @@ func (dbConfig *DbConfig) validateVersion(isEnterpriseEdition bool) (errorMessag
 		}
 	}
 
+	if dbConfig.ImportLargeDocSize != nil && *dbConfig.ImportLargeDocSize < 0 {
+		errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "import_large_doc_size", 0))
+	}
+
+	if dbConfig.ImportMaxDocSize != nil && *dbConfig.ImportMaxDocSize < 0 {
+		errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "import_max_doc_size", 0))
+	}
+
+	if dbConfig.ImportMaxInFlightBytes != nil && *dbConfig.ImportMaxInFlightBytes < 0 {
+		errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "import_max_in_flight_bytes", 0))
+	}
+
+	if dbConfig.UserXattrKey != "" {
+		if err := base.ValidateXattrKey(dbConfig.UserXattrKey); err != nil {
+			errorMessages = multierror.Append(errorMessages, fmt.Errorf("Invalid configuration - user_xattr_key: %w", err))
+		}
+	}
+
 	if dbConfig.DeprecatedPool != nil {
 		base.Warnf(`"pool" config option is not supported. The pool will be set to "default". The option should be removed from config file.`)
 	}
@@ -876,6 +1013,14 @@ func (config *ServerConfig) validate() (errorMessages error) {
 		}
 	}
 
+	if config.MaxChangesFeeds != nil && *config.MaxChangesFeeds < 0 {
+		errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "max_changes_feeds", 0))
+	}
+
+	if config.ChannelCacheMaxMemoryMB != nil && *config.ChannelCacheMaxMemoryMB < 0 {
+		errorMessages = multierror.Append(errorMessages, fmt.Errorf(minValueErrorMsg, "channel_cache_max_memory_mb", 0))
+	}
+
 	return errorMessages
 }
 
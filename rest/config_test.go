@@ -127,6 +127,25 @@ func TestConfigValidation(t *testing.T) {
 			name:   "Compact Interval just right",
 			config: `{"databases": {"db":{"compact_interval_days": 0.04}}}`,
 		},
+		{
+			name:   "Tombstone retention within default client partition window",
+			config: `{"databases": {"db":{"tombstone_retention_secs": 86400}}}`,
+			err:    "tombstone_retention_secs (86400) must be greater than client_partition_window_secs (2592000)",
+		},
+		{
+			name:   "Tombstone retention equal to client partition window",
+			config: `{"databases": {"db":{"tombstone_retention_secs": 86400, "client_partition_window_secs": 86400}}}`,
+			err:    "tombstone_retention_secs (86400) must be greater than client_partition_window_secs (86400)",
+		},
+		{
+			name:   "Tombstone retention beyond client partition window",
+			config: `{"databases": {"db":{"tombstone_retention_secs": 86401, "client_partition_window_secs": 86400}}}`,
+		},
+		{
+			name:   "Compact ops per sec too low",
+			config: `{"databases": {"db":{"compact_ops_per_sec": 0}}}`,
+			err:    "minimum value for compact_ops_per_sec is: 1",
+		},
 	}
 
 	for _, test := range tests {
@@ -299,6 +318,55 @@ func TestConfigValidationImportPartitions(t *testing.T) {
 	}
 }
 
+func TestConfigValidationCacheProfile(t *testing.T) {
+	for _, profile := range db.CacheProfileNames() {
+		dbConfig := &DbConfig{CacheProfile: profile}
+		assert.Nil(t, dbConfig.validate(), "Expected cache profile %q to be valid", profile)
+	}
+
+	dbConfig := &DbConfig{CacheProfile: "huge"}
+	errorMessages := dbConfig.validate()
+	require.NotNil(t, errorMessages)
+	multiError, ok := errorMessages.(*multierror.Error)
+	require.True(t, ok)
+	require.Equal(t, 1, multiError.Len())
+	assert.EqualError(t, multiError.Errors[0], `Invalid configuration - cache_profile: unknown cache profile "huge" - must be one of balanced, high_throughput, low_memory`)
+}
+
+func TestConfigValidationUserXattrKey(t *testing.T) {
+	tests := []struct {
+		name         string
+		userXattrKey string
+		err          string
+	}{
+		{name: "Plain key", userXattrKey: "channels"},
+		{name: "Key with dot", userXattrKey: "user.channels"},
+		{name: "Key with backtick", userXattrKey: "user`channels"},
+		{name: "Unicode key", userXattrKey: "каналы"},
+		{name: "Too long", userXattrKey: "user_channels_key", err: `Invalid configuration - user_xattr_key: invalid xattr key "user_channels_key": must not be longer than 16 bytes`},
+		{name: "Virtual xattr", userXattrKey: "$document", err: `Invalid configuration - user_xattr_key: invalid xattr key "$document": must not start with $, which is reserved for virtual xattrs`},
+		{name: "Whitespace", userXattrKey: "user channels", err: `Invalid configuration - user_xattr_key: invalid xattr key "user channels": must not contain control or whitespace characters`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			dbConfig := &DbConfig{UserXattrKey: test.userXattrKey}
+			errorMessages := dbConfig.validate()
+			if test.err == "" {
+				assert.Nil(tt, errorMessages)
+				return
+			}
+			require.NotNil(tt, errorMessages)
+			multiError, ok := errorMessages.(*multierror.Error)
+			require.True(tt, ok)
+			require.Equal(tt, 1, multiError.Len())
+			assert.EqualError(tt, multiError.Errors[0], test.err)
+			var invalidKeyErr *base.InvalidXattrKeyError
+			assert.True(tt, errors.As(multiError.Errors[0], &invalidKeyErr))
+		})
+	}
+}
+
 // TestLoadServerConfigExamples will run LoadServerConfig for configs found under the examples directory.
 func TestLoadServerConfigExamples(t *testing.T) {
 	const exampleLogDirectory = "../examples/"
@@ -12,28 +12,49 @@ package rest
 
 import (
 	"compress/gzip"
+	"compress/zlib"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 )
 
+// Response encodings supported by EncodedResponseWriter
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// responseCompressor is implemented by the gzip and deflate writers used to compress responses.
+type responseCompressor interface {
+	io.Writer
+	Flush() error
+}
+
 // An implementation of http.ResponseWriter that wraps another instance and transparently applies
 // GZip compression when appropriate.
 type EncodedResponseWriter struct {
 	http.ResponseWriter
-	gz            *gzip.Writer
-	status        int
-	sniffDone     bool
-	headerWritten bool
+	compressor        responseCompressor                   // Set once the response is being compressed
+	encoding          string                               // Encoding applied to compressible responses, or empty when none is accepted
+	forceEncoding     bool                                 // Set by compressAs - compress regardless of content type
+	onClose           func(uncompressed, compressed int64) // Optional callback with the size of a compressed response
+	uncompressedBytes int64                                // Bytes written to the compressor
+	compressedBytes   countingWriter                       // Counts bytes written by the compressor
+	status            int
+	sniffDone         bool
+	headerWritten     bool
 }
 
-// Creates a new EncodedResponseWriter, or returns nil if the request doesn't allow encoded responses.
+// Creates a new EncodedResponseWriter, or returns nil if the request doesn't allow encoded responses.  Responses are
+// only compressed with gzip, unless a handler negotiates another encoding with compressAs.
 func NewEncodedResponseWriter(response http.ResponseWriter, rq *http.Request) *EncodedResponseWriter {
 	isWebSocketRequest := strings.ToLower(rq.Header.Get("Upgrade")) == "websocket" &&
 		strings.Contains(strings.ToLower(rq.Header.Get("Connection")), "upgrade")
 
-	if isWebSocketRequest || !strings.Contains(rq.Header.Get("Accept-Encoding"), "gzip") ||
+	acceptEncoding := rq.Header.Get("Accept-Encoding")
+	if isWebSocketRequest || negotiateEncoding(acceptEncoding, encodingGzip, encodingDeflate) == "" ||
 		rq.Method == "HEAD" || rq.Method == "PUT" || rq.Method == "DELETE" {
 		return nil
 	}
@@ -49,7 +70,7 @@ func NewEncodedResponseWriter(response http.ResponseWriter, rq *http.Request) *E
 		}
 	}
 
-	return &EncodedResponseWriter{ResponseWriter: response}
+	return &EncodedResponseWriter{ResponseWriter: response, encoding: negotiateEncoding(acceptEncoding, encodingGzip)}
 }
 
 func (w *EncodedResponseWriter) WriteHeader(status int) {
@@ -65,8 +86,9 @@ func (w *EncodedResponseWriter) WriteHeader(status int) {
 
 func (w *EncodedResponseWriter) Write(b []byte) (int, error) {
 	w.sniff(b)
-	if w.gz != nil {
-		return w.gz.Write(b)
+	if w.compressor != nil {
+		w.uncompressedBytes += int64(len(b))
+		return w.compressor.Write(b)
 	} else {
 		return w.ResponseWriter.Write(b)
 	}
@@ -76,6 +98,18 @@ func (w *EncodedResponseWriter) disableCompression() {
 	w.sniffDone = true
 }
 
+// compressAs compresses the response with encoding regardless of its content type, for handlers that negotiate the
+// response encoding themselves.  onClose, if non-nil, is called with the size of the response before and after
+// compression once it's complete.  Has no effect once the response has started, or when encoding is empty.
+func (w *EncodedResponseWriter) compressAs(encoding string, onClose func(uncompressed, compressed int64)) {
+	if w.sniffDone || encoding == "" {
+		return
+	}
+	w.encoding = encoding
+	w.forceEncoding = true
+	w.onClose = onClose
+}
+
 func (w *EncodedResponseWriter) sniff(bytes []byte) {
 	if w.sniffDone {
 		return
@@ -89,23 +123,29 @@ func (w *EncodedResponseWriter) sniff(bytes []byte) {
 	}
 
 	// Can/should we compress the response?
-	if w.status >= 300 || w.Header().Get("Content-Encoding") != "" ||
-		(!strings.HasPrefix(respType, "application/json") && !strings.HasPrefix(respType, "text/") && !strings.HasPrefix(respType, "multipart/mixed")) {
+	if w.encoding == "" || w.status >= 300 || w.Header().Get("Content-Encoding") != "" ||
+		(!w.forceEncoding && !strings.HasPrefix(respType, "application/json") && !strings.HasPrefix(respType, "text/") && !strings.HasPrefix(respType, "multipart/mixed")) {
 		return
 	}
 
 	// OK, we can compress the response:
 	//base.Debugf(base.KeyHTTP, "GZip-compressing response")
-	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Encoding", w.encoding)
 	w.Header().Del("Content-Length") // length is unknown due to compression
 
-	w.gz = GetGZipWriter(w.ResponseWriter)
+	w.compressedBytes.Writer = w.ResponseWriter
+	if w.encoding == encodingDeflate {
+		w.compressor = getDeflateWriter(&w.compressedBytes)
+	} else {
+		w.compressor = GetGZipWriter(&w.compressedBytes)
+	}
 }
 
-// Flushes the GZip encoder buffer, and if possible flushes output to the network.
+// Flushes the compressor's buffer, and if possible flushes output to the network.  Flushing ends the compressor's
+// current block, so that everything written so far can be decompressed by the client.
 func (w *EncodedResponseWriter) Flush() {
-	if w.gz != nil {
-		_ = w.gz.Flush()
+	if w.compressor != nil {
+		_ = w.compressor.Flush()
 	}
 	switch r := w.ResponseWriter.(type) {
 	case http.Flusher:
@@ -113,11 +153,20 @@ func (w *EncodedResponseWriter) Flush() {
 	}
 }
 
-// The writer should be closed when output is complete, to flush the GZip encoder buffer.
+// The writer should be closed when output is complete, to flush the compressor's buffer.
 func (w *EncodedResponseWriter) Close() {
-	if w.gz != nil {
-		ReturnGZipWriter(w.gz)
-		w.gz = nil
+	if w.compressor == nil {
+		return
+	}
+	switch compressor := w.compressor.(type) {
+	case *gzip.Writer:
+		ReturnGZipWriter(compressor)
+	case *zlib.Writer:
+		returnDeflateWriter(compressor)
+	}
+	w.compressor = nil
+	if w.onClose != nil {
+		w.onClose(w.uncompressedBytes, w.compressedBytes.count)
 	}
 }
 
@@ -149,3 +198,75 @@ func ReturnGZipWriter(gz *gzip.Writer) {
 	_ = gz.Close()
 	zipperCache.Put(gz)
 }
+
+var deflaterCache sync.Pool
+
+// Gets a deflate writer from the pool, or creates a new one if the pool is empty.  HTTP's deflate content coding is
+// the zlib format (RFC 1950), not raw deflate data:
+func getDeflateWriter(writer io.Writer) *zlib.Writer {
+	if deflater, ok := deflaterCache.Get().(*zlib.Writer); ok {
+		deflater.Reset(writer)
+		return deflater
+	}
+	deflater, _ := zlib.NewWriterLevel(writer, zlib.DefaultCompression) // Only errors for an invalid level
+	return deflater
+}
+
+// Closes a deflate writer and returns it to the pool:
+func returnDeflateWriter(deflater *zlib.Writer) {
+	_ = deflater.Close()
+	deflaterCache.Put(deflater)
+}
+
+// countingWriter counts the bytes written to the wrapped writer.
+type countingWriter struct {
+	io.Writer
+	count int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.count += int64(n)
+	return n, err
+}
+
+// negotiateEncoding returns the supported encoding the client prefers according to an Accept-Encoding header value,
+// or empty when none are acceptable.  Encodings are ranked by quality value, then by their order in supported.  A
+// wildcard applies to any supported encoding not listed explicitly.
+func negotiateEncoding(acceptEncoding string, supported ...string) string {
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(coding, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if name == "*" {
+			wildcard = quality
+		} else {
+			qualities[name] = quality
+		}
+	}
+
+	preferred, preferredQuality := "", 0.0
+	for _, encoding := range supported {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality = wildcard
+		}
+		if quality > preferredQuality {
+			preferred, preferredQuality = encoding, quality
+		}
+	}
+	return preferred
+}
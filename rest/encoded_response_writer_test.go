@@ -0,0 +1,73 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package rest
+
+import (
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	testCases := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"foo, gzip, bar", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"GZIP;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"gzip;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0.1, deflate;q=0.5", "deflate"},
+		{"gzip;q=0, *", "deflate"},
+		{"br;q=1.0, gzip;q=0.8", "gzip"},
+	}
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, negotiateEncoding(testCase.acceptEncoding, encodingGzip, encodingDeflate), "Unexpected encoding for %q", testCase.acceptEncoding)
+	}
+
+	// Only gzip is used for responses that don't negotiate their encoding
+	assert.Equal(t, "", negotiateEncoding("deflate", encodingGzip))
+}
+
+// Validates that a deflate-encoded response is in the zlib format that HTTP's deflate content coding specifies.
+func TestEncodedResponseWriterDeflate(t *testing.T) {
+	rq := httptest.NewRequest(http.MethodGet, "/db/_changes", nil)
+	rq.Header.Set("Accept-Encoding", "deflate")
+	recorder := httptest.NewRecorder()
+	writer := NewEncodedResponseWriter(recorder, rq)
+	require.NotNil(t, writer)
+
+	var uncompressed int64
+	writer.compressAs(encodingDeflate, func(uncompressedBytes, compressedBytes int64) { uncompressed = uncompressedBytes })
+	writer.Header().Set("Content-Type", "application/json")
+	_, err := writer.Write([]byte(`{"results":[]}`))
+	require.NoError(t, err)
+	writer.Close()
+
+	assert.Equal(t, encodingDeflate, recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, int64(len(`{"results":[]}`)), uncompressed)
+	reader, err := zlib.NewReader(recorder.Body)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, `{"results":[]}`, string(body))
+}
@@ -210,9 +210,11 @@ func (h *handler) invoke(method handlerMethod) error {
 		if err != nil {
 			return err
 		}
-		h.db.Ctx = context.WithValue(context.Background(), base.LogContextKey{},
-			base.LogContext{CorrelationID: h.formatSerialNumber()},
-		)
+		logCtx := base.LogContext{CorrelationID: h.formatSerialNumber()}
+		h.db.Ctx = context.WithValue(context.Background(), base.LogContextKey{}, logCtx)
+		// Document writes on behalf of the request stop retrying once the client disconnects.  Work that can outlive the
+		// request, such as a resync, uses the detached db.Ctx
+		h.db.RequestCtx = context.WithValue(h.rq.Context(), base.LogContextKey{}, logCtx)
 	}
 
 	if base.EnableLogHTTPBodies {
@@ -2193,3 +2193,50 @@ func TestDeletedDocumentImportWithImportFilter(t *testing.T) {
 	syncMeta = respBody[base.SyncPropertyName].(map[string]interface{})
 	assert.NotEmpty(t, syncMeta["rev"].(string))
 }
+
+// Test that runtime import suppression skips feed import of documents matching the suppressed prefix, until removed.
+func TestImportSuppressionByPrefix(t *testing.T) {
+	SkipImportTestsIfNotEnabled(t)
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DbConfig{
+			AutoImport: true,
+		},
+	})
+	defer rt.Close()
+
+	response := rt.SendAdminRequest("PUT", "/db/_import_suppression", `{"prefix": ""}`)
+	assertStatus(t, response, http.StatusBadRequest)
+	response = rt.SendAdminRequest("PUT", "/db/_import_suppression", `{"prefix": "bulk::", "ttl_secs": 600}`)
+	assertStatus(t, response, http.StatusOK)
+
+	importStats := rt.GetDatabase().DbStats.SharedBucketImport()
+	_, err := rt.Bucket().Add("bulk::1", 0, map[string]interface{}{"foo": "bar"})
+	require.NoError(t, err)
+	_, err = rt.Bucket().Add("doc1", 0, map[string]interface{}{"foo": "bar"})
+	require.NoError(t, err)
+	require.NoError(t, rt.WaitForCondition(func() bool {
+		return importStats.ImportCount.Value() == 1 && importStats.ImportSuppressedCount.Value() == 1
+	}))
+
+	var suppressions []db.ImportSuppression
+	response = rt.SendAdminRequest("GET", "/db/_import_suppression", "")
+	assertStatus(t, response, http.StatusOK)
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &suppressions))
+	require.Len(t, suppressions, 1)
+	assert.Equal(t, "bulk::", suppressions[0].Prefix)
+	assert.Equal(t, int64(1), suppressions[0].SuppressedCount)
+
+	// Removing the suppression resumes import of matching documents
+	response = rt.SendAdminRequest("DELETE", "/db/_import_suppression?prefix=bulk::", "")
+	assertStatus(t, response, http.StatusOK)
+	response = rt.SendAdminRequest("DELETE", "/db/_import_suppression?prefix=bulk::", "")
+	assertStatus(t, response, http.StatusNotFound)
+
+	_, err = rt.Bucket().Add("bulk::2", 0, map[string]interface{}{"foo": "bar"})
+	require.NoError(t, err)
+	require.NoError(t, rt.WaitForCondition(func() bool {
+		return importStats.ImportCount.Value() == 2
+	}))
+	assert.Equal(t, int64(1), importStats.ImportSuppressedCount.Value())
+}
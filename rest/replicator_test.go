@@ -235,6 +235,104 @@ func TestActiveReplicatorPullBasic(t *testing.T) {
 	assert.Equal(t, strconv.FormatUint(remoteDoc.Sequence, 10), ar.GetStatus().LastSeqPull)
 }
 
+// TestActiveReplicatorPullChannelAccessCheck verifies that a bychannel pull replication detects filter channels
+// that the remote user doesn't have access to, and when configured to, rejects the replication only when none of its
+// channels are accessible.
+func TestActiveReplicatorPullChannelAccessCheck(t *testing.T) {
+
+	if base.GTestBucketPool.NumUsableBuckets() < 2 {
+		t.Skipf("test requires at least 2 usable test buckets")
+	}
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyReplicate, base.KeyHTTP)()
+
+	const (
+		username = "alice"
+		password = "pass"
+	)
+
+	// Passive
+	rt2 := NewRestTester(t, &RestTesterConfig{
+		TestBucket: base.GetTestBucket(t),
+		DatabaseConfig: &DbConfig{
+			Users: map[string]*db.PrincipalConfig{
+				username: {
+					Password:         base.StringPtr(password),
+					ExplicitChannels: base.SetOf("allowed"),
+				},
+			},
+		},
+	})
+	defer rt2.Close()
+
+	srv := httptest.NewServer(rt2.TestPublicHandler())
+	defer srv.Close()
+
+	passiveDBURL, err := url.Parse(srv.URL + "/db")
+	require.NoError(t, err)
+	passiveDBURL.User = url.UserPassword(username, password)
+
+	inaccessible, err := db.RemoteInaccessibleChannels(*passiveDBURL, false, []string{"allowed", "disallowed"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"disallowed"}, inaccessible)
+
+	// Access is unknown without a remote user, so no channels are reported as inaccessible
+	anonDBURL := *passiveDBURL
+	anonDBURL.User = nil
+	inaccessible, err = db.RemoteInaccessibleChannels(anonDBURL, false, []string{"allowed", "disallowed"})
+	require.NoError(t, err)
+	assert.Nil(t, inaccessible)
+
+	adminSrv := httptest.NewServer(rt2.TestAdminHandler())
+	defer adminSrv.Close()
+	adminDBURL, err := url.Parse(adminSrv.URL + "/db")
+	require.NoError(t, err)
+	inaccessible, err = db.RemoteInaccessibleChannels(*adminDBURL, false, []string{"allowed", "disallowed"})
+	require.NoError(t, err)
+	assert.Nil(t, inaccessible)
+
+	// Active
+	rt1 := NewRestTester(t, &RestTesterConfig{
+		TestBucket: base.GetTestBucket(t),
+	})
+	defer rt1.Close()
+
+	newReplicator := func(id string, filterChannels []string) *db.ActiveReplicator {
+		return db.NewActiveReplicator(&db.ActiveReplicatorConfig{
+			ID:          id,
+			Direction:   db.ActiveReplicatorTypePull,
+			RemoteDBURL: passiveDBURL,
+			ActiveDB: &db.Database{
+				DatabaseContext: rt1.GetDatabase(),
+			},
+			Continuous:                 true,
+			Filter:                     base.ByChannelFilter,
+			FilterChannels:             filterChannels,
+			RejectInaccessibleChannels: true,
+			ChangesBatchSize:           200,
+			ReplicationStatsMap:        base.SyncGatewayStats.NewDBStats(id, false, false, false).DBReplicatorStats(id),
+		})
+	}
+
+	// Only partly outside the grant set - warned about, but not rejected
+	output := base.CaptureConsoleLogOutput(func() {
+		ar := newReplicator(t.Name()+"-partial", []string{"allowed", "disallowed"})
+		require.NoError(t, ar.Start())
+		assert.NoError(t, ar.Stop())
+	})
+	assert.Contains(t, output, "remote user doesn't have access to channels")
+
+	// Entirely outside the grant set
+	ar := newReplicator(t.Name()+"-disallowed", []string{"disallowed", "alsoDisallowed"})
+	defer func() { assert.NoError(t, ar.Stop()) }()
+
+	err = ar.Start()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "disallowed")
+	_, errorMessage := ar.State()
+	assert.Contains(t, errorMessage, "remote user doesn't have access to channels [disallowed alsoDisallowed]")
+}
+
 // TestActiveReplicatorPullAttachments:
 //   - Starts 2 RestTesters, one active, and one passive.
 //   - Creates a document with an attachment on rt2 which can be pulled by the replicator running in rt1.
@@ -1160,6 +1258,93 @@ func TestActiveReplicatorPushAttachments(t *testing.T) {
 	assert.Equal(t, int64(1), ar.Push.GetStats().HandleProveAttachment.Value())
 }
 
+// TestActiveReplicatorPushAttachmentStats:
+//   - Pushes a doc with two attachments from rt1 to rt2, then a second doc referencing the same attachments.
+//   - Verifies the replication's attachment count/byte stats, and that the second doc's attachments are counted as
+//     already known, both for the replication and for the passive database.
+func TestActiveReplicatorPushAttachmentStats(t *testing.T) {
+
+	if base.GTestBucketPool.NumUsableBuckets() < 2 {
+		t.Skipf("test requires at least 2 usable test buckets")
+	}
+
+	defer base.SetUpTestLogging(base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeyReplicate)()
+
+	// Active
+	tb1 := base.GetTestBucket(t)
+	rt1 := NewRestTester(t, &RestTesterConfig{
+		TestBucket: tb1,
+	})
+	defer rt1.Close()
+
+	// Passive
+	tb2 := base.GetTestBucket(t)
+	rt2 := NewRestTester(t, &RestTesterConfig{
+		TestBucket: tb2,
+		DatabaseConfig: &DbConfig{
+			Users: map[string]*db.PrincipalConfig{
+				"alice": {
+					Password:         base.StringPtr("pass"),
+					ExplicitChannels: base.SetOf("alice"),
+				},
+			},
+		},
+	})
+	defer rt2.Close()
+
+	// "hi" and "bye"
+	attachments := `"_attachments":{"hi.txt":{"data":"aGk=","content_type":"text/plain"},"bye.txt":{"data":"Ynll","content_type":"text/plain"}}`
+
+	resp := rt1.SendAdminRequest(http.MethodPut, "/db/"+t.Name()+"doc1", `{"doc_num":1,`+attachments+`,"channels":["alice"]}`)
+	assertStatus(t, resp, http.StatusCreated)
+
+	srv := httptest.NewServer(rt2.TestPublicHandler())
+	defer srv.Close()
+
+	passiveDBURL, err := url.Parse(srv.URL + "/db")
+	require.NoError(t, err)
+	passiveDBURL.User = url.UserPassword("alice", "pass")
+
+	replicationStats := base.SyncGatewayStats.NewDBStats(t.Name(), false, false, false).DBReplicatorStats(t.Name())
+	ar := db.NewActiveReplicator(&db.ActiveReplicatorConfig{
+		ID:          t.Name(),
+		Direction:   db.ActiveReplicatorTypePush,
+		RemoteDBURL: passiveDBURL,
+		ActiveDB: &db.Database{
+			DatabaseContext: rt1.GetDatabase(),
+		},
+		ChangesBatchSize:    200,
+		Continuous:          true,
+		ReplicationStatsMap: replicationStats,
+	})
+	defer func() { assert.NoError(t, ar.Stop()) }()
+
+	assert.NoError(t, ar.Start())
+
+	_, err = rt2.WaitForChanges(1, "/db/_changes?since=0", "", true)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(2), replicationStats.NumAttachmentPushed.Value())
+	assert.Equal(t, int64(5), replicationStats.NumAttachmentBytesPushed.Value())
+	assert.Equal(t, int64(0), replicationStats.NumAttachmentPushedKnown.Value())
+
+	// Second doc references the same attachments, which only need to be proved
+	resp = rt1.SendAdminRequest(http.MethodPut, "/db/"+t.Name()+"doc2", `{"doc_num":2,`+attachments+`,"channels":["alice"]}`)
+	assertStatus(t, resp, http.StatusCreated)
+
+	_, err = rt2.WaitForChanges(2, "/db/_changes?since=0", "", true)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(2), replicationStats.NumAttachmentPushed.Value())
+	assert.Equal(t, int64(5), replicationStats.NumAttachmentBytesPushed.Value())
+	assert.Equal(t, int64(2), replicationStats.NumAttachmentPushedKnown.Value())
+
+	passiveStats := rt2.GetDatabase().DbStats.CBLReplicationPush()
+	assert.Equal(t, int64(2), passiveStats.AttachmentPushCount.Value())
+	assert.Equal(t, int64(5), passiveStats.AttachmentPushBytes.Value())
+	assert.Equal(t, int64(2), passiveStats.AttachmentPushKnown.Value())
+}
+
 // TestActiveReplicatorPushFromCheckpoint:
 //   - Starts 2 RestTesters, one active, and one passive.
 //   - Creates enough documents on rt1 which can be pushed by a replicator running in rt1 to start setting checkpoints.
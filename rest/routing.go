@@ -224,6 +224,8 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 		makeHandler(sc, adminPrivs, (*handler).handleHeapProfiling)).Methods("POST")
 	r.Handle("/_stats",
 		makeHandler(sc, adminPrivs, (*handler).handleStats)).Methods("GET")
+	r.Handle("/_stats/schema",
+		makeHandler(sc, adminPrivs, (*handler).handleStatsSchema)).Methods("GET")
 	r.Handle(kDebugURLPathPrefix,
 		makeHandler(sc, adminPrivs, (*handler).handleExpvar)).Methods("GET")
 	r.Handle("/_config",
@@ -293,8 +295,38 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 		makeHandler(sc, adminPrivs, (*handler).handleView)).Methods("GET")
 	dbr.Handle("/_dumpchannel/{channel}",
 		makeHandler(sc, adminPrivs, (*handler).handleDumpChannel)).Methods("GET")
+	dbr.Handle("/_cache",
+		makeHandler(sc, adminPrivs, (*handler).handleGetCache)).Methods("GET")
+	dbr.Handle("/_cache/skipped",
+		makeHandler(sc, adminPrivs, (*handler).handleGetSkippedSequences)).Methods("GET")
+	dbr.Handle("/_cache/skipped",
+		makeHandler(sc, adminPrivs, (*handler).handlePostSkippedSequences)).Methods("POST")
+	dbr.Handle("/_cache/options",
+		makeHandler(sc, adminPrivs, (*handler).handlePutCacheOptions)).Methods("PUT")
+	dbr.Handle("/_cache/pause",
+		makeHandler(sc, adminPrivs, (*handler).handlePostCachePause)).Methods("POST")
+	dbr.Handle("/_cache/resume",
+		makeHandler(sc, adminPrivs, (*handler).handlePostCacheResume)).Methods("POST")
+	dbr.Handle("/_cache/channel/{channel}",
+		makeHandler(sc, adminPrivs, (*handler).handleGetChannelCache)).Methods("GET")
+	dbr.Handle("/_cache/channel/{channel}",
+		makeHandler(sc, adminPrivs, (*handler).handleDeleteChannelCache)).Methods("DELETE")
+	dbr.Handle("/_cache/channel/{channel}/audit",
+		makeHandler(sc, adminPrivs, (*handler).handleAuditChannelCache)).Methods("POST")
+	dbr.Handle("/_import_suppression",
+		makeHandler(sc, adminPrivs, (*handler).handleGetImportSuppression)).Methods("GET")
+	dbr.Handle("/_import_suppression",
+		makeHandler(sc, adminPrivs, (*handler).handlePutImportSuppression)).Methods("PUT")
+	dbr.Handle("/_import_suppression",
+		makeHandler(sc, adminPrivs, (*handler).handleDeleteImportSuppression)).Methods("DELETE")
+	dbr.Handle("/_resync_advice",
+		makeHandler(sc, adminPrivs, (*handler).handleGetResyncAdvice)).Methods("GET")
+	dbr.Handle("/_resync_advice",
+		makeHandler(sc, adminPrivs, (*handler).handleDeleteResyncAdvice)).Methods("DELETE")
 	dbr.Handle("/_repair",
 		makeHandler(sc, adminPrivs, (*handler).handleRepair)).Methods("POST")
+	dbr.Handle("/_repair_sequence",
+		makeOfflineHandler(sc, adminPrivs, (*handler).handleRepairSequence)).Methods("POST")
 
 	// The routes below are part of the CouchDB REST API but should only be available to admins,
 	// so the handlers are moved to the admin port.
@@ -307,6 +339,18 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 		makeHandler(sc, adminPrivs, (*handler).handleAllDbs)).Methods("GET", "HEAD")
 	dbr.Handle("/_compact",
 		makeHandler(sc, adminPrivs, (*handler).handleCompact)).Methods("POST")
+	dbr.Handle("/_compact",
+		makeHandler(sc, adminPrivs, (*handler).handleGetCompact)).Methods("GET")
+	dbr.Handle("/_metadata/purge",
+		makeHandler(sc, adminPrivs, (*handler).handleMetadataPurge)).Methods("POST")
+	dbr.Handle("/_metadata/dcp_checkpoints",
+		makeHandler(sc, adminPrivs, (*handler).handleGetStaleDCPCheckpoints)).Methods("GET")
+	dbr.Handle("/_metadata/old_rev_expiry",
+		makeHandler(sc, adminPrivs, (*handler).handleGetOldRevExpiry)).Methods("GET")
+	dbr.Handle("/_metadata/usage",
+		makeHandler(sc, adminPrivs, (*handler).handlePostMetadataUsage)).Methods("POST")
+	dbr.Handle("/_metadata/usage",
+		makeHandler(sc, adminPrivs, (*handler).handleGetMetadataUsage)).Methods("GET")
 
 	return r
 }
@@ -324,6 +368,7 @@ func CreateMetricRouter(sc *ServerContext) *mux.Router {
 
 	r.Handle("/_metrics", makeHandler(sc, publicPrivs, (*handler).handleMetrics)).Methods("GET")
 	r.Handle(kDebugURLPathPrefix, makeHandler(sc, publicPrivs, (*handler).handleExpvar)).Methods("GET")
+	r.Handle("/_stats/schema", makeHandler(sc, publicPrivs, (*handler).handleStatsSchema)).Methods("GET")
 
 	return r
 }
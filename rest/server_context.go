@@ -47,8 +47,10 @@ type ServerContext struct {
 	statsContext      *statsContext
 	HTTPClient        *http.Client
 	replicator        *base.Replicator
-	cpuPprofFileMutex sync.Mutex // Protect cpuPprofFile from concurrent Start and Stop CPU profiling requests
-	cpuPprofFile      *os.File   // An open file descriptor holds the reference during CPU profiling
+	cpuPprofFileMutex sync.Mutex              // Protect cpuPprofFile from concurrent Start and Stop CPU profiling requests
+	cpuPprofFile      *os.File                // An open file descriptor holds the reference during CPU profiling
+	changesFeeds      *db.ChangesFeedLimiter  // Limits active changes feeds across all databases
+	cacheMemory       *db.CacheMemoryGovernor // Limits channel cache memory across all databases
 }
 
 func (sc *ServerContext) SetCpuPprofFile(file *os.File) {
@@ -86,6 +88,19 @@ func NewServerContext(config *ServerConfig) *ServerContext {
 		config.SlowQueryWarningThreshold = base.IntPtr(kDefaultSlowQueryWarningThreshold)
 	}
 
+	var changesFeedLimits db.ChangesFeedLimits
+	if config.MaxChangesFeeds != nil {
+		changesFeedLimits.Max = *config.MaxChangesFeeds
+	}
+	sc.changesFeeds = db.NewChangesFeedLimiter(changesFeedLimits, nil, nil)
+
+	var cacheMaxMemoryBytes int64
+	if config.ChannelCacheMaxMemoryMB != nil {
+		cacheMaxMemoryBytes = int64(*config.ChannelCacheMaxMemoryMB) * 1024 * 1024
+	}
+	resourceUtilization := base.SyncGatewayStats.GlobalStats.ResourceUtilizationStats()
+	sc.cacheMemory = db.NewCacheMemoryGovernor(cacheMaxMemoryBytes, resourceUtilization.ChannelCacheMemoryBytes, resourceUtilization.ChannelCacheMemoryPressure)
+
 	sc.startStatsLogger()
 
 	return sc
@@ -228,6 +243,24 @@ func (sc *ServerContext) AllDatabases() map[string]*db.DatabaseContext {
 	return databases
 }
 
+// cacheHealth returns the worst change cache health across online databases, with the longest retry hint.
+func (sc *ServerContext) cacheHealth() db.CacheHealth {
+	health := db.CacheHealth{Status: db.CacheHealthOK}
+	for _, database := range sc.AllDatabases() {
+		if atomic.LoadUint32(&database.State) != db.DBOnline {
+			continue
+		}
+		dbHealth := database.CacheHealth()
+		if dbHealth.Status != db.CacheHealthOK {
+			health.Status = dbHealth.Status
+		}
+		if dbHealth.RetryAfterMs > health.RetryAfterMs {
+			health.RetryAfterMs = dbHealth.RetryAfterMs
+		}
+	}
+	return health
+}
+
 type PostUpgradeResult map[string]PostUpgradeDatabaseResult
 
 type PostUpgradeDatabaseResult struct {
@@ -569,7 +602,10 @@ func (sc *ServerContext) _getOrAddDatabaseFromConfig(config *DbConfig, useExisti
 	// Save the config
 	sc.config.Databases[dbName] = config
 
-	if config.StartOffline {
+	if corruptionErr := dbcontext.SequenceCorruption(); corruptionErr != nil {
+		atomic.StoreUint32(&dbcontext.State, db.DBOffline)
+		_ = dbcontext.EventMgr.RaiseDBStateChangeEvent(dbName, "offline", corruptionErr.Error(), sc.config.AdminInterface)
+	} else if config.StartOffline {
 		atomic.StoreUint32(&dbcontext.State, db.DBOffline)
 		_ = dbcontext.EventMgr.RaiseDBStateChangeEvent(dbName, "offline", "DB loaded from config", sc.config.AdminInterface)
 	} else {
@@ -588,6 +624,25 @@ func dbcOptionsFromConfig(sc *ServerContext, config *DbConfig, dbName string) (d
 		importOptions.ImportFilter = db.NewImportFilterFunction(*config.ImportFilter)
 	}
 	importOptions.BackupOldRev = config.ImportBackupOldRev
+	if config.ImportLargeDocSize != nil {
+		importOptions.LargeDocSize = *config.ImportLargeDocSize
+	}
+	if config.ImportMaxDocSize != nil {
+		importOptions.MaxDocSize = *config.ImportMaxDocSize
+	}
+	importOptions.DeclaredFields = config.ImportDeclaredFields
+	if config.ImportCheckpointGroup != nil {
+		importOptions.CheckpointGroup = *config.ImportCheckpointGroup
+	}
+	importOptions.CheckpointGCMaxAge = base.DefaultDCPCheckpointGCMaxAge
+	if config.ImportCheckpointGCMaxAgeSecs != nil {
+		importOptions.CheckpointGCMaxAge = time.Duration(*config.ImportCheckpointGCMaxAgeSecs) * time.Second
+	}
+	importOptions.CheckpointGCOnStartup = config.ImportCheckpointGCOnStartup
+	if config.ImportMaxInFlightBytes != nil {
+		importOptions.MaxInFlightBytes = *config.ImportMaxInFlightBytes
+	}
+	importOptions.DeferOverBudget = config.ImportDeferOverBudget
 
 	if config.ImportPartitions == nil {
 		importOptions.ImportPartitions = base.DefaultImportPartitions
@@ -600,23 +655,52 @@ func dbcOptionsFromConfig(sc *ServerContext, config *DbConfig, dbName string) (d
 	for _, warnLog := range warnings {
 		base.Warnf(warnLog)
 	}
-	// Set cache properties, if present
-	cacheOptions := db.DefaultCacheOptions()
+	// Set cache properties, if present, over those of the cache profile
+	cacheOptions, err := db.CacheProfileOptions(config.CacheProfile)
+	if err != nil {
+		return db.DatabaseContextOptions{}, err
+	}
 	revCacheOptions := db.DefaultRevisionCacheOptions()
 	if config.CacheConfig != nil {
 		if config.CacheConfig.ChannelCacheConfig != nil {
 			if config.CacheConfig.ChannelCacheConfig.MaxNumPending != nil {
 				cacheOptions.CachePendingSeqMaxNum = *config.CacheConfig.ChannelCacheConfig.MaxNumPending
 			}
+			if config.CacheConfig.ChannelCacheConfig.MaxPendingMemoryMB != nil {
+				cacheOptions.CachePendingSeqMaxBytes = int64(*config.CacheConfig.ChannelCacheConfig.MaxPendingMemoryMB) * 1024 * 1024
+			}
 			if config.CacheConfig.ChannelCacheConfig.MaxWaitPending != nil {
 				cacheOptions.CachePendingSeqMaxWait = time.Duration(*config.CacheConfig.ChannelCacheConfig.MaxWaitPending) * time.Millisecond
 			}
+			if config.CacheConfig.ChannelCacheConfig.MinWaitPending != nil {
+				cacheOptions.CachePendingSeqMinWait = time.Duration(*config.CacheConfig.ChannelCacheConfig.MinWaitPending) * time.Millisecond
+			}
+			if config.CacheConfig.ChannelCacheConfig.AdaptiveWaitPending != nil {
+				cacheOptions.AdaptivePendingSeqMaxWait = *config.CacheConfig.ChannelCacheConfig.AdaptiveWaitPending
+			}
 			if config.CacheConfig.ChannelCacheConfig.MaxWaitSkipped != nil {
 				cacheOptions.CacheSkippedSeqMaxWait = time.Duration(*config.CacheConfig.ChannelCacheConfig.MaxWaitSkipped) * time.Millisecond
 			}
-			// set EnableStarChannelLog directly here (instead of via NewDatabaseContext), so that it's set when we create the channels view in ConnectToBucket
+			if config.CacheConfig.ChannelCacheConfig.MaxNumSkipped != nil {
+				cacheOptions.CacheSkippedSeqMaxNum = *config.CacheConfig.ChannelCacheConfig.MaxNumSkipped
+			}
+			if config.CacheConfig.ChannelCacheConfig.MaxWaitSequence != nil {
+				cacheOptions.SequenceWaitTimeout = time.Duration(*config.CacheConfig.ChannelCacheConfig.MaxWaitSequence) * time.Millisecond
+			}
+			if config.CacheConfig.ChannelCacheConfig.FeedLagWarnThreshold != nil {
+				cacheOptions.FeedLagWarnThreshold = time.Duration(*config.CacheConfig.ChannelCacheConfig.FeedLagWarnThreshold) * time.Millisecond
+			}
+			if config.CacheConfig.ChannelCacheConfig.FeedStageSampleRate != nil {
+				cacheOptions.FeedStageSampleRate = *config.CacheConfig.ChannelCacheConfig.FeedStageSampleRate
+			}
+			if config.CacheConfig.ChannelCacheConfig.NotifyDebounce != nil {
+				cacheOptions.NotificationDebounceInterval = time.Duration(*config.CacheConfig.ChannelCacheConfig.NotifyDebounce) * time.Millisecond
+			}
+			if config.CacheConfig.ChannelCacheConfig.BypassBuffering != nil {
+				cacheOptions.BypassSequenceBuffering = *config.CacheConfig.ChannelCacheConfig.BypassBuffering
+			}
 			if config.CacheConfig.ChannelCacheConfig.EnableStarChannel != nil {
-				db.EnableStarChannelLog = *config.CacheConfig.ChannelCacheConfig.EnableStarChannel
+				cacheOptions.EnableStarChannel = *config.CacheConfig.ChannelCacheConfig.EnableStarChannel
 			}
 			if config.CacheConfig.ChannelCacheConfig.MaxLength != nil {
 				cacheOptions.ChannelCacheMaxLength = *config.CacheConfig.ChannelCacheConfig.MaxLength
@@ -624,12 +708,36 @@ func dbcOptionsFromConfig(sc *ServerContext, config *DbConfig, dbName string) (d
 			if config.CacheConfig.ChannelCacheConfig.MinLength != nil {
 				cacheOptions.ChannelCacheMinLength = *config.CacheConfig.ChannelCacheConfig.MinLength
 			}
+			if config.CacheConfig.ChannelCacheConfig.MaxMemoryMB != nil {
+				cacheOptions.MaxMemoryBytes = int64(*config.CacheConfig.ChannelCacheConfig.MaxMemoryMB) * 1024 * 1024
+			}
+			if config.CacheConfig.ChannelCacheConfig.RemovalRetention != nil {
+				cacheOptions.RemovalRetention = *config.CacheConfig.ChannelCacheConfig.RemovalRetention
+			}
+			if config.CacheConfig.ChannelCacheConfig.NameNormalization != nil {
+				cacheOptions.ChannelNameNormalization = *config.CacheConfig.ChannelCacheConfig.NameNormalization
+			}
+			if config.CacheConfig.ChannelCacheConfig.UncachedChannels != nil {
+				cacheOptions.UncachedChannelPatterns = config.CacheConfig.ChannelCacheConfig.UncachedChannels
+			}
 			if config.CacheConfig.ChannelCacheConfig.ExpirySeconds != nil {
 				cacheOptions.ChannelCacheAge = time.Duration(*config.CacheConfig.ChannelCacheConfig.ExpirySeconds) * time.Second
 			}
 			if config.CacheConfig.ChannelCacheConfig.MaxNumber != nil {
 				cacheOptions.MaxNumChannels = *config.CacheConfig.ChannelCacheConfig.MaxNumber
 			}
+			if config.CacheConfig.ChannelCacheConfig.Handoff != nil {
+				cacheOptions.Handoff.Enabled = *config.CacheConfig.ChannelCacheConfig.Handoff
+			}
+			if config.CacheConfig.ChannelCacheConfig.HandoffMaxChannels != nil {
+				cacheOptions.Handoff.MaxChannels = *config.CacheConfig.ChannelCacheConfig.HandoffMaxChannels
+			}
+			if config.CacheConfig.ChannelCacheConfig.HandoffMaxSizeKB != nil {
+				cacheOptions.Handoff.MaxBytes = *config.CacheConfig.ChannelCacheConfig.HandoffMaxSizeKB * 1024
+			}
+			if config.CacheConfig.ChannelCacheConfig.HandoffMaxAgeSeconds != nil {
+				cacheOptions.Handoff.MaxAge = time.Duration(*config.CacheConfig.ChannelCacheConfig.HandoffMaxAgeSeconds) * time.Second
+			}
 			if config.CacheConfig.ChannelCacheConfig.HighWatermarkPercent != nil && *config.CacheConfig.ChannelCacheConfig.HighWatermarkPercent > 0 {
 				cacheOptions.CompactHighWatermarkPercent = *config.CacheConfig.ChannelCacheConfig.HighWatermarkPercent
 			}
@@ -711,8 +819,67 @@ func dbcOptionsFromConfig(sc *ServerContext, config *DbConfig, dbName string) (d
 	if queryPaginationLimit < 2 {
 		return db.DatabaseContextOptions{}, fmt.Errorf("query_pagination_limit: %d must be greater than 1", queryPaginationLimit)
 	}
+
+	maxChangesLimit := db.DefaultMaxChangesLimit
+	if config.MaxChangesLimit != nil {
+		maxChangesLimit = *config.MaxChangesLimit
+	}
+	if maxChangesLimit < 0 {
+		return db.DatabaseContextOptions{}, fmt.Errorf("max_changes_limit: %d must not be negative", maxChangesLimit)
+	}
+
+	channelVerificationRate := 0.0
+	if config.ChannelVerificationSampleRate != nil {
+		channelVerificationRate = *config.ChannelVerificationSampleRate
+	}
+	if channelVerificationRate < 0 || channelVerificationRate > 1 {
+		return db.DatabaseContextOptions{}, fmt.Errorf("channel_verification_sample_rate: %g must be between 0 and 1", channelVerificationRate)
+	}
 	cacheOptions.ChannelQueryLimit = queryPaginationLimit
 
+	changesFeedLimits, err := changesFeedLimitsFromConfig(config)
+	if err != nil {
+		return db.DatabaseContextOptions{}, err
+	}
+
+	var resyncAdvisorOptions *db.ResyncAdvisorOptions
+	if advisorConfig := config.ResyncAdvisor; advisorConfig != nil {
+		resyncAdvisorOptions = &db.ResyncAdvisorOptions{
+			Window:                      db.DefaultResyncAdvisorWindow,
+			AbandonedSeqsThreshold:      db.DefaultResyncAdvisorAbandonedSeqsThreshold,
+			ChannelDivergenceThreshold:  db.DefaultResyncAdvisorChannelDivergenceThreshold,
+			MigrateCasMismatchThreshold: db.DefaultResyncAdvisorMigrateCasMismatchThreshold,
+		}
+		if advisorConfig.WindowSecs != nil {
+			if *advisorConfig.WindowSecs == 0 {
+				return db.DatabaseContextOptions{}, fmt.Errorf("resync_advisor.window_secs must be greater than 0")
+			}
+			resyncAdvisorOptions.Window = time.Duration(*advisorConfig.WindowSecs) * time.Second
+		}
+		for _, threshold := range []struct {
+			name   string
+			config *int64
+			option *int64
+		}{
+			{"abandoned_seqs_threshold", advisorConfig.AbandonedSeqsThreshold, &resyncAdvisorOptions.AbandonedSeqsThreshold},
+			{"channel_divergence_threshold", advisorConfig.ChannelDivergenceThreshold, &resyncAdvisorOptions.ChannelDivergenceThreshold},
+			{"migrate_cas_mismatch_threshold", advisorConfig.MigrateCasMismatchThreshold, &resyncAdvisorOptions.MigrateCasMismatchThreshold},
+		} {
+			if threshold.config == nil {
+				continue
+			}
+			if *threshold.config < 0 {
+				return db.DatabaseContextOptions{}, fmt.Errorf("resync_advisor.%s: %d must not be negative", threshold.name, *threshold.config)
+			}
+			*threshold.option = *threshold.config
+		}
+	}
+
+	channelWebhooks, err := channelWebhooksFromConfig(config.ChannelWebhooks)
+	if err != nil {
+		return db.DatabaseContextOptions{}, err
+	}
+
 	secureCookieOverride := sc.config.SSLCert != nil
 	if config.SecureCookieOverride != nil {
 		secureCookieOverride = *config.SecureCookieOverride
@@ -742,6 +909,15 @@ func dbcOptionsFromConfig(sc *ServerContext, config *DbConfig, dbName string) (d
 		clientPartitionWindow = time.Duration(*config.ClientPartitionWindowSecs) * time.Second
 	}
 
+	var tombstoneRetention time.Duration
+	if config.TombstoneRetentionSecs != nil {
+		tombstoneRetention = time.Duration(*config.TombstoneRetentionSecs) * time.Second
+	}
+	compactOpsPerSec := db.DefaultCompactOpsPerSec
+	if config.CompactOpsPerSec != nil {
+		compactOpsPerSec = *config.CompactOpsPerSec
+	}
+
 	contextOptions := db.DatabaseContextOptions{
 		CacheOptions:              &cacheOptions,
 		RevisionCacheOptions:      revCacheOptions,
@@ -761,6 +937,8 @@ func dbcOptionsFromConfig(sc *ServerContext, config *DbConfig, dbName string) (d
 		DeltaSyncOptions:          deltaSyncOptions,
 		CompactInterval:           compactIntervalSecs,
 		QueryPaginationLimit:      queryPaginationLimit,
+		MaxChangesLimit:           maxChangesLimit,
+		ChannelVerificationRate:   channelVerificationRate,
 		UserXattrKey:              config.UserXattrKey,
 		SGReplicateOptions: db.SGReplicateOptions{
 			Enabled:               sgReplicateEnabled,
@@ -768,11 +946,69 @@ func dbcOptionsFromConfig(sc *ServerContext, config *DbConfig, dbName string) (d
 		},
 		SlowQueryWarningThreshold: time.Duration(*sc.config.SlowQueryWarningThreshold) * time.Millisecond,
 		ClientPartitionWindow:     clientPartitionWindow,
+		SequenceEpochEnabled:      config.SequenceEpochEnabled != nil && *config.SequenceEpochEnabled,
+		FilterExpiredTombstones:   config.FilterExpiredTombstones != nil && *config.FilterExpiredTombstones,
+		ResyncAdvisorOptions:      resyncAdvisorOptions,
+		ChangesFeedLimits:         changesFeedLimits,
+		ChannelWebhooks:           channelWebhooks,
+		ChannelWebhookClient:      sc.HTTPClient,
+		TombstoneRetention:        tombstoneRetention,
+		CompactOpsPerSec:          compactOpsPerSec,
 	}
 
 	return contextOptions, nil
 }
 
+// changesFeedLimitsFromConfig returns the database's limits on active changes feeds.
+func changesFeedLimitsFromConfig(config *DbConfig) (limits db.ChangesFeedLimits, err error) {
+	if config.MaxChangesFeeds != nil {
+		limits.Max = *config.MaxChangesFeeds
+	}
+	if config.SoftMaxChangesFeeds != nil {
+		limits.SoftMax = *config.SoftMaxChangesFeeds
+	}
+	if limits.Max < 0 {
+		return limits, fmt.Errorf("max_changes_feeds: %d must not be negative", limits.Max)
+	}
+	if limits.SoftMax < 0 {
+		return limits, fmt.Errorf("soft_max_changes_feeds: %d must not be negative", limits.SoftMax)
+	}
+	if limits.Max > 0 && limits.SoftMax > limits.Max {
+		return limits, fmt.Errorf("soft_max_changes_feeds: %d must not be greater than max_changes_feeds: %d", limits.SoftMax, limits.Max)
+	}
+	return limits, nil
+}
+
+// channelWebhooksFromConfig returns the channel webhook options for the config, validating each webhook's URL and
+// channels.
+func channelWebhooksFromConfig(config []ChannelWebhookConfig) (webhooks []db.ChannelWebhookOptions, err error) {
+	for i, webhookConfig := range config {
+		if parsed, err := url.Parse(webhookConfig.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("channel_webhooks[%d].url must be an http or https URL", i)
+		}
+		if len(webhookConfig.Channels) == 0 {
+			return nil, fmt.Errorf("channel_webhooks[%d].channels must not be empty", i)
+		}
+		for _, channelName := range webhookConfig.Channels {
+			if channelName == "" {
+				return nil, fmt.Errorf("channel_webhooks[%d].channels must not contain empty channel names", i)
+			}
+		}
+		webhook := db.ChannelWebhookOptions{
+			URL:      webhookConfig.URL,
+			Channels: webhookConfig.Channels,
+		}
+		if webhookConfig.TimeoutMs != nil {
+			if *webhookConfig.TimeoutMs == 0 {
+				return nil, fmt.Errorf("channel_webhooks[%d].timeout_ms must be greater than 0", i)
+			}
+			webhook.Timeout = time.Duration(*webhookConfig.TimeoutMs) * time.Millisecond
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
 func (sc *ServerContext) TakeDbOnline(database *db.DatabaseContext) {
 
 	//Take a write lock on the Database context, so that we can cycle the underlying Database
@@ -788,6 +1024,12 @@ func (sc *ServerContext) TakeDbOnline(database *db.DatabaseContext) {
 			return
 		}
 
+		// A reloaded DB with a corrupt sequence counter remains offline until the counter is repaired
+		if corruptionErr := reloadedDb.SequenceCorruption(); corruptionErr != nil {
+			base.Errorf("Unable to take Database : %v online: %v", base.MD(database.Name), corruptionErr)
+			return
+		}
+
 		// Reloaded DB should already be online in most cases, but force state to online to handle cases
 		// where config specifies offline startup
 		atomic.StoreUint32(&reloadedDb.State, db.DBOnline)
@@ -1097,6 +1339,7 @@ func (sc *ServerContext) logStats() error {
 	}
 
 	sc.updateCalculatedStats()
+	sc.governCacheMemory()
 	// Create wrapper expvar map in order to add a timestamp field for logging purposes
 	currentTime := time.Now()
 	wrapper := statsWrapper{
@@ -1147,6 +1390,18 @@ func (sc *ServerContext) updateCalculatedStats() {
 
 }
 
+// governCacheMemory measures the channel cache memory used by every database, and reduces it when over the server's or
+// a database's limit.  Databases are collected under the lock, but not held by it while they're reduced.
+func (sc *ServerContext) governCacheMemory() {
+	sc.lock.RLock()
+	databases := make([]*db.DatabaseContext, 0, len(sc.databases_))
+	for _, dbContext := range sc.databases_ {
+		databases = append(databases, dbContext)
+	}
+	sc.lock.RUnlock()
+	sc.cacheMemory.Govern(databases)
+}
+
 // For test use
 func (sc *ServerContext) Database(name string) *db.DatabaseContext {
 	db, err := sc.GetDatabase(name)
@@ -14,12 +14,15 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Tests the ConfigServer feature.
@@ -131,10 +134,24 @@ func MakeResponse(status int, headers map[string]string, body string) *http.Resp
 
 // Implementation of http.RoundTripper that does the actual work
 type mockTripper struct {
-	getURLs map[string]*http.Response
+	getURLs      map[string]*http.Response
+	lock         sync.Mutex          // Coordinates access to the POST fields below
+	postStatuses map[string][]int    // Statuses of successive POSTs to each URL - the last is repeated
+	postBodies   map[string][][]byte // Bodies POSTed to each URL
+	hangURLs     map[string]bool     // URLs that don't respond until the request is cancelled
 }
 
 func (m *mockTripper) RoundTrip(rq *http.Request) (*http.Response, error) {
+	m.lock.Lock()
+	hang := m.hangURLs[rq.URL.String()]
+	m.lock.Unlock()
+	if hang {
+		<-rq.Context().Done()
+		return nil, rq.Context().Err()
+	}
+	if rq.Method == http.MethodPost {
+		return m.roundTripPOST(rq)
+	}
 	response := m.getURLs[rq.URL.String()]
 	if response == nil {
 		response = MakeResponse(http.StatusNotFound, nil, "Not Found")
@@ -142,6 +159,32 @@ func (m *mockTripper) RoundTrip(rq *http.Request) (*http.Response, error) {
 	return response, nil
 }
 
+// roundTripPOST records the POSTed body, and responds with the URL's next status.
+func (m *mockTripper) roundTripPOST(rq *http.Request) (*http.Response, error) {
+	var body []byte
+	if rq.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(rq.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	url := rq.URL.String()
+	m.postBodies[url] = append(m.postBodies[url], body)
+	statuses := m.postStatuses[url]
+	if len(statuses) == 0 {
+		return MakeResponse(http.StatusNotFound, nil, "Not Found"), nil
+	}
+	status := statuses[0]
+	if len(statuses) > 1 {
+		m.postStatuses[url] = statuses[1:]
+	}
+	return MakeResponse(status, nil, ""), nil
+}
+
 // Fake http.Client that returns canned responses.
 type MockClient struct {
 	*http.Client
@@ -150,7 +193,10 @@ type MockClient struct {
 // Creates a new MockClient.
 func NewMockClient() *MockClient {
 	tripper := mockTripper{
-		getURLs: map[string]*http.Response{},
+		getURLs:      map[string]*http.Response{},
+		postStatuses: map[string][]int{},
+		postBodies:   map[string][][]byte{},
+		hangURLs:     map[string]bool{},
 	}
 	return &MockClient{
 		Client: &http.Client{Transport: &tripper},
@@ -163,6 +209,31 @@ func (client *MockClient) RespondToGET(url string, response *http.Response) {
 	tripper.getURLs[url] = response
 }
 
+// Adds canned statuses.  The Client will respond to successive POSTs to the given URL with each status in turn,
+// repeating the last.
+func (client *MockClient) RespondToPOST(url string, statuses ...int) {
+	tripper := client.Transport.(*mockTripper)
+	tripper.lock.Lock()
+	tripper.postStatuses[url] = statuses
+	tripper.lock.Unlock()
+}
+
+// The Client won't respond to requests to the given URL until they're cancelled.
+func (client *MockClient) HangOnRequest(url string) {
+	tripper := client.Transport.(*mockTripper)
+	tripper.lock.Lock()
+	tripper.hangURLs[url] = true
+	tripper.lock.Unlock()
+}
+
+// Returns the bodies POSTed to the given URL, in the order they were received.
+func (client *MockClient) PostedBodies(url string) [][]byte {
+	tripper := client.Transport.(*mockTripper)
+	tripper.lock.Lock()
+	defer tripper.lock.Unlock()
+	return append([][]byte(nil), tripper.postBodies[url]...)
+}
+
 // convenience function to get a BucketConfig for a given TestBucket.
 func bucketConfigFromTestBucket(tb *base.TestBucket) BucketConfig {
 	tbUser, tbPassword, _ := tb.BucketSpec.Auth.GetCredentials()
@@ -289,3 +360,87 @@ func TestStatsLoggerStopped(t *testing.T) {
 	// sleep a bit to allow the "Stopping stats logging goroutine" debug logging to be printed
 	time.Sleep(time.Millisecond * 10)
 }
+
+// Validates that the change cache's channel notifications are posted to matching channel webhooks, that failed
+// deliveries are retried before an endpoint's circuit opens, and that a dead endpoint doesn't stall notifications to
+// others.
+func TestChannelWebhooks(t *testing.T) {
+	defer base.SetUpTestLogging(base.LevelDebug, base.KeyEvents)()
+
+	defer func(backoff time.Duration) { db.ChannelWebhookRetryBackoff = backoff }(db.ChannelWebhookRetryBackoff)
+	db.ChannelWebhookRetryBackoff = time.Millisecond
+
+	const (
+		activeURL = "http://active.example.com/hook"
+		downURL   = "http://down.example.com/hook"
+		deadURL   = "http://dead.example.com/hook"
+	)
+	mockClient := NewMockClient()
+	mockClient.RespondToPOST(activeURL, http.StatusInternalServerError, http.StatusOK)
+	mockClient.HangOnRequest(deadURL)
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		httpClient: mockClient.Client,
+		DatabaseConfig: &DbConfig{ChannelWebhooks: []ChannelWebhookConfig{
+			// The dead endpoint is configured first, and is notified of every channel
+			{URL: deadURL, Channels: []string{"*"}, TimeoutMs: base.Uint32Ptr(60000)},
+			{URL: activeURL, Channels: []string{"ABC", "user-*"}},
+			{URL: downURL, Channels: []string{"ABC"}},
+		}},
+	})
+	defer rt.Close()
+	database := rt.GetDatabase()
+	activeStats := database.DbStats.ChannelWebhook(activeURL)
+	downStats := database.DbStats.ChannelWebhook(downURL)
+	deadStats := database.DbStats.ChannelWebhook(deadURL)
+
+	// writeDoc writes a doc to the channels, and returns its sequence once it's been cached
+	writeDoc := func(docID string, channels string) uint64 {
+		assertStatus(t, rt.SendAdminRequest(http.MethodPut, "/db/"+docID, `{"channels":`+channels+`}`), http.StatusCreated)
+		require.NoError(t, rt.WaitForPendingChanges())
+		seq, err := database.LastSequence()
+		require.NoError(t, err)
+		return seq
+	}
+	waitForPosts := func(url string, count int) [][]byte {
+		require.NoError(t, rt.WaitForCondition(func() bool {
+			return len(mockClient.PostedBodies(url)) >= count
+		}))
+		return mockClient.PostedBodies(url)
+	}
+
+	// The first delivery is retried after the 500 response
+	seq := writeDoc("doc1", `["ABC"]`)
+	expected := fmt.Sprintf(`[{"channel":"ABC","maxSeq":%d,"entryCount":1}]`, seq)
+	bodies := waitForPosts(activeURL, 2)
+	assert.JSONEq(t, expected, string(bodies[0]))
+	assert.JSONEq(t, expected, string(bodies[1]))
+	require.NoError(t, rt.WaitForCondition(func() bool { return activeStats.Delivered.Value() == 1 }))
+	assert.Equal(t, int64(1), activeStats.Retries.Value())
+	assert.Equal(t, int64(0), activeStats.Failed.Value())
+
+	// Only the channels an endpoint matches are included in its payload
+	seq = writeDoc("doc2", `["user-1", "NBC"]`)
+	bodies = waitForPosts(activeURL, 3)
+	assert.JSONEq(t, fmt.Sprintf(`[{"channel":"user-1","maxSeq":%d,"entryCount":1}]`, seq), string(bodies[2]))
+
+	// The down endpoint's circuit opens once it's failed channelWebhookCircuitFailures (5) deliveries, after which its
+	// deliveries are skipped
+	for i := 3; i <= 6; i++ {
+		writeDoc(fmt.Sprintf("doc%d", i), `["ABC"]`)
+		waitForPosts(activeURL, i+1)
+	}
+	require.NoError(t, rt.WaitForCondition(func() bool { return downStats.Failed.Value()+downStats.Skipped.Value() == 5 }))
+	assert.Equal(t, int64(5), downStats.Failed.Value())
+	assert.Equal(t, int64(10), downStats.Retries.Value())
+	assert.Equal(t, int64(1), downStats.CircuitOpen.Value())
+	writeDoc("doc7", `["ABC"]`)
+	waitForPosts(activeURL, 8)
+	require.NoError(t, rt.WaitForCondition(func() bool { return downStats.Skipped.Value() == 1 }))
+	assert.Len(t, mockClient.PostedBodies(downURL), 15)
+
+	// The active endpoint received every notification while the dead endpoint's first delivery is still outstanding
+	require.NoError(t, rt.WaitForCondition(func() bool { return activeStats.Delivered.Value() == 7 }))
+	assert.Equal(t, int64(0), deadStats.Delivered.Value())
+	assert.Equal(t, int64(0), deadStats.Failed.Value())
+}
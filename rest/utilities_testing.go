@@ -53,6 +53,8 @@ type RestTesterConfig struct {
 	sgReplicateEnabled    bool                 // sgReplicateManager disabled by default for RestTester
 	sgr1Replications      []*ReplicateV1Config // sgr1Replications are a list of replications to enable on the server context.
 	hideProductInfo       bool
+	healthHints           bool         // Include cache health hints in root and database root responses
+	httpClient            *http.Client // If set, replaces the server context's HTTP client before the database is added
 }
 
 type RestTester struct {
@@ -131,8 +133,13 @@ func (rt *RestTester) Bucket() base.Bucket {
 		AdminInterface:     adminInterface,
 		Replications:       rt.RestTesterConfig.sgr1Replications,
 		HideProductVersion: rt.RestTesterConfig.hideProductInfo,
+		HealthHints:        rt.RestTesterConfig.healthHints,
 	})
 
+	if rt.RestTesterConfig.httpClient != nil {
+		rt.RestTesterServerContext.HTTPClient = rt.RestTesterConfig.httpClient
+	}
+
 	useXattrs := base.TestUseXattrs()
 
 	if rt.DatabaseConfig == nil {